@@ -2,71 +2,164 @@
 package main
 
 import (
-	"html/template"
+	"context"
+	"errors"
+	"flag"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 
+	"wledger/internal/activity"
 	"wledger/internal/background"
+	"wledger/internal/config"
+	"wledger/internal/core"
+	"wledger/internal/features/api"
+	"wledger/internal/features/auth"
 	"wledger/internal/features/dashboard"
 	"wledger/internal/features/hardware"
 	"wledger/internal/features/inspiration"
 	"wledger/internal/features/inventory"
+	"wledger/internal/features/jobs"
 	"wledger/internal/features/parts"
+	"wledger/internal/features/rules"
+	"wledger/internal/features/schedules"
 	"wledger/internal/features/settings"
 	"wledger/internal/features/system"
+	"wledger/internal/features/webhooks"
+	"wledger/internal/features/zones"
+	"wledger/internal/models"
+	"wledger/internal/scheduler"
 	"wledger/internal/store"
 	"wledger/internal/wled"
+	"wledger/ui"
+)
+
+// store.TxFor hands the features' WithTx a *store.Store as their TxStore
+var (
+	_ inventory.TxStore = (*store.Store)(nil)
+	_ parts.TxStore     = (*store.Store)(nil)
+	_ api.TxStore       = (*store.Store)(nil)
 )
 
 func main() {
+	// Load config (file, then environment, then flags)
+	cfg, err := config.Load(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+
 	// Ensure required directories exist
-	if err := os.MkdirAll("data/uploads", 0755); err != nil {
+	if err := os.MkdirAll(cfg.UploadDir(), 0755); err != nil {
 		log.Fatal("Failed to create directories:", err)
 	}
 
-	// Init store (database)
-	db, err := store.NewStore("./data/inventory.db")
+	// Init store (database), a SQLite file unless Postgres is configured
+	var db *store.Store
+	if cfg.DatabaseURL != "" {
+		db, err = store.NewPostgresStore(cfg.DatabaseURL)
+	} else {
+		db, err = store.NewStore(cfg.DatabasePath())
+	}
 	if err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
 
-	// Init templates
-	templates, err := template.ParseGlob("ui/templates/*.html")
+	// Init templates and static files, embedded in the binary
+	// unless they're reloaded from disk in dev mode
+	var uiFS fs.FS = ui.FS
+	if cfg.Dev {
+		log.Println("Dev mode: serving the UI from " + cfg.UIDir)
+		uiFS = os.DirFS(cfg.UIDir)
+	}
+	templates, err := core.LoadTemplates(uiFS, "templates/*.html", cfg.Dev)
 	if err != nil {
 		log.Fatal("Failed to parse templates:", err)
 	}
+	staticFS, err := fs.Sub(uiFS, "static")
+	if err != nil {
+		log.Fatal("Failed to open static files:", err)
+	}
+	static, err := core.StaticHandler(staticFS, cfg.Dev)
+	if err != nil {
+		log.Fatal("Failed to load static files:", err)
+	}
+
+	// Init WLED client. The breaker skips offline controllers
+	// and replays their commands once they're back.
+	wledClient := wled.NewBreaker(wled.NewWLEDClient().WithCredentials(db))
 
-	// Init WLED client
-	wledClient := wled.NewWLEDClient()
+	// Tracks LED use so scheduled lighting doesn't interrupt anyone
+	tracker := activity.New()
+
+	// Restores the controllers' own lighting once located bins are turned off
+	states := wled.NewStateKeeper(wledClient)
 
 	// Initialize feature modules
-	systemHandler := system.New(db, "./data/uploads")
-	hwHandler := hardware.New(db, wledClient, templates)
-	settingsHandler := settings.New(db, templates)
-	invHandler := inventory.New(db, templates)
-	partsHandler := parts.New(db, templates, "./data/uploads")
-	dashHandler := dashboard.New(db, wledClient, templates)
+	authHandler := auth.New(db, templates, cfg.SessionLifetime)
+	systemHandler := system.New(db, cfg.UploadDir())
+	forget := wled.Forgetters{wledClient, states}
+	hwHandler := hardware.New(db, wledClient, forget, templates)
+	settingsHandler := settings.New(db, templates, cfg)
+	invHandler := inventory.New(store.TxFor[inventory.TxStore]{Store: db}, templates)
+	partsHandler := parts.New(store.TxFor[parts.TxStore]{Store: db}, templates, cfg.UploadDir())
+	dashHandler := dashboard.New(db, wledClient, states, tracker, templates)
 	inspHandler := inspiration.New(db, templates)
-	bgService := background.New(db, wledClient)
+	rulesHandler := rules.New(db, templates)
+	schedulesHandler := schedules.New(db, templates)
+	zonesHandler := zones.New(db, wledClient, templates)
+	apiHandler := api.New(store.TxFor[api.TxStore]{Store: db}, forget, cfg.UploadDir())
+	webhooksHandler := webhooks.New(db, templates)
+	bgService := background.New(db, wledClient, dashHandler, tracker)
+	bgService.HealthInterval = cfg.HealthInterval
+	bgService.HealthRetention = cfg.HealthRetention
+	bgService.CleanupInterval = cfg.CleanupInterval
+	bgService.StockRuleInterval = cfg.StockRuleInterval
+	bgService.WebhookInterval = cfg.WebhookInterval
+	bgService.WebhookRetention = cfg.WebhookRetention
+	dashHandler.QuietHours = bgService
+
+	// Stop on Ctrl+C or docker stop
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Start background services (health checks, tag cleanup)
-	go bgService.Start()
+	// Start background jobs (health checks, tag cleanup, lighting schedules, webhooks)
+	sched := scheduler.New(db)
+	if err := bgService.RegisterJobs(ctx, sched); err != nil {
+		log.Fatal("Failed to register background jobs:", err)
+	}
+	jobsHandler := jobs.New(db, sched, templates)
+	bgDone := make(chan struct{})
+	go func() {
+		sched.Start(ctx)
+		close(bgDone)
+	}()
 
 	// Setup Router
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
+	r.Use(core.RequestTimeout(cfg.RequestTimeout))
+	r.Use(authHandler.Authenticate)
+	r.Use(core.VerifyCSRF(templates))
 
 	// Register Routes
-	// Static Files
-	r.Handle("/static/*", http.StripPrefix("/static/", http.FileServer(http.Dir("./ui/static"))))
-	r.Handle("/uploads/*", http.StripPrefix("/uploads/", http.FileServer(http.Dir("./data/uploads"))))
+	// Static Files. Uploads are part images and documents, for signed in users only.
+	r.Handle("/static/*", http.StripPrefix("/static/", static))
+	r.With(core.Require(models.RoleViewer)).Handle("/uploads/*", http.StripPrefix("/uploads/", http.FileServer(http.Dir(cfg.UploadDir()))))
 
-	// Register Feature Routes
+	// Register Feature Routes. Each module requires the roles its
+	// routes need, see core.Require.
+	authHandler.RegisterRoutes(r)
 	systemHandler.RegisterRoutes(r)
 	hwHandler.RegisterRoutes(r)
 	settingsHandler.RegisterRoutes(r)
@@ -74,10 +167,54 @@ func main() {
 	partsHandler.RegisterRoutes(r)
 	dashHandler.RegisterRoutes(r)
 	inspHandler.RegisterRoutes(r)
+	rulesHandler.RegisterRoutes(r)
+	schedulesHandler.RegisterRoutes(r)
+	zonesHandler.RegisterRoutes(r)
+	jobsHandler.RegisterRoutes(r)
+	apiHandler.RegisterRoutes(r)
+	webhooksHandler.RegisterRoutes(r)
 
 	// Start Server
-	log.Println("Starting server on :3000")
-	if err := http.ListenAndServe(":3000", r); err != nil {
-		log.Fatal("Failed to start server:", err)
+	srv := &http.Server{Addr: cfg.Addr, Handler: r}
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Println("Starting server on " + cfg.Addr)
+		serverErr <- srv.ListenAndServe()
+	}()
+
+	exitCode := 0
+	select {
+	case err := <-serverErr:
+		log.Println("Failed to start server:", err)
+		exitCode = 1
+	case <-ctx.Done():
+		log.Println("Shutting down...")
+	}
+	// A second signal kills the server right away
+	stop()
+
+	shutdown(srv, bgDone, db, cfg.ShutdownTimeout)
+	os.Exit(exitCode)
+}
+
+// shutdown drains the HTTP server, waits for the background jobs and
+// closes the database. Requests and jobs still running after timeout
+// are abandoned.
+func shutdown(srv *http.Server, background <-chan struct{}, db *store.Store, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Println("Failed to drain HTTP requests:", err)
+	}
+	select {
+	case <-background:
+	case <-ctx.Done():
+		log.Println("Background jobs didn't finish in time.")
+	}
+
+	if err := db.Close(); err != nil {
+		log.Println("Failed to close database:", err)
 	}
+	log.Println("Shutdown complete.")
 }
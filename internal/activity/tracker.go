@@ -0,0 +1,63 @@
+// Package activity keeps track of when people are using the LEDs,
+// so background lighting only runs while nobody is locating parts.
+package activity
+
+import (
+	"sync"
+	"time"
+)
+
+// Tracker records which parts are currently being located
+// and when the LEDs were last used
+type Tracker struct {
+	mu           sync.Mutex
+	locating     map[int]bool
+	lastActivity time.Time
+	now          func() time.Time
+}
+
+func New() *Tracker {
+	return &Tracker{locating: make(map[int]bool), now: time.Now}
+}
+
+// LocateStarted records that a part's bins are lit
+func (t *Tracker) LocateStarted(partID int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.locating[partID] = true
+	t.lastActivity = t.now()
+}
+
+// LocateStopped records that a part's bins were turned off
+func (t *Tracker) LocateStopped(partID int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.locating, partID)
+	t.lastActivity = t.now()
+}
+
+// AllStopped records that every LED was turned off
+func (t *Tracker) AllStopped() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.locating = make(map[int]bool)
+	t.lastActivity = t.now()
+}
+
+// Touch records any other use of the LEDs, e.g. a stock status view
+func (t *Tracker) Touch() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastActivity = t.now()
+}
+
+// IsIdle reports whether nothing is being located and the LEDs
+// haven't been used for at least the given duration
+func (t *Tracker) IsIdle(quietFor time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.locating) > 0 {
+		return false
+	}
+	return t.now().Sub(t.lastActivity) >= quietFor
+}
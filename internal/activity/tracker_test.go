@@ -0,0 +1,42 @@
+package activity
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTracker_IsIdle(t *testing.T) {
+	now := time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC)
+	tr := New()
+	tr.now = func() time.Time { return now }
+
+	if !tr.IsIdle(5 * time.Minute) {
+		t.Errorf("Fresh tracker should be idle")
+	}
+
+	tr.LocateStarted(1)
+	tr.LocateStarted(2)
+	now = now.Add(time.Hour)
+	if tr.IsIdle(5 * time.Minute) {
+		t.Errorf("Should not be idle while locating")
+	}
+
+	tr.LocateStopped(1)
+	if tr.IsIdle(0) {
+		t.Errorf("Part 2 is still being located")
+	}
+
+	tr.AllStopped()
+	if tr.IsIdle(5 * time.Minute) {
+		t.Errorf("Should not be idle right after stopping")
+	}
+	now = now.Add(5 * time.Minute)
+	if !tr.IsIdle(5 * time.Minute) {
+		t.Errorf("Should be idle 5 minutes after stopping")
+	}
+
+	tr.Touch()
+	if tr.IsIdle(time.Minute) {
+		t.Errorf("Touch should reset the idle timer")
+	}
+}
@@ -0,0 +1,53 @@
+package background
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"wledger/internal/models"
+)
+
+func TestRunHealthChecks(t *testing.T) {
+	s, ms, _, _, _ := setupTest()
+
+	s.runHealthChecks(context.Background())
+
+	if ms.Statuses[1] != "online" || ms.Statuses[2] != "offline" {
+		t.Errorf("Unexpected statuses: %v", ms.Statuses)
+	}
+	if len(ms.Probes) != 2 {
+		t.Fatalf("Expected 2 probes recorded, got %d", len(ms.Probes))
+	}
+	sort.Slice(ms.Probes, func(i, j int) bool { return ms.Probes[i].ControllerID < ms.Probes[j].ControllerID })
+
+	online, offline := ms.Probes[0], ms.Probes[1]
+	if !online.Online || online.Latency != 15*time.Millisecond || online.Error != "" {
+		t.Errorf("Unexpected online probe: %+v", online)
+	}
+	if offline.Online || offline.Error != "unreachable" {
+		t.Errorf("Unexpected offline probe: %+v", offline)
+	}
+}
+
+func TestRunHealthChecks_OfflineEvent(t *testing.T) {
+	s, ms, _, _, _ := setupTest()
+	ms.Controllers = []models.WLEDController{
+		{ID: 1, IPAddress: "10.0.0.1", Status: "online"},
+		{ID: 2, IPAddress: "10.0.0.2", Status: "online"},
+	}
+
+	s.runHealthChecks(context.Background())
+	if len(ms.Events) != 1 || ms.Events[0] != models.EventControllerOffline {
+		t.Errorf("Expected one controller.offline event, got %v", ms.Events)
+	}
+
+	// Still offline is no news
+	ms.Events = nil
+	ms.Controllers[1].Status = "offline"
+	s.runHealthChecks(context.Background())
+	if len(ms.Events) != 0 {
+		t.Errorf("Expected no events for a controller that stayed offline, got %v", ms.Events)
+	}
+}
@@ -0,0 +1,350 @@
+package background
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"wledger/internal/cron"
+	"wledger/internal/models"
+)
+
+// Lighting schedule actions
+const (
+	ActionStockStatus = "stock_status" // Show stock status, optionally from a saved preset
+	ActionAmbient     = "ambient"      // Light all bins in one color while nobody is locating
+	ActionQuietStart  = "quiet_start"  // Dim or turn off all controllers
+	ActionQuietEnd    = "quiet_end"    // Restore all controllers, optionally at a brightness
+)
+
+// Schedule run statuses
+const (
+	RunStatusOK      = "ok"
+	RunStatusSkipped = "skipped"
+	RunStatusError   = "error"
+)
+
+// ambientIdleAfter is how long the LEDs must be unused before ambient lighting runs
+const ambientIdleAfter = 15 * time.Minute
+
+// actionOrder decides the run order of schedules firing in the same minute,
+// so quiet hours end before and start after anything else.
+var actionOrder = map[string]int{
+	ActionQuietEnd:    0,
+	ActionStockStatus: 1,
+	ActionAmbient:     2,
+	ActionQuietStart:  3,
+}
+
+// runLightingSchedules runs every enabled schedule that fires in the minute of now.
+// Each minute is only handled once, however often it is called.
+func (s *Service) runLightingSchedules(ctx context.Context, now time.Time) error {
+	minute := now.Truncate(time.Minute)
+	s.mu.Lock()
+	if !minute.After(s.lastScheduleMinute) {
+		s.mu.Unlock()
+		return nil
+	}
+	s.lastScheduleMinute = minute
+	s.mu.Unlock()
+
+	schedules, err := s.store.GetLightingSchedules(ctx)
+	if err != nil {
+		return fmt.Errorf("querying lighting schedules: %w", err)
+	}
+
+	due := []models.LightingSchedule{}
+	for _, ls := range schedules {
+		if !ls.Enabled {
+			continue
+		}
+		spec, err := cron.Parse(ls.Cron)
+		if err != nil {
+			log.Printf("Scheduler: Skipping %q, invalid cron expression: %v", ls.Name, err)
+			continue
+		}
+		if spec.Matches(minute) {
+			due = append(due, ls)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return actionOrder[due[i].Action] < actionOrder[due[j].Action]
+	})
+
+	for _, ls := range due {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s.runLightingSchedule(ctx, ls)
+	}
+	return nil
+}
+
+// runLightingSchedule runs a single schedule and logs the run
+func (s *Service) runLightingSchedule(ctx context.Context, ls models.LightingSchedule) {
+	run := &models.ScheduleRun{
+		ScheduleID:   sql.NullInt64{Int64: int64(ls.ID), Valid: true},
+		ScheduleName: ls.Name,
+		Action:       ls.Action,
+		StartedAt:    time.Now(),
+	}
+
+	var err error
+	run.Status, run.Message, err = s.runLightingAction(ctx, ls)
+	if err != nil {
+		run.Status = RunStatusError
+		run.Message = err.Error()
+	}
+	run.Duration = time.Since(run.StartedAt)
+
+	log.Printf("Scheduler: %q (%s) %s: %s", ls.Name, ls.Action, run.Status, run.Message)
+	// The run is logged even if it was cut short by a shutdown
+	if err := s.store.RecordScheduleRun(context.WithoutCancel(ctx), run); err != nil {
+		log.Println("Scheduler: Error recording schedule run:", err)
+	}
+}
+
+func (s *Service) runLightingAction(ctx context.Context, ls models.LightingSchedule) (status, message string, err error) {
+	switch ls.Action {
+	case ActionStockStatus:
+		if s.inQuietHours() {
+			return RunStatusSkipped, "Quiet hours are in effect.", nil
+		}
+		view := models.StockStatusPreset{Level: "all"}
+		if ls.PresetID != 0 {
+			if view, err = s.store.GetStockStatusPresetByID(ctx, ls.PresetID); err != nil {
+				return "", "", fmt.Errorf("loading preset %d: %w", ls.PresetID, err)
+			}
+		}
+		result, err := s.lights.ShowStockStatus(ctx, view, "")
+		if err != nil {
+			return "", "", err
+		}
+		return runResult(fmt.Sprintf("Lit %d bins", result.BinsLit), len(result.Offline))
+
+	case ActionAmbient:
+		if s.inQuietHours() {
+			return RunStatusSkipped, "Quiet hours are in effect.", nil
+		}
+		if !s.activity.IsIdle(ambientIdleAfter) {
+			return RunStatusSkipped, "The LEDs are in use.", nil
+		}
+		return s.showAmbient(ctx, scaleColor(ls.Color, ls.Brightness))
+
+	case ActionQuietStart:
+		s.setQuietHours(true, ls.Brightness <= 0)
+		s.saveControllerStates(ctx)
+		state := models.WLEDState{}
+		if ls.Brightness <= 0 {
+			state.On = boolPtr(false)
+		} else {
+			state.Brightness = intPtr(ls.Brightness)
+		}
+		return s.sendToAllControllers(ctx, state)
+
+	case ActionQuietEnd:
+		s.setQuietHours(false, false)
+		saved := s.quietStates
+		s.quietStates = nil
+		if ls.Brightness > 0 {
+			return s.sendToAllControllers(ctx, models.WLEDState{On: boolPtr(true), Brightness: intPtr(ls.Brightness)})
+		}
+		return s.restoreControllerStates(ctx, saved)
+
+	default:
+		return "", "", fmt.Errorf("unknown action %q", ls.Action)
+	}
+}
+
+// showAmbient lights every bin in the given color
+func (s *Service) showAmbient(ctx context.Context, color string) (string, string, error) {
+	bins, err := s.store.GetAllBinLocationsForStopAll(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	payloads := make(map[string]map[int][]interface{})
+	for _, bin := range bins {
+		if payloads[bin.IP] == nil {
+			payloads[bin.IP] = make(map[int][]interface{})
+		}
+		payloads[bin.IP][bin.SegID] = append(payloads[bin.IP][bin.SegID], bin.LEDIndex, color)
+	}
+
+	failed := 0
+	for ip, segments := range payloads {
+		wledSegments := []models.WLEDSegment{}
+		for segID, iPayload := range segments {
+			wledSegments = append(wledSegments, models.WLEDSegment{ID: segID, On: true, I: iPayload})
+		}
+		if err := s.wled.SendCommand(ip, models.WLEDState{Segments: wledSegments}); err != nil {
+			log.Printf("Scheduler: Failed to send ambient command to %s: %v", ip, err)
+			failed++
+		}
+	}
+	return runResult(fmt.Sprintf("Lit %d bins", len(bins)), failed)
+}
+
+// sendToAllControllers sends the same state to every controller
+func (s *Service) sendToAllControllers(ctx context.Context, state models.WLEDState) (string, string, error) {
+	controllers, err := s.store.GetAllControllersForHealthCheck(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	failed := 0
+	for _, c := range controllers {
+		if err := s.wled.SendCommand(c.IPAddress, state); err != nil {
+			log.Printf("Scheduler: Failed to send command to %s: %v", c.IPAddress, err)
+			failed++
+		}
+	}
+	return runResult(fmt.Sprintf("Updated %d controllers", len(controllers)-failed), failed)
+}
+
+// saveControllerStates remembers each controller's power and brightness as
+// quiet hours start, so ending them can put them back. A controller saved
+// by an earlier start, e.g. one that dimmed it before this one turns it
+// off, keeps its state from before quiet hours.
+func (s *Service) saveControllerStates(ctx context.Context) {
+	controllers, err := s.store.GetAllControllersForHealthCheck(ctx)
+	if err != nil {
+		log.Println("Scheduler: Error querying controllers:", err)
+		return
+	}
+	if s.quietStates == nil {
+		s.quietStates = make(map[string]models.WLEDState)
+	}
+	for _, c := range controllers {
+		if _, ok := s.quietStates[c.IPAddress]; ok {
+			continue
+		}
+		raw, err := s.wled.GetState(c.IPAddress)
+		if err != nil {
+			log.Printf("Scheduler: Failed to save the state of %s: %v", c.IPAddress, err)
+			continue
+		}
+		var state struct {
+			On         *bool `json:"on"`
+			Brightness *int  `json:"bri"`
+		}
+		if err := json.Unmarshal(raw, &state); err != nil || state.On == nil || state.Brightness == nil {
+			log.Printf("Scheduler: Unexpected state from %s: %s", c.IPAddress, raw)
+			continue
+		}
+		s.quietStates[c.IPAddress] = models.WLEDState{On: state.On, Brightness: state.Brightness}
+	}
+}
+
+// restoreControllerStates gives every controller back the power and
+// brightness saved when quiet hours started. Controllers without a saved
+// state, e.g. after a restart, are just turned on at their own brightness.
+func (s *Service) restoreControllerStates(ctx context.Context, saved map[string]models.WLEDState) (string, string, error) {
+	controllers, err := s.store.GetAllControllersForHealthCheck(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	failed := 0
+	for _, c := range controllers {
+		state, ok := saved[c.IPAddress]
+		if !ok {
+			state = models.WLEDState{On: boolPtr(true)}
+		}
+		if err := s.wled.SendCommand(c.IPAddress, state); err != nil {
+			log.Printf("Scheduler: Failed to send command to %s: %v", c.IPAddress, err)
+			failed++
+		}
+	}
+	return runResult(fmt.Sprintf("Restored %d controllers", len(controllers)-failed), failed)
+}
+
+// runResult reports an error status if any controller could not be reached
+func runResult(summary string, failed int) (string, string, error) {
+	if failed > 0 {
+		return RunStatusError, fmt.Sprintf("%s, %d controllers unreachable.", summary, failed), nil
+	}
+	return RunStatusOK, summary + ".", nil
+}
+
+func (s *Service) inQuietHours() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.quiet
+}
+
+// ControllersOff reports whether quiet hours have turned the controllers
+// off, so bins lit on request stay dark until quiet hours end
+func (s *Service) ControllersOff() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.quiet && s.quietOff
+}
+
+func (s *Service) setQuietHours(quiet, off bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.quiet = quiet
+	s.quietOff = quiet && off
+}
+
+// restoreQuietHours works out whether quiet hours are in effect after a
+// restart, by finding whichever quiet hours schedule fired last in the past day.
+func (s *Service) restoreQuietHours(ctx context.Context, now time.Time) {
+	schedules, err := s.store.GetLightingSchedules(ctx)
+	if err != nil {
+		log.Println("Scheduler: Error querying lighting schedules:", err)
+		return
+	}
+
+	type quietSpec struct {
+		start bool
+		off   bool // Quiet hours turn the controllers off
+		spec  *cron.Schedule
+	}
+	specs := []quietSpec{}
+	for _, ls := range schedules {
+		if !ls.Enabled || (ls.Action != ActionQuietStart && ls.Action != ActionQuietEnd) {
+			continue
+		}
+		if spec, err := cron.Parse(ls.Cron); err == nil {
+			specs = append(specs, quietSpec{start: ls.Action == ActionQuietStart, off: ls.Brightness <= 0, spec: spec})
+		}
+	}
+	if len(specs) == 0 {
+		return
+	}
+
+	minute := now.Truncate(time.Minute)
+	for t := minute; t.After(minute.Add(-24 * time.Hour)); t = t.Add(-time.Minute) {
+		for _, q := range specs {
+			if q.spec.Matches(t) {
+				s.setQuietHours(q.start, q.off)
+				return
+			}
+		}
+	}
+}
+
+// scaleColor dims a hex RGB color to the given brightness (0-255).
+// A brightness of 0 or less leaves the color unchanged.
+func scaleColor(hex string, brightness int) string {
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return hex
+	}
+	if brightness <= 0 || brightness > 255 {
+		return hex
+	}
+	scale := func(c uint64) uint64 { return c * uint64(brightness) / 255 }
+	r, g, b := scale(value>>16&0xFF), scale(value>>8&0xFF), scale(value&0xFF)
+	return fmt.Sprintf("%02X%02X%02X", r, g, b)
+}
+
+func boolPtr(b bool) *bool { return &b }
+func intPtr(i int) *int    { return &i }
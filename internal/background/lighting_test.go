@@ -0,0 +1,342 @@
+package background
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"wledger/internal/models"
+)
+
+// Local Mocks
+type mockStore struct {
+	Schedules []models.LightingSchedule
+	Runs      []models.ScheduleRun
+
+	mu       sync.Mutex // Health checks run concurrently
+	Statuses map[int]string
+	Probes   []models.HealthProbe
+
+	Controllers []models.WLEDController // The two test controllers if nil
+	Events      []models.WebhookEvent
+	Hooks       []models.Webhook
+	Deliveries  []models.WebhookDelivery // Returned as due, updated by RecordWebhookAttempt
+
+	BinData    []models.DashboardBinData
+	StockRules []models.StockRule
+}
+
+func (m *mockStore) GetAllControllersForHealthCheck(ctx context.Context) ([]models.WLEDController, error) {
+	if m.Controllers != nil {
+		return m.Controllers, nil
+	}
+	return []models.WLEDController{{ID: 1, IPAddress: "10.0.0.1"}, {ID: 2, IPAddress: "10.0.0.2"}}, nil
+}
+func (m *mockStore) UpdateControllerStatus(ctx context.Context, id int, status string, lastSeen sql.NullTime) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Statuses == nil {
+		m.Statuses = make(map[int]string)
+	}
+	m.Statuses[id] = status
+	return nil
+}
+func (m *mockStore) RecordHealthProbe(ctx context.Context, p *models.HealthProbe) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Probes = append(m.Probes, *p)
+	return nil
+}
+func (m *mockStore) PruneHealthProbes(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+func (m *mockStore) CleanupOrphanedCategories(ctx context.Context) error { return nil }
+func (m *mockStore) GetDashboardBinData(ctx context.Context, filter models.StockStatusFilter) ([]models.DashboardBinData, error) {
+	return m.BinData, nil
+}
+func (m *mockStore) GetStockRules(ctx context.Context) ([]models.StockRule, error) {
+	return m.StockRules, nil
+}
+func (m *mockStore) GetStockStatusPresetByID(ctx context.Context, id int) (models.StockStatusPreset, error) {
+	if id == 7 {
+		return models.StockStatusPreset{ID: 7, Name: "Critical", Level: "critical"}, nil
+	}
+	return models.StockStatusPreset{}, sql.ErrNoRows
+}
+func (m *mockStore) GetLightingSchedules(ctx context.Context) ([]models.LightingSchedule, error) {
+	return m.Schedules, nil
+}
+func (m *mockStore) RecordScheduleRun(ctx context.Context, run *models.ScheduleRun) error {
+	m.Runs = append(m.Runs, *run)
+	return nil
+}
+func (m *mockStore) GetAllBinLocationsForStopAll(ctx context.Context) ([]struct {
+	IP       string
+	SegID    int
+	LEDIndex int
+}, error) {
+	return []struct {
+		IP       string
+		SegID    int
+		LEDIndex int
+	}{{"10.0.0.1", 0, 3}, {"10.0.0.1", 0, 4}}, nil
+}
+
+func (m *mockStore) EmitWebhookEvent(ctx context.Context, event models.WebhookEvent, data any) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Events = append(m.Events, event)
+	return nil
+}
+func (m *mockStore) GetWebhooks(ctx context.Context) ([]models.Webhook, error) { return m.Hooks, nil }
+func (m *mockStore) GetDueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	due := []models.WebhookDelivery{}
+	for _, d := range m.Deliveries {
+		if d.Status == "pending" && !d.NextAttemptAt.After(now) {
+			due = append(due, d)
+		}
+	}
+	return due, nil
+}
+func (m *mockStore) RecordWebhookAttempt(ctx context.Context, d *models.WebhookDelivery) error {
+	for i := range m.Deliveries {
+		if m.Deliveries[i].ID == d.ID {
+			m.Deliveries[i] = *d
+		}
+	}
+	return nil
+}
+func (m *mockStore) PruneWebhookDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+
+type mockWLED struct {
+	Sent   map[string][]models.WLEDState
+	States map[string]string // What GetState returns, unreachable if missing
+	Fail   bool
+	Block  chan struct{} // Probes wait until it's closed
+}
+
+func (m *mockWLED) Probe(ip string) (time.Duration, error) {
+	if m.Block != nil {
+		<-m.Block
+	}
+	if m.Fail || ip == "10.0.0.2" {
+		return 0, errors.New("unreachable")
+	}
+	return 15 * time.Millisecond, nil
+}
+func (m *mockWLED) SendCommand(ip string, state models.WLEDState) error {
+	if m.Fail {
+		return errors.New("unreachable")
+	}
+	if m.Sent == nil {
+		m.Sent = make(map[string][]models.WLEDState)
+	}
+	m.Sent[ip] = append(m.Sent[ip], state)
+	return nil
+}
+
+func (m *mockWLED) GetState(ip string) (json.RawMessage, error) {
+	state, ok := m.States[ip]
+	if m.Fail || !ok {
+		return nil, errors.New("unreachable")
+	}
+	return json.RawMessage(state), nil
+}
+
+type mockLights struct {
+	Views []models.StockStatusPreset
+}
+
+func (m *mockLights) ShowStockStatus(ctx context.Context, view models.StockStatusPreset, fillStyle string) (models.StockStatusResult, error) {
+	m.Views = append(m.Views, view)
+	return models.StockStatusResult{BinsLit: 5}, nil
+}
+
+type mockActivity struct {
+	Idle bool
+}
+
+func (m *mockActivity) IsIdle(quietFor time.Duration) bool { return m.Idle }
+
+func setupTest(schedules ...models.LightingSchedule) (*Service, *mockStore, *mockWLED, *mockLights, *mockActivity) {
+	ms := &mockStore{Schedules: schedules}
+	mw := &mockWLED{}
+	ml := &mockLights{}
+	ma := &mockActivity{Idle: true}
+	return New(ms, mw, ml, ma), ms, mw, ml, ma
+}
+
+// Weekday morning, Monday 2025-06-02 08:00:20
+var monday8 = time.Date(2025, 6, 2, 8, 0, 20, 0, time.Local)
+
+// Tests
+
+func TestRunLightingSchedules_StockStatus(t *testing.T) {
+	s, ms, _, ml, _ := setupTest(
+		models.LightingSchedule{ID: 1, Name: "Morning", Cron: "0 8 * * 1-5", Action: ActionStockStatus, PresetID: 7, Enabled: true},
+		models.LightingSchedule{ID: 2, Name: "Disabled", Cron: "0 8 * * *", Action: ActionStockStatus, Enabled: false},
+		models.LightingSchedule{ID: 3, Name: "Evening", Cron: "0 18 * * *", Action: ActionStockStatus, Enabled: true},
+	)
+
+	s.runLightingSchedules(context.Background(), monday8)
+
+	if len(ml.Views) != 1 || ml.Views[0].Level != "critical" {
+		t.Fatalf("Expected the preset view to be shown once, got %+v", ml.Views)
+	}
+	if len(ms.Runs) != 1 {
+		t.Fatalf("Expected 1 run logged, got %d", len(ms.Runs))
+	}
+	run := ms.Runs[0]
+	if run.ScheduleName != "Morning" || run.Status != RunStatusOK || run.Message != "Lit 5 bins." {
+		t.Errorf("Unexpected run: %+v", run)
+	}
+
+	// The same minute isn't run twice
+	s.runLightingSchedules(context.Background(), monday8.Add(15*time.Second))
+	if len(ml.Views) != 1 {
+		t.Errorf("Expected the schedule to run once per minute, ran %d times", len(ml.Views))
+	}
+}
+
+func TestRunLightingSchedules_QuietHours(t *testing.T) {
+	s, ms, mw, ml, _ := setupTest(
+		models.LightingSchedule{ID: 1, Name: "Night", Cron: "0 22 * * *", Action: ActionQuietStart, Brightness: 0, Enabled: true},
+		models.LightingSchedule{ID: 2, Name: "Stock", Cron: "* * * * *", Action: ActionStockStatus, Enabled: true},
+		models.LightingSchedule{ID: 3, Name: "Morning", Cron: "0 7 * * *", Action: ActionQuietEnd, Brightness: 128, Enabled: true},
+	)
+
+	night := time.Date(2025, 6, 2, 22, 0, 0, 0, time.Local)
+	s.runLightingSchedules(context.Background(), night)
+
+	// Stock status runs before quiet hours start in the same minute
+	if len(ml.Views) != 1 {
+		t.Fatalf("Expected stock status to run before quiet hours, got %d runs", len(ml.Views))
+	}
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		sent := mw.Sent[ip]
+		if len(sent) != 1 || sent[0].On == nil || *sent[0].On {
+			t.Errorf("Expected %s to be turned off, got %+v", ip, sent)
+		}
+	}
+	if !s.ControllersOff() {
+		t.Errorf("Expected the controllers to be reported off")
+	}
+
+	// Skipped during quiet hours
+	s.runLightingSchedules(context.Background(), night.Add(time.Minute))
+	if len(ml.Views) != 1 {
+		t.Errorf("Expected stock status to be skipped during quiet hours")
+	}
+	last := ms.Runs[len(ms.Runs)-1]
+	if last.Status != RunStatusSkipped {
+		t.Errorf("Expected a skipped run, got %+v", last)
+	}
+
+	// Quiet hours end in the morning, before stock status runs
+	s.runLightingSchedules(context.Background(), time.Date(2025, 6, 3, 7, 0, 0, 0, time.Local))
+	if len(ml.Views) != 2 {
+		t.Errorf("Expected stock status to run after quiet hours")
+	}
+	sent := mw.Sent["10.0.0.1"]
+	if st := sent[len(sent)-1]; st.On == nil || !*st.On || st.Brightness == nil || *st.Brightness != 128 {
+		t.Errorf("Expected controllers to be restored at brightness 128, got %+v", st)
+	}
+	if s.ControllersOff() {
+		t.Errorf("Expected the controllers to be reported on after quiet hours")
+	}
+}
+
+func TestRunLightingSchedules_QuietHoursRestoreStates(t *testing.T) {
+	s, _, mw, _, _ := setupTest(
+		models.LightingSchedule{ID: 1, Name: "Evening", Cron: "0 20 * * *", Action: ActionQuietStart, Brightness: 30, Enabled: true},
+		models.LightingSchedule{ID: 2, Name: "Night", Cron: "0 22 * * *", Action: ActionQuietStart, Enabled: true},
+		models.LightingSchedule{ID: 3, Name: "Morning", Cron: "0 7 * * *", Action: ActionQuietEnd, Enabled: true},
+	)
+	mw.States = map[string]string{"10.0.0.1": `{"on":true,"bri":90,"seg":[{"id":0,"col":[[255,0,0]]}]}`}
+
+	s.runLightingSchedules(t.Context(), time.Date(2025, 6, 2, 20, 0, 0, 0, time.Local))
+	// Dimmed by the evening schedule, not what the night one should save
+	mw.States["10.0.0.1"] = `{"on":true,"bri":30}`
+	s.runLightingSchedules(t.Context(), time.Date(2025, 6, 2, 22, 0, 0, 0, time.Local))
+	s.runLightingSchedules(t.Context(), time.Date(2025, 6, 3, 7, 0, 0, 0, time.Local))
+
+	sent := mw.Sent["10.0.0.1"]
+	if st := sent[len(sent)-1]; st.On == nil || !*st.On || st.Brightness == nil || *st.Brightness != 90 {
+		t.Errorf("Expected 10.0.0.1 back at its brightness of 90, got %+v", st)
+	}
+	// Its state couldn't be saved, so its brightness is left alone
+	sent = mw.Sent["10.0.0.2"]
+	if st := sent[len(sent)-1]; st.On == nil || !*st.On || st.Brightness != nil {
+		t.Errorf("Expected 10.0.0.2 just turned on, got %+v", st)
+	}
+}
+
+func TestRestoreQuietHours(t *testing.T) {
+	s, _, _, _, _ := setupTest(
+		models.LightingSchedule{ID: 1, Name: "Night", Cron: "0 22 * * *", Action: ActionQuietStart, Enabled: true},
+		models.LightingSchedule{ID: 2, Name: "Morning", Cron: "0 7 * * *", Action: ActionQuietEnd, Enabled: true},
+	)
+
+	s.restoreQuietHours(t.Context(), time.Date(2025, 6, 3, 2, 30, 0, 0, time.Local))
+	if !s.inQuietHours() || !s.ControllersOff() {
+		t.Errorf("Expected quiet hours with the controllers off at 02:30")
+	}
+	s.restoreQuietHours(t.Context(), time.Date(2025, 6, 3, 12, 0, 0, 0, time.Local))
+	if s.inQuietHours() {
+		t.Errorf("Expected no quiet hours at 12:00")
+	}
+}
+
+func TestControllersOff_Dimmed(t *testing.T) {
+	s, _, _, _, _ := setupTest(
+		models.LightingSchedule{ID: 1, Name: "Night", Cron: "0 22 * * *", Action: ActionQuietStart, Brightness: 20, Enabled: true},
+	)
+
+	// Dimmed controllers still show located bins
+	s.runLightingSchedules(context.Background(), time.Date(2025, 6, 2, 22, 0, 0, 0, time.Local))
+	if !s.inQuietHours() || s.ControllersOff() {
+		t.Errorf("Expected quiet hours with the controllers dimmed, not off")
+	}
+}
+
+func TestRunLightingSchedules_Ambient(t *testing.T) {
+	s, ms, mw, _, ma := setupTest(
+		models.LightingSchedule{ID: 1, Name: "Glow", Cron: "*/5 * * * *", Action: ActionAmbient, Color: "0000FF", Brightness: 51, Enabled: true},
+	)
+
+	// Someone is locating parts
+	ma.Idle = false
+	s.runLightingSchedules(context.Background(), monday8)
+	if len(mw.Sent) != 0 || ms.Runs[0].Status != RunStatusSkipped {
+		t.Fatalf("Expected ambient to be skipped while the LEDs are in use")
+	}
+
+	ma.Idle = true
+	s.runLightingSchedules(context.Background(), monday8.Add(5*time.Minute))
+	sent := mw.Sent["10.0.0.1"]
+	if len(sent) != 1 || len(sent[0].Segments) != 1 {
+		t.Fatalf("Expected one ambient command, got %+v", sent)
+	}
+	i := sent[0].Segments[0].I
+	if len(i) != 4 || i[1] != "000033" {
+		t.Errorf("Expected both bins in dimmed blue, got %v", i)
+	}
+}
+
+func TestRunLightingSchedules_Unreachable(t *testing.T) {
+	s, ms, mw, _, _ := setupTest(
+		models.LightingSchedule{ID: 1, Name: "Night", Cron: "* * * * *", Action: ActionQuietStart, Brightness: 20, Enabled: true},
+	)
+	mw.Fail = true
+
+	s.runLightingSchedules(context.Background(), monday8)
+	if ms.Runs[0].Status != RunStatusError || ms.Runs[0].Message != "Updated 0 controllers, 2 controllers unreachable." {
+		t.Errorf("Unexpected run: %+v", ms.Runs[0])
+	}
+}
@@ -1,94 +1,352 @@
 package background
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"wledger/internal/models"
+	"wledger/internal/scheduler"
+	"wledger/internal/stockstatus"
+	"wledger/internal/webhook"
 )
 
 // Store defines the methods this service needs from the database
 type Store interface {
-	GetAllControllersForHealthCheck() ([]models.WLEDController, error)
-	UpdateControllerStatus(id int, status string, lastSeen sql.NullTime) error
-	CleanupOrphanedCategories() error
+	GetAllControllersForHealthCheck(ctx context.Context) ([]models.WLEDController, error)
+	UpdateControllerStatus(ctx context.Context, id int, status string, lastSeen sql.NullTime) error
+	RecordHealthProbe(ctx context.Context, p *models.HealthProbe) error
+	PruneHealthProbes(ctx context.Context, before time.Time) (int64, error)
+	CleanupOrphanedCategories(ctx context.Context) error
+	GetDashboardBinData(ctx context.Context, filter models.StockStatusFilter) ([]models.DashboardBinData, error)
+	GetStockRules(ctx context.Context) ([]models.StockRule, error)
+	GetStockStatusPresetByID(ctx context.Context, id int) (models.StockStatusPreset, error)
+	GetLightingSchedules(ctx context.Context) ([]models.LightingSchedule, error)
+	RecordScheduleRun(ctx context.Context, run *models.ScheduleRun) error
+	GetAllBinLocationsForStopAll(ctx context.Context) ([]struct {
+		IP       string
+		SegID    int
+		LEDIndex int
+	}, error)
+	EmitWebhookEvent(ctx context.Context, event models.WebhookEvent, data any) error
+	GetWebhooks(ctx context.Context) ([]models.Webhook, error)
+	GetDueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error)
+	RecordWebhookAttempt(ctx context.Context, d *models.WebhookDelivery) error
+	PruneWebhookDeliveries(ctx context.Context, before time.Time) (int64, error)
 }
 
 // WLEDClient defines the hardware communication methods
 type WLEDClient interface {
-	Ping(ipAddress string) bool
+	Probe(ipAddress string) (time.Duration, error)
+	SendCommand(ipAddress string, state models.WLEDState) error
+	GetState(ipAddress string) (json.RawMessage, error)
 }
 
-type Service struct {
-	store Store
-	wled  WLEDClient
+// StockStatusRunner lights bins by stock status, see the dashboard package
+type StockStatusRunner interface {
+	ShowStockStatus(ctx context.Context, view models.StockStatusPreset, fillStyle string) (models.StockStatusResult, error)
+}
+
+// WebhookSender delivers webhooks, see the webhook package
+type WebhookSender interface {
+	Send(ctx context.Context, w models.Webhook, d models.WebhookDelivery) (int, error)
 }
 
-func New(s Store, w WLEDClient) *Service {
-	return &Service{store: s, wled: w}
+// ActivityMonitor reports whether anyone is using the LEDs
+type ActivityMonitor interface {
+	IsIdle(quietFor time.Duration) bool
 }
 
-func (s *Service) Start() {
-	log.Println("Starting background services...")
+// Defaults for the Service's intervals
+const (
+	DefaultHealthInterval    = 1 * time.Minute
+	DefaultHealthRetention   = 30 * 24 * time.Hour
+	DefaultCleanupInterval   = 6 * time.Hour
+	DefaultStockRuleInterval = 1 * time.Hour
+	DefaultWebhookInterval   = 10 * time.Second
+	DefaultWebhookRetention  = 30 * 24 * time.Hour
+
+	// healthCheckWorkers limits how many controllers are probed at once
+	healthCheckWorkers = 8
+)
+
+type Service struct {
+	store    Store
+	wled     WLEDClient
+	lights   StockStatusRunner
+	activity ActivityMonitor
+	webhooks WebhookSender
 
-	healthTicker := time.NewTicker(1 * time.Minute)
-	defer healthTicker.Stop()
+	// HealthInterval is how often controllers are probed,
+	// HealthRetention how long the probe history is kept
+	HealthInterval  time.Duration
+	HealthRetention time.Duration
 
-	cleanupTicker := time.NewTicker(6 * time.Hour)
-	defer cleanupTicker.Stop()
+	// CleanupInterval is how often unused categories are removed,
+	// StockRuleInterval how often stock rule notifications are checked
+	CleanupInterval   time.Duration
+	StockRuleInterval time.Duration
 
-	go s.runHealthChecks()
-	go s.runCleanupJob()
+	// WebhookInterval is how often due webhook deliveries are sent,
+	// WebhookRetention how long finished ones are kept in the log
+	WebhookInterval  time.Duration
+	WebhookRetention time.Duration
 
-	for {
-		select {
-		case <-healthTicker.C:
-			go s.runHealthChecks()
-		case <-cleanupTicker.C:
-			go s.runCleanupJob()
+	// The notification rules that matched each part at the last check. Only
+	// the stock rule job uses it, and the scheduler never overlaps a job.
+	notified map[ruleMatch]ruleNames
+
+	// Each controller's power and brightness from before quiet hours, by
+	// address. Only the lighting schedule job uses it.
+	quietStates map[string]models.WLEDState
+
+	mu                 sync.Mutex
+	quiet              bool      // Quiet hours are in effect
+	quietOff           bool      // and have turned the controllers off
+	lastScheduleMinute time.Time // Last minute lighting schedules were checked for
+}
+
+func New(s Store, w WLEDClient, l StockStatusRunner, a ActivityMonitor) *Service {
+	return &Service{
+		store:             s,
+		wled:              w,
+		lights:            l,
+		activity:          a,
+		webhooks:          webhook.NewClient(),
+		HealthInterval:    DefaultHealthInterval,
+		HealthRetention:   DefaultHealthRetention,
+		CleanupInterval:   DefaultCleanupInterval,
+		StockRuleInterval: DefaultStockRuleInterval,
+		WebhookInterval:   DefaultWebhookInterval,
+		WebhookRetention:  DefaultWebhookRetention,
+	}
+}
+
+// Jobs the service registers with the scheduler
+const (
+	JobHealthChecks      = "health-checks"
+	JobCleanup           = "cleanup"
+	JobStockRules        = "stock-rule-notifications"
+	JobLightingSchedules = "lighting-schedules"
+	JobWebhooks          = "webhook-deliveries"
+)
+
+// scheduleCheckInterval is how often lighting schedules are checked, more
+// often than once a minute so they fire close to the minute mark
+const scheduleCheckInterval = 15 * time.Second
+
+// RegisterJobs registers the background jobs with the scheduler. It also
+// works out whether quiet hours are in effect, for the first schedule check.
+func (s *Service) RegisterJobs(ctx context.Context, sched *scheduler.Scheduler) error {
+	s.restoreQuietHours(ctx, time.Now())
+
+	jobs := []scheduler.Job{
+		{
+			Name:        JobHealthChecks,
+			Description: "Probes every controller and records its health.",
+			Interval:    s.HealthInterval,
+			Run:         s.runHealthChecks,
+		},
+		{
+			Name:        JobCleanup,
+			Description: "Removes unused tags and prunes old controller health and webhook deliveries.",
+			Interval:    s.CleanupInterval,
+			Run:         s.runCleanupJob,
+		},
+		{
+			Name:        JobStockRules,
+			Description: "Logs the parts matching a notification stock rule.",
+			Interval:    s.StockRuleInterval,
+			Run:         s.runStockRuleNotifications,
+		},
+		{
+			Name:        JobLightingSchedules,
+			Description: "Runs the lighting schedules that are due.",
+			Interval:    scheduleCheckInterval,
+			Run: func(ctx context.Context) error {
+				return s.runLightingSchedules(ctx, time.Now())
+			},
+		},
+		{
+			Name:        JobWebhooks,
+			Description: "Sends the webhook deliveries that are due, retrying failed ones.",
+			Interval:    s.WebhookInterval,
+			Run: func(ctx context.Context) error {
+				return s.runWebhookDeliveries(ctx, time.Now())
+			},
+		},
+	}
+	for _, j := range jobs {
+		if err := sched.Register(j); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+// ruleMatch is a notification rule that matches a part
+type ruleMatch struct {
+	partID, ruleID int
 }
 
-func (s *Service) runCleanupJob() {
-	log.Println("Running background tag cleanup...")
-	// FIX: Use s.store, not s.PartStore/DashStore
-	if err := s.store.CleanupOrphanedCategories(); err != nil {
-		log.Println("Background cleanup failed:", err)
+// ruleNames are the names of a rule match, for the log
+type ruleNames struct {
+	rule, part string
+}
+
+// runStockRuleNotifications evaluates the stock rules against each part's
+// total stock, and reports notification rules that started or stopped
+// matching a part since the last check. A new match is sent to the
+// stock.rule_matched webhooks.
+func (s *Service) runStockRuleNotifications(ctx context.Context) error {
+	bins, err := s.store.GetDashboardBinData(ctx, models.StockStatusFilter{})
+	if err != nil {
+		return fmt.Errorf("querying stock data: %w", err)
+	}
+	rules, err := s.store.GetStockRules(ctx)
+	if err != nil {
+		return fmt.Errorf("querying rules: %w", err)
+	}
+	evaluator := stockstatus.NewEvaluator(rules)
+
+	// Dashboard data is per bin, notifications are per part
+	seen := make(map[int]bool)
+	matched := make(map[ruleMatch]ruleNames)
+	for _, bin := range bins {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if seen[bin.PartID] {
+			continue
+		}
+		seen[bin.PartID] = true
+
+		facts := stockstatus.FactsFromBin(bin, bin.PartQuantity, bin.MinStock, bin.ReorderPoint)
+		for _, rule := range evaluator.EvaluateAll(facts) {
+			if rule.Action != stockstatus.ActionNotify {
+				continue
+			}
+			match := ruleMatch{partID: bin.PartID, ruleID: rule.ID}
+			if _, ok := s.notified[match]; !ok {
+				log.Printf("StockRules: %q matched part %q (qty %d): %s", rule.Name, bin.PartName, bin.PartQuantity, rule.Message)
+				data := models.RuleEventData{
+					RuleID: rule.ID, RuleName: rule.Name, Message: rule.Message,
+					PartID: bin.PartID, PartName: bin.PartName, TotalQuantity: bin.PartQuantity,
+				}
+				if err := s.store.EmitWebhookEvent(ctx, models.EventStockRuleMatched, data); err != nil {
+					// Left out of matched, so the next check tries again
+					log.Println("StockRules: Error queueing webhooks:", err)
+					continue
+				}
+			}
+			matched[match] = ruleNames{rule: rule.Name, part: bin.PartName}
+		}
 	}
-	log.Println("Background tag cleanup complete.")
+
+	for match, names := range s.notified {
+		if _, ok := matched[match]; !ok {
+			log.Printf("StockRules: %q no longer matches part %q", names.rule, names.part)
+		}
+	}
+	s.notified = matched
+	return nil
 }
 
-func (s *Service) runHealthChecks() {
-	log.Println("Running WLED health checks...")
+func (s *Service) runCleanupJob(ctx context.Context) error {
+	if err := s.store.CleanupOrphanedCategories(ctx); err != nil {
+		return fmt.Errorf("cleaning up tags: %w", err)
+	}
 
-	// FIX: Use s.store, not s.CtrlStore
-	controllers, err := s.store.GetAllControllersForHealthCheck()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	pruned, err := s.store.PruneHealthProbes(ctx, time.Now().Add(-s.HealthRetention))
 	if err != nil {
-		log.Println("HealthCheck: Error querying controllers:", err)
-		return
+		return fmt.Errorf("pruning probe history: %w", err)
+	}
+	if pruned > 0 {
+		log.Printf("HealthCheck: Pruned %d old probes.", pruned)
 	}
 
+	pruned, err = s.store.PruneWebhookDeliveries(ctx, time.Now().Add(-s.WebhookRetention))
+	if err != nil {
+		return fmt.Errorf("pruning webhook deliveries: %w", err)
+	}
+	if pruned > 0 {
+		log.Printf("Webhooks: Pruned %d old deliveries.", pruned)
+	}
+	return nil
+}
+
+// runHealthChecks probes every controller concurrently, updates its
+// status and records the probe in the health history
+func (s *Service) runHealthChecks(ctx context.Context) error {
+	controllers, err := s.store.GetAllControllersForHealthCheck(ctx)
+	if err != nil {
+		return fmt.Errorf("querying controllers: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, healthCheckWorkers)
 	for _, c := range controllers {
-		// FIX: Use s.wled, not s.Wled
-		online := s.wled.Ping(c.IPAddress)
-
-		var status string
-		var lastSeen sql.NullTime
-		if online {
-			status = "online"
-			lastSeen.Time = time.Now()
-			lastSeen.Valid = true
-		} else {
-			status = "offline"
-			lastSeen.Valid = false
+		// Probes already started finish, the rest are skipped. Checked
+		// first, a select would pick a free worker over ctx.Done at random.
+		if err := ctx.Err(); err != nil {
+			wg.Wait()
+			return err
 		}
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		case workers <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(c models.WLEDController) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			// A probe that was started is recorded, even during a shutdown
+			s.checkController(context.WithoutCancel(ctx), c)
+		}(c)
+	}
+	wg.Wait()
+	return nil
+}
+
+func (s *Service) checkController(ctx context.Context, c models.WLEDController) {
+	probe := &models.HealthProbe{ControllerID: c.ID, CheckedAt: time.Now()}
+	latency, err := s.wled.Probe(c.IPAddress)
+
+	var status string
+	var lastSeen sql.NullTime
+	if err == nil {
+		status = "online"
+		lastSeen.Time = probe.CheckedAt
+		lastSeen.Valid = true
+		probe.Online = true
+		probe.Latency = latency
+	} else {
+		status = "offline"
+		probe.Error = err.Error()
+	}
+
+	if err := s.store.UpdateControllerStatus(ctx, c.ID, status, lastSeen); err != nil {
+		log.Println("HealthCheck: Error updating controller status:", err)
+	}
+	if err := s.store.RecordHealthProbe(ctx, probe); err != nil {
+		log.Println("HealthCheck: Error recording probe:", err)
+	}
 
-		// FIX: Use s.store, not s.CtrlStore
-		if err := s.store.UpdateControllerStatus(c.ID, status, lastSeen); err != nil {
-			log.Println("HealthCheck: Error updating controller status:", err)
+	// Only going offline is an event, not every failed probe
+	if status == "offline" && c.Status != "offline" {
+		data := models.ControllerEventData{ControllerID: c.ID, Name: c.Name, Address: c.IPAddress, Error: probe.Error}
+		if err := s.store.EmitWebhookEvent(ctx, models.EventControllerOffline, data); err != nil {
+			log.Println("HealthCheck: Error queueing webhooks:", err)
 		}
 	}
-	log.Println("WLED health checks complete.")
 }
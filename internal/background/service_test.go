@@ -0,0 +1,101 @@
+package background
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"wledger/internal/models"
+	"wledger/internal/scheduler"
+)
+
+type mockJobStore struct{}
+
+func (m *mockJobStore) RecordJobRun(ctx context.Context, run *models.JobRun) error { return nil }
+
+func TestRegisterJobs(t *testing.T) {
+	s, _, _, _, _ := setupTest()
+	sched := scheduler.New(&mockJobStore{})
+
+	if err := s.RegisterJobs(t.Context(), sched); err != nil {
+		t.Fatalf("RegisterJobs failed: %v", err)
+	}
+	names := map[string]bool{}
+	for _, j := range sched.Jobs() {
+		names[j.Name] = true
+	}
+	for _, name := range []string{JobHealthChecks, JobCleanup, JobStockRules, JobLightingSchedules, JobWebhooks} {
+		if !names[name] {
+			t.Errorf("Expected job %s to be registered", name)
+		}
+	}
+}
+
+func TestRunHealthChecks_Cancelled(t *testing.T) {
+	s, ms, _, _, _ := setupTest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.runHealthChecks(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if len(ms.Probes) != 0 {
+		t.Errorf("Expected no probes after cancelling, got %d", len(ms.Probes))
+	}
+}
+
+func TestRunLightingSchedules_Cancelled(t *testing.T) {
+	s, ms, _, ml, _ := setupTest(
+		models.LightingSchedule{ID: 1, Name: "Morning", Cron: "0 8 * * 1-5", Action: ActionStockStatus, Enabled: true},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.runLightingSchedules(ctx, monday8)
+
+	if len(ml.Views) != 0 || len(ms.Runs) != 0 {
+		t.Errorf("Expected no schedules to run after cancelling, got %d views, %d runs", len(ml.Views), len(ms.Runs))
+	}
+}
+
+func TestRunStockRuleNotifications_OnlyChanges(t *testing.T) {
+	s, ms, _, _, _ := setupTest()
+	ms.StockRules = []models.StockRule{{
+		ID: 1, Name: "Reorder", Enabled: true, Action: "notify", Message: "Order more",
+		Conditions: []models.StockRuleCondition{{Field: "quantity", Operator: "lte", Value: "reorder_point"}},
+	}}
+	ms.BinData = []models.DashboardBinData{{PartID: 1, PartName: "R1", PartQuantity: 2, ReorderPoint: 5}}
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	run := func() string {
+		logged.Reset()
+		if err := s.runStockRuleNotifications(t.Context()); err != nil {
+			t.Fatalf("runStockRuleNotifications failed: %v", err)
+		}
+		return logged.String()
+	}
+
+	if out := run(); !strings.Contains(out, `"Reorder" matched part "R1"`) {
+		t.Errorf("Expected a notification, got %q", out)
+	}
+	if out := run(); out != "" {
+		t.Errorf("Expected no repeat while the rule still matches, got %q", out)
+	}
+	if len(ms.Events) != 1 || ms.Events[0] != models.EventStockRuleMatched {
+		t.Errorf("Expected one stock.rule_matched event, got %v", ms.Events)
+	}
+
+	ms.BinData[0].PartQuantity = 50
+	if out := run(); !strings.Contains(out, `"Reorder" no longer matches part "R1"`) {
+		t.Errorf("Expected the match to be cleared, got %q", out)
+	}
+	if out := run(); out != "" {
+		t.Errorf("Expected nothing once cleared, got %q", out)
+	}
+}
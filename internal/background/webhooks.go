@@ -0,0 +1,84 @@
+package background
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"wledger/internal/models"
+	"wledger/internal/webhook"
+)
+
+// webhookBatchSize is how many deliveries one run sends at most, the
+// rest wait for the next run
+const webhookBatchSize = 100
+
+// runWebhookDeliveries sends the deliveries that are due. A failed
+// delivery is tried again after a backoff, until it's out of attempts.
+// Once a webhook fails, its other deliveries wait for the next run, so a
+// receiver that's down isn't sent the whole queue.
+func (s *Service) runWebhookDeliveries(ctx context.Context, now time.Time) error {
+	deliveries, err := s.store.GetDueWebhookDeliveries(ctx, now, webhookBatchSize)
+	if err != nil {
+		return fmt.Errorf("querying deliveries: %w", err)
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	hooks, err := s.store.GetWebhooks(ctx)
+	if err != nil {
+		return fmt.Errorf("querying webhooks: %w", err)
+	}
+	byID := make(map[int]models.Webhook, len(hooks))
+	for _, w := range hooks {
+		byID[w.ID] = w
+	}
+
+	down := make(map[int]bool)
+	failed := 0
+	for _, d := range deliveries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		w, ok := byID[d.WebhookID]
+		if !ok || down[d.WebhookID] {
+			continue
+		}
+		if !s.deliver(ctx, w, &d, now) {
+			down[d.WebhookID] = true
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d webhook deliveries failed", failed)
+	}
+	return nil
+}
+
+// deliver makes one attempt to send d and records it. It reports whether
+// the delivery was accepted.
+func (s *Service) deliver(ctx context.Context, w models.Webhook, d *models.WebhookDelivery, now time.Time) bool {
+	code, err := s.webhooks.Send(ctx, w, *d)
+
+	d.Attempts++
+	d.LastAttemptAt = sql.NullTime{Time: now, Valid: true}
+	d.ResponseCode = code
+	switch {
+	case err == nil:
+		d.Status, d.Error = "ok", ""
+	case d.Attempts >= webhook.MaxAttempts:
+		d.Status, d.Error = "failed", err.Error()
+		log.Printf("Webhooks: Giving up on delivery %d of %s to %q: %v", d.ID, d.Event, w.Name, err)
+	default:
+		d.Error = err.Error()
+		d.NextAttemptAt = now.Add(webhook.Backoff(d.Attempts))
+	}
+
+	// An attempt that was made is recorded, even during a shutdown
+	if err := s.store.RecordWebhookAttempt(context.WithoutCancel(ctx), d); err != nil {
+		log.Println("Webhooks: Error recording delivery:", err)
+	}
+	return err == nil
+}
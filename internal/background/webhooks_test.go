@@ -0,0 +1,89 @@
+package background
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"wledger/internal/models"
+	"wledger/internal/webhook"
+)
+
+type mockSender struct {
+	Sent []int        // Delivery IDs
+	Down map[int]bool // Webhook IDs that fail
+}
+
+func (m *mockSender) Send(ctx context.Context, w models.Webhook, d models.WebhookDelivery) (int, error) {
+	m.Sent = append(m.Sent, d.ID)
+	if m.Down[w.ID] {
+		return 503, errors.New("webhook returned 503 Service Unavailable")
+	}
+	return 204, nil
+}
+
+func setupWebhookTest() (*Service, *mockStore, *mockSender) {
+	s, ms, _, _, _ := setupTest()
+	sender := &mockSender{Down: map[int]bool{}}
+	s.webhooks = sender
+	ms.Hooks = []models.Webhook{{ID: 1, Name: "Sheet"}, {ID: 2, Name: "Bot"}}
+	ms.Deliveries = []models.WebhookDelivery{
+		{ID: 1, WebhookID: 1, Event: models.EventStockChanged, Status: "pending", NextAttemptAt: monday8},
+		{ID: 2, WebhookID: 2, Event: models.EventStockChanged, Status: "pending", NextAttemptAt: monday8},
+		{ID: 3, WebhookID: 2, Event: models.EventStockLow, Status: "pending", NextAttemptAt: monday8},
+		{ID: 4, WebhookID: 1, Event: models.EventPartCreated, Status: "pending", NextAttemptAt: monday8.Add(time.Hour)},
+	}
+	return s, ms, sender
+}
+
+func TestRunWebhookDeliveries(t *testing.T) {
+	s, ms, sender := setupWebhookTest()
+
+	if err := s.runWebhookDeliveries(context.Background(), monday8); err != nil {
+		t.Fatalf("runWebhookDeliveries failed: %v", err)
+	}
+	if len(sender.Sent) != 3 {
+		t.Errorf("Expected the 3 due deliveries to be sent, got %v", sender.Sent)
+	}
+	for _, d := range ms.Deliveries[:3] {
+		if d.Status != "ok" || d.Attempts != 1 || d.ResponseCode != 204 || !d.LastAttemptAt.Valid {
+			t.Errorf("Unexpected delivery after sending: %+v", d)
+		}
+	}
+	if ms.Deliveries[3].Status != "pending" || ms.Deliveries[3].Attempts != 0 {
+		t.Errorf("Expected the delivery that isn't due to wait, got %+v", ms.Deliveries[3])
+	}
+}
+
+func TestRunWebhookDeliveries_Retries(t *testing.T) {
+	s, ms, sender := setupWebhookTest()
+	sender.Down[2] = true
+
+	if err := s.runWebhookDeliveries(context.Background(), monday8); err == nil {
+		t.Error("Expected an error for the failed delivery")
+	}
+	// The down webhook's second delivery waits for the next run
+	if len(sender.Sent) != 2 {
+		t.Errorf("Expected 2 deliveries to be sent, got %v", sender.Sent)
+	}
+	failed := ms.Deliveries[1]
+	if failed.Status != "pending" || failed.Attempts != 1 || failed.ResponseCode != 503 || failed.Error == "" {
+		t.Errorf("Unexpected failed delivery: %+v", failed)
+	}
+	if want := monday8.Add(webhook.Backoff(1)); !failed.NextAttemptAt.Equal(want) {
+		t.Errorf("Expected a retry at %v, got %v", want, failed.NextAttemptAt)
+	}
+	if ms.Deliveries[2].Attempts != 0 {
+		t.Errorf("Expected the skipped delivery to be untouched, got %+v", ms.Deliveries[2])
+	}
+
+	// Out of attempts
+	ms.Deliveries[1].Attempts = webhook.MaxAttempts - 1
+	ms.Deliveries[1].NextAttemptAt = monday8
+	ms.Deliveries[2].Status = "ok"
+	s.runWebhookDeliveries(context.Background(), monday8)
+	if d := ms.Deliveries[1]; d.Status != "failed" || d.Attempts != webhook.MaxAttempts {
+		t.Errorf("Expected the delivery to fail for good, got %+v", d)
+	}
+}
@@ -0,0 +1,368 @@
+// Package config loads the server's runtime configuration. Each setting
+// starts at its default and is overridden, in order, by the config file,
+// the WLEDGER_* environment variables and the command line flags.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"wledger/internal/background"
+)
+
+// DefaultFile is read if it exists and no other config file is given
+const DefaultFile = "wledger.json"
+
+// DefaultShutdownTimeout fits within the 10 seconds docker stop waits
+// before killing the server
+const DefaultShutdownTimeout = 8 * time.Second
+
+// DefaultRequestTimeout leaves room for a restore of a large backup
+const DefaultRequestTimeout = 30 * time.Second
+
+// DefaultSessionLifetime signs users out after a month
+const DefaultSessionLifetime = 30 * 24 * time.Hour
+
+// Where a setting's value came from
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// Config is the effective runtime configuration
+type Config struct {
+	Addr    string // Address the HTTP server listens on
+	DataDir string // Holds the database and the uploads
+	Dev     bool   // Serve the UI from UIDir and reload it on change
+	UIDir   string // Holds the templates and static files in dev mode
+
+	DatabaseURL string // PostgreSQL database to use instead of the SQLite file in DataDir
+
+	HealthInterval    time.Duration // How often controllers are probed
+	HealthRetention   time.Duration // How long the probe history is kept
+	CleanupInterval   time.Duration // How often unused categories are removed
+	StockRuleInterval time.Duration // How often stock rule notifications are checked
+	WebhookInterval   time.Duration // How often due webhook deliveries are sent
+	WebhookRetention  time.Duration // How long finished webhook deliveries are kept
+	ShutdownTimeout   time.Duration // How long requests and jobs get to finish on shutdown
+	RequestTimeout    time.Duration // How long a request's database work may take
+	SessionLifetime   time.Duration // How long a user stays signed in
+
+	File    string            // Config file that was loaded, if any
+	sources map[string]string // Setting name to where its value came from
+}
+
+// Entry is one setting of the effective configuration
+type Entry struct {
+	Name   string // Config file key
+	Env    string
+	Flag   string
+	Value  string
+	Source string
+}
+
+// setting describes one setting. Its name is the config file key, the
+// flag is the name with dashes and the environment variable is the name
+// upper-cased and prefixed with WLEDGER_.
+type setting struct {
+	name    string
+	usage   string
+	boolean bool // Its flag can be given without a value
+	get     func(c *Config) string
+	set     func(c *Config, value string) error
+}
+
+var settings = []setting{
+	stringSetting("addr", "address to listen on", func(c *Config) *string { return &c.Addr }),
+	stringSetting("data_dir", "directory for the database and uploads", func(c *Config) *string { return &c.DataDir }),
+	boolSetting("dev", "serve the UI from ui_dir and reload it on change", func(c *Config) *bool { return &c.Dev }),
+	stringSetting("ui_dir", "directory with the templates and static files in dev mode", func(c *Config) *string { return &c.UIDir }),
+	dsnSetting("database_url", "PostgreSQL database to use instead of the SQLite file in data_dir", func(c *Config) *string { return &c.DatabaseURL }),
+	durationSetting("health_interval", "how often controllers are probed", func(c *Config) *time.Duration { return &c.HealthInterval }),
+	durationSetting("health_retention", "how long controller health is kept", func(c *Config) *time.Duration { return &c.HealthRetention }),
+	durationSetting("cleanup_interval", "how often unused categories are removed", func(c *Config) *time.Duration { return &c.CleanupInterval }),
+	durationSetting("stock_rule_interval", "how often stock rule notifications are checked", func(c *Config) *time.Duration { return &c.StockRuleInterval }),
+	durationSetting("webhook_interval", "how often due webhook deliveries are sent", func(c *Config) *time.Duration { return &c.WebhookInterval }),
+	durationSetting("webhook_retention", "how long finished webhook deliveries are kept", func(c *Config) *time.Duration { return &c.WebhookRetention }),
+	durationSetting("shutdown_timeout", "how long requests and jobs get to finish on shutdown", func(c *Config) *time.Duration { return &c.ShutdownTimeout }),
+	durationSetting("request_timeout", "how long a request's database work may take before it's cancelled", func(c *Config) *time.Duration { return &c.RequestTimeout }),
+	durationSetting("session_lifetime", "how long a user stays signed in", func(c *Config) *time.Duration { return &c.SessionLifetime }),
+}
+
+func stringSetting(name, usage string, field func(c *Config) *string) setting {
+	return setting{
+		name:  name,
+		usage: usage,
+		get:   func(c *Config) string { return *field(c) },
+		set:   func(c *Config, v string) error { *field(c) = v; return nil },
+	}
+}
+
+// dsnSetting is a database connection string. Its password isn't shown.
+func dsnSetting(name, usage string, field func(c *Config) *string) setting {
+	s := stringSetting(name, usage, field)
+	s.get = func(c *Config) string { return redactDSN(*field(c)) }
+	return s
+}
+
+// dsnPassword matches the password of a key=value connection string
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// redactDSN hides the password of a URL or key=value connection string
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+		}
+		if q := u.Query(); q.Has("password") {
+			q.Set("password", "xxxxx")
+			u.RawQuery = q.Encode()
+		}
+		return u.String()
+	}
+	return dsnPassword.ReplaceAllString(dsn, "${1}xxxxx")
+}
+
+// boolSetting is a setting written as "true" or "false"
+func boolSetting(name, usage string, field func(c *Config) *bool) setting {
+	return setting{
+		name:    name,
+		usage:   usage,
+		boolean: true,
+		get:     func(c *Config) string { return strconv.FormatBool(*field(c)) },
+		set: func(c *Config, v string) error {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return err
+			}
+			*field(c) = b
+			return nil
+		},
+	}
+}
+
+// durationSetting is a setting written as "30s" or "720h"
+func durationSetting(name, usage string, field func(c *Config) *time.Duration) setting {
+	return setting{
+		name:  name,
+		usage: usage,
+		get:   func(c *Config) string { return field(c).String() },
+		set: func(c *Config, v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return err
+			}
+			*field(c) = d
+			return nil
+		},
+	}
+}
+
+// flagValue holds a flag's raw value until it's applied
+type flagValue struct {
+	value   string
+	boolean bool
+}
+
+func (f *flagValue) String() string     { return f.value }
+func (f *flagValue) Set(v string) error { f.value = v; return nil }
+func (f *flagValue) IsBoolFlag() bool   { return f.boolean }
+
+func (s setting) flagName() string { return strings.ReplaceAll(s.name, "_", "-") }
+func (s setting) envName() string  { return "WLEDGER_" + strings.ToUpper(s.name) }
+
+// Default returns the configuration used when nothing is overridden
+func Default() *Config {
+	c := &Config{
+		Addr:              ":3000",
+		DataDir:           "./data",
+		UIDir:             "./ui",
+		HealthInterval:    background.DefaultHealthInterval,
+		HealthRetention:   background.DefaultHealthRetention,
+		CleanupInterval:   background.DefaultCleanupInterval,
+		StockRuleInterval: background.DefaultStockRuleInterval,
+		WebhookInterval:   background.DefaultWebhookInterval,
+		WebhookRetention:  background.DefaultWebhookRetention,
+		ShutdownTimeout:   DefaultShutdownTimeout,
+		RequestTimeout:    DefaultRequestTimeout,
+		SessionLifetime:   DefaultSessionLifetime,
+		sources:           map[string]string{},
+	}
+	for _, s := range settings {
+		c.sources[s.name] = SourceDefault
+	}
+	return c
+}
+
+// Load builds the configuration from the defaults, the config file, the
+// environment and the command line arguments, then validates it.
+// The config file is given by -config or WLEDGER_CONFIG.
+func Load(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	c := Default()
+
+	fs := flag.NewFlagSet("wledger", flag.ContinueOnError)
+	file := fs.String("config", "", "config file (default "+DefaultFile+" if it exists, env WLEDGER_CONFIG)")
+	flags := map[string]*flagValue{}
+	for _, s := range settings {
+		flags[s.name] = &flagValue{boolean: s.boolean}
+		fs.Var(flags[s.name], s.flagName(), fmt.Sprintf("%s (default %s, env %s)", s.usage, s.get(c), s.envName()))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// Only an explicitly named config file has to exist
+	path, required := *file, *file != ""
+	if v, ok := lookupEnv("WLEDGER_CONFIG"); ok && v != "" && !required {
+		path, required = v, true
+	}
+	if !required {
+		path = DefaultFile
+	}
+	if err := c.loadFile(path, required); err != nil {
+		return nil, err
+	}
+
+	for _, s := range settings {
+		if v, ok := lookupEnv(s.envName()); ok && v != "" {
+			if err := c.apply(s, v, SourceEnv); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if f.Name == s.flagName() && err == nil {
+				err = c.apply(s, flags[s.name].value, SourceFlag)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// loadFile applies a JSON config file of setting names to string values
+func (c *Config) loadFile(path string, required bool) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	if err := json.NewDecoder(f).Decode(&values); err != nil && err != io.EOF {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	for name, v := range values {
+		s, ok := lookup(name)
+		if !ok {
+			return fmt.Errorf("config file %s: unknown setting %q", path, name)
+		}
+		if err := c.apply(s, v, SourceFile); err != nil {
+			return fmt.Errorf("config file %s: %w", path, err)
+		}
+	}
+	c.File = path
+	return nil
+}
+
+func lookup(name string) (setting, bool) {
+	for _, s := range settings {
+		if s.name == name {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+func (c *Config) apply(s setting, value, source string) error {
+	if err := s.set(c, value); err != nil {
+		return fmt.Errorf("invalid %s %q from %s: %w", s.name, value, source, err)
+	}
+	c.sources[s.name] = source
+	return nil
+}
+
+// Validate checks the settings make sense together
+func (c *Config) Validate() error {
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		return fmt.Errorf("invalid addr %q: %w", c.Addr, err)
+	}
+	if c.DataDir == "" {
+		return errors.New("data_dir must not be empty")
+	}
+	if c.Dev {
+		if info, err := os.Stat(c.UIDir); err != nil || !info.IsDir() {
+			return fmt.Errorf("ui_dir %q is not a directory", c.UIDir)
+		}
+	}
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"health_interval", c.HealthInterval},
+		{"health_retention", c.HealthRetention},
+		{"cleanup_interval", c.CleanupInterval},
+		{"stock_rule_interval", c.StockRuleInterval},
+		{"webhook_interval", c.WebhookInterval},
+		{"webhook_retention", c.WebhookRetention},
+		{"shutdown_timeout", c.ShutdownTimeout},
+		{"request_timeout", c.RequestTimeout},
+		{"session_lifetime", c.SessionLifetime},
+	}
+	for _, d := range durations {
+		if d.value <= 0 {
+			return fmt.Errorf("%s must be positive, got %s", d.name, d.value)
+		}
+	}
+	if c.HealthRetention < c.HealthInterval {
+		return fmt.Errorf("health_retention (%s) must be at least health_interval (%s)", c.HealthRetention, c.HealthInterval)
+	}
+	return nil
+}
+
+// DatabasePath is the SQLite database in the data directory, used unless
+// DatabaseURL is set
+func (c *Config) DatabasePath() string { return filepath.Join(c.DataDir, "inventory.db") }
+
+// UploadDir holds the uploaded images and documents
+func (c *Config) UploadDir() string { return filepath.Join(c.DataDir, "uploads") }
+
+// Entries lists the effective settings and where each came from
+func (c *Config) Entries() []Entry {
+	entries := make([]Entry, 0, len(settings))
+	for _, s := range settings {
+		entries = append(entries, Entry{
+			Name:   s.name,
+			Env:    s.envName(),
+			Flag:   "-" + s.flagName(),
+			Value:  s.get(c),
+			Source: c.sources[s.name],
+		})
+	}
+	return entries
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"wledger/internal/models"
+)
+
+// LoginPath is where Require sends anyone who isn't signed in
+const LoginPath = "/login"
+
+// userKey holds the signed in user in a request's context
+type userKey struct{}
+
+// tokenKey holds the API token a request was made with
+type tokenKey struct{}
+
+// WithUser returns a copy of ctx for a request the user is signed in to
+func WithUser(ctx context.Context, u models.User) context.Context {
+	return context.WithValue(ctx, userKey{}, u)
+}
+
+// CurrentUser returns the user signed in to the request, if any
+func CurrentUser(r *http.Request) (models.User, bool) {
+	u, ok := r.Context().Value(userKey{}).(models.User)
+	return u, ok
+}
+
+// WithToken returns a copy of ctx for a request made with an API token.
+// Its user is signed in with WithUser as well.
+func WithToken(ctx context.Context, t models.APIToken) context.Context {
+	return context.WithValue(ctx, tokenKey{}, t)
+}
+
+// CurrentToken returns the API token the request was made with, if any
+func CurrentToken(r *http.Request) (models.APIToken, bool) {
+	t, ok := r.Context().Value(tokenKey{}).(models.APIToken)
+	return t, ok
+}
+
+// ValidRole reports whether role is one of models.Roles
+func ValidRole(role models.Role) bool {
+	return slices.Contains(models.Roles, role)
+}
+
+// RoleAllows reports whether a user with the role can do what need can.
+// Each role can do everything the roles before it in models.Roles can.
+func RoleAllows(role, need models.Role) bool {
+	return ValidRole(role) && slices.Index(models.Roles, role) >= slices.Index(models.Roles, need)
+}
+
+// ScopeRole is the role a user needs to give a token the scope
+func ScopeRole(s models.Scope) models.Role {
+	switch s {
+	case models.ScopeStockWrite:
+		return models.RoleEditor
+	case models.ScopeAdmin:
+		return models.RoleAdmin
+	}
+	return models.RoleViewer
+}
+
+// RequiredScope is the scope a token needs for a request to a route that
+// requires the role. What viewers can do is split in two: reading, and
+// everything else they can do, which is lighting bins.
+func RequiredScope(role models.Role, method string) models.Scope {
+	switch role {
+	case models.RoleAdmin:
+		return models.ScopeAdmin
+	case models.RoleEditor:
+		return models.ScopeStockWrite
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		return models.ScopeRead
+	}
+	return models.ScopeLocate
+}
+
+// Require is middleware letting through users with at least the given
+// role. Anyone not signed in is sent to the login page, and comes back
+// afterwards if it was a page they were loading. JSON API requests get a
+// 401 instead. A request made with an
+// API token also needs the token to have the RequiredScope.
+func Require(role models.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, ok := CurrentUser(r)
+			token, isToken := CurrentToken(r)
+			scope := RequiredScope(role, r.Method)
+			switch {
+			case !ok && IsAPIRequest(r):
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				ClientError(w, r, http.StatusUnauthorized, "Please sign in or send an API token", nil)
+			case !ok && r.Header.Get("HX-Request") != "":
+				// htmx swaps nothing on a 401, but follows HX-Redirect
+				w.Header().Set("HX-Redirect", LoginPath)
+				ClientError(w, r, http.StatusUnauthorized, "Please sign in", nil)
+			case !ok && r.Method == http.MethodGet:
+				http.Redirect(w, r, LoginPath+"?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			case !ok:
+				ClientError(w, r, http.StatusUnauthorized, "Please sign in", nil)
+			case !RoleAllows(u.Role, role):
+				ClientError(w, r, http.StatusForbidden, "This needs the "+string(role)+" role", nil)
+			case isToken && !slices.Contains(token.Scopes, scope):
+				ClientError(w, r, http.StatusForbidden, "This token needs the "+string(scope)+" scope", nil)
+			default:
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"wledger/internal/models"
+)
+
+func TestRequire(t *testing.T) {
+	handler := Require(models.RoleEditor)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	signedIn := func(method string, role models.Role) *http.Request {
+		req := httptest.NewRequest(method, "/parts", nil)
+		return req.WithContext(WithUser(req.Context(), models.User{Username: "alice", Role: role}))
+	}
+
+	// Pages redirect to the login page, and back again
+	rr := serve(httptest.NewRequest("GET", "/part/3?tab=stock", nil))
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/login?next=%2Fpart%2F3%3Ftab%3Dstock" {
+		t.Errorf("Expected a redirect to the login page, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+
+	req := httptest.NewRequest("DELETE", "/parts/3", nil)
+	req.Header.Set("HX-Request", "true")
+	rr = serve(req)
+	if rr.Code != http.StatusUnauthorized || rr.Header().Get("HX-Redirect") != LoginPath {
+		t.Errorf("Expected htmx to be sent to the login page, got %d %v", rr.Code, rr.Header())
+	}
+	if rr := serve(httptest.NewRequest("POST", "/parts", nil)); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Not signed in: got status %d, want 401", rr.Code)
+	}
+
+	// The JSON API asks for a token instead of redirecting
+	rr = serve(httptest.NewRequest("GET", "/api/v1/parts", nil))
+	if rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") != "Bearer" || rr.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected a JSON 401, got %d %v", rr.Code, rr.Header())
+	}
+
+	if rr := serve(signedIn("POST", models.RoleViewer)); rr.Code != http.StatusForbidden {
+		t.Errorf("Viewer: got status %d, want 403", rr.Code)
+	}
+	if rr := serve(signedIn("POST", models.Role("root"))); rr.Code != http.StatusForbidden {
+		t.Errorf("Unknown role: got status %d, want 403", rr.Code)
+	}
+	for _, role := range []models.Role{models.RoleEditor, models.RoleAdmin} {
+		if rr := serve(signedIn("POST", role)); rr.Code != http.StatusNoContent {
+			t.Errorf("%s: got status %d, want 204", role, rr.Code)
+		}
+	}
+}
+
+func TestRequire_Token(t *testing.T) {
+	serve := func(role models.Role, method string, scopes ...models.Scope) int {
+		handler := Require(role)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		req := httptest.NewRequest(method, "/", nil)
+		ctx := WithUser(req.Context(), models.User{Username: "alice", Role: models.RoleEditor})
+		ctx = WithToken(ctx, models.APIToken{Name: "script", Scopes: scopes})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req.WithContext(ctx))
+		return rr.Code
+	}
+
+	tests := []struct {
+		name   string
+		role   models.Role
+		method string
+		scopes []models.Scope
+		want   int
+	}{
+		{"Read", models.RoleViewer, "GET", []models.Scope{models.ScopeRead}, http.StatusNoContent},
+		{"Locate", models.RoleViewer, "POST", []models.Scope{models.ScopeLocate}, http.StatusNoContent},
+		{"Locate Without Scope", models.RoleViewer, "POST", []models.Scope{models.ScopeRead}, http.StatusForbidden},
+		{"Read Without Scope", models.RoleViewer, "GET", []models.Scope{models.ScopeLocate}, http.StatusForbidden},
+		{"Stock Write", models.RoleEditor, "PUT", []models.Scope{models.ScopeStockWrite}, http.StatusNoContent},
+		{"Stock Read Needs Stock Write", models.RoleEditor, "GET", []models.Scope{models.ScopeRead}, http.StatusForbidden},
+		// The scope doesn't lift the user's role
+		{"Admin Beyond Role", models.RoleAdmin, "POST", []models.Scope{models.ScopeAdmin}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serve(tt.role, tt.method, tt.scopes...); got != tt.want {
+				t.Errorf("got status %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoleAllows(t *testing.T) {
+	if !RoleAllows(models.RoleAdmin, models.RoleViewer) || !RoleAllows(models.RoleEditor, models.RoleEditor) {
+		t.Error("Expected a role to allow itself and the roles below it")
+	}
+	if RoleAllows(models.RoleViewer, models.RoleEditor) || RoleAllows("", models.RoleViewer) {
+		t.Error("Expected a role not to allow the roles above it")
+	}
+}
@@ -0,0 +1,80 @@
+package core
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// CSRFHeader carries the CSRF token on htmx requests, see _header.html
+const CSRFHeader = "X-CSRF-Token"
+
+// CSRFField carries the CSRF token in plain forms. Multipart forms send
+// it in their action's query string, so uploads aren't read before the
+// handler limits their size.
+const CSRFField = "csrf_token"
+
+// csrfKey holds the request's CSRF token in its context
+type csrfKey struct{}
+
+// WithCSRFToken returns a copy of ctx for a request whose browser was
+// given the token
+func WithCSRFToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, csrfKey{}, token)
+}
+
+// CSRFToken returns the token the request's pages must send back with
+// anything that changes something. Pages put it in their data as
+// "CSRFToken".
+func CSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfKey{}).(string)
+	return token
+}
+
+// VerifyCSRF is middleware rejecting POST, PUT, PATCH and DELETE requests
+// that don't send back the request's CSRF token, so other sites can't
+// make a signed in browser change anything. Requests made with an API
+// token don't need one, browsers don't send those by themselves.
+func VerifyCSRF(templates TemplateExecutor) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				next.ServeHTTP(w, r)
+				return
+			}
+			if _, ok := CurrentToken(r); ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			want := CSRFToken(r)
+			got := r.Header.Get(CSRFHeader)
+			if got == "" {
+				// Only reads url-encoded bodies and the query string
+				r.ParseForm()
+				got = r.Form.Get(CSRFField)
+			}
+			if want != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if IsAPIRequest(r) {
+				ClientError(w, r, http.StatusForbidden, "CSRF token missing or wrong, send an API token instead of the session cookie", errors.New("CSRF token missing or wrong"))
+				return
+			}
+			log.Printf("Client Error: %s %s: CSRF token missing or wrong", r.Method, r.URL.Path)
+			if r.Header.Get("HX-Request") != "" {
+				// Shown above the page, _header.html lets htmx swap it in
+				w.Header().Set("HX-Retarget", "main")
+				w.Header().Set("HX-Reswap", "afterbegin")
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusForbidden)
+			templates.ExecuteTemplate(w, "_csrf-error.html", nil)
+		})
+	}
+}
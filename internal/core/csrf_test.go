@@ -0,0 +1,87 @@
+package core
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"wledger/internal/models"
+)
+
+func TestVerifyCSRF(t *testing.T) {
+	tmpl := template.Must(template.New("_csrf-error.html").Parse("security token"))
+	handler := VerifyCSRF(tmpl)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	request := func(method, target, body string) *http.Request {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		return req.WithContext(WithCSRFToken(req.Context(), "s3cret"))
+	}
+
+	tests := []struct {
+		name   string
+		req    func() *http.Request
+		status int
+	}{
+		{"Reading", func() *http.Request { return request("GET", "/settings", "") }, http.StatusNoContent},
+		{"No token", func() *http.Request { return request("POST", "/settings/zones", "name=Lab") }, http.StatusForbidden},
+		{"Wrong token", func() *http.Request {
+			return request("DELETE", "/settings/zones/1", "csrf_token=guess")
+		}, http.StatusForbidden},
+		{"Form field", func() *http.Request {
+			return request("POST", "/settings/zones", "name=Lab&csrf_token=s3cret")
+		}, http.StatusNoContent},
+		{"htmx header", func() *http.Request {
+			req := request("PUT", "/settings/zones/1", "name=Lab")
+			req.Header.Set(CSRFHeader, "s3cret")
+			return req
+		}, http.StatusNoContent},
+		{"Upload query string", func() *http.Request {
+			req := request("POST", "/settings/backup/restore?csrf_token=s3cret", "")
+			req.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+			return req
+		}, http.StatusNoContent},
+		{"Browser without a token", func() *http.Request {
+			return httptest.NewRequest("POST", "/settings/zones", strings.NewReader("csrf_token="))
+		}, http.StatusForbidden},
+		{"API token", func() *http.Request {
+			req := httptest.NewRequest("POST", "/locate/part/3", nil)
+			ctx := WithToken(req.Context(), models.APIToken{Scopes: []models.Scope{models.ScopeLocate}})
+			return req.WithContext(ctx)
+		}, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, tt.req())
+			if rr.Code != tt.status {
+				t.Errorf("got status %d, want %d", rr.Code, tt.status)
+			}
+			if rr.Code == http.StatusForbidden && !strings.Contains(rr.Body.String(), "security token") {
+				t.Errorf("Expected the error fragment, got %q", rr.Body.String())
+			}
+		})
+	}
+
+	// The JSON API gets a JSON error
+	req := request("PATCH", "/api/v1/parts/3", "")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), `"status":403`) {
+		t.Errorf("Expected a JSON 403, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	// htmx shows the error above the page
+	req = request("POST", "/parts", "")
+	req.Header.Set("HX-Request", "true")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Header().Get("HX-Retarget") != "main" || rr.Header().Get("HX-Reswap") != "afterbegin" {
+		t.Errorf("Expected the error to be retargeted, got %v", rr.Header())
+	}
+}
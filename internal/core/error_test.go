@@ -1,7 +1,10 @@
 package core
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -37,3 +40,44 @@ func TestClientError(t *testing.T) {
 		t.Errorf("body mismatch")
 	}
 }
+
+func TestServerError_Timeout(t *testing.T) {
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+
+	ServerError(rr, req, fmt.Errorf("querying parts: %w", context.DeadlineExceeded))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("got %d, want 503", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "timed out") {
+		t.Errorf("body mismatch")
+	}
+}
+
+func TestClientError_API(t *testing.T) {
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/v1/parts/3", nil)
+
+	ClientError(rr, req, http.StatusNotFound, "Part not found", nil)
+
+	if rr.Code != http.StatusNotFound || rr.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("got %d %q, want a JSON 404", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	var body APIError
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Invalid JSON %q: %v", rr.Body.String(), err)
+	}
+	if body.Error.Status != http.StatusNotFound || body.Error.Message != "Part not found" {
+		t.Errorf("Unexpected error %+v", body)
+	}
+
+	// The dashboard's htmx fragments under the API prefix get plain text
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/api/v1/stop-all", nil)
+	req.Header.Set("HX-Request", "true")
+	ServerError(rr, req, errors.New("controller went boom"))
+	if strings.HasPrefix(rr.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("Expected plain text for htmx, got %q", rr.Body.String())
+	}
+}
@@ -1,20 +1,68 @@
 package core
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strings"
 )
 
-// ServerError logs the error and sends a 500 Internal Server Error
+// APIPrefix is where the JSON API lives, see the api package
+const APIPrefix = "/api/v1/"
+
+// IsAPIRequest reports whether a request is for the JSON API, so its
+// errors are sent as JSON. htmx requests under APIPrefix are for the
+// dashboard's HTML fragments.
+func IsAPIRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, APIPrefix) && r.Header.Get("HX-Request") == ""
+}
+
+// APIError is the body of every JSON API error
+type APIError struct {
+	Error struct {
+		Status  int    `json:"status"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// writeError sends the message as plain text, or as an APIError to the JSON API
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if !IsAPIRequest(r) {
+		http.Error(w, message, status)
+		return
+	}
+	var body APIError
+	body.Error.Status, body.Error.Message = status, message
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// ServerError logs the error and sends a 500 Internal Server Error.
+// A request that ran past its deadline gets a 503 Service Unavailable
+// instead, and one the browser abandoned gets nothing.
 func ServerError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		log.Printf("Request Timed Out: %s %s: %s", r.Method, r.URL.Path, err.Error())
+		writeError(w, r, http.StatusServiceUnavailable, "Request timed out")
+		return
+	case errors.Is(err, context.Canceled):
+		log.Printf("Request Cancelled: %s %s", r.Method, r.URL.Path)
+		return
+	}
 	log.Printf("Internal Server Error: %s %s: %s", r.Method, r.URL.Path, err.Error())
-	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	writeError(w, r, http.StatusInternalServerError, "Internal Server Error")
 }
 
 // ClientError logs the error and sends a specific client-side error status
+// with the message
 func ClientError(w http.ResponseWriter, r *http.Request, status int, message string, err error) {
 	if err != nil {
 		log.Printf("Client Error: %s %s: %s", r.Method, r.URL.Path, err.Error())
 	}
-	http.Error(w, message, status)
+	writeError(w, r, status, message)
 }
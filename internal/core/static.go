@@ -0,0 +1,54 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// staticCacheControl lets browsers use a static file for a day before
+// checking it's still current
+const staticCacheControl = "public, max-age=86400"
+
+// StaticHandler serves the static files with cache headers. Files are
+// cached for a day and revalidated by their content hash, so an upgrade
+// is picked up by tomorrow at the latest. In dev mode they're always
+// revalidated, by modification time.
+func StaticHandler(fsys fs.FS, dev bool) (http.Handler, error) {
+	files := http.FileServer(http.FS(fsys))
+	if dev {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "no-cache")
+			files.ServeHTTP(w, r)
+		}), nil
+	}
+
+	// Embedded files have no modification time, so hash them once instead
+	etags := map[string]string{}
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(content)
+		etags[path] = `"` + hex.EncodeToString(sum[:8]) + `"`
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// http.FileServer answers If-None-Match from the ETag header
+		if etag, ok := etags[strings.TrimPrefix(r.URL.Path, "/")]; ok {
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Cache-Control", staticCacheControl)
+		}
+		files.ServeHTTP(w, r)
+	}), nil
+}
@@ -0,0 +1,39 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestStaticHandler(t *testing.T) {
+	fsys := fstest.MapFS{"style.css": {Data: []byte("body {}")}}
+
+	h, err := StaticHandler(fsys, false)
+	if err != nil {
+		t.Fatalf("StaticHandler failed: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/style.css", nil))
+	etag := rr.Header().Get("ETag")
+	if rr.Code != http.StatusOK || etag == "" || rr.Header().Get("Cache-Control") != staticCacheControl {
+		t.Fatalf("Expected a cacheable file, got %d %v", rr.Code, rr.Header())
+	}
+
+	// Revalidating an unchanged file
+	req := httptest.NewRequest("GET", "/style.css", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("got status %d, want %d", rr.Code, http.StatusNotModified)
+	}
+
+	dev, _ := StaticHandler(fsys, true)
+	rr = httptest.NewRecorder()
+	dev.ServeHTTP(rr, httptest.NewRequest("GET", "/style.css", nil))
+	if rr.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("Expected no caching in dev mode, got %v", rr.Header())
+	}
+}
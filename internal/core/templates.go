@@ -1,10 +1,96 @@
 package core
 
 import (
+	"fmt"
+	"html/template"
 	"io"
+	"io/fs"
+	"log"
+	"sync"
+	"time"
 )
 
 // TemplateExecutor interface for executing templates
 type TemplateExecutor interface {
 	ExecuteTemplate(wr io.Writer, name string, data any) error
 }
+
+// LoadTemplates parses the templates matching pattern. If live is set,
+// they're parsed again whenever one of them changes, for development.
+func LoadTemplates(fsys fs.FS, pattern string, live bool) (TemplateExecutor, error) {
+	if !live {
+		return template.ParseFS(fsys, pattern)
+	}
+	t := &liveTemplates{fsys: fsys, pattern: pattern}
+	if _, err := t.current(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// liveTemplates re-parses the templates when a file is added, removed or changed
+type liveTemplates struct {
+	fsys    fs.FS
+	pattern string
+
+	mu        sync.Mutex
+	templates *template.Template
+	version   string // File count and latest modification time when parsed
+}
+
+func (t *liveTemplates) ExecuteTemplate(wr io.Writer, name string, data any) error {
+	templates, err := t.current()
+	if err != nil {
+		return err
+	}
+	return templates.ExecuteTemplate(wr, name, data)
+}
+
+// current returns the templates, parsing them again if they've changed
+func (t *liveTemplates) current() (*template.Template, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	version, err := t.fileVersion()
+	if err != nil {
+		return nil, err
+	}
+	if t.templates != nil && version == t.version {
+		return t.templates, nil
+	}
+
+	templates, err := template.ParseFS(t.fsys, t.pattern)
+	if err != nil {
+		// Keep serving the last good templates until the file is fixed
+		if t.templates != nil {
+			log.Printf("Failed to reload templates: %v", err)
+			t.version = version
+			return t.templates, nil
+		}
+		return nil, err
+	}
+	if t.templates != nil {
+		log.Println("Templates changed, reloaded them.")
+	}
+	t.templates, t.version = templates, version
+	return templates, nil
+}
+
+// fileVersion summarizes the template files, so any change alters it
+func (t *liveTemplates) fileVersion() (string, error) {
+	names, err := fs.Glob(t.fsys, t.pattern)
+	if err != nil {
+		return "", err
+	}
+	var latest time.Time
+	for _, name := range names {
+		info, err := fs.Stat(t.fsys, name)
+		if err != nil {
+			return "", err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return fmt.Sprintf("%d files, %s", len(names), latest.Format(time.RFC3339Nano)), nil
+}
@@ -0,0 +1,45 @@
+package core
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestLoadTemplates_Live(t *testing.T) {
+	start := time.Now()
+	fsys := fstest.MapFS{
+		"templates/page.html": {Data: []byte(`{{ define "page.html" }}v1{{ end }}`), ModTime: start},
+	}
+	render := func(tmpl TemplateExecutor) string {
+		var sb strings.Builder
+		if err := tmpl.ExecuteTemplate(&sb, "page.html", nil); err != nil {
+			t.Fatalf("ExecuteTemplate failed: %v", err)
+		}
+		return sb.String()
+	}
+
+	fixed, err := LoadTemplates(fsys, "templates/*.html", false)
+	if err != nil {
+		t.Fatalf("LoadTemplates failed: %v", err)
+	}
+	live, err := LoadTemplates(fsys, "templates/*.html", true)
+	if err != nil {
+		t.Fatalf("LoadTemplates (live) failed: %v", err)
+	}
+
+	fsys["templates/page.html"] = &fstest.MapFile{Data: []byte(`{{ define "page.html" }}v2{{ end }}`), ModTime: start.Add(time.Second)}
+	if got := render(fixed); got != "v1" {
+		t.Errorf("Templates shouldn't reload outside dev mode, got %q", got)
+	}
+	if got := render(live); got != "v2" {
+		t.Errorf("Expected the changed template, got %q", got)
+	}
+
+	// A broken edit keeps the last good templates
+	fsys["templates/page.html"] = &fstest.MapFile{Data: []byte(`{{ define "page.html" }}`), ModTime: start.Add(2 * time.Second)}
+	if got := render(live); got != "v2" {
+		t.Errorf("Expected the last good template, got %q", got)
+	}
+}
@@ -0,0 +1,20 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// RequestTimeout gives each request a deadline. The store's queries use
+// the request's context, so they're cancelled once it passes, or as soon
+// as the browser goes away.
+func RequestTimeout(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
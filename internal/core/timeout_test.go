@@ -0,0 +1,25 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	var deadline time.Time
+	var ok bool
+	handler := RequestTimeout(time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, ok = r.Context().Deadline()
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if !ok {
+		t.Fatal("request has no deadline")
+	}
+	if left := time.Until(deadline); left <= 0 || left > time.Minute {
+		t.Errorf("deadline in %s, want within a minute", left)
+	}
+}
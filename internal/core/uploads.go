@@ -0,0 +1,45 @@
+package core
+
+import (
+	"io"
+	"os"
+)
+
+// Upload is an uploaded file saved under a temporary name in its directory.
+// Nothing serves it until Keep gives it its name.
+type Upload struct {
+	tmpPath string
+	absPath string
+}
+
+// SaveUpload writes an uploaded file to a temporary file in absDir, creating
+// the directory. Save the row pointing at absPath, then Keep the upload once
+// that's committed, or Discard it if it isn't.
+func SaveUpload(src io.Reader, absDir, absPath string) (*Upload, error) {
+	if err := os.MkdirAll(absDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	dst, err := os.CreateTemp(absDir, ".upload-*")
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+		return nil, err
+	}
+	return &Upload{tmpPath: dst.Name(), absPath: absPath}, nil
+}
+
+// Keep moves the upload to its path
+func (u *Upload) Keep() error {
+	return os.Rename(u.tmpPath, u.absPath)
+}
+
+// Discard removes the upload
+func (u *Upload) Discard() {
+	os.Remove(u.tmpPath)
+}
@@ -0,0 +1,43 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveUpload(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "documents")
+	path := filepath.Join(dir, "datasheet.pdf")
+
+	upload, err := SaveUpload(strings.NewReader("%PDF"), dir, path)
+	if err != nil {
+		t.Fatalf("SaveUpload failed: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Expected the file to keep its temporary name until kept")
+	}
+	if err := upload.Keep(); err != nil {
+		t.Fatalf("Keep failed: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "%PDF" {
+		t.Errorf("Expected the upload at its path, got %q", data)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("Expected only the kept file, found %d files", len(files))
+	}
+}
+
+func TestSaveUpload_Discard(t *testing.T) {
+	dir := t.TempDir()
+
+	upload, err := SaveUpload(strings.NewReader("%PDF"), dir, filepath.Join(dir, "datasheet.pdf"))
+	if err != nil {
+		t.Fatalf("SaveUpload failed: %v", err)
+	}
+	upload.Discard()
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Expected the upload removed, found %d files", len(files))
+	}
+}
@@ -0,0 +1,47 @@
+package core
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// ZoneCookie remembers the zone a browser works in
+const ZoneCookie = "wledger_zone"
+
+// SessionZone returns the zone picked in this browser, 0 for all zones
+func SessionZone(r *http.Request) int {
+	c, err := r.Cookie(ZoneCookie)
+	if err != nil {
+		return 0
+	}
+	id, _ := strconv.Atoi(c.Value)
+	return id
+}
+
+// SetSessionZone remembers the zone for this browser. 0 clears it.
+func SetSessionZone(w http.ResponseWriter, zoneID int) {
+	c := &http.Cookie{
+		Name:     ZoneCookie,
+		Value:    strconv.Itoa(zoneID),
+		Path:     "/",
+		MaxAge:   365 * 24 * 60 * 60,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if zoneID == 0 {
+		c.Value = ""
+		c.MaxAge = -1
+	}
+	http.SetCookie(w, c)
+}
+
+// ZoneFromForm returns the submitted zone_id if the request has one,
+// otherwise the browser's zone
+func ZoneFromForm(r *http.Request) int {
+	r.ParseForm()
+	if _, ok := r.Form["zone_id"]; !ok {
+		return SessionZone(r)
+	}
+	id, _ := strconv.Atoi(r.Form.Get("zone_id"))
+	return id
+}
@@ -0,0 +1,33 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSessionZone(t *testing.T) {
+	rr := httptest.NewRecorder()
+	SetSessionZone(rr, 3)
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(""))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, c := range rr.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	if got := SessionZone(req); got != 3 {
+		t.Errorf("SessionZone() = %d, want 3", got)
+	}
+	if got := ZoneFromForm(req); got != 3 {
+		t.Errorf("ZoneFromForm() without a zone_id = %d, want the session zone 3", got)
+	}
+
+	// A submitted zone wins, even "all zones"
+	req = httptest.NewRequest("POST", "/", strings.NewReader("zone_id=0"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: ZoneCookie, Value: "3"})
+	if got := ZoneFromForm(req); got != 0 {
+		t.Errorf("ZoneFromForm() = %d, want 0", got)
+	}
+}
@@ -0,0 +1,76 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse_Invalid(t *testing.T) {
+	bad := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+	}
+	for _, expr := range bad {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) should have failed", expr)
+		}
+	}
+}
+
+func TestSchedule_Matches(t *testing.T) {
+	// Monday 2025-06-02 08:00
+	monday8 := time.Date(2025, 6, 2, 8, 0, 0, 0, time.Local)
+	saturday8 := time.Date(2025, 6, 7, 8, 0, 0, 0, time.Local)
+	sunday := time.Date(2025, 6, 8, 22, 30, 0, 0, time.Local)
+
+	tests := []struct {
+		expr string
+		t    time.Time
+		want bool
+	}{
+		{"0 8 * * 1-5", monday8, true},
+		{"0 8 * * 1-5", saturday8, false},
+		{"0 8 * * 1-5", monday8.Add(time.Minute), false},
+		{"*/15 * * * *", monday8.Add(45 * time.Minute), true},
+		{"*/15 * * * *", monday8.Add(50 * time.Minute), false},
+		{"30 22 * * 7", sunday, true}, // 7 is Sunday too
+		{"30 22 * * 0", sunday, true},
+		{"0 8 2 * 6", monday8, true},   // Day of month OR day of week
+		{"0 8 2 * 6", saturday8, true}, // ...either one matches
+		{"@hourly", monday8, true},
+		{"@weekdays", monday8.Add(-8 * time.Hour), true},
+		{"0 8,20 * 6 *", monday8, true},
+		{"0 8 * 7 *", monday8, false},
+	}
+	for _, tc := range tests {
+		s, err := Parse(tc.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", tc.expr, err)
+		}
+		if got := s.Matches(tc.t); got != tc.want {
+			t.Errorf("%q matches %s = %v, want %v", tc.expr, tc.t.Format(time.RFC1123), got, tc.want)
+		}
+	}
+}
+
+func TestSchedule_Next(t *testing.T) {
+	s, _ := Parse("0 8 * * 1-5")
+
+	// Friday evening -> Monday morning
+	friday := time.Date(2025, 6, 6, 18, 0, 0, 0, time.Local)
+	want := time.Date(2025, 6, 9, 8, 0, 0, 0, time.Local)
+	if got := s.Next(friday); !got.Equal(want) {
+		t.Errorf("Next() = %s, want %s", got, want)
+	}
+
+	never, _ := Parse("0 0 31 2 *")
+	if got := never.Next(friday); !got.IsZero() {
+		t.Errorf("Expected zero time for impossible schedule, got %s", got)
+	}
+}
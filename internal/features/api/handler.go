@@ -0,0 +1,1666 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"wledger/internal/core"
+	"wledger/internal/endpoint"
+	"wledger/internal/models"
+	"wledger/internal/store"
+)
+
+const (
+	defaultLimit  = 50
+	maxLimit      = 500
+	maxBodySize   = 1 << 20         // 1 MB of JSON
+	maxUploadSize = 5 * 1024 * 1024 // 5 MB, like the details page
+)
+
+// openAPI describes every route below, keep it in sync
+//
+//go:embed openapi.json
+var openAPI []byte
+
+// Store defines the database methods this module needs. It's most of the
+// store, the API covers everything the pages edit except the settings.
+type Store interface {
+	// Parts
+	ListParts(ctx context.Context, f models.PartFilter) ([]models.Part, int, error)
+	GetPartByID(ctx context.Context, id int) (models.Part, error)
+	CreatePart(ctx context.Context, p *models.Part) error
+	UpdatePart(ctx context.Context, p *models.Part) error
+	DeletePart(ctx context.Context, id int) error
+
+	// Bins
+	ListBins(ctx context.Context, f models.BinFilter) ([]models.Bin, int, error)
+	GetBinByID(ctx context.Context, id int) (models.Bin, error)
+	DeleteBin(ctx context.Context, id int) error
+	GetLEDConflict(ctx context.Context, b models.Bin) (models.LEDConflict, error)
+
+	// Controllers
+	ListControllers(ctx context.Context, f models.ControllerFilter) ([]models.WLEDController, int, error)
+	GetControllerByID(ctx context.Context, id int) (models.WLEDController, error)
+	DeleteController(ctx context.Context, id int) error
+
+	// Locations
+	ListPartLocations(ctx context.Context, partID, limit, offset int) ([]models.PartLocation, int, error)
+	GetPartLocationByID(ctx context.Context, locationID int) (models.PartLocation, error)
+	DeletePartLocation(ctx context.Context, locationID int) error
+
+	// Categories
+	ListCategories(ctx context.Context, f models.CategoryFilter) ([]models.Category, int, error)
+	GetCategoryByID(ctx context.Context, id int) (models.Category, error)
+	CreateCategory(ctx context.Context, name string) (models.Category, error)
+	UpdateCategory(ctx context.Context, c *models.Category) error
+	DeleteCategory(ctx context.Context, id int) error
+	AssignCategoryToPart(ctx context.Context, partID int, categoryID int) error
+	RemoveCategoryFromPart(ctx context.Context, partID int, categoryID int) error
+
+	// URLs
+	ListPartURLs(ctx context.Context, partID, limit, offset int) ([]models.PartURL, int, error)
+	GetPartURLByID(ctx context.Context, urlID int) (models.PartURL, error)
+	CreatePartURL(ctx context.Context, partID int, url string, description string) (int, error)
+	UpdatePartURL(ctx context.Context, u *models.PartURL) error
+	DeletePartURL(ctx context.Context, urlID int) error
+
+	// Documents
+	ListPartDocuments(ctx context.Context, partID, limit, offset int) ([]models.PartDocument, int, error)
+	GetDocumentByID(ctx context.Context, docID int) (models.PartDocument, error)
+	UpdatePartDocument(ctx context.Context, doc *models.PartDocument) error
+	DeletePartDocument(ctx context.Context, docID int) error
+
+	// WithTx runs fn in one transaction, see store.Store.WithTx
+	WithTx(ctx context.Context, fn func(tx TxStore) error) error
+	TxStore
+}
+
+// TxStore holds the Store methods the handlers call inside WithTx
+type TxStore interface {
+	CreateBin(ctx context.Context, name string, controllerID, segmentID, ledIndex int, sharedLED bool) (int, error)
+	UpdateBin(ctx context.Context, b *models.Bin) error
+	CreateController(ctx context.Context, c *models.WLEDController) error
+	UpdateController(ctx context.Context, c *models.WLEDController) error
+	CreatePartLocation(ctx context.Context, partID, binID, quantity int) (int, error)
+	UpdatePartLocation(ctx context.Context, locationID, quantity int) error
+	UpdatePartLocationThresholds(ctx context.Context, locationID int, reorderPoint, minStock sql.NullInt64) error
+	UpdatePartLocationCapacity(ctx context.Context, locationID int, capacity sql.NullInt64) error
+	CreatePartDocument(ctx context.Context, doc *models.PartDocument) error
+}
+
+// Forgetter drops what's kept in memory about a controller address, like
+// its breaker and saved state, once the controller moved to another address
+type Forgetter interface {
+	Forget(ipAddress string)
+}
+
+type Handler struct {
+	store     Store
+	forget    Forgetter
+	uploadDir string
+}
+
+func New(s Store, f Forgetter, uploadDir string) *Handler {
+	return &Handler{
+		store:     s,
+		forget:    f,
+		uploadDir: uploadDir,
+	}
+}
+
+func (h *Handler) RegisterRoutes(r chi.Router) {
+	r.Get("/api/v1/openapi.json", h.handleOpenAPI)
+
+	// Reading
+	r.Group(func(r chi.Router) {
+		r.Use(core.Require(models.RoleViewer))
+		r.Get("/api/v1/parts", h.handleListParts)
+		r.Get("/api/v1/parts/{id}", h.handleGetPart)
+		r.Get("/api/v1/parts/{id}/locations", h.handleListLocations)
+		r.Get("/api/v1/parts/{id}/categories", h.handleListPartCategories)
+		r.Get("/api/v1/parts/{id}/urls", h.handleListURLs)
+		r.Get("/api/v1/parts/{id}/documents", h.handleListDocuments)
+		r.Get("/api/v1/locations/{id}", h.handleGetLocation)
+		r.Get("/api/v1/categories", h.handleListCategories)
+		r.Get("/api/v1/categories/{id}", h.handleGetCategory)
+		r.Get("/api/v1/urls/{id}", h.handleGetURL)
+		r.Get("/api/v1/documents/{id}", h.handleGetDocument)
+		r.Get("/api/v1/documents/{id}/content", h.handleDownloadDocument)
+		r.Get("/api/v1/bins", h.handleListBins)
+		r.Get("/api/v1/bins/{id}", h.handleGetBin)
+	})
+
+	// Parts and their stock, like the details page
+	r.Group(func(r chi.Router) {
+		r.Use(core.Require(models.RoleEditor))
+		r.Post("/api/v1/parts", h.handleCreatePart)
+		r.Patch("/api/v1/parts/{id}", h.handleUpdatePart)
+		r.Delete("/api/v1/parts/{id}", h.handleDeletePart)
+
+		r.Post("/api/v1/parts/{id}/locations", h.handleCreateLocation)
+		r.Patch("/api/v1/locations/{id}", h.handleUpdateLocation)
+		r.Delete("/api/v1/locations/{id}", h.handleDeleteLocation)
+
+		r.Post("/api/v1/categories", h.handleCreateCategory)
+		r.Put("/api/v1/parts/{id}/categories/{category_id}", h.handleAssignCategory)
+		r.Delete("/api/v1/parts/{id}/categories/{category_id}", h.handleRemoveCategory)
+
+		r.Post("/api/v1/parts/{id}/urls", h.handleCreateURL)
+		r.Patch("/api/v1/urls/{id}", h.handleUpdateURL)
+		r.Delete("/api/v1/urls/{id}", h.handleDeleteURL)
+
+		r.Post("/api/v1/parts/{id}/documents", h.handleUploadDocument)
+		r.Patch("/api/v1/documents/{id}", h.handleUpdateDocument)
+		r.Delete("/api/v1/documents/{id}", h.handleDeleteDocument)
+	})
+
+	// Hardware, and categories every part shares, like the settings page
+	r.Group(func(r chi.Router) {
+		r.Use(core.Require(models.RoleAdmin))
+		r.Patch("/api/v1/categories/{id}", h.handleUpdateCategory)
+		r.Delete("/api/v1/categories/{id}", h.handleDeleteCategory)
+
+		r.Post("/api/v1/bins", h.handleCreateBin)
+		r.Patch("/api/v1/bins/{id}", h.handleUpdateBin)
+		r.Delete("/api/v1/bins/{id}", h.handleDeleteBin)
+
+		r.Get("/api/v1/controllers", h.handleListControllers)
+		r.Get("/api/v1/controllers/{id}", h.handleGetController)
+		r.Post("/api/v1/controllers", h.handleCreateController)
+		r.Patch("/api/v1/controllers/{id}", h.handleUpdateController)
+		r.Delete("/api/v1/controllers/{id}", h.handleDeleteController)
+	})
+}
+
+// Resources, as the API sends them
+
+type part struct {
+	ID            int       `json:"id"`
+	Name          string    `json:"name"`
+	Description   string    `json:"description"`
+	PartNumber    string    `json:"part_number"`
+	Manufacturer  string    `json:"manufacturer"`
+	Supplier      string    `json:"supplier"`
+	UnitCost      float64   `json:"unit_cost"`
+	Status        string    `json:"status"`
+	StockTracking bool      `json:"stock_tracking"`
+	ReorderPoint  int       `json:"reorder_point"`
+	MinStock      int       `json:"min_stock"`
+	TotalQuantity int       `json:"total_quantity"`
+	ImageURL      string    `json:"image_url"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+func toPart(p models.Part) part {
+	out := part{
+		ID:            p.ID,
+		Name:          p.Name,
+		Description:   p.Description.String,
+		PartNumber:    p.PartNumber.String,
+		Manufacturer:  p.Manufacturer.String,
+		Supplier:      p.Supplier.String,
+		UnitCost:      p.UnitCost.Float64,
+		Status:        p.Status.String,
+		StockTracking: p.StockTracking,
+		ReorderPoint:  p.ReorderPoint,
+		MinStock:      p.MinStock,
+		TotalQuantity: p.TotalQuantity,
+		CreatedAt:     p.CreatedAt,
+		UpdatedAt:     p.UpdatedAt,
+	}
+	if p.ImagePath.String != "" {
+		out.ImageURL = "/uploads/" + p.ImagePath.String
+	}
+	return out
+}
+
+type bin struct {
+	ID             int    `json:"id"`
+	Name           string `json:"name"`
+	ControllerID   int    `json:"controller_id"` // 0 if the bin is detached
+	ControllerName string `json:"controller_name"`
+	SegmentID      int    `json:"segment_id"`
+	LEDIndex       int    `json:"led_index"`
+	SharedLED      bool   `json:"shared_led"`
+	Capacity       int    `json:"capacity"`
+	ZoneID         int    `json:"zone_id"`
+	ZoneName       string `json:"zone_name"`
+	HasOverlap     bool   `json:"has_overlap"`
+	IsOrphaned     bool   `json:"is_orphaned"`
+	IsDetached     bool   `json:"is_detached"`
+}
+
+func toBin(b models.Bin) bin {
+	return bin{
+		ID:             b.ID,
+		Name:           b.Name,
+		ControllerID:   b.WLEDControllerID,
+		ControllerName: b.WLEDControllerName.String,
+		SegmentID:      b.WLEDSegmentID,
+		LEDIndex:       b.LEDIndex,
+		SharedLED:      b.SharedLED,
+		Capacity:       b.Capacity,
+		ZoneID:         b.ZoneID,
+		ZoneName:       b.ZoneName.String,
+		HasOverlap:     b.HasOverlap,
+		IsOrphaned:     b.IsOrphaned,
+		IsDetached:     b.IsDetached,
+	}
+}
+
+// controller leaves out the controller's password, has_login tells
+// whether it has one
+type controller struct {
+	ID           int        `json:"id"`
+	Name         string     `json:"name"`
+	Address      string     `json:"address"`
+	Username     string     `json:"username"`
+	HasLogin     bool       `json:"has_login"`
+	Status       string     `json:"status"`
+	LastSeen     *time.Time `json:"last_seen"`
+	BinCount     int        `json:"bin_count"`
+	RestoreState bool       `json:"restore_state"`
+	ZoneID       int        `json:"zone_id"`
+	ZoneName     string     `json:"zone_name"`
+}
+
+func toController(c models.WLEDController) controller {
+	out := controller{
+		ID:           c.ID,
+		Name:         c.Name,
+		Address:      endpoint.Address(c.Endpoint),
+		Username:     c.Endpoint.Username,
+		HasLogin:     c.Endpoint.Username != "" || c.Endpoint.Password != "",
+		Status:       c.Status,
+		BinCount:     c.BinCount,
+		RestoreState: c.RestoreState,
+		ZoneID:       c.ZoneID,
+		ZoneName:     c.ZoneName.String,
+	}
+	if c.LastSeen.Valid {
+		out.LastSeen = &c.LastSeen.Time
+	}
+	return out
+}
+
+// location is a part's stock in a bin. Its thresholds and capacity are
+// null when the part's or bin's apply.
+type location struct {
+	ID           int    `json:"id"`
+	PartID       int    `json:"part_id"`
+	BinID        int    `json:"bin_id"`
+	BinName      string `json:"bin_name"`
+	ControllerID int    `json:"controller_id"`
+	SegmentID    int    `json:"segment_id"`
+	LEDIndex     int    `json:"led_index"`
+	Quantity     int    `json:"quantity"`
+	ReorderPoint *int64 `json:"reorder_point"`
+	MinStock     *int64 `json:"min_stock"`
+	Capacity     *int64 `json:"capacity"`
+}
+
+func toLocation(l models.PartLocation) location {
+	nullable := func(n sql.NullInt64) *int64 {
+		if !n.Valid {
+			return nil
+		}
+		return &n.Int64
+	}
+	return location{
+		ID:           l.LocationID,
+		PartID:       l.PartID,
+		BinID:        l.BinID,
+		BinName:      l.BinName,
+		ControllerID: l.ControllerID,
+		SegmentID:    l.SegmentID,
+		LEDIndex:     l.LEDIndex,
+		Quantity:     l.Quantity,
+		ReorderPoint: nullable(l.ReorderPoint),
+		MinStock:     nullable(l.MinStock),
+		Capacity:     nullable(l.Capacity),
+	}
+}
+
+type category struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func toCategory(c models.Category) category {
+	return category{ID: c.ID, Name: c.Name}
+}
+
+type partURL struct {
+	ID          int    `json:"id"`
+	PartID      int    `json:"part_id"`
+	URL         string `json:"url"`
+	Description string `json:"description"`
+}
+
+func toURL(u models.PartURL) partURL {
+	return partURL{ID: u.ID, PartID: u.PartID, URL: u.URL, Description: u.Description.String}
+}
+
+type document struct {
+	ID          int    `json:"id"`
+	PartID      int    `json:"part_id"`
+	Filename    string `json:"filename"`
+	Description string `json:"description"`
+	Mimetype    string `json:"mimetype"`
+	ContentURL  string `json:"content_url"`
+}
+
+func toDocument(d models.PartDocument) document {
+	return document{
+		ID:          d.ID,
+		PartID:      d.PartID,
+		Filename:    d.Filename,
+		Description: d.Description.String,
+		Mimetype:    d.Mimetype,
+		ContentURL:  core.APIPrefix + "documents/" + strconv.Itoa(d.ID) + "/content",
+	}
+}
+
+// Request bodies. Creating and updating share them, fields left out keep
+// their value, or are empty for something new.
+
+// optional is a field of a request body that may be left out or null
+type optional[T any] struct {
+	Set   bool // The field was sent
+	Null  bool // It was sent as null
+	Value T
+}
+
+func (o *optional[T]) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	if string(data) == "null" {
+		o.Null = true
+		return nil
+	}
+	return json.Unmarshal(data, &o.Value)
+}
+
+// apply copies the field's value to dst if it was sent, null sets the zero value
+func (o optional[T]) apply(dst *T) {
+	if o.Set {
+		*dst = o.Value
+	}
+}
+
+// applyString copies a text field, null and "" both clear it
+func applyString(o optional[string], dst *sql.NullString) {
+	if o.Set {
+		*dst = sql.NullString{String: o.Value, Valid: !o.Null}
+	}
+}
+
+// applyInt copies a field where null means "not set"
+func applyInt(o optional[int64], dst *sql.NullInt64) {
+	if o.Set {
+		*dst = sql.NullInt64{Int64: o.Value, Valid: !o.Null}
+	}
+}
+
+type partInput struct {
+	Name          optional[string]  `json:"name"`
+	Description   optional[string]  `json:"description"`
+	PartNumber    optional[string]  `json:"part_number"`
+	Manufacturer  optional[string]  `json:"manufacturer"`
+	Supplier      optional[string]  `json:"supplier"`
+	UnitCost      optional[float64] `json:"unit_cost"`
+	Status        optional[string]  `json:"status"`
+	StockTracking optional[bool]    `json:"stock_tracking"`
+	ReorderPoint  optional[int]     `json:"reorder_point"`
+	MinStock      optional[int]     `json:"min_stock"`
+}
+
+func (in partInput) applyTo(p *models.Part) {
+	in.Name.apply(&p.Name)
+	p.Name = strings.TrimSpace(p.Name)
+	applyString(in.Description, &p.Description)
+	applyString(in.PartNumber, &p.PartNumber)
+	applyString(in.Manufacturer, &p.Manufacturer)
+	applyString(in.Supplier, &p.Supplier)
+	in.Status.apply(&p.Status.String)
+	in.UnitCost.apply(&p.UnitCost.Float64)
+	in.StockTracking.apply(&p.StockTracking)
+	in.ReorderPoint.apply(&p.ReorderPoint)
+	in.MinStock.apply(&p.MinStock)
+}
+
+// partStatuses are the statuses the details page offers
+var partStatuses = []string{"active", "obsolete", "in-progress"}
+
+// validPart checks a part before it's saved, or sends a 400. Only a status
+// that's sent is checked, parts made on the inventory page may have none.
+func validPart(w http.ResponseWriter, r *http.Request, p models.Part, in partInput) bool {
+	switch {
+	case p.Name == "":
+		core.ClientError(w, r, http.StatusBadRequest, "Part name is required", nil)
+	case in.Status.Set && !slices.Contains(partStatuses, p.Status.String):
+		core.ClientError(w, r, http.StatusBadRequest, "status must be one of "+strings.Join(partStatuses, ", "), nil)
+	case p.UnitCost.Float64 < 0 || p.ReorderPoint < 0 || p.MinStock < 0:
+		core.ClientError(w, r, http.StatusBadRequest, "unit_cost, reorder_point and min_stock can't be negative", nil)
+	default:
+		return true
+	}
+	return false
+}
+
+type binInput struct {
+	Name         optional[string] `json:"name"`
+	ControllerID optional[int]    `json:"controller_id"`
+	SegmentID    optional[int]    `json:"segment_id"`
+	LEDIndex     optional[int]    `json:"led_index"`
+	SharedLED    optional[bool]   `json:"shared_led"`
+	Capacity     optional[int]    `json:"capacity"`
+	ZoneID       optional[int]    `json:"zone_id"`
+}
+
+func (in binInput) applyTo(b *models.Bin) {
+	in.Name.apply(&b.Name)
+	b.Name = strings.TrimSpace(b.Name)
+	in.ControllerID.apply(&b.WLEDControllerID)
+	in.SegmentID.apply(&b.WLEDSegmentID)
+	in.LEDIndex.apply(&b.LEDIndex)
+	in.SharedLED.apply(&b.SharedLED)
+	in.Capacity.apply(&b.Capacity)
+	in.ZoneID.apply(&b.ZoneID)
+}
+
+type controllerInput struct {
+	Name         optional[string] `json:"name"`
+	Address      optional[string] `json:"address"`
+	Username     optional[string] `json:"username"`
+	Password     optional[string] `json:"password"`
+	RestoreState optional[bool]   `json:"restore_state"`
+	ZoneID       optional[int]    `json:"zone_id"`
+}
+
+// applyTo updates the controller, checking its new address like the
+// settings page does
+func (in controllerInput) applyTo(c *models.WLEDController) error {
+	in.Name.apply(&c.Name)
+	c.Name = strings.TrimSpace(c.Name)
+	in.RestoreState.apply(&c.RestoreState)
+	in.ZoneID.apply(&c.ZoneID)
+
+	e := c.Endpoint
+	if in.Address.Set {
+		parsed, err := endpoint.Parse(in.Address.Value)
+		if err != nil {
+			return err
+		}
+		// Credentials in the address win, otherwise the login is kept
+		if parsed.Username == "" {
+			parsed.Username, parsed.Password = e.Username, e.Password
+		}
+		e = parsed
+	}
+	if in.Username.Set {
+		e.Username = strings.TrimSpace(in.Username.Value)
+	}
+	in.Password.apply(&e.Password)
+	c.Endpoint = e
+	return endpoint.Validate(e)
+}
+
+type locationInput struct {
+	BinID        optional[int]   `json:"bin_id"` // Only when creating
+	Quantity     optional[int]   `json:"quantity"`
+	ReorderPoint optional[int64] `json:"reorder_point"`
+	MinStock     optional[int64] `json:"min_stock"`
+	Capacity     optional[int64] `json:"capacity"`
+}
+
+type categoryInput struct {
+	Name optional[string] `json:"name"`
+}
+
+type urlInput struct {
+	URL         optional[string] `json:"url"`
+	Description optional[string] `json:"description"`
+}
+
+type documentInput struct {
+	Filename    optional[string] `json:"filename"`
+	Description optional[string] `json:"description"`
+}
+
+// Helpers
+
+// list is the body of every list response
+type list[T any] struct {
+	Data   []T `json:"data"`
+	Total  int `json:"total"` // Matching items on all pages
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+// pageQuery returns the limit and offset query parameters, or sends a 400
+// if they're invalid
+func pageQuery(w http.ResponseWriter, r *http.Request) (limit, offset int, ok bool) {
+	limit = defaultLimit
+	var err error
+	if s := r.URL.Query().Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > maxLimit {
+			core.ClientError(w, r, http.StatusBadRequest, fmt.Sprintf("limit must be a number from 1 to %d", maxLimit), nil)
+			return 0, 0, false
+		}
+	}
+	if s := r.URL.Query().Get("offset"); s != "" {
+		if offset, err = strconv.Atoi(s); err != nil || offset < 0 {
+			core.ClientError(w, r, http.StatusBadRequest, "offset must be a number from 0", nil)
+			return 0, 0, false
+		}
+	}
+	return limit, offset, true
+}
+
+// convertAll converts items for the API
+func convertAll[M, T any](items []M, convert func(M) T) []T {
+	out := make([]T, 0, len(items))
+	for _, item := range items {
+		out = append(out, convert(item))
+	}
+	return out
+}
+
+// writeList sends a page of items the store returned, converted for the API
+func writeList[M, T any](w http.ResponseWriter, items []M, total, limit, offset int, convert func(M) T) {
+	writeJSON(w, http.StatusOK, list[T]{
+		Data:   convertAll(items, convert),
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing API response: %v", err)
+	}
+}
+
+// writeCreated sends something new along with where to find it
+func writeCreated(w http.ResponseWriter, location string, v any) {
+	w.Header().Set("Location", location)
+	writeJSON(w, http.StatusCreated, v)
+}
+
+// decodeJSON reads a request body, or sends a 400 if it isn't valid
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		core.ClientError(w, r, http.StatusBadRequest, "Invalid JSON body: "+err.Error(), err)
+		return false
+	}
+	return true
+}
+
+// idParam returns a numeric route parameter, or sends a 400
+func idParam(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, name))
+	if err != nil || id < 1 {
+		core.ClientError(w, r, http.StatusBadRequest, "Invalid "+strings.ReplaceAll(name, "_", " "), err)
+		return 0, false
+	}
+	return id, true
+}
+
+// intQuery returns a numeric query parameter, 0 if it's missing, or sends a 400
+func intQuery(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		core.ClientError(w, r, http.StatusBadRequest, name+" must be a number", err)
+		return 0, false
+	}
+	return n, true
+}
+
+// lookupError sends a 404 if something doesn't exist, or a 500
+func lookupError(w http.ResponseWriter, r *http.Request, what string, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		core.ClientError(w, r, http.StatusNotFound, what+" not found", err)
+	} else {
+		core.ServerError(w, r, err)
+	}
+}
+
+func (h *Handler) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPI)
+}
+
+// Parts
+
+func (h *Handler) handleListParts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := models.PartFilter{
+		Search:       q.Get("q"),
+		Manufacturer: q.Get("manufacturer"),
+		Supplier:     q.Get("supplier"),
+		Status:       q.Get("status"),
+	}
+	switch q.Get("stock_tracking") {
+	case "":
+	case "true", "false":
+		filter.StockTracking = sql.NullBool{Bool: q.Get("stock_tracking") == "true", Valid: true}
+	default:
+		core.ClientError(w, r, http.StatusBadRequest, "stock_tracking must be true or false", nil)
+		return
+	}
+	var ok bool
+	if filter.Limit, filter.Offset, ok = pageQuery(w, r); !ok {
+		return
+	}
+
+	parts, total, err := h.store.ListParts(r.Context(), filter)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	writeList(w, parts, total, filter.Limit, filter.Offset, toPart)
+}
+
+func (h *Handler) handleGetPart(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	p, err := h.store.GetPartByID(r.Context(), id)
+	if err != nil {
+		lookupError(w, r, "Part", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toPart(p))
+}
+
+func (h *Handler) handleCreatePart(w http.ResponseWriter, r *http.Request) {
+	var in partInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	now := time.Now()
+	p := models.Part{
+		CreatedAt: now,
+		UpdatedAt: now,
+		UnitCost:  sql.NullFloat64{Valid: true},
+		Status:    sql.NullString{String: "active", Valid: true},
+	}
+	in.applyTo(&p)
+	if !validPart(w, r, p, in) {
+		return
+	}
+
+	if err := h.store.CreatePart(r.Context(), &p); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	created, err := h.store.GetPartByID(r.Context(), p.ID)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	writeCreated(w, core.APIPrefix+"parts/"+strconv.Itoa(p.ID), toPart(created))
+}
+
+func (h *Handler) handleUpdatePart(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	var in partInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	p, err := h.store.GetPartByID(r.Context(), id)
+	if err != nil {
+		lookupError(w, r, "Part", err)
+		return
+	}
+	in.applyTo(&p)
+	if !validPart(w, r, p, in) {
+		return
+	}
+	p.UpdatedAt = time.Now()
+
+	if err := h.store.UpdatePart(r.Context(), &p); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toPart(p))
+}
+
+func (h *Handler) handleDeletePart(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	if _, err := h.store.GetPartByID(r.Context(), id); err != nil {
+		lookupError(w, r, "Part", err)
+		return
+	}
+	if err := h.store.DeletePart(r.Context(), id); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Bins
+
+func (h *Handler) handleListBins(w http.ResponseWriter, r *http.Request) {
+	controllerID, ok := intQuery(w, r, "controller_id")
+	if !ok {
+		return
+	}
+	filter := models.BinFilter{Search: r.URL.Query().Get("q"), ControllerID: controllerID}
+	if filter.ZoneID, ok = intQuery(w, r, "zone_id"); !ok {
+		return
+	}
+	if filter.Limit, filter.Offset, ok = pageQuery(w, r); !ok {
+		return
+	}
+
+	bins, total, err := h.store.ListBins(r.Context(), filter)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	writeList(w, bins, total, filter.Limit, filter.Offset, toBin)
+}
+
+func (h *Handler) handleGetBin(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	b, err := h.store.GetBinByID(r.Context(), id)
+	if err != nil {
+		lookupError(w, r, "Bin", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toBin(b))
+}
+
+// writeBinError explains why a bin couldn't be saved. An LED that's in use
+// names the bins on it and the next free LED, like the settings page.
+func (h *Handler) writeBinError(w http.ResponseWriter, r *http.Request, b models.Bin, err error) {
+	switch {
+	case errors.Is(err, store.ErrUniqueConstraint):
+		core.ClientError(w, r, http.StatusConflict, "A bin with this name already exists", err)
+	case errors.Is(err, store.ErrForeignKeyConstraint):
+		core.ClientError(w, r, http.StatusBadRequest, "Invalid controller or zone", err)
+	case errors.Is(err, store.ErrLEDConflict):
+		conflict, cerr := h.store.GetLEDConflict(r.Context(), b)
+		if cerr != nil {
+			core.ServerError(w, r, cerr)
+			return
+		}
+		share := "set shared_led to share it"
+		if !conflict.Shared {
+			share = "set shared_led on those bins, then on this one, to share it"
+		}
+		msg := fmt.Sprintf("LED %d on segment %d is used by %s. The next free LED is %d, or %s.",
+			conflict.LEDIndex, conflict.SegmentID, strings.Join(conflict.Bins, ", "), conflict.NextFree, share)
+		core.ClientError(w, r, http.StatusConflict, msg, err)
+	default:
+		core.ServerError(w, r, err)
+	}
+}
+
+func (h *Handler) handleCreateBin(w http.ResponseWriter, r *http.Request) {
+	var in binInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	var b models.Bin
+	in.applyTo(&b)
+	if b.Name == "" || b.WLEDControllerID == 0 {
+		core.ClientError(w, r, http.StatusBadRequest, "name and controller_id are required", nil)
+		return
+	}
+
+	// Capacity and zone aren't part of creating a bin, so they're saved
+	// right after it, together
+	err := h.store.WithTx(r.Context(), func(tx TxStore) error {
+		id, err := tx.CreateBin(r.Context(), b.Name, b.WLEDControllerID, b.WLEDSegmentID, b.LEDIndex, b.SharedLED)
+		if err != nil {
+			return err
+		}
+		b.ID = id
+		if b.Capacity != 0 || b.ZoneID != 0 {
+			return tx.UpdateBin(r.Context(), &b)
+		}
+		return nil
+	})
+	if err != nil {
+		b.ID = 0
+		h.writeBinError(w, r, b, err)
+		return
+	}
+	created, err := h.store.GetBinByID(r.Context(), b.ID)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	writeCreated(w, core.APIPrefix+"bins/"+strconv.Itoa(b.ID), toBin(created))
+}
+
+func (h *Handler) handleUpdateBin(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	var in binInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	b, err := h.store.GetBinByID(r.Context(), id)
+	if err != nil {
+		lookupError(w, r, "Bin", err)
+		return
+	}
+	in.applyTo(&b)
+	if b.Name == "" {
+		core.ClientError(w, r, http.StatusBadRequest, "Bin name is required", nil)
+		return
+	}
+
+	if err := h.store.UpdateBin(r.Context(), &b); err != nil {
+		h.writeBinError(w, r, b, err)
+		return
+	}
+	updated, err := h.store.GetBinByID(r.Context(), id)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toBin(updated))
+}
+
+func (h *Handler) handleDeleteBin(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	if _, err := h.store.GetBinByID(r.Context(), id); err != nil {
+		lookupError(w, r, "Bin", err)
+		return
+	}
+	if err := h.store.DeleteBin(r.Context(), id); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Controllers
+
+func (h *Handler) handleListControllers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := models.ControllerFilter{Search: q.Get("q"), Status: q.Get("status")}
+	var ok bool
+	if filter.ZoneID, ok = intQuery(w, r, "zone_id"); !ok {
+		return
+	}
+	if filter.Limit, filter.Offset, ok = pageQuery(w, r); !ok {
+		return
+	}
+
+	controllers, total, err := h.store.ListControllers(r.Context(), filter)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	writeList(w, controllers, total, filter.Limit, filter.Offset, toController)
+}
+
+func (h *Handler) handleGetController(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	c, err := h.store.GetControllerByID(r.Context(), id)
+	if err != nil {
+		lookupError(w, r, "Controller", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toController(c))
+}
+
+// writeControllerError reports a duplicate address or a controller that
+// still has bins
+func writeControllerError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, store.ErrUniqueConstraint):
+		core.ClientError(w, r, http.StatusConflict, "A controller with this address already exists", err)
+	case errors.Is(err, store.ErrForeignKeyConstraint):
+		core.ClientError(w, r, http.StatusConflict, "The controller still has bins, move or delete them first", err)
+	default:
+		core.ServerError(w, r, err)
+	}
+}
+
+func (h *Handler) handleCreateController(w http.ResponseWriter, r *http.Request) {
+	var in controllerInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if !in.Address.Set || in.Address.Value == "" {
+		core.ClientError(w, r, http.StatusBadRequest, "name and address are required", nil)
+		return
+	}
+	var c models.WLEDController
+	if err := in.applyTo(&c); err != nil {
+		core.ClientError(w, r, http.StatusBadRequest, "Invalid address: "+err.Error(), err)
+		return
+	}
+	if c.Name == "" {
+		core.ClientError(w, r, http.StatusBadRequest, "name and address are required", nil)
+		return
+	}
+
+	// Restoring state and the zone aren't part of creating a controller
+	err := h.store.WithTx(r.Context(), func(tx TxStore) error {
+		if err := tx.CreateController(r.Context(), &c); err != nil {
+			return err
+		}
+		if c.RestoreState || c.ZoneID != 0 {
+			return tx.UpdateController(r.Context(), &c)
+		}
+		return nil
+	})
+	if err != nil {
+		writeControllerError(w, r, err)
+		return
+	}
+	created, err := h.store.GetControllerByID(r.Context(), c.ID)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	writeCreated(w, core.APIPrefix+"controllers/"+strconv.Itoa(c.ID), toController(created))
+}
+
+func (h *Handler) handleUpdateController(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	var in controllerInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	c, err := h.store.GetControllerByID(r.Context(), id)
+	if err != nil {
+		lookupError(w, r, "Controller", err)
+		return
+	}
+	if err := in.applyTo(&c); err != nil {
+		core.ClientError(w, r, http.StatusBadRequest, "Invalid address: "+err.Error(), err)
+		return
+	}
+	if c.Name == "" {
+		core.ClientError(w, r, http.StatusBadRequest, "Controller name is required", nil)
+		return
+	}
+
+	previous := c.IPAddress
+	if err := h.store.UpdateController(r.Context(), &c); err != nil {
+		writeControllerError(w, r, err)
+		return
+	}
+	updated, err := h.store.GetControllerByID(r.Context(), id)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	if updated.IPAddress != previous {
+		h.forget.Forget(previous)
+	}
+	writeJSON(w, http.StatusOK, toController(updated))
+}
+
+func (h *Handler) handleDeleteController(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	if _, err := h.store.GetControllerByID(r.Context(), id); err != nil {
+		lookupError(w, r, "Controller", err)
+		return
+	}
+	if err := h.store.DeleteController(r.Context(), id); err != nil {
+		writeControllerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Locations
+
+func (h *Handler) handleListLocations(w http.ResponseWriter, r *http.Request) {
+	partID, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	limit, offset, ok := pageQuery(w, r)
+	if !ok {
+		return
+	}
+	if _, err := h.store.GetPartByID(r.Context(), partID); err != nil {
+		lookupError(w, r, "Part", err)
+		return
+	}
+	locations, total, err := h.store.ListPartLocations(r.Context(), partID, limit, offset)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	writeList(w, locations, total, limit, offset, toLocation)
+}
+
+func (h *Handler) handleGetLocation(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	loc, err := h.store.GetPartLocationByID(r.Context(), id)
+	if err != nil {
+		lookupError(w, r, "Location", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toLocation(loc))
+}
+
+// saveLocationDetails saves a location's quantity, thresholds and capacity
+// if the body has them
+func saveLocationDetails(ctx context.Context, tx TxStore, id int, in locationInput, current models.PartLocation) error {
+	if in.Quantity.Set {
+		if err := tx.UpdatePartLocation(ctx, id, in.Quantity.Value); err != nil {
+			return err
+		}
+	}
+	if in.ReorderPoint.Set || in.MinStock.Set {
+		applyInt(in.ReorderPoint, &current.ReorderPoint)
+		applyInt(in.MinStock, &current.MinStock)
+		if err := tx.UpdatePartLocationThresholds(ctx, id, current.ReorderPoint, current.MinStock); err != nil {
+			return err
+		}
+	}
+	if in.Capacity.Set {
+		applyInt(in.Capacity, &current.Capacity)
+		return tx.UpdatePartLocationCapacity(ctx, id, current.Capacity)
+	}
+	return nil
+}
+
+// validLocation checks the numbers a location body sends
+func validLocation(w http.ResponseWriter, r *http.Request, in locationInput) bool {
+	if in.Quantity.Value < 0 || in.ReorderPoint.Value < 0 || in.MinStock.Value < 0 || in.Capacity.Value < 0 {
+		core.ClientError(w, r, http.StatusBadRequest, "Quantities can't be negative", nil)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) handleCreateLocation(w http.ResponseWriter, r *http.Request) {
+	partID, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	var in locationInput
+	if !decodeJSON(w, r, &in) || !validLocation(w, r, in) {
+		return
+	}
+	if in.BinID.Value == 0 {
+		core.ClientError(w, r, http.StatusBadRequest, "bin_id is required", nil)
+		return
+	}
+	if _, err := h.store.GetPartByID(r.Context(), partID); err != nil {
+		lookupError(w, r, "Part", err)
+		return
+	}
+	if _, err := h.store.GetBinByID(r.Context(), in.BinID.Value); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			core.ClientError(w, r, http.StatusBadRequest, "Invalid bin_id", err)
+		} else {
+			core.ServerError(w, r, err)
+		}
+		return
+	}
+
+	var id int
+	err := h.store.WithTx(r.Context(), func(tx TxStore) error {
+		var err error
+		if id, err = tx.CreatePartLocation(r.Context(), partID, in.BinID.Value, in.Quantity.Value); err != nil {
+			return err
+		}
+		in.Quantity.Set = false
+		return saveLocationDetails(r.Context(), tx, id, in, models.PartLocation{})
+	})
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	loc, err := h.store.GetPartLocationByID(r.Context(), id)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	writeCreated(w, core.APIPrefix+"locations/"+strconv.Itoa(id), toLocation(loc))
+}
+
+func (h *Handler) handleUpdateLocation(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	var in locationInput
+	if !decodeJSON(w, r, &in) || !validLocation(w, r, in) {
+		return
+	}
+	if in.BinID.Set {
+		core.ClientError(w, r, http.StatusBadRequest, "A location's bin can't change, create a new location instead", nil)
+		return
+	}
+	current, err := h.store.GetPartLocationByID(r.Context(), id)
+	if err != nil {
+		lookupError(w, r, "Location", err)
+		return
+	}
+
+	err = h.store.WithTx(r.Context(), func(tx TxStore) error {
+		return saveLocationDetails(r.Context(), tx, id, in, current)
+	})
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	loc, err := h.store.GetPartLocationByID(r.Context(), id)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toLocation(loc))
+}
+
+func (h *Handler) handleDeleteLocation(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	if _, err := h.store.GetPartLocationByID(r.Context(), id); err != nil {
+		lookupError(w, r, "Location", err)
+		return
+	}
+	if err := h.store.DeletePartLocation(r.Context(), id); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Categories
+
+func (h *Handler) handleListCategories(w http.ResponseWriter, r *http.Request) {
+	filter := models.CategoryFilter{Search: r.URL.Query().Get("q")}
+	var ok bool
+	if filter.Limit, filter.Offset, ok = pageQuery(w, r); !ok {
+		return
+	}
+
+	categories, total, err := h.store.ListCategories(r.Context(), filter)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	writeList(w, categories, total, filter.Limit, filter.Offset, toCategory)
+}
+
+func (h *Handler) handleGetCategory(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	c, err := h.store.GetCategoryByID(r.Context(), id)
+	if err != nil {
+		lookupError(w, r, "Category", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toCategory(c))
+}
+
+// handleCreateCategory returns the existing category if the name is taken
+func (h *Handler) handleCreateCategory(w http.ResponseWriter, r *http.Request) {
+	var in categoryInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	name := strings.TrimSpace(in.Name.Value)
+	if name == "" {
+		core.ClientError(w, r, http.StatusBadRequest, "Category name is required", nil)
+		return
+	}
+	c, err := h.store.CreateCategory(r.Context(), name)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	writeCreated(w, core.APIPrefix+"categories/"+strconv.Itoa(c.ID), toCategory(c))
+}
+
+func (h *Handler) handleUpdateCategory(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	var in categoryInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	c, err := h.store.GetCategoryByID(r.Context(), id)
+	if err != nil {
+		lookupError(w, r, "Category", err)
+		return
+	}
+	in.Name.apply(&c.Name)
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Name == "" {
+		core.ClientError(w, r, http.StatusBadRequest, "Category name is required", nil)
+		return
+	}
+
+	if err := h.store.UpdateCategory(r.Context(), &c); err != nil {
+		if errors.Is(err, store.ErrUniqueConstraint) {
+			core.ClientError(w, r, http.StatusConflict, "A category with this name already exists", err)
+		} else {
+			core.ServerError(w, r, err)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, toCategory(c))
+}
+
+func (h *Handler) handleDeleteCategory(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	if _, err := h.store.GetCategoryByID(r.Context(), id); err != nil {
+		lookupError(w, r, "Category", err)
+		return
+	}
+	if err := h.store.DeleteCategory(r.Context(), id); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) handleListPartCategories(w http.ResponseWriter, r *http.Request) {
+	partID, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	limit, offset, ok := pageQuery(w, r)
+	if !ok {
+		return
+	}
+	if _, err := h.store.GetPartByID(r.Context(), partID); err != nil {
+		lookupError(w, r, "Part", err)
+		return
+	}
+	categories, total, err := h.store.ListCategories(r.Context(), models.CategoryFilter{PartID: partID, Limit: limit, Offset: offset})
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	writeList(w, categories, total, limit, offset, toCategory)
+}
+
+// partAndCategory returns the part and category a route names, or sends
+// an error if either doesn't exist
+func (h *Handler) partAndCategory(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	partID, ok := idParam(w, r, "id")
+	if !ok {
+		return 0, 0, false
+	}
+	categoryID, ok := idParam(w, r, "category_id")
+	if !ok {
+		return 0, 0, false
+	}
+	if _, err := h.store.GetPartByID(r.Context(), partID); err != nil {
+		lookupError(w, r, "Part", err)
+		return 0, 0, false
+	}
+	if _, err := h.store.GetCategoryByID(r.Context(), categoryID); err != nil {
+		lookupError(w, r, "Category", err)
+		return 0, 0, false
+	}
+	return partID, categoryID, true
+}
+
+func (h *Handler) handleAssignCategory(w http.ResponseWriter, r *http.Request) {
+	partID, categoryID, ok := h.partAndCategory(w, r)
+	if !ok {
+		return
+	}
+	if err := h.store.AssignCategoryToPart(r.Context(), partID, categoryID); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) handleRemoveCategory(w http.ResponseWriter, r *http.Request) {
+	partID, categoryID, ok := h.partAndCategory(w, r)
+	if !ok {
+		return
+	}
+	if err := h.store.RemoveCategoryFromPart(r.Context(), partID, categoryID); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// URLs
+
+func (h *Handler) handleListURLs(w http.ResponseWriter, r *http.Request) {
+	partID, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	limit, offset, ok := pageQuery(w, r)
+	if !ok {
+		return
+	}
+	if _, err := h.store.GetPartByID(r.Context(), partID); err != nil {
+		lookupError(w, r, "Part", err)
+		return
+	}
+	urls, total, err := h.store.ListPartURLs(r.Context(), partID, limit, offset)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	writeList(w, urls, total, limit, offset, toURL)
+}
+
+func (h *Handler) handleGetURL(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	u, err := h.store.GetPartURLByID(r.Context(), id)
+	if err != nil {
+		lookupError(w, r, "URL", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toURL(u))
+}
+
+// validURL reports whether a link is safe for the details page to show
+func validURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func (h *Handler) handleCreateURL(w http.ResponseWriter, r *http.Request) {
+	partID, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	var in urlInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if !validURL(in.URL.Value) {
+		core.ClientError(w, r, http.StatusBadRequest, "url must be an http or https link", nil)
+		return
+	}
+	if _, err := h.store.GetPartByID(r.Context(), partID); err != nil {
+		lookupError(w, r, "Part", err)
+		return
+	}
+
+	id, err := h.store.CreatePartURL(r.Context(), partID, in.URL.Value, in.Description.Value)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	u, err := h.store.GetPartURLByID(r.Context(), id)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	writeCreated(w, core.APIPrefix+"urls/"+strconv.Itoa(id), toURL(u))
+}
+
+func (h *Handler) handleUpdateURL(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	var in urlInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	u, err := h.store.GetPartURLByID(r.Context(), id)
+	if err != nil {
+		lookupError(w, r, "URL", err)
+		return
+	}
+	in.URL.apply(&u.URL)
+	applyString(in.Description, &u.Description)
+	if !validURL(u.URL) {
+		core.ClientError(w, r, http.StatusBadRequest, "url must be an http or https link", nil)
+		return
+	}
+
+	if err := h.store.UpdatePartURL(r.Context(), &u); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toURL(u))
+}
+
+func (h *Handler) handleDeleteURL(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	if _, err := h.store.GetPartURLByID(r.Context(), id); err != nil {
+		lookupError(w, r, "URL", err)
+		return
+	}
+	if err := h.store.DeletePartURL(r.Context(), id); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Documents
+
+func (h *Handler) handleListDocuments(w http.ResponseWriter, r *http.Request) {
+	partID, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	limit, offset, ok := pageQuery(w, r)
+	if !ok {
+		return
+	}
+	if _, err := h.store.GetPartByID(r.Context(), partID); err != nil {
+		lookupError(w, r, "Part", err)
+		return
+	}
+	docs, total, err := h.store.ListPartDocuments(r.Context(), partID, limit, offset)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	writeList(w, docs, total, limit, offset, toDocument)
+}
+
+func (h *Handler) handleGetDocument(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	doc, err := h.store.GetDocumentByID(r.Context(), id)
+	if err != nil {
+		lookupError(w, r, "Document", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toDocument(doc))
+}
+
+func (h *Handler) handleDownloadDocument(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	doc, err := h.store.GetDocumentByID(r.Context(), id)
+	if err != nil {
+		lookupError(w, r, "Document", err)
+		return
+	}
+	w.Header().Set("Content-Type", doc.Mimetype)
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+doc.Filename+"\"")
+	http.ServeFile(w, r, filepath.Join(h.uploadDir, doc.Filepath))
+}
+
+// handleUploadDocument takes a multipart form with the file in "file" and
+// an optional "description", like the details page's upload
+func (h *Handler) handleUploadDocument(w http.ResponseWriter, r *http.Request) {
+	partID, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+		core.ClientError(w, r, http.StatusBadRequest, "Send the document as multipart/form-data of at most 5 MB", err)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		core.ClientError(w, r, http.StatusBadRequest, "The form needs the document in its file field", err)
+		return
+	}
+	defer file.Close()
+	if _, err := h.store.GetPartByID(r.Context(), partID); err != nil {
+		lookupError(w, r, "Part", err)
+		return
+	}
+
+	filename := fmt.Sprintf("%d-%d-%s", partID, time.Now().UnixNano(), filepath.Base(header.Filename))
+	absDir := filepath.Join(h.uploadDir, "documents")
+	absPath := filepath.Join(absDir, filename)
+	doc := &models.PartDocument{
+		PartID:      partID,
+		Filename:    header.Filename,
+		Filepath:    path.Join("documents", filename),
+		Description: sql.NullString{String: r.FormValue("description"), Valid: true},
+		Mimetype:    header.Header.Get("Content-Type"),
+	}
+	// The file gets its name once the document is committed
+	upload, err := core.SaveUpload(file, absDir, absPath)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	err = h.store.WithTx(r.Context(), func(tx TxStore) error {
+		return tx.CreatePartDocument(r.Context(), doc)
+	})
+	if err != nil {
+		upload.Discard()
+		core.ServerError(w, r, err)
+		return
+	}
+	if err := upload.Keep(); err != nil {
+		h.store.DeletePartDocument(r.Context(), doc.ID)
+		upload.Discard()
+		core.ServerError(w, r, err)
+		return
+	}
+	writeCreated(w, core.APIPrefix+"documents/"+strconv.Itoa(doc.ID), toDocument(*doc))
+}
+
+func (h *Handler) handleUpdateDocument(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	var in documentInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	doc, err := h.store.GetDocumentByID(r.Context(), id)
+	if err != nil {
+		lookupError(w, r, "Document", err)
+		return
+	}
+	in.Filename.apply(&doc.Filename)
+	doc.Filename = strings.TrimSpace(doc.Filename)
+	applyString(in.Description, &doc.Description)
+	if doc.Filename == "" {
+		core.ClientError(w, r, http.StatusBadRequest, "filename can't be empty", nil)
+		return
+	}
+
+	if err := h.store.UpdatePartDocument(r.Context(), &doc); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toDocument(doc))
+}
+
+func (h *Handler) handleDeleteDocument(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	doc, err := h.store.GetDocumentByID(r.Context(), id)
+	if err != nil {
+		lookupError(w, r, "Document", err)
+		return
+	}
+	if err := h.store.DeletePartDocument(r.Context(), id); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	if err := os.Remove(filepath.Join(h.uploadDir, doc.Filepath)); err != nil {
+		log.Printf("Warning: failed to delete document file %s: %v", doc.Filepath, err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
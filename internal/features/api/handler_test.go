@@ -0,0 +1,853 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"wledger/internal/core"
+	"wledger/internal/models"
+	"wledger/internal/store"
+)
+
+// Local mock, an in-memory inventory
+type mockStore struct {
+	Parts       map[int]models.Part
+	Bins        map[int]models.Bin
+	Controllers map[int]models.WLEDController
+	Locations   map[int]models.PartLocation
+	Categories  map[int]models.Category
+	PartCats    map[int][]int
+	URLs        map[int]models.PartURL
+	Documents   map[int]models.PartDocument
+	nextID      int
+
+	// RolledBack is set when a WithTx function fails
+	RolledBack bool
+	// Forgotten lists the controller addresses the handler forgot
+	Forgotten []string
+	// PartFilter is the last filter parts were listed with
+	PartFilter models.PartFilter
+}
+
+func newMockStore() *mockStore {
+	return &mockStore{
+		Parts:       map[int]models.Part{},
+		Bins:        map[int]models.Bin{},
+		Controllers: map[int]models.WLEDController{},
+		Locations:   map[int]models.PartLocation{},
+		Categories:  map[int]models.Category{},
+		PartCats:    map[int][]int{},
+		URLs:        map[int]models.PartURL{},
+		Documents:   map[int]models.PartDocument{},
+		nextID:      100,
+	}
+}
+
+func (m *mockStore) id() int {
+	m.nextID++
+	return m.nextID
+}
+
+// sorted returns a map's values by ID, like the store's lists
+func sorted[T any](items map[int]T) []T {
+	ids := []int{}
+	for id := range items {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	out := []T{}
+	for _, id := range ids {
+		out = append(out, items[id])
+	}
+	return out
+}
+
+func get[T any](items map[int]T, id int) (T, error) {
+	item, ok := items[id]
+	if !ok {
+		return item, sql.ErrNoRows
+	}
+	return item, nil
+}
+
+func (m *mockStore) ListParts(ctx context.Context, f models.PartFilter) ([]models.Part, int, error) {
+	m.PartFilter = f
+	out := []models.Part{}
+	for _, p := range sorted(m.Parts) {
+		if strings.Contains(p.Name, f.Search) &&
+			(f.Manufacturer == "" || strings.EqualFold(f.Manufacturer, p.Manufacturer.String)) &&
+			(f.Supplier == "" || strings.EqualFold(f.Supplier, p.Supplier.String)) &&
+			(f.Status == "" || strings.EqualFold(f.Status, p.Status.String)) &&
+			(!f.StockTracking.Valid || f.StockTracking.Bool == p.StockTracking) {
+			out = append(out, p)
+		}
+	}
+	return pageOf(out, f.Limit, f.Offset)
+}
+
+// pageOf returns a page of items and their count, like the store's lists
+func pageOf[T any](items []T, limit, offset int) ([]T, int, error) {
+	total := len(items)
+	items = items[min(offset, total):]
+	if limit > 0 {
+		items = items[:min(limit, len(items))]
+	}
+	return items, total, nil
+}
+
+// containsFold reports whether search is found in s, ignoring case
+func containsFold(s, search string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(search))
+}
+
+func (m *mockStore) GetPartByID(ctx context.Context, id int) (models.Part, error) {
+	return get(m.Parts, id)
+}
+func (m *mockStore) CreatePart(ctx context.Context, p *models.Part) error {
+	p.ID = m.id()
+	m.Parts[p.ID] = *p
+	return nil
+}
+func (m *mockStore) UpdatePart(ctx context.Context, p *models.Part) error {
+	m.Parts[p.ID] = *p
+	return nil
+}
+func (m *mockStore) DeletePart(ctx context.Context, id int) error {
+	delete(m.Parts, id)
+	return nil
+}
+
+func (m *mockStore) ListBins(ctx context.Context, f models.BinFilter) ([]models.Bin, int, error) {
+	out := []models.Bin{}
+	for _, b := range sorted(m.Bins) {
+		if containsFold(b.Name, f.Search) &&
+			(f.ControllerID == 0 || b.WLEDControllerID == f.ControllerID) &&
+			(f.ZoneID == 0 || b.ZoneID == f.ZoneID) {
+			out = append(out, b)
+		}
+	}
+	return pageOf(out, f.Limit, f.Offset)
+}
+func (m *mockStore) GetBinByID(ctx context.Context, id int) (models.Bin, error) {
+	return get(m.Bins, id)
+}
+
+// binsOnLED returns the names of the other unshared bins on a bin's LED
+func (m *mockStore) binsOnLED(b models.Bin) []models.Bin {
+	bins := []models.Bin{}
+	for _, o := range sorted(m.Bins) {
+		if o.ID != b.ID && o.WLEDControllerID == b.WLEDControllerID && o.WLEDSegmentID == b.WLEDSegmentID && o.LEDIndex == b.LEDIndex {
+			bins = append(bins, o)
+		}
+	}
+	return bins
+}
+func (m *mockStore) CreateBin(ctx context.Context, name string, controllerID, segmentID, ledIndex int, sharedLED bool) (int, error) {
+	b := models.Bin{Name: name, WLEDControllerID: controllerID, WLEDSegmentID: segmentID, LEDIndex: ledIndex, SharedLED: sharedLED}
+	err := m.saveBin(&b)
+	return b.ID, err
+}
+func (m *mockStore) UpdateBin(ctx context.Context, b *models.Bin) error {
+	return m.saveBin(b)
+}
+func (m *mockStore) saveBin(b *models.Bin) error {
+	for _, o := range m.Bins {
+		if o.ID != b.ID && o.Name == b.Name {
+			return store.ErrUniqueConstraint
+		}
+	}
+	for _, o := range m.binsOnLED(*b) {
+		if !b.SharedLED || !o.SharedLED {
+			return store.ErrLEDConflict
+		}
+	}
+	if b.ID == 0 {
+		b.ID = m.id()
+	}
+	m.Bins[b.ID] = *b
+	return nil
+}
+func (m *mockStore) DeleteBin(ctx context.Context, id int) error {
+	delete(m.Bins, id)
+	return nil
+}
+func (m *mockStore) GetLEDConflict(ctx context.Context, b models.Bin) (models.LEDConflict, error) {
+	c := models.LEDConflict{
+		ControllerID: b.WLEDControllerID, SegmentID: b.WLEDSegmentID, LEDIndex: b.LEDIndex, NextFree: b.LEDIndex + 1,
+	}
+	others := m.binsOnLED(b)
+	c.Shared = len(others) > 0
+	for _, o := range others {
+		c.Bins = append(c.Bins, o.Name)
+		c.Shared = c.Shared && o.SharedLED
+	}
+	return c, nil
+}
+
+func (m *mockStore) ListControllers(ctx context.Context, f models.ControllerFilter) ([]models.WLEDController, int, error) {
+	out := []models.WLEDController{}
+	for _, c := range sorted(m.Controllers) {
+		if (containsFold(c.Name, f.Search) || containsFold(c.IPAddress, f.Search)) &&
+			(f.Status == "" || strings.EqualFold(f.Status, c.Status)) &&
+			(f.ZoneID == 0 || c.ZoneID == f.ZoneID) {
+			out = append(out, c)
+		}
+	}
+	return pageOf(out, f.Limit, f.Offset)
+}
+func (m *mockStore) GetControllerByID(ctx context.Context, id int) (models.WLEDController, error) {
+	return get(m.Controllers, id)
+}
+func (m *mockStore) CreateController(ctx context.Context, c *models.WLEDController) error {
+	c.ID = m.id()
+	return m.UpdateController(ctx, c)
+}
+func (m *mockStore) UpdateController(ctx context.Context, c *models.WLEDController) error {
+	for _, o := range m.Controllers {
+		if o.ID != c.ID && o.Endpoint.Host == c.Endpoint.Host {
+			return store.ErrUniqueConstraint
+		}
+	}
+	c.IPAddress = c.Endpoint.Host
+	m.Controllers[c.ID] = *c
+	return nil
+}
+func (m *mockStore) Forget(ipAddress string) {
+	m.Forgotten = append(m.Forgotten, ipAddress)
+}
+func (m *mockStore) DeleteController(ctx context.Context, id int) error {
+	for _, b := range m.Bins {
+		if b.WLEDControllerID == id {
+			return store.ErrForeignKeyConstraint
+		}
+	}
+	delete(m.Controllers, id)
+	return nil
+}
+
+func (m *mockStore) ListPartLocations(ctx context.Context, partID, limit, offset int) ([]models.PartLocation, int, error) {
+	out := []models.PartLocation{}
+	for _, l := range sorted(m.Locations) {
+		if l.PartID == partID {
+			out = append(out, l)
+		}
+	}
+	return pageOf(out, limit, offset)
+}
+func (m *mockStore) GetPartLocationByID(ctx context.Context, locationID int) (models.PartLocation, error) {
+	return get(m.Locations, locationID)
+}
+func (m *mockStore) CreatePartLocation(ctx context.Context, partID, binID, quantity int) (int, error) {
+	id := m.id()
+	m.Locations[id] = models.PartLocation{LocationID: id, PartID: partID, BinID: binID, Quantity: quantity, BinName: m.Bins[binID].Name}
+	return id, nil
+}
+func (m *mockStore) UpdatePartLocation(ctx context.Context, locationID, quantity int) error {
+	l := m.Locations[locationID]
+	l.Quantity = quantity
+	m.Locations[locationID] = l
+	return nil
+}
+func (m *mockStore) UpdatePartLocationThresholds(ctx context.Context, locationID int, reorderPoint, minStock sql.NullInt64) error {
+	l := m.Locations[locationID]
+	l.ReorderPoint, l.MinStock = reorderPoint, minStock
+	m.Locations[locationID] = l
+	return nil
+}
+func (m *mockStore) UpdatePartLocationCapacity(ctx context.Context, locationID int, capacity sql.NullInt64) error {
+	l := m.Locations[locationID]
+	l.Capacity = capacity
+	m.Locations[locationID] = l
+	return nil
+}
+func (m *mockStore) DeletePartLocation(ctx context.Context, locationID int) error {
+	delete(m.Locations, locationID)
+	return nil
+}
+
+func (m *mockStore) ListCategories(ctx context.Context, f models.CategoryFilter) ([]models.Category, int, error) {
+	out := []models.Category{}
+	if f.PartID != 0 {
+		for _, id := range m.PartCats[f.PartID] {
+			out = append(out, m.Categories[id])
+		}
+	} else {
+		out = sorted(m.Categories)
+	}
+	out = slices.DeleteFunc(out, func(c models.Category) bool { return !containsFold(c.Name, f.Search) })
+	return pageOf(out, f.Limit, f.Offset)
+}
+func (m *mockStore) GetCategoryByID(ctx context.Context, id int) (models.Category, error) {
+	return get(m.Categories, id)
+}
+func (m *mockStore) CreateCategory(ctx context.Context, name string) (models.Category, error) {
+	for _, c := range m.Categories {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	c := models.Category{ID: m.id(), Name: name}
+	m.Categories[c.ID] = c
+	return c, nil
+}
+func (m *mockStore) UpdateCategory(ctx context.Context, c *models.Category) error {
+	for _, o := range m.Categories {
+		if o.ID != c.ID && o.Name == c.Name {
+			return store.ErrUniqueConstraint
+		}
+	}
+	m.Categories[c.ID] = *c
+	return nil
+}
+func (m *mockStore) DeleteCategory(ctx context.Context, id int) error {
+	delete(m.Categories, id)
+	return nil
+}
+func (m *mockStore) AssignCategoryToPart(ctx context.Context, partID int, categoryID int) error {
+	if !slices.Contains(m.PartCats[partID], categoryID) {
+		m.PartCats[partID] = append(m.PartCats[partID], categoryID)
+	}
+	return nil
+}
+func (m *mockStore) RemoveCategoryFromPart(ctx context.Context, partID int, categoryID int) error {
+	m.PartCats[partID] = slices.DeleteFunc(m.PartCats[partID], func(id int) bool { return id == categoryID })
+	return nil
+}
+
+func (m *mockStore) ListPartURLs(ctx context.Context, partID, limit, offset int) ([]models.PartURL, int, error) {
+	out := []models.PartURL{}
+	for _, u := range sorted(m.URLs) {
+		if u.PartID == partID {
+			out = append(out, u)
+		}
+	}
+	return pageOf(out, limit, offset)
+}
+func (m *mockStore) GetPartURLByID(ctx context.Context, urlID int) (models.PartURL, error) {
+	return get(m.URLs, urlID)
+}
+func (m *mockStore) CreatePartURL(ctx context.Context, partID int, url string, description string) (int, error) {
+	id := m.id()
+	m.URLs[id] = models.PartURL{ID: id, PartID: partID, URL: url, Description: sql.NullString{String: description, Valid: true}}
+	return id, nil
+}
+func (m *mockStore) UpdatePartURL(ctx context.Context, u *models.PartURL) error {
+	m.URLs[u.ID] = *u
+	return nil
+}
+func (m *mockStore) DeletePartURL(ctx context.Context, urlID int) error {
+	delete(m.URLs, urlID)
+	return nil
+}
+
+func (m *mockStore) ListPartDocuments(ctx context.Context, partID, limit, offset int) ([]models.PartDocument, int, error) {
+	out := []models.PartDocument{}
+	for _, d := range sorted(m.Documents) {
+		if d.PartID == partID {
+			out = append(out, d)
+		}
+	}
+	return pageOf(out, limit, offset)
+}
+func (m *mockStore) GetDocumentByID(ctx context.Context, docID int) (models.PartDocument, error) {
+	return get(m.Documents, docID)
+}
+func (m *mockStore) CreatePartDocument(ctx context.Context, doc *models.PartDocument) error {
+	doc.ID = m.id()
+	m.Documents[doc.ID] = *doc
+	return nil
+}
+func (m *mockStore) UpdatePartDocument(ctx context.Context, doc *models.PartDocument) error {
+	m.Documents[doc.ID] = *doc
+	return nil
+}
+func (m *mockStore) DeletePartDocument(ctx context.Context, docID int) error {
+	delete(m.Documents, docID)
+	return nil
+}
+
+func (m *mockStore) WithTx(ctx context.Context, fn func(tx TxStore) error) error {
+	err := fn(m)
+	if err != nil {
+		m.RolledBack = true
+	}
+	return err
+}
+
+// Helpers
+
+func setupTest(t *testing.T) (*Handler, *mockStore, string) {
+	t.Helper()
+	ms := newMockStore()
+	uploadDir := t.TempDir()
+	return New(ms, ms, uploadDir), ms, uploadDir
+}
+
+// router signs every request in as a user with the role
+func router(h *Handler, role models.Role) http.Handler {
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if role != "" {
+				r = r.WithContext(core.WithUser(r.Context(), models.User{ID: 1, Username: "ada", Role: role}))
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	h.RegisterRoutes(r)
+	return r
+}
+
+// do sends a request with a JSON body, if any, and decodes the response into out
+func do(t *testing.T, handler http.Handler, method, target, body string, out any) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if out != nil && rr.Body.Len() > 0 {
+		if err := json.Unmarshal(rr.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: invalid JSON %q: %v", method, target, rr.Body.String(), err)
+		}
+	}
+	return rr
+}
+
+// errorMessage returns the message of a JSON error response
+func errorMessage(t *testing.T, rr *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body core.APIError
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Expected a JSON error, got %q", rr.Body.String())
+	}
+	if body.Error.Status != rr.Code {
+		t.Errorf("Error status %d doesn't match the response's %d", body.Error.Status, rr.Code)
+	}
+	return body.Error.Message
+}
+
+// Tests
+
+func TestOpenAPIDescribesEveryRoute(t *testing.T) {
+	h, _, _ := setupTest(t)
+	r := chi.NewRouter()
+	h.RegisterRoutes(r)
+
+	var spec struct {
+		OpenAPI string                    `json:"openapi"`
+		Paths   map[string]map[string]any `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPI, &spec); err != nil {
+		t.Fatalf("openapi.json is invalid: %v", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Errorf("Expected OpenAPI 3, got %q", spec.OpenAPI)
+	}
+
+	routes := map[string]bool{}
+	chi.Walk(r, func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		key := strings.ToLower(method) + " " + route
+		routes[key] = true
+		if _, ok := spec.Paths[route][strings.ToLower(method)]; !ok {
+			t.Errorf("%s isn't in openapi.json", key)
+		}
+		return nil
+	})
+	for path, ops := range spec.Paths {
+		for method := range ops {
+			if method != "parameters" && !routes[method+" "+path] {
+				t.Errorf("openapi.json describes %s %s, which doesn't exist", method, path)
+			}
+		}
+	}
+
+	rr := do(t, r, "GET", "/api/v1/openapi.json", "", nil)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected the description without signing in, got %d", rr.Code)
+	}
+}
+
+func TestParts(t *testing.T) {
+	h, ms, _ := setupTest(t)
+	api := router(h, models.RoleEditor)
+
+	// Creating
+	rr := do(t, api, "POST", "/api/v1/parts", `{"description": "No name"}`, nil)
+	if rr.Code != http.StatusBadRequest || errorMessage(t, rr) != "Part name is required" {
+		t.Errorf("Expected a nameless part to be rejected, got %d %q", rr.Code, rr.Body.String())
+	}
+	rr = do(t, api, "POST", "/api/v1/parts", `{"name": "Resistor", "colour": "brown"}`, nil)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(errorMessage(t, rr), "colour") {
+		t.Errorf("Expected an unknown field to be rejected, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	var created part
+	rr = do(t, api, "POST", "/api/v1/parts", `{"name": "Resistor", "manufacturer": "Yageo", "unit_cost": 0.02, "reorder_point": 50}`, &created)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d %q", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Location") != "/api/v1/parts/"+strconv.Itoa(created.ID) {
+		t.Errorf("Unexpected Location %q", rr.Header().Get("Location"))
+	}
+	if created.Name != "Resistor" || created.Manufacturer != "Yageo" || created.UnitCost != 0.02 || created.Status != "active" || created.ReorderPoint != 50 {
+		t.Errorf("Unexpected part %+v", created)
+	}
+	do(t, api, "POST", "/api/v1/parts", `{"name": "Capacitor", "manufacturer": "Murata"}`, nil)
+	do(t, api, "POST", "/api/v1/parts", `{"name": "Resistor Array", "manufacturer": "yageo"}`, nil)
+
+	// Updating only changes what's sent, null clears
+	var updated part
+	rr = do(t, api, "PATCH", "/api/v1/parts/"+strconv.Itoa(created.ID), `{"supplier": "Mouser", "description": null}`, &updated)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d %q", rr.Code, rr.Body.String())
+	}
+	if updated.Supplier != "Mouser" || updated.UnitCost != 0.02 || updated.Manufacturer != "Yageo" || updated.ReorderPoint != 50 {
+		t.Errorf("Unexpected update %+v", updated)
+	}
+	if ms.Parts[created.ID].Description.Valid {
+		t.Error("Expected the description to be cleared")
+	}
+	rr = do(t, api, "PATCH", "/api/v1/parts/"+strconv.Itoa(created.ID), `{"status": "lost"}`, nil)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(errorMessage(t, rr), "obsolete") {
+		t.Errorf("Expected an unknown status to be rejected, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	// Listing, searching and filtering
+	var page list[part]
+	do(t, api, "GET", "/api/v1/parts?manufacturer=YAGEO", "", &page)
+	if page.Total != 2 || len(page.Data) != 2 {
+		t.Errorf("Expected 2 Yageo parts, got %+v", page)
+	}
+	do(t, api, "GET", "/api/v1/parts?q=Resistor&limit=1&offset=1", "", &page)
+	if page.Total != 2 || page.Limit != 1 || page.Offset != 1 || len(page.Data) != 1 || page.Data[0].Name != "Resistor Array" {
+		t.Errorf("Unexpected second page %+v", page)
+	}
+	if f := ms.PartFilter; f.Search != "Resistor" || f.Limit != 1 || f.Offset != 1 {
+		t.Errorf("Expected the store to filter and page, got %+v", f)
+	}
+	do(t, api, "GET", "/api/v1/parts?stock_tracking=false", "", nil)
+	if f := ms.PartFilter; !f.StockTracking.Valid || f.StockTracking.Bool || f.Limit != defaultLimit {
+		t.Errorf("Expected untracked parts on the first page, got %+v", f)
+	}
+	do(t, api, "GET", "/api/v1/parts?offset=10", "", &page)
+	if page.Total != 3 || page.Data == nil || len(page.Data) != 0 {
+		t.Errorf("Expected an empty page past the end, got %+v", page)
+	}
+	for _, query := range []string{"limit=0", "limit=501", "offset=-1", "limit=ten", "stock_tracking=maybe"} {
+		if rr := do(t, api, "GET", "/api/v1/parts?"+query, "", nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rr.Code)
+		}
+	}
+
+	// Deleting
+	if rr := do(t, api, "DELETE", "/api/v1/parts/"+strconv.Itoa(created.ID), "", nil); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", rr.Code)
+	}
+	rr = do(t, api, "GET", "/api/v1/parts/"+strconv.Itoa(created.ID), "", nil)
+	if rr.Code != http.StatusNotFound || errorMessage(t, rr) != "Part not found" {
+		t.Errorf("Expected a 404, got %d %q", rr.Code, rr.Body.String())
+	}
+	if rr := do(t, api, "GET", "/api/v1/parts/abc", "", nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a 400 for an invalid ID, got %d", rr.Code)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	h, ms, _ := setupTest(t)
+	ms.Parts[1] = models.Part{ID: 1, Name: "Resistor"}
+
+	rr := do(t, router(h, ""), "GET", "/api/v1/parts", "", nil)
+	if rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Errorf("Expected a 401 asking for a token, got %d %v", rr.Code, rr.Header())
+	}
+	errorMessage(t, rr)
+
+	viewer := router(h, models.RoleViewer)
+	if rr := do(t, viewer, "GET", "/api/v1/parts/1", "", nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected viewers to read parts, got %d", rr.Code)
+	}
+	rr = do(t, viewer, "PATCH", "/api/v1/parts/1", `{"name": "Capacitor"}`, nil)
+	if rr.Code != http.StatusForbidden || errorMessage(t, rr) != "This needs the editor role" {
+		t.Errorf("Expected viewers not to change parts, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	editor := router(h, models.RoleEditor)
+	if rr := do(t, editor, "GET", "/api/v1/controllers", "", nil); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected controllers to need the admin role, got %d", rr.Code)
+	}
+	if rr := do(t, editor, "POST", "/api/v1/bins", `{"name": "A1", "controller_id": 1}`, nil); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected bins to need the admin role to change, got %d", rr.Code)
+	}
+}
+
+func TestBins(t *testing.T) {
+	h, ms, _ := setupTest(t)
+	ms.Controllers[1] = models.WLEDController{ID: 1, Name: "Shelf"}
+	ms.Bins[1] = models.Bin{ID: 1, Name: "A1", WLEDControllerID: 1, LEDIndex: 4}
+	api := router(h, models.RoleAdmin)
+
+	rr := do(t, api, "POST", "/api/v1/bins", `{"name": "A2"}`, nil)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a bin without a controller to be rejected, got %d", rr.Code)
+	}
+
+	// Another bin on the LED
+	rr = do(t, api, "POST", "/api/v1/bins", `{"name": "A2", "controller_id": 1, "led_index": 4}`, nil)
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("Expected 409, got %d %q", rr.Code, rr.Body.String())
+	}
+	if msg := errorMessage(t, rr); !strings.Contains(msg, "A1") || !strings.Contains(msg, "next free LED is 5") {
+		t.Errorf("Expected the conflict and a free LED, got %q", msg)
+	}
+	if !ms.RolledBack {
+		t.Error("Expected the new bin to be rolled back")
+	}
+
+	var created bin
+	rr = do(t, api, "POST", "/api/v1/bins", `{"name": "A2", "controller_id": 1, "led_index": 5, "capacity": 20}`, &created)
+	if rr.Code != http.StatusCreated || created.Capacity != 20 || created.LEDIndex != 5 {
+		t.Fatalf("Expected the bin with its capacity, got %d %+v", rr.Code, created)
+	}
+
+	rr = do(t, api, "PATCH", "/api/v1/bins/"+strconv.Itoa(created.ID), `{"name": "A1"}`, nil)
+	if rr.Code != http.StatusConflict || errorMessage(t, rr) != "A bin with this name already exists" {
+		t.Errorf("Expected a duplicate name to conflict, got %d %q", rr.Code, rr.Body.String())
+	}
+	// A1 has to share its LED first
+	rr = do(t, api, "PATCH", "/api/v1/bins/"+strconv.Itoa(created.ID), `{"led_index": 4, "shared_led": true}`, nil)
+	if rr.Code != http.StatusConflict || !strings.Contains(errorMessage(t, rr), "set shared_led on those bins") {
+		t.Errorf("Expected sharing with an unshared bin to conflict, got %d %q", rr.Code, rr.Body.String())
+	}
+	do(t, api, "PATCH", "/api/v1/bins/1", `{"shared_led": true}`, nil)
+	var updated bin
+	do(t, api, "PATCH", "/api/v1/bins/"+strconv.Itoa(created.ID), `{"led_index": 4, "shared_led": true}`, &updated)
+	if updated.Name != "A2" || updated.LEDIndex != 4 || !updated.SharedLED || updated.Capacity != 20 {
+		t.Errorf("Expected the bin to share the LED, got %+v", updated)
+	}
+
+	var page list[bin]
+	do(t, api, "GET", "/api/v1/bins?q=a2&controller_id=1", "", &page)
+	if page.Total != 1 || page.Data[0].ID != created.ID {
+		t.Errorf("Expected A2, got %+v", page)
+	}
+	do(t, api, "GET", "/api/v1/bins?limit=1&offset=1", "", &page)
+	if page.Total != 2 || page.Limit != 1 || len(page.Data) != 1 || page.Data[0].ID != created.ID {
+		t.Errorf("Expected the second of 2 bins, got %+v", page)
+	}
+	if rr := do(t, api, "GET", "/api/v1/bins?limit=0", "", nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid limit to be rejected, got %d", rr.Code)
+	}
+	if rr := do(t, api, "GET", "/api/v1/bins?zone_id=x", "", nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid zone to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestControllers(t *testing.T) {
+	h, ms, _ := setupTest(t)
+	api := router(h, models.RoleAdmin)
+
+	rr := do(t, api, "POST", "/api/v1/controllers", `{"name": "Shelf", "address": "ftp://shelf"}`, nil)
+	if rr.Code != http.StatusBadRequest || !strings.HasPrefix(errorMessage(t, rr), "Invalid address") {
+		t.Errorf("Expected an invalid address to be rejected, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	var created controller
+	rr = do(t, api, "POST", "/api/v1/controllers", `{"name": "Shelf", "address": "192.168.1.50", "username": "admin", "password": "hunter2", "zone_id": 3}`, &created)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d %q", rr.Code, rr.Body.String())
+	}
+	if strings.Contains(rr.Body.String(), "hunter2") || !created.HasLogin || created.ZoneID != 3 {
+		t.Errorf("Unexpected controller %s", rr.Body.String())
+	}
+	if ms.Controllers[created.ID].Endpoint.Password != "hunter2" {
+		t.Error("Expected the password to be saved")
+	}
+
+	// The login is kept when only the name changes
+	do(t, api, "PATCH", "/api/v1/controllers/"+strconv.Itoa(created.ID), `{"name": "Top Shelf"}`, nil)
+	if c := ms.Controllers[created.ID]; c.Name != "Top Shelf" || c.Endpoint.Password != "hunter2" || c.Endpoint.Host != "192.168.1.50" {
+		t.Errorf("Unexpected controller %+v", c)
+	}
+	if len(ms.Forgotten) != 0 {
+		t.Errorf("Expected the address to be kept, got %v forgotten", ms.Forgotten)
+	}
+	do(t, api, "PATCH", "/api/v1/controllers/"+strconv.Itoa(created.ID), `{"address": "192.168.1.51"}`, nil)
+	if len(ms.Forgotten) != 1 || ms.Forgotten[0] != "192.168.1.50" {
+		t.Errorf("Expected the old address to be forgotten, got %v", ms.Forgotten)
+	}
+
+	ms.Bins[1] = models.Bin{ID: 1, Name: "A1", WLEDControllerID: created.ID}
+	rr = do(t, api, "DELETE", "/api/v1/controllers/"+strconv.Itoa(created.ID), "", nil)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected a controller with bins to stay, got %d", rr.Code)
+	}
+}
+
+func TestLocations(t *testing.T) {
+	h, ms, _ := setupTest(t)
+	ms.Parts[1] = models.Part{ID: 1, Name: "Resistor"}
+	ms.Bins[2] = models.Bin{ID: 2, Name: "A1"}
+	api := router(h, models.RoleEditor)
+
+	if rr := do(t, api, "POST", "/api/v1/parts/1/locations", `{"bin_id": 9, "quantity": 5}`, nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown bin to be rejected, got %d", rr.Code)
+	}
+	if rr := do(t, api, "POST", "/api/v1/parts/1/locations", `{"bin_id": 2, "quantity": -1}`, nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a negative quantity to be rejected, got %d", rr.Code)
+	}
+
+	var created location
+	rr := do(t, api, "POST", "/api/v1/parts/1/locations", `{"bin_id": 2, "quantity": 5, "capacity": 100}`, &created)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d %q", rr.Code, rr.Body.String())
+	}
+	if created.Quantity != 5 || created.BinName != "A1" || created.Capacity == nil || *created.Capacity != 100 || created.ReorderPoint != nil {
+		t.Errorf("Unexpected location %+v", created)
+	}
+
+	var updated location
+	do(t, api, "PATCH", "/api/v1/locations/"+strconv.Itoa(created.ID), `{"reorder_point": 10, "capacity": null}`, &updated)
+	if updated.Quantity != 5 || updated.ReorderPoint == nil || *updated.ReorderPoint != 10 || updated.Capacity != nil {
+		t.Errorf("Unexpected update %+v", updated)
+	}
+	if rr := do(t, api, "PATCH", "/api/v1/locations/"+strconv.Itoa(created.ID), `{"bin_id": 3}`, nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected moving a location to be rejected, got %d", rr.Code)
+	}
+
+	var page list[location]
+	do(t, api, "GET", "/api/v1/parts/1/locations", "", &page)
+	if page.Total != 1 {
+		t.Errorf("Expected 1 location, got %+v", page)
+	}
+	if rr := do(t, api, "GET", "/api/v1/parts/7/locations", "", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected a missing part to 404, got %d", rr.Code)
+	}
+	if rr := do(t, api, "DELETE", "/api/v1/locations/"+strconv.Itoa(created.ID), "", nil); rr.Code != http.StatusNoContent || len(ms.Locations) != 0 {
+		t.Errorf("Expected the location to be deleted, got %d", rr.Code)
+	}
+}
+
+func TestCategories(t *testing.T) {
+	h, ms, _ := setupTest(t)
+	ms.Parts[1] = models.Part{ID: 1, Name: "Resistor"}
+	editor := router(h, models.RoleEditor)
+	admin := router(h, models.RoleAdmin)
+
+	var passive, active category
+	do(t, editor, "POST", "/api/v1/categories", `{"name": "Passive"}`, &passive)
+	do(t, editor, "POST", "/api/v1/categories", `{"name": "Active"}`, &active)
+	if rr := do(t, editor, "PUT", "/api/v1/parts/1/categories/"+strconv.Itoa(passive.ID), "", nil); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected the category to be assigned, got %d", rr.Code)
+	}
+	if rr := do(t, editor, "PUT", "/api/v1/parts/1/categories/999", "", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown category to 404, got %d", rr.Code)
+	}
+	var page list[category]
+	do(t, editor, "GET", "/api/v1/parts/1/categories", "", &page)
+	if page.Total != 1 || page.Data[0].Name != "Passive" {
+		t.Errorf("Expected the part to be passive, got %+v", page)
+	}
+
+	if rr := do(t, editor, "PATCH", "/api/v1/categories/"+strconv.Itoa(active.ID), `{"name": "Semis"}`, nil); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected renaming to need the admin role, got %d", rr.Code)
+	}
+	rr := do(t, admin, "PATCH", "/api/v1/categories/"+strconv.Itoa(active.ID), `{"name": "Passive"}`, nil)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected a duplicate name to conflict, got %d", rr.Code)
+	}
+	do(t, admin, "PATCH", "/api/v1/categories/"+strconv.Itoa(active.ID), `{"name": "Semis"}`, nil)
+	do(t, admin, "GET", "/api/v1/categories?q=sem", "", &page)
+	if page.Total != 1 || page.Data[0].Name != "Semis" {
+		t.Errorf("Expected the renamed category, got %+v", page)
+	}
+
+	if rr := do(t, editor, "DELETE", "/api/v1/parts/1/categories/"+strconv.Itoa(passive.ID), "", nil); rr.Code != http.StatusNoContent || len(ms.PartCats[1]) != 0 {
+		t.Errorf("Expected the category to be removed, got %d", rr.Code)
+	}
+}
+
+func TestURLs(t *testing.T) {
+	h, ms, _ := setupTest(t)
+	ms.Parts[1] = models.Part{ID: 1, Name: "Resistor"}
+	api := router(h, models.RoleEditor)
+
+	for _, link := range []string{`""`, `"javascript:alert(1)"`, `"datasheet.pdf"`} {
+		if rr := do(t, api, "POST", "/api/v1/parts/1/urls", `{"url": `+link+`}`, nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", link, rr.Code)
+		}
+	}
+	var created partURL
+	rr := do(t, api, "POST", "/api/v1/parts/1/urls", `{"url": "https://example.com/r.pdf", "description": "Datasheet"}`, &created)
+	if rr.Code != http.StatusCreated || created.Description != "Datasheet" {
+		t.Fatalf("Expected the link, got %d %q", rr.Code, rr.Body.String())
+	}
+	var updated partURL
+	do(t, api, "PATCH", "/api/v1/urls/"+strconv.Itoa(created.ID), `{"description": "Old datasheet"}`, &updated)
+	if updated.URL != "https://example.com/r.pdf" || updated.Description != "Old datasheet" {
+		t.Errorf("Unexpected update %+v", updated)
+	}
+}
+
+func TestDocuments(t *testing.T) {
+	h, ms, uploadDir := setupTest(t)
+	ms.Parts[1] = models.Part{ID: 1, Name: "Resistor"}
+	api := router(h, models.RoleEditor)
+
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	file, _ := form.CreateFormFile("file", "datasheet.pdf")
+	file.Write([]byte("%PDF"))
+	form.WriteField("description", "Datasheet")
+	form.Close()
+	req := httptest.NewRequest("POST", "/api/v1/parts/1/documents", body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rr := httptest.NewRecorder()
+	api.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d %q", rr.Code, rr.Body.String())
+	}
+	var created document
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	if created.Filename != "datasheet.pdf" || created.Description != "Datasheet" || created.ContentURL != "/api/v1/documents/"+strconv.Itoa(created.ID)+"/content" {
+		t.Errorf("Unexpected document %+v", created)
+	}
+
+	rr = do(t, api, "GET", created.ContentURL, "", nil)
+	if rr.Body.String() != "%PDF" {
+		t.Errorf("Expected the file, got %q", rr.Body.String())
+	}
+
+	var updated document
+	do(t, api, "PATCH", "/api/v1/documents/"+strconv.Itoa(created.ID), `{"filename": "r.pdf"}`, &updated)
+	if updated.Filename != "r.pdf" || updated.Description != "Datasheet" {
+		t.Errorf("Unexpected update %+v", updated)
+	}
+
+	rr = do(t, api, "POST", "/api/v1/parts/1/documents", `{"file": "datasheet.pdf"}`, nil)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected JSON to be rejected, got %d", rr.Code)
+	}
+
+	path := filepath.Join(uploadDir, ms.Documents[created.ID].Filepath)
+	if rr := do(t, api, "DELETE", "/api/v1/documents/"+strconv.Itoa(created.ID), "", nil); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", rr.Code)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Expected the file to be deleted")
+	}
+}
@@ -0,0 +1,761 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"golang.org/x/crypto/bcrypt"
+
+	"wledger/internal/core"
+	"wledger/internal/models"
+	"wledger/internal/store"
+)
+
+// SessionCookie holds the token of the browser's session
+const SessionCookie = "wledger_session"
+
+// VisitorCookie holds a token for a browser that isn't signed in, so the
+// login and setup forms have a CSRF token too
+const VisitorCookie = "wledger_visitor"
+
+// minPasswordLength is the shortest password accepted
+const minPasswordLength = 8
+
+// TokenPrefix starts every API token, so they're easy to spot in scripts
+const TokenPrefix = "wlg_"
+
+// defaultTokenLifetime is the expiry date the token form suggests
+const defaultTokenLifetime = 90 * 24 * time.Hour
+
+// tokenEventsShown is how many audit log entries the tokens page lists
+const tokenEventsShown = 50
+
+// Store defines the database methods this module needs
+type Store interface {
+	CountUsers(ctx context.Context) (int, error)
+	GetUsers(ctx context.Context) ([]models.User, error)
+	GetUserByID(ctx context.Context, id int) (models.User, error)
+	GetUserByUsername(ctx context.Context, username string) (models.User, error)
+	CreateUser(ctx context.Context, u *models.User) error
+	CreateFirstUser(ctx context.Context, u *models.User) error
+	UpdateUserRole(ctx context.Context, id int, role models.Role) error
+	UpdateUserPassword(ctx context.Context, id int, passwordHash string) error
+	DeleteUser(ctx context.Context, id int) error
+
+	CreateSession(ctx context.Context, tokenHash string, userID int, expires time.Time) error
+	GetSessionUser(ctx context.Context, tokenHash string) (models.User, error)
+	DeleteSession(ctx context.Context, tokenHash string) error
+
+	GetAPITokens(ctx context.Context, userID int) ([]models.APIToken, error)
+	GetAPITokenByID(ctx context.Context, id int) (models.APIToken, error)
+	GetAPITokenByHash(ctx context.Context, tokenHash string) (models.APIToken, models.User, error)
+	CreateAPIToken(ctx context.Context, t *models.APIToken, tokenHash string) error
+	TouchAPIToken(ctx context.Context, id int, at time.Time) error
+	DeleteAPIToken(ctx context.Context, id int) error
+	RecordAPITokenEvent(ctx context.Context, e *models.APITokenEvent) error
+	GetAPITokenEvents(ctx context.Context, userID, limit int) ([]models.APITokenEvent, error)
+}
+
+// userRow is a user in the user management table
+type userRow struct {
+	models.User
+	Roles []models.Role
+	Self  bool // The signed in user
+}
+
+func newUserRow(u, current models.User) userRow {
+	return userRow{User: u, Roles: models.Roles, Self: u.ID == current.ID}
+}
+
+type Handler struct {
+	store           Store
+	templates       core.TemplateExecutor
+	sessionLifetime time.Duration
+}
+
+func New(s Store, t core.TemplateExecutor, sessionLifetime time.Duration) *Handler {
+	return &Handler{store: s, templates: t, sessionLifetime: sessionLifetime}
+}
+
+func (h *Handler) RegisterRoutes(r chi.Router) {
+	// Signing in, and creating the first admin
+	r.Get("/login", h.handleShowLogin)
+	r.Post("/login", h.handleLogin)
+	r.Post("/logout", h.handleLogout)
+	r.Get("/setup", h.handleShowSetup)
+	r.Post("/setup", h.handleSetup)
+
+	r.With(core.Require(models.RoleViewer)).Get("/account", h.handleGetAccountMenu)
+
+	// API tokens, everyone manages their own
+	r.Group(func(r chi.Router) {
+		r.Use(core.Require(models.RoleViewer), requireSession)
+		r.Get("/settings/tokens", h.handleShowTokens)
+		r.Post("/settings/tokens", h.handleCreateToken)
+		r.Delete("/settings/tokens/{id}", h.handleDeleteToken)
+	})
+
+	// User management
+	r.Group(func(r chi.Router) {
+		r.Use(core.Require(models.RoleAdmin), requireSession)
+		r.Get("/settings/users", h.handleShowUsers)
+		r.Post("/settings/users", h.handleCreateUser)
+		r.Put("/settings/users/{id}", h.handleUpdateUser)
+		r.Delete("/settings/users/{id}", h.handleDeleteUser)
+	})
+}
+
+// Authenticate is middleware that signs the request in to the user of
+// its session cookie, if it has a current one. It lets every request
+// through, core.Require decides what needs signing in. Requests with an
+// Authorization header are signed in by their API token instead, and
+// rejected if it isn't valid.
+//
+// It also gives the request its CSRF token, which is derived from the
+// session token, or from the visitor token if nobody is signed in.
+func (h *Handler) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if header := r.Header.Get("Authorization"); header != "" {
+			h.authenticateToken(w, r, next, header)
+			return
+		}
+
+		c, err := r.Cookie(SessionCookie)
+		if err != nil || c.Value == "" {
+			next.ServeHTTP(w, withVisitor(w, r))
+			return
+		}
+		u, err := h.store.GetSessionUser(r.Context(), hashToken(c.Value))
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// Expired or signed out, the login page will replace it
+			r = withVisitor(w, r)
+		case err != nil:
+			core.ServerError(w, r, err)
+			return
+		default:
+			ctx := core.WithUser(r.Context(), u)
+			r = r.WithContext(core.WithCSRFToken(ctx, csrfToken(c.Value)))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withVisitor gives a request that isn't signed in the CSRF token of its
+// browser's visitor token, handing the browser one if it has none
+func withVisitor(w http.ResponseWriter, r *http.Request) *http.Request {
+	token := ""
+	if c, err := r.Cookie(VisitorCookie); err == nil && c.Value != "" {
+		token = c.Value
+	} else {
+		var err error
+		if token, err = newToken(); err != nil {
+			// The request goes on without one, so only a form post fails
+			log.Printf("Failed to create a visitor token: %v", err)
+			return r
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     VisitorCookie,
+			Value:    token,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return r.WithContext(core.WithCSRFToken(r.Context(), csrfToken(token)))
+}
+
+// authenticateToken serves a request made with an API token. Its use is
+// recorded, and requests that change something are audited.
+func (h *Handler) authenticateToken(w http.ResponseWriter, r *http.Request, next http.Handler, header string) {
+	raw, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		tokenError(w, r, "Authorization must be a Bearer token")
+		return
+	}
+	t, u, err := h.store.GetAPITokenByHash(r.Context(), hashToken(strings.TrimSpace(raw)))
+	if errors.Is(err, sql.ErrNoRows) {
+		tokenError(w, r, "Invalid API token")
+		return
+	}
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+
+	now := time.Now()
+	if !now.Before(t.ExpiresAt) {
+		h.audit(r, t, "rejected", "expired, "+r.Method+" "+r.URL.Path)
+		tokenError(w, r, "API token expired")
+		return
+	}
+	if err := h.store.TouchAPIToken(r.Context(), t.ID, now); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+
+	r = r.WithContext(core.WithToken(core.WithUser(r.Context(), u), t))
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		next.ServeHTTP(w, r)
+		return
+	}
+	ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+	next.ServeHTTP(ww, r)
+	status := ww.Status()
+	if status == 0 {
+		status = http.StatusOK
+	}
+	h.audit(r, t, "used", fmt.Sprintf("%s %s: %d", r.Method, r.URL.Path, status))
+}
+
+// requireSession is middleware that keeps API tokens out, so a leaked
+// token can't be used to make more or to manage users
+func requireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := core.CurrentToken(r); ok {
+			core.ClientError(w, r, http.StatusForbidden, "API tokens can't be used for this", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Handlers
+
+func (h *Handler) handleShowLogin(w http.ResponseWriter, r *http.Request) {
+	count, err := h.store.CountUsers(r.Context())
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	if count == 0 {
+		http.Redirect(w, r, "/setup", http.StatusSeeOther)
+		return
+	}
+	h.renderLogin(w, r, http.StatusOK, "")
+}
+
+func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		core.ClientError(w, r, http.StatusBadRequest, "Bad Request", err)
+		return
+	}
+
+	u, err := h.store.GetUserByUsername(r.Context(), normalizeUsername(r.FormValue("username")))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		core.ServerError(w, r, err)
+		return
+	}
+	if !checkPassword(u, r.FormValue("password")) {
+		h.renderLogin(w, r, http.StatusUnauthorized, "Wrong username or password.")
+		return
+	}
+
+	if err := h.startSession(w, r, u); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, localPath(r.FormValue("next")), http.StatusSeeOther)
+}
+
+func (h *Handler) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(SessionCookie); err == nil && c.Value != "" {
+		if err := h.store.DeleteSession(r.Context(), hashToken(c.Value)); err != nil {
+			core.ServerError(w, r, err)
+			return
+		}
+	}
+	setSessionCookie(w, r, "", -1)
+	http.Redirect(w, r, core.LoginPath, http.StatusSeeOther)
+}
+
+func (h *Handler) handleShowSetup(w http.ResponseWriter, r *http.Request) {
+	count, err := h.store.CountUsers(r.Context())
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	if count > 0 {
+		http.Redirect(w, r, core.LoginPath, http.StatusSeeOther)
+		return
+	}
+	h.renderSetup(w, r, http.StatusOK, "", "")
+}
+
+// handleSetup creates the first admin, only while there are no users
+func (h *Handler) handleSetup(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		core.ClientError(w, r, http.StatusBadRequest, "Bad Request", err)
+		return
+	}
+
+	username := normalizeUsername(r.FormValue("username"))
+	password := r.FormValue("password")
+	if msg := validateCredentials(username, password); msg != "" {
+		h.renderSetup(w, r, http.StatusBadRequest, username, msg)
+		return
+	}
+	if password != r.FormValue("confirm") {
+		h.renderSetup(w, r, http.StatusBadRequest, username, "The passwords don't match.")
+		return
+	}
+
+	u := &models.User{Username: username, Role: models.RoleAdmin}
+	if err := h.setPassword(u, password); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	if err := h.store.CreateFirstUser(r.Context(), u); err != nil {
+		if errors.Is(err, store.ErrUsersExist) {
+			http.Redirect(w, r, core.LoginPath, http.StatusSeeOther)
+		} else {
+			core.ServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := h.startSession(w, r, *u); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// handleGetAccountMenu renders the signed in user's part of the nav bar
+func (h *Handler) handleGetAccountMenu(w http.ResponseWriter, r *http.Request) {
+	u, _ := core.CurrentUser(r)
+	data := map[string]any{
+		"User":      u,
+		"CSRFToken": core.CSRFToken(r),
+	}
+	if err := h.templates.ExecuteTemplate(w, "_account-menu.html", data); err != nil {
+		core.ServerError(w, r, err)
+	}
+}
+
+// handleShowTokens lists the user's API tokens and their audit log.
+// Admins see everyone's.
+func (h *Handler) handleShowTokens(w http.ResponseWriter, r *http.Request) {
+	h.renderTokens(w, r, "")
+}
+
+func (h *Handler) handleCreateToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		core.ClientError(w, r, http.StatusBadRequest, "Bad Request", err)
+		return
+	}
+	u, _ := core.CurrentUser(r)
+
+	t := &models.APIToken{UserID: u.ID, Username: u.Username, Name: strings.TrimSpace(r.FormValue("name"))}
+	if t.Name == "" {
+		core.ClientError(w, r, http.StatusBadRequest, "Token name is required", nil)
+		return
+	}
+	expires, err := time.ParseInLocation(time.DateOnly, r.FormValue("expires"), time.Local)
+	if err != nil {
+		core.ClientError(w, r, http.StatusBadRequest, "Invalid expiry date", err)
+		return
+	}
+	// Valid through the expiry date
+	t.ExpiresAt = expires.AddDate(0, 0, 1)
+	if !t.ExpiresAt.After(time.Now()) {
+		core.ClientError(w, r, http.StatusBadRequest, "The expiry date has passed", nil)
+		return
+	}
+	for _, s := range r.Form["scopes"] {
+		scope := models.Scope(s)
+		if !slices.Contains(models.Scopes, scope) || !core.RoleAllows(u.Role, core.ScopeRole(scope)) {
+			core.ClientError(w, r, http.StatusBadRequest, "Invalid scope "+s, nil)
+			return
+		}
+		t.Scopes = append(t.Scopes, scope)
+	}
+	if len(t.Scopes) == 0 {
+		core.ClientError(w, r, http.StatusBadRequest, "Pick at least one scope", nil)
+		return
+	}
+
+	secret, err := newToken()
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	token := TokenPrefix + secret
+	t.Prefix = token[:len(TokenPrefix)+8]
+	if err := h.store.CreateAPIToken(r.Context(), t, hashToken(token)); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	h.audit(r, *t, "created", fmt.Sprintf("scopes %s, expires %s", joinScopes(t.Scopes), expires.Format(time.DateOnly)))
+
+	// The token itself is only ever shown now
+	h.renderTokens(w, r, token)
+}
+
+// handleDeleteToken revokes a token. Admins can revoke anyone's.
+func (h *Handler) handleDeleteToken(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	u, _ := core.CurrentUser(r)
+
+	t, err := h.store.GetAPITokenByID(r.Context(), id)
+	if err != nil || (t.UserID != u.ID && u.Role != models.RoleAdmin) {
+		core.ClientError(w, r, http.StatusNotFound, "Token not found", err)
+		return
+	}
+	if err := h.store.DeleteAPIToken(r.Context(), id); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	h.audit(r, t, "revoked", "by "+u.Username)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) handleShowUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := h.store.GetUsers(r.Context())
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	current, _ := core.CurrentUser(r)
+	rows := make([]userRow, len(users))
+	for i, u := range users {
+		rows[i] = newUserRow(u, current)
+	}
+	data := map[string]any{
+		"Title":     "Users",
+		"CSRFToken": core.CSRFToken(r),
+		"Users":     rows,
+		"Roles":     models.Roles,
+	}
+	if err := h.templates.ExecuteTemplate(w, "users.html", data); err != nil {
+		core.ServerError(w, r, err)
+	}
+}
+
+func (h *Handler) handleCreateUser(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		core.ClientError(w, r, http.StatusBadRequest, "Bad Request", err)
+		return
+	}
+
+	u := &models.User{
+		Username: normalizeUsername(r.FormValue("username")),
+		Role:     models.Role(r.FormValue("role")),
+	}
+	password := r.FormValue("password")
+	if msg := validateCredentials(u.Username, password); msg != "" {
+		core.ClientError(w, r, http.StatusBadRequest, msg, nil)
+		return
+	}
+	if !core.ValidRole(u.Role) {
+		core.ClientError(w, r, http.StatusBadRequest, "Invalid role", nil)
+		return
+	}
+	if err := h.setPassword(u, password); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+
+	if err := h.store.CreateUser(r.Context(), u); err != nil {
+		if errors.Is(err, store.ErrUniqueConstraint) {
+			core.ClientError(w, r, http.StatusConflict, "A user with this name already exists.", err)
+		} else {
+			core.ServerError(w, r, err)
+		}
+		return
+	}
+	http.Redirect(w, r, "/settings/users", http.StatusSeeOther)
+}
+
+// handleUpdateUser changes a user's role, and their password if a new
+// one is given
+func (h *Handler) handleUpdateUser(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	if err := r.ParseForm(); err != nil {
+		core.ClientError(w, r, http.StatusBadRequest, "Bad Request", err)
+		return
+	}
+
+	u, err := h.store.GetUserByID(r.Context(), id)
+	if err != nil {
+		core.ClientError(w, r, http.StatusNotFound, "User not found", err)
+		return
+	}
+	role := models.Role(r.FormValue("role"))
+	if !core.ValidRole(role) {
+		core.ClientError(w, r, http.StatusBadRequest, "Invalid role", nil)
+		return
+	}
+	password := r.FormValue("password")
+	if password != "" {
+		if msg := validateCredentials(u.Username, password); msg != "" {
+			core.ClientError(w, r, http.StatusBadRequest, msg, nil)
+			return
+		}
+	}
+
+	if role != u.Role {
+		if err := h.store.UpdateUserRole(r.Context(), id, role); err != nil {
+			userError(w, r, err)
+			return
+		}
+		u.Role = role
+	}
+	if password != "" {
+		if err := h.setPassword(&u, password); err != nil {
+			core.ServerError(w, r, err)
+			return
+		}
+		if err := h.store.UpdateUserPassword(r.Context(), id, u.PasswordHash); err != nil {
+			core.ServerError(w, r, err)
+			return
+		}
+	}
+
+	current, _ := core.CurrentUser(r)
+	if err := h.templates.ExecuteTemplate(w, "_user-row.html", newUserRow(u, current)); err != nil {
+		core.ServerError(w, r, err)
+	}
+}
+
+func (h *Handler) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	if id == 0 {
+		core.ClientError(w, r, http.StatusBadRequest, "Invalid ID", nil)
+		return
+	}
+	if err := h.store.DeleteUser(r.Context(), id); err != nil {
+		userError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Helpers
+
+func (h *Handler) renderLogin(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	data := map[string]any{
+		"Title":     "Sign In",
+		"CSRFToken": core.CSRFToken(r),
+		"SignedOut": true,
+		"Next":      localPath(r.FormValue("next")),
+		"Username":  r.FormValue("username"),
+		"Error":     msg,
+	}
+	w.WriteHeader(status)
+	if err := h.templates.ExecuteTemplate(w, "login.html", data); err != nil {
+		core.ServerError(w, r, err)
+	}
+}
+
+func (h *Handler) renderSetup(w http.ResponseWriter, r *http.Request, status int, username, msg string) {
+	data := map[string]any{
+		"Title":             "Setup",
+		"CSRFToken":         core.CSRFToken(r),
+		"SignedOut":         true,
+		"Username":          username,
+		"MinPasswordLength": minPasswordLength,
+		"Error":             msg,
+	}
+	w.WriteHeader(status)
+	if err := h.templates.ExecuteTemplate(w, "setup.html", data); err != nil {
+		core.ServerError(w, r, err)
+	}
+}
+
+func (h *Handler) renderTokens(w http.ResponseWriter, r *http.Request, newToken string) {
+	u, _ := core.CurrentUser(r)
+	userID := u.ID
+	if u.Role == models.RoleAdmin {
+		userID = 0
+	}
+	tokens, err := h.store.GetAPITokens(r.Context(), userID)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	events, err := h.store.GetAPITokenEvents(r.Context(), userID, tokenEventsShown)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+
+	// Users can only give their tokens the scopes of their role
+	scopes := []models.Scope{}
+	for _, s := range models.Scopes {
+		if core.RoleAllows(u.Role, core.ScopeRole(s)) {
+			scopes = append(scopes, s)
+		}
+	}
+	data := map[string]any{
+		"Title":         "API Tokens",
+		"CSRFToken":     core.CSRFToken(r),
+		"Tokens":        tokens,
+		"Events":        events,
+		"Scopes":        scopes,
+		"AllUsers":      userID == 0,
+		"NewToken":      newToken,
+		"DefaultExpiry": time.Now().Add(defaultTokenLifetime).Format(time.DateOnly),
+	}
+	if err := h.templates.ExecuteTemplate(w, "tokens.html", data); err != nil {
+		core.ServerError(w, r, err)
+	}
+}
+
+// audit records an event in the API token audit log. A failure is only
+// logged, the request has already been handled.
+func (h *Handler) audit(r *http.Request, t models.APIToken, event, detail string) {
+	e := &models.APITokenEvent{
+		TokenID:   t.ID,
+		TokenName: t.Name,
+		UserID:    t.UserID,
+		Username:  t.Username,
+		At:        time.Now(),
+		Event:     event,
+		Detail:    detail,
+	}
+	if err := h.store.RecordAPITokenEvent(context.WithoutCancel(r.Context()), e); err != nil {
+		log.Printf("Failed to record API token event: %v", err)
+	}
+}
+
+// startSession signs the browser in as u
+func (h *Handler) startSession(w http.ResponseWriter, r *http.Request, u models.User) error {
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	if err := h.store.CreateSession(r.Context(), hashToken(token), u.ID, time.Now().Add(h.sessionLifetime)); err != nil {
+		return err
+	}
+	setSessionCookie(w, r, token, int(h.sessionLifetime.Seconds()))
+	return nil
+}
+
+func (h *Handler) setPassword(u *models.User, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	u.PasswordHash = string(hash)
+	return nil
+}
+
+// dummyHash is compared against when there's no such user, so a wrong
+// username takes as long as a wrong password
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+// checkPassword reports whether password is u's. u is the zero User if
+// there's no such user.
+func checkPassword(u models.User, password string) bool {
+	if u.ID == 0 {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil
+}
+
+// validateCredentials returns what's wrong with a new username and
+// password, or "" if nothing is
+func validateCredentials(username, password string) string {
+	switch {
+	case username == "":
+		return "A username is required."
+	case len(password) < minPasswordLength:
+		return "The password needs at least " + strconv.Itoa(minPasswordLength) + " characters."
+	case len(password) > 72:
+		// bcrypt ignores the rest
+		return "The password can't be longer than 72 bytes."
+	}
+	return ""
+}
+
+// Usernames aren't case sensitive
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// localPath returns next if it's a path on this server, otherwise "/", so
+// the login page can't send anyone elsewhere
+func localPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// newToken returns a random session token
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is what a session is stored as, so the sessions table can't
+// be used to sign in
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// csrfToken derives a browser's CSRF token from its session or visitor
+// token. Pages can show it, as it doesn't give the token away.
+func csrfToken(token string) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte("csrf"))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// setSessionCookie stores the session token in the browser. A negative
+// maxAge removes it.
+func setSessionCookie(w http.ResponseWriter, r *http.Request, token string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// tokenError rejects a request with a missing, invalid or expired API token
+func tokenError(w http.ResponseWriter, r *http.Request, msg string) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	core.ClientError(w, r, http.StatusUnauthorized, msg, nil)
+}
+
+func joinScopes(scopes []models.Scope) string {
+	names := make([]string, len(scopes))
+	for i, s := range scopes {
+		names[i] = string(s)
+	}
+	return strings.Join(names, " ")
+}
+
+func userError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, store.ErrLastAdmin) {
+		core.ClientError(w, r, http.StatusConflict, "At least one admin is required.", err)
+	} else {
+		core.ServerError(w, r, err)
+	}
+}
@@ -0,0 +1,556 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/crypto/bcrypt"
+
+	"wledger/internal/core"
+	"wledger/internal/models"
+	"wledger/internal/store"
+)
+
+// Local mocks
+type mockStore struct {
+	FailOps bool
+
+	Users    map[int]models.User
+	Sessions map[string]int // Token hash to user ID
+	nextID   int
+
+	Tokens      map[string]models.APIToken // By hash
+	Events      []models.APITokenEvent
+	nextTokenID int
+}
+
+func (m *mockStore) CountUsers(ctx context.Context) (int, error) {
+	if m.FailOps {
+		return 0, errors.New("db error")
+	}
+	return len(m.Users), nil
+}
+func (m *mockStore) GetUsers(ctx context.Context) ([]models.User, error) {
+	var users []models.User
+	for i := 1; i <= m.nextID; i++ {
+		if u, ok := m.Users[i]; ok {
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
+func (m *mockStore) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	u, ok := m.Users[id]
+	if !ok {
+		return u, sql.ErrNoRows
+	}
+	return u, nil
+}
+func (m *mockStore) GetUserByUsername(ctx context.Context, username string) (models.User, error) {
+	for _, u := range m.Users {
+		if u.Username == username {
+			return u, nil
+		}
+	}
+	return models.User{}, sql.ErrNoRows
+}
+func (m *mockStore) CreateUser(ctx context.Context, u *models.User) error {
+	if _, err := m.GetUserByUsername(ctx, u.Username); err == nil {
+		return store.ErrUniqueConstraint
+	}
+	m.nextID++
+	u.ID = m.nextID
+	m.Users[u.ID] = *u
+	return nil
+}
+func (m *mockStore) CreateFirstUser(ctx context.Context, u *models.User) error {
+	if len(m.Users) > 0 {
+		return store.ErrUsersExist
+	}
+	return m.CreateUser(ctx, u)
+}
+func (m *mockStore) UpdateUserRole(ctx context.Context, id int, role models.Role) error {
+	u := m.Users[id]
+	u.Role = role
+	if role != models.RoleAdmin && m.admins(id) == 0 {
+		return store.ErrLastAdmin
+	}
+	m.Users[id] = u
+	return nil
+}
+func (m *mockStore) UpdateUserPassword(ctx context.Context, id int, passwordHash string) error {
+	u := m.Users[id]
+	u.PasswordHash = passwordHash
+	m.Users[id] = u
+	return nil
+}
+func (m *mockStore) DeleteUser(ctx context.Context, id int) error {
+	if m.admins(id) == 0 {
+		return store.ErrLastAdmin
+	}
+	delete(m.Users, id)
+	return nil
+}
+func (m *mockStore) CreateSession(ctx context.Context, tokenHash string, userID int, expires time.Time) error {
+	m.Sessions[tokenHash] = userID
+	return nil
+}
+func (m *mockStore) GetSessionUser(ctx context.Context, tokenHash string) (models.User, error) {
+	if m.FailOps {
+		return models.User{}, errors.New("db error")
+	}
+	id, ok := m.Sessions[tokenHash]
+	if !ok {
+		return models.User{}, sql.ErrNoRows
+	}
+	return m.Users[id], nil
+}
+func (m *mockStore) DeleteSession(ctx context.Context, tokenHash string) error {
+	delete(m.Sessions, tokenHash)
+	return nil
+}
+
+func (m *mockStore) GetAPITokens(ctx context.Context, userID int) ([]models.APIToken, error) {
+	var tokens []models.APIToken
+	for _, t := range m.Tokens {
+		if userID == 0 || t.UserID == userID {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens, nil
+}
+func (m *mockStore) GetAPITokenByID(ctx context.Context, id int) (models.APIToken, error) {
+	for _, t := range m.Tokens {
+		if t.ID == id {
+			return t, nil
+		}
+	}
+	return models.APIToken{}, sql.ErrNoRows
+}
+func (m *mockStore) GetAPITokenByHash(ctx context.Context, tokenHash string) (models.APIToken, models.User, error) {
+	t, ok := m.Tokens[tokenHash]
+	if !ok {
+		return t, models.User{}, sql.ErrNoRows
+	}
+	return t, m.Users[t.UserID], nil
+}
+func (m *mockStore) CreateAPIToken(ctx context.Context, t *models.APIToken, tokenHash string) error {
+	m.nextTokenID++
+	t.ID = m.nextTokenID
+	m.Tokens[tokenHash] = *t
+	return nil
+}
+func (m *mockStore) TouchAPIToken(ctx context.Context, id int, at time.Time) error {
+	for hash, t := range m.Tokens {
+		if t.ID == id {
+			t.LastUsedAt = sql.NullTime{Time: at, Valid: true}
+			m.Tokens[hash] = t
+		}
+	}
+	return nil
+}
+func (m *mockStore) DeleteAPIToken(ctx context.Context, id int) error {
+	for hash, t := range m.Tokens {
+		if t.ID == id {
+			delete(m.Tokens, hash)
+		}
+	}
+	return nil
+}
+func (m *mockStore) RecordAPITokenEvent(ctx context.Context, e *models.APITokenEvent) error {
+	m.Events = append(m.Events, *e)
+	return nil
+}
+func (m *mockStore) GetAPITokenEvents(ctx context.Context, userID, limit int) ([]models.APITokenEvent, error) {
+	return m.Events, nil
+}
+
+// admins counts the admins other than the given user
+func (m *mockStore) admins(except int) int {
+	n := 0
+	for id, u := range m.Users {
+		if id != except && u.Role == models.RoleAdmin {
+			n++
+		}
+	}
+	return n
+}
+
+// Test Setup Helper
+func setupTest(t *testing.T) (http.Handler, *mockStore) {
+	t.Helper()
+	ms := &mockStore{Users: map[int]models.User{}, Sessions: map[string]int{}, Tokens: map[string]models.APIToken{}}
+	tmpl, err := template.ParseGlob("../../../ui/templates/*.html")
+	if err != nil {
+		t.Fatalf("Failed to parse templates: %v", err)
+	}
+	h := New(ms, tmpl, time.Hour)
+	r := chi.NewRouter()
+	r.Use(h.Authenticate)
+	h.RegisterRoutes(r)
+
+	// Stands in for the other modules' routes
+	r.With(core.Require(models.RoleViewer)).Post("/locate/part/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return r, ms
+}
+
+// addUser creates a user with the password "password"
+func addUser(t *testing.T, ms *mockStore, username string, role models.Role) models.User {
+	t.Helper()
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	u := &models.User{Username: username, PasswordHash: string(hash), Role: role}
+	if err := ms.CreateUser(t.Context(), u); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	return *u
+}
+
+// signIn returns the session cookie of a new session for u
+func signIn(ms *mockStore, u models.User) *http.Cookie {
+	token, _ := newToken()
+	ms.Sessions[hashToken(token)] = u.ID
+	return &http.Cookie{Name: SessionCookie, Value: token}
+}
+
+func serve(r http.Handler, req *http.Request, cookie *http.Cookie) *httptest.ResponseRecorder {
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func formRequest(method, target string, form url.Values) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func sessionCookie(rr *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == SessionCookie {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestHandleSetup(t *testing.T) {
+	r, ms := setupTest(t)
+
+	// The login page sends the first visitor to setup
+	rr := serve(r, httptest.NewRequest("GET", "/login", nil), nil)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/setup" {
+		t.Fatalf("Expected a redirect to setup, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if rr := serve(r, httptest.NewRequest("GET", "/setup", nil), nil); rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", rr.Code)
+	}
+
+	rr = serve(r, formRequest("POST", "/setup", url.Values{"username": {"Alice"}, "password": {"correct horse"}, "confirm": {"correct hose"}}), nil)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "don&#39;t match") {
+		t.Errorf("Mismatched passwords: got %d", rr.Code)
+	}
+	rr = serve(r, formRequest("POST", "/setup", url.Values{"username": {"Alice"}, "password": {"short"}, "confirm": {"short"}}), nil)
+	if rr.Code != http.StatusBadRequest || len(ms.Users) != 0 {
+		t.Errorf("Short password: got %d", rr.Code)
+	}
+
+	rr = serve(r, formRequest("POST", "/setup", url.Values{"username": {" Alice "}, "password": {"correct horse"}, "confirm": {"correct horse"}}), nil)
+	if rr.Code != http.StatusSeeOther || sessionCookie(rr) == nil {
+		t.Fatalf("Expected to be signed in, got %d", rr.Code)
+	}
+	admin := ms.Users[1]
+	if admin.Username != "alice" || admin.Role != models.RoleAdmin {
+		t.Errorf("Expected the admin alice, got %+v", admin)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(admin.PasswordHash), []byte("correct horse")) != nil {
+		t.Errorf("Expected a bcrypt hash of the password, got %q", admin.PasswordHash)
+	}
+
+	// Setup only runs once
+	rr = serve(r, formRequest("POST", "/setup", url.Values{"username": {"mallory"}, "password": {"correct horse"}, "confirm": {"correct horse"}}), nil)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != core.LoginPath || len(ms.Users) != 1 {
+		t.Errorf("Expected setup to be closed, got %d and %d users", rr.Code, len(ms.Users))
+	}
+	if rr := serve(r, httptest.NewRequest("GET", "/setup", nil), nil); rr.Code != http.StatusSeeOther {
+		t.Errorf("Expected the setup page to redirect, got %d", rr.Code)
+	}
+}
+
+func TestHandleLogin(t *testing.T) {
+	r, ms := setupTest(t)
+	addUser(t, ms, "alice", models.RoleViewer)
+
+	rr := serve(r, formRequest("POST", "/login", url.Values{"username": {"alice"}, "password": {"wrong"}}), nil)
+	if rr.Code != http.StatusUnauthorized || sessionCookie(rr) != nil {
+		t.Errorf("Wrong password: got %d", rr.Code)
+	}
+	rr = serve(r, formRequest("POST", "/login", url.Values{"username": {"bob"}, "password": {"password"}}), nil)
+	if rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Body.String(), "Wrong username or password") {
+		t.Errorf("Unknown user: got %d", rr.Code)
+	}
+
+	rr = serve(r, formRequest("POST", "/login", url.Values{"username": {"Alice"}, "password": {"password"}, "next": {"/part/3"}}), nil)
+	cookie := sessionCookie(rr)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/part/3" || cookie == nil {
+		t.Fatalf("Expected to be signed in and sent on, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if !cookie.HttpOnly || cookie.MaxAge != 3600 || len(ms.Sessions) != 1 {
+		t.Errorf("Unexpected session cookie %+v", cookie)
+	}
+	if _, ok := ms.Sessions[cookie.Value]; ok {
+		t.Errorf("Expected the session to be stored by the hash of its token")
+	}
+
+	// Signed in with the cookie
+	rr = serve(r, httptest.NewRequest("GET", "/account", nil), cookie)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "alice") {
+		t.Errorf("Expected the account menu, got %d", rr.Code)
+	}
+
+	// Signing out ends the session
+	rr = serve(r, httptest.NewRequest("POST", "/logout", nil), cookie)
+	if rr.Code != http.StatusSeeOther || len(ms.Sessions) != 0 || sessionCookie(rr).MaxAge >= 0 {
+		t.Errorf("Expected to be signed out, got %d", rr.Code)
+	}
+	if rr := serve(r, httptest.NewRequest("GET", "/account", nil), cookie); rr.Code != http.StatusSeeOther {
+		t.Errorf("Signed out: got %d, want a redirect to the login page", rr.Code)
+	}
+}
+
+func TestAuthenticate_DBError(t *testing.T) {
+	r, ms := setupTest(t)
+	cookie := signIn(ms, addUser(t, ms, "alice", models.RoleAdmin))
+
+	ms.FailOps = true
+	if rr := serve(r, httptest.NewRequest("GET", "/account", nil), cookie); rr.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want 500", rr.Code)
+	}
+}
+
+func TestHandleUsers(t *testing.T) {
+	r, ms := setupTest(t)
+	admin := signIn(ms, addUser(t, ms, "alice", models.RoleAdmin))
+	viewer := signIn(ms, addUser(t, ms, "bob", models.RoleViewer))
+
+	// Only admins manage users
+	if rr := serve(r, httptest.NewRequest("GET", "/settings/users", nil), viewer); rr.Code != http.StatusForbidden {
+		t.Errorf("Viewer: got status %d, want 403", rr.Code)
+	}
+	rr := serve(r, httptest.NewRequest("GET", "/settings/users", nil), admin)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "bob") {
+		t.Errorf("Expected the user list, got %d", rr.Code)
+	}
+
+	rr = serve(r, formRequest("POST", "/settings/users", url.Values{"username": {"carol"}, "password": {"password"}, "role": {"editor"}}), admin)
+	if rr.Code != http.StatusSeeOther || ms.Users[3].Role != models.RoleEditor {
+		t.Errorf("Expected carol to be created, got %d %+v", rr.Code, ms.Users[3])
+	}
+	rr = serve(r, formRequest("POST", "/settings/users", url.Values{"username": {"Carol"}, "password": {"password"}, "role": {"editor"}}), admin)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Duplicate user: got %d, want 409", rr.Code)
+	}
+	rr = serve(r, formRequest("POST", "/settings/users", url.Values{"username": {"dave"}, "password": {"password"}, "role": {"root"}}), admin)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Unknown role: got %d, want 400", rr.Code)
+	}
+
+	// Promote bob and reset his password
+	rr = serve(r, formRequest("PUT", "/settings/users/2", url.Values{"role": {"editor"}, "password": {"new password"}}), admin)
+	if rr.Code != http.StatusOK || ms.Users[2].Role != models.RoleEditor {
+		t.Fatalf("Expected bob to be an editor, got %d %+v", rr.Code, ms.Users[2])
+	}
+	if bcrypt.CompareHashAndPassword([]byte(ms.Users[2].PasswordHash), []byte("new password")) != nil {
+		t.Errorf("Expected bob's password to change")
+	}
+
+	// There's always an admin
+	if rr := serve(r, formRequest("PUT", "/settings/users/1", url.Values{"role": {"viewer"}}), admin); rr.Code != http.StatusConflict {
+		t.Errorf("Demoting the last admin: got %d, want 409", rr.Code)
+	}
+	if rr := serve(r, httptest.NewRequest("DELETE", "/settings/users/1", nil), admin); rr.Code != http.StatusConflict {
+		t.Errorf("Deleting the last admin: got %d, want 409", rr.Code)
+	}
+
+	if rr := serve(r, httptest.NewRequest("DELETE", "/settings/users/3", nil), admin); rr.Code != http.StatusOK || len(ms.Users) != 2 {
+		t.Errorf("Expected carol to be deleted, got %d", rr.Code)
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	tests := map[string]string{
+		"":                     "/",
+		"/part/3?tab=stock":    "/part/3?tab=stock",
+		"//evil.example/":      "/",
+		"/\\evil.example":      "/",
+		"https://evil.example": "/",
+	}
+	for next, want := range tests {
+		if got := localPath(next); got != want {
+			t.Errorf("localPath(%q) = %q, want %q", next, got, want)
+		}
+	}
+}
+
+// bearer returns a request authorized with the API token
+func bearer(method, target, token string) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestAPITokens(t *testing.T) {
+	r, ms := setupTest(t)
+	session := signIn(ms, addUser(t, ms, "bob", models.RoleViewer))
+
+	// Viewers can't give tokens scopes beyond their role
+	form := url.Values{"name": {"Label printer"}, "expires": {time.Now().AddDate(0, 1, 0).Format(time.DateOnly)}, "scopes": {"read", "stock-write"}}
+	if rr := serve(r, formRequest("POST", "/settings/tokens", form), session); rr.Code != http.StatusBadRequest {
+		t.Errorf("Scope beyond the role: got %d, want 400", rr.Code)
+	}
+	form["expires"] = []string{"2001-01-01"}
+	form["scopes"] = []string{"read", "locate"}
+	if rr := serve(r, formRequest("POST", "/settings/tokens", form), session); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expiry in the past: got %d, want 400", rr.Code)
+	}
+
+	form["expires"] = []string{time.Now().Format(time.DateOnly)}
+	rr := serve(r, formRequest("POST", "/settings/tokens", form), session)
+	if rr.Code != http.StatusOK || len(ms.Tokens) != 1 {
+		t.Fatalf("Expected the token to be created, got %d", rr.Code)
+	}
+	start := strings.Index(rr.Body.String(), TokenPrefix)
+	token := rr.Body.String()[start : start+len(TokenPrefix)+43]
+	stored, ok := ms.Tokens[hashToken(token)]
+	if !ok || stored.Prefix != token[:12] || len(stored.Scopes) != 2 {
+		t.Fatalf("Expected the token to be stored by its hash, got %+v", ms.Tokens)
+	}
+	if !stored.ExpiresAt.After(time.Now()) {
+		t.Errorf("Expected the token to be valid through its expiry date, got %s", stored.ExpiresAt)
+	}
+
+	// Reads are tracked, changes audited too
+	if rr := serve(r, bearer("GET", "/account", token), nil); rr.Code != http.StatusOK {
+		t.Errorf("Read: got %d, want 200", rr.Code)
+	}
+	if !ms.Tokens[hashToken(token)].LastUsedAt.Valid {
+		t.Errorf("Expected the token's use to be recorded")
+	}
+	if rr := serve(r, bearer("POST", "/locate/part/3", token), nil); rr.Code != http.StatusNoContent {
+		t.Errorf("Locate: got %d, want 204", rr.Code)
+	}
+	last := ms.Events[len(ms.Events)-1]
+	if ms.Events[0].Event != "created" || last.Event != "used" || last.Detail != "POST /locate/part/3: 204" {
+		t.Errorf("Unexpected audit log: %+v", ms.Events)
+	}
+
+	// Tokens can't manage tokens
+	if rr := serve(r, bearer("GET", "/settings/tokens", token), nil); rr.Code != http.StatusForbidden {
+		t.Errorf("Token listing tokens: got %d, want 403", rr.Code)
+	}
+
+	rr = serve(r, bearer("GET", "/account", "wlg_nope"), nil)
+	if rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("Invalid token: got %d %v", rr.Code, rr.Header())
+	}
+
+	// Expired tokens are rejected, and that's audited
+	stored.ExpiresAt = time.Now().Add(-time.Second)
+	ms.Tokens[hashToken(token)] = stored
+	if rr := serve(r, bearer("GET", "/account", token), nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expired token: got %d, want 401", rr.Code)
+	}
+	if last := ms.Events[len(ms.Events)-1]; last.Event != "rejected" {
+		t.Errorf("Expected the expired token to be audited, got %+v", last)
+	}
+}
+
+func TestHandleDeleteToken(t *testing.T) {
+	r, ms := setupTest(t)
+	admin := signIn(ms, addUser(t, ms, "alice", models.RoleAdmin))
+	bob := addUser(t, ms, "bob", models.RoleViewer)
+	carol := signIn(ms, addUser(t, ms, "carol", models.RoleViewer))
+	ms.CreateAPIToken(t.Context(), &models.APIToken{UserID: bob.ID, Username: "bob", Name: "Printer"}, "hash")
+
+	// Only its user and admins can revoke a token
+	if rr := serve(r, httptest.NewRequest("DELETE", "/settings/tokens/1", nil), carol); rr.Code != http.StatusNotFound || len(ms.Tokens) != 1 {
+		t.Errorf("Someone else's token: got %d, want 404", rr.Code)
+	}
+	if rr := serve(r, httptest.NewRequest("DELETE", "/settings/tokens/1", nil), admin); rr.Code != http.StatusOK || len(ms.Tokens) != 0 {
+		t.Errorf("Admin revoking: got %d, want 200", rr.Code)
+	}
+	if len(ms.Events) != 1 || ms.Events[0].Event != "revoked" || ms.Events[0].Detail != "by alice" {
+		t.Errorf("Expected the revocation to be audited, got %+v", ms.Events)
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	_, ms := setupTest(t)
+	tmpl, _ := template.ParseGlob("../../../ui/templates/*.html")
+	h := New(ms, tmpl, time.Hour)
+	r := chi.NewRouter()
+	r.Use(h.Authenticate, core.VerifyCSRF(tmpl))
+	h.RegisterRoutes(r)
+	r.With(core.Require(models.RoleViewer)).Post("/locate/part/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	alice := addUser(t, ms, "alice", models.RoleViewer)
+
+	// Visitors get a token for the login form
+	rr := serve(r, httptest.NewRequest("GET", "/login", nil), nil)
+	var visitor *http.Cookie
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == VisitorCookie {
+			visitor = c
+		}
+	}
+	if visitor == nil || !strings.Contains(rr.Body.String(), `value="`+csrfToken(visitor.Value)+`"`) {
+		t.Fatalf("Expected the login form to carry the visitor's CSRF token")
+	}
+
+	form := url.Values{"username": {"alice"}, "password": {"password"}}
+	if rr := serve(r, formRequest("POST", "/login", form), visitor); rr.Code != http.StatusForbidden {
+		t.Errorf("Login without a token: got %d, want 403", rr.Code)
+	}
+	form.Set("csrf_token", csrfToken(visitor.Value))
+	if rr := serve(r, formRequest("POST", "/login", form), visitor); rr.Code != http.StatusSeeOther || sessionCookie(rr) == nil {
+		t.Errorf("Login with the token: got %d, want 303", rr.Code)
+	}
+
+	// Signed in, the token comes from the session
+	session := signIn(ms, alice)
+	if rr := serve(r, httptest.NewRequest("GET", "/account", nil), session); !strings.Contains(rr.Body.String(), csrfToken(session.Value)) {
+		t.Errorf("Expected the account menu to carry the session's CSRF token")
+	}
+	req := httptest.NewRequest("POST", "/locate/part/3", nil)
+	req.Header.Set(core.CSRFHeader, csrfToken(visitor.Value))
+	req.Header.Set("HX-Request", "true")
+	if rr := serve(r, req, session); rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "security token") {
+		t.Errorf("Another browser's token: got %d, want 403 with the error", rr.Code)
+	}
+	req = httptest.NewRequest("POST", "/locate/part/3", nil)
+	req.Header.Set(core.CSRFHeader, csrfToken(session.Value))
+	if rr := serve(r, req, session); rr.Code != http.StatusNoContent {
+		t.Errorf("Session token: got %d, want 204", rr.Code)
+	}
+
+	// API tokens need none
+	token := TokenPrefix + "script"
+	ms.Tokens[hashToken(token)] = models.APIToken{ID: 1, UserID: alice.ID, Scopes: []models.Scope{models.ScopeLocate}, ExpiresAt: time.Now().Add(time.Hour)}
+	if rr := serve(r, bearer("POST", "/locate/part/3", token), nil); rr.Code != http.StatusNoContent {
+		t.Errorf("API token: got %d, want 204", rr.Code)
+	}
+}
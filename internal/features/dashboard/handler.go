@@ -1,14 +1,23 @@
 package dashboard
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"math"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 
 	"wledger/internal/core"
 	"wledger/internal/models"
+	"wledger/internal/stockstatus"
+	"wledger/internal/store"
+	"wledger/internal/wled"
 )
 
 // Store defines the database methods this module needs.
@@ -16,18 +25,32 @@ import (
 // TODO: Consider simplifying GetPartLocationsForLocate and GetPartLocationsForStop into a
 // single method with a flag, parameter or something
 type Store interface {
-	GetDashboardBinData() ([]models.DashboardBinData, error)
-	GetPartLocationsForLocate(partID int) ([]struct {
+	GetDashboardBinData(ctx context.Context, filter models.StockStatusFilter) ([]models.DashboardBinData, error)
+	GetStockRules(ctx context.Context) ([]models.StockRule, error)
+	GetCategories(ctx context.Context) ([]models.Category, error)
+	GetControllers(ctx context.Context) ([]models.WLEDController, error)
+	GetStockStatusPresets(ctx context.Context) ([]models.StockStatusPreset, error)
+	GetStockStatusPresetByID(ctx context.Context, id int) (models.StockStatusPreset, error)
+	CreateStockStatusPreset(ctx context.Context, p *models.StockStatusPreset) error
+	DeleteStockStatusPreset(ctx context.Context, id int) error
+	GetStateRestoreIPs(ctx context.Context) (map[string]bool, error)
+	GetZones(ctx context.Context) ([]models.Zone, error)
+	GetZoneBinLocations(ctx context.Context, zoneID int) ([]struct {
 		IP       string
 		SegID    int
 		LEDIndex int
 	}, error)
-	GetPartLocationsForStop(partID int) ([]struct {
+	GetPartLocationsForLocate(ctx context.Context, partID int) ([]struct {
 		IP       string
 		SegID    int
 		LEDIndex int
 	}, error)
-	GetAllBinLocationsForStopAll() ([]struct {
+	GetPartLocationsForStop(ctx context.Context, partID int) ([]struct {
+		IP       string
+		SegID    int
+		LEDIndex int
+	}, error)
+	GetAllBinLocationsForStopAll(ctx context.Context) ([]struct {
 		IP       string
 		SegID    int
 		LEDIndex int
@@ -39,49 +62,331 @@ type WLEDClient interface {
 	SendCommand(ipAddress string, state models.WLEDState) error
 }
 
+// ControllerStates tracks the LEDs lit on each controller, so controllers
+// that opt in get their own state back once WLEDger is done with them
+type ControllerStates interface {
+	Claim(ipAddress string, snapshot bool, leds []wled.LED)
+	Release(ipAddress string, leds []wled.LED)
+	ReleaseAll(ipAddress string)
+}
+
+// Activity records when the LEDs are used, so scheduled
+// ambient lighting stays out of the way
+type Activity interface {
+	LocateStarted(partID int)
+	LocateStopped(partID int)
+	AllStopped()
+	Touch()
+}
+
+// Stock evaluation modes decide which quantity and thresholds
+// a bin's color is based on.
+const (
+	EvalModeBin      = "bin"      // Each bin's quantity against the part's thresholds
+	EvalModePart     = "part"     // The part's total quantity, applied to all of its bins
+	EvalModeLocation = "location" // Each bin's quantity against its own thresholds
+)
+
+// Fill level styles color bins by how full they are instead of by stock rule
+const (
+	FillStyleGradient   = "gradient"   // Red (empty) through yellow to green (full)
+	FillStyleBrightness = "brightness" // White, dimmed to the fill level
+)
+
+// QuietHours reports whether quiet hours have turned the controllers off
+type QuietHours interface {
+	ControllersOff() bool
+}
+
 type Handler struct {
 	store     Store
 	wled      WLEDClient
+	states    ControllerStates
+	activity  Activity
 	templates core.TemplateExecutor
+
+	// QuietHours, if set, is asked whether bins lit on request will
+	// stay dark. It's set after New, as the scheduler needs the handler.
+	QuietHours QuietHours
+}
+
+func New(s Store, w WLEDClient, cs ControllerStates, a Activity, t core.TemplateExecutor) *Handler {
+	return &Handler{store: s, wled: w, states: cs, activity: a, templates: t}
+}
+
+// binLocations returns the LEDs of every bin in a zone, or of all bins for zone 0
+func (h *Handler) binLocations(ctx context.Context, zoneID int) ([]struct {
+	IP       string
+	SegID    int
+	LEDIndex int
+}, error) {
+	if zoneID == 0 {
+		return h.store.GetAllBinLocationsForStopAll(ctx)
+	}
+	return h.store.GetZoneBinLocations(ctx, zoneID)
+}
+
+// restoreIPs returns the controllers that want their state restored.
+// Restoring is best effort, so errors only disable it for this request.
+func (h *Handler) restoreIPs(ctx context.Context) map[string]bool {
+	ips, err := h.store.GetStateRestoreIPs(ctx)
+	if err != nil {
+		log.Println("Dashboard: Failed to load state restore settings:", err)
+		return map[string]bool{}
+	}
+	return ips
 }
 
-func New(s Store, w WLEDClient, t core.TemplateExecutor) *Handler {
-	return &Handler{store: s, wled: w, templates: t}
+// controllerNames maps controller IP addresses to names, for messages about them
+func (h *Handler) controllerNames(ctx context.Context) map[string]string {
+	names := make(map[string]string)
+	controllers, err := h.store.GetControllers(ctx)
+	if err != nil {
+		log.Println("Dashboard: Error loading controller names:", err)
+		return names
+	}
+	for _, c := range controllers {
+		names[c.IPAddress] = c.Name
+	}
+	return names
+}
+
+// quietNote is shown when bins are lit while quiet hours keep the controllers off
+const quietNote = "Quiet hours are active, the bins light up once they end"
+
+// controllersOff reports whether quiet hours have turned the controllers off
+func (h *Handler) controllersOff() bool {
+	return h.QuietHours != nil && h.QuietHours.ControllersOff()
+}
+
+// offlineNote names the controllers that could not be reached,
+// e.g. "Cabinet C is offline" or "Cabinet B and Cabinet C are offline"
+func offlineNote(controllers []string) string {
+	sort.Strings(controllers)
+	switch len(controllers) {
+	case 0:
+		return ""
+	case 1:
+		return controllers[0] + " is offline"
+	default:
+		last := len(controllers) - 1
+		return strings.Join(controllers[:last], ", ") + " and " + controllers[last] + " are offline"
+	}
+}
+
+// offlineControllers returns the names of the given controller IPs
+func (h *Handler) offlineControllers(ctx context.Context, ips []string) []string {
+	names := h.controllerNames(ctx)
+	offline := []string{}
+	for _, ip := range ips {
+		name, ok := names[ip]
+		if !ok {
+			name = ip
+		}
+		offline = append(offline, name)
+	}
+	return offline
 }
 
 func (h *Handler) RegisterRoutes(r chi.Router) {
-	r.Get("/dashboard", h.handleShowDashboard)
-	r.Post("/api/v1/stock-status", h.handleShowStockStatus)
-	r.Post("/api/v1/stop-all", h.handleStopAll)
-
-	// Locate routes
-	r.Post("/locate/part/{id}", h.handleLocatePart)
-	r.Post("/locate/stop/{id}", h.handleStopLocate)
-	r.Get("/locate/button/{id}", h.handleGetLocateButton)
+	r.Group(func(r chi.Router) {
+		r.Use(core.Require(models.RoleViewer))
+		r.Get("/dashboard", h.handleShowDashboard)
+		r.Post("/api/v1/stock-status", h.handleShowStockStatus)
+		r.Post("/api/v1/stop-all", h.handleStopAll)
+
+		// Locate routes
+		r.Post("/locate/part/{id}", h.handleLocatePart)
+		r.Post("/locate/stop/{id}", h.handleStopLocate)
+		r.Get("/locate/button/{id}", h.handleGetLocateButton)
+	})
+
+	// Presets are shared by everyone
+	r.Group(func(r chi.Router) {
+		r.Use(core.Require(models.RoleEditor))
+		r.Post("/dashboard/presets", h.handleCreatePreset)
+		r.Delete("/dashboard/presets/{id}", h.handleDeletePreset)
+	})
 }
 
 // Handlers
 
 func (h *Handler) handleShowDashboard(w http.ResponseWriter, r *http.Request) {
+	categories, err := h.store.GetCategories(r.Context())
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	controllers, err := h.store.GetControllers(r.Context())
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	presets, err := h.store.GetStockStatusPresets(r.Context())
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	zones, err := h.store.GetZones(r.Context())
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+
 	data := map[string]interface{}{
-		"Title": "Stock Dashboard",
+		"Title":       "Stock Dashboard",
+		"CSRFToken":   core.CSRFToken(r),
+		"Categories":  categories,
+		"Controllers": controllers,
+		"Presets":     presets,
+		"Zones":       zones,
+		"SessionZone": core.SessionZone(r),
 	}
-	err := h.templates.ExecuteTemplate(w, "dashboard.html", data)
+	err = h.templates.ExecuteTemplate(w, "dashboard.html", data)
 	if err != nil {
 		core.ServerError(w, r, err)
 	}
 }
 
+// showsSeverity reports whether a stock status level shows rules of a severity
+func showsSeverity(level, severity string) bool {
+	switch level {
+	case "critical":
+		return severity == stockstatus.SeverityCritical
+	case "attention":
+		return severity != stockstatus.SeverityOK
+	}
+	return true
+}
+
+// stockLevelInputs returns the quantity and thresholds used to color a bin
+// for the given evaluation mode. Unknown modes fall back to EvalModeBin.
+func stockLevelInputs(bin models.DashboardBinData, mode string) (quantity, minStock, reorderPoint int) {
+	switch mode {
+	case EvalModePart:
+		return bin.PartQuantity, bin.MinStock, bin.ReorderPoint
+	case EvalModeLocation:
+		minStock, reorderPoint = bin.MinStock, bin.ReorderPoint
+		if bin.LocationMinStock.Valid {
+			minStock = int(bin.LocationMinStock.Int64)
+		}
+		if bin.LocationReorderPoint.Valid {
+			reorderPoint = int(bin.LocationReorderPoint.Int64)
+		}
+		return bin.BinQuantity, minStock, reorderPoint
+	default:
+		return bin.BinQuantity, bin.MinStock, bin.ReorderPoint
+	}
+}
+
+// stockStatusFilterFromForm reads the optional scope filters from the request
+func stockStatusFilterFromForm(r *http.Request) models.StockStatusFilter {
+	controllerID, _ := strconv.Atoi(r.FormValue("controller_id"))
+	return models.StockStatusFilter{
+		Category:     strings.TrimSpace(r.FormValue("category")),
+		Supplier:     strings.TrimSpace(r.FormValue("supplier")),
+		Manufacturer: strings.TrimSpace(r.FormValue("manufacturer")),
+		ControllerID: controllerID,
+		Location:     strings.TrimSpace(r.FormValue("location")),
+		ZoneID:       core.ZoneFromForm(r),
+	}
+}
+
+// fillLevel returns how full a bin is, between 0 and 1.
+// The location's capacity wins over the bin's. Bins without a capacity return false.
+func fillLevel(bin models.DashboardBinData) (float64, bool) {
+	capacity := bin.BinCapacity
+	if bin.LocationCapacity.Valid && bin.LocationCapacity.Int64 > 0 {
+		capacity = int(bin.LocationCapacity.Int64)
+	}
+	if capacity <= 0 {
+		return 0, false
+	}
+	return math.Max(0, math.Min(1, float64(bin.BinQuantity)/float64(capacity))), true
+}
+
+// fillLevelColor returns the hex color for a fill level in the given style
+func fillLevelColor(fill float64, style string) string {
+	if style == FillStyleBrightness {
+		// Keep empty bins faintly lit so they can be told apart from untracked ones
+		v := int(math.Round(255 * math.Max(fill, 0.05)))
+		return fmt.Sprintf("%02X%02X%02X", v, v, v)
+	}
+
+	// Red -> Yellow over the first half, Yellow -> Green over the second
+	red, green := 255, 255
+	if fill < 0.5 {
+		green = int(math.Round(255 * fill * 2))
+	} else {
+		red = int(math.Round(255 * (1 - fill) * 2))
+	}
+	return fmt.Sprintf("%02X%02X00", red, green)
+}
+
 func (h *Handler) handleShowStockStatus(w http.ResponseWriter, r *http.Request) {
-	level := r.FormValue("level")
+	view := models.StockStatusPreset{
+		Level:  r.FormValue("level"),
+		Mode:   r.FormValue("mode"),
+		Filter: stockStatusFilterFromForm(r),
+	}
+
+	// A saved preset replaces the submitted level, mode and filters
+	if presetID, _ := strconv.Atoi(r.FormValue("preset_id")); presetID != 0 {
+		preset, err := h.store.GetStockStatusPresetByID(r.Context(), presetID)
+		if err != nil {
+			core.ClientError(w, r, http.StatusNotFound, "Preset not found", err)
+			return
+		}
+		view = preset
+	}
 
-	// Clear all LEDs
-	allBinsForStop, err := h.store.GetAllBinLocationsForStopAll()
+	h.activity.Touch()
+	result, err := h.ShowStockStatus(r.Context(), view, r.FormValue("fill"))
 	if err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
 
+	switch {
+	case h.controllersOff():
+		fmt.Fprintf(w, "<strong>Quiet hours.</strong> %d bins set, they light up once quiet hours end.", result.BinsLit)
+		if len(result.Offline) > 0 {
+			fmt.Fprintf(w, " %s.", offlineNote(result.Offline))
+		}
+		if result.Notifications > 0 {
+			fmt.Fprintf(w, " %d bins match a notification rule.", result.Notifications)
+		}
+	case len(result.Offline) > 0:
+		fmt.Fprintf(w, "<strong>Partly done.</strong> %d of %d bins lit; %s.",
+			result.BinsLit, result.BinsLit+result.BinsOffline+result.BinsQueued, offlineNote(result.Offline))
+		if result.BinsQueued > 0 {
+			fmt.Fprintf(w, " %d bins light up once their controller is back.", result.BinsQueued)
+		}
+		if result.Notifications > 0 {
+			fmt.Fprintf(w, " %d bins match a notification rule.", result.Notifications)
+		}
+	case result.Notifications > 0:
+		fmt.Fprintf(w, "<strong>Success!</strong> Lit %d bins, %d bins match a notification rule.", result.BinsLit, result.Notifications)
+	default:
+		fmt.Fprintf(w, "<strong>Success!</strong> Lit %d bins.", result.BinsLit)
+	}
+	if result.NoCapacity > 0 {
+		fmt.Fprintf(w, " %d bins were skipped because they have no capacity set.", result.NoCapacity)
+	}
+}
+
+// ShowStockStatus clears all LEDs and lights the bins matching the view's
+// level and filters, colored by stock rule or by fill level.
+// It is also used by the lighting scheduler.
+func (h *Handler) ShowStockStatus(ctx context.Context, view models.StockStatusPreset, fillStyle string) (models.StockStatusResult, error) {
+	var result models.StockStatusResult
+	level, mode, filter := view.Level, view.Mode, view.Filter
+	// Clear all LEDs in the zone
+	allBinsForStop, err := h.binLocations(ctx, filter.ZoneID)
+	if err != nil {
+		return result, err
+	}
+
 	type ledPayload map[string]map[int][]interface{}
 	stopPayloads := make(ledPayload)
 	colorBlack := "000000"
@@ -96,7 +401,17 @@ func (h *Handler) handleShowStockStatus(w http.ResponseWriter, r *http.Request)
 		)
 	}
 
+	// Every bin is taken over while the status is shown,
+	// unlit ones are released again once the status is lit
+	restore := h.restoreIPs(ctx)
+	binLEDs := make(map[string][]wled.LED)
+	for _, bin := range allBinsForStop {
+		binLEDs[bin.IP] = append(binLEDs[bin.IP], wled.LED{Segment: bin.SegID, Index: bin.LEDIndex})
+	}
+
 	for ip, segments := range stopPayloads {
+		h.states.Claim(ip, restore[ip], binLEDs[ip])
+
 		wledSegments := []models.WLEDSegment{}
 		for segID, iPayload := range segments {
 			wledSegments = append(wledSegments, models.WLEDSegment{
@@ -108,41 +423,64 @@ func (h *Handler) handleShowStockStatus(w http.ResponseWriter, r *http.Request)
 		state := models.WLEDState{Segments: wledSegments}
 		if err := h.wled.SendCommand(ip, state); err != nil {
 			// Log only
-			fmt.Printf("Dashboard (Clear): Failed to send WLED 'off' command to %s: %v\n", ip, err)
+			log.Printf("Dashboard (Clear): Failed to send WLED 'off' command to %s: %v", ip, err)
 		}
 	}
 
 	// Get all bin data
-	allBins, err := h.store.GetDashboardBinData()
+	allBins, err := h.store.GetDashboardBinData(ctx, filter)
 	if err != nil {
-		core.ServerError(w, r, err)
-		return
+		return result, err
 	}
 
-	colorRed := "FF0000"
-	colorYellow := "FFFF00"
-	colorGreen := "00FF00"
+	rules, err := h.store.GetStockRules(ctx)
+	if err != nil {
+		return result, err
+	}
+	evaluator := stockstatus.NewEvaluator(rules)
 
 	payloads := make(ledPayload)
-	binsLit := 0
+	effects := make(map[string]map[int]int)   // ip -> segment -> WLED effect ID
+	lit := make(map[string]map[wled.LED]bool) // ip -> LEDs lit by this view
 
 	for _, bin := range allBins {
-		var color string
-		if bin.BinQuantity <= bin.MinStock {
-			color = colorRed
-		} else if bin.BinQuantity <= bin.ReorderPoint {
-			color = colorYellow
-		} else {
-			color = colorGreen
+		quantity, minStock, reorderPoint := stockLevelInputs(bin, mode)
+
+		// Notify rules don't light bins, the first other rule that matches
+		// does. The bins they match are counted for the status message.
+		var rule models.StockRule
+		ok, notify := false, false
+		for _, r := range evaluator.EvaluateAll(stockstatus.FactsFromBin(bin, quantity, minStock, reorderPoint)) {
+			if r.Action == stockstatus.ActionNotify {
+				notify = notify || showsSeverity(level, r.Severity)
+			} else if !ok {
+				rule, ok = r, true
+			}
 		}
-
-		if level == "critical" && color != colorRed {
-			continue
+		if notify {
+			result.Notifications++
 		}
-		if level == "attention" && color == colorGreen {
+		if !ok || !showsSeverity(level, rule.Severity) {
 			continue
 		}
 
+		color := rule.Color
+		if fillStyle == FillStyleGradient || fillStyle == FillStyleBrightness {
+			fill, ok := fillLevel(bin)
+			if !ok {
+				result.NoCapacity++
+				continue
+			}
+			color = fillLevelColor(fill, fillStyle)
+		}
+
+		if rule.Action == stockstatus.ActionEffect {
+			if effects[bin.BinIP] == nil {
+				effects[bin.BinIP] = make(map[int]int)
+			}
+			effects[bin.BinIP][bin.BinSegmentID] = rule.Effect
+		}
+
 		if payloads[bin.BinIP] == nil {
 			payloads[bin.BinIP] = make(map[int][]interface{})
 		}
@@ -150,29 +488,106 @@ func (h *Handler) handleShowStockStatus(w http.ResponseWriter, r *http.Request)
 			payloads[bin.BinIP][bin.BinSegmentID],
 			bin.BinLEDIndex, color,
 		)
-		binsLit++
+		if lit[bin.BinIP] == nil {
+			lit[bin.BinIP] = make(map[wled.LED]bool)
+		}
+		lit[bin.BinIP][wled.LED{Segment: bin.BinSegmentID, Index: bin.BinLEDIndex}] = true
+		result.BinsLit++
 	}
 
+	offline := []string{}
 	for ip, segments := range payloads {
 		wledSegments := []models.WLEDSegment{}
 		for segID, iPayload := range segments {
 			wledSegments = append(wledSegments, models.WLEDSegment{
-				ID: segID,
-				On: true,
-				I:  iPayload,
+				ID:     segID,
+				On:     true,
+				Effect: effects[ip][segID],
+				I:      iPayload,
 			})
 		}
 		state := models.WLEDState{Segments: wledSegments}
 		if err := h.wled.SendCommand(ip, state); err != nil {
-			fmt.Printf("Dashboard: Failed to send WLED command to %s: %v\n", ip, err)
+			log.Printf("Dashboard: Failed to send WLED command to %s: %v", ip, err)
+			result.BinsLit -= len(lit[ip])
+			offline = append(offline, ip)
+			// Queued commands light the bins once the controller is back,
+			// so their LEDs stay claimed
+			if errors.Is(err, wled.ErrOffline) {
+				result.BinsQueued += len(lit[ip])
+			} else {
+				result.BinsOffline += len(lit[ip])
+				delete(lit, ip)
+			}
+		}
+	}
+	if len(offline) > 0 {
+		result.Offline = h.offlineControllers(ctx, offline)
+	}
+
+	for ip, leds := range binLEDs {
+		unlit := []wled.LED{}
+		for _, led := range leds {
+			if !lit[ip][led] {
+				unlit = append(unlit, led)
+			}
+		}
+		h.states.Release(ip, unlit)
+	}
+
+	return result, nil
+}
+
+func (h *Handler) handleCreatePreset(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		core.ClientError(w, r, http.StatusBadRequest, "Bad Request", err)
+		return
+	}
+
+	preset := &models.StockStatusPreset{
+		Name:   strings.TrimSpace(r.FormValue("name")),
+		Level:  r.FormValue("level"),
+		Mode:   r.FormValue("mode"),
+		Filter: stockStatusFilterFromForm(r),
+	}
+	if preset.Name == "" {
+		core.ClientError(w, r, http.StatusBadRequest, "Preset name is required", nil)
+		return
+	}
+	if preset.Level == "" {
+		preset.Level = "all"
+	}
+	if preset.Mode == "" {
+		preset.Mode = EvalModeBin
+	}
+
+	if err := h.store.CreateStockStatusPreset(r.Context(), preset); err != nil {
+		if errors.Is(err, store.ErrUniqueConstraint) {
+			core.ClientError(w, r, http.StatusConflict, "A preset with this name already exists.", err)
+		} else {
+			core.ServerError(w, r, err)
 		}
+		return
 	}
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
 
-	fmt.Fprintf(w, "<strong>Success!</strong> Lit %d bins.", binsLit)
+func (h *Handler) handleDeletePreset(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	if id == 0 {
+		core.ClientError(w, r, http.StatusBadRequest, "Invalid ID", nil)
+		return
+	}
+	if err := h.store.DeleteStockStatusPreset(r.Context(), id); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
 }
 
 func (h *Handler) handleStopAll(w http.ResponseWriter, r *http.Request) {
-	locations, err := h.store.GetAllBinLocationsForStopAll()
+	zoneID := core.ZoneFromForm(r)
+	locations, err := h.binLocations(r.Context(), zoneID)
 	if err != nil {
 		core.ServerError(w, r, err)
 		return
@@ -180,12 +595,14 @@ func (h *Handler) handleStopAll(w http.ResponseWriter, r *http.Request) {
 
 	type ledMap map[string]map[int][]interface{}
 	ledsByController := make(ledMap)
+	binLEDs := make(map[string][]wled.LED)
 
 	for _, loc := range locations {
 		if ledsByController[loc.IP] == nil {
 			ledsByController[loc.IP] = make(map[int][]interface{})
 		}
 		ledsByController[loc.IP][loc.SegID] = append(ledsByController[loc.IP][loc.SegID], loc.LEDIndex, "000000")
+		binLEDs[loc.IP] = append(binLEDs[loc.IP], wled.LED{Segment: loc.SegID, Index: loc.LEDIndex})
 	}
 
 	for ip, segments := range ledsByController {
@@ -199,10 +616,21 @@ func (h *Handler) handleStopAll(w http.ResponseWriter, r *http.Request) {
 		}
 		state := models.WLEDState{Segments: wledSegments}
 		if err := h.wled.SendCommand(ip, state); err != nil {
-			fmt.Printf("StopAll: Failed to send WLED 'off' command to %s: %v\n", ip, err)
+			log.Printf("StopAll: Failed to send WLED 'off' command to %s: %v", ip, err)
+		}
+		// A zone may share a controller with another zone, so only release its own LEDs
+		if zoneID == 0 {
+			h.states.ReleaseAll(ip)
+		} else {
+			h.states.Release(ip, binLEDs[ip])
 		}
 	}
 
+	if zoneID == 0 {
+		h.activity.AllStopped()
+	} else {
+		h.activity.Touch()
+	}
 	w.Header().Set("HX-Trigger", "resetLocateButtons")
 	w.WriteHeader(http.StatusOK)
 }
@@ -210,7 +638,7 @@ func (h *Handler) handleStopAll(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) handleLocatePart(w http.ResponseWriter, r *http.Request) {
 	partID, _ := strconv.Atoi(chi.URLParam(r, "id"))
 
-	locations, err := h.store.GetPartLocationsForLocate(partID)
+	locations, err := h.store.GetPartLocationsForLocate(r.Context(), partID)
 	if err != nil {
 		core.ServerError(w, r, err)
 		return
@@ -226,13 +654,18 @@ func (h *Handler) handleLocatePart(w http.ResponseWriter, r *http.Request) {
 		ledsByController[loc.IP][loc.SegID] = append(ledsByController[loc.IP][loc.SegID], loc.LEDIndex)
 	}
 
+	restore := h.restoreIPs(r.Context())
 	color := "FF0000" // Red
+	binsLit, queued := 0, 0
+	offline := []string{}
 	for ip, segments := range ledsByController {
 		wledSegments := []models.WLEDSegment{}
+		leds := []wled.LED{}
 		for segID, ledIndices := range segments {
 			iPayload := []interface{}{}
 			for _, ledIndex := range ledIndices {
 				iPayload = append(iPayload, ledIndex, color)
+				leds = append(leds, wled.LED{Segment: segID, Index: ledIndex})
 			}
 			wledSegments = append(wledSegments, models.WLEDSegment{
 				ID: segID,
@@ -241,24 +674,46 @@ func (h *Handler) handleLocatePart(w http.ResponseWriter, r *http.Request) {
 			})
 		}
 
+		h.states.Claim(ip, restore[ip], leds)
 		state := models.WLEDState{Segments: wledSegments}
 		if err := h.wled.SendCommand(ip, state); err != nil {
-			fmt.Printf("Failed to send WLED command to %s: %v\n", ip, err)
-			// Send back 'Start' button on failure
-			part := models.Part{ID: partID}
-			h.templates.ExecuteTemplate(w, "_locate-start-button.html", part)
-			return
+			log.Printf("Failed to send WLED command to %s: %v", ip, err)
+			offline = append(offline, ip)
+			// Queued commands light the bins once the controller is back
+			if errors.Is(err, wled.ErrOffline) {
+				queued += len(leds)
+			} else {
+				h.states.Release(ip, leds)
+			}
+			continue
 		}
+		binsLit += len(leds)
 	}
 
 	part := models.Part{ID: partID}
-	h.templates.ExecuteTemplate(w, "_locate-stop-button.html", part)
+	if binsLit == 0 && queued == 0 {
+		// Send back 'Start' button on failure
+		h.templates.ExecuteTemplate(w, "_locate-start-button.html", part)
+	} else {
+		h.activity.LocateStarted(partID)
+		h.templates.ExecuteTemplate(w, "_locate-stop-button.html", part)
+	}
+	if len(offline) > 0 {
+		data := map[string]interface{}{
+			"Note":   fmt.Sprintf("%d of %d bins lit; %s", binsLit, len(locations), offlineNote(h.offlineControllers(r.Context(), offline))),
+			"Queued": queued > 0,
+		}
+		h.templates.ExecuteTemplate(w, "_locate-note.html", data)
+	}
+	if binsLit > 0 && h.controllersOff() {
+		h.templates.ExecuteTemplate(w, "_locate-note.html", map[string]interface{}{"Note": quietNote})
+	}
 }
 
 func (h *Handler) handleStopLocate(w http.ResponseWriter, r *http.Request) {
 	partID, _ := strconv.Atoi(chi.URLParam(r, "id"))
 
-	locations, err := h.store.GetPartLocationsForStop(partID)
+	locations, err := h.store.GetPartLocationsForStop(r.Context(), partID)
 	if err != nil {
 		core.ServerError(w, r, err)
 		return
@@ -277,10 +732,12 @@ func (h *Handler) handleStopLocate(w http.ResponseWriter, r *http.Request) {
 	color := "000000" // Black
 	for ip, segments := range ledsByController {
 		wledSegments := []models.WLEDSegment{}
+		leds := []wled.LED{}
 		for segID, ledIndices := range segments {
 			iPayload := []interface{}{}
 			for _, ledIndex := range ledIndices {
 				iPayload = append(iPayload, ledIndex, color)
+				leds = append(leds, wled.LED{Segment: segID, Index: ledIndex})
 			}
 			wledSegments = append(wledSegments, models.WLEDSegment{
 				ID: segID,
@@ -291,10 +748,13 @@ func (h *Handler) handleStopLocate(w http.ResponseWriter, r *http.Request) {
 
 		state := models.WLEDState{Segments: wledSegments}
 		if err := h.wled.SendCommand(ip, state); err != nil {
-			fmt.Printf("Failed to send WLED 'off' command to %s: %v\n", ip, err)
+			log.Printf("Failed to send WLED 'off' command to %s: %v", ip, err)
 		}
+		// Restores the controller's own state once nothing else is lit
+		h.states.Release(ip, leds)
 	}
 
+	h.activity.LocateStopped(partID)
 	part := models.Part{ID: partID}
 	h.templates.ExecuteTemplate(w, "_locate-start-button.html", part)
 }
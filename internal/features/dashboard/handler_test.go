@@ -2,24 +2,37 @@
 package dashboard
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"html/template"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/go-chi/chi/v5"
 
+	"wledger/internal/activity"
+	"wledger/internal/core"
 	"wledger/internal/models"
+	"wledger/internal/stockstatus"
+	"wledger/internal/store"
+	"wledger/internal/wled"
 )
 
 // Local Mocks
 type mockStore struct {
-	FailOps bool
+	FailOps    bool
+	Presets    map[int]models.StockStatusPreset
+	RestoreIPs map[string]bool
 
-	GetDashboardBinDataFunc       func() ([]models.DashboardBinData, error)
+	GetDashboardBinDataFunc       func(filter models.StockStatusFilter) ([]models.DashboardBinData, error)
+	GetStockRulesFunc             func() ([]models.StockRule, error)
 	GetPartLocationsForLocateFunc func(partID int) ([]struct {
 		IP       string
 		SegID    int
@@ -37,16 +50,74 @@ type mockStore struct {
 	}, error)
 }
 
-func (m *mockStore) GetDashboardBinData() ([]models.DashboardBinData, error) {
+func (m *mockStore) GetDashboardBinData(ctx context.Context, filter models.StockStatusFilter) ([]models.DashboardBinData, error) {
 	if m.FailOps {
 		return nil, errors.New("db error")
 	}
 	if m.GetDashboardBinDataFunc != nil {
-		return m.GetDashboardBinDataFunc()
+		return m.GetDashboardBinDataFunc(filter)
 	}
 	return nil, nil
 }
-func (m *mockStore) GetPartLocationsForLocate(id int) ([]struct {
+func (m *mockStore) GetCategories(ctx context.Context) ([]models.Category, error) {
+	if m.FailOps {
+		return nil, errors.New("db error")
+	}
+	return []models.Category{{ID: 1, Name: "Passives"}}, nil
+}
+func (m *mockStore) GetControllers(ctx context.Context) ([]models.WLEDController, error) {
+	if m.FailOps {
+		return nil, errors.New("db error")
+	}
+	return []models.WLEDController{{ID: 1, Name: "Cabinet B", IPAddress: "1.1"}, {ID: 3, Name: "Cabinet C", IPAddress: "3.3"}}, nil
+}
+func (m *mockStore) GetStockStatusPresets(ctx context.Context) ([]models.StockStatusPreset, error) {
+	if m.FailOps {
+		return nil, errors.New("db error")
+	}
+	presets := []models.StockStatusPreset{}
+	for _, p := range m.Presets {
+		presets = append(presets, p)
+	}
+	return presets, nil
+}
+func (m *mockStore) GetStockStatusPresetByID(ctx context.Context, id int) (models.StockStatusPreset, error) {
+	p, ok := m.Presets[id]
+	if !ok {
+		return p, errors.New("not found")
+	}
+	return p, nil
+}
+func (m *mockStore) CreateStockStatusPreset(ctx context.Context, p *models.StockStatusPreset) error {
+	if m.FailOps {
+		return errors.New("db error")
+	}
+	for _, existing := range m.Presets {
+		if existing.Name == p.Name {
+			return store.ErrUniqueConstraint
+		}
+	}
+	p.ID = len(m.Presets) + 1
+	m.Presets[p.ID] = *p
+	return nil
+}
+func (m *mockStore) DeleteStockStatusPreset(ctx context.Context, id int) error {
+	if m.FailOps {
+		return errors.New("db error")
+	}
+	delete(m.Presets, id)
+	return nil
+}
+func (m *mockStore) GetStockRules(ctx context.Context) ([]models.StockRule, error) {
+	if m.FailOps {
+		return nil, errors.New("db error")
+	}
+	if m.GetStockRulesFunc != nil {
+		return m.GetStockRulesFunc()
+	}
+	return nil, nil
+}
+func (m *mockStore) GetPartLocationsForLocate(ctx context.Context, id int) ([]struct {
 	IP       string
 	SegID    int
 	LEDIndex int
@@ -59,7 +130,7 @@ func (m *mockStore) GetPartLocationsForLocate(id int) ([]struct {
 	}
 	return nil, nil
 }
-func (m *mockStore) GetPartLocationsForStop(id int) ([]struct {
+func (m *mockStore) GetPartLocationsForStop(ctx context.Context, id int) ([]struct {
 	IP       string
 	SegID    int
 	LEDIndex int
@@ -72,7 +143,7 @@ func (m *mockStore) GetPartLocationsForStop(id int) ([]struct {
 	}
 	return nil, nil
 }
-func (m *mockStore) GetAllBinLocationsForStopAll() ([]struct {
+func (m *mockStore) GetAllBinLocationsForStopAll(ctx context.Context) ([]struct {
 	IP       string
 	SegID    int
 	LEDIndex int
@@ -86,8 +157,46 @@ func (m *mockStore) GetAllBinLocationsForStopAll() ([]struct {
 	return nil, nil
 }
 
+func (m *mockStore) GetZones(ctx context.Context) ([]models.Zone, error) {
+	if m.FailOps {
+		return nil, errors.New("db error")
+	}
+	return []models.Zone{{ID: 1, Name: "Electronics wall"}, {ID: 2, Name: "Mechanical bench"}}, nil
+}
+
+// GetZoneBinLocations puts every bin on controller "<zoneID>.1"
+func (m *mockStore) GetZoneBinLocations(ctx context.Context, zoneID int) ([]struct {
+	IP       string
+	SegID    int
+	LEDIndex int
+}, error) {
+	if m.FailOps {
+		return nil, errors.New("db error")
+	}
+	return []struct {
+		IP       string
+		SegID    int
+		LEDIndex int
+	}{{IP: strconv.Itoa(zoneID) + ".1", SegID: 0, LEDIndex: 3}}, nil
+}
+
 type mockWLED struct {
 	SendCommandFunc func(ipAddress string, state models.WLEDState) error
+	Snapshots       int
+	Restored        map[string]string
+}
+
+func (m *mockWLED) GetState(ip string) (json.RawMessage, error) {
+	m.Snapshots++
+	return json.RawMessage(`{"on":true,"ps":4}`), nil
+}
+
+func (m *mockWLED) SetState(ip string, state json.RawMessage) error {
+	if m.Restored == nil {
+		m.Restored = make(map[string]string)
+	}
+	m.Restored[ip] = string(state)
+	return nil
 }
 
 func (m *mockWLED) SendCommand(ip string, state models.WLEDState) error {
@@ -97,10 +206,17 @@ func (m *mockWLED) SendCommand(ip string, state models.WLEDState) error {
 	return nil
 }
 
+func (m *mockStore) GetStateRestoreIPs(ctx context.Context) (map[string]bool, error) {
+	if m.FailOps {
+		return nil, errors.New("db error")
+	}
+	return m.RestoreIPs, nil
+}
+
 // Setup
 func setupTest(t *testing.T) (*Handler, *mockStore, *mockWLED) {
 	t.Helper()
-	ms := &mockStore{}
+	ms := &mockStore{Presets: map[int]models.StockStatusPreset{}}
 	mw := &mockWLED{}
 
 	tmpl, err := template.ParseGlob("../../../ui/templates/*.html")
@@ -111,7 +227,7 @@ func setupTest(t *testing.T) (*Handler, *mockStore, *mockWLED) {
 		}
 	}
 
-	h := New(ms, mw, tmpl)
+	h := New(ms, mw, wled.NewStateKeeper(mw), activity.New(), tmpl)
 	return h, ms, mw
 }
 
@@ -133,7 +249,7 @@ func TestHandleShowStockStatus(t *testing.T) {
 	h, ms, _ := setupTest(t)
 
 	// Setup data: 3 bins (Red, Yellow, Green)
-	ms.GetDashboardBinDataFunc = func() ([]models.DashboardBinData, error) {
+	ms.GetDashboardBinDataFunc = func(filter models.StockStatusFilter) ([]models.DashboardBinData, error) {
 		return []models.DashboardBinData{
 			{BinQuantity: 0, MinStock: 5, ReorderPoint: 10, BinIP: "1.1.1.1", BinSegmentID: 0, BinLEDIndex: 0},  // Red
 			{BinQuantity: 8, MinStock: 5, ReorderPoint: 10, BinIP: "1.1.1.1", BinSegmentID: 0, BinLEDIndex: 1},  // Yellow
@@ -177,6 +293,352 @@ func TestHandleShowStockStatus(t *testing.T) {
 	}
 }
 
+func TestHandleShowStockStatus_Offline(t *testing.T) {
+	h, ms, mw := setupTest(t)
+
+	ms.GetDashboardBinDataFunc = func(filter models.StockStatusFilter) ([]models.DashboardBinData, error) {
+		return []models.DashboardBinData{
+			{BinQuantity: 0, MinStock: 5, ReorderPoint: 10, BinIP: "1.1", BinSegmentID: 0, BinLEDIndex: 0},
+			{BinQuantity: 0, MinStock: 5, ReorderPoint: 10, BinIP: "3.3", BinSegmentID: 0, BinLEDIndex: 0},
+			{BinQuantity: 0, MinStock: 5, ReorderPoint: 10, BinIP: "3.3", BinSegmentID: 0, BinLEDIndex: 1},
+		}, nil
+	}
+	ms.GetAllBinLocationsForStopAllFunc = func() ([]struct {
+		IP       string
+		SegID    int
+		LEDIndex int
+	}, error) {
+		return []struct {
+			IP       string
+			SegID    int
+			LEDIndex int
+		}{{IP: "1.1", SegID: 0, LEDIndex: 0}, {IP: "3.3", SegID: 0, LEDIndex: 0}, {IP: "3.3", SegID: 0, LEDIndex: 1}}, nil
+	}
+	mw.SendCommandFunc = func(ip string, s models.WLEDState) error {
+		if ip == "3.3" {
+			return fmt.Errorf("%s: %w, command queued", ip, wled.ErrOffline)
+		}
+		return nil
+	}
+
+	form := url.Values{"level": {"all"}}
+	req := httptest.NewRequest("POST", "/api/v1/stock-status", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	h.handleShowStockStatus(rr, req)
+
+	if !strings.Contains(rr.Body.String(), "1 of 3 bins lit; Cabinet C is offline. 2 bins light up once their controller is back.") {
+		t.Errorf("Expected the offline controller to be reported, got %s", rr.Body.String())
+	}
+	// The queued command keeps its LEDs claimed, so Stop releases them later
+	if owned := h.states.(*wled.StateKeeper).Owned("3.3"); owned != 2 {
+		t.Errorf("Expected the offline controller's LEDs to stay claimed, got %d", owned)
+	}
+
+	// A controller that turns the command down lights nothing later
+	mw.SendCommandFunc = func(ip string, s models.WLEDState) error {
+		if ip == "3.3" {
+			return errors.New("3.3: unexpected status 401 Unauthorized")
+		}
+		return nil
+	}
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/api/v1/stock-status", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	h.handleShowStockStatus(rr, req)
+	if body := rr.Body.String(); !strings.Contains(body, "1 of 3 bins lit") || strings.Contains(body, "light up once") {
+		t.Errorf("Expected the rejected bins not to be reported as queued, got %s", body)
+	}
+	if owned := h.states.(*wled.StateKeeper).Owned("3.3"); owned != 0 {
+		t.Errorf("Expected the rejected LEDs to be released, got %d", owned)
+	}
+}
+
+func TestHandleShowStockStatus_QuietHours(t *testing.T) {
+	h, ms, _ := setupTest(t)
+	h.QuietHours = quietHours(true)
+
+	ms.GetDashboardBinDataFunc = func(filter models.StockStatusFilter) ([]models.DashboardBinData, error) {
+		return []models.DashboardBinData{
+			{BinQuantity: 0, MinStock: 5, ReorderPoint: 10, BinIP: "1.1", BinSegmentID: 0, BinLEDIndex: 0},
+		}, nil
+	}
+
+	form := url.Values{"level": {"all"}}
+	req := httptest.NewRequest("POST", "/api/v1/stock-status", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	h.handleShowStockStatus(rr, req)
+
+	body := rr.Body.String()
+	if !strings.Contains(body, "Quiet hours.") || strings.Contains(body, "Success!") {
+		t.Errorf("Expected quiet hours to be reported instead of success, got %s", body)
+	}
+}
+
+func TestOfflineNote(t *testing.T) {
+	if got := offlineNote([]string{"Cabinet C", "Cabinet A", "Cabinet B"}); got != "Cabinet A, Cabinet B and Cabinet C are offline" {
+		t.Errorf("Unexpected note: %q", got)
+	}
+}
+
+func TestHandleShowStockStatus_EvalModes(t *testing.T) {
+	h, ms, _ := setupTest(t)
+
+	// One part (min 50, reorder 80) spread over 3 bins of 40 each (120 total),
+	// plus a location override on the last bin
+	ms.GetDashboardBinDataFunc = func(filter models.StockStatusFilter) ([]models.DashboardBinData, error) {
+		bin := models.DashboardBinData{PartID: 1, MinStock: 50, ReorderPoint: 80, PartQuantity: 120, BinQuantity: 40, BinIP: "1.1.1.1"}
+		overridden := bin
+		overridden.BinLEDIndex = 2
+		overridden.LocationMinStock = sql.NullInt64{Int64: 10, Valid: true}
+		overridden.LocationReorderPoint = sql.NullInt64{Int64: 20, Valid: true}
+		second := bin
+		second.BinLEDIndex = 1
+		return []models.DashboardBinData{bin, second, overridden}, nil
+	}
+
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{"bin", "Lit 3 bins"},      // every bin is 40 <= 50
+		{"", "Lit 3 bins"},         // default is bin
+		{"part", "Lit 0 bins"},     // 120 is above both thresholds
+		{"location", "Lit 2 bins"}, // the overridden bin is fine on its own
+	}
+
+	for _, tc := range tests {
+		form := url.Values{"level": {"critical"}, "mode": {tc.mode}}
+		req := httptest.NewRequest("POST", "/api/v1/stock-status", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		h.handleShowStockStatus(rr, req)
+		if !strings.Contains(rr.Body.String(), tc.want) {
+			t.Errorf("mode %q: expected %q, got %s", tc.mode, tc.want, rr.Body.String())
+		}
+	}
+}
+
+func TestHandleShowStockStatus_CustomRules(t *testing.T) {
+	h, ms, mw := setupTest(t)
+
+	ms.GetDashboardBinDataFunc = func(filter models.StockStatusFilter) ([]models.DashboardBinData, error) {
+		return []models.DashboardBinData{
+			{PartName: "Resistor", Supplier: sql.NullString{String: "Digi-Key", Valid: true}, BinQuantity: 3, BinIP: "1.1.1.1"},
+			{PartName: "Bolt", BinQuantity: 3, BinIP: "1.1.1.1", BinLEDIndex: 1},
+		}, nil
+	}
+	ms.GetStockRulesFunc = func() ([]models.StockRule, error) {
+		return []models.StockRule{
+			{
+				Name: "Digi-Key order", Priority: 1, Enabled: true, Severity: stockstatus.SeverityAttention,
+				Action: stockstatus.ActionColor, Color: "0000FF",
+				Conditions: []models.StockRuleCondition{{Field: stockstatus.FieldSupplier, Operator: stockstatus.OpEqual, Value: "digi-key"}},
+			},
+			{Name: "Tell someone", Priority: 2, Enabled: true, Severity: stockstatus.SeverityAttention, Action: stockstatus.ActionNotify},
+		}, nil
+	}
+
+	var sent []models.WLEDState
+	mw.SendCommandFunc = func(ip string, state models.WLEDState) error {
+		sent = append(sent, state)
+		return nil
+	}
+
+	form := url.Values{"level": {"all"}}
+	req := httptest.NewRequest("POST", "/api/v1/stock-status", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	h.handleShowStockStatus(rr, req)
+
+	if !strings.Contains(rr.Body.String(), "Lit 1 bins, 2 bins match a notification rule") {
+		t.Errorf("Unexpected response: %s", rr.Body.String())
+	}
+	if len(sent) == 0 {
+		t.Fatalf("Expected a WLED command")
+	}
+	last := sent[len(sent)-1].Segments[0].I
+	if len(last) != 2 || last[1] != "0000FF" {
+		t.Errorf("Expected bin lit blue, got %v", last)
+	}
+}
+
+func TestShowStockStatus_NotifyRulesDontBlockLighting(t *testing.T) {
+	h, ms, mw := setupTest(t)
+	ms.GetDashboardBinDataFunc = func(filter models.StockStatusFilter) ([]models.DashboardBinData, error) {
+		return []models.DashboardBinData{{PartName: "Resistor", BinQuantity: 1, MinStock: 2, BinIP: "1.1.1.1"}}, nil
+	}
+	ms.GetStockRulesFunc = func() ([]models.StockRule, error) {
+		return []models.StockRule{
+			{Name: "Tell someone", Priority: 1, Enabled: true, Severity: stockstatus.SeverityCritical, Action: stockstatus.ActionNotify},
+			{
+				Name: "Critical", Priority: 2, Enabled: true, Severity: stockstatus.SeverityCritical, Action: stockstatus.ActionColor, Color: "FF0000",
+				Conditions: []models.StockRuleCondition{{Field: stockstatus.FieldQuantity, Operator: stockstatus.OpLessOrEqual, Value: stockstatus.FieldMinStock}},
+			},
+		}, nil
+	}
+	var sent []models.WLEDState
+	mw.SendCommandFunc = func(ip string, state models.WLEDState) error {
+		sent = append(sent, state)
+		return nil
+	}
+
+	result, err := h.ShowStockStatus(t.Context(), models.StockStatusPreset{Level: "critical"}, "")
+	if err != nil {
+		t.Fatalf("ShowStockStatus failed: %v", err)
+	}
+	if result.BinsLit != 1 || result.Notifications != 1 {
+		t.Errorf("Expected the bin lit and counted for the notification, got %+v", result)
+	}
+	last := sent[len(sent)-1].Segments[0].I
+	if len(last) != 2 || last[1] != "FF0000" {
+		t.Errorf("Expected the bin lit red, got %v", last)
+	}
+}
+
+func TestHandleShowStockStatus_ScopeAndPresets(t *testing.T) {
+	h, ms, _ := setupTest(t)
+
+	var gotFilter models.StockStatusFilter
+	ms.GetDashboardBinDataFunc = func(filter models.StockStatusFilter) ([]models.DashboardBinData, error) {
+		gotFilter = filter
+		return nil, nil
+	}
+
+	// Filters are passed through to the store
+	form := url.Values{"level": {"attention"}, "supplier": {" Digi-Key "}, "category": {"Passives"}, "controller_id": {"2"}, "location": {"B"}}
+	req := httptest.NewRequest("POST", "/api/v1/stock-status", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	h.handleShowStockStatus(rr, req)
+
+	want := models.StockStatusFilter{Category: "Passives", Supplier: "Digi-Key", ControllerID: 2, Location: "B"}
+	if gotFilter != want {
+		t.Errorf("got filter %+v, want %+v", gotFilter, want)
+	}
+
+	// Save the view as a preset
+	form.Set("name", "Digi-Key order")
+	req = httptest.NewRequest("POST", "/dashboard/presets", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	h.handleCreatePreset(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Create preset: got %d", rr.Code)
+	}
+	if ms.Presets[1].Level != "attention" || ms.Presets[1].Mode != EvalModeBin {
+		t.Errorf("Preset not saved as expected: %+v", ms.Presets[1])
+	}
+
+	// Duplicate name
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/dashboard/presets", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	h.handleCreatePreset(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Duplicate preset: got %d, want 409", rr.Code)
+	}
+
+	// Running a preset uses its saved filter
+	gotFilter = models.StockStatusFilter{}
+	req = httptest.NewRequest("POST", "/api/v1/stock-status", strings.NewReader(url.Values{"preset_id": {"1"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	h.handleShowStockStatus(rr, req)
+	if gotFilter != want {
+		t.Errorf("Preset: got filter %+v, want %+v", gotFilter, want)
+	}
+
+	// Unknown preset
+	req = httptest.NewRequest("POST", "/api/v1/stock-status", strings.NewReader(url.Values{"preset_id": {"9"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	h.handleShowStockStatus(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Unknown preset: got %d, want 404", rr.Code)
+	}
+
+	// Dashboard lists the preset
+	rr = httptest.NewRecorder()
+	h.handleShowDashboard(rr, httptest.NewRequest("GET", "/dashboard", nil))
+	if !strings.Contains(rr.Body.String(), "Digi-Key order") {
+		t.Errorf("Expected preset on dashboard page")
+	}
+
+	// Delete
+	r := chi.NewRouter()
+	r.Delete("/dashboard/presets/{id}", h.handleDeletePreset)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("DELETE", "/dashboard/presets/1", nil))
+	if rr.Code != http.StatusOK || len(ms.Presets) != 0 {
+		t.Errorf("Delete preset: got %d, %d left", rr.Code, len(ms.Presets))
+	}
+}
+
+func TestFillLevelColor(t *testing.T) {
+	tests := []struct {
+		fill  float64
+		style string
+		want  string
+	}{
+		{0, FillStyleGradient, "FF0000"},
+		{0.5, FillStyleGradient, "FFFF00"},
+		{1, FillStyleGradient, "00FF00"},
+		{0.25, FillStyleBrightness, "404040"},
+		{0, FillStyleBrightness, "0D0D0D"},
+		{1, FillStyleBrightness, "FFFFFF"},
+	}
+	for _, tc := range tests {
+		if got := fillLevelColor(tc.fill, tc.style); got != tc.want {
+			t.Errorf("fillLevelColor(%v, %s) = %s, want %s", tc.fill, tc.style, got, tc.want)
+		}
+	}
+}
+
+func TestHandleShowStockStatus_FillLevel(t *testing.T) {
+	h, ms, mw := setupTest(t)
+
+	ms.GetDashboardBinDataFunc = func(filter models.StockStatusFilter) ([]models.DashboardBinData, error) {
+		return []models.DashboardBinData{
+			{BinQuantity: 50, BinCapacity: 200, BinIP: "1.1.1.1"},                                                                          // 25%
+			{BinQuantity: 50, BinCapacity: 200, LocationCapacity: sql.NullInt64{Int64: 50, Valid: true}, BinLEDIndex: 1, BinIP: "1.1.1.1"}, // 100%
+			{BinQuantity: 50, BinLEDIndex: 2, BinIP: "1.1.1.1"},                                                                            // No capacity
+		}, nil
+	}
+
+	var sent []models.WLEDState
+	mw.SendCommandFunc = func(ip string, state models.WLEDState) error {
+		sent = append(sent, state)
+		return nil
+	}
+
+	form := url.Values{"level": {"all"}, "fill": {"brightness"}}
+	req := httptest.NewRequest("POST", "/api/v1/stock-status", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	h.handleShowStockStatus(rr, req)
+
+	body := rr.Body.String()
+	if !strings.Contains(body, "Lit 2 bins") || !strings.Contains(body, "1 bins were skipped") {
+		t.Errorf("Unexpected response: %s", body)
+	}
+
+	last := sent[len(sent)-1].Segments[0].I
+	want := []interface{}{0, "404040", 1, "FFFFFF"}
+	if len(last) != len(want) {
+		t.Fatalf("got payload %v, want %v", last, want)
+	}
+	for i := range want {
+		if last[i] != want[i] {
+			t.Errorf("got payload %v, want %v", last, want)
+			break
+		}
+	}
+}
+
 func TestHandleStopAll(t *testing.T) {
 	h, ms, _ := setupTest(t)
 	ms.GetAllBinLocationsForStopAllFunc = func() ([]struct {
@@ -209,6 +671,28 @@ func TestHandleStopAll(t *testing.T) {
 	}
 }
 
+func TestHandleStopAll_Zone(t *testing.T) {
+	h, _, mw := setupTest(t)
+	sent := map[string]bool{}
+	mw.SendCommandFunc = func(ip string, s models.WLEDState) error {
+		sent[ip] = true
+		return nil
+	}
+
+	// The browser's zone is used when the request doesn't name one
+	req := httptest.NewRequest("POST", "/api/v1/stop-all", nil)
+	req.AddCookie(&http.Cookie{Name: core.ZoneCookie, Value: "2"})
+	rr := httptest.NewRecorder()
+	h.handleStopAll(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %d", rr.Code)
+	}
+	if len(sent) != 1 || !sent["2.1"] {
+		t.Errorf("Expected only zone 2 to be turned off, sent to %v", sent)
+	}
+}
+
 func TestHandleLocatePart(t *testing.T) {
 	h, ms, mw := setupTest(t)
 
@@ -238,6 +722,9 @@ func TestHandleLocatePart(t *testing.T) {
 	if rr.Code != http.StatusOK {
 		t.Errorf("Happy: got %d", rr.Code)
 	}
+	if h.activity.(*activity.Tracker).IsIdle(0) {
+		t.Errorf("Expected locating to mark the LEDs as in use")
+	}
 
 	// Offline (WLED Error)
 	mw.SendCommandFunc = func(ip string, s models.WLEDState) error { return errors.New("offline") }
@@ -265,6 +752,89 @@ func TestHandleLocatePart(t *testing.T) {
 	}
 }
 
+func TestHandleLocatePart_Offline(t *testing.T) {
+	h, ms, mw := setupTest(t)
+
+	ms.GetPartLocationsForLocateFunc = func(id int) ([]struct {
+		IP       string
+		SegID    int
+		LEDIndex int
+	}, error) {
+		return []struct {
+			IP       string
+			SegID    int
+			LEDIndex int
+		}{{IP: "1.1", SegID: 0, LEDIndex: 0}, {IP: "1.1", SegID: 0, LEDIndex: 1}, {IP: "3.3", SegID: 0, LEDIndex: 0}}, nil
+	}
+	mw.SendCommandFunc = func(ip string, s models.WLEDState) error {
+		if ip == "3.3" {
+			return fmt.Errorf("%s: %w, command queued", ip, wled.ErrOffline)
+		}
+		return nil
+	}
+
+	r := chi.NewRouter()
+	r.Post("/locate/part/{id}", h.handleLocatePart)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("POST", "/locate/part/1", nil))
+
+	body := rr.Body.String()
+	if !strings.Contains(body, "2 of 3 bins lit; Cabinet C is offline") {
+		t.Errorf("Expected the offline controller to be reported, got %s", body)
+	}
+	if !strings.Contains(body, `hx-post="/locate/stop/1"`) {
+		t.Errorf("Expected the stop button while bins are lit")
+	}
+
+	// The queued command keeps its LEDs claimed, so Stop releases them later
+	if owned := h.states.(*wled.StateKeeper).Owned("3.3"); owned != 1 {
+		t.Errorf("Expected the offline controller's LED to stay claimed, got %d", owned)
+	}
+}
+
+// quietHours fakes the scheduler's quiet hours
+type quietHours bool
+
+func (q quietHours) ControllersOff() bool { return bool(q) }
+
+func TestHandleLocatePart_QuietHours(t *testing.T) {
+	h, ms, _ := setupTest(t)
+	ms.GetPartLocationsForLocateFunc = func(id int) ([]struct {
+		IP       string
+		SegID    int
+		LEDIndex int
+	}, error) {
+		return []struct {
+			IP       string
+			SegID    int
+			LEDIndex int
+		}{{IP: "1.1", SegID: 0, LEDIndex: 0}}, nil
+	}
+
+	r := chi.NewRouter()
+	r.Post("/locate/part/{id}", h.handleLocatePart)
+
+	locate := func() string {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("POST", "/locate/part/1", nil))
+		return rr.Body.String()
+	}
+
+	h.QuietHours = quietHours(false)
+	if body := locate(); strings.Contains(body, quietNote) {
+		t.Errorf("Expected no quiet hours note, got %s", body)
+	}
+
+	h.QuietHours = quietHours(true)
+	body := locate()
+	if !strings.Contains(body, quietNote) {
+		t.Errorf("Expected the quiet hours note, got %s", body)
+	}
+	if !strings.Contains(body, `hx-post="/locate/stop/1"`) {
+		t.Errorf("Expected the stop button, the bins are lit once quiet hours end")
+	}
+}
+
 func TestHandleStopLocate(t *testing.T) {
 	h, ms, _ := setupTest(t)
 
@@ -309,3 +879,57 @@ func TestHandleGetLocateButton(t *testing.T) {
 		t.Errorf("got %d", rr.Code)
 	}
 }
+
+func TestLocate_RestoresControllerState(t *testing.T) {
+	h, ms, mw := setupTest(t)
+	ms.RestoreIPs = map[string]bool{"1.1": true}
+
+	parts := map[int][]struct {
+		IP       string
+		SegID    int
+		LEDIndex int
+	}{
+		1: {{IP: "1.1", SegID: 0, LEDIndex: 0}, {IP: "2.2", SegID: 0, LEDIndex: 0}},
+		2: {{IP: "1.1", SegID: 0, LEDIndex: 5}},
+	}
+	locations := func(id int) ([]struct {
+		IP       string
+		SegID    int
+		LEDIndex int
+	}, error) {
+		return parts[id], nil
+	}
+	ms.GetPartLocationsForLocateFunc = locations
+	ms.GetPartLocationsForStopFunc = locations
+
+	r := chi.NewRouter()
+	r.Post("/locate/part/{id}", h.handleLocatePart)
+	r.Post("/locate/stop/{id}", h.handleStopLocate)
+	post := func(path string) {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("POST", path, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: got %d", path, rr.Code)
+		}
+	}
+
+	post("/locate/part/1")
+	post("/locate/part/2")
+	if mw.Snapshots != 1 {
+		t.Fatalf("Expected one snapshot of the opted-in controller, got %d", mw.Snapshots)
+	}
+
+	// Part 2 is still lit on 1.1
+	post("/locate/stop/1")
+	if len(mw.Restored) != 0 {
+		t.Fatalf("Restored while LEDs are still lit: %v", mw.Restored)
+	}
+
+	post("/locate/stop/2")
+	if mw.Restored["1.1"] != `{"on":true,"ps":4}` {
+		t.Errorf("Expected 1.1 to be restored, got %v", mw.Restored)
+	}
+	if _, ok := mw.Restored["2.2"]; ok {
+		t.Errorf("2.2 didn't opt in and shouldn't be restored")
+	}
+}
@@ -1,71 +1,91 @@
 package hardware
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 
 	"wledger/internal/core"
+	"wledger/internal/endpoint"
 	"wledger/internal/models"
+	"wledger/internal/store"
 )
 
 type Store interface {
-	GetControllerByID(id int) (models.WLEDController, error)
-	GetControllers() ([]models.WLEDController, error)
-	CreateController(name, ipAddress string) error
-	UpdateController(c *models.WLEDController) error
-	DeleteController(id int) error
-	UpdateControllerStatus(id int, status string, lastSeen sql.NullTime) error
-	MigrateBins(oldControllerID, newControllerID int) error
-	GetBins() ([]models.Bin, error)
+	GetControllerByID(ctx context.Context, id int) (models.WLEDController, error)
+	GetControllers(ctx context.Context) ([]models.WLEDController, error)
+	CreateController(ctx context.Context, c *models.WLEDController) error
+	UpdateController(ctx context.Context, c *models.WLEDController) error
+	DeleteController(ctx context.Context, id int) error
+	UpdateControllerStatus(ctx context.Context, id int, status string, lastSeen sql.NullTime) error
+	MigrateBins(ctx context.Context, oldControllerID, newControllerID, segmentOffset, ledOffset int) error
+	GetControllerStock(ctx context.Context, id int) (int, error)
+	DeleteControllerWithBins(ctx context.Context, id int, d models.BinDisposal) error
+	GetBins(ctx context.Context) ([]models.Bin, error)
 }
 
 type WLEDClient interface {
 	Ping(ipAddress string) bool
 }
 
+// Forgetter drops what's kept in memory about a controller address, like
+// its breaker and saved state, once the controller moved to another address
+type Forgetter interface {
+	Forget(ipAddress string)
+}
+
 type Handler struct {
 	store     Store
 	wled      WLEDClient
+	forget    Forgetter
 	templates core.TemplateExecutor
 }
 
-func New(s Store, w WLEDClient, t core.TemplateExecutor) *Handler {
-	return &Handler{store: s, wled: w, templates: t}
+func New(s Store, w WLEDClient, f Forgetter, t core.TemplateExecutor) *Handler {
+	return &Handler{store: s, wled: w, forget: f, templates: t}
 }
 
 func (h *Handler) RegisterRoutes(r chi.Router) {
-	r.Get("/settings", h.handleShowSettings)
-	r.Post("/settings/controllers", h.handleCreateController)
-	r.Delete("/settings/controllers/{id}", h.handleDeleteController)
-	r.Post("/settings/controllers/{id}/refresh", h.handleRefreshControllerStatus)
-
-	r.Get("/settings/controllers/{id}", h.handleGetControllerRow)
-	r.Get("/settings/controllers/{id}/edit", h.handleGetControllerEditRow)
-	r.Put("/settings/controllers/{id}", h.handleUpdateController)
-	r.Get("/settings/controllers/{id}/migrate", h.handleGetControllerMigrateRow)
-	r.Post("/settings/controllers/{id}/migrate", h.handleMigrateController)
+	r.Group(func(r chi.Router) {
+		r.Use(core.Require(models.RoleAdmin))
+		r.Get("/settings", h.handleShowSettings)
+		r.Post("/settings/controllers", h.handleCreateController)
+		r.Delete("/settings/controllers/{id}", h.handleDeleteController)
+		r.Post("/settings/controllers/{id}/refresh", h.handleRefreshControllerStatus)
+
+		r.Get("/settings/controllers/{id}", h.handleGetControllerRow)
+		r.Get("/settings/controllers/{id}/edit", h.handleGetControllerEditRow)
+		r.Put("/settings/controllers/{id}", h.handleUpdateController)
+		r.Get("/settings/controllers/{id}/migrate", h.handleGetControllerMigrateRow)
+		r.Post("/settings/controllers/{id}/migrate", h.handleMigrateController)
+		r.Get("/settings/controllers/{id}/delete", h.handleGetControllerDeleteRow)
+		r.Post("/settings/controllers/{id}/delete", h.handleDeleteControllerWithBins)
+	})
 }
 
 // Handlers
 
 func (h *Handler) handleShowSettings(w http.ResponseWriter, r *http.Request) {
-	controllers, err := h.store.GetControllers()
+	controllers, err := h.store.GetControllers(r.Context())
 	if err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
-	bins, err := h.store.GetBins()
+	bins, err := h.store.GetBins(r.Context())
 	if err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
 	data := map[string]interface{}{
 		"Title":       "Settings",
+		"CSRFToken":   core.CSRFToken(r),
 		"Controllers": controllers,
 		"Bins":        bins,
 	}
@@ -75,21 +95,54 @@ func (h *Handler) handleShowSettings(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// endpointFromForm reads the controller's address and optional login.
+// A blank password keeps the current one, unless the username changed.
+func endpointFromForm(r *http.Request, current models.WLEDEndpoint) (models.WLEDEndpoint, error) {
+	e, err := endpoint.Parse(r.FormValue("ip_address"))
+	if err != nil {
+		return e, err
+	}
+
+	// Credentials typed into the address win over the login fields
+	if e.Username == "" {
+		e.Username = strings.TrimSpace(r.FormValue("username"))
+		e.Password = r.FormValue("password")
+		if e.Password == "" && e.Username == current.Username {
+			e.Password = current.Password
+		}
+	}
+	return e, endpoint.Validate(e)
+}
+
+// writeControllerError reports an invalid endpoint or a duplicate address
+func writeControllerError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, store.ErrUniqueConstraint) {
+		core.ClientError(w, r, http.StatusConflict, "A controller with this address already exists.", err)
+	} else {
+		core.ServerError(w, r, err)
+	}
+}
+
 func (h *Handler) handleCreateController(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		core.ClientError(w, r, http.StatusBadRequest, "Bad Request", err)
 		return
 	}
-	name := r.FormValue("name")
-	ipAddress := r.FormValue("ip_address")
+	name := strings.TrimSpace(r.FormValue("name"))
 
-	if name == "" || ipAddress == "" {
+	if name == "" || r.FormValue("ip_address") == "" {
 		core.ClientError(w, r, http.StatusBadRequest, "Name and IP Address are required", nil)
 		return
 	}
 
-	if err := h.store.CreateController(name, ipAddress); err != nil {
-		core.ServerError(w, r, err)
+	e, err := endpointFromForm(r, models.WLEDEndpoint{})
+	if err != nil {
+		core.ClientError(w, r, http.StatusBadRequest, "Invalid address: "+err.Error(), err)
+		return
+	}
+
+	if err := h.store.CreateController(r.Context(), &models.WLEDController{Name: name, Endpoint: e}); err != nil {
+		writeControllerError(w, r, err)
 		return
 	}
 
@@ -103,7 +156,7 @@ func (h *Handler) handleDeleteController(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err := h.store.DeleteController(id)
+	err := h.store.DeleteController(r.Context(), id)
 	if err != nil {
 		if err.Error() == "foreign key constraint violation" {
 			core.ClientError(w, r, http.StatusConflict, "Cannot delete controller: It is in use by one or more bins.", err)
@@ -123,7 +176,7 @@ func (h *Handler) handleRefreshControllerStatus(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	controller, err := h.store.GetControllerByID(id)
+	controller, err := h.store.GetControllerByID(r.Context(), id)
 	if err != nil {
 		core.ClientError(w, r, http.StatusNotFound, "Controller not found", err)
 		return
@@ -141,12 +194,12 @@ func (h *Handler) handleRefreshControllerStatus(w http.ResponseWriter, r *http.R
 		lastSeen.Valid = false
 	}
 
-	if err := h.store.UpdateControllerStatus(id, status, lastSeen); err != nil {
+	if err := h.store.UpdateControllerStatus(r.Context(), id, status, lastSeen); err != nil {
 		// Log error but do NOT return 500 response
 		log.Printf("Error updating controller status: %v", err)
 	}
 
-	updatedController, err := h.store.GetControllerByID(id)
+	updatedController, err := h.store.GetControllerByID(r.Context(), id)
 	if err != nil {
 		core.ClientError(w, r, http.StatusNotFound, "Controller not found", err)
 		return
@@ -156,7 +209,7 @@ func (h *Handler) handleRefreshControllerStatus(w http.ResponseWriter, r *http.R
 
 func (h *Handler) handleGetControllerRow(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
-	controller, err := h.store.GetControllerByID(id)
+	controller, err := h.store.GetControllerByID(r.Context(), id)
 	if err != nil {
 		core.ClientError(w, r, http.StatusNotFound, "Controller not found", err)
 		return
@@ -166,7 +219,7 @@ func (h *Handler) handleGetControllerRow(w http.ResponseWriter, r *http.Request)
 
 func (h *Handler) handleGetControllerEditRow(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
-	controller, err := h.store.GetControllerByID(id)
+	controller, err := h.store.GetControllerByID(r.Context(), id)
 	if err != nil {
 		core.ClientError(w, r, http.StatusNotFound, "Controller not found", err)
 		return
@@ -181,52 +234,100 @@ func (h *Handler) handleUpdateController(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	current, err := h.store.GetControllerByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			core.ClientError(w, r, http.StatusNotFound, "Controller not found", err)
+		} else {
+			core.ServerError(w, r, err)
+		}
+		return
+	}
+
 	controller := &models.WLEDController{
-		ID:        id,
-		Name:      r.FormValue("name"),
-		IPAddress: r.FormValue("ip_address"),
+		ID:           id,
+		Name:         strings.TrimSpace(r.FormValue("name")),
+		RestoreState: r.FormValue("restore_state") == "on",
 	}
+	controller.ZoneID, _ = strconv.Atoi(r.FormValue("zone_id"))
 
-	if controller.Name == "" || controller.IPAddress == "" {
+	if controller.Name == "" || r.FormValue("ip_address") == "" {
 		core.ClientError(w, r, http.StatusBadRequest, "Name and IP are required", nil)
 		return
 	}
 
-	if err := h.store.UpdateController(controller); err != nil {
-		core.ServerError(w, r, err)
+	controller.Endpoint, err = endpointFromForm(r, current.Endpoint)
+	if err != nil {
+		core.ClientError(w, r, http.StatusBadRequest, "Invalid address: "+err.Error(), err)
 		return
 	}
 
-	updated, err := h.store.GetControllerByID(id)
+	if err := h.store.UpdateController(r.Context(), controller); err != nil {
+		writeControllerError(w, r, err)
+		return
+	}
+
+	updated, err := h.store.GetControllerByID(r.Context(), id)
 	if err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
+	if updated.IPAddress != current.IPAddress {
+		h.forget.Forget(current.IPAddress)
+	}
 	h.templates.ExecuteTemplate(w, "_controller-row.html", updated)
 }
 
+// migrationTargets returns every controller except the source
+func (h *Handler) migrationTargets(ctx context.Context, sourceID int) ([]models.WLEDController, error) {
+	all, err := h.store.GetControllers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := []models.WLEDController{}
+	for _, c := range all {
+		if c.ID != sourceID {
+			targets = append(targets, c)
+		}
+	}
+	return targets, nil
+}
+
+// ledConflictMessage is shown when moved bins would land on LEDs the
+// target controller's bins already use
+const ledConflictMessage = "Some bins would land on LEDs already used by the target controller's bins. Pick other offsets, or move those bins first."
+
+// offsetsFromForm reads the segment and LED offsets of a bin migration, blank is 0
+func offsetsFromForm(r *http.Request) (segmentOffset, ledOffset int, err error) {
+	if v := r.FormValue("segment_offset"); v != "" {
+		if segmentOffset, err = strconv.Atoi(v); err != nil {
+			return 0, 0, err
+		}
+	}
+	if v := r.FormValue("led_offset"); v != "" {
+		if ledOffset, err = strconv.Atoi(v); err != nil {
+			return 0, 0, err
+		}
+	}
+	return segmentOffset, ledOffset, nil
+}
+
 func (h *Handler) handleGetControllerMigrateRow(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
 
-	source, err := h.store.GetControllerByID(id)
+	source, err := h.store.GetControllerByID(r.Context(), id)
 	if err != nil {
 		core.ClientError(w, r, http.StatusNotFound, "Controller not found", err)
 		return
 	}
 
-	all, err := h.store.GetControllers()
+	targets, err := h.migrationTargets(r.Context(), source.ID)
 	if err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
 
-	targets := []models.WLEDController{}
-	for _, c := range all {
-		if c.ID != source.ID {
-			targets = append(targets, c)
-		}
-	}
-
 	if len(targets) == 0 {
 		core.ClientError(w, r, http.StatusConflict, "No other controllers available to migrate to.", nil)
 		return
@@ -253,15 +354,114 @@ func (h *Handler) handleMigrateController(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if err := h.store.MigrateBins(oldID, newID); err != nil {
-		core.ServerError(w, r, err)
+	segmentOffset, ledOffset, err := offsetsFromForm(r)
+	if err != nil {
+		core.ClientError(w, r, http.StatusBadRequest, "Offsets must be whole numbers", err)
+		return
+	}
+
+	if err := h.store.MigrateBins(r.Context(), oldID, newID, segmentOffset, ledOffset); err != nil {
+		if errors.Is(err, store.ErrInvalidOffset) {
+			core.ClientError(w, r, http.StatusBadRequest, "These offsets would move bins below segment or LED 0.", err)
+		} else if errors.Is(err, store.ErrLEDConflict) {
+			core.ClientError(w, r, http.StatusConflict, ledConflictMessage, err)
+		} else {
+			core.ServerError(w, r, err)
+		}
 		return
 	}
 
-	updatedSource, err := h.store.GetControllerByID(oldID)
+	updatedSource, err := h.store.GetControllerByID(r.Context(), oldID)
 	if err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
 	h.templates.ExecuteTemplate(w, "_controller-row.html", updatedSource)
 }
+
+// handleGetControllerDeleteRow shows the deletion wizard for a controller that still has bins
+func (h *Handler) handleGetControllerDeleteRow(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	source, err := h.store.GetControllerByID(r.Context(), id)
+	if err != nil {
+		core.ClientError(w, r, http.StatusNotFound, "Controller not found", err)
+		return
+	}
+
+	targets, err := h.migrationTargets(r.Context(), source.ID)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+
+	items, err := h.store.GetControllerStock(r.Context(), source.ID)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Source":  source,
+		"Targets": targets,
+		"Items":   items,
+	}
+	h.templates.ExecuteTemplate(w, "_controller-delete-row.html", data)
+}
+
+// handleDeleteControllerWithBins moves, detaches or deletes the controller's
+// bins, then deletes the controller
+func (h *Handler) handleDeleteControllerWithBins(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	if id == 0 {
+		core.ClientError(w, r, http.StatusBadRequest, "Invalid ID", nil)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		core.ClientError(w, r, http.StatusBadRequest, "Bad Request", err)
+		return
+	}
+
+	d := models.BinDisposal{Action: r.FormValue("bins")}
+	switch d.Action {
+	case models.BinsMove:
+		d.TargetControllerID, _ = strconv.Atoi(r.FormValue("new_controller_id"))
+		if d.TargetControllerID == 0 {
+			core.ClientError(w, r, http.StatusBadRequest, "Pick a controller to move the bins to.", nil)
+			return
+		}
+		var err error
+		if d.SegmentOffset, d.LEDOffset, err = offsetsFromForm(r); err != nil {
+			core.ClientError(w, r, http.StatusBadRequest, "Offsets must be whole numbers", err)
+			return
+		}
+	case models.BinsDetach:
+	case models.BinsDelete:
+		if r.FormValue("confirm") != "on" {
+			core.ClientError(w, r, http.StatusBadRequest, "Confirm that the bins and their stock will be deleted.", nil)
+			return
+		}
+	default:
+		core.ClientError(w, r, http.StatusBadRequest, "Choose what happens to the bins.", nil)
+		return
+	}
+
+	if err := h.store.DeleteControllerWithBins(r.Context(), id, d); err != nil {
+		switch {
+		case errors.Is(err, store.ErrInvalidOffset):
+			core.ClientError(w, r, http.StatusBadRequest, "These offsets would move bins below segment or LED 0.", err)
+		case errors.Is(err, store.ErrLEDConflict):
+			core.ClientError(w, r, http.StatusConflict, ledConflictMessage, err)
+		case errors.Is(err, sql.ErrNoRows):
+			core.ClientError(w, r, http.StatusNotFound, "Controller not found", err)
+		default:
+			core.ServerError(w, r, err)
+		}
+		return
+	}
+
+	// The bins table changed as well
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+}
@@ -1,6 +1,7 @@
 package hardware
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"html/template"
@@ -11,6 +12,7 @@ import (
 	"testing"
 
 	"wledger/internal/models"
+	"wledger/internal/store"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -21,12 +23,14 @@ type mockStore struct {
 
 	GetControllerByIDFunc      func(id int) (models.WLEDController, error)
 	GetControllersFunc         func() ([]models.WLEDController, error)
-	CreateControllerFunc       func(name, ipAddress string) error
+	CreateControllerFunc       func(c *models.WLEDController) error
 	UpdateControllerFunc       func(c *models.WLEDController) error
 	DeleteControllerFunc       func(id int) error
 	UpdateControllerStatusFunc func(id int, status string, lastSeen sql.NullTime) error
-	MigrateBinsFunc            func(oldID, newID int) error
+	MigrateBinsFunc            func(oldID, newID, segmentOffset, ledOffset int) error
 	GetBinsFunc                func() ([]models.Bin, error)
+
+	DeleteControllerWithBinsFunc func(id int, d models.BinDisposal) error
 }
 
 func (m *mockStore) retErr() error {
@@ -36,7 +40,7 @@ func (m *mockStore) retErr() error {
 	return nil
 }
 
-func (m *mockStore) GetControllerByID(id int) (models.WLEDController, error) {
+func (m *mockStore) GetControllerByID(ctx context.Context, id int) (models.WLEDController, error) {
 	if m.FailOps {
 		return models.WLEDController{}, errors.New("db error")
 	}
@@ -45,7 +49,7 @@ func (m *mockStore) GetControllerByID(id int) (models.WLEDController, error) {
 	}
 	return models.WLEDController{}, nil
 }
-func (m *mockStore) GetControllers() ([]models.WLEDController, error) {
+func (m *mockStore) GetControllers(ctx context.Context) ([]models.WLEDController, error) {
 	if m.FailOps {
 		return nil, errors.New("db error")
 	}
@@ -54,16 +58,16 @@ func (m *mockStore) GetControllers() ([]models.WLEDController, error) {
 	}
 	return nil, nil
 }
-func (m *mockStore) CreateController(name, ip string) error {
+func (m *mockStore) CreateController(ctx context.Context, c *models.WLEDController) error {
 	if err := m.retErr(); err != nil {
 		return err
 	}
 	if m.CreateControllerFunc != nil {
-		return m.CreateControllerFunc(name, ip)
+		return m.CreateControllerFunc(c)
 	}
 	return nil
 }
-func (m *mockStore) UpdateController(c *models.WLEDController) error {
+func (m *mockStore) UpdateController(ctx context.Context, c *models.WLEDController) error {
 	if err := m.retErr(); err != nil {
 		return err
 	}
@@ -72,13 +76,13 @@ func (m *mockStore) UpdateController(c *models.WLEDController) error {
 	}
 	return nil
 }
-func (m *mockStore) DeleteController(id int) error {
+func (m *mockStore) DeleteController(ctx context.Context, id int) error {
 	if m.DeleteControllerFunc != nil {
 		return m.DeleteControllerFunc(id)
 	}
 	return m.retErr()
 }
-func (m *mockStore) UpdateControllerStatus(id int, status string, lastSeen sql.NullTime) error {
+func (m *mockStore) UpdateControllerStatus(ctx context.Context, id int, status string, lastSeen sql.NullTime) error {
 	// Special handling for refresh test:
 	// We want to test "Update fails but flow continues" vs "DB Error generally"
 	if m.UpdateControllerStatusFunc != nil {
@@ -89,16 +93,31 @@ func (m *mockStore) UpdateControllerStatus(id int, status string, lastSeen sql.N
 	}
 	return nil
 }
-func (m *mockStore) MigrateBins(oldID, newID int) error {
+func (m *mockStore) MigrateBins(ctx context.Context, oldID, newID, segmentOffset, ledOffset int) error {
 	if err := m.retErr(); err != nil {
 		return err
 	}
 	if m.MigrateBinsFunc != nil {
-		return m.MigrateBinsFunc(oldID, newID)
+		return m.MigrateBinsFunc(oldID, newID, segmentOffset, ledOffset)
 	}
 	return nil
 }
-func (m *mockStore) GetBins() ([]models.Bin, error) {
+func (m *mockStore) GetControllerStock(ctx context.Context, id int) (int, error) {
+	if m.FailOps {
+		return 0, errors.New("db error")
+	}
+	return 42, nil
+}
+func (m *mockStore) DeleteControllerWithBins(ctx context.Context, id int, d models.BinDisposal) error {
+	if err := m.retErr(); err != nil {
+		return err
+	}
+	if m.DeleteControllerWithBinsFunc != nil {
+		return m.DeleteControllerWithBinsFunc(id, d)
+	}
+	return nil
+}
+func (m *mockStore) GetBins(ctx context.Context) ([]models.Bin, error) {
 	if m.FailOps {
 		return nil, errors.New("db error")
 	}
@@ -109,7 +128,12 @@ func (m *mockStore) GetBins() ([]models.Bin, error) {
 }
 
 type mockWLED struct {
-	PingFunc func(ipAddress string) bool
+	PingFunc  func(ipAddress string) bool
+	Forgotten []string
+}
+
+func (m *mockWLED) Forget(ip string) {
+	m.Forgotten = append(m.Forgotten, ip)
 }
 
 func (m *mockWLED) Ping(ip string) bool {
@@ -129,7 +153,7 @@ func setupTest(t *testing.T) (*Handler, *mockStore, *mockWLED) {
 	if tmpl == nil {
 		tmpl, _ = template.ParseGlob("ui/templates/*.html")
 	}
-	h := New(ms, mw, tmpl)
+	h := New(ms, mw, mw, tmpl)
 	return h, ms, mw
 }
 
@@ -158,6 +182,28 @@ func TestHandleCreateController(t *testing.T) {
 		t.Errorf("Missing Data: got %d", rr.Code)
 	}
 
+	// Invalid Address
+	form = url.Values{"name": {"C1"}, "ip_address": {"ftp://1.1.1.1"}}
+	req = httptest.NewRequest("POST", "/settings/controllers", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	h.handleCreateController(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Invalid Address: got %d", rr.Code)
+	}
+
+	// Duplicate Address
+	ms.CreateControllerFunc = func(c *models.WLEDController) error { return store.ErrUniqueConstraint }
+	form = url.Values{"name": {"C2"}, "ip_address": {"1.1.1.1"}}
+	req = httptest.NewRequest("POST", "/settings/controllers", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	h.handleCreateController(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Duplicate Address: got %d", rr.Code)
+	}
+	ms.CreateControllerFunc = nil
+
 	// DB Error
 	ms.FailOps = true
 	form = url.Values{"name": {"C1"}, "ip_address": {"1.1.1.1"}}
@@ -170,6 +216,105 @@ func TestHandleCreateController(t *testing.T) {
 	}
 }
 
+func TestHandleCreateController_Endpoint(t *testing.T) {
+	h, ms, _ := setupTest(t)
+
+	var created *models.WLEDController
+	ms.CreateControllerFunc = func(c *models.WLEDController) error {
+		created = c
+		return nil
+	}
+
+	form := url.Values{
+		"name":       {"Proxy"},
+		"ip_address": {"https://proxy.example:8443/wled"},
+		"username":   {"admin"},
+		"password":   {"secret"},
+	}
+	req := httptest.NewRequest("POST", "/settings/controllers", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	h.handleCreateController(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	want := models.WLEDEndpoint{Scheme: "https", Host: "proxy.example", Port: 8443, BasePath: "/wled", Username: "admin", Password: "secret"}
+	if created == nil || created.Endpoint != want {
+		t.Errorf("Expected endpoint %+v, got %+v", want, created)
+	}
+}
+
+func TestHandleUpdateController_KeepsPassword(t *testing.T) {
+	h, ms, _ := setupTest(t)
+	r := chi.NewRouter()
+	r.Put("/settings/controllers/{id}", h.handleUpdateController)
+
+	ms.GetControllerByIDFunc = func(id int) (models.WLEDController, error) {
+		return models.WLEDController{ID: 1, Name: "C1", Endpoint: models.WLEDEndpoint{Scheme: "http", Host: "1.1.1.1", Username: "admin", Password: "secret"}}, nil
+	}
+	var saved *models.WLEDController
+	ms.UpdateControllerFunc = func(c *models.WLEDController) error {
+		saved = c
+		return nil
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		want     string
+	}{
+		{"Blank Keeps", "admin", "", "secret"},
+		{"New Replaces", "admin", "other", "other"},
+		{"New User Clears", "guest", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"name": {"C1"}, "ip_address": {"1.1.1.1"}, "username": {tt.username}, "password": {tt.password}}
+			req := httptest.NewRequest("PUT", "/settings/controllers/1", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("got %d", rr.Code)
+			}
+			if saved.Endpoint.Password != tt.want {
+				t.Errorf("Expected password %q, got %q", tt.want, saved.Endpoint.Password)
+			}
+		})
+	}
+}
+
+func TestHandleUpdateController_ForgetsOldAddress(t *testing.T) {
+	h, ms, mw := setupTest(t)
+	r := chi.NewRouter()
+	r.Put("/settings/controllers/{id}", h.handleUpdateController)
+
+	stored := models.WLEDController{ID: 1, Name: "C1", IPAddress: "1.1.1.1", Endpoint: models.WLEDEndpoint{Scheme: "http", Host: "1.1.1.1"}}
+	ms.GetControllerByIDFunc = func(id int) (models.WLEDController, error) { return stored, nil }
+	ms.UpdateControllerFunc = func(c *models.WLEDController) error {
+		stored = *c
+		stored.IPAddress = c.Endpoint.Host
+		return nil
+	}
+
+	send := func(address string) {
+		form := url.Values{"name": {"C1"}, "ip_address": {address}}
+		req := httptest.NewRequest("PUT", "/settings/controllers/1", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	send("1.1.1.1")
+	if len(mw.Forgotten) != 0 {
+		t.Errorf("Expected nothing forgotten for the same address, got %v", mw.Forgotten)
+	}
+	send("2.2.2.2")
+	if len(mw.Forgotten) != 1 || mw.Forgotten[0] != "1.1.1.1" {
+		t.Errorf("Expected the old address to be forgotten, got %v", mw.Forgotten)
+	}
+}
+
 func TestHandleDeleteController(t *testing.T) {
 	h, ms, _ := setupTest(t)
 	r := chi.NewRouter()
@@ -246,9 +391,14 @@ func TestHandleUpdateController(t *testing.T) {
 	ms.GetControllerByIDFunc = func(id int) (models.WLEDController, error) {
 		return models.WLEDController{Name: "New"}, nil
 	}
+	var saved *models.WLEDController
+	ms.UpdateControllerFunc = func(c *models.WLEDController) error {
+		saved = c
+		return nil
+	}
 
 	// Happy
-	form := url.Values{"name": {"New"}, "ip_address": {"2.2.2.2"}}
+	form := url.Values{"name": {"New"}, "ip_address": {"2.2.2.2"}, "restore_state": {"on"}, "zone_id": {"3"}}
 	req := httptest.NewRequest("PUT", "/settings/controllers/1", strings.NewReader(form.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	rr := httptest.NewRecorder()
@@ -259,6 +409,9 @@ func TestHandleUpdateController(t *testing.T) {
 	if !strings.Contains(rr.Body.String(), "New") {
 		t.Error("Body missing updated name")
 	}
+	if saved == nil || !saved.RestoreState || saved.ZoneID != 3 {
+		t.Errorf("Expected state restore and zone to be saved, got %+v", saved)
+	}
 
 	// DB Error
 	ms.FailOps = true
@@ -280,13 +433,55 @@ func TestHandleMigrateController(t *testing.T) {
 	ms.GetControllerByIDFunc = func(id int) (models.WLEDController, error) {
 		return models.WLEDController{Name: "Source"}, nil
 	}
+	var offsets [2]int
+	ms.MigrateBinsFunc = func(oldID, newID, segmentOffset, ledOffset int) error {
+		offsets = [2]int{segmentOffset, ledOffset}
+		if ledOffset < 0 {
+			return store.ErrInvalidOffset
+		}
+		if ledOffset == 3 {
+			return store.ErrLEDConflict
+		}
+		return nil
+	}
 
-	// Happy
-	form := url.Values{"new_controller_id": {"2"}}
+	// Offsets
+	form := url.Values{"new_controller_id": {"2"}, "segment_offset": {"1"}, "led_offset": {"8"}}
 	req := httptest.NewRequest("POST", "/settings/controllers/1/migrate", strings.NewReader(form.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	rr := httptest.NewRecorder()
 	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || offsets != [2]int{1, 8} {
+		t.Errorf("Offsets: got %d, offsets %v", rr.Code, offsets)
+	}
+
+	// Invalid Offset
+	form = url.Values{"new_controller_id": {"2"}, "led_offset": {"-5"}}
+	req = httptest.NewRequest("POST", "/settings/controllers/1/migrate", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Invalid Offset: got %d", rr.Code)
+	}
+
+	// LEDs In Use
+	form = url.Values{"new_controller_id": {"2"}, "led_offset": {"3"}}
+	req = httptest.NewRequest("POST", "/settings/controllers/1/migrate", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), "already used") {
+		t.Errorf("LED Conflict: got %d", rr.Code)
+	}
+	ms.MigrateBinsFunc = nil
+
+	// Happy
+	form = url.Values{"new_controller_id": {"2"}}
+	req = httptest.NewRequest("POST", "/settings/controllers/1/migrate", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
 	if rr.Code != http.StatusOK {
 		t.Errorf("Happy: got %d", rr.Code)
 	}
@@ -337,3 +532,98 @@ func TestHandleGetControllerRows(t *testing.T) {
 		t.Errorf("Migrate: got %d", rr.Code)
 	}
 }
+
+func TestHandleGetControllerDeleteRow(t *testing.T) {
+	h, ms, _ := setupTest(t)
+	r := chi.NewRouter()
+	r.Get("/settings/controllers/{id}/delete", h.handleGetControllerDeleteRow)
+
+	ms.GetControllerByIDFunc = func(id int) (models.WLEDController, error) {
+		return models.WLEDController{ID: 1, Name: "Old", BinCount: 3}, nil
+	}
+	ms.GetControllersFunc = func() ([]models.WLEDController, error) {
+		return []models.WLEDController{{ID: 1, Name: "Old"}, {ID: 2, Name: "New"}}, nil
+	}
+
+	req := httptest.NewRequest("GET", "/settings/controllers/1/delete", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %d", rr.Code)
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, "3 bins and 42 stocked items") {
+		t.Error("Expected the bin and stock count in the confirmation")
+	}
+	if !strings.Contains(body, `<option value="2">New`) || strings.Contains(body, `<option value="1">`) {
+		t.Error("Expected only the other controllers as move targets")
+	}
+}
+
+func TestHandleDeleteControllerWithBins(t *testing.T) {
+	h, ms, _ := setupTest(t)
+	r := chi.NewRouter()
+	r.Post("/settings/controllers/{id}/delete", h.handleDeleteControllerWithBins)
+
+	var got models.BinDisposal
+	ms.DeleteControllerWithBinsFunc = func(id int, d models.BinDisposal) error {
+		got = d
+		return nil
+	}
+
+	tests := []struct {
+		name     string
+		form     url.Values
+		wantCode int
+		want     models.BinDisposal
+	}{
+		{"Move", url.Values{"bins": {"move"}, "new_controller_id": {"2"}, "segment_offset": {"1"}, "led_offset": {"-2"}},
+			http.StatusOK, models.BinDisposal{Action: models.BinsMove, TargetControllerID: 2, SegmentOffset: 1, LEDOffset: -2}},
+		{"Move Without Target", url.Values{"bins": {"move"}}, http.StatusBadRequest, models.BinDisposal{}},
+		{"Detach", url.Values{"bins": {"detach"}}, http.StatusOK, models.BinDisposal{Action: models.BinsDetach}},
+		{"Delete Unconfirmed", url.Values{"bins": {"delete"}}, http.StatusBadRequest, models.BinDisposal{}},
+		{"Delete", url.Values{"bins": {"delete"}, "confirm": {"on"}}, http.StatusOK, models.BinDisposal{Action: models.BinsDelete}},
+		{"No Choice", url.Values{}, http.StatusBadRequest, models.BinDisposal{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = models.BinDisposal{}
+			req := httptest.NewRequest("POST", "/settings/controllers/1/delete", strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+			if rr.Code != tt.wantCode {
+				t.Fatalf("got %d, want %d", rr.Code, tt.wantCode)
+			}
+			if got != tt.want {
+				t.Errorf("Expected disposal %+v, got %+v", tt.want, got)
+			}
+			if tt.wantCode == http.StatusOK && rr.Header().Get("HX-Refresh") != "true" {
+				t.Error("Expected the page to refresh")
+			}
+		})
+	}
+
+	// Invalid Offset
+	ms.DeleteControllerWithBinsFunc = func(id int, d models.BinDisposal) error { return store.ErrInvalidOffset }
+	form := url.Values{"bins": {"move"}, "new_controller_id": {"2"}, "led_offset": {"-9"}}
+	req := httptest.NewRequest("POST", "/settings/controllers/1/delete", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Invalid Offset: got %d", rr.Code)
+	}
+
+	// DB Error
+	ms.DeleteControllerWithBinsFunc = nil
+	ms.FailOps = true
+	form = url.Values{"bins": {"detach"}}
+	req = httptest.NewRequest("POST", "/settings/controllers/1/delete", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("DB Error: got %d", rr.Code)
+	}
+}
@@ -1,6 +1,7 @@
 package inspiration
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
@@ -14,7 +15,7 @@ import (
 // Store defines what this module needs from the database
 // Currently, it just needs to read the parts catalog
 type Store interface {
-	GetParts() ([]models.Part, error)
+	GetParts(ctx context.Context) ([]models.Part, error)
 }
 
 type Handler struct {
@@ -27,14 +28,14 @@ func New(s Store, t core.TemplateExecutor) *Handler {
 }
 
 func (h *Handler) RegisterRoutes(r chi.Router) {
-	r.Get("/inspiration", h.handleShowInspiration)
+	r.With(core.Require(models.RoleViewer)).Get("/inspiration", h.handleShowInspiration)
 }
 
 // Handlers
 
 func (h *Handler) handleShowInspiration(w http.ResponseWriter, r *http.Request) {
 	// Get all parts
-	parts, err := h.store.GetParts()
+	parts, err := h.store.GetParts(r.Context())
 	if err != nil {
 		core.ServerError(w, r, err)
 		return
@@ -90,8 +91,9 @@ Do not suggest projects that require highly specialized or expensive components
 	finalPrompt := basePrompt + inventoryList.String()
 
 	data := map[string]interface{}{
-		"Title":  "Project Inspiration",
-		"Prompt": finalPrompt,
+		"Title":     "Project Inspiration",
+		"CSRFToken": core.CSRFToken(r),
+		"Prompt":    finalPrompt,
 	}
 
 	err = h.templates.ExecuteTemplate(w, "inspiration.html", data)
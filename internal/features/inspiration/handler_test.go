@@ -1,6 +1,7 @@
 package inspiration
 
 import (
+	"context"
 	"database/sql"
 	"html/template"
 	"net/http"
@@ -16,7 +17,7 @@ type mockStore struct {
 	GetPartsFunc func() ([]models.Part, error)
 }
 
-func (m *mockStore) GetParts() ([]models.Part, error) {
+func (m *mockStore) GetParts(ctx context.Context) ([]models.Part, error) {
 	if m.GetPartsFunc != nil {
 		return m.GetPartsFunc()
 	}
@@ -1,9 +1,13 @@
 package inventory
 
 import (
+	"context"
+	"database/sql"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 
@@ -16,18 +20,31 @@ import (
 // It combines Bin and Location methods.
 type Store interface {
 	// Bin methods
-	GetBinByID(id int) (models.Bin, error)
-	GetControllers() ([]models.WLEDController, error) // Needed for the dropdown
-	CreateBin(name string, controllerID, segmentID, ledIndex int) error
-	CreateBinsBulk(controllerID, segmentID, ledCount int, namePrefix string) error
-	UpdateBin(b *models.Bin) error
-	DeleteBin(id int) error
+	GetBins(ctx context.Context) ([]models.Bin, error)
+	GetBinByID(ctx context.Context, id int) (models.Bin, error)
+	GetControllers(ctx context.Context) ([]models.WLEDController, error) // Needed for the dropdown
+	CreateBinsBulk(ctx context.Context, controllerID, segmentID, ledCount int, namePrefix string) error
+	DeleteBin(ctx context.Context, id int) error
+	GetLEDConflict(ctx context.Context, b models.Bin) (models.LEDConflict, error)
 
 	// Location methods
-	CreatePartLocation(partID, binID, quantity int) error
-	GetPartLocationByID(locationID int) (models.PartLocation, error)
-	UpdatePartLocation(locationID, quantity int) error
-	DeletePartLocation(locationID int) error
+	CreatePartLocation(ctx context.Context, partID, binID, quantity int) (int, error)
+	GetPartLocationByID(ctx context.Context, locationID int) (models.PartLocation, error)
+	DeletePartLocation(ctx context.Context, locationID int) error
+
+	// WithTx runs fn in one transaction, see store.Store.WithTx
+	WithTx(ctx context.Context, fn func(tx TxStore) error) error
+	TxStore
+}
+
+// TxStore holds the Store methods the handlers call inside WithTx
+type TxStore interface {
+	CreateBin(ctx context.Context, name string, controllerID, segmentID, ledIndex int, sharedLED bool) (int, error)
+	UpdateBin(ctx context.Context, b *models.Bin) error
+	ShareLED(ctx context.Context, controllerID, segmentID, ledIndex int) error
+	UpdatePartLocation(ctx context.Context, locationID, quantity int) error
+	UpdatePartLocationThresholds(ctx context.Context, locationID int, reorderPoint, minStock sql.NullInt64) error
+	UpdatePartLocationCapacity(ctx context.Context, locationID int, capacity sql.NullInt64) error
 }
 
 type Handler struct {
@@ -41,19 +58,29 @@ func New(s Store, t core.TemplateExecutor) *Handler {
 
 func (h *Handler) RegisterRoutes(r chi.Router) {
 	// Bin management
-	r.Post("/settings/bins", h.handleCreateBin)
-	r.Post("/settings/bins/bulk", h.handleCreateBinsBulk)
-	r.Delete("/settings/bins/{id}", h.handleDeleteBin)
-	r.Get("/settings/bins/{id}", h.handleGetBinRow)
-	r.Get("/settings/bins/{id}/edit", h.handleGetBinEditRow)
-	r.Put("/settings/bins/{id}", h.handleUpdateBin)
+	r.Group(func(r chi.Router) {
+		r.Use(core.Require(models.RoleAdmin))
+		r.Post("/settings/bins", h.handleCreateBin)
+		r.Post("/settings/bins/bulk", h.handleCreateBinsBulk)
+		r.Delete("/settings/bins/{id}", h.handleDeleteBin)
+		r.Get("/settings/bins/{id}", h.handleGetBinRow)
+		r.Get("/settings/bins/{id}/edit", h.handleGetBinEditRow)
+		r.Put("/settings/bins/{id}", h.handleUpdateBin)
+
+		// Orphaned, detached and overlapping bins
+		r.Get("/settings/bins/repair", h.handleShowBinRepair)
+		r.Put("/settings/bins/{id}/repair", h.handleRepairBin)
+	})
 
 	// Part management
-	r.Post("/part/locations", h.handleCreatePartLocation)
-	r.Get("/part/location/{loc_id}", h.handleGetPartLocationRow)
-	r.Get("/part/location/{loc_id}/edit", h.handleGetPartLocationEditRow)
-	r.Put("/part/location/{loc_id}", h.handleUpdatePartLocation)
-	r.Delete("/part/location/{loc_id}", h.handleDeletePartLocation)
+	r.With(core.Require(models.RoleViewer)).Get("/part/location/{loc_id}", h.handleGetPartLocationRow)
+	r.Group(func(r chi.Router) {
+		r.Use(core.Require(models.RoleEditor))
+		r.Post("/part/locations", h.handleCreatePartLocation)
+		r.Get("/part/location/{loc_id}/edit", h.handleGetPartLocationEditRow)
+		r.Put("/part/location/{loc_id}", h.handleUpdatePartLocation)
+		r.Delete("/part/location/{loc_id}", h.handleDeletePartLocation)
+	})
 }
 
 // Bin Handlers
@@ -67,15 +94,25 @@ func (h *Handler) handleCreateBin(w http.ResponseWriter, r *http.Request) {
 	controllerID, _ := strconv.Atoi(r.FormValue("controller_id"))
 	segmentID, _ := strconv.Atoi(r.FormValue("segment_id"))
 	ledIndex, _ := strconv.Atoi(r.FormValue("led_index"))
+	sharedLED := r.FormValue("shared_led") == "on"
 
 	if name == "" || controllerID == 0 {
 		core.ClientError(w, r, http.StatusBadRequest, "Name and Controller are required", nil)
 		return
 	}
-	err := h.store.CreateBin(name, controllerID, segmentID, ledIndex)
+	err := h.store.WithTx(r.Context(), func(tx TxStore) error {
+		if err := shareLEDIfConfirmed(tx, r, controllerID, segmentID, ledIndex); err != nil {
+			return err
+		}
+		_, err := tx.CreateBin(r.Context(), name, controllerID, segmentID, ledIndex, sharedLED)
+		return err
+	})
 	if err != nil {
 		if errors.Is(err, store.ErrUniqueConstraint) {
 			core.ClientError(w, r, http.StatusConflict, "A bin with this name already exists.", err)
+		} else if errors.Is(err, store.ErrLEDConflict) {
+			bin := models.Bin{Name: name, WLEDControllerID: controllerID, WLEDSegmentID: segmentID, LEDIndex: ledIndex, SharedLED: sharedLED}
+			h.renderBinConflict(w, r, bin)
 		} else if errors.Is(err, store.ErrForeignKeyConstraint) {
 			core.ClientError(w, r, http.StatusBadRequest, "Invalid controller selected.", err)
 		} else {
@@ -100,10 +137,12 @@ func (h *Handler) handleCreateBinsBulk(w http.ResponseWriter, r *http.Request) {
 		core.ClientError(w, r, http.StatusBadRequest, "Controller, positive LED count, and Name Prefix are required", nil)
 		return
 	}
-	err := h.store.CreateBinsBulk(controllerID, segmentID, ledCount, namePrefix)
+	err := h.store.CreateBinsBulk(r.Context(), controllerID, segmentID, ledCount, namePrefix)
 	if err != nil {
 		if errors.Is(err, store.ErrUniqueConstraint) {
 			core.ClientError(w, r, http.StatusConflict, "One or more bin names already exist (e.g., "+namePrefix+"0).", err)
+		} else if errors.Is(err, store.ErrLEDConflict) {
+			core.ClientError(w, r, http.StatusConflict, "Some of these LEDs already have bins. Pick another segment, or add the remaining bins one by one.", err)
 		} else {
 			core.ServerError(w, r, err)
 		}
@@ -118,7 +157,7 @@ func (h *Handler) handleDeleteBin(w http.ResponseWriter, r *http.Request) {
 		core.ClientError(w, r, http.StatusBadRequest, "Invalid ID", nil)
 		return
 	}
-	if err := h.store.DeleteBin(id); err != nil {
+	if err := h.store.DeleteBin(r.Context(), id); err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
@@ -127,7 +166,7 @@ func (h *Handler) handleDeleteBin(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) handleGetBinRow(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
-	bin, err := h.store.GetBinByID(id)
+	bin, err := h.store.GetBinByID(r.Context(), id)
 	if err != nil {
 		core.ClientError(w, r, http.StatusNotFound, "Bin not found", err)
 		return
@@ -137,21 +176,65 @@ func (h *Handler) handleGetBinRow(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) handleGetBinEditRow(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
-	bin, err := h.store.GetBinByID(id)
+	bin, err := h.store.GetBinByID(r.Context(), id)
 	if err != nil {
 		core.ClientError(w, r, http.StatusNotFound, "Bin not found", err)
 		return
 	}
 
-	controllers, _ := h.store.GetControllers()
+	h.renderBinEditRow(w, r, bin)
+}
+
+// shareLEDIfConfirmed marks the bins already on an LED shared, if the user
+// confirmed sharing it with them on the conflict screen
+func shareLEDIfConfirmed(tx TxStore, r *http.Request, controllerID, segmentID, ledIndex int) error {
+	if r.FormValue("share_existing") != "on" {
+		return nil
+	}
+	return tx.ShareLED(r.Context(), controllerID, segmentID, ledIndex)
+}
+
+// renderBinEditRow renders a bin's edit row, noting any bin that keeps it
+// off its LED
+func (h *Handler) renderBinEditRow(w http.ResponseWriter, r *http.Request, bin models.Bin) {
+	controllers, _ := h.store.GetControllers(r.Context())
 
 	data := map[string]interface{}{
 		"Bin":         bin,
 		"Controllers": controllers,
 	}
+	if bin.WLEDControllerID != 0 {
+		conflict, err := h.store.GetLEDConflict(r.Context(), bin)
+		if err != nil {
+			core.ServerError(w, r, err)
+			return
+		}
+		if len(conflict.Bins) > 0 && !(bin.SharedLED && conflict.Shared) {
+			data["Conflict"] = conflict
+		}
+	}
 	h.templates.ExecuteTemplate(w, "_bin-edit-row.html", data)
 }
 
+// renderBinConflict shows the LED conflict of a new bin, with the next free
+// LED and the option to share the LED instead
+func (h *Handler) renderBinConflict(w http.ResponseWriter, r *http.Request, bin models.Bin) {
+	conflict, err := h.store.GetLEDConflict(r.Context(), bin)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Title":     "LED Already In Use",
+		"CSRFToken": core.CSRFToken(r),
+		"Bin":       bin,
+		"Conflict":  conflict,
+	}
+	w.WriteHeader(http.StatusConflict)
+	h.templates.ExecuteTemplate(w, "bin-conflict.html", data)
+}
+
 func (h *Handler) handleUpdateBin(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
 	if err := r.ParseForm(); err != nil {
@@ -170,27 +253,181 @@ func (h *Handler) handleUpdateBin(w http.ResponseWriter, r *http.Request) {
 	bin.WLEDControllerID, _ = strconv.Atoi(r.FormValue("controller_id"))
 	bin.WLEDSegmentID, _ = strconv.Atoi(r.FormValue("segment_id"))
 	bin.LEDIndex, _ = strconv.Atoi(r.FormValue("led_index"))
+	bin.Capacity, _ = strconv.Atoi(r.FormValue("capacity"))
+	bin.ZoneID, _ = strconv.Atoi(r.FormValue("zone_id"))
+	bin.SharedLED = r.FormValue("shared_led") == "on"
 
 	if bin.Name == "" || bin.WLEDControllerID == 0 {
 		core.ClientError(w, r, http.StatusBadRequest, "Name and Controller are required", nil)
 		return
 	}
 
-	if err := h.store.UpdateBin(bin); err != nil {
+	err := h.store.WithTx(r.Context(), func(tx TxStore) error {
+		if err := shareLEDIfConfirmed(tx, r, bin.WLEDControllerID, bin.WLEDSegmentID, bin.LEDIndex); err != nil {
+			return err
+		}
+		return tx.UpdateBin(r.Context(), bin)
+	})
+	if err != nil {
 		if errors.Is(err, store.ErrUniqueConstraint) {
 			core.ClientError(w, r, http.StatusConflict, "Bin name already exists", err)
+		} else if errors.Is(err, store.ErrLEDConflict) {
+			// Keep editing, with the conflict and a free LED to pick
+			h.renderBinEditRow(w, r, *bin)
 		} else {
 			core.ServerError(w, r, err)
 		}
 		return
 	}
 
-	updated, _ := h.store.GetBinByID(id)
+	updated, _ := h.store.GetBinByID(r.Context(), id)
 	h.templates.ExecuteTemplate(w, "_bin-row.html", updated)
 }
 
 // Part (stock) & Location Handlers
 
+// binProblem is a bin listed on the repair screen
+type binProblem struct {
+	models.Bin
+	OverlapsWith []string // The other bins on the same LED
+	NextFree     int      // For overlapping bins, the next free LED on the segment
+}
+
+// binProblems returns the orphaned, detached and overlapping bins
+func binProblems(bins []models.Bin) []binProblem {
+	key := func(b models.Bin) [3]int { return [3]int{b.WLEDControllerID, b.WLEDSegmentID, b.LEDIndex} }
+	byLED := make(map[[3]int][]string)
+	for _, b := range bins {
+		if b.HasOverlap {
+			byLED[key(b)] = append(byLED[key(b)], b.Name)
+		}
+	}
+
+	problems := []binProblem{}
+	for _, b := range bins {
+		if !b.IsOrphaned && !b.IsDetached && !b.HasOverlap {
+			continue
+		}
+		p := binProblem{Bin: b}
+		for _, name := range byLED[key(b)] {
+			if name != b.Name {
+				p.OverlapsWith = append(p.OverlapsWith, name)
+			}
+		}
+		problems = append(problems, p)
+	}
+	return problems
+}
+
+// binRepairData loads what the repair list shows
+func (h *Handler) binRepairData(ctx context.Context) (map[string]interface{}, error) {
+	bins, err := h.store.GetBins(ctx)
+	if err != nil {
+		return nil, err
+	}
+	controllers, err := h.store.GetControllers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	problems := binProblems(bins)
+	for i := range problems {
+		if problems[i].HasOverlap {
+			conflict, err := h.store.GetLEDConflict(ctx, problems[i].Bin)
+			if err != nil {
+				return nil, err
+			}
+			problems[i].NextFree = conflict.NextFree
+		}
+	}
+
+	return map[string]interface{}{
+		"Title":       "Repair Bins",
+		"Problems":    problems,
+		"Controllers": controllers,
+	}, nil
+}
+
+func (h *Handler) handleShowBinRepair(w http.ResponseWriter, r *http.Request) {
+	data, err := h.binRepairData(r.Context())
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	data["CSRFToken"] = core.CSRFToken(r)
+	if err := h.templates.ExecuteTemplate(w, "bin-repair.html", data); err != nil {
+		core.ServerError(w, r, err)
+	}
+}
+
+// handleRepairBin assigns a bin to a controller and LED, shares its LED or
+// detaches it, then re-renders the repair list, since fixing one bin can fix
+// its overlaps too
+func (h *Handler) handleRepairBin(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	if err := r.ParseForm(); err != nil {
+		core.ClientError(w, r, http.StatusBadRequest, "Bad Request", err)
+		return
+	}
+
+	bin, err := h.store.GetBinByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			core.ClientError(w, r, http.StatusNotFound, "Bin not found", err)
+		} else {
+			core.ServerError(w, r, err)
+		}
+		return
+	}
+
+	switch r.FormValue("action") {
+	case "assign":
+		bin.WLEDControllerID, _ = strconv.Atoi(r.FormValue("controller_id"))
+		bin.WLEDSegmentID, _ = strconv.Atoi(r.FormValue("segment_id"))
+		bin.LEDIndex, _ = strconv.Atoi(r.FormValue("led_index"))
+		if bin.WLEDControllerID == 0 || bin.WLEDSegmentID < 0 || bin.LEDIndex < 0 {
+			core.ClientError(w, r, http.StatusBadRequest, "Controller, segment and LED are required", nil)
+			return
+		}
+	case "share":
+		bin.SharedLED = true
+	case "detach":
+		bin.WLEDControllerID = 0
+	default:
+		core.ClientError(w, r, http.StatusBadRequest, "Unknown repair", nil)
+		return
+	}
+
+	var notice string
+	if r.FormValue("action") == "share" {
+		// The button on the overlap is the user confirming the bins share it
+		err = h.store.ShareLED(r.Context(), bin.WLEDControllerID, bin.WLEDSegmentID, bin.LEDIndex)
+	} else {
+		err = h.store.UpdateBin(r.Context(), &bin)
+	}
+	if err != nil {
+		if !errors.Is(err, store.ErrLEDConflict) {
+			core.ServerError(w, r, err)
+			return
+		}
+		conflict, err := h.store.GetLEDConflict(r.Context(), bin)
+		if err != nil {
+			core.ServerError(w, r, err)
+			return
+		}
+		notice = fmt.Sprintf("%s was not moved: LED %d:%d is used by %s. LED %d is free.",
+			bin.Name, bin.WLEDSegmentID, bin.LEDIndex, strings.Join(conflict.Bins, ", "), conflict.NextFree)
+	}
+
+	data, err := h.binRepairData(r.Context())
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	data["Notice"] = notice
+	h.templates.ExecuteTemplate(w, "_bin-repair-list.html", data)
+}
+
 func (h *Handler) handleCreatePartLocation(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		core.ClientError(w, r, http.StatusBadRequest, "Bad Request", err)
@@ -204,7 +441,7 @@ func (h *Handler) handleCreatePartLocation(w http.ResponseWriter, r *http.Reques
 		core.ClientError(w, r, http.StatusBadRequest, "Invalid part or bin ID", nil)
 		return
 	}
-	if err := h.store.CreatePartLocation(partID, binID, quantity); err != nil {
+	if _, err := h.store.CreatePartLocation(r.Context(), partID, binID, quantity); err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
@@ -213,7 +450,7 @@ func (h *Handler) handleCreatePartLocation(w http.ResponseWriter, r *http.Reques
 
 func (h *Handler) handleGetPartLocationRow(w http.ResponseWriter, r *http.Request) {
 	locID, _ := strconv.Atoi(chi.URLParam(r, "loc_id"))
-	loc, err := h.store.GetPartLocationByID(locID)
+	loc, err := h.store.GetPartLocationByID(r.Context(), locID)
 	if err != nil {
 		core.ClientError(w, r, http.StatusNotFound, "Location not found", err)
 		return
@@ -223,7 +460,7 @@ func (h *Handler) handleGetPartLocationRow(w http.ResponseWriter, r *http.Reques
 
 func (h *Handler) handleGetPartLocationEditRow(w http.ResponseWriter, r *http.Request) {
 	locID, _ := strconv.Atoi(chi.URLParam(r, "loc_id"))
-	loc, err := h.store.GetPartLocationByID(locID)
+	loc, err := h.store.GetPartLocationByID(r.Context(), locID)
 	if err != nil {
 		core.ClientError(w, r, http.StatusNotFound, "Location not found", err)
 		return
@@ -239,11 +476,35 @@ func (h *Handler) handleUpdatePartLocation(w http.ResponseWriter, r *http.Reques
 	}
 	quantity, _ := strconv.Atoi(r.FormValue("quantity"))
 
-	if err := h.store.UpdatePartLocation(locID, quantity); err != nil {
+	// Per-location thresholds are optional, blank means "use the part's value"
+	reorderPoint, ok1 := parseOptionalInt(r.FormValue("reorder_point"))
+	minStock, ok2 := parseOptionalInt(r.FormValue("min_stock"))
+	capacity, ok3 := parseOptionalInt(r.FormValue("capacity"))
+	if !ok1 || !ok2 || !ok3 {
+		core.ClientError(w, r, http.StatusBadRequest, "Reorder point, min stock and capacity must be blank or a whole number of zero or more", nil)
+		return
+	}
+
+	// The quantity, thresholds and capacity are saved together or not at all
+	err := h.store.WithTx(r.Context(), func(tx TxStore) error {
+		if err := tx.UpdatePartLocation(r.Context(), locID, quantity); err != nil {
+			return err
+		}
+		if r.Form.Has("reorder_point") || r.Form.Has("min_stock") {
+			if err := tx.UpdatePartLocationThresholds(r.Context(), locID, reorderPoint, minStock); err != nil {
+				return err
+			}
+		}
+		if r.Form.Has("capacity") {
+			return tx.UpdatePartLocationCapacity(r.Context(), locID, capacity)
+		}
+		return nil
+	})
+	if err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
-	loc, err := h.store.GetPartLocationByID(locID)
+	loc, err := h.store.GetPartLocationByID(r.Context(), locID)
 	if err != nil {
 		core.ServerError(w, r, err)
 		return
@@ -253,9 +514,23 @@ func (h *Handler) handleUpdatePartLocation(w http.ResponseWriter, r *http.Reques
 
 func (h *Handler) handleDeletePartLocation(w http.ResponseWriter, r *http.Request) {
 	locID, _ := strconv.Atoi(chi.URLParam(r, "loc_id"))
-	if err := h.store.DeletePartLocation(locID); err != nil {
+	if err := h.store.DeletePartLocation(r.Context(), locID); err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
+
+// parseOptionalInt converts a form value to a nullable int.
+// Blank input is NULL; ok is false for anything but a non-negative integer.
+func parseOptionalInt(value string) (n sql.NullInt64, ok bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return sql.NullInt64{}, true
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 0 {
+		return sql.NullInt64{}, false
+	}
+	return sql.NullInt64{Int64: int64(i), Valid: true}, true
+}
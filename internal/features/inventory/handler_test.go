@@ -1,6 +1,8 @@
 package inventory
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"html/template"
 	"net/http"
@@ -17,18 +19,25 @@ import (
 
 // Local mock
 type mockStore struct {
-	FailOps bool // Flag to trigger DB errors
+	FailOps    bool // Flag to trigger DB errors
+	RolledBack bool // Set when a WithTx function fails
 
+	GetBinsFunc             func() ([]models.Bin, error)
 	GetBinByIDFunc          func(id int) (models.Bin, error)
 	GetControllersFunc      func() ([]models.WLEDController, error)
-	CreateBinFunc           func(name string, controllerID, segmentID, ledIndex int) error
+	CreateBinFunc           func(name string, controllerID, segmentID, ledIndex int, sharedLED bool) error
 	CreateBinsBulkFunc      func(controllerID, segmentID, ledCount int, namePrefix string) error
 	UpdateBinFunc           func(b *models.Bin) error
 	DeleteBinFunc           func(id int) error
+	GetLEDConflictFunc      func(b models.Bin) (models.LEDConflict, error)
+	ShareLEDFunc            func(controllerID, segmentID, ledIndex int) error
 	CreatePartLocationFunc  func(partID, binID, quantity int) error
 	GetPartLocationByIDFunc func(locationID int) (models.PartLocation, error)
 	UpdatePartLocationFunc  func(locationID, quantity int) error
 	DeletePartLocationFunc  func(locationID int) error
+
+	UpdatePartLocationThresholdsFunc func(locationID int, reorderPoint, minStock sql.NullInt64) error
+	UpdatePartLocationCapacityFunc   func(locationID int, capacity sql.NullInt64) error
 }
 
 // Helper to return error if FailOps is true
@@ -39,7 +48,16 @@ func (m *mockStore) retErr() error {
 	return nil
 }
 
-func (m *mockStore) GetBinByID(id int) (models.Bin, error) {
+func (m *mockStore) GetBins(ctx context.Context) ([]models.Bin, error) {
+	if m.FailOps {
+		return nil, errors.New("db error")
+	}
+	if m.GetBinsFunc != nil {
+		return m.GetBinsFunc()
+	}
+	return nil, nil
+}
+func (m *mockStore) GetBinByID(ctx context.Context, id int) (models.Bin, error) {
 	if m.FailOps {
 		return models.Bin{}, errors.New("db error")
 	}
@@ -48,7 +66,7 @@ func (m *mockStore) GetBinByID(id int) (models.Bin, error) {
 	}
 	return models.Bin{}, nil
 }
-func (m *mockStore) GetControllers() ([]models.WLEDController, error) {
+func (m *mockStore) GetControllers(ctx context.Context) ([]models.WLEDController, error) {
 	if m.FailOps {
 		return nil, errors.New("db error")
 	}
@@ -57,38 +75,53 @@ func (m *mockStore) GetControllers() ([]models.WLEDController, error) {
 	}
 	return nil, nil
 }
-func (m *mockStore) CreateBin(name string, cid, sid, led int) error {
+func (m *mockStore) CreateBin(ctx context.Context, name string, cid, sid, led int, shared bool) (int, error) {
 	// Allow specific override to take precedence for unique constraint tests
 	if m.CreateBinFunc != nil {
-		return m.CreateBinFunc(name, cid, sid, led)
+		return 1, m.CreateBinFunc(name, cid, sid, led, shared)
 	}
-	return m.retErr()
+	return 1, m.retErr()
 }
-func (m *mockStore) CreateBinsBulk(cid, sid, count int, prefix string) error {
+func (m *mockStore) CreateBinsBulk(ctx context.Context, cid, sid, count int, prefix string) error {
 	if m.CreateBinsBulkFunc != nil {
 		return m.CreateBinsBulkFunc(cid, sid, count, prefix)
 	}
 	return m.retErr()
 }
-func (m *mockStore) UpdateBin(b *models.Bin) error {
+func (m *mockStore) UpdateBin(ctx context.Context, b *models.Bin) error {
 	if m.UpdateBinFunc != nil {
 		return m.UpdateBinFunc(b)
 	}
 	return m.retErr()
 }
-func (m *mockStore) DeleteBin(id int) error {
+func (m *mockStore) DeleteBin(ctx context.Context, id int) error {
 	if m.DeleteBinFunc != nil {
 		return m.DeleteBinFunc(id)
 	}
 	return m.retErr()
 }
-func (m *mockStore) CreatePartLocation(pid, bid, qty int) error {
-	if m.CreatePartLocationFunc != nil {
-		return m.CreatePartLocationFunc(pid, bid, qty)
+func (m *mockStore) GetLEDConflict(ctx context.Context, b models.Bin) (models.LEDConflict, error) {
+	if m.FailOps {
+		return models.LEDConflict{}, errors.New("db error")
+	}
+	if m.GetLEDConflictFunc != nil {
+		return m.GetLEDConflictFunc(b)
+	}
+	return models.LEDConflict{ControllerID: b.WLEDControllerID, SegmentID: b.WLEDSegmentID, LEDIndex: b.LEDIndex, NextFree: b.LEDIndex + 1}, nil
+}
+func (m *mockStore) ShareLED(ctx context.Context, cid, sid, led int) error {
+	if m.ShareLEDFunc != nil {
+		return m.ShareLEDFunc(cid, sid, led)
 	}
 	return m.retErr()
 }
-func (m *mockStore) GetPartLocationByID(id int) (models.PartLocation, error) {
+func (m *mockStore) CreatePartLocation(ctx context.Context, pid, bid, qty int) (int, error) {
+	if m.CreatePartLocationFunc != nil {
+		return 1, m.CreatePartLocationFunc(pid, bid, qty)
+	}
+	return 1, m.retErr()
+}
+func (m *mockStore) GetPartLocationByID(ctx context.Context, id int) (models.PartLocation, error) {
 	if m.FailOps {
 		return models.PartLocation{}, errors.New("db error")
 	}
@@ -97,18 +130,37 @@ func (m *mockStore) GetPartLocationByID(id int) (models.PartLocation, error) {
 	}
 	return models.PartLocation{}, nil
 }
-func (m *mockStore) UpdatePartLocation(id, qty int) error {
+func (m *mockStore) UpdatePartLocation(ctx context.Context, id, qty int) error {
 	if m.UpdatePartLocationFunc != nil {
 		return m.UpdatePartLocationFunc(id, qty)
 	}
 	return m.retErr()
 }
-func (m *mockStore) DeletePartLocation(id int) error {
+func (m *mockStore) UpdatePartLocationThresholds(ctx context.Context, id int, reorderPoint, minStock sql.NullInt64) error {
+	if m.UpdatePartLocationThresholdsFunc != nil {
+		return m.UpdatePartLocationThresholdsFunc(id, reorderPoint, minStock)
+	}
+	return m.retErr()
+}
+func (m *mockStore) UpdatePartLocationCapacity(ctx context.Context, id int, capacity sql.NullInt64) error {
+	if m.UpdatePartLocationCapacityFunc != nil {
+		return m.UpdatePartLocationCapacityFunc(id, capacity)
+	}
+	return m.retErr()
+}
+func (m *mockStore) DeletePartLocation(ctx context.Context, id int) error {
 	if m.DeletePartLocationFunc != nil {
 		return m.DeletePartLocationFunc(id)
 	}
 	return m.retErr()
 }
+func (m *mockStore) WithTx(ctx context.Context, fn func(tx TxStore) error) error {
+	err := fn(m)
+	if err != nil {
+		m.RolledBack = true
+	}
+	return err
+}
 
 // Test setup Helper
 func setupTest(t *testing.T) (*Handler, *mockStore) {
@@ -139,7 +191,7 @@ func TestHandleCreateBin(t *testing.T) {
 	}
 
 	// Duplicate Error (Specific)
-	ms.CreateBinFunc = func(n string, c, s, l int) error { return store.ErrUniqueConstraint }
+	ms.CreateBinFunc = func(n string, c, s, l int, shared bool) error { return store.ErrUniqueConstraint }
 	req = httptest.NewRequest("POST", "/settings/bins", strings.NewReader(form.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	rr = httptest.NewRecorder()
@@ -208,6 +260,121 @@ func TestHandleCreateBinsBulk(t *testing.T) {
 	}
 }
 
+func TestHandleCreateBin_LEDConflict(t *testing.T) {
+	h, ms := setupTest(t)
+
+	var shared, ledShared bool
+	ms.ShareLEDFunc = func(c, s, l int) error {
+		ledShared = true
+		return nil
+	}
+	ms.CreateBinFunc = func(n string, c, s, l int, sharedLED bool) error {
+		shared = sharedLED
+		if !sharedLED || !ledShared {
+			return store.ErrLEDConflict
+		}
+		return nil
+	}
+	ms.GetLEDConflictFunc = func(b models.Bin) (models.LEDConflict, error) {
+		return models.LEDConflict{SegmentID: 0, LEDIndex: 5, Bins: []string{"A5"}, NextFree: 8}, nil
+	}
+
+	// Conflict shows the resolution page
+	form := url.Values{"name": {"B1"}, "controller_id": {"1"}, "segment_id": {"0"}, "led_index": {"5"}}
+	req := httptest.NewRequest("POST", "/settings/bins", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	h.handleCreateBin(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("Conflict: got %d", rr.Code)
+	}
+	body := rr.Body.String()
+	for _, want := range []string{"<strong>A5</strong>", "Use LED 8 instead", `name="led_index" value="8"`, `name="shared_led" value="on"`, `name="share_existing" value="on"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in conflict page", want)
+		}
+	}
+
+	// Ticking shared alone doesn't mark A5 shared, that needs the confirmation
+	form.Set("shared_led", "on")
+	req = httptest.NewRequest("POST", "/settings/bins", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	h.handleCreateBin(rr, req)
+	if rr.Code != http.StatusConflict || ledShared {
+		t.Errorf("Shared without confirming: got %d, LED shared %v", rr.Code, ledShared)
+	}
+
+	// Confirmed on the conflict page, the LED is shared with A5
+	form.Set("share_existing", "on")
+	req = httptest.NewRequest("POST", "/settings/bins", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	h.handleCreateBin(rr, req)
+	if rr.Code != http.StatusSeeOther || !shared || !ledShared {
+		t.Errorf("Shared: got %d, shared %v, LED shared %v", rr.Code, shared, ledShared)
+	}
+}
+
+func TestHandleUpdateBin_LEDConflict(t *testing.T) {
+	h, ms := setupTest(t)
+	r := chi.NewRouter()
+	r.Put("/settings/bins/{id}", h.handleUpdateBin)
+
+	ms.UpdateBinFunc = func(b *models.Bin) error { return store.ErrLEDConflict }
+	ms.GetLEDConflictFunc = func(b models.Bin) (models.LEDConflict, error) {
+		return models.LEDConflict{LEDIndex: b.LEDIndex, Bins: []string{"A5"}, NextFree: 6}, nil
+	}
+
+	form := url.Values{"name": {"B1"}, "controller_id": {"1"}, "led_index": {"5"}}
+	req := httptest.NewRequest("PUT", "/settings/bins/1", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %d", rr.Code)
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, "Used by A5") || !strings.Contains(body, "Use free LED 6") {
+		t.Error("Expected the edit row again, with the conflict and the next free LED")
+	}
+	if !strings.Contains(body, `name="name" value="B1"`) {
+		t.Error("Expected the edit row to keep the entered values")
+	}
+
+	// A shared bin still conflicts with bins that aren't, and is offered
+	// to share the LED with them
+	form.Set("shared_led", "on")
+	req = httptest.NewRequest("PUT", "/settings/bins/1", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if body := rr.Body.String(); !strings.Contains(body, "Used by A5") || !strings.Contains(body, `"share_existing": "on"`) {
+		t.Error("Expected the conflict with the option to share the LED")
+	}
+
+	// Confirmed, the LED is shared before the bin is saved
+	var sharedLED [3]int
+	ms.ShareLEDFunc = func(c, s, l int) error {
+		sharedLED = [3]int{c, s, l}
+		return nil
+	}
+	ms.UpdateBinFunc = func(b *models.Bin) error {
+		if sharedLED != [3]int{1, 0, 5} {
+			return store.ErrLEDConflict
+		}
+		return nil
+	}
+	form.Set("share_existing", "on")
+	req = httptest.NewRequest("PUT", "/settings/bins/1", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), "Used by A5") {
+		t.Errorf("Confirmed share: got %d, %s", rr.Code, rr.Body.String())
+	}
+}
+
 func TestHandleUpdateBin(t *testing.T) {
 	h, ms := setupTest(t)
 
@@ -215,9 +382,14 @@ func TestHandleUpdateBin(t *testing.T) {
 	ms.GetBinByIDFunc = func(id int) (models.Bin, error) {
 		return models.Bin{ID: 1, Name: "Old"}, nil
 	}
+	var saved *models.Bin
+	ms.UpdateBinFunc = func(b *models.Bin) error {
+		saved = b
+		return nil
+	}
 
 	// Happy
-	form := url.Values{"name": {"New"}, "controller_id": {"1"}}
+	form := url.Values{"name": {"New"}, "controller_id": {"1"}, "zone_id": {"2"}}
 	req := httptest.NewRequest("PUT", "/settings/bins/1", strings.NewReader(form.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	rr := httptest.NewRecorder()
@@ -230,8 +402,12 @@ func TestHandleUpdateBin(t *testing.T) {
 	if rr.Code != http.StatusOK {
 		t.Errorf("Happy: got %d", rr.Code)
 	}
+	if saved == nil || saved.ZoneID != 2 {
+		t.Errorf("Expected the bin's zone to be saved, got %+v", saved)
+	}
 
 	// DB Error
+	ms.UpdateBinFunc = nil
 	ms.FailOps = true
 	// send valid data to pass validation check
 	form = url.Values{"name": {"New"}, "controller_id": {"1"}}
@@ -301,6 +477,129 @@ func TestHandleGetBinRows(t *testing.T) {
 	}
 }
 
+func TestHandleShowBinRepair(t *testing.T) {
+	h, ms := setupTest(t)
+	r := chi.NewRouter()
+	r.Get("/settings/bins/repair", h.handleShowBinRepair)
+
+	ms.GetBinsFunc = func() ([]models.Bin, error) {
+		return []models.Bin{
+			{ID: 1, Name: "Fine"},
+			{ID: 2, Name: "Lost", WLEDControllerID: 9, IsOrphaned: true},
+			{ID: 3, Name: "Spare", IsDetached: true},
+			{ID: 4, Name: "A1", WLEDControllerID: 1, LEDIndex: 5, HasOverlap: true},
+			{ID: 5, Name: "A2", WLEDControllerID: 1, LEDIndex: 5, HasOverlap: true},
+		}, nil
+	}
+
+	req := httptest.NewRequest("GET", "/settings/bins/repair", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %d", rr.Code)
+	}
+	body := rr.Body.String()
+	if strings.Contains(body, `id="bin-1"`) {
+		t.Error("Bins without problems should not be listed")
+	}
+	for _, want := range []string{"Controller #9 no longer exists", `id="bin-3"`, "with A2", "with A1", "Use LED 6", "Share LED"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in repair screen", want)
+		}
+	}
+
+	// DB Error
+	ms.FailOps = true
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("DB Error: got %d", rr.Code)
+	}
+}
+
+func TestHandleRepairBin(t *testing.T) {
+	h, ms := setupTest(t)
+	r := chi.NewRouter()
+	r.Put("/settings/bins/{id}/repair", h.handleRepairBin)
+
+	ms.GetBinByIDFunc = func(id int) (models.Bin, error) {
+		return models.Bin{ID: id, Name: "Lost", WLEDControllerID: 9, Capacity: 20, IsOrphaned: true}, nil
+	}
+	var saved *models.Bin
+	ms.UpdateBinFunc = func(b *models.Bin) error {
+		saved = b
+		return nil
+	}
+
+	send := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/settings/bins/2/repair", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Assign
+	rr := send(url.Values{"action": {"assign"}, "controller_id": {"1"}, "segment_id": {"0"}, "led_index": {"7"}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Assign: got %d", rr.Code)
+	}
+	if saved.WLEDControllerID != 1 || saved.LEDIndex != 7 || saved.Capacity != 20 || saved.Name != "Lost" {
+		t.Errorf("Assign should only change controller and LED, got %+v", saved)
+	}
+	if !strings.Contains(rr.Body.String(), "All bins are on a controller") {
+		t.Error("Expected the refreshed repair list")
+	}
+
+	// Detach
+	if rr := send(url.Values{"action": {"detach"}}); rr.Code != http.StatusOK || saved.WLEDControllerID != 0 {
+		t.Errorf("Detach: got %d, controller %d", rr.Code, saved.WLEDControllerID)
+	}
+
+	// Share, marking every bin on the LED shared
+	var sharedLED [3]int
+	ms.ShareLEDFunc = func(c, s, l int) error {
+		sharedLED = [3]int{c, s, l}
+		return nil
+	}
+	ms.GetBinByIDFunc = func(id int) (models.Bin, error) {
+		return models.Bin{ID: id, Name: "Overlap", WLEDControllerID: 1, LEDIndex: 4, HasOverlap: true}, nil
+	}
+	if rr := send(url.Values{"action": {"share"}}); rr.Code != http.StatusOK || sharedLED != [3]int{1, 0, 4} {
+		t.Errorf("Share: got %d, shared LED %v", rr.Code, sharedLED)
+	}
+
+	// Assign To A Used LED
+	ms.UpdateBinFunc = func(b *models.Bin) error { return store.ErrLEDConflict }
+	ms.GetLEDConflictFunc = func(b models.Bin) (models.LEDConflict, error) {
+		return models.LEDConflict{Bins: []string{"A7"}, NextFree: 8}, nil
+	}
+	rr = send(url.Values{"action": {"assign"}, "controller_id": {"1"}, "segment_id": {"0"}, "led_index": {"7"}})
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "LED 0:7 is used by A7. LED 8 is free.") {
+		t.Errorf("Conflict: got %d, %s", rr.Code, rr.Body.String())
+	}
+	ms.UpdateBinFunc = func(b *models.Bin) error {
+		saved = b
+		return nil
+	}
+
+	// Assign Without Controller
+	if rr := send(url.Values{"action": {"assign"}, "led_index": {"7"}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Missing Controller: got %d", rr.Code)
+	}
+
+	// Unknown Action
+	if rr := send(url.Values{"action": {"explode"}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Unknown Action: got %d", rr.Code)
+	}
+
+	// Not Found
+	ms.GetBinByIDFunc = func(id int) (models.Bin, error) { return models.Bin{}, sql.ErrNoRows }
+	if rr := send(url.Values{"action": {"detach"}}); rr.Code != http.StatusNotFound {
+		t.Errorf("Not Found: got %d", rr.Code)
+	}
+}
+
 func TestHandleCreatePartLocation(t *testing.T) {
 	h, ms := setupTest(t)
 
@@ -344,6 +643,70 @@ func TestHandleUpdatePartLocation(t *testing.T) {
 		t.Errorf("Happy: got %d", rr.Code)
 	}
 
+	// Thresholds (blank min stock falls back to the part's value)
+	var gotReorder, gotMin sql.NullInt64
+	ms.UpdatePartLocationThresholdsFunc = func(id int, reorderPoint, minStock sql.NullInt64) error {
+		gotReorder, gotMin = reorderPoint, minStock
+		return nil
+	}
+	thresholdForm := url.Values{"quantity": {"50"}, "reorder_point": {"20"}, "min_stock": {""}}
+	req = httptest.NewRequest("PUT", "/part/location/1", strings.NewReader(thresholdForm.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Thresholds: got %d", rr.Code)
+	}
+	if !gotReorder.Valid || gotReorder.Int64 != 20 {
+		t.Errorf("Expected reorder point 20, got %+v", gotReorder)
+	}
+	if gotMin.Valid {
+		t.Errorf("Expected NULL min stock, got %+v", gotMin)
+	}
+
+	// Capacity
+	var gotCapacity sql.NullInt64
+	ms.UpdatePartLocationCapacityFunc = func(id int, capacity sql.NullInt64) error {
+		gotCapacity = capacity
+		return nil
+	}
+	capacityForm := url.Values{"quantity": {"50"}, "capacity": {"200"}}
+	req = httptest.NewRequest("PUT", "/part/location/1", strings.NewReader(capacityForm.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || gotCapacity.Int64 != 200 {
+		t.Errorf("Capacity: got %d, capacity %+v", rr.Code, gotCapacity)
+	}
+
+	// Invalid thresholds are rejected before anything is saved
+	gotReorder, gotCapacity = sql.NullInt64{}, sql.NullInt64{}
+	for _, bad := range []url.Values{
+		{"quantity": {"50"}, "reorder_point": {"ten"}, "min_stock": {"5"}},
+		{"quantity": {"50"}, "reorder_point": {"10"}, "min_stock": {"-1"}},
+		{"quantity": {"50"}, "capacity": {"1.5"}},
+	} {
+		req = httptest.NewRequest("PUT", "/part/location/1", strings.NewReader(bad.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr = httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest || gotReorder.Valid || gotCapacity.Valid {
+			t.Errorf("Invalid %v: got %d, reorder %+v, capacity %+v", bad, rr.Code, gotReorder, gotCapacity)
+		}
+	}
+
+	// A failed capacity update rolls back the quantity
+	ms.UpdatePartLocationCapacityFunc = func(id int, capacity sql.NullInt64) error {
+		return errors.New("db error")
+	}
+	req = httptest.NewRequest("PUT", "/part/location/1", strings.NewReader(capacityForm.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError || !ms.RolledBack {
+		t.Errorf("Capacity Error: got %d, rolled back %v", rr.Code, ms.RolledBack)
+	}
+
 	// DB Error
 	ms.FailOps = true
 	req = httptest.NewRequest("PUT", "/part/location/1", strings.NewReader(form.Encode()))
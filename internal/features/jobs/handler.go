@@ -0,0 +1,135 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"wledger/internal/core"
+	"wledger/internal/models"
+	"wledger/internal/scheduler"
+)
+
+// Store defines the database methods this module needs
+type Store interface {
+	GetLatestJobRuns(ctx context.Context) ([]models.JobRun, error)
+	GetJobRuns(ctx context.Context, jobName string, limit int) ([]models.JobRun, error)
+}
+
+// Scheduler runs the background jobs, see the scheduler package
+type Scheduler interface {
+	Jobs() []models.JobStatus
+	RunNow(name string) error
+}
+
+// jobRunsShown is how many runs a job's history lists
+const jobRunsShown = 20
+
+type Handler struct {
+	store     Store
+	scheduler Scheduler
+	templates core.TemplateExecutor
+}
+
+func New(s Store, sch Scheduler, t core.TemplateExecutor) *Handler {
+	return &Handler{store: s, scheduler: sch, templates: t}
+}
+
+func (h *Handler) RegisterRoutes(r chi.Router) {
+	r.Group(func(r chi.Router) {
+		r.Use(core.Require(models.RoleAdmin))
+		r.Get("/settings/jobs", h.handleShowJobs)
+		r.Get("/settings/jobs/list", h.handleGetJobList)
+		r.Post("/settings/jobs/{name}/run", h.handleRunJob)
+		r.Get("/settings/jobs/{name}/runs", h.handleGetJobRuns)
+	})
+}
+
+// Handlers
+
+func (h *Handler) handleShowJobs(w http.ResponseWriter, r *http.Request) {
+	jobs, err := h.jobStatuses(r.Context())
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	data := map[string]any{
+		"Title":     "Background Jobs",
+		"CSRFToken": core.CSRFToken(r),
+		"Jobs":      jobs,
+	}
+	if err := h.templates.ExecuteTemplate(w, "jobs.html", data); err != nil {
+		core.ServerError(w, r, err)
+	}
+}
+
+// handleGetJobList re-renders the job list, which polls to show progress
+func (h *Handler) handleGetJobList(w http.ResponseWriter, r *http.Request) {
+	h.renderJobList(w, r)
+}
+
+func (h *Handler) handleRunJob(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if err := h.scheduler.RunNow(name); err != nil {
+		switch {
+		case errors.Is(err, scheduler.ErrUnknownJob):
+			core.ClientError(w, r, http.StatusNotFound, "Job not found", err)
+		case errors.Is(err, scheduler.ErrJobRunning):
+			core.ClientError(w, r, http.StatusConflict, name+" is already running", err)
+		default:
+			core.ServerError(w, r, err)
+		}
+		return
+	}
+	h.renderJobList(w, r)
+}
+
+func (h *Handler) handleGetJobRuns(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	runs, err := h.store.GetJobRuns(r.Context(), name, jobRunsShown)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	data := map[string]any{
+		"Name": name,
+		"Runs": runs,
+	}
+	if err := h.templates.ExecuteTemplate(w, "_job-runs.html", data); err != nil {
+		core.ServerError(w, r, err)
+	}
+}
+
+// Helpers
+
+func (h *Handler) renderJobList(w http.ResponseWriter, r *http.Request) {
+	jobs, err := h.jobStatuses(r.Context())
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	if err := h.templates.ExecuteTemplate(w, "_job-list.html", map[string]any{"Jobs": jobs}); err != nil {
+		core.ServerError(w, r, err)
+	}
+}
+
+// jobStatuses combines the registered jobs with their last recorded run,
+// which may be from before the last restart
+func (h *Handler) jobStatuses(ctx context.Context) ([]models.JobStatus, error) {
+	runs, err := h.store.GetLatestJobRuns(ctx)
+	if err != nil {
+		return nil, err
+	}
+	latest := make(map[string]models.JobRun, len(runs))
+	for _, run := range runs {
+		latest[run.JobName] = run
+	}
+
+	jobs := h.scheduler.Jobs()
+	for i := range jobs {
+		jobs[i].LastRun = latest[jobs[i].Name]
+	}
+	return jobs, nil
+}
@@ -0,0 +1,137 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"wledger/internal/core"
+	"wledger/internal/models"
+	"wledger/internal/scheduler"
+)
+
+// Local mocks
+type mockStore struct {
+	FailOps bool
+	Latest  []models.JobRun
+	Runs    []models.JobRun
+}
+
+func (m *mockStore) GetLatestJobRuns(ctx context.Context) ([]models.JobRun, error) {
+	if m.FailOps {
+		return nil, errors.New("db error")
+	}
+	return m.Latest, nil
+}
+func (m *mockStore) GetJobRuns(ctx context.Context, jobName string, limit int) ([]models.JobRun, error) {
+	if m.FailOps {
+		return nil, errors.New("db error")
+	}
+	return m.Runs, nil
+}
+
+type mockScheduler struct {
+	Statuses []models.JobStatus
+	Started  []string
+}
+
+func (m *mockScheduler) Jobs() []models.JobStatus {
+	return append([]models.JobStatus{}, m.Statuses...)
+}
+func (m *mockScheduler) RunNow(name string) error {
+	for i, j := range m.Statuses {
+		if j.Name != name {
+			continue
+		}
+		if j.Running {
+			return scheduler.ErrJobRunning
+		}
+		m.Statuses[i].Running = true
+		m.Started = append(m.Started, name)
+		return nil
+	}
+	return scheduler.ErrUnknownJob
+}
+
+// Test Setup Helper
+func setupTest(t *testing.T) (*chi.Mux, *mockStore, *mockScheduler) {
+	t.Helper()
+	ms := &mockStore{}
+	sch := &mockScheduler{Statuses: []models.JobStatus{
+		{Name: "health-checks", Description: "Probes every controller.", Schedule: "every 1m", NextRun: time.Now().Add(time.Minute)},
+		{Name: "cleanup", Schedule: "every 6h"},
+	}}
+	tmpl, err := template.ParseGlob("../../../ui/templates/*.html")
+	if err != nil {
+		t.Fatalf("Failed to parse templates: %v", err)
+	}
+	r := chi.NewRouter()
+	New(ms, sch, tmpl).RegisterRoutes(r)
+	return r, ms, sch
+}
+
+// serve routes a request signed in as an admin
+func serve(r http.Handler, method, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req.WithContext(core.WithUser(req.Context(), models.User{Username: "alice", Role: models.RoleAdmin})))
+	return rr
+}
+
+func TestHandleShowJobs(t *testing.T) {
+	r, ms, _ := setupTest(t)
+	ms.Latest = []models.JobRun{{ID: 4, JobName: "cleanup", Trigger: "manual", StartedAt: time.Now(), Status: "error", Error: "database is locked"}}
+
+	rr := serve(r, "GET", "/settings/jobs")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", rr.Code)
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, "health-checks") || !strings.Contains(body, "every 1m") {
+		t.Errorf("Expected the registered jobs on the page")
+	}
+	if !strings.Contains(body, "database is locked") || !strings.Contains(body, "Never") {
+		t.Errorf("Expected the last runs, including a job that never ran")
+	}
+
+	ms.FailOps = true
+	if rr := serve(r, "GET", "/settings/jobs"); rr.Code != http.StatusInternalServerError {
+		t.Errorf("DB error: got status %d, want 500", rr.Code)
+	}
+}
+
+func TestHandleRunJob(t *testing.T) {
+	r, _, sch := setupTest(t)
+
+	rr := serve(r, "POST", "/settings/jobs/cleanup/run")
+	if rr.Code != http.StatusOK || len(sch.Started) != 1 || sch.Started[0] != "cleanup" {
+		t.Fatalf("Expected the job to start, got %d %v", rr.Code, sch.Started)
+	}
+	if !strings.Contains(rr.Body.String(), "Running...") {
+		t.Errorf("Expected the job list to show the job running")
+	}
+
+	if rr := serve(r, "POST", "/settings/jobs/cleanup/run"); rr.Code != http.StatusConflict {
+		t.Errorf("Running job: got status %d, want 409", rr.Code)
+	}
+	if rr := serve(r, "POST", "/settings/jobs/missing/run"); rr.Code != http.StatusNotFound {
+		t.Errorf("Unknown job: got status %d, want 404", rr.Code)
+	}
+}
+
+func TestHandleGetJobRuns(t *testing.T) {
+	r, ms, _ := setupTest(t)
+	ms.Runs = []models.JobRun{{ID: 1, JobName: "health-checks", Trigger: "schedule", StartedAt: time.Now(), Duration: 42 * time.Millisecond, Status: "ok"}}
+
+	rr := serve(r, "GET", "/settings/jobs/health-checks/runs")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "42 ms") {
+		t.Errorf("Expected the job's runs, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
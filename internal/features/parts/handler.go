@@ -1,9 +1,9 @@
 package parts
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"path"
@@ -25,35 +25,43 @@ const maxUploadSize = 5 * 1024 * 1024 // 5 MB
 // It could probably be split up further but for now it's manageable
 type Store interface {
 	// Part Core
-	GetPartByID(id int) (models.Part, error)
-	GetParts() ([]models.Part, error)
-	SearchParts(searchTerm string) ([]models.Part, error)
-	CreatePart(p *models.Part) error
-	UpdatePart(p *models.Part) error
-	DeletePart(id int) error
-	UpdatePartImagePath(partID int, imagePath string) error
+	GetPartByID(ctx context.Context, id int) (models.Part, error)
+	GetParts(ctx context.Context) ([]models.Part, error)
+	SearchParts(ctx context.Context, searchTerm string) ([]models.Part, error)
+	CreatePart(ctx context.Context, p *models.Part) error
+	UpdatePart(ctx context.Context, p *models.Part) error
+	DeletePart(ctx context.Context, id int) error
 
 	// Related Data (read only for details page))
-	GetPartLocations(partID int) ([]models.PartLocation, error)
-	GetAvailableBins(partID int) ([]models.Bin, error)
+	GetPartLocations(ctx context.Context, partID int) ([]models.PartLocation, error)
+	GetAvailableBins(ctx context.Context, partID int) ([]models.Bin, error)
 
 	// URLs
-	GetURLsByPartID(partID int) ([]models.PartURL, error)
-	CreatePartURL(partID int, url string, description string) error
-	DeletePartURL(urlID int) error
+	GetURLsByPartID(ctx context.Context, partID int) ([]models.PartURL, error)
+	CreatePartURL(ctx context.Context, partID int, url string, description string) (int, error)
+	DeletePartURL(ctx context.Context, urlID int) error
 
 	// Documents
-	GetDocumentsByPartID(partID int) ([]models.PartDocument, error)
-	GetDocumentByID(docID int) (models.PartDocument, error)
-	CreatePartDocument(doc *models.PartDocument) error
-	DeletePartDocument(docID int) error
+	GetDocumentsByPartID(ctx context.Context, partID int) ([]models.PartDocument, error)
+	GetDocumentByID(ctx context.Context, docID int) (models.PartDocument, error)
+	DeletePartDocument(ctx context.Context, docID int) error
 
 	// Categories
-	GetCategories() ([]models.Category, error)
-	GetCategoriesByPartID(partID int) ([]models.Category, error)
-	CreateCategory(name string) (models.Category, error)
-	AssignCategoryToPart(partID int, categoryID int) error
-	RemoveCategoryFromPart(partID int, categoryID int) error
+	GetCategories(ctx context.Context) ([]models.Category, error)
+	GetCategoriesByPartID(ctx context.Context, partID int) ([]models.Category, error)
+	RemoveCategoryFromPart(ctx context.Context, partID int, categoryID int) error
+
+	// WithTx runs fn in one transaction, see store.Store.WithTx
+	WithTx(ctx context.Context, fn func(tx TxStore) error) error
+	TxStore
+}
+
+// TxStore holds the Store methods the handlers call inside WithTx
+type TxStore interface {
+	UpdatePartImagePath(ctx context.Context, partID int, imagePath string) error
+	CreatePartDocument(ctx context.Context, doc *models.PartDocument) error
+	CreateCategory(ctx context.Context, name string) (models.Category, error)
+	AssignCategoryToPart(ctx context.Context, partID int, categoryID int) error
 }
 
 type Handler struct {
@@ -71,43 +79,54 @@ func New(s Store, t core.TemplateExecutor, uploadDir string) *Handler {
 }
 
 func (h *Handler) RegisterRoutes(r chi.Router) {
-	// Core Part Routes
-	r.Get("/", h.handleShowParts)
-	r.Post("/parts/search", h.handleSearchParts)
-	r.Post("/parts", h.handleCreatePart)
-	r.Delete("/parts/{id}", h.handleDeletePart)
-
-	// Details page and sub-resources
-	r.Get("/part/{id}", h.handleShowPartDetails)
-	r.Post("/part/{id}/details", h.handleUpdatePartDetails)
-	r.Post("/part/{id}/image/upload", h.handlePartImageUpload)
-
-	// URLs
-	r.Post("/part/urls", h.handleAddPartURL)
-	r.Delete("/part/urls/{url_id}", h.handleDeletePartURL)
-
-	// Documents
-	r.Post("/part/{id}/document/upload", h.handleUploadDocument)
-	r.Get("/part/document/{doc_id}", h.handleDownloadDocument)
-	r.Delete("/part/document/{doc_id}", h.handleDeleteDocument)
-
-	// Categories
-	r.Post("/part/categories", h.handleAssignCategoryToPart)
-	r.Delete("/part/{part_id}/categories/{cat_id}", h.handleRemoveCategoryFromPart)
+	// Finding parts
+	r.Group(func(r chi.Router) {
+		r.Use(core.Require(models.RoleViewer))
+		r.Get("/", h.handleShowParts)
+		r.Post("/parts/search", h.handleSearchParts)
+		r.Get("/parts/search", h.handleSearchParts) // For API tokens with the read scope
+		r.Get("/part/{id}", h.handleShowPartDetails)
+		r.Get("/part/document/{doc_id}", h.handleDownloadDocument)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(core.Require(models.RoleEditor))
+
+		// Core Part Routes
+		r.Post("/parts", h.handleCreatePart)
+		r.Delete("/parts/{id}", h.handleDeletePart)
+
+		// Details page and sub-resources
+		r.Post("/part/{id}/details", h.handleUpdatePartDetails)
+		r.Post("/part/{id}/image/upload", h.handlePartImageUpload)
+
+		// URLs
+		r.Post("/part/urls", h.handleAddPartURL)
+		r.Delete("/part/urls/{url_id}", h.handleDeletePartURL)
+
+		// Documents
+		r.Post("/part/{id}/document/upload", h.handleUploadDocument)
+		r.Delete("/part/document/{doc_id}", h.handleDeleteDocument)
+
+		// Categories
+		r.Post("/part/categories", h.handleAssignCategoryToPart)
+		r.Delete("/part/{part_id}/categories/{cat_id}", h.handleRemoveCategoryFromPart)
+	})
 }
 
 // Handlers
 
 func (h *Handler) handleShowParts(w http.ResponseWriter, r *http.Request) {
-	parts, err := h.store.GetParts()
+	parts, err := h.store.GetParts(r.Context())
 	if err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
 
 	data := map[string]interface{}{
-		"Title": "Inventory",
-		"Parts": parts,
+		"Title":     "Inventory",
+		"CSRFToken": core.CSRFToken(r),
+		"Parts":     parts,
 	}
 
 	err = h.templates.ExecuteTemplate(w, "index.html", data)
@@ -119,7 +138,7 @@ func (h *Handler) handleShowParts(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) handleSearchParts(w http.ResponseWriter, r *http.Request) {
 	searchTerm := r.FormValue("search")
 
-	parts, err := h.store.SearchParts(searchTerm)
+	parts, err := h.store.SearchParts(r.Context(), searchTerm)
 	if err != nil {
 		core.ServerError(w, r, err)
 		return
@@ -162,7 +181,7 @@ func (h *Handler) handleCreatePart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.store.CreatePart(part); err != nil {
+	if err := h.store.CreatePart(r.Context(), part); err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
@@ -177,7 +196,7 @@ func (h *Handler) handleDeletePart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.store.DeletePart(id); err != nil {
+	if err := h.store.DeletePart(r.Context(), id); err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
@@ -188,42 +207,42 @@ func (h *Handler) handleDeletePart(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) handleShowPartDetails(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
 
-	part, err := h.store.GetPartByID(id)
+	part, err := h.store.GetPartByID(r.Context(), id)
 	if err != nil {
 		core.ClientError(w, r, http.StatusNotFound, "Part not found", err)
 		return
 	}
 
-	locations, err := h.store.GetPartLocations(id)
+	locations, err := h.store.GetPartLocations(r.Context(), id)
 	if err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
 
-	availableBins, err := h.store.GetAvailableBins(id)
+	availableBins, err := h.store.GetAvailableBins(r.Context(), id)
 	if err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
 
-	urls, err := h.store.GetURLsByPartID(id)
+	urls, err := h.store.GetURLsByPartID(r.Context(), id)
 	if err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
 
-	docs, err := h.store.GetDocumentsByPartID(id)
+	docs, err := h.store.GetDocumentsByPartID(r.Context(), id)
 	if err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
 
-	assignedCategories, err := h.store.GetCategoriesByPartID(id)
+	assignedCategories, err := h.store.GetCategoriesByPartID(r.Context(), id)
 	if err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
-	allCategories, err := h.store.GetCategories()
+	allCategories, err := h.store.GetCategories(r.Context())
 	if err != nil {
 		core.ServerError(w, r, err)
 		return
@@ -231,6 +250,7 @@ func (h *Handler) handleShowPartDetails(w http.ResponseWriter, r *http.Request)
 
 	data := map[string]interface{}{
 		"Title":              part.Name,
+		"CSRFToken":          core.CSRFToken(r),
 		"Part":               part,
 		"Locations":          locations,
 		"AvailableBins":      availableBins,
@@ -263,7 +283,7 @@ func (h *Handler) handleUpdatePartDetails(w http.ResponseWriter, r *http.Request
 	minStock, _ := strconv.Atoi(r.FormValue("min_stock"))
 	stockTracking := r.FormValue("stock_tracking_enabled") == "on"
 
-	part, err := h.store.GetPartByID(id)
+	part, err := h.store.GetPartByID(r.Context(), id)
 	if err != nil {
 		core.ClientError(w, r, http.StatusNotFound, "Part not found", err)
 		return
@@ -281,7 +301,7 @@ func (h *Handler) handleUpdatePartDetails(w http.ResponseWriter, r *http.Request
 	part.ReorderPoint = reorder
 	part.MinStock = minStock
 
-	if err := h.store.UpdatePart(&part); err != nil {
+	if err := h.store.UpdatePart(r.Context(), &part); err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
@@ -295,7 +315,7 @@ func (h *Handler) handlePartImageUpload(w http.ResponseWriter, r *http.Request)
 		core.ClientError(w, r, http.StatusBadRequest, "Invalid Part ID", nil)
 		return
 	}
-	part, err := h.store.GetPartByID(partID)
+	part, err := h.store.GetPartByID(r.Context(), partID)
 	if err != nil {
 		core.ClientError(w, r, http.StatusNotFound, "Part not found", err)
 		return
@@ -336,18 +356,23 @@ func (h *Handler) handlePartImageUpload(w http.ResponseWriter, r *http.Request)
 	absDir := filepath.Join(h.uploadDir, "images")
 	absPath := filepath.Join(absDir, filename)
 
-	if err := os.MkdirAll(absDir, os.ModePerm); err != nil {
+	// The file gets its name once the new path is committed
+	upload, err := core.SaveUpload(file, absDir, absPath)
+	if err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
-	dst, err := os.Create(absPath)
+	err = h.store.WithTx(r.Context(), func(tx TxStore) error {
+		return tx.UpdatePartImagePath(r.Context(), partID, relPath)
+	})
 	if err != nil {
+		upload.Discard()
 		core.ServerError(w, r, err)
 		return
 	}
-	defer dst.Close()
-
-	if _, err := io.Copy(dst, file); err != nil {
+	if err := upload.Keep(); err != nil {
+		h.store.UpdatePartImagePath(r.Context(), partID, part.ImagePath.String)
+		upload.Discard()
 		core.ServerError(w, r, err)
 		return
 	}
@@ -358,11 +383,6 @@ func (h *Handler) handlePartImageUpload(w http.ResponseWriter, r *http.Request)
 			fmt.Printf("Warning: failed to delete old image file %s: %v\n", oldPath, err)
 		}
 	}
-
-	if err := h.store.UpdatePartImagePath(partID, relPath); err != nil {
-		core.ServerError(w, r, err)
-		return
-	}
 	http.Redirect(w, r, "/part/"+strconv.Itoa(partID), http.StatusSeeOther)
 }
 
@@ -379,7 +399,7 @@ func (h *Handler) handleAddPartURL(w http.ResponseWriter, r *http.Request) {
 		core.ClientError(w, r, http.StatusBadRequest, "Part ID and URL are required", nil)
 		return
 	}
-	if err := h.store.CreatePartURL(partID, url, desc); err != nil {
+	if _, err := h.store.CreatePartURL(r.Context(), partID, url, desc); err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
@@ -392,7 +412,7 @@ func (h *Handler) handleDeletePartURL(w http.ResponseWriter, r *http.Request) {
 		core.ClientError(w, r, http.StatusBadRequest, "Invalid URL ID", nil)
 		return
 	}
-	if err := h.store.DeletePartURL(urlID); err != nil {
+	if err := h.store.DeletePartURL(r.Context(), urlID); err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
@@ -421,30 +441,31 @@ func (h *Handler) handleUploadDocument(w http.ResponseWriter, r *http.Request) {
 	absDir := filepath.Join(h.uploadDir, "documents")
 	absPath := filepath.Join(absDir, filename)
 
-	if err := os.MkdirAll(absDir, os.ModePerm); err != nil {
-		core.ServerError(w, r, err)
-		return
+	doc := &models.PartDocument{
+		PartID:      partID,
+		Filename:    header.Filename,
+		Filepath:    relPath,
+		Description: sql.NullString{String: r.FormValue("description"), Valid: true},
+		Mimetype:    header.Header.Get("Content-Type"),
 	}
-	dst, err := os.Create(absPath)
+	// The file gets its name once the document is committed
+	upload, err := core.SaveUpload(file, absDir, absPath)
 	if err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
-	defer dst.Close()
-	if _, err := io.Copy(dst, file); err != nil {
+	err = h.store.WithTx(r.Context(), func(tx TxStore) error {
+		return tx.CreatePartDocument(r.Context(), doc)
+	})
+	if err != nil {
+		upload.Discard()
 		core.ServerError(w, r, err)
 		return
 	}
-	doc := &models.PartDocument{
-		PartID:      partID,
-		Filename:    header.Filename,
-		Filepath:    relPath,
-		Description: sql.NullString{String: r.FormValue("description"), Valid: true},
-		Mimetype:    header.Header.Get("Content-Type"),
-	}
-	if err := h.store.CreatePartDocument(doc); err != nil {
+	if err := upload.Keep(); err != nil {
+		h.store.DeletePartDocument(r.Context(), doc.ID)
+		upload.Discard()
 		core.ServerError(w, r, err)
-		os.Remove(absPath)
 		return
 	}
 	http.Redirect(w, r, "/part/"+strconv.Itoa(partID), http.StatusSeeOther)
@@ -456,7 +477,7 @@ func (h *Handler) handleDownloadDocument(w http.ResponseWriter, r *http.Request)
 		core.ClientError(w, r, http.StatusBadRequest, "Invalid Document ID", nil)
 		return
 	}
-	doc, err := h.store.GetDocumentByID(docID)
+	doc, err := h.store.GetDocumentByID(r.Context(), docID)
 	if err != nil {
 		core.ClientError(w, r, http.StatusNotFound, "Document not found", err)
 		return
@@ -472,12 +493,12 @@ func (h *Handler) handleDeleteDocument(w http.ResponseWriter, r *http.Request) {
 		core.ClientError(w, r, http.StatusBadRequest, "Invalid Document ID", nil)
 		return
 	}
-	doc, err := h.store.GetDocumentByID(docID)
+	doc, err := h.store.GetDocumentByID(r.Context(), docID)
 	if err != nil {
 		core.ClientError(w, r, http.StatusNotFound, "Document not found", err)
 		return
 	}
-	if err := h.store.DeletePartDocument(docID); err != nil {
+	if err := h.store.DeletePartDocument(r.Context(), docID); err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
@@ -499,15 +520,18 @@ func (h *Handler) handleAssignCategoryToPart(w http.ResponseWriter, r *http.Requ
 		core.ClientError(w, r, http.StatusBadRequest, "Part ID and Category Name are required", nil)
 		return
 	}
-	category, err := h.store.CreateCategory(categoryName)
+	// A new category is only kept if it's assigned
+	err := h.store.WithTx(r.Context(), func(tx TxStore) error {
+		category, err := tx.CreateCategory(r.Context(), categoryName)
+		if err != nil {
+			return err
+		}
+		return tx.AssignCategoryToPart(r.Context(), partID, category.ID)
+	})
 	if err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
-	if err := h.store.AssignCategoryToPart(partID, category.ID); err != nil {
-		core.ServerError(w, r, err)
-		return
-	}
 	http.Redirect(w, r, "/part/"+r.FormValue("part_id"), http.StatusSeeOther)
 }
 
@@ -519,7 +543,7 @@ func (h *Handler) handleRemoveCategoryFromPart(w http.ResponseWriter, r *http.Re
 		core.ClientError(w, r, http.StatusBadRequest, "Invalid Part or Category ID", nil)
 		return
 	}
-	if err := h.store.RemoveCategoryFromPart(partID, categoryID); err != nil {
+	if err := h.store.RemoveCategoryFromPart(r.Context(), partID, categoryID); err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
@@ -2,6 +2,8 @@ package parts
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"html/template"
 	"mime/multipart"
 	"net/http"
@@ -42,139 +44,151 @@ type mockStore struct {
 	AssignCategoryToPartFunc   func(partID int, categoryID int) error
 	RemoveCategoryFromPartFunc func(partID int, categoryID int) error
 
+	// RolledBack is set when a WithTx function fails
+	RolledBack bool
+
 	// Unused stubs
 	GetBinLocationCountFunc       func(partID int) (int, error)
 	CleanupOrphanedCategoriesFunc func() error
 }
 
 // Implementations
-func (m *mockStore) GetParts() ([]models.Part, error) {
+func (m *mockStore) GetParts(ctx context.Context) ([]models.Part, error) {
 	if m.GetPartsFunc != nil {
 		return m.GetPartsFunc()
 	}
 	return nil, nil
 }
-func (m *mockStore) DeletePart(id int) error {
+func (m *mockStore) DeletePart(ctx context.Context, id int) error {
 	if m.DeletePartFunc != nil {
 		return m.DeletePartFunc(id)
 	}
 	return nil
 }
-func (m *mockStore) CreatePart(p *models.Part) error {
+func (m *mockStore) CreatePart(ctx context.Context, p *models.Part) error {
 	if m.CreatePartFunc != nil {
 		return m.CreatePartFunc(p)
 	}
 	return nil
 }
-func (m *mockStore) GetPartByID(id int) (models.Part, error) {
+func (m *mockStore) GetPartByID(ctx context.Context, id int) (models.Part, error) {
 	if m.GetPartByIDFunc != nil {
 		return m.GetPartByIDFunc(id)
 	}
 	return models.Part{}, nil
 }
-func (m *mockStore) UpdatePart(p *models.Part) error {
+func (m *mockStore) UpdatePart(ctx context.Context, p *models.Part) error {
 	if m.UpdatePartFunc != nil {
 		return m.UpdatePartFunc(p)
 	}
 	return nil
 }
-func (m *mockStore) UpdatePartImagePath(partID int, imagePath string) error {
+func (m *mockStore) UpdatePartImagePath(ctx context.Context, partID int, imagePath string) error {
 	if m.UpdatePartImagePathFunc != nil {
 		return m.UpdatePartImagePathFunc(partID, imagePath)
 	}
 	return nil
 }
-func (m *mockStore) SearchParts(searchTerm string) ([]models.Part, error) {
+func (m *mockStore) SearchParts(ctx context.Context, searchTerm string) ([]models.Part, error) {
 	if m.SearchPartsFunc != nil {
 		return m.SearchPartsFunc(searchTerm)
 	}
 	return nil, nil
 }
-func (m *mockStore) GetPartLocations(partID int) ([]models.PartLocation, error) {
+func (m *mockStore) GetPartLocations(ctx context.Context, partID int) ([]models.PartLocation, error) {
 	if m.GetPartLocationsFunc != nil {
 		return m.GetPartLocationsFunc(partID)
 	}
 	return nil, nil
 }
-func (m *mockStore) GetAvailableBins(partID int) ([]models.Bin, error) {
+func (m *mockStore) GetAvailableBins(ctx context.Context, partID int) ([]models.Bin, error) {
 	if m.GetAvailableBinsFunc != nil {
 		return m.GetAvailableBinsFunc(partID)
 	}
 	return nil, nil
 }
-func (m *mockStore) GetURLsByPartID(partID int) ([]models.PartURL, error) {
+func (m *mockStore) GetURLsByPartID(ctx context.Context, partID int) ([]models.PartURL, error) {
 	if m.GetURLsByPartIDFunc != nil {
 		return m.GetURLsByPartIDFunc(partID)
 	}
 	return nil, nil
 }
-func (m *mockStore) GetDocumentsByPartID(partID int) ([]models.PartDocument, error) {
+func (m *mockStore) GetDocumentsByPartID(ctx context.Context, partID int) ([]models.PartDocument, error) {
 	if m.GetDocumentsByPartIDFunc != nil {
 		return m.GetDocumentsByPartIDFunc(partID)
 	}
 	return nil, nil
 }
-func (m *mockStore) GetCategoriesByPartID(partID int) ([]models.Category, error) {
+func (m *mockStore) GetCategoriesByPartID(ctx context.Context, partID int) ([]models.Category, error) {
 	if m.GetCategoriesByPartIDFunc != nil {
 		return m.GetCategoriesByPartIDFunc(partID)
 	}
 	return nil, nil
 }
-func (m *mockStore) GetCategories() ([]models.Category, error) {
+func (m *mockStore) GetCategories(ctx context.Context) ([]models.Category, error) {
 	if m.GetCategoriesFunc != nil {
 		return m.GetCategoriesFunc()
 	}
 	return nil, nil
 }
-func (m *mockStore) CreatePartURL(partID int, url string, description string) error {
+func (m *mockStore) CreatePartURL(ctx context.Context, partID int, url string, description string) (int, error) {
 	if m.CreatePartURLFunc != nil {
-		return m.CreatePartURLFunc(partID, url, description)
+		return 1, m.CreatePartURLFunc(partID, url, description)
 	}
-	return nil
+	return 1, nil
 }
-func (m *mockStore) DeletePartURL(urlID int) error {
+func (m *mockStore) DeletePartURL(ctx context.Context, urlID int) error {
 	if m.DeletePartURLFunc != nil {
 		return m.DeletePartURLFunc(urlID)
 	}
 	return nil
 }
-func (m *mockStore) CreatePartDocument(doc *models.PartDocument) error {
+func (m *mockStore) CreatePartDocument(ctx context.Context, doc *models.PartDocument) error {
 	if m.CreatePartDocumentFunc != nil {
 		return m.CreatePartDocumentFunc(doc)
 	}
 	return nil
 }
-func (m *mockStore) GetDocumentByID(docID int) (models.PartDocument, error) {
+func (m *mockStore) GetDocumentByID(ctx context.Context, docID int) (models.PartDocument, error) {
 	if m.GetDocumentByIDFunc != nil {
 		return m.GetDocumentByIDFunc(docID)
 	}
 	return models.PartDocument{}, nil
 }
-func (m *mockStore) DeletePartDocument(docID int) error {
+func (m *mockStore) DeletePartDocument(ctx context.Context, docID int) error {
 	if m.DeletePartDocumentFunc != nil {
 		return m.DeletePartDocumentFunc(docID)
 	}
 	return nil
 }
-func (m *mockStore) CreateCategory(name string) (models.Category, error) {
+func (m *mockStore) CreateCategory(ctx context.Context, name string) (models.Category, error) {
 	if m.CreateCategoryFunc != nil {
 		return m.CreateCategoryFunc(name)
 	}
 	return models.Category{}, nil
 }
-func (m *mockStore) AssignCategoryToPart(partID int, categoryID int) error {
+func (m *mockStore) AssignCategoryToPart(ctx context.Context, partID int, categoryID int) error {
 	if m.AssignCategoryToPartFunc != nil {
 		return m.AssignCategoryToPartFunc(partID, categoryID)
 	}
 	return nil
 }
-func (m *mockStore) RemoveCategoryFromPart(partID int, categoryID int) error {
+func (m *mockStore) RemoveCategoryFromPart(ctx context.Context, partID int, categoryID int) error {
 	if m.RemoveCategoryFromPartFunc != nil {
 		return m.RemoveCategoryFromPartFunc(partID, categoryID)
 	}
 	return nil
 }
 
+// WithTx runs fn without a transaction, recording a rollback if it fails
+func (m *mockStore) WithTx(ctx context.Context, fn func(tx TxStore) error) error {
+	err := fn(m)
+	if err != nil {
+		m.RolledBack = true
+	}
+	return err
+}
+
 // Unused stubs (required by interface)
 func (m *mockStore) CleanupOrphanedCategories() error            { return nil }
 func (m *mockStore) GetBinLocationCount(partID int) (int, error) { return 0, nil }
@@ -546,6 +560,69 @@ func TestHandleUploadDocument(t *testing.T) {
 	}
 }
 
+func TestHandleUploadDocument_SaveFails(t *testing.T) {
+	h, ms := setupTest(t)
+	// A file where the documents directory should be
+	os.WriteFile(filepath.Join(h.uploadDir, "documents"), nil, 0644)
+	called := false
+	ms.CreatePartDocumentFunc = func(doc *models.PartDocument) error {
+		called = true
+		return nil
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("part_document", "test.txt")
+	part.Write([]byte("test content"))
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/part/1/document/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+
+	r := chi.NewRouter()
+	r.Post("/part/{id}/document/upload", h.handleUploadDocument)
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want 500", rr.Code)
+	}
+	if called {
+		t.Error("Expected no document row for a file that wasn't saved")
+	}
+}
+
+func TestHandleUploadDocument_InsertFails(t *testing.T) {
+	h, ms := setupTest(t)
+	ms.CreatePartDocumentFunc = func(doc *models.PartDocument) error {
+		return errors.New("db error")
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("part_document", "test.txt")
+	part.Write([]byte("test content"))
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/part/1/document/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+
+	r := chi.NewRouter()
+	r.Post("/part/{id}/document/upload", h.handleUploadDocument)
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want 500", rr.Code)
+	}
+	if !ms.RolledBack {
+		t.Error("Expected the document row to be rolled back")
+	}
+	if files, _ := os.ReadDir(filepath.Join(h.uploadDir, "documents")); len(files) != 0 {
+		t.Errorf("Expected the file removed, found %d files", len(files))
+	}
+}
+
 func TestHandleDownloadDocument(t *testing.T) {
 	h, ms := setupTest(t)
 	// Create a dummy file to serve
@@ -0,0 +1,254 @@
+package rules
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"wledger/internal/core"
+	"wledger/internal/models"
+	"wledger/internal/stockstatus"
+)
+
+// Store defines the database methods this module needs
+type Store interface {
+	GetStockRuleByID(ctx context.Context, id int) (models.StockRule, error)
+	CreateStockRule(ctx context.Context, r *models.StockRule) error
+	UpdateStockRule(ctx context.Context, r *models.StockRule) error
+	DeleteStockRule(ctx context.Context, id int) error
+}
+
+type Handler struct {
+	store     Store
+	templates core.TemplateExecutor
+}
+
+func New(s Store, t core.TemplateExecutor) *Handler {
+	return &Handler{store: s, templates: t}
+}
+
+func (h *Handler) RegisterRoutes(r chi.Router) {
+	r.Group(func(r chi.Router) {
+		r.Use(core.Require(models.RoleAdmin))
+		r.Post("/settings/rules", h.handleCreateRule)
+		r.Get("/settings/rules/{id}", h.handleGetRuleRow)
+		r.Get("/settings/rules/{id}/edit", h.handleGetRuleEditRow)
+		r.Put("/settings/rules/{id}", h.handleUpdateRule)
+		r.Put("/settings/rules/{id}/toggle", h.handleToggleRule)
+		r.Delete("/settings/rules/{id}", h.handleDeleteRule)
+	})
+}
+
+// Handlers
+
+func (h *Handler) handleCreateRule(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		core.ClientError(w, r, http.StatusBadRequest, "Bad Request", err)
+		return
+	}
+
+	rule := ruleFromForm(r)
+	rule.Enabled = true
+	if msg := validateRule(rule); msg != "" {
+		core.ClientError(w, r, http.StatusBadRequest, msg, nil)
+		return
+	}
+
+	if err := h.store.CreateStockRule(r.Context(), rule); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+func (h *Handler) handleGetRuleRow(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	rule, err := h.store.GetStockRuleByID(r.Context(), id)
+	if err != nil {
+		core.ClientError(w, r, http.StatusNotFound, "Rule not found", err)
+		return
+	}
+	h.templates.ExecuteTemplate(w, "_rule-row.html", rule)
+}
+
+func (h *Handler) handleGetRuleEditRow(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	rule, err := h.store.GetStockRuleByID(r.Context(), id)
+	if err != nil {
+		core.ClientError(w, r, http.StatusNotFound, "Rule not found", err)
+		return
+	}
+
+	form, other := splitConditions(rule.Conditions)
+	data := map[string]interface{}{
+		"Rule":       rule,
+		"Conditions": form,
+		"Other":      other,
+	}
+	h.templates.ExecuteTemplate(w, "_rule-edit-row.html", data)
+}
+
+func (h *Handler) handleUpdateRule(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	if err := r.ParseForm(); err != nil {
+		core.ClientError(w, r, http.StatusBadRequest, "Bad Request", err)
+		return
+	}
+
+	current, err := h.store.GetStockRuleByID(r.Context(), id)
+	if err != nil {
+		core.ClientError(w, r, http.StatusNotFound, "Rule not found", err)
+		return
+	}
+
+	rule := ruleFromForm(r)
+	rule.ID = id
+	rule.Enabled = current.Enabled
+	if msg := validateRule(rule); msg != "" {
+		core.ClientError(w, r, http.StatusBadRequest, msg, nil)
+		return
+	}
+
+	// Conditions the form can't show are kept as they are
+	_, other := splitConditions(current.Conditions)
+	rule.Conditions = append(rule.Conditions, other...)
+
+	if err := h.store.UpdateStockRule(r.Context(), rule); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	h.templates.ExecuteTemplate(w, "_rule-row.html", rule)
+}
+
+func (h *Handler) handleToggleRule(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	rule, err := h.store.GetStockRuleByID(r.Context(), id)
+	if err != nil {
+		core.ClientError(w, r, http.StatusNotFound, "Rule not found", err)
+		return
+	}
+
+	rule.Enabled = !rule.Enabled
+	if err := h.store.UpdateStockRule(r.Context(), &rule); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	h.templates.ExecuteTemplate(w, "_rule-row.html", rule)
+}
+
+func (h *Handler) handleDeleteRule(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	if id == 0 {
+		core.ClientError(w, r, http.StatusBadRequest, "Invalid ID", nil)
+		return
+	}
+	if err := h.store.DeleteStockRule(r.Context(), id); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// ruleFromForm reads a rule submitted by the add or edit form
+func ruleFromForm(r *http.Request) *models.StockRule {
+	rule := &models.StockRule{
+		Name:       strings.TrimSpace(r.FormValue("name")),
+		Severity:   r.FormValue("severity"),
+		Action:     r.FormValue("action"),
+		Color:      strings.ToUpper(strings.TrimPrefix(r.FormValue("color"), "#")),
+		Message:    strings.TrimSpace(r.FormValue("message")),
+		Conditions: conditionsFromForm(r),
+	}
+	rule.Priority, _ = strconv.Atoi(r.FormValue("priority"))
+	rule.Effect, _ = strconv.Atoi(r.FormValue("effect"))
+	return rule
+}
+
+// validateRule returns what's wrong with a rule, or "" if nothing is
+func validateRule(rule *models.StockRule) string {
+	if rule.Name == "" {
+		return "Rule name is required"
+	}
+	switch rule.Severity {
+	case stockstatus.SeverityCritical, stockstatus.SeverityAttention, stockstatus.SeverityOK:
+	default:
+		return "Invalid severity"
+	}
+	switch rule.Action {
+	case stockstatus.ActionColor, stockstatus.ActionEffect:
+		if len(rule.Color) != 6 {
+			return "A color is required for color and effect rules"
+		}
+	case stockstatus.ActionNotify:
+		if rule.Message == "" {
+			return "A message is required for notification rules"
+		}
+	default:
+		return "Invalid action"
+	}
+	return ""
+}
+
+// splitConditions sorts a rule's conditions into those the form shows, by
+// field, and the rest, such as ones from a restored backup
+func splitConditions(conditions []models.StockRuleCondition) (form map[string]models.StockRuleCondition, other []models.StockRuleCondition) {
+	form = make(map[string]models.StockRuleCondition)
+	for _, c := range conditions {
+		var editable bool
+		switch c.Field {
+		case stockstatus.FieldQuantity:
+			editable = true
+		case stockstatus.FieldCategory, stockstatus.FieldSupplier, stockstatus.FieldManufacturer, stockstatus.FieldStatus:
+			editable = c.Operator == stockstatus.OpEqual
+		case stockstatus.FieldDaysSinceUpdate:
+			editable = c.Operator == stockstatus.OpGreaterOrEqual
+		}
+		if _, taken := form[c.Field]; editable && !taken {
+			form[c.Field] = c
+		} else {
+			other = append(other, c)
+		}
+	}
+	return form, other
+}
+
+// conditionsFromForm builds the rule conditions from the optional form fields.
+// Blank fields are skipped.
+func conditionsFromForm(r *http.Request) []models.StockRuleCondition {
+	conditions := []models.StockRuleCondition{}
+
+	if value := strings.TrimSpace(r.FormValue("quantity_value")); value != "" {
+		op := r.FormValue("quantity_op")
+		if op == "" {
+			op = stockstatus.OpLessOrEqual
+		}
+		conditions = append(conditions, models.StockRuleCondition{
+			Field: stockstatus.FieldQuantity, Operator: op, Value: value,
+		})
+	}
+
+	textFields := []string{
+		stockstatus.FieldCategory,
+		stockstatus.FieldSupplier,
+		stockstatus.FieldManufacturer,
+		stockstatus.FieldStatus,
+	}
+	for _, field := range textFields {
+		if value := strings.TrimSpace(r.FormValue(field)); value != "" {
+			conditions = append(conditions, models.StockRuleCondition{
+				Field: field, Operator: stockstatus.OpEqual, Value: value,
+			})
+		}
+	}
+
+	if value := strings.TrimSpace(r.FormValue("days_since_update")); value != "" {
+		conditions = append(conditions, models.StockRuleCondition{
+			Field: stockstatus.FieldDaysSinceUpdate, Operator: stockstatus.OpGreaterOrEqual, Value: value,
+		})
+	}
+
+	return conditions
+}
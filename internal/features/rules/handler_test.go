@@ -0,0 +1,230 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"wledger/internal/models"
+)
+
+// Local mock
+type mockStore struct {
+	FailOps bool
+
+	Rules   map[int]models.StockRule
+	Created *models.StockRule
+}
+
+func (m *mockStore) GetStockRuleByID(ctx context.Context, id int) (models.StockRule, error) {
+	r, ok := m.Rules[id]
+	if !ok {
+		return r, errors.New("not found")
+	}
+	return r, nil
+}
+func (m *mockStore) CreateStockRule(ctx context.Context, r *models.StockRule) error {
+	if m.FailOps {
+		return errors.New("db error")
+	}
+	m.Created = r
+	return nil
+}
+func (m *mockStore) UpdateStockRule(ctx context.Context, r *models.StockRule) error {
+	if m.FailOps {
+		return errors.New("db error")
+	}
+	m.Rules[r.ID] = *r
+	return nil
+}
+func (m *mockStore) DeleteStockRule(ctx context.Context, id int) error {
+	if m.FailOps {
+		return errors.New("db error")
+	}
+	delete(m.Rules, id)
+	return nil
+}
+
+// Test Setup Helper
+func setupTest(t *testing.T) (*Handler, *mockStore) {
+	t.Helper()
+	ms := &mockStore{Rules: map[int]models.StockRule{}}
+	tmpl, err := template.ParseGlob("../../../ui/templates/*.html")
+	if err != nil {
+		t.Fatalf("Failed to parse templates: %v", err)
+	}
+	return New(ms, tmpl), ms
+}
+
+func TestHandleCreateRule(t *testing.T) {
+	h, ms := setupTest(t)
+
+	form := url.Values{
+		"name":           {"Digi-Key low"},
+		"priority":       {"10"},
+		"severity":       {"attention"},
+		"action":         {"color"},
+		"color":          {"#00aaff"},
+		"quantity_op":    {"lte"},
+		"quantity_value": {"reorder_point"},
+		"supplier":       {"Digi-Key"},
+		"category":       {""},
+	}
+	req := httptest.NewRequest("POST", "/settings/rules", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	h.handleCreateRule(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("got %d, want 303: %s", rr.Code, rr.Body.String())
+	}
+	if ms.Created == nil || ms.Created.Color != "00AAFF" || ms.Created.Priority != 10 {
+		t.Fatalf("Rule not created as expected: %+v", ms.Created)
+	}
+	if len(ms.Created.Conditions) != 2 {
+		t.Errorf("Expected 2 conditions, got %+v", ms.Created.Conditions)
+	}
+
+	// Notify rules need a message
+	form.Set("action", "notify")
+	req = httptest.NewRequest("POST", "/settings/rules", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	h.handleCreateRule(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Missing message: got %d, want 400", rr.Code)
+	}
+
+	// Invalid severity
+	form.Set("action", "color")
+	form.Set("severity", "meh")
+	req = httptest.NewRequest("POST", "/settings/rules", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	h.handleCreateRule(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Invalid severity: got %d, want 400", rr.Code)
+	}
+}
+
+func TestHandleToggleAndDeleteRule(t *testing.T) {
+	h, ms := setupTest(t)
+	ms.Rules[1] = models.StockRule{ID: 1, Name: "R1", Enabled: true, Action: "color", Color: "FF0000"}
+
+	r := chi.NewRouter()
+	r.Put("/settings/rules/{id}/toggle", h.handleToggleRule)
+	r.Delete("/settings/rules/{id}", h.handleDeleteRule)
+
+	req := httptest.NewRequest("PUT", "/settings/rules/1/toggle", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Toggle: got %d", rr.Code)
+	}
+	if ms.Rules[1].Enabled {
+		t.Errorf("Expected rule to be disabled")
+	}
+	if !strings.Contains(rr.Body.String(), "Enable") {
+		t.Errorf("Expected Enable button in row: %s", rr.Body.String())
+	}
+
+	// Not found
+	req = httptest.NewRequest("PUT", "/settings/rules/99/toggle", nil)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Toggle missing: got %d", rr.Code)
+	}
+
+	req = httptest.NewRequest("DELETE", "/settings/rules/1", nil)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || len(ms.Rules) != 0 {
+		t.Errorf("Delete: got %d, rules left %d", rr.Code, len(ms.Rules))
+	}
+
+	// DB Error
+	ms.FailOps = true
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("DB Error: got %d", rr.Code)
+	}
+}
+
+func TestHandleEditAndUpdateRule(t *testing.T) {
+	h, ms := setupTest(t)
+	ms.Rules[1] = models.StockRule{
+		ID: 1, Name: "Low", Priority: 20, Enabled: false, Severity: "attention", Action: "color", Color: "FFAA00",
+		Conditions: []models.StockRuleCondition{
+			{Field: "quantity", Operator: "lte", Value: "reorder_point"},
+			{Field: "name", Operator: "contains", Value: "resistor"},
+		},
+	}
+
+	r := chi.NewRouter()
+	r.Get("/settings/rules/{id}", h.handleGetRuleRow)
+	r.Get("/settings/rules/{id}/edit", h.handleGetRuleEditRow)
+	r.Put("/settings/rules/{id}", h.handleUpdateRule)
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/settings/rules/1/edit", nil))
+	body := rr.Body.String()
+	if rr.Code != http.StatusOK || !strings.Contains(body, `value="reorder_point"`) || !strings.Contains(body, "name contains resistor") {
+		t.Fatalf("Expected the edit row with the rule's conditions, got %d: %s", rr.Code, body)
+	}
+
+	form := url.Values{
+		"name":           {"Very low"},
+		"priority":       {"5"},
+		"severity":       {"critical"},
+		"action":         {"color"},
+		"color":          {"#ff0000"},
+		"quantity_op":    {"lte"},
+		"quantity_value": {"min_stock"},
+	}
+	req := httptest.NewRequest("PUT", "/settings/rules/1", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Update: got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	got := ms.Rules[1]
+	if got.Name != "Very low" || got.Priority != 5 || got.Color != "FF0000" || got.Enabled {
+		t.Errorf("Rule not updated as expected: %+v", got)
+	}
+	// The condition the form can't show is kept
+	if len(got.Conditions) != 2 || got.Conditions[0].Value != "min_stock" || got.Conditions[1].Field != "name" {
+		t.Errorf("Unexpected conditions: %+v", got.Conditions)
+	}
+	if !strings.Contains(rr.Body.String(), "Very low") {
+		t.Errorf("Expected the updated row, got %s", rr.Body.String())
+	}
+
+	// Invalid
+	form.Set("name", "")
+	req = httptest.NewRequest("PUT", "/settings/rules/1", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Missing name: got %d, want 400", rr.Code)
+	}
+
+	// Not found
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/settings/rules/99/edit", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Edit missing: got %d", rr.Code)
+	}
+}
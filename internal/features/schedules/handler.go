@@ -0,0 +1,119 @@
+package schedules
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"wledger/internal/background"
+	"wledger/internal/core"
+	"wledger/internal/cron"
+	"wledger/internal/models"
+)
+
+// Store defines the database methods this module needs
+type Store interface {
+	GetLightingScheduleByID(ctx context.Context, id int) (models.LightingSchedule, error)
+	CreateLightingSchedule(ctx context.Context, ls *models.LightingSchedule) error
+	UpdateLightingSchedule(ctx context.Context, ls *models.LightingSchedule) error
+	DeleteLightingSchedule(ctx context.Context, id int) error
+}
+
+type Handler struct {
+	store     Store
+	templates core.TemplateExecutor
+}
+
+func New(s Store, t core.TemplateExecutor) *Handler {
+	return &Handler{store: s, templates: t}
+}
+
+func (h *Handler) RegisterRoutes(r chi.Router) {
+	r.Group(func(r chi.Router) {
+		r.Use(core.Require(models.RoleAdmin))
+		r.Post("/settings/schedules", h.handleCreateSchedule)
+		r.Put("/settings/schedules/{id}/toggle", h.handleToggleSchedule)
+		r.Delete("/settings/schedules/{id}", h.handleDeleteSchedule)
+	})
+}
+
+// Handlers
+
+func (h *Handler) handleCreateSchedule(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		core.ClientError(w, r, http.StatusBadRequest, "Bad Request", err)
+		return
+	}
+
+	ls := &models.LightingSchedule{
+		Name:    strings.TrimSpace(r.FormValue("name")),
+		Cron:    strings.Join(strings.Fields(r.FormValue("cron")), " "),
+		Action:  r.FormValue("action"),
+		Color:   strings.ToUpper(strings.TrimPrefix(r.FormValue("color"), "#")),
+		Enabled: true,
+	}
+	ls.PresetID, _ = strconv.Atoi(r.FormValue("preset_id"))
+	ls.Brightness, _ = strconv.Atoi(r.FormValue("brightness"))
+
+	if ls.Name == "" {
+		core.ClientError(w, r, http.StatusBadRequest, "Schedule name is required", nil)
+		return
+	}
+	if _, err := cron.Parse(ls.Cron); err != nil {
+		core.ClientError(w, r, http.StatusBadRequest, "Invalid schedule: "+err.Error(), err)
+		return
+	}
+	if ls.Brightness < 0 || ls.Brightness > 255 {
+		core.ClientError(w, r, http.StatusBadRequest, "Brightness must be between 0 and 255", nil)
+		return
+	}
+	switch ls.Action {
+	case background.ActionStockStatus, background.ActionQuietStart, background.ActionQuietEnd:
+	case background.ActionAmbient:
+		if len(ls.Color) != 6 {
+			core.ClientError(w, r, http.StatusBadRequest, "A color is required for ambient lighting", nil)
+			return
+		}
+	default:
+		core.ClientError(w, r, http.StatusBadRequest, "Invalid action", nil)
+		return
+	}
+
+	if err := h.store.CreateLightingSchedule(r.Context(), ls); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+func (h *Handler) handleToggleSchedule(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	ls, err := h.store.GetLightingScheduleByID(r.Context(), id)
+	if err != nil {
+		core.ClientError(w, r, http.StatusNotFound, "Schedule not found", err)
+		return
+	}
+
+	ls.Enabled = !ls.Enabled
+	if err := h.store.UpdateLightingSchedule(r.Context(), &ls); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	h.templates.ExecuteTemplate(w, "_schedule-row.html", ls)
+}
+
+func (h *Handler) handleDeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	if id == 0 {
+		core.ClientError(w, r, http.StatusBadRequest, "Invalid ID", nil)
+		return
+	}
+	if err := h.store.DeleteLightingSchedule(r.Context(), id); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,163 @@
+package schedules
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"wledger/internal/models"
+)
+
+// Local mock
+type mockStore struct {
+	FailOps bool
+
+	Schedules map[int]models.LightingSchedule
+	Created   *models.LightingSchedule
+}
+
+func (m *mockStore) GetLightingScheduleByID(ctx context.Context, id int) (models.LightingSchedule, error) {
+	ls, ok := m.Schedules[id]
+	if !ok {
+		return ls, errors.New("not found")
+	}
+	return ls, nil
+}
+func (m *mockStore) CreateLightingSchedule(ctx context.Context, ls *models.LightingSchedule) error {
+	if m.FailOps {
+		return errors.New("db error")
+	}
+	m.Created = ls
+	return nil
+}
+func (m *mockStore) UpdateLightingSchedule(ctx context.Context, ls *models.LightingSchedule) error {
+	if m.FailOps {
+		return errors.New("db error")
+	}
+	m.Schedules[ls.ID] = *ls
+	return nil
+}
+func (m *mockStore) DeleteLightingSchedule(ctx context.Context, id int) error {
+	if m.FailOps {
+		return errors.New("db error")
+	}
+	delete(m.Schedules, id)
+	return nil
+}
+
+// Test Setup Helper
+func setupTest(t *testing.T) (*Handler, *mockStore) {
+	t.Helper()
+	ms := &mockStore{Schedules: map[int]models.LightingSchedule{}}
+	tmpl, err := template.ParseGlob("../../../ui/templates/*.html")
+	if err != nil {
+		t.Fatalf("Failed to parse templates: %v", err)
+	}
+	return New(ms, tmpl), ms
+}
+
+func postForm(h *Handler, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/settings/schedules", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	h.handleCreateSchedule(rr, req)
+	return rr
+}
+
+func TestHandleCreateSchedule(t *testing.T) {
+	h, ms := setupTest(t)
+
+	form := url.Values{
+		"name":       {"Morning"},
+		"cron":       {" 0 8  * * 1-5 "},
+		"action":     {"stock_status"},
+		"preset_id":  {"3"},
+		"brightness": {"0"},
+	}
+	rr := postForm(h, form)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("got %d, want 303: %s", rr.Code, rr.Body.String())
+	}
+	if ms.Created == nil || ms.Created.Cron != "0 8 * * 1-5" || ms.Created.PresetID != 3 || !ms.Created.Enabled {
+		t.Fatalf("Schedule not created as expected: %+v", ms.Created)
+	}
+
+	// Invalid cron expression
+	form.Set("cron", "0 25 * * *")
+	if rr := postForm(h, form); rr.Code != http.StatusBadRequest {
+		t.Errorf("Invalid cron: got %d, want 400", rr.Code)
+	}
+
+	// Ambient lighting needs a color
+	form.Set("cron", "*/10 * * * *")
+	form.Set("action", "ambient")
+	if rr := postForm(h, form); rr.Code != http.StatusBadRequest {
+		t.Errorf("Missing color: got %d, want 400", rr.Code)
+	}
+
+	// Brightness out of range
+	form.Set("action", "quiet_start")
+	form.Set("brightness", "300")
+	if rr := postForm(h, form); rr.Code != http.StatusBadRequest {
+		t.Errorf("Invalid brightness: got %d, want 400", rr.Code)
+	}
+
+	// Unknown action
+	form.Set("action", "disco")
+	form.Set("brightness", "10")
+	if rr := postForm(h, form); rr.Code != http.StatusBadRequest {
+		t.Errorf("Invalid action: got %d, want 400", rr.Code)
+	}
+}
+
+func TestHandleToggleAndDeleteSchedule(t *testing.T) {
+	h, ms := setupTest(t)
+	ms.Schedules[1] = models.LightingSchedule{ID: 1, Name: "Night", Cron: "0 22 * * *", Action: "quiet_start", Enabled: true}
+
+	r := chi.NewRouter()
+	r.Put("/settings/schedules/{id}/toggle", h.handleToggleSchedule)
+	r.Delete("/settings/schedules/{id}", h.handleDeleteSchedule)
+
+	req := httptest.NewRequest("PUT", "/settings/schedules/1/toggle", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Toggle: got %d", rr.Code)
+	}
+	if ms.Schedules[1].Enabled {
+		t.Errorf("Expected schedule to be disabled")
+	}
+	if !strings.Contains(rr.Body.String(), "Enable") {
+		t.Errorf("Expected Enable button in row: %s", rr.Body.String())
+	}
+
+	// Not found
+	req = httptest.NewRequest("PUT", "/settings/schedules/99/toggle", nil)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Toggle missing: got %d", rr.Code)
+	}
+
+	req = httptest.NewRequest("DELETE", "/settings/schedules/1", nil)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || len(ms.Schedules) != 0 {
+		t.Errorf("Delete: got %d, schedules left %d", rr.Code, len(ms.Schedules))
+	}
+
+	// DB Error
+	ms.FailOps = true
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("DB Error: got %d", rr.Code)
+	}
+}
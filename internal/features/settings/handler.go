@@ -1,45 +1,108 @@
 package settings
 
 import (
+	"context"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 
+	"wledger/internal/config"
 	"wledger/internal/core"
 	"wledger/internal/models"
 )
 
 // Store defines the read-only methods needed to render the settings page
 type Store interface {
-	GetControllers() ([]models.WLEDController, error)
-	GetBins() ([]models.Bin, error)
+	GetZones(ctx context.Context) ([]models.Zone, error)
+	GetControllers(ctx context.Context) ([]models.WLEDController, error)
+	GetControllerHealth(ctx context.Context, since, until time.Time, slot time.Duration) ([]models.ControllerHealth, error)
+	GetBins(ctx context.Context) ([]models.Bin, error)
+	GetStockRules(ctx context.Context) ([]models.StockRule, error)
+	GetStockStatusPresets(ctx context.Context) ([]models.StockStatusPreset, error)
+	GetLightingSchedules(ctx context.Context) ([]models.LightingSchedule, error)
+	GetScheduleRuns(ctx context.Context, limit int) ([]models.ScheduleRun, error)
+	SchemaVersion(ctx context.Context) (int, error)
 }
 
+// scheduleRunsShown is how many recent schedule runs the settings page lists
+const scheduleRunsShown = 20
+
+// The controller health report covers the last day, in hourly slots
+const (
+	healthWindow = 24 * time.Hour
+	healthSlot   = time.Hour
+)
+
 type Handler struct {
 	store     Store
 	templates core.TemplateExecutor
+	config    *config.Config
 }
 
-func New(s Store, t core.TemplateExecutor) *Handler {
-	return &Handler{store: s, templates: t}
+func New(s Store, t core.TemplateExecutor, cfg *config.Config) *Handler {
+	return &Handler{store: s, templates: t, config: cfg}
 }
 
 func (h *Handler) RegisterRoutes(r chi.Router) {
-	r.Get("/settings", h.handleShowSettings)
+	r.With(core.Require(models.RoleAdmin)).Get("/settings", h.handleShowSettings)
 }
 
 // Handlers
 
 func (h *Handler) handleShowSettings(w http.ResponseWriter, r *http.Request) {
 	// Fetch controllers from hardware domain
-	controllers, err := h.store.GetControllers()
+	controllers, err := h.store.GetControllers(r.Context())
 	if err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
 
 	// Fetch bins from inventory domain
-	bins, err := h.store.GetBins()
+	bins, err := h.store.GetBins(r.Context())
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+
+	now := time.Now()
+	health, err := h.store.GetControllerHealth(r.Context(), now.Add(-healthWindow), now, healthSlot)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+
+	zones, err := h.store.GetZones(r.Context())
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+
+	rules, err := h.store.GetStockRules(r.Context())
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+
+	presets, err := h.store.GetStockStatusPresets(r.Context())
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+
+	schedules, err := h.store.GetLightingSchedules(r.Context())
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+
+	runs, err := h.store.GetScheduleRuns(r.Context(), scheduleRunsShown)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+
+	schemaVersion, err := h.store.SchemaVersion(r.Context())
 	if err != nil {
 		core.ServerError(w, r, err)
 		return
@@ -47,9 +110,20 @@ func (h *Handler) handleShowSettings(w http.ResponseWriter, r *http.Request) {
 
 	// Render the composite view
 	data := map[string]interface{}{
-		"Title":       "Settings",
-		"Controllers": controllers,
-		"Bins":        bins,
+		"Title":         "Settings",
+		"CSRFToken":     core.CSRFToken(r),
+		"Zones":         zones,
+		"Controllers":   controllers,
+		"Health":        health,
+		"Bins":          bins,
+		"BinProblems":   countBinProblems(bins),
+		"StockRules":    rules,
+		"Presets":       presets,
+		"Schedules":     schedules,
+		"ScheduleRuns":  runs,
+		"SchemaVersion": schemaVersion,
+		"Config":        h.config.Entries(),
+		"ConfigFile":    h.config.File,
 	}
 
 	err = h.templates.ExecuteTemplate(w, "settings.html", data)
@@ -57,3 +131,14 @@ func (h *Handler) handleShowSettings(w http.ResponseWriter, r *http.Request) {
 		core.ServerError(w, r, err)
 	}
 }
+
+// countBinProblems counts the bins listed on the bin repair screen
+func countBinProblems(bins []models.Bin) int {
+	n := 0
+	for _, b := range bins {
+		if b.IsOrphaned || b.IsDetached || b.HasOverlap {
+			n++
+		}
+	}
+	return n
+}
@@ -1,11 +1,15 @@
 package settings
 
 import (
+	"context"
 	"html/template"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"wledger/internal/config"
 	"wledger/internal/models"
 )
 
@@ -13,27 +17,64 @@ import (
 type mockStore struct {
 	GetControllersFunc func() ([]models.WLEDController, error)
 	GetBinsFunc        func() ([]models.Bin, error)
+	GetStockRulesFunc  func() ([]models.StockRule, error)
+	Zones              []models.Zone
+	Health             []models.ControllerHealth
+	Schedules          []models.LightingSchedule
+	ScheduleRuns       []models.ScheduleRun
+	Version            int
 }
 
-func (m *mockStore) GetControllers() ([]models.WLEDController, error) {
+func (m *mockStore) GetControllers(ctx context.Context) ([]models.WLEDController, error) {
 	if m.GetControllersFunc != nil {
 		return m.GetControllersFunc()
 	}
 	return nil, nil
 }
-func (m *mockStore) GetBins() ([]models.Bin, error) {
+func (m *mockStore) GetBins(ctx context.Context) ([]models.Bin, error) {
 	if m.GetBinsFunc != nil {
 		return m.GetBinsFunc()
 	}
 	return nil, nil
 }
 
+func (m *mockStore) GetStockRules(ctx context.Context) ([]models.StockRule, error) {
+	if m.GetStockRulesFunc != nil {
+		return m.GetStockRulesFunc()
+	}
+	return nil, nil
+}
+
+func (m *mockStore) GetControllerHealth(ctx context.Context, since, until time.Time, slot time.Duration) ([]models.ControllerHealth, error) {
+	return m.Health, nil
+}
+
+func (m *mockStore) GetZones(ctx context.Context) ([]models.Zone, error) {
+	return m.Zones, nil
+}
+
+func (m *mockStore) GetStockStatusPresets(ctx context.Context) ([]models.StockStatusPreset, error) {
+	return nil, nil
+}
+
+func (m *mockStore) GetLightingSchedules(ctx context.Context) ([]models.LightingSchedule, error) {
+	return m.Schedules, nil
+}
+
+func (m *mockStore) GetScheduleRuns(ctx context.Context, limit int) ([]models.ScheduleRun, error) {
+	return m.ScheduleRuns, nil
+}
+
+func (m *mockStore) SchemaVersion(ctx context.Context) (int, error) {
+	return m.Version, nil
+}
+
 // Test Setup Helper
 func setupTest(t *testing.T) (*Handler, *mockStore) {
 	t.Helper()
 	ms := &mockStore{}
 	tmpl, _ := template.ParseGlob("../../../ui/templates/*.html")
-	h := New(ms, tmpl)
+	h := New(ms, tmpl, config.Default())
 	return h, ms
 }
 
@@ -45,8 +86,20 @@ func TestHandleShowSettings(t *testing.T) {
 		return []models.WLEDController{{Name: "Test Ctrl"}}, nil
 	}
 	ms.GetBinsFunc = func() ([]models.Bin, error) {
-		return []models.Bin{{Name: "Test Bin"}}, nil
+		return []models.Bin{{Name: "Test Bin"}, {Name: "Lost Bin", IsOrphaned: true}}, nil
+	}
+	ms.GetStockRulesFunc = func() ([]models.StockRule, error) {
+		return []models.StockRule{{ID: 1, Name: "Test Rule", Enabled: true, Action: "color", Color: "FF0000"}}, nil
 	}
+	ms.Health = []models.ControllerHealth{{
+		ControllerID: 1, ControllerName: "Test Ctrl", Probes: 4, Uptime: 75,
+		Timeline:  []models.HealthSlot{{Probes: 4, Failed: 1}},
+		LastError: "connection refused",
+	}}
+	ms.Zones = []models.Zone{{ID: 1, Name: "Workshop", ControllerCount: 2}}
+	ms.Schedules = []models.LightingSchedule{{ID: 1, Name: "Morning Check", Cron: "0 8 * * 1-5", Action: "stock_status", Enabled: true}}
+	ms.ScheduleRuns = []models.ScheduleRun{{ID: 1, ScheduleName: "Morning Check", Status: "ok", Message: "Lit 12 bins."}}
+	ms.Version = 4
 
 	req := httptest.NewRequest("GET", "/settings", nil)
 	rr := httptest.NewRecorder()
@@ -56,4 +109,25 @@ func TestHandleShowSettings(t *testing.T) {
 	if rr.Code != http.StatusOK {
 		t.Errorf("got status %d, want %d", rr.Code, http.StatusOK)
 	}
+	if !strings.Contains(rr.Body.String(), "Test Rule") {
+		t.Errorf("Expected stock rule in settings page")
+	}
+	if !strings.Contains(rr.Body.String(), "0 8 * * 1-5") || !strings.Contains(rr.Body.String(), "Lit 12 bins.") {
+		t.Errorf("Expected lighting schedule and its runs in settings page")
+	}
+	if !strings.Contains(rr.Body.String(), "75.0%") || !strings.Contains(rr.Body.String(), "connection refused") {
+		t.Errorf("Expected controller health in settings page")
+	}
+	if !strings.Contains(rr.Body.String(), `id="zone-1"`) {
+		t.Errorf("Expected zone in settings page")
+	}
+	if !strings.Contains(rr.Body.String(), "1 bins are orphaned") || !strings.Contains(rr.Body.String(), `href="/settings/bins/repair"`) {
+		t.Errorf("Expected a link to repair the orphaned bin")
+	}
+	if !strings.Contains(rr.Body.String(), "WLEDGER_HEALTH_INTERVAL") || !strings.Contains(rr.Body.String(), "<code>:3000</code>") {
+		t.Errorf("Expected the server configuration in settings page")
+	}
+	if !strings.Contains(rr.Body.String(), "Database schema v4") {
+		t.Errorf("Expected the schema version in settings page")
+	}
 }
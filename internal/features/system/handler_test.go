@@ -1,6 +1,7 @@
 package system
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -15,19 +16,19 @@ type mockStore struct {
 	CleanupOrphanedCategoriesFunc func() error
 }
 
-func (m *mockStore) GetAllDataForBackup() (models.BackupData, error) {
+func (m *mockStore) GetAllDataForBackup(ctx context.Context) (models.BackupData, error) {
 	if m.GetAllDataForBackupFunc != nil {
 		return m.GetAllDataForBackupFunc()
 	}
 	return models.BackupData{}, nil
 }
-func (m *mockStore) RestoreFromBackup(data models.BackupData) error {
+func (m *mockStore) RestoreFromBackup(ctx context.Context, data models.BackupData) error {
 	if m.RestoreFromBackupFunc != nil {
 		return m.RestoreFromBackupFunc(data)
 	}
 	return nil
 }
-func (m *mockStore) CleanupOrphanedCategories() error {
+func (m *mockStore) CleanupOrphanedCategories(ctx context.Context) error {
 	if m.CleanupOrphanedCategoriesFunc != nil {
 		return m.CleanupOrphanedCategoriesFunc()
 	}
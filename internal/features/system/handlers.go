@@ -2,7 +2,9 @@ package system
 
 import (
 	"archive/zip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -14,13 +16,14 @@ import (
 
 	"wledger/internal/core"
 	"wledger/internal/models"
+	"wledger/internal/store"
 )
 
 // Store defines the specific database methods this module needs.
 type Store interface {
-	GetAllDataForBackup() (models.BackupData, error)
-	RestoreFromBackup(data models.BackupData) error
-	CleanupOrphanedCategories() error
+	GetAllDataForBackup(ctx context.Context) (models.BackupData, error)
+	RestoreFromBackup(ctx context.Context, data models.BackupData) error
+	CleanupOrphanedCategories(ctx context.Context) error
 }
 
 type Handler struct {
@@ -39,15 +42,18 @@ func New(s Store, uploadDir string) *Handler {
 
 // RegisterRoutes defines the URLs for this module
 func (h *Handler) RegisterRoutes(r chi.Router) {
-	r.Post("/settings/categories/cleanup", h.handleCleanupCategories)
-	r.Get("/settings/backup/download", h.handleDownloadBackup)
-	r.Post("/settings/backup/restore", h.handleRestoreBackup)
+	r.Group(func(r chi.Router) {
+		r.Use(core.Require(models.RoleAdmin))
+		r.Post("/settings/categories/cleanup", h.handleCleanupCategories)
+		r.Get("/settings/backup/download", h.handleDownloadBackup)
+		r.Post("/settings/backup/restore", h.handleRestoreBackup)
+	})
 }
 
 // Handlers
 
 func (h *Handler) handleCleanupCategories(w http.ResponseWriter, r *http.Request) {
-	if err := h.store.CleanupOrphanedCategories(); err != nil {
+	if err := h.store.CleanupOrphanedCategories(r.Context()); err != nil {
 		core.ServerError(w, r, err)
 		return
 	}
@@ -57,7 +63,7 @@ func (h *Handler) handleCleanupCategories(w http.ResponseWriter, r *http.Request
 }
 
 func (h *Handler) handleDownloadBackup(w http.ResponseWriter, r *http.Request) {
-	data, err := h.store.GetAllDataForBackup()
+	data, err := h.store.GetAllDataForBackup(r.Context())
 	if err != nil {
 		core.ServerError(w, r, err)
 		return
@@ -179,7 +185,12 @@ func (h *Handler) handleRestoreBackup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.store.RestoreFromBackup(backupData); err != nil {
+	if err := h.store.RestoreFromBackup(r.Context(), backupData); err != nil {
+		if errors.Is(err, store.ErrBackupTooNew) {
+			msg := fmt.Sprintf("This backup is from a newer version of WLEDger (schema v%d), please upgrade first", backupData.SchemaVersion)
+			core.ClientError(w, r, http.StatusBadRequest, msg, err)
+			return
+		}
 		core.ServerError(w, r, err)
 		return
 	}
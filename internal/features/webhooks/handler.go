@@ -0,0 +1,251 @@
+package webhooks
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"wledger/internal/core"
+	"wledger/internal/models"
+	"wledger/internal/webhook"
+)
+
+// Store defines the database methods this module needs
+type Store interface {
+	GetWebhooks(ctx context.Context) ([]models.Webhook, error)
+	GetWebhookByID(ctx context.Context, id int) (models.Webhook, error)
+	CreateWebhook(ctx context.Context, w *models.Webhook) error
+	UpdateWebhook(ctx context.Context, w *models.Webhook) error
+	DeleteWebhook(ctx context.Context, id int) error
+	SendWebhookPing(ctx context.Context, id int) error
+	GetWebhookDeliveries(ctx context.Context, webhookID, limit int) ([]models.WebhookDelivery, error)
+	RetryWebhookDelivery(ctx context.Context, id int) error
+}
+
+// deliveriesShown is how many deliveries the delivery log lists
+const deliveriesShown = 50
+
+// webhookRow is a webhook in the webhook table
+type webhookRow struct {
+	models.Webhook
+	AllEvents []models.WebhookEvent
+}
+
+func newWebhookRow(w models.Webhook) webhookRow {
+	return webhookRow{Webhook: w, AllEvents: models.WebhookEvents}
+}
+
+type Handler struct {
+	store     Store
+	templates core.TemplateExecutor
+}
+
+func New(s Store, t core.TemplateExecutor) *Handler {
+	return &Handler{store: s, templates: t}
+}
+
+func (h *Handler) RegisterRoutes(r chi.Router) {
+	r.Group(func(r chi.Router) {
+		r.Use(core.Require(models.RoleAdmin))
+		r.Get("/settings/webhooks", h.handleShowWebhooks)
+		r.Post("/settings/webhooks", h.handleCreateWebhook)
+		r.Put("/settings/webhooks/{id}", h.handleUpdateWebhook)
+		r.Post("/settings/webhooks/{id}/toggle", h.handleToggleWebhook)
+		r.Post("/settings/webhooks/{id}/ping", h.handlePingWebhook)
+		r.Delete("/settings/webhooks/{id}", h.handleDeleteWebhook)
+		r.Get("/settings/webhooks/deliveries", h.handleGetDeliveries)
+		r.Post("/settings/webhooks/deliveries/{id}/retry", h.handleRetryDelivery)
+	})
+}
+
+// Handlers
+
+func (h *Handler) handleShowWebhooks(w http.ResponseWriter, r *http.Request) {
+	h.renderWebhooks(w, r, "")
+}
+
+// handleCreateWebhook adds a webhook. Its secret is generated unless one
+// is given, and only shown now.
+func (h *Handler) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	hook := &models.Webhook{Enabled: true}
+	if !parseWebhookForm(w, r, hook) {
+		return
+	}
+	hook.Secret = strings.TrimSpace(r.FormValue("secret"))
+	if hook.Secret == "" {
+		hook.Secret = webhook.NewSecret()
+	}
+	if err := h.store.CreateWebhook(r.Context(), hook); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	h.renderWebhooks(w, r, hook.Secret)
+}
+
+// handleUpdateWebhook changes a webhook's name, URL and events
+func (h *Handler) handleUpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := h.webhook(w, r)
+	if !ok || !parseWebhookForm(w, r, &hook) {
+		return
+	}
+	h.saveAndRenderRow(w, r, hook)
+}
+
+func (h *Handler) handleToggleWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := h.webhook(w, r)
+	if !ok {
+		return
+	}
+	hook.Enabled = !hook.Enabled
+	h.saveAndRenderRow(w, r, hook)
+}
+
+// handlePingWebhook queues a test delivery and shows it in the delivery log
+func (h *Handler) handlePingWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := h.webhook(w, r)
+	if !ok {
+		return
+	}
+	if err := h.store.SendWebhookPing(r.Context(), hook.ID); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	h.renderDeliveries(w, r)
+}
+
+// handleDeleteWebhook deletes a webhook with its deliveries
+func (h *Handler) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := h.webhook(w, r)
+	if !ok {
+		return
+	}
+	if err := h.store.DeleteWebhook(r.Context(), hook.ID); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleGetDeliveries re-renders the delivery log, which polls to show
+// deliveries being sent
+func (h *Handler) handleGetDeliveries(w http.ResponseWriter, r *http.Request) {
+	h.renderDeliveries(w, r)
+}
+
+// handleRetryDelivery queues a failed delivery for one more attempt
+func (h *Handler) handleRetryDelivery(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	if id == 0 {
+		core.ClientError(w, r, http.StatusBadRequest, "Invalid ID", nil)
+		return
+	}
+	if err := h.store.RetryWebhookDelivery(r.Context(), id); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	h.renderDeliveries(w, r)
+}
+
+// Helpers
+
+// webhook returns the webhook of the request's {id}, or writes an error
+func (h *Handler) webhook(w http.ResponseWriter, r *http.Request) (models.Webhook, bool) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	if id == 0 {
+		core.ClientError(w, r, http.StatusBadRequest, "Invalid ID", nil)
+		return models.Webhook{}, false
+	}
+	hook, err := h.store.GetWebhookByID(r.Context(), id)
+	if err != nil {
+		core.ClientError(w, r, http.StatusNotFound, "Webhook not found", err)
+		return models.Webhook{}, false
+	}
+	return hook, true
+}
+
+// parseWebhookForm reads the name, URL and events of the form into hook.
+// It writes an error and returns false if they aren't valid.
+func parseWebhookForm(w http.ResponseWriter, r *http.Request, hook *models.Webhook) bool {
+	if err := r.ParseForm(); err != nil {
+		core.ClientError(w, r, http.StatusBadRequest, "Bad Request", err)
+		return false
+	}
+	hook.Name = strings.TrimSpace(r.FormValue("name"))
+	if hook.Name == "" {
+		core.ClientError(w, r, http.StatusBadRequest, "Webhook name is required", nil)
+		return false
+	}
+	hook.URL = strings.TrimSpace(r.FormValue("url"))
+	if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		core.ClientError(w, r, http.StatusBadRequest, "The URL must start with http:// or https://", err)
+		return false
+	}
+	hook.Events = []models.WebhookEvent{}
+	for _, e := range r.Form["events"] {
+		event := models.WebhookEvent(e)
+		if !slices.Contains(models.WebhookEvents, event) {
+			core.ClientError(w, r, http.StatusBadRequest, "Invalid event "+e, nil)
+			return false
+		}
+		hook.Events = append(hook.Events, event)
+	}
+	if len(hook.Events) == 0 {
+		core.ClientError(w, r, http.StatusBadRequest, "Pick at least one event", nil)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) saveAndRenderRow(w http.ResponseWriter, r *http.Request, hook models.Webhook) {
+	if err := h.store.UpdateWebhook(r.Context(), &hook); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	if err := h.templates.ExecuteTemplate(w, "_webhook-row.html", newWebhookRow(hook)); err != nil {
+		core.ServerError(w, r, err)
+	}
+}
+
+func (h *Handler) renderWebhooks(w http.ResponseWriter, r *http.Request, newSecret string) {
+	hooks, err := h.store.GetWebhooks(r.Context())
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	deliveries, err := h.store.GetWebhookDeliveries(r.Context(), 0, deliveriesShown)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	rows := make([]webhookRow, len(hooks))
+	for i, hook := range hooks {
+		rows[i] = newWebhookRow(hook)
+	}
+	data := map[string]any{
+		"Title":      "Webhooks",
+		"CSRFToken":  core.CSRFToken(r),
+		"Webhooks":   rows,
+		"Deliveries": deliveries,
+		"Events":     models.WebhookEvents,
+		"NewSecret":  newSecret,
+	}
+	if err := h.templates.ExecuteTemplate(w, "webhooks.html", data); err != nil {
+		core.ServerError(w, r, err)
+	}
+}
+
+func (h *Handler) renderDeliveries(w http.ResponseWriter, r *http.Request) {
+	deliveries, err := h.store.GetWebhookDeliveries(r.Context(), 0, deliveriesShown)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	if err := h.templates.ExecuteTemplate(w, "_webhook-deliveries.html", map[string]any{"Deliveries": deliveries}); err != nil {
+		core.ServerError(w, r, err)
+	}
+}
@@ -0,0 +1,233 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"wledger/internal/core"
+	"wledger/internal/models"
+)
+
+// Local mocks
+type mockStore struct {
+	FailOps    bool
+	Hooks      map[int]models.Webhook
+	Deliveries []models.WebhookDelivery
+	Pinged     []int
+	Retried    []int
+}
+
+func (m *mockStore) GetWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	if m.FailOps {
+		return nil, errors.New("db error")
+	}
+	hooks := []models.Webhook{}
+	for _, w := range m.Hooks {
+		hooks = append(hooks, w)
+	}
+	return hooks, nil
+}
+func (m *mockStore) GetWebhookByID(ctx context.Context, id int) (models.Webhook, error) {
+	w, ok := m.Hooks[id]
+	if !ok {
+		return w, sql.ErrNoRows
+	}
+	return w, nil
+}
+func (m *mockStore) CreateWebhook(ctx context.Context, w *models.Webhook) error {
+	if m.FailOps {
+		return errors.New("db error")
+	}
+	w.ID = len(m.Hooks) + 1
+	m.Hooks[w.ID] = *w
+	return nil
+}
+func (m *mockStore) UpdateWebhook(ctx context.Context, w *models.Webhook) error {
+	m.Hooks[w.ID] = *w
+	return nil
+}
+func (m *mockStore) DeleteWebhook(ctx context.Context, id int) error {
+	delete(m.Hooks, id)
+	return nil
+}
+func (m *mockStore) SendWebhookPing(ctx context.Context, id int) error {
+	m.Pinged = append(m.Pinged, id)
+	return nil
+}
+func (m *mockStore) GetWebhookDeliveries(ctx context.Context, webhookID, limit int) ([]models.WebhookDelivery, error) {
+	return m.Deliveries, nil
+}
+func (m *mockStore) RetryWebhookDelivery(ctx context.Context, id int) error {
+	m.Retried = append(m.Retried, id)
+	return nil
+}
+
+// Test Setup Helper
+func setupTest(t *testing.T) (*chi.Mux, *mockStore) {
+	t.Helper()
+	ms := &mockStore{Hooks: map[int]models.Webhook{
+		1: {ID: 1, Name: "Order sheet", URL: "https://example.com/hook", Secret: "s3cret", Events: []models.WebhookEvent{models.EventStockLow}, Enabled: true},
+	}}
+	tmpl, err := template.ParseGlob("../../../ui/templates/*.html")
+	if err != nil {
+		t.Fatalf("Failed to parse templates: %v", err)
+	}
+	r := chi.NewRouter()
+	New(ms, tmpl).RegisterRoutes(r)
+	return r, ms
+}
+
+// serve routes a request signed in as the given role
+func serve(r http.Handler, role models.Role, method, target string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req.WithContext(core.WithUser(req.Context(), models.User{Username: "alice", Role: role})))
+	return rr
+}
+
+func TestHandleShowWebhooks(t *testing.T) {
+	r, ms := setupTest(t)
+	ms.Deliveries = []models.WebhookDelivery{
+		{ID: 9, WebhookName: "Order sheet", Event: models.EventStockLow, Status: "failed", Attempts: 12,
+			LastAttemptAt: sql.NullTime{Valid: true}, ResponseCode: 502, Error: "webhook returned 502 Bad Gateway"},
+	}
+
+	rr := serve(r, models.RoleAdmin, "GET", "/settings/webhooks", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", rr.Code)
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, "Order sheet") || !strings.Contains(body, "https://example.com/hook") {
+		t.Errorf("Expected the webhook on the page")
+	}
+	if strings.Contains(body, "s3cret") {
+		t.Errorf("Expected the secret to stay hidden")
+	}
+	if !strings.Contains(body, "502 Bad Gateway") || !strings.Contains(body, "/settings/webhooks/deliveries/9/retry") {
+		t.Errorf("Expected the failed delivery with a retry button")
+	}
+
+	if rr := serve(r, models.RoleEditor, "GET", "/settings/webhooks", nil); rr.Code != http.StatusForbidden {
+		t.Errorf("Editor: got status %d, want 403", rr.Code)
+	}
+	ms.FailOps = true
+	if rr := serve(r, models.RoleAdmin, "GET", "/settings/webhooks", nil); rr.Code != http.StatusInternalServerError {
+		t.Errorf("DB error: got status %d, want 500", rr.Code)
+	}
+}
+
+func TestHandleCreateWebhook(t *testing.T) {
+	tests := []struct {
+		name     string
+		form     url.Values
+		wantCode int
+	}{
+		{"Valid", url.Values{"name": {"Bot"}, "url": {"http://bot.local/in"}, "events": {"part.created", "controller.offline"}}, http.StatusOK},
+		{"Missing name", url.Values{"url": {"http://bot.local/in"}, "events": {"part.created"}}, http.StatusBadRequest},
+		{"Bad URL", url.Values{"name": {"Bot"}, "url": {"ftp://bot.local"}, "events": {"part.created"}}, http.StatusBadRequest},
+		{"No events", url.Values{"name": {"Bot"}, "url": {"http://bot.local/in"}}, http.StatusBadRequest},
+		{"Unknown event", url.Values{"name": {"Bot"}, "url": {"http://bot.local/in"}, "events": {"part.eaten"}}, http.StatusBadRequest},
+		{"Ping isn't an event", url.Values{"name": {"Bot"}, "url": {"http://bot.local/in"}, "events": {"ping"}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, ms := setupTest(t)
+			rr := serve(r, models.RoleAdmin, "POST", "/settings/webhooks", tt.form)
+			if rr.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.wantCode, rr.Body.String())
+			}
+			if tt.wantCode != http.StatusOK {
+				if len(ms.Hooks) != 1 {
+					t.Errorf("Expected no webhook to be created")
+				}
+				return
+			}
+			hook := ms.Hooks[2]
+			if hook.Name != "Bot" || !hook.Enabled || len(hook.Events) != 2 || !strings.HasPrefix(hook.Secret, "whsec_") {
+				t.Errorf("Unexpected webhook: %+v", hook)
+			}
+			if !strings.Contains(rr.Body.String(), hook.Secret) {
+				t.Errorf("Expected the new secret to be shown once")
+			}
+		})
+	}
+
+	// A given secret is kept
+	r, ms := setupTest(t)
+	serve(r, models.RoleAdmin, "POST", "/settings/webhooks",
+		url.Values{"name": {"Sheet"}, "url": {"https://sheet.example"}, "events": {"stock.low"}, "secret": {"mine"}})
+	if ms.Hooks[2].Secret != "mine" {
+		t.Errorf("Expected the given secret, got %q", ms.Hooks[2].Secret)
+	}
+}
+
+func TestHandleUpdateWebhook(t *testing.T) {
+	r, ms := setupTest(t)
+
+	rr := serve(r, models.RoleAdmin, "PUT", "/settings/webhooks/1",
+		url.Values{"name": {"Sheet"}, "url": {"https://example.com/v2"}, "events": {"stock.changed", "stock.low"}})
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `id="webhook-1"`) {
+		t.Fatalf("Expected the updated row, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if hook := ms.Hooks[1]; hook.Name != "Sheet" || hook.URL != "https://example.com/v2" || len(hook.Events) != 2 || hook.Secret != "s3cret" {
+		t.Errorf("Unexpected webhook: %+v", hook)
+	}
+
+	if rr := serve(r, models.RoleAdmin, "PUT", "/settings/webhooks/7", url.Values{"name": {"x"}}); rr.Code != http.StatusNotFound {
+		t.Errorf("Missing webhook: got status %d, want 404", rr.Code)
+	}
+}
+
+func TestHandleToggleAndPingWebhook(t *testing.T) {
+	r, ms := setupTest(t)
+
+	rr := serve(r, models.RoleAdmin, "POST", "/settings/webhooks/1/toggle", nil)
+	if rr.Code != http.StatusOK || ms.Hooks[1].Enabled || !strings.Contains(rr.Body.String(), "Enable") {
+		t.Errorf("Expected the webhook to be disabled, got %d %+v", rr.Code, ms.Hooks[1])
+	}
+	serve(r, models.RoleAdmin, "POST", "/settings/webhooks/1/toggle", nil)
+	if !ms.Hooks[1].Enabled {
+		t.Errorf("Expected the webhook to be enabled again")
+	}
+
+	rr = serve(r, models.RoleAdmin, "POST", "/settings/webhooks/1/ping", nil)
+	if rr.Code != http.StatusOK || len(ms.Pinged) != 1 || ms.Pinged[0] != 1 {
+		t.Errorf("Expected a ping to be queued, got %d %v", rr.Code, ms.Pinged)
+	}
+	if rr := serve(r, models.RoleAdmin, "POST", "/settings/webhooks/5/ping", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Missing webhook: got status %d, want 404", rr.Code)
+	}
+}
+
+func TestHandleDeleteWebhook(t *testing.T) {
+	r, ms := setupTest(t)
+
+	if rr := serve(r, models.RoleAdmin, "DELETE", "/settings/webhooks/1", nil); rr.Code != http.StatusOK || len(ms.Hooks) != 0 {
+		t.Errorf("Expected the webhook to be deleted, got %d", rr.Code)
+	}
+	if rr := serve(r, models.RoleAdmin, "DELETE", "/settings/webhooks/abc", nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("Invalid ID: got status %d, want 400", rr.Code)
+	}
+}
+
+func TestHandleRetryDelivery(t *testing.T) {
+	r, ms := setupTest(t)
+
+	rr := serve(r, models.RoleAdmin, "POST", "/settings/webhooks/deliveries/9/retry", nil)
+	if rr.Code != http.StatusOK || len(ms.Retried) != 1 || ms.Retried[0] != 9 {
+		t.Errorf("Expected the delivery to be retried, got %d %v", rr.Code, ms.Retried)
+	}
+	if !strings.Contains(rr.Body.String(), "No deliveries yet.") {
+		t.Errorf("Expected the delivery log to be re-rendered")
+	}
+}
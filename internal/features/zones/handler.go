@@ -0,0 +1,193 @@
+package zones
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"wledger/internal/core"
+	"wledger/internal/models"
+	"wledger/internal/store"
+)
+
+// Store defines the database methods this module needs
+type Store interface {
+	GetZones(ctx context.Context) ([]models.Zone, error)
+	GetZoneByID(ctx context.Context, id int) (models.Zone, error)
+	CreateZone(ctx context.Context, z *models.Zone) error
+	UpdateZone(ctx context.Context, z *models.Zone) error
+	DeleteZone(ctx context.Context, id int) error
+	GetZoneControllerIPs(ctx context.Context, zoneID int) ([]string, error)
+}
+
+// WLEDClient defines the hardware communication methods
+type WLEDClient interface {
+	SendCommand(ipAddress string, state models.WLEDState) error
+}
+
+type Handler struct {
+	store     Store
+	wled      WLEDClient
+	templates core.TemplateExecutor
+}
+
+func New(s Store, w WLEDClient, t core.TemplateExecutor) *Handler {
+	return &Handler{store: s, wled: w, templates: t}
+}
+
+func (h *Handler) RegisterRoutes(r chi.Router) {
+	r.Group(func(r chi.Router) {
+		r.Use(core.Require(models.RoleAdmin))
+		r.Post("/settings/zones", h.handleCreateZone)
+		r.Put("/settings/zones/{id}", h.handleUpdateZone)
+		r.Delete("/settings/zones/{id}", h.handleDeleteZone)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(core.Require(models.RoleViewer))
+		r.Post("/zones/{id}/brightness", h.handleSetBrightness)
+		r.Get("/zones/options", h.handleGetZoneOptions)
+		r.Post("/zones/select", h.handleSelectZone)
+	})
+}
+
+// Handlers
+
+func (h *Handler) handleCreateZone(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		core.ClientError(w, r, http.StatusBadRequest, "Bad Request", err)
+		return
+	}
+
+	zone := &models.Zone{Name: strings.TrimSpace(r.FormValue("name"))}
+	if zone.Name == "" {
+		core.ClientError(w, r, http.StatusBadRequest, "Zone name is required", nil)
+		return
+	}
+
+	if err := h.store.CreateZone(r.Context(), zone); err != nil {
+		if errors.Is(err, store.ErrUniqueConstraint) {
+			core.ClientError(w, r, http.StatusConflict, "A zone with this name already exists.", err)
+		} else {
+			core.ServerError(w, r, err)
+		}
+		return
+	}
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+func (h *Handler) handleUpdateZone(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	if err := r.ParseForm(); err != nil {
+		core.ClientError(w, r, http.StatusBadRequest, "Bad Request", err)
+		return
+	}
+
+	zone, err := h.store.GetZoneByID(r.Context(), id)
+	if err != nil {
+		core.ClientError(w, r, http.StatusNotFound, "Zone not found", err)
+		return
+	}
+	zone.Name = strings.TrimSpace(r.FormValue("name"))
+	if zone.Name == "" {
+		core.ClientError(w, r, http.StatusBadRequest, "Zone name is required", nil)
+		return
+	}
+
+	if err := h.store.UpdateZone(r.Context(), &zone); err != nil {
+		if errors.Is(err, store.ErrUniqueConstraint) {
+			core.ClientError(w, r, http.StatusConflict, "A zone with this name already exists.", err)
+		} else {
+			core.ServerError(w, r, err)
+		}
+		return
+	}
+	h.templates.ExecuteTemplate(w, "_zone-row.html", zone)
+}
+
+func (h *Handler) handleDeleteZone(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	if id == 0 {
+		core.ClientError(w, r, http.StatusBadRequest, "Invalid ID", nil)
+		return
+	}
+	if err := h.store.DeleteZone(r.Context(), id); err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleSetBrightness sets the master brightness of every controller in the zone
+func (h *Handler) handleSetBrightness(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	brightness, err := strconv.Atoi(r.FormValue("bri"))
+	if err != nil || brightness < 1 || brightness > 255 {
+		core.ClientError(w, r, http.StatusBadRequest, "Brightness must be between 1 and 255", err)
+		return
+	}
+
+	ips, err := h.store.GetZoneControllerIPs(r.Context(), id)
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+
+	updated := 0
+	for _, ip := range ips {
+		if err := h.wled.SendCommand(ip, models.WLEDState{Brightness: &brightness}); err != nil {
+			log.Printf("Zones: Failed to set brightness on %s: %v", ip, err)
+			continue
+		}
+		updated++
+	}
+	fmt.Fprintf(w, "Brightness set on %d of %d controllers.", updated, len(ips))
+}
+
+// handleGetZoneOptions renders the <option>s of a zone select.
+// "selected" picks an option, "session=1" picks the browser's zone instead
+// and "empty" labels the no-zone option.
+func (h *Handler) handleGetZoneOptions(w http.ResponseWriter, r *http.Request) {
+	zones, err := h.store.GetZones(r.Context())
+	if err != nil {
+		core.ServerError(w, r, err)
+		return
+	}
+
+	selected, _ := strconv.Atoi(r.URL.Query().Get("selected"))
+	if r.URL.Query().Get("session") == "1" {
+		selected = core.SessionZone(r)
+	}
+	empty := r.URL.Query().Get("empty")
+	if empty == "" {
+		empty = "No zone"
+	}
+
+	data := map[string]interface{}{
+		"Zones":    zones,
+		"Selected": selected,
+		"Empty":    empty,
+	}
+	h.templates.ExecuteTemplate(w, "_zone-options.html", data)
+}
+
+// handleSelectZone sets the zone this browser works in
+func (h *Handler) handleSelectZone(w http.ResponseWriter, r *http.Request) {
+	zoneID, _ := strconv.Atoi(r.FormValue("zone_id"))
+	if zoneID != 0 {
+		if _, err := h.store.GetZoneByID(r.Context(), zoneID); err != nil {
+			core.ClientError(w, r, http.StatusNotFound, "Zone not found", err)
+			return
+		}
+	}
+
+	core.SetSessionZone(w, zoneID)
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+}
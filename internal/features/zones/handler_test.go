@@ -0,0 +1,240 @@
+package zones
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"wledger/internal/core"
+	"wledger/internal/models"
+	"wledger/internal/store"
+)
+
+// Local mocks
+type mockStore struct {
+	FailOps bool
+
+	Zones   map[int]models.Zone
+	Created *models.Zone
+}
+
+func (m *mockStore) GetZones(ctx context.Context) ([]models.Zone, error) {
+	var zones []models.Zone
+	for i := 1; i <= len(m.Zones); i++ {
+		zones = append(zones, m.Zones[i])
+	}
+	return zones, nil
+}
+func (m *mockStore) GetZoneByID(ctx context.Context, id int) (models.Zone, error) {
+	z, ok := m.Zones[id]
+	if !ok {
+		return z, errors.New("not found")
+	}
+	return z, nil
+}
+func (m *mockStore) CreateZone(ctx context.Context, z *models.Zone) error {
+	if z.Name == "Duplicate" {
+		return store.ErrUniqueConstraint
+	}
+	if m.FailOps {
+		return errors.New("db error")
+	}
+	m.Created = z
+	return nil
+}
+func (m *mockStore) UpdateZone(ctx context.Context, z *models.Zone) error {
+	if m.FailOps {
+		return errors.New("db error")
+	}
+	m.Zones[z.ID] = *z
+	return nil
+}
+func (m *mockStore) DeleteZone(ctx context.Context, id int) error {
+	if m.FailOps {
+		return errors.New("db error")
+	}
+	delete(m.Zones, id)
+	return nil
+}
+func (m *mockStore) GetZoneControllerIPs(ctx context.Context, zoneID int) ([]string, error) {
+	if zoneID == 1 {
+		return []string{"10.0.0.1", "10.0.0.2"}, nil
+	}
+	return nil, nil
+}
+
+type mockWLED struct {
+	Sent map[string]models.WLEDState
+}
+
+func (m *mockWLED) SendCommand(ip string, state models.WLEDState) error {
+	if ip == "10.0.0.2" {
+		return errors.New("unreachable")
+	}
+	m.Sent[ip] = state
+	return nil
+}
+
+// Test Setup Helper
+func setupTest(t *testing.T) (*Handler, *mockStore, *mockWLED) {
+	t.Helper()
+	ms := &mockStore{Zones: map[int]models.Zone{
+		1: {ID: 1, Name: "Workshop", ControllerCount: 2},
+		2: {ID: 2, Name: "Garage"},
+	}}
+	mw := &mockWLED{Sent: map[string]models.WLEDState{}}
+	tmpl, err := template.ParseGlob("../../../ui/templates/*.html")
+	if err != nil {
+		t.Fatalf("Failed to parse templates: %v", err)
+	}
+	return New(ms, mw, tmpl), ms, mw
+}
+
+// serve routes a request signed in as an admin
+func serve(h *Handler, req *http.Request) *httptest.ResponseRecorder {
+	return serveAs(h, models.RoleAdmin, req)
+}
+
+func serveAs(h *Handler, role models.Role, req *http.Request) *httptest.ResponseRecorder {
+	r := chi.NewRouter()
+	h.RegisterRoutes(r)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req.WithContext(core.WithUser(req.Context(), models.User{Username: "alice", Role: role})))
+	return rr
+}
+
+func formRequest(method, target string, form url.Values) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestHandleCreateZone(t *testing.T) {
+	h, ms, _ := setupTest(t)
+
+	rr := serve(h, formRequest("POST", "/settings/zones", url.Values{"name": {" Lab "}}))
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected 303, got %d", rr.Code)
+	}
+	if ms.Created == nil || ms.Created.Name != "Lab" {
+		t.Errorf("Zone not created correctly: %+v", ms.Created)
+	}
+
+	rr = serve(h, formRequest("POST", "/settings/zones", url.Values{"name": {"Duplicate"}}))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a duplicate, got %d", rr.Code)
+	}
+
+	rr = serve(h, formRequest("POST", "/settings/zones", url.Values{"name": {"  "}}))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an empty name, got %d", rr.Code)
+	}
+}
+
+func TestHandleUpdateZone(t *testing.T) {
+	h, ms, _ := setupTest(t)
+
+	rr := serve(h, formRequest("PUT", "/settings/zones/2", url.Values{"name": {"Basement"}}))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rr.Code)
+	}
+	if ms.Zones[2].Name != "Basement" {
+		t.Errorf("Zone not renamed: %+v", ms.Zones[2])
+	}
+	if !strings.Contains(rr.Body.String(), `id="zone-2"`) {
+		t.Errorf("Expected the zone row to be rendered")
+	}
+
+	rr = serve(h, formRequest("PUT", "/settings/zones/9", url.Values{"name": {"X"}}))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", rr.Code)
+	}
+}
+
+func TestHandleDeleteZone(t *testing.T) {
+	h, ms, _ := setupTest(t)
+
+	rr := serve(h, httptest.NewRequest("DELETE", "/settings/zones/2", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rr.Code)
+	}
+	if _, ok := ms.Zones[2]; ok {
+		t.Errorf("Zone was not deleted")
+	}
+}
+
+func TestHandleSetBrightness(t *testing.T) {
+	h, _, mw := setupTest(t)
+
+	rr := serve(h, formRequest("POST", "/zones/1/brightness", url.Values{"bri": {"64"}}))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rr.Code)
+	}
+	st, ok := mw.Sent["10.0.0.1"]
+	if !ok || st.Brightness == nil || *st.Brightness != 64 {
+		t.Errorf("Expected brightness 64 to be sent, got %+v", st)
+	}
+	if rr.Body.String() != "Brightness set on 1 of 2 controllers." {
+		t.Errorf("Unexpected response: %q", rr.Body.String())
+	}
+
+	rr = serve(h, formRequest("POST", "/zones/1/brightness", url.Values{"bri": {"0"}}))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for brightness 0, got %d", rr.Code)
+	}
+}
+
+func TestHandleGetZoneOptions(t *testing.T) {
+	h, _, _ := setupTest(t)
+
+	req := httptest.NewRequest("GET", "/zones/options?session=1&empty=All+zones", nil)
+	req.AddCookie(&http.Cookie{Name: core.ZoneCookie, Value: "2"})
+	rr := serve(h, req)
+
+	body := rr.Body.String()
+	if !strings.Contains(body, "All zones") {
+		t.Errorf("Expected the empty label, got %s", body)
+	}
+	if !strings.Contains(body, `<option value="2" selected>Garage</option>`) {
+		t.Errorf("Expected the session zone to be selected, got %s", body)
+	}
+}
+
+func TestHandleSelectZone(t *testing.T) {
+	h, _, _ := setupTest(t)
+
+	rr := serve(h, formRequest("POST", "/zones/select", url.Values{"zone_id": {"1"}}))
+	if rr.Code != http.StatusOK || rr.Header().Get("HX-Refresh") != "true" {
+		t.Fatalf("Expected 200 with HX-Refresh, got %d", rr.Code)
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != core.ZoneCookie || cookies[0].Value != "1" {
+		t.Errorf("Expected the zone cookie to be set, got %+v", cookies)
+	}
+
+	rr = serve(h, formRequest("POST", "/zones/select", url.Values{"zone_id": {"9"}}))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown zone, got %d", rr.Code)
+	}
+}
+
+func TestRoutes_Roles(t *testing.T) {
+	h, ms, _ := setupTest(t)
+
+	// Viewers pick a zone to work in, but only admins change zones
+	rr := serveAs(h, models.RoleViewer, formRequest("POST", "/zones/select", url.Values{"zone_id": {"1"}}))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Viewer selecting a zone: got %d, want 200", rr.Code)
+	}
+	rr = serveAs(h, models.RoleEditor, formRequest("POST", "/settings/zones", url.Values{"name": {"Attic"}}))
+	if rr.Code != http.StatusForbidden || len(ms.Zones) != 2 {
+		t.Errorf("Editor creating a zone: got %d, want 403", rr.Code)
+	}
+}
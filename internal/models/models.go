@@ -25,26 +25,107 @@ type Part struct {
 	MinStock      int
 }
 
+// WLEDEndpoint is where a controller's JSON API is reached
+type WLEDEndpoint struct {
+	Scheme   string // "http" or "https"
+	Host     string // IPv4 address, IPv6 address without brackets, or hostname
+	Port     int    // 0 for the scheme's default port
+	BasePath string // Path prefix behind a reverse proxy, e.g. "/wled/a"
+	Username string // Basic auth, optional
+	Password string
+}
+
 // WLEDController struct to hold WLED controller data
 type WLEDController struct {
-	ID        int
-	Name      string
-	IPAddress string
-	Status    string
-	LastSeen  sql.NullTime
-	BinCount  int
+	ID           int
+	Name         string
+	IPAddress    string // The endpoint's address without credentials, see endpoint.Address
+	Endpoint     WLEDEndpoint
+	Status       string
+	LastSeen     sql.NullTime
+	BinCount     int
+	RestoreState bool // Snapshot the controller's own state before lighting bins and restore it afterwards
+	ZoneID       int  // 0 if the controller isn't in a zone
+	ZoneName     sql.NullString
+}
+
+// HealthProbe is the result of one controller health check
+type HealthProbe struct {
+	ID           int
+	ControllerID int
+	CheckedAt    time.Time
+	Online       bool
+	Latency      time.Duration
+	Error        string // Why the probe failed, empty when online
+}
+
+// HealthSlot counts the health probes in one slice of the availability timeline
+type HealthSlot struct {
+	Start  time.Time
+	Probes int
+	Failed int
+}
+
+// ControllerHealth summarizes a controller's health probes over a time window
+type ControllerHealth struct {
+	ControllerID   int
+	ControllerName string
+	Probes         int
+	Uptime         float64 // Percentage of successful probes
+	AvgLatency     time.Duration
+	Timeline       []HealthSlot
+	LastError      string // Most recent failure within the retention window
+	LastErrorAt    sql.NullTime
+}
+
+// Zone groups controllers and bins, e.g. "Electronics wall"
+type Zone struct {
+	ID              int
+	Name            string
+	ControllerCount int
+	BinCount        int // Bins in the zone, directly or through their controller
 }
 
 // Bin struct (a single LED)
 type Bin struct {
 	ID                 int
 	Name               string
-	WLEDControllerID   int
+	WLEDControllerID   int // 0 if the bin is detached
 	WLEDSegmentID      int
 	LEDIndex           int
+	Capacity           int            // How many items fit in the bin, 0 if unknown
+	ZoneID             int            // The bin's own zone, 0 to use its controller's zone
+	ZoneName           sql.NullString // The zone the bin is in, its own or its controller's
 	WLEDControllerName sql.NullString
-	HasOverlap         bool
-	IsOrphaned         bool
+	SharedLED          bool // Deliberately shares its LED with other bins, not an overlap
+	HasOverlap         bool // Shares its LED with a bin that isn't marked shared
+	IsOrphaned         bool // Assigned to a controller that no longer exists
+	IsDetached         bool // Deliberately not on any controller, so never lit
+}
+
+// LEDConflict describes the bins already on an LED, for resolving an overlap
+type LEDConflict struct {
+	ControllerID int
+	SegmentID    int
+	LEDIndex     int
+	Bins         []string // The other bins on the LED
+	Shared       bool     // All of them are marked shared, so a shared bin may join
+	NextFree     int      // The next LED index on the segment without a bin
+}
+
+// What happens to a controller's bins when it's deleted
+const (
+	BinsMove   = "move"   // Move them to another controller
+	BinsDetach = "detach" // Keep them and their stock, but stop lighting them
+	BinsDelete = "delete" // Delete them along with their stock
+)
+
+// BinDisposal is the choice made in the controller deletion wizard
+type BinDisposal struct {
+	Action             string // BinsMove, BinsDetach or BinsDelete
+	TargetControllerID int    // For BinsMove
+	SegmentOffset      int    // Added to the moved bins' segment IDs
+	LEDOffset          int    // Added to the moved bins' LED indexes
 }
 
 // PartLocation holds detailed info about a single part's inventory
@@ -57,11 +138,16 @@ type PartLocation struct {
 	SegmentID    int
 	LEDIndex     int
 	ControllerID int
+	ReorderPoint sql.NullInt64 // Overrides the part's reorder point for this bin
+	MinStock     sql.NullInt64 // Overrides the part's min stock for this bin
+	Capacity     sql.NullInt64 // How many of this part fit in the bin, overrides the bin's capacity
 }
 
 // WLEDState represents the state to send to WLED
 type WLEDState struct {
-	Segments []WLEDSegment `json:"seg"`
+	On         *bool         `json:"on,omitempty"`  // Master power, nil leaves it unchanged
+	Brightness *int          `json:"bri,omitempty"` // Master brightness 1-255, nil leaves it unchanged
+	Segments   []WLEDSegment `json:"seg,omitempty"`
 }
 
 // WLEDSegment defines a single segment's (LED Strip) state
@@ -98,26 +184,179 @@ type Category struct {
 
 // DashboardBinData holds aggregated data for dashboard display
 type DashboardBinData struct {
-	ReorderPoint int
-	MinStock     int
-	BinQuantity  int
-	BinIP        string
-	BinSegmentID int
-	BinLEDIndex  int
+	PartID               int
+	PartName             string
+	PartNumber           sql.NullString
+	Manufacturer         sql.NullString
+	Supplier             sql.NullString
+	Status               sql.NullString
+	Categories           []string
+	UpdatedAt            time.Time
+	ReorderPoint         int
+	MinStock             int
+	PartQuantity         int // Total quantity of the part across all bins
+	BinQuantity          int
+	LocationReorderPoint sql.NullInt64
+	LocationMinStock     sql.NullInt64
+	BinCapacity          int
+	LocationCapacity     sql.NullInt64
+	BinIP                string
+	BinSegmentID         int
+	BinLEDIndex          int
+}
+
+// PartFilter selects a page of parts. Empty fields don't filter.
+type PartFilter struct {
+	Search        string // Found in the name, description, part number or a category
+	Manufacturer  string
+	Supplier      string
+	Status        string
+	StockTracking sql.NullBool
+	Limit         int // 0 returns every match
+	Offset        int
+}
+
+// BinFilter selects a page of bins. Empty fields don't filter.
+type BinFilter struct {
+	Search       string // Found in the name
+	ControllerID int
+	ZoneID       int // The bin's own zone, not its controller's
+	Limit        int // 0 returns every match
+	Offset       int
+}
+
+// ControllerFilter selects a page of controllers. Empty fields don't filter.
+type ControllerFilter struct {
+	Search string // Found in the name or address
+	Status string
+	ZoneID int
+	Limit  int // 0 returns every match
+	Offset int
+}
+
+// CategoryFilter selects a page of categories. Empty fields don't filter.
+type CategoryFilter struct {
+	Search string // Found in the name
+	PartID int    // Only the part's categories
+	Limit  int    // 0 returns every match
+	Offset int
+}
+
+// StockStatusFilter limits which bins a stock status view lights up.
+// Empty fields don't filter.
+type StockStatusFilter struct {
+	Category     string
+	Supplier     string
+	Manufacturer string
+	ControllerID int
+	Location     string // Bin name prefix, e.g. "B" for everything in cabinet B
+	ZoneID       int
+}
+
+// StockStatusPreset is a saved, named stock status view
+type StockStatusPreset struct {
+	ID     int
+	Name   string
+	Level  string // "all", "attention" or "critical"
+	Mode   string // Stock evaluation mode, see the dashboard package
+	Filter StockStatusFilter
+}
+
+// StockStatusResult summarizes what a stock status view lit up
+type StockStatusResult struct {
+	BinsLit       int
+	Notifications int      // Bins a notification rule matches, see the background service
+	NoCapacity    int      // Bins skipped by a fill level view because they have no capacity
+	BinsOffline   int      // Bins not lit because their controller turned the command down
+	BinsQueued    int      // Bins of offline controllers, lit once the controller is back
+	Offline       []string // Names of the offline controllers
+}
+
+// LightingSchedule runs a lighting action on a cron schedule
+type LightingSchedule struct {
+	ID         int
+	Name       string
+	Cron       string // 5-field cron expression, in server local time
+	Action     string // "stock_status", "ambient", "quiet_start" or "quiet_end"
+	PresetID   int    // Stock status preset to show, 0 shows everything. Not a foreign key
+	Color      string // Ambient color, hex RGB
+	Brightness int    // 0-255. Dims the ambient color, or the master brightness for quiet hours (0 is off)
+	Enabled    bool
+}
+
+// ScheduleRun is a log entry for one run of a lighting schedule
+type ScheduleRun struct {
+	ID           int
+	ScheduleID   sql.NullInt64 // NULL once the schedule is deleted
+	ScheduleName string
+	Action       string
+	StartedAt    time.Time
+	Duration     time.Duration
+	Status       string // "ok", "skipped" or "error"
+	Message      string
+}
+
+// JobRun is one run of a background job
+type JobRun struct {
+	ID        int
+	JobName   string
+	Trigger   string // "schedule" or "manual"
+	StartedAt time.Time
+	Duration  time.Duration
+	Status    string // "ok" or "error"
+	Error     string
+}
+
+// JobStatus describes a registered background job
+type JobStatus struct {
+	Name        string
+	Description string
+	Schedule    string // e.g. "every 1m" or a cron expression
+	Running     bool
+	NextRun     time.Time // Zero if it never runs again
+	LastRun     JobRun    // Zero if it never ran
+}
+
+// StockRule decides how a part's stock level is shown or reported.
+// Rules are evaluated in ascending priority order and the first match wins.
+type StockRule struct {
+	ID         int
+	Name       string
+	Priority   int
+	Enabled    bool
+	Conditions []StockRuleCondition // All must match, an empty list always matches
+	Severity   string               // "critical", "attention" or "ok"
+	Action     string               // "color", "effect" or "notify"
+	Color      string               // Hex RGB, e.g. "FF0000"
+	Effect     int                  // WLED effect ID, used by the "effect" action
+	Message    string               // Used by the "notify" action
+}
+
+// StockRuleCondition compares a part field against a value.
+// Numeric values may name another numeric field, e.g. "quantity lte min_stock".
+type StockRuleCondition struct {
+	Field    string `json:"field"`
+	Operator string `json:"op"`
+	Value    string `json:"value"`
 }
 
 // BackupData represents the complete state of the database
 type BackupData struct {
-	Version       int              `json:"version"`
-	GeneratedAt   time.Time        `json:"generated_at"`
-	Parts         []Part           `json:"parts"`
-	PartUrls      []PartURL        `json:"part_urls"`
-	PartDocs      []PartDocument   `json:"part_documents"`
-	Categories    []Category       `json:"categories"`
-	PartCats      []PartCategory   `json:"part_categories"` // struct for the join table
-	Controllers   []WLEDController `json:"controllers"`
-	Bins          []Bin            `json:"bins"`
-	PartLocations []PartLocation   `json:"part_locations"`
+	Version       int                 `json:"version"`
+	SchemaVersion int                 `json:"schema_version"` // 0 in backups from before migrations were versioned
+	GeneratedAt   time.Time           `json:"generated_at"`
+	Parts         []Part              `json:"parts"`
+	PartUrls      []PartURL           `json:"part_urls"`
+	PartDocs      []PartDocument      `json:"part_documents"`
+	Categories    []Category          `json:"categories"`
+	PartCats      []PartCategory      `json:"part_categories"` // struct for the join table
+	Controllers   []WLEDController    `json:"controllers"`
+	Bins          []Bin               `json:"bins"`
+	PartLocations []PartLocation      `json:"part_locations"`
+	StockRules    []StockRule         `json:"stock_rules,omitempty"`
+	Presets       []StockStatusPreset `json:"stock_status_presets,omitempty"`
+	Schedules     []LightingSchedule  `json:"lighting_schedules,omitempty"`
+	Zones         []Zone              `json:"zones,omitempty"`
 }
 
 // Needed for the join table as part of the backup and restore process
@@ -125,3 +364,152 @@ type PartCategory struct {
 	PartID     int `json:"part_id"`
 	CategoryID int `json:"category_id"`
 }
+
+// Role is what a user may do. Each role can do everything the roles
+// before it can.
+type Role string
+
+const (
+	RoleViewer Role = "viewer" // Search parts and light up their bins
+	RoleEditor Role = "editor" // Change parts and stock
+	RoleAdmin  Role = "admin"  // Manage controllers, settings, users and backups
+)
+
+// Roles lists the roles from least to most allowed
+var Roles = []Role{RoleViewer, RoleEditor, RoleAdmin}
+
+// User is a local account that signs in to WLEDger
+type User struct {
+	ID           int
+	Username     string
+	PasswordHash string // bcrypt
+	Role         Role
+	CreatedAt    time.Time
+}
+
+// Scope is what an API token may be used for. A token can never do more
+// than its user's role allows.
+type Scope string
+
+const (
+	ScopeRead       Scope = "read"        // The pages and data viewers can see
+	ScopeLocate     Scope = "locate"      // Lighting bins: locate, stop, stock status, brightness
+	ScopeStockWrite Scope = "stock-write" // Changing parts and stock
+	ScopeAdmin      Scope = "admin"       // Everything only admins can do
+)
+
+// Scopes lists every scope
+var Scopes = []Scope{ScopeRead, ScopeLocate, ScopeStockWrite, ScopeAdmin}
+
+// APIToken lets scripts call WLEDger as one of its users, with an
+// Authorization: Bearer header
+type APIToken struct {
+	ID         int
+	UserID     int
+	Username   string
+	Name       string // What it's for, e.g. "Label printer"
+	Prefix     string // The start of the token, to recognize it by
+	Scopes     []Scope
+	ExpiresAt  time.Time
+	LastUsedAt sql.NullTime
+	CreatedAt  time.Time
+}
+
+// APITokenEvent is an entry in the API token audit log
+type APITokenEvent struct {
+	ID        int
+	TokenID   int // Not a foreign key, events outlive their token
+	TokenName string
+	UserID    int
+	Username  string
+	At        time.Time
+	Event     string // "created", "revoked", "used" or "rejected"
+	Detail    string // e.g. "POST /locate/part/3: 200"
+}
+
+// WebhookEvent is something that happens in WLEDger that a webhook can
+// be sent for
+type WebhookEvent string
+
+const (
+	EventPartCreated       WebhookEvent = "part.created"
+	EventPartUpdated       WebhookEvent = "part.updated"
+	EventPartDeleted       WebhookEvent = "part.deleted"
+	EventStockChanged      WebhookEvent = "stock.changed"      // A location's quantity changed
+	EventStockLow          WebhookEvent = "stock.low"          // A part's stock fell to its reorder point
+	EventStockRuleMatched  WebhookEvent = "stock.rule_matched" // A notification stock rule started matching a part
+	EventControllerOffline WebhookEvent = "controller.offline" // A health check found a controller offline
+	EventPing              WebhookEvent = "ping"               // Sent by hand to test a webhook, always delivered
+)
+
+// WebhookEvents lists the events webhooks can subscribe to
+var WebhookEvents = []WebhookEvent{
+	EventPartCreated, EventPartUpdated, EventPartDeleted,
+	EventStockChanged, EventStockLow, EventStockRuleMatched, EventControllerOffline,
+}
+
+// Webhook POSTs the events it subscribes to to a URL, signed with its
+// secret
+type Webhook struct {
+	ID        int
+	Name      string
+	URL       string
+	Secret    string // Signs the deliveries, see webhook.Sign
+	Events    []WebhookEvent
+	Enabled   bool
+	CreatedAt time.Time
+}
+
+// WebhookDelivery is one event queued for, or sent to, a webhook
+type WebhookDelivery struct {
+	ID            int
+	WebhookID     int
+	WebhookName   string
+	Event         WebhookEvent
+	Payload       string // The JSON body, signed as is
+	Status        string // "pending", "ok" or "failed"
+	Attempts      int
+	NextAttemptAt time.Time
+	LastAttemptAt sql.NullTime
+	ResponseCode  int // 0 if no response was received
+	Error         string
+	CreatedAt     time.Time
+}
+
+// PartEventData is the data of the part.* webhook events
+type PartEventData struct {
+	PartID     int    `json:"part_id"`
+	Name       string `json:"name"`
+	PartNumber string `json:"part_number,omitempty"`
+}
+
+// StockEventData is the data of the stock.* webhook events
+type StockEventData struct {
+	PartID        int    `json:"part_id"`
+	PartName      string `json:"part_name"`
+	LocationID    int    `json:"location_id"`
+	BinID         int    `json:"bin_id"`
+	BinName       string `json:"bin_name"`
+	OldQuantity   int    `json:"old_quantity"`
+	Quantity      int    `json:"quantity"`
+	TotalQuantity int    `json:"total_quantity"` // Across all of the part's locations
+	ReorderPoint  int    `json:"reorder_point"`
+}
+
+// RuleEventData is the data of the stock.rule_matched webhook event
+type RuleEventData struct {
+	RuleID        int    `json:"rule_id"`
+	RuleName      string `json:"rule_name"`
+	Message       string `json:"message"`
+	PartID        int    `json:"part_id"`
+	PartName      string `json:"part_name"`
+	TotalQuantity int    `json:"total_quantity"`
+}
+
+// ControllerEventData is the data of the controller.* webhook events
+type ControllerEventData struct {
+	ControllerID int    `json:"controller_id"`
+	Name         string `json:"name"`
+	Address      string `json:"address"`
+	Error        string `json:"error,omitempty"`
+}
@@ -0,0 +1,261 @@
+// Package scheduler runs named background jobs on an interval or a cron
+// schedule. A job never overlaps itself, every run is recorded, and jobs
+// can be run on demand.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"wledger/internal/cron"
+	"wledger/internal/models"
+)
+
+// Run triggers
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// Run statuses
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+)
+
+var ErrUnknownJob = errors.New("no such job")
+var ErrJobRunning = errors.New("job is already running")
+var ErrStopped = errors.New("scheduler is stopped")
+
+// tickInterval is how often the scheduler checks for due jobs
+const tickInterval = time.Second
+
+// Store records the job runs
+type Store interface {
+	RecordJobRun(ctx context.Context, run *models.JobRun) error
+}
+
+// Job is a named piece of background work. It runs every Interval,
+// starting when the scheduler starts, or at the minutes matching Cron.
+type Job struct {
+	Name        string
+	Description string
+	Interval    time.Duration
+	Cron        string
+	Run         func(ctx context.Context) error
+}
+
+// entry is a registered job and its state
+type entry struct {
+	job     Job
+	spec    *cron.Schedule // nil for interval jobs
+	next    time.Time      // Zero for interval jobs that haven't run yet
+	running bool
+}
+
+type Scheduler struct {
+	store Store
+
+	mu      sync.Mutex
+	jobs    []*entry
+	ctx     context.Context // Passed to the runs, cancelled on shutdown
+	stopped bool
+	runs    sync.WaitGroup
+}
+
+func New(s Store) *Scheduler {
+	return &Scheduler{store: s, ctx: context.Background()}
+}
+
+// Register adds a job. Names must be unique.
+func (s *Scheduler) Register(j Job) error {
+	if j.Name == "" || j.Run == nil {
+		return errors.New("job needs a name and a run function")
+	}
+	e := &entry{job: j}
+	switch {
+	case j.Cron != "" && j.Interval != 0:
+		return fmt.Errorf("job %s: set either an interval or a cron expression", j.Name)
+	case j.Cron != "":
+		spec, err := cron.Parse(j.Cron)
+		if err != nil {
+			return fmt.Errorf("job %s: %w", j.Name, err)
+		}
+		e.spec = spec
+		e.next = spec.Next(time.Now())
+	case j.Interval <= 0:
+		return fmt.Errorf("job %s: interval must be positive", j.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.find(j.Name) != nil {
+		return fmt.Errorf("job %s is already registered", j.Name)
+	}
+	s.jobs = append(s.jobs, e)
+	return nil
+}
+
+// Start runs the due jobs until ctx is cancelled, then waits for the
+// running jobs. The jobs get ctx, so they can stop early.
+func (s *Scheduler) Start(ctx context.Context) {
+	log.Println("Starting background jobs...")
+	s.mu.Lock()
+	s.ctx = ctx
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	s.tick(time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Stopping background jobs, waiting for running jobs...")
+			s.mu.Lock()
+			s.stopped = true
+			s.mu.Unlock()
+			s.runs.Wait()
+			log.Println("Background jobs stopped.")
+			return
+		case now := <-ticker.C:
+			s.tick(now)
+		}
+	}
+}
+
+// tick starts every job that's due at now. A job that's still running
+// skips its turn.
+func (s *Scheduler) tick(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.jobs {
+		if e.spec != nil && e.next.IsZero() {
+			continue // The cron expression never fires
+		}
+		if now.Before(e.next) {
+			continue
+		}
+		if e.spec != nil {
+			e.next = e.spec.Next(now)
+		} else {
+			e.next = now.Add(e.job.Interval)
+		}
+
+		if e.running {
+			log.Printf("Jobs: %s is still running, skipped this run.", e.job.Name)
+			continue
+		}
+		s.start(e, TriggerSchedule)
+	}
+}
+
+// RunNow starts a job right away, unless it's already running
+func (s *Scheduler) RunNow(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.find(name)
+	if e == nil {
+		return ErrUnknownJob
+	}
+	if e.running {
+		return ErrJobRunning
+	}
+	if s.stopped {
+		return ErrStopped
+	}
+	s.start(e, TriggerManual)
+	return nil
+}
+
+// start runs a job in the background and records the run. s.mu must be held.
+func (s *Scheduler) start(e *entry, trigger string) {
+	if s.stopped {
+		return
+	}
+	e.running = true
+	ctx := s.ctx
+
+	s.runs.Add(1)
+	go func() {
+		defer s.runs.Done()
+
+		run := &models.JobRun{JobName: e.job.Name, Trigger: trigger, StartedAt: time.Now()}
+		err := runJob(ctx, e.job)
+		run.Duration = time.Since(run.StartedAt)
+		run.Status = StatusOK
+		if err != nil {
+			run.Status = StatusError
+			run.Error = err.Error()
+			log.Printf("Jobs: %s failed: %v", e.job.Name, err)
+		}
+		// A run cut short by a shutdown is still recorded
+		if err := s.store.RecordJobRun(context.WithoutCancel(ctx), run); err != nil {
+			log.Printf("Jobs: Error recording a run of %s: %v", e.job.Name, err)
+		}
+
+		s.mu.Lock()
+		e.running = false
+		s.mu.Unlock()
+	}()
+}
+
+// runJob runs a job, turning a panic into an error so it's recorded
+func runJob(ctx context.Context, j Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return j.Run(ctx)
+}
+
+func (s *Scheduler) find(name string) *entry {
+	for _, e := range s.jobs {
+		if e.job.Name == name {
+			return e
+		}
+	}
+	return nil
+}
+
+// Jobs describes the registered jobs, in registration order
+func (s *Scheduler) Jobs() []models.JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]models.JobStatus, 0, len(s.jobs))
+	for _, e := range s.jobs {
+		status := models.JobStatus{
+			Name:        e.job.Name,
+			Description: e.job.Description,
+			Schedule:    e.job.Cron,
+			Running:     e.running,
+			NextRun:     e.next,
+		}
+		if e.spec == nil {
+			status.Schedule = "every " + formatInterval(e.job.Interval)
+		}
+		jobs = append(jobs, status)
+	}
+	return jobs
+}
+
+// formatInterval drops the zero units time.Duration prints, "6h0m0s" is "6h"
+func formatInterval(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
@@ -0,0 +1,203 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"wledger/internal/models"
+)
+
+// Local mock
+type mockStore struct {
+	mu   sync.Mutex
+	Runs []models.JobRun
+}
+
+func (m *mockStore) RecordJobRun(ctx context.Context, run *models.JobRun) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Runs = append(m.Runs, *run)
+	return nil
+}
+
+func (m *mockStore) runs() []models.JobRun {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]models.JobRun{}, m.Runs...)
+}
+
+// waitFor polls until done reports true
+func waitFor(t *testing.T, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// blockingJob runs until release is closed
+func blockingJob(name string, release chan struct{}) Job {
+	return Job{Name: name, Interval: time.Minute, Run: func(ctx context.Context) error {
+		<-release
+		return nil
+	}}
+}
+
+func TestScheduler_Register(t *testing.T) {
+	s := New(&mockStore{})
+	run := func(ctx context.Context) error { return nil }
+
+	if err := s.Register(Job{Name: "a", Interval: time.Minute, Run: run}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if err := s.Register(Job{Name: "b", Cron: "*/5 * * * *", Run: run}); err != nil {
+		t.Fatalf("Register (cron) failed: %v", err)
+	}
+
+	invalid := []Job{
+		{Name: "a", Interval: time.Minute, Run: run},                    // Duplicate
+		{Name: "c", Run: run},                                           // No schedule
+		{Name: "d", Cron: "every day", Run: run},                        // Bad cron
+		{Name: "e", Cron: "* * * * *", Interval: time.Minute, Run: run}, // Both
+		{Name: "f", Interval: time.Minute},                              // Nothing to run
+	}
+	for _, j := range invalid {
+		if err := s.Register(j); err == nil {
+			t.Errorf("Expected an error registering %+v", j)
+		}
+	}
+
+	jobs := s.Jobs()
+	if len(jobs) != 2 || jobs[0].Schedule != "every 1m" || jobs[1].Schedule != "*/5 * * * *" {
+		t.Errorf("Unexpected jobs: %+v", jobs)
+	}
+}
+
+func TestScheduler_Tick(t *testing.T) {
+	ms := &mockStore{}
+	s := New(ms)
+	release := make(chan struct{})
+	s.Register(blockingJob("slow", release))
+	s.Register(Job{Name: "failing", Interval: time.Minute, Run: func(ctx context.Context) error {
+		return errors.New("controller unreachable")
+	}})
+
+	now := time.Now()
+	s.tick(now)
+	waitFor(t, func() bool { return len(ms.runs()) == 1 && !s.Jobs()[1].Running })
+
+	// Due again, but the slow job's first run is still going
+	s.tick(now.Add(time.Minute))
+	close(release)
+	s.runs.Wait()
+
+	runs := ms.runs()
+	if len(runs) != 3 {
+		t.Fatalf("Expected 3 runs (slow once, failing twice), got %+v", runs)
+	}
+	counts := map[string]int{}
+	for _, run := range runs {
+		counts[run.JobName]++
+		if run.Trigger != TriggerSchedule {
+			t.Errorf("Expected a scheduled run, got %+v", run)
+		}
+		if run.JobName == "failing" && (run.Status != StatusError || run.Error != "controller unreachable") {
+			t.Errorf("Expected the error to be recorded, got %+v", run)
+		}
+	}
+	if counts["slow"] != 1 {
+		t.Errorf("Expected the slow job to skip its overlapping run, ran %d times", counts["slow"])
+	}
+
+	// Not due before its interval is up
+	s.tick(now.Add(90 * time.Second))
+	s.runs.Wait()
+	if len(ms.runs()) != 3 {
+		t.Errorf("Expected no runs before the interval is up")
+	}
+}
+
+func TestScheduler_RunNow(t *testing.T) {
+	ms := &mockStore{}
+	s := New(ms)
+	release := make(chan struct{})
+	s.Register(blockingJob("slow", release))
+
+	if err := s.RunNow("missing"); !errors.Is(err, ErrUnknownJob) {
+		t.Errorf("Expected ErrUnknownJob, got %v", err)
+	}
+	if err := s.RunNow("slow"); err != nil {
+		t.Fatalf("RunNow failed: %v", err)
+	}
+	if !s.Jobs()[0].Running {
+		t.Errorf("Expected the job to be running")
+	}
+	if err := s.RunNow("slow"); !errors.Is(err, ErrJobRunning) {
+		t.Errorf("Expected ErrJobRunning, got %v", err)
+	}
+
+	close(release)
+	s.runs.Wait()
+	if runs := ms.runs(); len(runs) != 1 || runs[0].Trigger != TriggerManual || runs[0].Status != StatusOK {
+		t.Errorf("Expected one manual run, got %+v", runs)
+	}
+}
+
+func TestScheduler_Panic(t *testing.T) {
+	ms := &mockStore{}
+	s := New(ms)
+	s.Register(Job{Name: "broken", Interval: time.Minute, Run: func(ctx context.Context) error {
+		panic("nil map")
+	}})
+
+	s.RunNow("broken")
+	s.runs.Wait()
+	if runs := ms.runs(); len(runs) != 1 || runs[0].Error != "panic: nil map" {
+		t.Errorf("Expected the panic to be recorded, got %+v", runs)
+	}
+	if s.Jobs()[0].Running {
+		t.Errorf("Expected the job to be idle after panicking")
+	}
+}
+
+func TestScheduler_StopsOnCancel(t *testing.T) {
+	ms := &mockStore{}
+	s := New(ms)
+	started := make(chan struct{})
+	s.Register(Job{Name: "long", Interval: time.Hour, Run: func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		time.Sleep(20 * time.Millisecond) // Winding down
+		return ctx.Err()
+	}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		s.Start(ctx)
+		close(stopped)
+	}()
+
+	<-started
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Start didn't return after cancelling")
+	}
+
+	// Start waited for the job, which saw the cancellation
+	runs := ms.runs()
+	if len(runs) != 1 || runs[0].Error != context.Canceled.Error() {
+		t.Errorf("Expected the cancelled run to be recorded before stopping, got %+v", runs)
+	}
+	if err := s.RunNow("long"); !errors.Is(err, ErrStopped) {
+		t.Errorf("Expected ErrStopped after stopping, got %v", err)
+	}
+}
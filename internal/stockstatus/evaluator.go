@@ -0,0 +1,263 @@
+// Package stockstatus evaluates user-defined stock rules against part stock levels.
+// It is shared by the dashboard (colors and effects) and the background service (notifications).
+package stockstatus
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"wledger/internal/models"
+)
+
+// Rule severities, used to filter what the dashboard lights up
+const (
+	SeverityCritical  = "critical"
+	SeverityAttention = "attention"
+	SeverityOK        = "ok"
+)
+
+// Rule actions
+const (
+	ActionColor  = "color"
+	ActionEffect = "effect"
+	ActionNotify = "notify"
+)
+
+// Condition fields
+const (
+	FieldQuantity        = "quantity"
+	FieldMinStock        = "min_stock"
+	FieldReorderPoint    = "reorder_point"
+	FieldDaysSinceUpdate = "days_since_update"
+	FieldName            = "name"
+	FieldPartNumber      = "part_number"
+	FieldManufacturer    = "manufacturer"
+	FieldSupplier        = "supplier"
+	FieldStatus          = "status"
+	FieldCategory        = "category"
+)
+
+// Condition operators
+const (
+	OpEqual          = "eq"
+	OpNotEqual       = "ne"
+	OpLess           = "lt"
+	OpLessOrEqual    = "lte"
+	OpGreater        = "gt"
+	OpGreaterOrEqual = "gte"
+	OpContains       = "contains"
+)
+
+// Facts are the part and stock values a rule is evaluated against
+type Facts struct {
+	Quantity     int
+	MinStock     int
+	ReorderPoint int
+	Name         string
+	PartNumber   string
+	Manufacturer string
+	Supplier     string
+	Status       string
+	Categories   []string
+	UpdatedAt    time.Time
+}
+
+// FactsFromBin builds Facts from dashboard bin data using the given quantity and thresholds
+func FactsFromBin(bin models.DashboardBinData, quantity, minStock, reorderPoint int) Facts {
+	return Facts{
+		Quantity:     quantity,
+		MinStock:     minStock,
+		ReorderPoint: reorderPoint,
+		Name:         bin.PartName,
+		PartNumber:   bin.PartNumber.String,
+		Manufacturer: bin.Manufacturer.String,
+		Supplier:     bin.Supplier.String,
+		Status:       bin.Status.String,
+		Categories:   bin.Categories,
+		UpdatedAt:    bin.UpdatedAt,
+	}
+}
+
+// DefaultRules returns the built-in three-level rule set:
+// red at or below min stock, yellow at or below reorder point, green otherwise.
+func DefaultRules() []models.StockRule {
+	return []models.StockRule{
+		{
+			Name:       "Critical (at or below min stock)",
+			Priority:   100,
+			Enabled:    true,
+			Conditions: []models.StockRuleCondition{{Field: FieldQuantity, Operator: OpLessOrEqual, Value: FieldMinStock}},
+			Severity:   SeverityCritical,
+			Action:     ActionColor,
+			Color:      "FF0000",
+		},
+		{
+			Name:       "Low (at or below reorder point)",
+			Priority:   200,
+			Enabled:    true,
+			Conditions: []models.StockRuleCondition{{Field: FieldQuantity, Operator: OpLessOrEqual, Value: FieldReorderPoint}},
+			Severity:   SeverityAttention,
+			Action:     ActionColor,
+			Color:      "FFFF00",
+		},
+		{
+			Name:     "OK",
+			Priority: 300,
+			Enabled:  true,
+			Severity: SeverityOK,
+			Action:   ActionColor,
+			Color:    "00FF00",
+		},
+	}
+}
+
+// Evaluator matches Facts against an ordered set of rules
+type Evaluator struct {
+	rules []models.StockRule
+	now   func() time.Time
+}
+
+// NewEvaluator returns an Evaluator for the enabled rules, sorted by priority.
+// If no rules are given, the default rule set is used.
+func NewEvaluator(rules []models.StockRule) *Evaluator {
+	if len(rules) == 0 {
+		rules = DefaultRules()
+	}
+
+	enabled := []models.StockRule{}
+	for _, r := range rules {
+		if r.Enabled {
+			enabled = append(enabled, r)
+		}
+	}
+	sort.SliceStable(enabled, func(i, j int) bool {
+		return enabled[i].Priority < enabled[j].Priority
+	})
+
+	return &Evaluator{rules: enabled, now: time.Now}
+}
+
+// Evaluate returns the first rule that matches the facts
+func (e *Evaluator) Evaluate(f Facts) (models.StockRule, bool) {
+	for _, r := range e.rules {
+		if e.matches(r, f) {
+			return r, true
+		}
+	}
+	return models.StockRule{}, false
+}
+
+// EvaluateAll returns every rule that matches the facts, in priority order
+func (e *Evaluator) EvaluateAll(f Facts) []models.StockRule {
+	matched := []models.StockRule{}
+	for _, r := range e.rules {
+		if e.matches(r, f) {
+			matched = append(matched, r)
+		}
+	}
+	return matched
+}
+
+func (e *Evaluator) matches(r models.StockRule, f Facts) bool {
+	for _, c := range r.Conditions {
+		if !e.conditionMatches(c, f) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *Evaluator) conditionMatches(c models.StockRuleCondition, f Facts) bool {
+	switch c.Field {
+	case FieldQuantity, FieldMinStock, FieldReorderPoint, FieldDaysSinceUpdate:
+		left, _ := e.numericField(c.Field, f)
+		right, ok := e.numericField(c.Value, f)
+		if !ok {
+			n, err := strconv.Atoi(strings.TrimSpace(c.Value))
+			if err != nil {
+				return false
+			}
+			right = n
+		}
+		return compareInts(left, c.Operator, right)
+	case FieldCategory:
+		// "ne" means the part has no such category, other operators need any category to match
+		if c.Operator == OpNotEqual {
+			for _, cat := range f.Categories {
+				if compareStrings(cat, OpEqual, c.Value) {
+					return false
+				}
+			}
+			return true
+		}
+		for _, cat := range f.Categories {
+			if compareStrings(cat, c.Operator, c.Value) {
+				return true
+			}
+		}
+		return false
+	case FieldName:
+		return compareStrings(f.Name, c.Operator, c.Value)
+	case FieldPartNumber:
+		return compareStrings(f.PartNumber, c.Operator, c.Value)
+	case FieldManufacturer:
+		return compareStrings(f.Manufacturer, c.Operator, c.Value)
+	case FieldSupplier:
+		return compareStrings(f.Supplier, c.Operator, c.Value)
+	case FieldStatus:
+		return compareStrings(f.Status, c.Operator, c.Value)
+	}
+	return false
+}
+
+// numericField resolves a numeric field name to its value
+func (e *Evaluator) numericField(name string, f Facts) (int, bool) {
+	switch name {
+	case FieldQuantity:
+		return f.Quantity, true
+	case FieldMinStock:
+		return f.MinStock, true
+	case FieldReorderPoint:
+		return f.ReorderPoint, true
+	case FieldDaysSinceUpdate:
+		if f.UpdatedAt.IsZero() {
+			return 0, true
+		}
+		return int(e.now().Sub(f.UpdatedAt).Hours() / 24), true
+	}
+	return 0, false
+}
+
+func compareInts(a int, op string, b int) bool {
+	switch op {
+	case OpEqual:
+		return a == b
+	case OpNotEqual:
+		return a != b
+	case OpLess:
+		return a < b
+	case OpLessOrEqual:
+		return a <= b
+	case OpGreater:
+		return a > b
+	case OpGreaterOrEqual:
+		return a >= b
+	}
+	return false
+}
+
+// compareStrings compares case-insensitively
+func compareStrings(a, op, b string) bool {
+	a, b = strings.ToLower(strings.TrimSpace(a)), strings.ToLower(strings.TrimSpace(b))
+	switch op {
+	case OpEqual:
+		return a == b
+	case OpNotEqual:
+		return a != b
+	case OpContains:
+		return strings.Contains(a, b)
+	}
+	return false
+}
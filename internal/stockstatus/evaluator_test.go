@@ -0,0 +1,78 @@
+package stockstatus
+
+import (
+	"testing"
+	"time"
+
+	"wledger/internal/models"
+)
+
+func TestEvaluator_DefaultRules(t *testing.T) {
+	e := NewEvaluator(nil)
+
+	tests := []struct {
+		qty  int
+		want string
+	}{
+		{0, SeverityCritical},
+		{5, SeverityCritical},
+		{8, SeverityAttention},
+		{10, SeverityAttention},
+		{50, SeverityOK},
+	}
+	for _, tc := range tests {
+		rule, ok := e.Evaluate(Facts{Quantity: tc.qty, MinStock: 5, ReorderPoint: 10})
+		if !ok {
+			t.Fatalf("qty %d: no rule matched", tc.qty)
+		}
+		if rule.Severity != tc.want {
+			t.Errorf("qty %d: got %s, want %s", tc.qty, rule.Severity, tc.want)
+		}
+	}
+}
+
+func TestEvaluator_PriorityAndConditions(t *testing.T) {
+	rules := []models.StockRule{
+		{Name: "Fallback", Priority: 10, Enabled: true, Severity: SeverityOK, Action: ActionColor, Color: "00FF00"},
+		{
+			Name: "Digi-Key passives", Priority: 1, Enabled: true, Severity: SeverityAttention, Action: ActionColor, Color: "0000FF",
+			Conditions: []models.StockRuleCondition{
+				{Field: FieldSupplier, Operator: OpEqual, Value: "digi-key"},
+				{Field: FieldCategory, Operator: OpEqual, Value: "Passives"},
+				{Field: FieldQuantity, Operator: OpLess, Value: "100"},
+			},
+		},
+		{Name: "Disabled", Priority: 0, Enabled: false, Severity: SeverityCritical},
+	}
+	e := NewEvaluator(rules)
+
+	match := Facts{Quantity: 20, Supplier: "Digi-Key", Categories: []string{"ICs", "passives"}}
+	if rule, _ := e.Evaluate(match); rule.Name != "Digi-Key passives" {
+		t.Errorf("Expected Digi-Key rule, got %q", rule.Name)
+	}
+
+	noMatch := Facts{Quantity: 200, Supplier: "Digi-Key", Categories: []string{"passives"}}
+	if rule, _ := e.Evaluate(noMatch); rule.Name != "Fallback" {
+		t.Errorf("Expected fallback rule, got %q", rule.Name)
+	}
+
+	if all := e.EvaluateAll(match); len(all) != 2 {
+		t.Errorf("Expected 2 matching rules, got %d", len(all))
+	}
+}
+
+func TestEvaluator_DaysSinceUpdate(t *testing.T) {
+	now := time.Date(2025, 6, 30, 12, 0, 0, 0, time.UTC)
+	e := NewEvaluator([]models.StockRule{{
+		Name: "Stale", Priority: 1, Enabled: true, Action: ActionNotify,
+		Conditions: []models.StockRuleCondition{{Field: FieldDaysSinceUpdate, Operator: OpGreaterOrEqual, Value: "30"}},
+	}})
+	e.now = func() time.Time { return now }
+
+	if _, ok := e.Evaluate(Facts{UpdatedAt: now.AddDate(0, 0, -31)}); !ok {
+		t.Errorf("Expected stale part to match")
+	}
+	if _, ok := e.Evaluate(Facts{UpdatedAt: now.AddDate(0, 0, -2)}); ok {
+		t.Errorf("Expected fresh part not to match")
+	}
+}
@@ -1,45 +1,82 @@
 package store
 
 import (
+	"context"
+	"encoding/json"
 	"time"
 	"wledger/internal/models"
 )
 
-func (s *Store) GetAllDataForBackup() (models.BackupData, error) {
+func (s *Store) GetAllDataForBackup(ctx context.Context) (models.BackupData, error) {
 	data := models.BackupData{
 		Version:     1,
 		GeneratedAt: time.Now(),
 	}
 
+	version, err := s.SchemaVersion(ctx)
+	if err != nil {
+		return data, err
+	}
+	data.SchemaVersion = version
+
 	// Get all data from each table
-	parts, err := s.GetParts()
+	parts, err := s.GetParts(ctx)
 	if err != nil {
 		return data, err
 	}
 	data.Parts = parts
 
-	ctrls, err := s.GetControllers()
+	ctrls, err := s.GetControllers(ctx)
 	if err != nil {
 		return data, err
 	}
+	// Passwords are stored in plaintext, so they're kept out of backups,
+	// see RestoreFromBackup
+	for i := range ctrls {
+		ctrls[i].Endpoint.Password = ""
+	}
 	data.Controllers = ctrls
 
-	bins, err := s.GetBins()
+	bins, err := s.GetBins(ctx)
 	if err != nil {
 		return data, err
 	}
 	data.Bins = bins
 
-	cats, err := s.GetCategories()
+	cats, err := s.GetCategories(ctx)
 	if err != nil {
 		return data, err
 	}
 	data.Categories = cats
 
+	rules, err := s.GetStockRules(ctx)
+	if err != nil {
+		return data, err
+	}
+	data.StockRules = rules
+
+	presets, err := s.GetStockStatusPresets(ctx)
+	if err != nil {
+		return data, err
+	}
+	data.Presets = presets
+
+	zones, err := s.GetZones(ctx)
+	if err != nil {
+		return data, err
+	}
+	data.Zones = zones
+
+	schedules, err := s.GetLightingSchedules(ctx)
+	if err != nil {
+		return data, err
+	}
+	data.Schedules = schedules
+
 	// Manual Queries for things that have no "GetAll" methods
 
 	// Part URLs
-	rows, err := s.db.Query("SELECT id, part_id, url, description FROM part_urls")
+	rows, err := s.conn().QueryContext(ctx, "SELECT id, part_id, url, description FROM part_urls")
 	if err != nil {
 		return data, err
 	}
@@ -52,7 +89,7 @@ func (s *Store) GetAllDataForBackup() (models.BackupData, error) {
 	rows.Close()
 
 	// Part Docs
-	rows, err = s.db.Query("SELECT id, part_id, filename, filepath, description, mimetype FROM part_documents")
+	rows, err = s.conn().QueryContext(ctx, "SELECT id, part_id, filename, filepath, description, mimetype FROM part_documents")
 	if err != nil {
 		return data, err
 	}
@@ -65,7 +102,7 @@ func (s *Store) GetAllDataForBackup() (models.BackupData, error) {
 	rows.Close()
 
 	// Part Categories (Join Table)
-	rows, err = s.db.Query("SELECT part_id, category_id FROM part_categories")
+	rows, err = s.conn().QueryContext(ctx, "SELECT part_id, category_id FROM part_categories")
 	if err != nil {
 		return data, err
 	}
@@ -78,7 +115,7 @@ func (s *Store) GetAllDataForBackup() (models.BackupData, error) {
 	rows.Close()
 
 	// Part Locations
-	rows, err = s.db.Query("SELECT id, part_id, bin_id, quantity FROM part_locations")
+	rows, err = s.conn().QueryContext(ctx, "SELECT id, part_id, bin_id, quantity, reorder_point, min_stock, capacity FROM part_locations")
 	if err != nil {
 		return data, err
 	}
@@ -86,7 +123,7 @@ func (s *Store) GetAllDataForBackup() (models.BackupData, error) {
 	for rows.Next() {
 		var pl models.PartLocation
 		// scanning into base fields, ignoring joined names for backup
-		rows.Scan(&pl.LocationID, &pl.PartID, &pl.BinID, &pl.Quantity)
+		rows.Scan(&pl.LocationID, &pl.PartID, &pl.BinID, &pl.Quantity, &pl.ReorderPoint, &pl.MinStock, &pl.Capacity)
 		data.PartLocations = append(data.PartLocations, pl)
 	}
 
@@ -102,9 +139,22 @@ func (s *Store) GetAllDataForBackup() (models.BackupData, error) {
 // TODO: Consider adding an option to merge data instead of full replacement. Not sure how useful this is though.
 
 // RestoreFromBackup restores the database state from the provided BackupData, deleting existing data first
-func (s *Store) RestoreFromBackup(data models.BackupData) error {
-	tx, err := s.db.Begin()
+func (s *Store) RestoreFromBackup(ctx context.Context, data models.BackupData) error {
+	// Older backups are fine, their missing fields keep the defaults
+	if data.SchemaVersion > LatestSchemaVersion() {
+		return ErrBackupTooNew
+	}
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Backups have no controller passwords, so controllers keep the one
+	// currently stored for their address. Others need it entered again.
+	passwords, err := controllerPasswords(ctx, tx)
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 
@@ -115,23 +165,44 @@ func (s *Store) RestoreFromBackup(data models.BackupData) error {
 		"parts", "bins", "wled_controllers", "categories",
 	}
 	for _, table := range tables {
-		if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table); err != nil {
 			tx.Rollback()
 			return err
 		}
-		// Reset auto-increment counters brrrrrrr
-		if _, err := tx.Exec("DELETE FROM sqlite_sequence WHERE name=?", table); err != nil {
+	}
+
+	// RESTORE EVERYTHING (Parents first)
+
+	// Zones (older backups don't have any, keep the current zones then)
+	if data.Zones != nil {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM zones"); err != nil {
 			tx.Rollback()
 			return err
 		}
+		stmt, _ := tx.PrepareContext(ctx, "INSERT INTO zones (id, name) VALUES (?, ?)")
+		for _, z := range data.Zones {
+			if _, err := stmt.ExecContext(ctx, z.ID, z.Name); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		stmt.Close()
 	}
 
-	// RESTORE EVERYTHING (Parents first)
-
 	// Controllers
-	stmt, _ := tx.Prepare("INSERT INTO wled_controllers (id, name, ip_address, status, last_seen) VALUES (?, ?, ?, ?, ?)")
+	stmt, _ := tx.PrepareContext(ctx, `INSERT INTO wled_controllers (id, name, ip_address, status, last_seen, restore_state, zone_id, scheme, host, port, base_path, username, password)
+		VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, 0), ?, ?, ?, ?, ?, ?)`)
 	for _, c := range data.Controllers {
-		if _, err := stmt.Exec(c.ID, c.Name, c.IPAddress, c.Status, c.LastSeen); err != nil {
+		// Backups made before endpoints were structured only have the address
+		if err := normalizeEndpoint(&c); err != nil {
+			c.Endpoint = models.WLEDEndpoint{Scheme: "http", Host: c.IPAddress}
+		}
+		e := c.Endpoint
+		if e.Password == "" && e.Username != "" {
+			e.Password = passwords[c.IPAddress]
+		}
+		if _, err := stmt.ExecContext(ctx, c.ID, c.Name, c.IPAddress, c.Status, c.LastSeen, c.RestoreState, c.ZoneID,
+			e.Scheme, e.Host, e.Port, e.BasePath, e.Username, e.Password); err != nil {
 			tx.Rollback()
 			return err
 		}
@@ -139,9 +210,9 @@ func (s *Store) RestoreFromBackup(data models.BackupData) error {
 	stmt.Close()
 
 	// Bins
-	stmt, _ = tx.Prepare("INSERT INTO bins (id, name, wled_controller_id, wled_segment_id, led_index) VALUES (?, ?, ?, ?, ?)")
+	stmt, _ = tx.PrepareContext(ctx, "INSERT INTO bins (id, name, wled_controller_id, wled_segment_id, led_index, capacity, zone_id, shared_led) VALUES (?, ?, NULLIF(?, 0), ?, ?, ?, NULLIF(?, 0), ?)")
 	for _, b := range data.Bins {
-		if _, err := stmt.Exec(b.ID, b.Name, b.WLEDControllerID, b.WLEDSegmentID, b.LEDIndex); err != nil {
+		if _, err := stmt.ExecContext(ctx, b.ID, b.Name, b.WLEDControllerID, b.WLEDSegmentID, b.LEDIndex, b.Capacity, b.ZoneID, b.SharedLED); err != nil {
 			tx.Rollback()
 			return err
 		}
@@ -149,9 +220,9 @@ func (s *Store) RestoreFromBackup(data models.BackupData) error {
 	stmt.Close()
 
 	// Categories
-	stmt, _ = tx.Prepare("INSERT INTO categories (id, name) VALUES (?, ?)")
+	stmt, _ = tx.PrepareContext(ctx, "INSERT INTO categories (id, name) VALUES (?, ?)")
 	for _, c := range data.Categories {
-		if _, err := stmt.Exec(c.ID, c.Name); err != nil {
+		if _, err := stmt.ExecContext(ctx, c.ID, c.Name); err != nil {
 			tx.Rollback()
 			return err
 		}
@@ -159,9 +230,9 @@ func (s *Store) RestoreFromBackup(data models.BackupData) error {
 	stmt.Close()
 
 	// Parts
-	stmt, _ = tx.Prepare(`INSERT INTO parts (id, name, description, part_number, datasheet_url, created_at, updated_at, image_path, manufacturer, supplier, unit_cost, status, stock_tracking_enabled, reorder_point, min_stock) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	stmt, _ = tx.PrepareContext(ctx, `INSERT INTO parts (id, name, description, part_number, datasheet_url, created_at, updated_at, image_path, manufacturer, supplier, unit_cost, status, stock_tracking_enabled, reorder_point, min_stock) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 	for _, p := range data.Parts {
-		if _, err := stmt.Exec(p.ID, p.Name, p.Description, p.PartNumber, p.DatasheetURL, p.CreatedAt, p.UpdatedAt, p.ImagePath, p.Manufacturer, p.Supplier, p.UnitCost, p.Status, p.StockTracking, p.ReorderPoint, p.MinStock); err != nil {
+		if _, err := stmt.ExecContext(ctx, p.ID, p.Name, p.Description, p.PartNumber, p.DatasheetURL, p.CreatedAt, p.UpdatedAt, p.ImagePath, p.Manufacturer, p.Supplier, p.UnitCost, p.Status, p.StockTracking, p.ReorderPoint, p.MinStock); err != nil {
 			tx.Rollback()
 			return err
 		}
@@ -169,9 +240,9 @@ func (s *Store) RestoreFromBackup(data models.BackupData) error {
 	stmt.Close()
 
 	// Part URLs
-	stmt, _ = tx.Prepare("INSERT INTO part_urls (id, part_id, url, description) VALUES (?, ?, ?, ?)")
+	stmt, _ = tx.PrepareContext(ctx, "INSERT INTO part_urls (id, part_id, url, description) VALUES (?, ?, ?, ?)")
 	for _, u := range data.PartUrls {
-		if _, err := stmt.Exec(u.ID, u.PartID, u.URL, u.Description); err != nil {
+		if _, err := stmt.ExecContext(ctx, u.ID, u.PartID, u.URL, u.Description); err != nil {
 			tx.Rollback()
 			return err
 		}
@@ -179,9 +250,9 @@ func (s *Store) RestoreFromBackup(data models.BackupData) error {
 	stmt.Close()
 
 	// Part Docs
-	stmt, _ = tx.Prepare("INSERT INTO part_documents (id, part_id, filename, filepath, description, mimetype) VALUES (?, ?, ?, ?, ?, ?)")
+	stmt, _ = tx.PrepareContext(ctx, "INSERT INTO part_documents (id, part_id, filename, filepath, description, mimetype) VALUES (?, ?, ?, ?, ?, ?)")
 	for _, d := range data.PartDocs {
-		if _, err := stmt.Exec(d.ID, d.PartID, d.Filename, d.Filepath, d.Description, d.Mimetype); err != nil {
+		if _, err := stmt.ExecContext(ctx, d.ID, d.PartID, d.Filename, d.Filepath, d.Description, d.Mimetype); err != nil {
 			tx.Rollback()
 			return err
 		}
@@ -189,24 +260,108 @@ func (s *Store) RestoreFromBackup(data models.BackupData) error {
 	stmt.Close()
 
 	// Part Categories
-	stmt, _ = tx.Prepare("INSERT INTO part_categories (part_id, category_id) VALUES (?, ?)")
+	stmt, _ = tx.PrepareContext(ctx, "INSERT INTO part_categories (part_id, category_id) VALUES (?, ?)")
 	for _, pc := range data.PartCats {
-		if _, err := stmt.Exec(pc.PartID, pc.CategoryID); err != nil {
+		if _, err := stmt.ExecContext(ctx, pc.PartID, pc.CategoryID); err != nil {
 			tx.Rollback()
 			return err
 		}
 	}
 	stmt.Close()
 
+	// Stock Rules (older backups don't have any, keep the current rules then)
+	if data.StockRules != nil {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM stock_rules"); err != nil {
+			tx.Rollback()
+			return err
+		}
+		stmt, _ = tx.PrepareContext(ctx, "INSERT INTO stock_rules (id, name, priority, enabled, conditions, severity, action, color, effect, message) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		for _, r := range data.StockRules {
+			conditions, err := json.Marshal(r.Conditions)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+			if _, err := stmt.ExecContext(ctx, r.ID, r.Name, r.Priority, r.Enabled, string(conditions), r.Severity, r.Action, r.Color, r.Effect, r.Message); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		stmt.Close()
+	}
+
+	// Stock Status Presets (same as rules, older backups don't have any)
+	if data.Presets != nil {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM stock_status_presets"); err != nil {
+			tx.Rollback()
+			return err
+		}
+		stmt, _ = tx.PrepareContext(ctx, "INSERT INTO stock_status_presets (id, name, level, mode, category, supplier, manufacturer, controller_id, location, zone_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		for _, p := range data.Presets {
+			if _, err := stmt.ExecContext(ctx, p.ID, p.Name, p.Level, p.Mode, p.Filter.Category, p.Filter.Supplier, p.Filter.Manufacturer, p.Filter.ControllerID, p.Filter.Location, p.Filter.ZoneID); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		stmt.Close()
+	}
+
+	// Lighting Schedules (same as rules, older backups don't have any)
+	if data.Schedules != nil {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM lighting_schedules"); err != nil {
+			tx.Rollback()
+			return err
+		}
+		stmt, _ = tx.PrepareContext(ctx, "INSERT INTO lighting_schedules (id, name, cron, action, preset_id, color, brightness, enabled) VALUES (?, ?, ?, ?, ?, ?, ?, ?)")
+		for _, ls := range data.Schedules {
+			if _, err := stmt.ExecContext(ctx, ls.ID, ls.Name, ls.Cron, ls.Action, ls.PresetID, ls.Color, ls.Brightness, ls.Enabled); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		stmt.Close()
+	}
+
 	// Part Locations
-	stmt, _ = tx.Prepare("INSERT INTO part_locations (id, part_id, bin_id, quantity) VALUES (?, ?, ?, ?)")
+	stmt, _ = tx.PrepareContext(ctx, "INSERT INTO part_locations (id, part_id, bin_id, quantity, reorder_point, min_stock, capacity) VALUES (?, ?, ?, ?, ?, ?, ?)")
 	for _, pl := range data.PartLocations {
-		if _, err := stmt.Exec(pl.LocationID, pl.PartID, pl.BinID, pl.Quantity); err != nil {
+		if _, err := stmt.ExecContext(ctx, pl.LocationID, pl.PartID, pl.BinID, pl.Quantity, pl.ReorderPoint, pl.MinStock, pl.Capacity); err != nil {
 			tx.Rollback()
 			return err
 		}
 	}
 	stmt.Close()
 
+	// Reset auto-increment counters brrrrrrr, new rows carry on after the restored IDs
+	restored := []string{
+		"zones", "wled_controllers", "bins", "categories", "parts", "part_urls", "part_documents",
+		"stock_rules", "stock_status_presets", "lighting_schedules", "part_locations",
+	}
+	for _, table := range restored {
+		if err := s.dialect.resetIDs(ctx, tx, table); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
 	return tx.Commit()
 }
+
+// controllerPasswords returns the stored passwords by controller address
+func controllerPasswords(ctx context.Context, q dbtx) (map[string]string, error) {
+	rows, err := q.QueryContext(ctx, `SELECT ip_address, password FROM wled_controllers WHERE password != ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	passwords := map[string]string{}
+	for rows.Next() {
+		var address, password string
+		if err := rows.Scan(&address, &password); err != nil {
+			return nil, err
+		}
+		passwords[address] = password
+	}
+	return passwords, rows.Err()
+}
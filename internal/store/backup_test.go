@@ -2,22 +2,25 @@ package store
 
 import (
 	"testing"
+
+	"wledger/internal/models"
 )
 
 func TestStore_BackupRestore(t *testing.T) {
 	s := newTestStore(t)
 
 	// Populate Data
-	s.CreateController("C1", "1.1.1.1")
-	s.CreateBin("B1", 1, 0, 0)
-	s.CreatePart(getValidPart("P1"))
-	s.CreatePartLocation(1, 1, 10)
-	cat, _ := s.CreateCategory("Cat1")
-	s.AssignCategoryToPart(1, cat.ID)
-	s.CreatePartURL(1, "http://test", "test")
+	s.CreateController(t.Context(), &models.WLEDController{Name: "C1", IPAddress: "1.1.1.1"})
+	s.CreateBin(t.Context(), "B1", 1, 0, 0, false)
+	s.CreatePart(t.Context(), getValidPart("P1"))
+	s.CreatePartLocation(t.Context(), 1, 1, 10)
+	cat, _ := s.CreateCategory(t.Context(), "Cat1")
+	s.AssignCategoryToPart(t.Context(), 1, cat.ID)
+	s.CreatePartURL(t.Context(), 1, "http://test", "test")
+	s.CreateStockRule(t.Context(), &models.StockRule{Name: "Custom", Enabled: true, Severity: "ok", Action: "color", Color: "0000FF"})
 
 	// Export
-	backup, err := s.GetAllDataForBackup()
+	backup, err := s.GetAllDataForBackup(t.Context())
 	if err != nil {
 		t.Fatalf("Export failed: %v", err)
 	}
@@ -29,17 +32,53 @@ func TestStore_BackupRestore(t *testing.T) {
 	s2 := newTestStore(t)
 
 	// Import
-	if err := s2.RestoreFromBackup(backup); err != nil {
+	if err := s2.RestoreFromBackup(t.Context(), backup); err != nil {
 		t.Fatalf("Restore failed: %v", err)
 	}
 
 	// Verify
-	parts, _ := s2.GetParts()
+	parts, _ := s2.GetParts(t.Context())
 	if len(parts) != 1 {
 		t.Errorf("Restore failed: parts missing")
 	}
-	locs, _ := s2.GetPartLocations(parts[0].ID)
+	locs, _ := s2.GetPartLocations(t.Context(), parts[0].ID)
 	if len(locs) != 1 {
 		t.Errorf("Restore failed: locations missing")
 	}
+	rules, _ := s2.GetStockRules(t.Context())
+	if len(rules) != 4 {
+		t.Errorf("Restore failed: expected 4 stock rules, got %d", len(rules))
+	}
+}
+
+func TestStore_BackupRestore_ControllerPasswords(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateController(t.Context(), &models.WLEDController{Name: "Cabinet", Endpoint: models.WLEDEndpoint{
+		Scheme: "https", Host: "proxy.example", Username: "admin", Password: "s3cret",
+	}})
+
+	backup, err := s.GetAllDataForBackup(t.Context())
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if e := backup.Controllers[0].Endpoint; e.Username != "admin" || e.Password != "" {
+		t.Errorf("Expected the username without the password in the backup, got %+v", e)
+	}
+
+	// Restored over the same controller, the password is kept
+	if err := s.RestoreFromBackup(t.Context(), backup); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if _, pass, _ := s.GetControllerCredentials(t.Context(), "https://proxy.example"); pass != "s3cret" {
+		t.Errorf("Expected the current password to be kept, got %q", pass)
+	}
+
+	// Elsewhere it has to be entered again
+	s2 := newTestStore(t)
+	if err := s2.RestoreFromBackup(t.Context(), backup); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if user, pass, _ := s2.GetControllerCredentials(t.Context(), "https://proxy.example"); user != "admin" || pass != "" {
+		t.Errorf("Expected the username without a password, got %q %q", user, pass)
+	}
 }
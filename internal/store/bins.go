@@ -1,74 +1,97 @@
 package store
 
 import (
+	"context"
+	"database/sql"
 	"log"
 	"strconv"
 	"wledger/internal/models"
-
-	"modernc.org/sqlite"
-	sqlitelib "modernc.org/sqlite/lib"
 )
 
-func (s *Store) GetBins() ([]models.Bin, error) {
-	// Fetch all bins
+func (s *Store) GetBins(ctx context.Context) ([]models.Bin, error) {
+	bins, _, err := s.ListBins(ctx, models.BinFilter{})
+	return bins, err
+}
+
+// ListBins returns a page of the bins the filter matches, and how many
+// match in all. A bin overlaps when another bin is on its LED and they
+// don't all share it.
+func (s *Store) ListBins(ctx context.Context, f models.BinFilter) ([]models.Bin, int, error) {
+	where := ` WHERE 1 = 1`
+	args := []any{}
+	if f.Search != "" {
+		where += ` AND LOWER(b.name) LIKE LOWER(?) ESCAPE '\'`
+		args = append(args, "%"+escapeLike(f.Search)+"%")
+	}
+	if f.ControllerID != 0 {
+		where += ` AND b.wled_controller_id = ?`
+		args = append(args, f.ControllerID)
+	}
+	if f.ZoneID != 0 {
+		where += ` AND b.zone_id = ?`
+		args = append(args, f.ZoneID)
+	}
+
+	var total int
+	err := s.conn().QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM bins b
+		LEFT JOIN wled_controllers c ON b.wled_controller_id = c.id`+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
 	query := `
-		SELECT b.id, b.name, b.wled_controller_id, b.wled_segment_id, b.led_index, c.name
+		SELECT b.id, b.name, COALESCE(b.wled_controller_id, 0), b.wled_segment_id, b.led_index, b.capacity,
+		       COALESCE(b.zone_id, 0), z.name, c.name, b.shared_led,
+		       EXISTS (
+		           SELECT 1 FROM bins o
+		           WHERE o.wled_controller_id = b.wled_controller_id AND o.wled_segment_id = b.wled_segment_id
+		             AND o.led_index = b.led_index AND o.id <> b.id AND (NOT o.shared_led OR NOT b.shared_led))
 		FROM bins b
 		LEFT JOIN wled_controllers c ON b.wled_controller_id = c.id
-		ORDER BY b.wled_segment_id ASC, b.led_index ASC;
-	`
-	rows, err := s.db.Query(query)
+		LEFT JOIN zones z ON z.id = ` + binZoneExpr + where + `
+		ORDER BY b.wled_segment_id ASC, b.led_index ASC, b.id ASC`
+	if f.Limit > 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, f.Limit, f.Offset)
+	}
+	rows, err := s.conn().QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
-	var bins []models.Bin
-
-	// Map to track overlaps: "CtrlID-SegID-LEDIndex" -> Count
-	occurrenceMap := make(map[string]int)
-
+	bins := []models.Bin{}
 	for rows.Next() {
 		var b models.Bin
-		err := rows.Scan(&b.ID, &b.Name, &b.WLEDControllerID, &b.WLEDSegmentID, &b.LEDIndex, &b.WLEDControllerName)
+		var overlap bool
+		err := rows.Scan(&b.ID, &b.Name, &b.WLEDControllerID, &b.WLEDSegmentID, &b.LEDIndex, &b.Capacity, &b.ZoneID, &b.ZoneName, &b.WLEDControllerName, &b.SharedLED, &overlap)
 		if err != nil {
 			log.Println("Error scanning bin row:", err)
 			continue
 		}
 
-		// DETECT ORPHAN: If the LEFT JOIN returned NULL for the name, the controller doesn't exist
-		if !b.WLEDControllerName.Valid {
+		// DETECT ORPHAN: If the LEFT JOIN returned NULL for the name, the controller doesn't exist.
+		// Orphans don't have overlap warnings, they have orphan warnings.
+		if b.WLEDControllerID == 0 {
+			b.IsDetached = true
+		} else if !b.WLEDControllerName.Valid {
 			b.IsOrphaned = true
 		} else {
-			// Only count occurrences for valid controllers
-			key := strconv.Itoa(b.WLEDControllerID) + "-" + strconv.Itoa(b.WLEDSegmentID) + "-" + strconv.Itoa(b.LEDIndex)
-			occurrenceMap[key]++
+			b.HasOverlap = overlap
 		}
-
 		bins = append(bins, b)
 	}
-
-	// Set flags
-	for i := range bins {
-		if bins[i].IsOrphaned {
-			continue // Orphans don't have overlap warnings, they have orphan warnings
-		}
-		key := strconv.Itoa(bins[i].WLEDControllerID) + "-" + strconv.Itoa(bins[i].WLEDSegmentID) + "-" + strconv.Itoa(bins[i].LEDIndex)
-		if occurrenceMap[key] > 1 {
-			bins[i].HasOverlap = true
-		}
-	}
-
-	return bins, nil
+	return bins, total, nil
 }
 
-func (s *Store) GetAvailableBins(partID int) ([]models.Bin, error) {
+func (s *Store) GetAvailableBins(ctx context.Context, partID int) ([]models.Bin, error) {
 	availBinsQuery := `
 		SELECT id, name, wled_segment_id, led_index FROM bins
 		WHERE id NOT IN (SELECT bin_id FROM part_locations WHERE part_id = ?)
 		ORDER BY wled_segment_id ASC, led_index ASC;
 	`
-	binRows, err := s.db.Query(availBinsQuery, partID)
+	binRows, err := s.conn().QueryContext(ctx, availBinsQuery, partID)
 	if err != nil {
 		return nil, err
 	}
@@ -87,56 +110,222 @@ func (s *Store) GetAvailableBins(partID int) ([]models.Bin, error) {
 	return availableBins, nil
 }
 
-func (s *Store) GetBinByID(id int) (models.Bin, error) {
+func (s *Store) GetBinByID(ctx context.Context, id int) (models.Bin, error) {
 	var b models.Bin
 	query := `
-		SELECT b.id, b.name, b.wled_controller_id, b.wled_segment_id, b.led_index, c.name
+		SELECT b.id, b.name, COALESCE(b.wled_controller_id, 0), b.wled_segment_id, b.led_index, b.capacity,
+		       COALESCE(b.zone_id, 0), z.name, c.name, b.shared_led
 		FROM bins b
 		LEFT JOIN wled_controllers c ON b.wled_controller_id = c.id
+		LEFT JOIN zones z ON z.id = COALESCE(b.zone_id, c.zone_id)
 		WHERE b.id = ?;
 	`
-	row := s.db.QueryRow(query, id)
-	err := row.Scan(&b.ID, &b.Name, &b.WLEDControllerID, &b.WLEDSegmentID, &b.LEDIndex, &b.WLEDControllerName)
+	row := s.conn().QueryRowContext(ctx, query, id)
+	err := row.Scan(&b.ID, &b.Name, &b.WLEDControllerID, &b.WLEDSegmentID, &b.LEDIndex, &b.Capacity, &b.ZoneID, &b.ZoneName, &b.WLEDControllerName, &b.SharedLED)
+
+	if err != nil {
+		return b, err
+	}
 
 	// Re-run orphan/overlap logic for single item
-	if !b.WLEDControllerName.Valid {
+	if b.WLEDControllerID == 0 {
+		b.IsDetached = true
+	} else if !b.WLEDControllerName.Valid {
 		b.IsOrphaned = true
+	} else {
+		others, err := binsOnLED(ctx, s.conn(), b.WLEDControllerID, b.WLEDSegmentID, b.LEDIndex, b.ID)
+		if err != nil {
+			return b, err
+		}
+		b.HasOverlap = ledTaken(b, others)
 	}
-	// Note: Detecting overlap for a single item requires querying all items,
-	// it won't show until refresh.
 
-	return b, err
+	return b, nil
 }
 
-func (s *Store) CreateBin(name string, controllerID, segmentID, ledIndex int) error {
-	_, err := s.db.Exec(
-		`INSERT INTO bins (name, wled_controller_id, wled_segment_id, led_index) 
-		 VALUES (?, ?, ?, ?)`,
-		name, controllerID, segmentID, ledIndex,
+// binsOnLED returns the names and shared flags of the bins on an LED, except one
+func binsOnLED(ctx context.Context, q dbtx, controllerID, segmentID, ledIndex, exceptBinID int) ([]models.Bin, error) {
+	rows, err := q.QueryContext(ctx,
+		`SELECT id, name, shared_led FROM bins
+		 WHERE wled_controller_id = ? AND wled_segment_id = ? AND led_index = ? AND id != ?
+		 ORDER BY name`,
+		controllerID, segmentID, ledIndex, exceptBinID,
 	)
 	if err != nil {
-		// Check for specific constraint errors
-		sqliteErr, ok := err.(*sqlite.Error)
-		if ok {
-			if sqliteErr.Code() == sqlitelib.SQLITE_CONSTRAINT_UNIQUE {
-				return ErrUniqueConstraint
-			}
-			if sqliteErr.Code() == sqlitelib.SQLITE_CONSTRAINT_FOREIGNKEY {
-				return ErrForeignKeyConstraint
-			}
+		return nil, err
+	}
+	defer rows.Close()
+
+	bins := []models.Bin{}
+	for rows.Next() {
+		var b models.Bin
+		if err := rows.Scan(&b.ID, &b.Name, &b.SharedLED); err != nil {
+			return nil, err
 		}
+		bins = append(bins, b)
+	}
+	return bins, rows.Err()
+}
+
+// binsOnController returns the LEDs and shared flags of a controller's bins
+func binsOnController(ctx context.Context, q dbtx, controllerID int) ([]models.Bin, error) {
+	rows, err := q.QueryContext(ctx,
+		`SELECT id, name, wled_segment_id, led_index, shared_led FROM bins
+		 WHERE wled_controller_id = ? ORDER BY id`,
+		controllerID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bins := []models.Bin{}
+	for rows.Next() {
+		var b models.Bin
+		if err := rows.Scan(&b.ID, &b.Name, &b.WLEDSegmentID, &b.LEDIndex, &b.SharedLED); err != nil {
+			return nil, err
+		}
+		bins = append(bins, b)
+	}
+	return bins, rows.Err()
+}
+
+// ledTaken reports whether the other bins on an LED keep b off it. Sharing
+// is only fine if all bins on the LED agree, so they all have to be shared.
+func ledTaken(b models.Bin, others []models.Bin) bool {
+	for _, o := range others {
+		if !b.SharedLED || !o.SharedLED {
+			return true
+		}
+	}
+	return false
+}
+
+// claimLED checks that a bin may use its LED, see ledTaken. Bins already on
+// the LED are never marked shared on the way, that's for ShareLED.
+func claimLED(ctx context.Context, tx dbtx, b *models.Bin) error {
+	if b.WLEDControllerID == 0 {
+		return nil // Detached bins don't use an LED
+	}
+	others, err := binsOnLED(ctx, tx, b.WLEDControllerID, b.WLEDSegmentID, b.LEDIndex, b.ID)
+	if err != nil {
 		return err
 	}
+	if ledTaken(*b, others) {
+		return ErrLEDConflict
+	}
 	return nil
 }
 
-func (s *Store) CreateBinsBulk(controllerID, segmentID, ledCount int, namePrefix string) error {
-	tx, err := s.db.Begin()
+// ShareLED marks every bin on an LED shared, for when the user confirmed
+// the bins really sit behind the same light
+func (s *Store) ShareLED(ctx context.Context, controllerID, segmentID, ledIndex int) error {
+	_, err := s.conn().ExecContext(ctx,
+		`UPDATE bins SET shared_led = TRUE WHERE wled_controller_id = ? AND wled_segment_id = ? AND led_index = ?`,
+		controllerID, segmentID, ledIndex,
+	)
+	return err
+}
+
+// GetLEDConflict lists the other bins on a bin's LED and finds the next
+// free LED after it on the same segment
+func (s *Store) GetLEDConflict(ctx context.Context, b models.Bin) (models.LEDConflict, error) {
+	c := models.LEDConflict{ControllerID: b.WLEDControllerID, SegmentID: b.WLEDSegmentID, LEDIndex: b.LEDIndex}
+
+	others, err := binsOnLED(ctx, s.conn(), b.WLEDControllerID, b.WLEDSegmentID, b.LEDIndex, b.ID)
+	if err != nil {
+		return c, err
+	}
+	c.Shared = len(others) > 0
+	for _, o := range others {
+		c.Bins = append(c.Bins, o.Name)
+		c.Shared = c.Shared && o.SharedLED
+	}
+
+	rows, err := s.conn().QueryContext(ctx,
+		`SELECT DISTINCT led_index FROM bins
+		 WHERE wled_controller_id = ? AND wled_segment_id = ? AND led_index > ? AND id != ?
+		 ORDER BY led_index`,
+		b.WLEDControllerID, b.WLEDSegmentID, b.LEDIndex, b.ID,
+	)
+	if err != nil {
+		return c, err
+	}
+	defer rows.Close()
+
+	c.NextFree = b.LEDIndex + 1
+	for rows.Next() {
+		var used int
+		if err := rows.Scan(&used); err != nil {
+			return c, err
+		}
+		if used != c.NextFree {
+			break
+		}
+		c.NextFree++
+	}
+	return c, rows.Err()
+}
+
+// CreateBin adds a bin on an LED. It returns ErrLEDConflict if another bin
+// is on the LED, unless sharedLED is set.
+func (s *Store) CreateBin(ctx context.Context, name string, controllerID, segmentID, ledIndex int, sharedLED bool) (int, error) {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO bins (name, wled_controller_id, wled_segment_id, led_index, shared_led) 
+		 VALUES (?, ?, ?, ?, ?) RETURNING id`,
+		name, controllerID, segmentID, ledIndex, sharedLED,
+	).Scan(&id)
+	if err != nil {
+		return 0, binError(err)
+	}
+
+	b := models.Bin{ID: id, WLEDControllerID: controllerID, WLEDSegmentID: segmentID, LEDIndex: ledIndex, SharedLED: sharedLED}
+	if err := claimLED(ctx, tx, &b); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// binError maps constraint violations to the store's errors
+func binError(err error) error {
+	if isUniqueViolation(err) {
+		return ErrUniqueConstraint
+	}
+	if isForeignKeyViolation(err) {
+		return ErrForeignKeyConstraint
+	}
+	return err
+}
+
+func (s *Store) CreateBinsBulk(ctx context.Context, controllerID, segmentID, ledCount int, namePrefix string) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	// None of the LEDs may have a bin yet
+	var used int
+	err = tx.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM bins WHERE wled_controller_id = ? AND wled_segment_id = ? AND led_index < ?`,
+		controllerID, segmentID, ledCount,
+	).Scan(&used)
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
+	if used > 0 {
+		tx.Rollback()
+		return ErrLEDConflict
+	}
 
-	stmt, err := tx.Prepare(`
+	stmt, err := tx.PrepareContext(ctx, `
 		INSERT INTO bins (name, wled_controller_id, wled_segment_id, led_index) 
 		VALUES (?, ?, ?, ?)
 	`)
@@ -149,13 +338,10 @@ func (s *Store) CreateBinsBulk(controllerID, segmentID, ledCount int, namePrefix
 	for i := 0; i < ledCount; i++ {
 		binName := namePrefix + strconv.Itoa(i)
 		ledIndex := i
-		if _, err := stmt.Exec(binName, controllerID, segmentID, ledIndex); err != nil {
-			sqliteErr, ok := err.(*sqlite.Error)
-			if ok {
-				if sqliteErr.Code() == sqlitelib.SQLITE_CONSTRAINT_UNIQUE {
-					tx.Rollback()
-					return ErrUniqueConstraint
-				}
+		if _, err := stmt.ExecContext(ctx, binName, controllerID, segmentID, ledIndex); err != nil {
+			if isUniqueViolation(err) {
+				tx.Rollback()
+				return ErrUniqueConstraint
 			}
 			tx.Rollback()
 			return err
@@ -165,20 +351,34 @@ func (s *Store) CreateBinsBulk(controllerID, segmentID, ledCount int, namePrefix
 	return tx.Commit()
 }
 
-func (s *Store) UpdateBin(b *models.Bin) error {
-	_, err := s.db.Exec(
-		`UPDATE bins SET name = ?, wled_controller_id = ?, wled_segment_id = ?, led_index = ? WHERE id = ?`,
-		b.Name, b.WLEDControllerID, b.WLEDSegmentID, b.LEDIndex, b.ID,
+// UpdateBin saves a bin. Like CreateBin, it returns ErrLEDConflict if
+// another bin is on its LED, unless it's a shared LED.
+func (s *Store) UpdateBin(ctx context.Context, b *models.Bin) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE bins SET name = ?, wled_controller_id = NULLIF(?, 0), wled_segment_id = ?, led_index = ?, capacity = ?, zone_id = NULLIF(?, 0), shared_led = ? WHERE id = ?`,
+		b.Name, b.WLEDControllerID, b.WLEDSegmentID, b.LEDIndex, b.Capacity, b.ZoneID, b.SharedLED, b.ID,
 	)
-	return err
+	if err != nil {
+		return binError(err)
+	}
+	if err := claimLED(ctx, tx, b); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (s *Store) DeleteBin(id int) error {
-	_, err := s.db.Exec(`DELETE FROM bins WHERE id = ?`, id)
+func (s *Store) DeleteBin(ctx context.Context, id int) error {
+	_, err := s.conn().ExecContext(ctx, `DELETE FROM bins WHERE id = ?`, id)
 	return err
 }
 
-func (s *Store) GetAllBinLocationsForStopAll() ([]struct {
+func (s *Store) GetAllBinLocationsForStopAll(ctx context.Context) ([]struct {
 	IP       string
 	SegID    int
 	LEDIndex int
@@ -188,7 +388,7 @@ func (s *Store) GetAllBinLocationsForStopAll() ([]struct {
 		FROM bins b
 		JOIN wled_controllers c ON b.wled_controller_id = c.id;
 	`
-	rows, err := s.db.Query(query)
+	rows, err := s.conn().QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -213,14 +413,14 @@ func (s *Store) GetAllBinLocationsForStopAll() ([]struct {
 	return locations, nil
 }
 
-func (s *Store) GetPartNamesInBin(binID int) ([]string, error) {
+func (s *Store) GetPartNamesInBin(ctx context.Context, binID int) ([]string, error) {
 	query := `
         SELECT p.name FROM parts p
         JOIN part_locations pl ON p.id = pl.part_id
         WHERE pl.bin_id = ? AND pl.quantity > 0
         ORDER BY p.name;
     `
-	rows, err := s.db.Query(query, binID)
+	rows, err := s.conn().QueryContext(ctx, query, binID)
 	if err != nil {
 		return nil, err
 	}
@@ -239,35 +439,52 @@ func (s *Store) GetPartNamesInBin(binID int) ([]string, error) {
 
 // Location methods
 
-func (s *Store) GetPartLocationByID(locationID int) (models.PartLocation, error) {
+func (s *Store) GetPartLocationByID(ctx context.Context, locationID int) (models.PartLocation, error) {
 	var loc models.PartLocation
 	query := `
-		SELECT pl.id, pl.part_id, pl.bin_id, pl.quantity,
-			   b.name, b.wled_segment_id, b.led_index, b.wled_controller_id
+		SELECT pl.id, pl.part_id, pl.bin_id, pl.quantity, pl.reorder_point, pl.min_stock, pl.capacity,
+			   b.name, b.wled_segment_id, b.led_index, COALESCE(b.wled_controller_id, 0)
 		FROM part_locations pl
 		JOIN bins b ON pl.bin_id = b.id
 		WHERE pl.id = ?;
 	`
-	row := s.db.QueryRow(query, locationID)
+	row := s.conn().QueryRowContext(ctx, query, locationID)
 	err := row.Scan(
-		&loc.LocationID, &loc.PartID, &loc.BinID, &loc.Quantity,
+		&loc.LocationID, &loc.PartID, &loc.BinID, &loc.Quantity, &loc.ReorderPoint, &loc.MinStock, &loc.Capacity,
 		&loc.BinName, &loc.SegmentID, &loc.LEDIndex, &loc.ControllerID,
 	)
 	return loc, err
 }
 
-func (s *Store) GetPartLocations(partID int) ([]models.PartLocation, error) {
+func (s *Store) GetPartLocations(ctx context.Context, partID int) ([]models.PartLocation, error) {
+	locations, _, err := s.ListPartLocations(ctx, partID, 0, 0)
+	return locations, err
+}
+
+// ListPartLocations returns a page of a part's locations, and how many it
+// has. A limit of 0 returns them all.
+func (s *Store) ListPartLocations(ctx context.Context, partID, limit, offset int) ([]models.PartLocation, int, error) {
+	var total int
+	err := s.conn().QueryRowContext(ctx, `SELECT COUNT(*) FROM part_locations WHERE part_id = ?`, partID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
 	query := `
-		SELECT pl.id, pl.part_id, pl.bin_id, pl.quantity,
-			   b.name, b.wled_segment_id, b.led_index, b.wled_controller_id
+		SELECT pl.id, pl.part_id, pl.bin_id, pl.quantity, pl.reorder_point, pl.min_stock, pl.capacity,
+			   b.name, b.wled_segment_id, b.led_index, COALESCE(b.wled_controller_id, 0)
 		FROM part_locations pl
 		JOIN bins b ON pl.bin_id = b.id
 		WHERE pl.part_id = ?
-		ORDER BY b.name;
-	`
-	rows, err := s.db.Query(query, partID)
+		ORDER BY b.name, pl.id`
+	args := []any{partID}
+	if limit > 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, limit, offset)
+	}
+	rows, err := s.conn().QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
@@ -275,7 +492,7 @@ func (s *Store) GetPartLocations(partID int) ([]models.PartLocation, error) {
 	for rows.Next() {
 		var loc models.PartLocation
 		err := rows.Scan(
-			&loc.LocationID, &loc.PartID, &loc.BinID, &loc.Quantity,
+			&loc.LocationID, &loc.PartID, &loc.BinID, &loc.Quantity, &loc.ReorderPoint, &loc.MinStock, &loc.Capacity,
 			&loc.BinName, &loc.SegmentID, &loc.LEDIndex, &loc.ControllerID,
 		)
 		if err != nil {
@@ -284,23 +501,173 @@ func (s *Store) GetPartLocations(partID int) ([]models.PartLocation, error) {
 		}
 		locations = append(locations, loc)
 	}
-	return locations, nil
+	return locations, total, nil
 }
 
-func (s *Store) CreatePartLocation(partID, binID, quantity int) error {
-	_, err := s.db.Exec(
-		`INSERT INTO part_locations (part_id, bin_id, quantity) VALUES (?, ?, ?)`,
+// CreatePartLocation stocks a part in a bin. Like the other quantity
+// changes, it emits the stock webhook events.
+func (s *Store) CreatePartLocation(ctx context.Context, partID, binID, quantity int) (int, error) {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO part_locations (part_id, bin_id, quantity) VALUES (?, ?, ?) RETURNING id`,
 		partID, binID, quantity,
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	after, tracked, err := locationStock(ctx, tx, id)
+	if err != nil {
+		return 0, err
+	}
+	before := after
+	before.Quantity, before.TotalQuantity = 0, after.TotalQuantity-quantity
+	if err := stockEvents(ctx, tx, before, after, tracked); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func (s *Store) UpdatePartLocation(ctx context.Context, locationID, quantity int) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, _, err := locationStock(ctx, tx, locationID)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE part_locations SET quantity = ? WHERE id = ?`, quantity, locationID); err != nil {
+		return err
+	}
+	after, tracked, err := locationStock(ctx, tx, locationID)
+	if err != nil {
+		return err
+	}
+	if err := stockEvents(ctx, tx, before, after, tracked); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdatePartLocationThresholds sets the per-location stock thresholds.
+// A NULL value falls back to the part's own threshold.
+func (s *Store) UpdatePartLocationThresholds(ctx context.Context, locationID int, reorderPoint, minStock sql.NullInt64) error {
+	_, err := s.conn().ExecContext(ctx,
+		`UPDATE part_locations SET reorder_point = ?, min_stock = ? WHERE id = ?`,
+		reorderPoint, minStock, locationID,
 	)
 	return err
 }
 
-func (s *Store) UpdatePartLocation(locationID, quantity int) error {
-	_, err := s.db.Exec(`UPDATE part_locations SET quantity = ? WHERE id = ?`, quantity, locationID)
+// UpdatePartLocationCapacity sets how many of the part fit in the location's bin.
+// A NULL value falls back to the bin's capacity.
+func (s *Store) UpdatePartLocationCapacity(ctx context.Context, locationID int, capacity sql.NullInt64) error {
+	_, err := s.conn().ExecContext(ctx, `UPDATE part_locations SET capacity = ? WHERE id = ?`, capacity, locationID)
 	return err
 }
 
-func (s *Store) DeletePartLocation(locationID int) error {
-	_, err := s.db.Exec(`DELETE FROM part_locations WHERE id = ?`, locationID)
-	return err
+func (s *Store) DeletePartLocation(ctx context.Context, locationID int) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deletePartLocation(ctx, tx, locationID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deletePartLocation deletes a location and emits the stock webhook
+// events for the stock that went with it
+func deletePartLocation(ctx context.Context, q dbtx, locationID int) error {
+	before, tracked, err := locationStock(ctx, q, locationID)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if _, err := q.ExecContext(ctx, `DELETE FROM part_locations WHERE id = ?`, locationID); err != nil {
+		return err
+	}
+	after := before
+	after.Quantity, after.TotalQuantity = 0, before.TotalQuantity-before.Quantity
+	return stockEvents(ctx, q, before, after, tracked)
+}
+
+// deletePartLocations deletes the locations whose IDs the query selects
+// one at a time, so each part's stock events see its running total
+func deletePartLocations(ctx context.Context, q dbtx, query string, args ...any) error {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := deletePartLocation(ctx, q, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateDetachableBins rebuilds the bins table of databases created before
+// bins could be detached, to drop NOT NULL from wled_controller_id.
+// It runs after the bins columns are ensured, so none are lost.
+func migrateDetachableBins(tx *schemaTx) error {
+	_, notNull, err := tx.column("bins", "wled_controller_id")
+	if err != nil || !notNull {
+		return err
+	}
+	log.Println("Migrating bins so they can be detached from their controller...")
+
+	// Dropping the old table doesn't cascade to the stock, because
+	// migrations run with foreign keys off
+	queries := []string{
+		`CREATE TABLE bins_new (
+			id                     INTEGER PRIMARY KEY AUTOINCREMENT,
+			name                   TEXT NOT NULL UNIQUE,
+			wled_controller_id     INTEGER, -- NULL once detached from its controller
+			wled_segment_id        INTEGER NOT NULL,
+			led_index              INTEGER NOT NULL,
+			capacity               INTEGER NOT NULL DEFAULT 0,
+			zone_id                INTEGER REFERENCES zones (id) ON DELETE SET NULL, -- NULL uses the controller's zone
+			shared_led             BOOLEAN NOT NULL DEFAULT 0, -- Deliberately shares its LED with other bins
+			FOREIGN KEY (wled_controller_id) REFERENCES wled_controllers (id)
+		);`,
+		`INSERT INTO bins_new (id, name, wled_controller_id, wled_segment_id, led_index, capacity, zone_id, shared_led)
+		 SELECT id, name, wled_controller_id, wled_segment_id, led_index, capacity, zone_id, shared_led FROM bins;`,
+		`DROP TABLE bins;`,
+		`ALTER TABLE bins_new RENAME TO bins;`,
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -2,12 +2,14 @@ package store
 
 import (
 	"database/sql"
+	"errors"
+	"slices"
 	"testing"
 	"time"
 	"wledger/internal/models"
 )
 
-func createValidPartForBinTest(s *Store) error {
+func createValidPartForBinTest(t *testing.T, s *Store) error {
 	part := &models.Part{
 		Name:          "P1",
 		Description:   sql.NullString{String: "Desc", Valid: true},
@@ -20,20 +22,20 @@ func createValidPartForBinTest(s *Store) error {
 		CreatedAt:     time.Now(),
 		UpdatedAt:     time.Now(),
 	}
-	return s.CreatePart(part)
+	return s.CreatePart(t.Context(), part)
 }
 
 func TestStore_BinCRUD(t *testing.T) {
 	s := newTestStore(t)
-	s.CreateController("C1", "1.1.1.1")
+	s.CreateController(t.Context(), &models.WLEDController{Name: "C1", IPAddress: "1.1.1.1"})
 
 	// Create
-	if err := s.CreateBin("B1", 1, 0, 0); err != nil {
+	if _, err := s.CreateBin(t.Context(), "B1", 1, 0, 0, false); err != nil {
 		t.Fatalf("CreateBin failed: %v", err)
 	}
 
 	// Get All
-	bins, err := s.GetBins()
+	bins, err := s.GetBins(t.Context())
 	if err != nil || len(bins) != 1 {
 		t.Fatalf("GetBins failed")
 	}
@@ -42,26 +44,27 @@ func TestStore_BinCRUD(t *testing.T) {
 	}
 
 	// Get ID
-	b, err := s.GetBinByID(bins[0].ID)
+	b, err := s.GetBinByID(t.Context(), bins[0].ID)
 	if err != nil || b.Name != "B1" {
 		t.Errorf("GetBinByID failed")
 	}
 
 	// Update
 	b.Name = "B1-Updated"
-	if err := s.UpdateBin(&b); err != nil {
+	b.Capacity = 200
+	if err := s.UpdateBin(t.Context(), &b); err != nil {
 		t.Fatalf("UpdateBin failed: %v", err)
 	}
-	b2, _ := s.GetBinByID(b.ID)
-	if b2.Name != "B1-Updated" {
+	b2, _ := s.GetBinByID(t.Context(), b.ID)
+	if b2.Name != "B1-Updated" || b2.Capacity != 200 {
 		t.Errorf("Update failed")
 	}
 
 	// Delete
-	if err := s.DeleteBin(b.ID); err != nil {
+	if err := s.DeleteBin(t.Context(), b.ID); err != nil {
 		t.Fatalf("DeleteBin failed: %v", err)
 	}
-	bins, _ = s.GetBins()
+	bins, _ = s.GetBins(t.Context())
 	if len(bins) != 0 {
 		t.Error("Bin not deleted")
 	}
@@ -69,14 +72,14 @@ func TestStore_BinCRUD(t *testing.T) {
 
 func TestStore_CreateBinsBulk(t *testing.T) {
 	s := newTestStore(t)
-	s.CreateController("C1", "1.1.1.1")
+	s.CreateController(t.Context(), &models.WLEDController{Name: "C1", IPAddress: "1.1.1.1"})
 
 	// Create 5 bins: Test-0 to Test-4
-	if err := s.CreateBinsBulk(1, 0, 5, "Test-"); err != nil {
+	if err := s.CreateBinsBulk(t.Context(), 1, 0, 5, "Test-"); err != nil {
 		t.Fatalf("CreateBinsBulk failed: %v", err)
 	}
 
-	bins, _ := s.GetBins()
+	bins, _ := s.GetBins(t.Context())
 	if len(bins) != 5 {
 		t.Errorf("Expected 5 bins, got %d", len(bins))
 	}
@@ -85,39 +88,155 @@ func TestStore_CreateBinsBulk(t *testing.T) {
 	}
 }
 
+// insertOverlappingBin adds a bin without the LED check, like the
+// overlapping bins of databases from before overlaps were rejected
+func insertOverlappingBin(t *testing.T, s *Store, name string, controllerID, segmentID, ledIndex int) {
+	t.Helper()
+	_, err := s.conn().ExecContext(t.Context(), `INSERT INTO bins (name, wled_controller_id, wled_segment_id, led_index) VALUES (?, ?, ?, ?)`,
+		name, controllerID, segmentID, ledIndex)
+	if err != nil {
+		t.Fatalf("Failed to insert bin %s: %v", name, err)
+	}
+}
+
 func TestStore_BinFlags(t *testing.T) {
 	s := newTestStore(t)
-	s.CreateController("C1", "1.1.1.1")
+	s.CreateController(t.Context(), &models.WLEDController{Name: "C1", IPAddress: "1.1.1.1"})
 
 	// 1. Test Overlap: Create two bins at Segment 0, LED 0
-	s.CreateBin("B1", 1, 0, 0)
-	s.CreateBin("B2", 1, 0, 0)
+	s.CreateBin(t.Context(), "B1", 1, 0, 0, false)
+	insertOverlappingBin(t, s, "B2", 1, 0, 0)
 
-	bins, _ := s.GetBins()
+	bins, _ := s.GetBins(t.Context())
 	// Check Overlap
 	if !bins[0].HasOverlap || !bins[1].HasOverlap {
 		t.Error("Bins should be flagged as overlapping")
 	}
+
+	// The single bin lookup detects it too
+	if b, _ := s.GetBinByID(t.Context(), 1); !b.HasOverlap {
+		t.Error("GetBinByID should flag the overlap")
+	}
+}
+
+func TestStore_LEDConflicts(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateController(t.Context(), &models.WLEDController{Name: "C1", IPAddress: "1.1.1.1"})
+	if _, err := s.CreateBin(t.Context(), "B1", 1, 0, 0, false); err != nil {
+		t.Fatalf("CreateBin failed: %v", err)
+	}
+	s.CreateBin(t.Context(), "B2", 1, 0, 1, false)
+	s.CreateBin(t.Context(), "B4", 1, 0, 3, false)
+
+	// Rejected on create and update
+	if _, err := s.CreateBin(t.Context(), "B3", 1, 0, 0, false); !errors.Is(err, ErrLEDConflict) {
+		t.Errorf("Expected ErrLEDConflict on create, got %v", err)
+	}
+	b2, _ := s.GetBinByID(t.Context(), 2)
+	b2.LEDIndex = 0
+	if err := s.UpdateBin(t.Context(), &b2); !errors.Is(err, ErrLEDConflict) {
+		t.Errorf("Expected ErrLEDConflict on update, got %v", err)
+	}
+	if err := s.CreateBinsBulk(t.Context(), 1, 0, 4, "X"); !errors.Is(err, ErrLEDConflict) {
+		t.Errorf("Expected ErrLEDConflict on bulk create, got %v", err)
+	}
+
+	// Saving a bin on its own LED is fine
+	b1, _ := s.GetBinByID(t.Context(), 1)
+	b1.Capacity = 10
+	if err := s.UpdateBin(t.Context(), &b1); err != nil {
+		t.Errorf("Updating a bin in place failed: %v", err)
+	}
+
+	// The conflict suggests the next free LED, skipping used ones
+	conflict, err := s.GetLEDConflict(t.Context(), models.Bin{WLEDControllerID: 1, WLEDSegmentID: 0, LEDIndex: 0})
+	if err != nil {
+		t.Fatalf("GetLEDConflict failed: %v", err)
+	}
+	if len(conflict.Bins) != 1 || conflict.Bins[0] != "B1" || conflict.NextFree != 2 {
+		t.Errorf("Expected B1 on the LED and LED 2 free, got %+v", conflict)
+	}
+
+	// A shared bin can't take over an LED whose bins aren't shared
+	if _, err := s.CreateBin(t.Context(), "B3", 1, 0, 0, true); !errors.Is(err, ErrLEDConflict) {
+		t.Errorf("Expected ErrLEDConflict sharing with an unshared bin, got %v", err)
+	}
+	if b1, _ := s.GetBinByID(t.Context(), 1); b1.SharedLED {
+		t.Error("The rejected bin marked B1 shared")
+	}
+
+	// Once the LED is shared, another shared bin may join
+	if err := s.ShareLED(t.Context(), 1, 0, 0); err != nil {
+		t.Fatalf("ShareLED failed: %v", err)
+	}
+	if conflict, _ := s.GetLEDConflict(t.Context(), models.Bin{WLEDControllerID: 1, LEDIndex: 0}); !conflict.Shared {
+		t.Errorf("Expected the LED to be shared, got %+v", conflict)
+	}
+	if _, err := s.CreateBin(t.Context(), "B3", 1, 0, 0, true); err != nil {
+		t.Fatalf("Shared CreateBin failed: %v", err)
+	}
+	if _, err := s.CreateBin(t.Context(), "B5", 1, 0, 0, false); !errors.Is(err, ErrLEDConflict) {
+		t.Errorf("Expected ErrLEDConflict for an unshared bin on a shared LED, got %v", err)
+	}
+	bins, _ := s.GetBins(t.Context())
+	for _, b := range bins {
+		if b.HasOverlap {
+			t.Errorf("Bin %s is on a shared LED and should not overlap", b.Name)
+		}
+		if b.LEDIndex == 0 && !b.SharedLED {
+			t.Errorf("Bin %s should be marked shared", b.Name)
+		}
+	}
+}
+
+func TestStore_DetachBin(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateController(t.Context(), &models.WLEDController{Name: "C1", IPAddress: "1.1.1.1"})
+	s.CreateBin(t.Context(), "B1", 1, 0, 0, false)
+	insertOverlappingBin(t, s, "B2", 1, 0, 0)
+
+	// Detaching one of two overlapping bins clears the overlap
+	b, _ := s.GetBinByID(t.Context(), 1)
+	b.WLEDControllerID = 0
+	if err := s.UpdateBin(t.Context(), &b); err != nil {
+		t.Fatalf("UpdateBin failed: %v", err)
+	}
+
+	bins, _ := s.GetBins(t.Context())
+	for _, b := range bins {
+		if b.HasOverlap {
+			t.Errorf("Bin %s should no longer overlap", b.Name)
+		}
+	}
+	if !bins[0].IsDetached || bins[0].IsOrphaned {
+		t.Errorf("B1 should be detached, got %+v", bins[0])
+	}
+
+	// Detached bins are never lit
+	locs, _ := s.GetAllBinLocationsForStopAll(t.Context())
+	if len(locs) != 1 {
+		t.Errorf("Expected only the attached bin's LED, got %d", len(locs))
+	}
 }
 
 func TestStore_GetAvailableBins(t *testing.T) {
 	s := newTestStore(t)
-	s.CreateController("C1", "1.1.1.1")
-	s.CreateBin("B1", 1, 0, 0)
-	s.CreateBin("B2", 1, 0, 1)
+	s.CreateController(t.Context(), &models.WLEDController{Name: "C1", IPAddress: "1.1.1.1"})
+	s.CreateBin(t.Context(), "B1", 1, 0, 0, false)
+	s.CreateBin(t.Context(), "B2", 1, 0, 1, false)
 
 	// Use helper to create valid part
-	if err := createValidPartForBinTest(s); err != nil {
+	if err := createValidPartForBinTest(t, s); err != nil {
 		t.Fatalf("CreatePart failed: %v", err)
 	}
 
 	// Occupy B1
-	if err := s.CreatePartLocation(1, 1, 10); err != nil {
+	if _, err := s.CreatePartLocation(t.Context(), 1, 1, 10); err != nil {
 		t.Fatalf("CreatePartLocation failed: %v", err)
 	}
 
 	// Get Available
-	avail, err := s.GetAvailableBins(1)
+	avail, err := s.GetAvailableBins(t.Context(), 1)
 	if err != nil {
 		t.Fatalf("GetAvailableBins failed: %v", err)
 	}
@@ -132,54 +251,54 @@ func TestStore_GetAvailableBins(t *testing.T) {
 
 func TestStore_Locations(t *testing.T) {
 	s := newTestStore(t)
-	s.CreateController("C1", "1.1.1.1")
-	s.CreateBin("B1", 1, 0, 0)
+	s.CreateController(t.Context(), &models.WLEDController{Name: "C1", IPAddress: "1.1.1.1"})
+	s.CreateBin(t.Context(), "B1", 1, 0, 0, false)
 
-	if err := createValidPartForBinTest(s); err != nil {
+	if err := createValidPartForBinTest(t, s); err != nil {
 		t.Fatalf("CreatePart failed: %v", err)
 	}
 
-	if err := s.CreatePartLocation(1, 1, 10); err != nil {
+	if _, err := s.CreatePartLocation(t.Context(), 1, 1, 10); err != nil {
 		t.Fatalf("CreateLocation failed: %v", err)
 	}
 
-	locs, _ := s.GetPartLocations(1)
+	locs, _ := s.GetPartLocations(t.Context(), 1)
 	if len(locs) != 1 || locs[0].Quantity != 10 {
 		t.Errorf("Location fetch failed")
 	}
 
 	// Get By ID
-	l, err := s.GetPartLocationByID(locs[0].LocationID)
+	l, err := s.GetPartLocationByID(t.Context(), locs[0].LocationID)
 	if err != nil || l.Quantity != 10 {
 		t.Errorf("GetPartLocationByID failed")
 	}
 
-	if err := s.UpdatePartLocation(locs[0].LocationID, 50); err != nil {
+	if err := s.UpdatePartLocation(t.Context(), locs[0].LocationID, 50); err != nil {
 		t.Fatalf("Update failed")
 	}
 
-	if err := s.DeletePartLocation(locs[0].LocationID); err != nil {
+	if err := s.DeletePartLocation(t.Context(), locs[0].LocationID); err != nil {
 		t.Fatalf("Delete failed")
 	}
 }
 
 func TestStore_GetPartNamesInBin(t *testing.T) {
 	s := newTestStore(t)
-	s.CreateController("C1", "1.1.1.1")
-	s.CreateBin("B1", 1, 0, 0)
+	s.CreateController(t.Context(), &models.WLEDController{Name: "C1", IPAddress: "1.1.1.1"})
+	s.CreateBin(t.Context(), "B1", 1, 0, 0, false)
 
 	// Create 2 parts
 	p1 := getValidPart("Resistor")
-	s.CreatePart(p1) // ID 1
+	s.CreatePart(t.Context(), p1) // ID 1
 	p2 := getValidPart("Capacitor")
-	s.CreatePart(p2) // ID 2
+	s.CreatePart(t.Context(), p2) // ID 2
 
 	// Add stock for both in Bin 1
-	s.CreatePartLocation(1, 1, 10)
-	s.CreatePartLocation(2, 1, 5)
+	s.CreatePartLocation(t.Context(), 1, 1, 10)
+	s.CreatePartLocation(t.Context(), 2, 1, 5)
 
 	// Test
-	names, err := s.GetPartNamesInBin(1)
+	names, err := s.GetPartNamesInBin(t.Context(), 1)
 	if err != nil {
 		t.Fatalf("GetPartNamesInBin failed: %v", err)
 	}
@@ -192,3 +311,53 @@ func TestStore_GetPartNamesInBin(t *testing.T) {
 		t.Errorf("Unexpected names or order: %v", names)
 	}
 }
+
+func TestStore_ListBins(t *testing.T) {
+	s := newTestStore(t)
+	zone := &models.Zone{Name: "Bench"}
+	s.CreateZone(t.Context(), zone)
+	s.CreateController(t.Context(), &models.WLEDController{Name: "C1", IPAddress: "1.1.1.1"})
+	s.CreateController(t.Context(), &models.WLEDController{Name: "C2", IPAddress: "2.2.2.2"})
+	c2, _ := s.GetControllerByID(t.Context(), 2)
+	c2.ZoneID = zone.ID
+	s.UpdateController(t.Context(), &c2)
+	s.CreateBin(t.Context(), "A1", 1, 0, 0, false)
+	s.CreateBin(t.Context(), "A_2", 1, 0, 1, false)
+	s.CreateBin(t.Context(), "B1", 2, 0, 2, false)
+	a2, _ := s.GetBinByID(t.Context(), 2)
+	a2.ZoneID = zone.ID
+	s.UpdateBin(t.Context(), &a2)
+
+	tests := []struct {
+		name   string
+		filter models.BinFilter
+		want   []string
+		total  int
+	}{
+		{"all", models.BinFilter{}, []string{"A1", "A_2", "B1"}, 3},
+		{"page", models.BinFilter{Limit: 1, Offset: 1}, []string{"A_2"}, 3},
+		{"search literal", models.BinFilter{Search: "a_"}, []string{"A_2"}, 1},
+		{"controller", models.BinFilter{ControllerID: 1, Limit: 1}, []string{"A1"}, 2},
+		{"own zone", models.BinFilter{ZoneID: zone.ID}, []string{"A_2"}, 1},
+	}
+	for _, tc := range tests {
+		bins, total, err := s.ListBins(t.Context(), tc.filter)
+		if err != nil {
+			t.Fatalf("%s: ListBins failed: %v", tc.name, err)
+		}
+		names := []string{}
+		for _, b := range bins {
+			names = append(names, b.Name)
+		}
+		if total != tc.total || !slices.Equal(names, tc.want) {
+			t.Errorf("%s: got %v of %d, want %v of %d", tc.name, names, total, tc.want, tc.total)
+		}
+	}
+
+	// Overlaps are found on a page without the other bin
+	insertOverlappingBin(t, s, "A3", 1, 0, 0)
+	bins, _, _ := s.ListBins(t.Context(), models.BinFilter{Search: "A1"})
+	if len(bins) != 1 || !bins[0].HasOverlap {
+		t.Errorf("Expected A1 to overlap A3, got %+v", bins)
+	}
+}
@@ -1,28 +1,63 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"log"
-	"wledger/internal/models"
 
-	"modernc.org/sqlite"
-	sqlitelib "modernc.org/sqlite/lib"
+	"wledger/internal/endpoint"
+	"wledger/internal/models"
 )
 
 // Controller Methods
-func (s *Store) GetControllers() ([]models.WLEDController, error) {
+func (s *Store) GetControllers(ctx context.Context) ([]models.WLEDController, error) {
+	controllers, _, err := s.ListControllers(ctx, models.ControllerFilter{})
+	return controllers, err
+}
+
+// ListControllers returns a page of the controllers the filter matches,
+// and how many match in all
+func (s *Store) ListControllers(ctx context.Context, f models.ControllerFilter) ([]models.WLEDController, int, error) {
+	where := ` WHERE 1 = 1`
+	args := []any{}
+	if f.Search != "" {
+		search := "%" + escapeLike(f.Search) + "%"
+		where += ` AND (LOWER(c.name) LIKE LOWER(?) ESCAPE '\' OR LOWER(c.ip_address) LIKE LOWER(?) ESCAPE '\')`
+		args = append(args, search, search)
+	}
+	if f.Status != "" {
+		where += ` AND LOWER(c.status) = LOWER(?)`
+		args = append(args, f.Status)
+	}
+	if f.ZoneID != 0 {
+		where += ` AND c.zone_id = ?`
+		args = append(args, f.ZoneID)
+	}
+
+	var total int
+	err := s.conn().QueryRowContext(ctx, `SELECT COUNT(*) FROM wled_controllers c`+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
 	// LEFT JOIN to count bins associated with each controller
 	query := `
-		SELECT c.id, c.name, c.ip_address, c.status, c.last_seen, COUNT(b.id) as bin_count
+		SELECT c.id, c.name, c.ip_address, c.status, c.last_seen, c.restore_state,
+		       COALESCE(c.zone_id, 0), z.name, COUNT(b.id) as bin_count,
+		       c.scheme, c.host, c.port, c.base_path, c.username, c.password
 		FROM wled_controllers c
 		LEFT JOIN bins b ON c.id = b.wled_controller_id
-		GROUP BY c.id
-		ORDER BY c.name ASC;
-	`
-	rows, err := s.db.Query(query)
+		LEFT JOIN zones z ON c.zone_id = z.id` + where + `
+		GROUP BY c.id, z.name
+		ORDER BY c.name ASC, c.id ASC`
+	if f.Limit > 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, f.Limit, f.Offset)
+	}
+	rows, err := s.conn().QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
@@ -31,7 +66,8 @@ func (s *Store) GetControllers() ([]models.WLEDController, error) {
 		var c models.WLEDController
 		var lastSeenStr sql.NullString
 
-		err := rows.Scan(&c.ID, &c.Name, &c.IPAddress, &c.Status, &c.LastSeen, &c.BinCount)
+		err := rows.Scan(&c.ID, &c.Name, &c.IPAddress, &c.Status, &c.LastSeen, &c.RestoreState, &c.ZoneID, &c.ZoneName, &c.BinCount,
+			&c.Endpoint.Scheme, &c.Endpoint.Host, &c.Endpoint.Port, &c.Endpoint.BasePath, &c.Endpoint.Username, &c.Endpoint.Password)
 		if err != nil {
 			log.Println("Error scanning controller row:", err)
 			continue
@@ -47,23 +83,27 @@ func (s *Store) GetControllers() ([]models.WLEDController, error) {
 
 		controllers = append(controllers, c)
 	}
-	return controllers, nil
+	return controllers, total, nil
 }
 
-func (s *Store) GetControllerByID(id int) (models.WLEDController, error) {
+func (s *Store) GetControllerByID(ctx context.Context, id int) (models.WLEDController, error) {
 	var c models.WLEDController
 	var lastSeenStr sql.NullString
 
 	query := `
-		SELECT c.id, c.name, c.ip_address, c.status, c.last_seen, COUNT(b.id) as bin_count
+		SELECT c.id, c.name, c.ip_address, c.status, c.last_seen, c.restore_state,
+		       COALESCE(c.zone_id, 0), z.name, COUNT(b.id) as bin_count,
+		       c.scheme, c.host, c.port, c.base_path, c.username, c.password
 		FROM wled_controllers c
 		LEFT JOIN bins b ON c.id = b.wled_controller_id
+		LEFT JOIN zones z ON c.zone_id = z.id
 		WHERE c.id = ?
-		GROUP BY c.id;
+		GROUP BY c.id, z.name;
 	`
-	row := s.db.QueryRow(query, id)
+	row := s.conn().QueryRowContext(ctx, query, id)
 
-	err := row.Scan(&c.ID, &c.Name, &c.IPAddress, &c.Status, &lastSeenStr, &c.BinCount)
+	err := row.Scan(&c.ID, &c.Name, &c.IPAddress, &c.Status, &lastSeenStr, &c.RestoreState, &c.ZoneID, &c.ZoneName, &c.BinCount,
+		&c.Endpoint.Scheme, &c.Endpoint.Host, &c.Endpoint.Port, &c.Endpoint.BasePath, &c.Endpoint.Username, &c.Endpoint.Password)
 	if err != nil {
 		return c, err
 	}
@@ -80,41 +120,121 @@ func (s *Store) GetControllerByID(id int) (models.WLEDController, error) {
 	return c, nil
 }
 
-func (s *Store) CreateController(name, ipAddress string) error {
-	_, err := s.db.Exec(
-		`INSERT INTO wled_controllers (name, ip_address, status) VALUES (?, ?, 'unknown')`,
-		name, ipAddress,
-	)
-	return err
+// normalizeEndpoint fills in the controller's endpoint from its address if
+// only the address is set, and its address from the endpoint otherwise
+func normalizeEndpoint(c *models.WLEDController) error {
+	if c.Endpoint.Host == "" {
+		e, err := endpoint.Parse(c.IPAddress)
+		if err != nil {
+			return err
+		}
+		c.Endpoint = e
+	}
+	c.IPAddress = endpoint.Address(c.Endpoint)
+	return nil
 }
 
-func (s *Store) UpdateController(c *models.WLEDController) error {
-	_, err := s.db.Exec(
-		`UPDATE wled_controllers SET name = ?, ip_address = ? WHERE id = ?`,
-		c.Name, c.IPAddress, c.ID,
+func (s *Store) CreateController(ctx context.Context, c *models.WLEDController) error {
+	if err := normalizeEndpoint(c); err != nil {
+		return err
+	}
+	e := c.Endpoint
+	err := s.conn().QueryRowContext(ctx,
+		`INSERT INTO wled_controllers (name, ip_address, status, scheme, host, port, base_path, username, password)
+		 VALUES (?, ?, 'unknown', ?, ?, ?, ?, ?, ?) RETURNING id`,
+		c.Name, c.IPAddress, e.Scheme, e.Host, e.Port, e.BasePath, e.Username, e.Password,
+	).Scan(&c.ID)
+	return controllerError(err)
+}
+
+func (s *Store) UpdateController(ctx context.Context, c *models.WLEDController) error {
+	if err := normalizeEndpoint(c); err != nil {
+		return err
+	}
+	e := c.Endpoint
+	_, err := s.conn().ExecContext(ctx,
+		`UPDATE wled_controllers
+		 SET name = ?, ip_address = ?, restore_state = ?, zone_id = NULLIF(?, 0),
+		     scheme = ?, host = ?, port = ?, base_path = ?, username = ?, password = ?
+		 WHERE id = ?`,
+		c.Name, c.IPAddress, c.RestoreState, c.ZoneID,
+		e.Scheme, e.Host, e.Port, e.BasePath, e.Username, e.Password, c.ID,
 	)
+	return controllerError(err)
+}
+
+// GetControllerCredentials returns the basic auth credentials of the
+// controller at an address, or empty strings if it has none
+func (s *Store) GetControllerCredentials(ctx context.Context, address string) (username, password string, err error) {
+	err = s.conn().QueryRowContext(ctx,
+		`SELECT username, password FROM wled_controllers WHERE ip_address = ?`, address,
+	).Scan(&username, &password)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", nil
+	}
+	return username, password, err
+}
+
+// controllerError maps a duplicate address to ErrUniqueConstraint
+func controllerError(err error) error {
+	if isUniqueViolation(err) {
+		return ErrUniqueConstraint
+	}
 	return err
 }
 
-func (s *Store) DeleteController(id int) error {
-	_, err := s.db.Exec(`DELETE FROM wled_controllers WHERE id = ?`, id)
+// migrateControllerEndpoints splits the addresses of controllers created
+// before endpoints were structured, e.g. "wled-a.local:8080", into their parts
+func migrateControllerEndpoints(tx *schemaTx) error {
+	rows, err := tx.Query(`SELECT id, ip_address FROM wled_controllers WHERE host = ''`)
 	if err != nil {
-		// Check if the error is a *pointer* to a sqlite.Error
-		sqliteErr, ok := err.(*sqlite.Error)
-		if ok {
-			// Check if the specific error code is a foreign key constraint
-			if sqliteErr.Code() == sqlitelib.SQLITE_CONSTRAINT_FOREIGNKEY {
-				return ErrForeignKeyConstraint
-			}
+		return err
+	}
+	defer rows.Close()
+
+	controllers := []models.WLEDController{}
+	for rows.Next() {
+		var c models.WLEDController
+		if err := rows.Scan(&c.ID, &c.IPAddress); err != nil {
+			return err
 		}
-		// Return other errors
+		controllers = append(controllers, c)
+	}
+	if err := rows.Err(); err != nil {
 		return err
 	}
+	rows.Close()
+
+	for _, c := range controllers {
+		if err := normalizeEndpoint(&c); err != nil {
+			// Keep the address as the host, so the controller can still be edited
+			log.Printf("Controller %d has an invalid address %q, please edit it: %v", c.ID, c.IPAddress, err)
+			c.Endpoint = models.WLEDEndpoint{Scheme: "http", Host: c.IPAddress}
+		}
+		e := c.Endpoint
+		_, err := tx.Exec(
+			`UPDATE wled_controllers
+			 SET ip_address = ?, scheme = ?, host = ?, port = ?, base_path = ?, username = ?, password = ?
+			 WHERE id = ?`,
+			c.IPAddress, e.Scheme, e.Host, e.Port, e.BasePath, e.Username, e.Password, c.ID,
+		)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (s *Store) GetAllControllersForHealthCheck() ([]models.WLEDController, error) {
-	rows, err := s.db.Query(`SELECT id, ip_address FROM wled_controllers`)
+func (s *Store) DeleteController(ctx context.Context, id int) error {
+	_, err := s.conn().ExecContext(ctx, `DELETE FROM wled_controllers WHERE id = ?`, id)
+	if isForeignKeyViolation(err) {
+		return ErrForeignKeyConstraint
+	}
+	return err
+}
+
+func (s *Store) GetAllControllersForHealthCheck(ctx context.Context) ([]models.WLEDController, error) {
+	rows, err := s.conn().QueryContext(ctx, `SELECT id, name, ip_address, status FROM wled_controllers`)
 	if err != nil {
 		return nil, err
 	}
@@ -123,7 +243,7 @@ func (s *Store) GetAllControllersForHealthCheck() ([]models.WLEDController, erro
 	controllers := []models.WLEDController{}
 	for rows.Next() {
 		var c models.WLEDController
-		if err := rows.Scan(&c.ID, &c.IPAddress); err != nil {
+		if err := rows.Scan(&c.ID, &c.Name, &c.IPAddress, &c.Status); err != nil {
 			return nil, err
 		}
 		controllers = append(controllers, c)
@@ -131,45 +251,167 @@ func (s *Store) GetAllControllersForHealthCheck() ([]models.WLEDController, erro
 	return controllers, nil
 }
 
-func (s *Store) UpdateControllerStatus(id int, status string, lastSeen sql.NullTime) error {
+// GetStateRestoreIPs returns the IP addresses of the controllers
+// that have their state restored after locating
+func (s *Store) GetStateRestoreIPs(ctx context.Context) (map[string]bool, error) {
+	rows, err := s.conn().QueryContext(ctx, `SELECT ip_address FROM wled_controllers WHERE restore_state = TRUE`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ips := make(map[string]bool)
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return nil, err
+		}
+		ips[ip] = true
+	}
+	return ips, rows.Err()
+}
+
+func (s *Store) UpdateControllerStatus(ctx context.Context, id int, status string, lastSeen sql.NullTime) error {
 	if status == "online" {
-		_, err := s.db.Exec(
+		_, err := s.conn().ExecContext(ctx,
 			`UPDATE wled_controllers SET status = ?, last_seen = ? WHERE id = ?`,
 			status, lastSeen, id,
 		)
 		return err
 	}
-	_, err := s.db.Exec(
+	_, err := s.conn().ExecContext(ctx,
 		`UPDATE wled_controllers SET status = ? WHERE id = ?`,
 		status, id,
 	)
 	return err
 }
 
-func (s *Store) MigrateBins(oldControllerID, newControllerID int) error {
+// MigrateBins moves all bins of one controller to another. The offsets are
+// added to the bins' segment IDs and LED indexes, for when the new controller
+// has its LEDs wired differently.
+func (s *Store) MigrateBins(ctx context.Context, oldControllerID, newControllerID, segmentOffset, ledOffset int) error {
 	// We use a transaction to ensure safety
-	tx, err := s.db.Begin()
+	tx, err := s.begin(ctx)
 	if err != nil {
 		return err
 	}
 
+	if err := migrateBins(ctx, tx, oldControllerID, newControllerID, segmentOffset, ledOffset); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateBins(ctx context.Context, tx dbtx, oldControllerID, newControllerID, segmentOffset, ledOffset int) error {
+	if oldControllerID == newControllerID {
+		return errors.New("target controller is the same controller")
+	}
+
 	// Verify the new controller exists (to prevent stranding bins)
 	var exists int
-	err = tx.QueryRow("SELECT 1 FROM wled_controllers WHERE id = ?", newControllerID).Scan(&exists)
+	err := tx.QueryRowContext(ctx, "SELECT 1 FROM wled_controllers WHERE id = ?", newControllerID).Scan(&exists)
 	if err != nil {
-		tx.Rollback()
 		return errors.New("target controller does not exist")
 	}
 
+	// Negative offsets can't move any bin below 0
+	var below int
+	err = tx.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM bins
+		 WHERE wled_controller_id = ? AND (wled_segment_id + ? < 0 OR led_index + ? < 0)`,
+		oldControllerID, segmentOffset, ledOffset,
+	).Scan(&below)
+	if err != nil {
+		return err
+	}
+	if below > 0 {
+		return ErrInvalidOffset
+	}
+
+	// Every bin has to fit on the new controller's LEDs, the moved bins
+	// keep their places among each other
+	moved, err := binsOnController(ctx, tx, oldControllerID)
+	if err != nil {
+		return err
+	}
+	for _, b := range moved {
+		b.WLEDSegmentID += segmentOffset
+		b.LEDIndex += ledOffset
+		others, err := binsOnLED(ctx, tx, newControllerID, b.WLEDSegmentID, b.LEDIndex, b.ID)
+		if err != nil {
+			return err
+		}
+		if ledTaken(b, others) {
+			return ErrLEDConflict
+		}
+	}
+
 	// Move the bins
-	_, err = tx.Exec(
-		`UPDATE bins SET wled_controller_id = ? WHERE wled_controller_id = ?`,
-		newControllerID, oldControllerID,
+	_, err = tx.ExecContext(ctx,
+		`UPDATE bins SET wled_controller_id = ?, wled_segment_id = wled_segment_id + ?, led_index = led_index + ?
+		 WHERE wled_controller_id = ?`,
+		newControllerID, segmentOffset, ledOffset, oldControllerID,
 	)
+	return err
+}
+
+// deleteControllerStock deletes the stock in a controller's bins
+func deleteControllerStock(ctx context.Context, q dbtx, controllerID int) error {
+	return deletePartLocations(ctx, q,
+		`SELECT pl.id FROM part_locations pl
+		 JOIN bins b ON pl.bin_id = b.id
+		 WHERE b.wled_controller_id = ?
+		 ORDER BY pl.id`, controllerID)
+}
+
+// GetControllerStock returns how many items are stocked in the controller's bins
+func (s *Store) GetControllerStock(ctx context.Context, id int) (int, error) {
+	var items int
+	err := s.conn().QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(pl.quantity), 0)
+		 FROM part_locations pl
+		 JOIN bins b ON pl.bin_id = b.id
+		 WHERE b.wled_controller_id = ?`, id,
+	).Scan(&items)
+	return items, err
+}
+
+// DeleteControllerWithBins deletes a controller after moving, detaching or
+// deleting its bins, all or nothing
+func (s *Store) DeleteControllerWithBins(ctx context.Context, id int, d models.BinDisposal) error {
+	tx, err := s.begin(ctx)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
+	defer tx.Rollback()
 
+	switch d.Action {
+	case models.BinsMove:
+		err = migrateBins(ctx, tx, id, d.TargetControllerID, d.SegmentOffset, d.LEDOffset)
+	case models.BinsDetach:
+		_, err = tx.ExecContext(ctx, `UPDATE bins SET wled_controller_id = NULL WHERE wled_controller_id = ?`, id)
+	case models.BinsDelete:
+		// Stock goes first, so its stock events are emitted
+		err = deleteControllerStock(ctx, tx, id)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, `DELETE FROM bins WHERE wled_controller_id = ?`, id)
+		}
+	default:
+		err = errors.New("unknown bin disposal: " + d.Action)
+	}
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM wled_controllers WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
 	return tx.Commit()
 }
@@ -2,6 +2,8 @@ package store
 
 import (
 	"database/sql"
+	"errors"
+	"slices"
 	"testing"
 	"time"
 
@@ -12,24 +14,25 @@ func TestStore_UpdateController(t *testing.T) {
 	s := newTestStore(t)
 
 	// Create Controller
-	err := s.CreateController("Old Name", "1.1.1.1")
+	err := s.CreateController(t.Context(), &models.WLEDController{Name: "Old Name", IPAddress: "1.1.1.1"})
 	if err != nil {
 		t.Fatalf("CreateController failed: %v", err)
 	}
 
 	// Update it
 	updated := &models.WLEDController{
-		ID:        1,
-		Name:      "New Name",
-		IPAddress: "2.2.2.2",
+		ID:           1,
+		Name:         "New Name",
+		IPAddress:    "2.2.2.2",
+		RestoreState: true,
 	}
-	err = s.UpdateController(updated)
+	err = s.UpdateController(t.Context(), updated)
 	if err != nil {
 		t.Fatalf("UpdateController failed: %v", err)
 	}
 
 	// Verify
-	got, err := s.GetControllerByID(1)
+	got, err := s.GetControllerByID(t.Context(), 1)
 	if err != nil {
 		t.Fatalf("GetControllerByID failed: %v", err)
 	}
@@ -39,17 +42,28 @@ func TestStore_UpdateController(t *testing.T) {
 	if got.IPAddress != "2.2.2.2" {
 		t.Errorf("got ip %q, want %q", got.IPAddress, "2.2.2.2")
 	}
+	if !got.RestoreState {
+		t.Errorf("Expected state restore to be enabled")
+	}
+
+	ips, err := s.GetStateRestoreIPs(t.Context())
+	if err != nil {
+		t.Fatalf("GetStateRestoreIPs failed: %v", err)
+	}
+	if !ips["2.2.2.2"] || len(ips) != 1 {
+		t.Errorf("got restore IPs %v, want only 2.2.2.2", ips)
+	}
 }
 
 func TestStore_DeleteController_InUse(t *testing.T) {
 	s := newTestStore(t)
 
 	// Create Controller and Bin that uses it
-	s.CreateController("C1", "1.1.1.1")
-	s.CreateBin("B1", 1, 0, 0)
+	s.CreateController(t.Context(), &models.WLEDController{Name: "C1", IPAddress: "1.1.1.1"})
+	s.CreateBin(t.Context(), "B1", 1, 0, 0, false)
 
 	// Attempt Delete
-	err := s.DeleteController(1)
+	err := s.DeleteController(t.Context(), 1)
 
 	// Assert Foreign Key Error
 	if err != ErrForeignKeyConstraint {
@@ -61,48 +75,177 @@ func TestStore_MigrateBins(t *testing.T) {
 	s := newTestStore(t)
 
 	// Create Controllers
-	s.CreateController("Source", "1.1.1.1")
-	s.CreateController("Target", "2.2.2.2")
+	s.CreateController(t.Context(), &models.WLEDController{Name: "Source", IPAddress: "1.1.1.1"})
+	s.CreateController(t.Context(), &models.WLEDController{Name: "Target", IPAddress: "2.2.2.2"})
 
 	// Create Bin on Source
-	s.CreateBin("B1", 1, 0, 0)
+	s.CreateBin(t.Context(), "B1", 1, 0, 0, false)
 
 	// Migrate
-	if err := s.MigrateBins(1, 2); err != nil {
+	if err := s.MigrateBins(t.Context(), 1, 2, 0, 0); err != nil {
 		t.Fatalf("Migrate failed: %v", err)
 	}
 
 	// Verify Target has the bin
-	c2, _ := s.GetControllerByID(2)
+	c2, _ := s.GetControllerByID(t.Context(), 2)
 	if c2.BinCount != 1 {
 		t.Errorf("Target bin count mismatch: got %d, want 1", c2.BinCount)
 	}
 
 	// Verify Source is empty
-	c1, _ := s.GetControllerByID(1)
+	c1, _ := s.GetControllerByID(t.Context(), 1)
 	if c1.BinCount != 0 {
 		t.Errorf("Source bin count mismatch: got %d, want 0", c1.BinCount)
 	}
 }
 
+func TestStore_MigrateBins_Offsets(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateController(t.Context(), &models.WLEDController{Name: "Source", IPAddress: "1.1.1.1"})
+	s.CreateController(t.Context(), &models.WLEDController{Name: "Target", IPAddress: "2.2.2.2"})
+	s.CreateBin(t.Context(), "B1", 1, 0, 2, false)
+	s.CreateBin(t.Context(), "B2", 1, 1, 5, false)
+
+	// Would move B1 to LED -1
+	if err := s.MigrateBins(t.Context(), 1, 2, 0, -3); !errors.Is(err, ErrInvalidOffset) {
+		t.Fatalf("Expected ErrInvalidOffset, got %v", err)
+	}
+	if b, _ := s.GetBinByID(t.Context(), 1); b.WLEDControllerID != 1 || b.LEDIndex != 2 {
+		t.Errorf("Bins should be untouched after a rejected migration, got %+v", b)
+	}
+
+	if err := s.MigrateBins(t.Context(), 1, 2, 1, 10); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	b1, _ := s.GetBinByID(t.Context(), 1)
+	b2, _ := s.GetBinByID(t.Context(), 2)
+	if b1.WLEDControllerID != 2 || b1.WLEDSegmentID != 1 || b1.LEDIndex != 12 {
+		t.Errorf("B1 remapped wrong: %+v", b1)
+	}
+	if b2.WLEDSegmentID != 2 || b2.LEDIndex != 15 {
+		t.Errorf("B2 remapped wrong: %+v", b2)
+	}
+
+	if err := s.MigrateBins(t.Context(), 2, 2, 0, 0); err == nil {
+		t.Error("Expected an error migrating a controller to itself")
+	}
+}
+
+func TestStore_MigrateBins_LEDConflict(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateController(t.Context(), &models.WLEDController{Name: "Source", IPAddress: "1.1.1.1"})
+	s.CreateController(t.Context(), &models.WLEDController{Name: "Target", IPAddress: "2.2.2.2"})
+	s.CreateBin(t.Context(), "B1", 1, 0, 2, false)
+	s.CreateBin(t.Context(), "B2", 1, 0, 3, true)
+	s.CreateBin(t.Context(), "T1", 2, 1, 13, true)
+
+	// B1 would land on T1's LED after the offsets, and isn't shared
+	if err := s.MigrateBins(t.Context(), 1, 2, 1, 11); !errors.Is(err, ErrLEDConflict) {
+		t.Fatalf("Expected ErrLEDConflict, got %v", err)
+	}
+	err := s.DeleteControllerWithBins(t.Context(), 1, models.BinDisposal{Action: models.BinsMove, TargetControllerID: 2, SegmentOffset: 1, LEDOffset: 11})
+	if !errors.Is(err, ErrLEDConflict) {
+		t.Fatalf("Expected ErrLEDConflict deleting the controller, got %v", err)
+	}
+	if c, err := s.GetControllerByID(t.Context(), 1); err != nil || c.BinCount != 2 {
+		t.Errorf("Expected the source controller and its bins to be untouched, got %+v, %v", c, err)
+	}
+
+	// B2 and T1 are both shared, so B2 may join T1's LED
+	if err := s.MigrateBins(t.Context(), 1, 2, 1, 10); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if b, _ := s.GetBinByID(t.Context(), 2); b.WLEDControllerID != 2 || b.LEDIndex != 13 || b.HasOverlap {
+		t.Errorf("B2 should share T1's LED, got %+v", b)
+	}
+}
+
+func TestStore_DeleteControllerWithBins(t *testing.T) {
+	setup := func(t *testing.T) *Store {
+		s := newTestStore(t)
+		s.CreateController(t.Context(), &models.WLEDController{Name: "Old", IPAddress: "1.1.1.1"})
+		s.CreateController(t.Context(), &models.WLEDController{Name: "New", IPAddress: "2.2.2.2"})
+		s.CreateBin(t.Context(), "B1", 1, 0, 0, false)
+		s.CreatePart(t.Context(), getValidPart("Resistor"))
+		s.CreatePartLocation(t.Context(), 1, 1, 25)
+		return s
+	}
+
+	t.Run("Move", func(t *testing.T) {
+		s := setup(t)
+		if items, _ := s.GetControllerStock(t.Context(), 1); items != 25 {
+			t.Errorf("Expected 25 items on the old controller, got %d", items)
+		}
+		err := s.DeleteControllerWithBins(t.Context(), 1, models.BinDisposal{Action: models.BinsMove, TargetControllerID: 2, LEDOffset: 4})
+		if err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		b, _ := s.GetBinByID(t.Context(), 1)
+		if b.WLEDControllerID != 2 || b.LEDIndex != 4 {
+			t.Errorf("Bin should be on the new controller at LED 4, got %+v", b)
+		}
+		if _, err := s.GetControllerByID(t.Context(), 1); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("Controller should be deleted, got %v", err)
+		}
+	})
+
+	t.Run("Detach", func(t *testing.T) {
+		s := setup(t)
+		if err := s.DeleteControllerWithBins(t.Context(), 1, models.BinDisposal{Action: models.BinsDetach}); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		b, _ := s.GetBinByID(t.Context(), 1)
+		if !b.IsDetached || b.IsOrphaned {
+			t.Errorf("Bin should be detached, got %+v", b)
+		}
+		if p, _ := s.GetPartByID(t.Context(), 1); p.TotalQuantity != 25 {
+			t.Errorf("Detaching should keep the stock, got %d", p.TotalQuantity)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		s := setup(t)
+		if err := s.DeleteControllerWithBins(t.Context(), 1, models.BinDisposal{Action: models.BinsDelete}); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if _, err := s.GetBinByID(t.Context(), 1); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("Bin should be deleted, got %v", err)
+		}
+		if p, _ := s.GetPartByID(t.Context(), 1); p.TotalQuantity != 0 {
+			t.Errorf("Stock should be deleted, got %d", p.TotalQuantity)
+		}
+	})
+
+	t.Run("Failed Move Keeps Controller", func(t *testing.T) {
+		s := setup(t)
+		err := s.DeleteControllerWithBins(t.Context(), 1, models.BinDisposal{Action: models.BinsMove, TargetControllerID: 99})
+		if err == nil {
+			t.Fatal("Expected an error moving to a missing controller")
+		}
+		if _, err := s.GetControllerByID(t.Context(), 1); err != nil {
+			t.Errorf("Controller should still exist: %v", err)
+		}
+	})
+}
+
 func TestStore_HealthCheck(t *testing.T) {
 	s := newTestStore(t)
-	s.CreateController("C1", "1.1.1.1")
+	s.CreateController(t.Context(), &models.WLEDController{Name: "C1", IPAddress: "1.1.1.1"})
 
 	// Test GetAll
-	ctrls, err := s.GetAllControllersForHealthCheck()
+	ctrls, err := s.GetAllControllersForHealthCheck(t.Context())
 	if err != nil || len(ctrls) != 1 {
 		t.Fatalf("GetAllControllersForHealthCheck failed")
 	}
 
 	// Test Update Status
 	now := sql.NullTime{Time: time.Now(), Valid: true}
-	if err := s.UpdateControllerStatus(1, "online", now); err != nil {
+	if err := s.UpdateControllerStatus(t.Context(), 1, "online", now); err != nil {
 		t.Fatalf("UpdateControllerStatus failed: %v", err)
 	}
 
 	// Verify Update
-	c, _ := s.GetControllerByID(1)
+	c, _ := s.GetControllerByID(t.Context(), 1)
 	if c.Status != "online" {
 		t.Errorf("Status not updated")
 	}
@@ -110,3 +253,94 @@ func TestStore_HealthCheck(t *testing.T) {
 		t.Errorf("LastSeen not updated")
 	}
 }
+
+func TestStore_ControllerEndpoints(t *testing.T) {
+	s := newTestStore(t)
+
+	// A structured endpoint behind an HTTPS proxy
+	proxied := &models.WLEDController{Name: "Cabinet C", Endpoint: models.WLEDEndpoint{
+		Scheme: "https", Host: "proxy.example", BasePath: "/wled/c", Username: "admin", Password: "s3cret",
+	}}
+	if err := s.CreateController(t.Context(), proxied); err != nil {
+		t.Fatalf("CreateController failed: %v", err)
+	}
+	got, err := s.GetControllerByID(t.Context(), proxied.ID)
+	if err != nil {
+		t.Fatalf("GetControllerByID failed: %v", err)
+	}
+	if got.IPAddress != "https://proxy.example/wled/c" || got.Endpoint != proxied.Endpoint {
+		t.Errorf("Unexpected controller: %+v", got)
+	}
+
+	user, pass, err := s.GetControllerCredentials(t.Context(), "https://proxy.example/wled/c")
+	if err != nil || user != "admin" || pass != "s3cret" {
+		t.Errorf("Expected the credentials, got %q %q (%v)", user, pass, err)
+	}
+	if user, _, _ := s.GetControllerCredentials(t.Context(), "10.9.9.9"); user != "" {
+		t.Errorf("Expected no credentials for an unknown address")
+	}
+
+	// The same address twice
+	err = s.CreateController(t.Context(), &models.WLEDController{Name: "Again", IPAddress: "https://proxy.example:443/wled/c/"})
+	if !errors.Is(err, ErrUniqueConstraint) {
+		t.Errorf("Expected ErrUniqueConstraint, got %v", err)
+	}
+
+	// Rows from before endpoints were structured are split up on startup
+	s.db.Exec(`INSERT INTO wled_controllers (name, ip_address) VALUES ('Legacy', 'wled-a.local:8080')`)
+	tx, _ := s.db.Begin()
+	if err := migrateControllerEndpoints(&schemaTx{Tx: tx, dialect: s.dialect}); err != nil {
+		t.Fatalf("migrateControllerEndpoints failed: %v", err)
+	}
+	tx.Commit()
+	controllers, _ := s.GetControllers(t.Context())
+	var legacy models.WLEDController
+	for _, c := range controllers {
+		if c.Name == "Legacy" {
+			legacy = c
+		}
+	}
+	want := models.WLEDEndpoint{Scheme: "http", Host: "wled-a.local", Port: 8080}
+	if legacy.Endpoint != want || legacy.IPAddress != "wled-a.local:8080" {
+		t.Errorf("Legacy controller not migrated: %+v", legacy)
+	}
+}
+
+func TestStore_ListControllers(t *testing.T) {
+	s := newTestStore(t)
+	zone := &models.Zone{Name: "Wall"}
+	s.CreateZone(t.Context(), zone)
+	s.CreateController(t.Context(), &models.WLEDController{Name: "Shelf", IPAddress: "10.0.0.1"})
+	s.CreateController(t.Context(), &models.WLEDController{Name: "Wall", IPAddress: "10.0.0.2"})
+	s.CreateController(t.Context(), &models.WLEDController{Name: "Cabinet", IPAddress: "192.168.1.5"})
+	s.UpdateControllerStatus(t.Context(), 2, "offline", sql.NullTime{})
+	wall, _ := s.GetControllerByID(t.Context(), 2)
+	wall.ZoneID = zone.ID
+	s.UpdateController(t.Context(), &wall)
+
+	tests := []struct {
+		name   string
+		filter models.ControllerFilter
+		want   []string
+		total  int
+	}{
+		{"all", models.ControllerFilter{}, []string{"Cabinet", "Shelf", "Wall"}, 3},
+		{"page", models.ControllerFilter{Limit: 2, Offset: 2}, []string{"Wall"}, 3},
+		{"search address", models.ControllerFilter{Search: "10.0.0"}, []string{"Shelf", "Wall"}, 2},
+		{"status", models.ControllerFilter{Status: "OFFLINE"}, []string{"Wall"}, 1},
+		{"zone", models.ControllerFilter{ZoneID: zone.ID}, []string{"Wall"}, 1},
+	}
+	for _, tc := range tests {
+		controllers, total, err := s.ListControllers(t.Context(), tc.filter)
+		if err != nil {
+			t.Fatalf("%s: ListControllers failed: %v", tc.name, err)
+		}
+		names := []string{}
+		for _, c := range controllers {
+			names = append(names, c.Name)
+		}
+		if total != tc.total || !slices.Equal(names, tc.want) {
+			t.Errorf("%s: got %v of %d, want %v of %d", tc.name, names, total, tc.want, tc.total)
+		}
+	}
+}
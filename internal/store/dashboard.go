@@ -1,19 +1,42 @@
 package store
 
 import (
+	"context"
+	"database/sql"
+	"strings"
 	"wledger/internal/models"
 )
 
-func (s *Store) GetDashboardBinData() ([]models.DashboardBinData, error) {
+// categorySeparator joins a part's categories, it can't be in a name
+const categorySeparator = "\x1f"
+
+func (s *Store) GetDashboardBinData(ctx context.Context, filter models.StockStatusFilter) ([]models.DashboardBinData, error) {
 	// This query gets the individual quantity for every bin
-	// that belongs to a part with stock tracking enabled.
+	// that belongs to a part with stock tracking enabled,
+	// along with the part's total and any per-location thresholds.
 	// Used by the dashboard to show which bins are below
 	// reorder point or minimum stock.
 	query := `
 		SELECT 
+			p.id,
+			p.name,
+			p.part_number,
+			p.manufacturer,
+			p.supplier,
+			p.status,
+			p.updated_at,
+			(SELECT STRING_AGG(cat.name, ?)
+			 FROM part_categories pc
+			 JOIN categories cat ON pc.category_id = cat.id
+			 WHERE pc.part_id = p.id),
 			p.reorder_point,
 			p.min_stock,
+			(SELECT COALESCE(SUM(quantity), 0) FROM part_locations WHERE part_id = p.id),
 			pl.quantity,
+			pl.reorder_point,
+			pl.min_stock,
+			b.capacity,
+			pl.capacity,
 			c.ip_address,
 			b.wled_segment_id,
 			b.led_index
@@ -22,10 +45,40 @@ func (s *Store) GetDashboardBinData() ([]models.DashboardBinData, error) {
 		JOIN bins b ON pl.bin_id = b.id
 		JOIN wled_controllers c ON b.wled_controller_id = c.id
 		WHERE 
-			p.stock_tracking_enabled = 1;
+			p.stock_tracking_enabled = TRUE
 	`
 
-	rows, err := s.db.Query(query)
+	// Optional scope filters, after the categories' separator
+	args := []any{categorySeparator}
+	if filter.Category != "" {
+		query += ` AND EXISTS (
+			SELECT 1 FROM part_categories fpc
+			JOIN categories fcat ON fpc.category_id = fcat.id
+			WHERE fpc.part_id = p.id AND LOWER(fcat.name) = LOWER(?))`
+		args = append(args, filter.Category)
+	}
+	if filter.Supplier != "" {
+		query += ` AND LOWER(p.supplier) = LOWER(?)`
+		args = append(args, filter.Supplier)
+	}
+	if filter.Manufacturer != "" {
+		query += ` AND LOWER(p.manufacturer) = LOWER(?)`
+		args = append(args, filter.Manufacturer)
+	}
+	if filter.ControllerID != 0 {
+		query += ` AND c.id = ?`
+		args = append(args, filter.ControllerID)
+	}
+	if filter.Location != "" {
+		query += ` AND LOWER(b.name) LIKE LOWER(?) ESCAPE '\'`
+		args = append(args, escapeLike(filter.Location)+"%")
+	}
+	if filter.ZoneID != 0 {
+		query += ` AND ` + binZoneExpr + ` = ?`
+		args = append(args, filter.ZoneID)
+	}
+
+	rows, err := s.conn().QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -34,19 +87,28 @@ func (s *Store) GetDashboardBinData() ([]models.DashboardBinData, error) {
 	var results []models.DashboardBinData
 	for rows.Next() {
 		var d models.DashboardBinData
+		var updatedStr string
+		var categories sql.NullString
 		err := rows.Scan(
-			&d.ReorderPoint, &d.MinStock, &d.BinQuantity,
+			&d.PartID, &d.PartName, &d.PartNumber, &d.Manufacturer, &d.Supplier, &d.Status,
+			&updatedStr, &categories, &d.ReorderPoint, &d.MinStock, &d.PartQuantity,
+			&d.BinQuantity, &d.LocationReorderPoint, &d.LocationMinStock,
+			&d.BinCapacity, &d.LocationCapacity,
 			&d.BinIP, &d.BinSegmentID, &d.BinLEDIndex,
 		)
 		if err != nil {
 			return nil, err
 		}
+		d.UpdatedAt = parseTime(updatedStr)
+		if categories.Valid {
+			d.Categories = strings.Split(categories.String, categorySeparator)
+		}
 		results = append(results, d)
 	}
 	return results, nil
 }
 
-func (s *Store) GetPartLocationsForLocate(partID int) ([]struct {
+func (s *Store) GetPartLocationsForLocate(ctx context.Context, partID int) ([]struct {
 	IP       string
 	SegID    int
 	LEDIndex int
@@ -58,7 +120,7 @@ func (s *Store) GetPartLocationsForLocate(partID int) ([]struct {
 		JOIN wled_controllers c ON b.wled_controller_id = c.id
 		WHERE pl.part_id = ? AND pl.quantity > 0;
 	`
-	rows, err := s.db.Query(query, partID)
+	rows, err := s.conn().QueryContext(ctx, query, partID)
 	if err != nil {
 		return nil, err
 	}
@@ -83,7 +145,7 @@ func (s *Store) GetPartLocationsForLocate(partID int) ([]struct {
 	return locations, nil
 }
 
-func (s *Store) GetPartLocationsForStop(partID int) ([]struct {
+func (s *Store) GetPartLocationsForStop(ctx context.Context, partID int) ([]struct {
 	IP       string
 	SegID    int
 	LEDIndex int
@@ -95,7 +157,7 @@ func (s *Store) GetPartLocationsForStop(partID int) ([]struct {
 		JOIN wled_controllers c ON b.wled_controller_id = c.id
 		WHERE pl.part_id = ?;
 	`
-	rows, err := s.db.Query(query, partID)
+	rows, err := s.conn().QueryContext(ctx, query, partID)
 	if err != nil {
 		return nil, err
 	}
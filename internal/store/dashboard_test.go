@@ -1,25 +1,28 @@
 package store
 
 import (
+	"database/sql"
 	"testing"
+
+	"wledger/internal/models"
 )
 
 func TestStore_Dashboard(t *testing.T) {
 	s := newTestStore(t)
 
 	// Setup 1 Controller, 1 Bin, 1 Part (Tracked)
-	s.CreateController("C1", "1.1.1.1")
-	s.CreateBin("B1", 1, 0, 0)
+	s.CreateController(t.Context(), &models.WLEDController{Name: "C1", IPAddress: "1.1.1.1"})
+	s.CreateBin(t.Context(), "B1", 1, 0, 0, false)
 
 	p := getValidPart("P1")
 	p.StockTracking = true
 	p.MinStock = 5
 	p.ReorderPoint = 10
-	s.CreatePart(p)
-	s.CreatePartLocation(1, 1, 3) // 3 < 5 (Red)
+	s.CreatePart(t.Context(), p)
+	s.CreatePartLocation(t.Context(), 1, 1, 3) // 3 < 5 (Red)
 
 	// Test GetDashboardBinData
-	data, err := s.GetDashboardBinData()
+	data, err := s.GetDashboardBinData(t.Context(), models.StockStatusFilter{})
 	if err != nil {
 		t.Fatalf("GetDashboardBinData failed: %v", err)
 	}
@@ -29,9 +32,30 @@ func TestStore_Dashboard(t *testing.T) {
 	if data[0].BinQuantity != 3 {
 		t.Errorf("Expected qty 3, got %d", data[0].BinQuantity)
 	}
+	if data[0].PartQuantity != 3 || data[0].LocationMinStock.Valid {
+		t.Errorf("Expected part total 3 and no location threshold, got %+v", data[0])
+	}
+
+	// Per-location thresholds
+	if err := s.UpdatePartLocationThresholds(t.Context(), 1, sql.NullInt64{Int64: 4, Valid: true}, sql.NullInt64{Int64: 2, Valid: true}); err != nil {
+		t.Fatalf("UpdatePartLocationThresholds failed: %v", err)
+	}
+	data, _ = s.GetDashboardBinData(t.Context(), models.StockStatusFilter{})
+	if data[0].LocationReorderPoint.Int64 != 4 || data[0].LocationMinStock.Int64 != 2 {
+		t.Errorf("Location thresholds mismatch: %+v", data[0])
+	}
+
+	// Capacity
+	if err := s.UpdatePartLocationCapacity(t.Context(), 1, sql.NullInt64{Int64: 50, Valid: true}); err != nil {
+		t.Fatalf("UpdatePartLocationCapacity failed: %v", err)
+	}
+	data, _ = s.GetDashboardBinData(t.Context(), models.StockStatusFilter{})
+	if !data[0].LocationCapacity.Valid || data[0].LocationCapacity.Int64 != 50 || data[0].BinCapacity != 0 {
+		t.Errorf("Capacity mismatch: %+v", data[0])
+	}
 
 	// Test Locate
-	locs, err := s.GetPartLocationsForLocate(1)
+	locs, err := s.GetPartLocationsForLocate(t.Context(), 1)
 	if err != nil || len(locs) != 1 {
 		t.Errorf("Locate failed")
 	}
@@ -40,14 +64,135 @@ func TestStore_Dashboard(t *testing.T) {
 	}
 
 	// Test Stop (should find it even if qty is 0, though here it is 3)
-	stops, err := s.GetPartLocationsForStop(1)
+	stops, err := s.GetPartLocationsForStop(t.Context(), 1)
 	if err != nil || len(stops) != 1 {
 		t.Errorf("Stop failed")
 	}
 
 	// Test Stop All
-	all, err := s.GetAllBinLocationsForStopAll()
+	all, err := s.GetAllBinLocationsForStopAll(t.Context())
 	if err != nil || len(all) != 1 {
 		t.Errorf("Stop All failed")
 	}
 }
+
+func TestStore_DashboardFilters(t *testing.T) {
+	s := newTestStore(t)
+
+	s.CreateController(t.Context(), &models.WLEDController{Name: "Cabinet A", IPAddress: "1.1.1.1"})
+	s.CreateController(t.Context(), &models.WLEDController{Name: "Cabinet B", IPAddress: "1.1.1.2"})
+	s.CreateBin(t.Context(), "A1", 1, 0, 0, false)
+	s.CreateBin(t.Context(), "B1", 2, 0, 0, false)
+
+	resistor := getValidPart("Resistor")
+	resistor.StockTracking = true
+	resistor.Supplier = sql.NullString{String: "Digi-Key", Valid: true}
+	s.CreatePart(t.Context(), resistor) // ID 1
+	bolt := getValidPart("Bolt")
+	bolt.StockTracking = true
+	bolt.Supplier = sql.NullString{String: "McMaster", Valid: true}
+	s.CreatePart(t.Context(), bolt) // ID 2
+
+	s.CreatePartLocation(t.Context(), 1, 1, 5)
+	s.CreatePartLocation(t.Context(), 2, 2, 5)
+	cat, _ := s.CreateCategory(t.Context(), "Passives")
+	s.AssignCategoryToPart(t.Context(), 1, cat.ID)
+
+	zone := &models.Zone{Name: "Cabinet B area"}
+	s.CreateZone(t.Context(), zone)
+	cabinetB, _ := s.GetControllerByID(t.Context(), 2)
+	cabinetB.ZoneID = zone.ID
+	s.UpdateController(t.Context(), &cabinetB)
+
+	tests := []struct {
+		name   string
+		filter models.StockStatusFilter
+		want   int
+	}{
+		{"none", models.StockStatusFilter{}, 2},
+		{"supplier", models.StockStatusFilter{Supplier: "digi-key"}, 1},
+		{"category", models.StockStatusFilter{Category: "passives"}, 1},
+		{"controller", models.StockStatusFilter{ControllerID: 2}, 1},
+		{"location", models.StockStatusFilter{Location: "B"}, 1},
+		{"location wildcards", models.StockStatusFilter{Location: "_1"}, 0},
+		{"location percent", models.StockStatusFilter{Location: "%"}, 0},
+		{"zone", models.StockStatusFilter{ZoneID: zone.ID}, 1},
+		{"combined", models.StockStatusFilter{Supplier: "Digi-Key", Location: "B"}, 0},
+	}
+	for _, tc := range tests {
+		data, err := s.GetDashboardBinData(t.Context(), tc.filter)
+		if err != nil {
+			t.Fatalf("%s: GetDashboardBinData failed: %v", tc.name, err)
+		}
+		if len(data) != tc.want {
+			t.Errorf("%s: got %d bins, want %d", tc.name, len(data), tc.want)
+		}
+	}
+
+	// Categories come along for the rules engine
+	data, _ := s.GetDashboardBinData(t.Context(), models.StockStatusFilter{Category: "Passives"})
+	if len(data) == 1 && (len(data[0].Categories) != 1 || data[0].Categories[0] != "Passives") {
+		t.Errorf("Expected categories on bin data, got %v", data[0].Categories)
+	}
+}
+
+func TestStore_DashboardLocationIsLiteral(t *testing.T) {
+	s := newTestStore(t)
+
+	s.CreateController(t.Context(), &models.WLEDController{Name: "Cabinet A", IPAddress: "1.1.1.1"})
+	s.CreateBin(t.Context(), `Shelf_1\a`, 1, 0, 0, false)
+	s.CreateBin(t.Context(), `ShelfX1\a`, 1, 0, 1, false)
+	part := getValidPart("Resistor")
+	part.StockTracking = true
+	s.CreatePart(t.Context(), part)
+	s.CreatePartLocation(t.Context(), 1, 1, 5)
+	s.CreatePartLocation(t.Context(), 1, 2, 5)
+
+	data, err := s.GetDashboardBinData(t.Context(), models.StockStatusFilter{Location: `shelf_1\`})
+	if err != nil {
+		t.Fatalf("GetDashboardBinData failed: %v", err)
+	}
+	if len(data) != 1 || data[0].BinLEDIndex != 0 {
+		t.Errorf("Expected only the bin named Shelf_1\\a, got %+v", data)
+	}
+}
+
+func TestStore_StockStatusPresets(t *testing.T) {
+	s := newTestStore(t)
+
+	p := &models.StockStatusPreset{
+		Name:   "Digi-Key order",
+		Level:  "attention",
+		Mode:   "part",
+		Filter: models.StockStatusFilter{Supplier: "Digi-Key", ControllerID: 2, ZoneID: 3},
+	}
+	if err := s.CreateStockStatusPreset(t.Context(), p); err != nil {
+		t.Fatalf("CreateStockStatusPreset failed: %v", err)
+	}
+
+	got, err := s.GetStockStatusPresetByID(t.Context(), p.ID)
+	if err != nil {
+		t.Fatalf("GetStockStatusPresetByID failed: %v", err)
+	}
+	if got.Filter != p.Filter || got.Mode != "part" {
+		t.Errorf("Preset mismatch: %+v", got)
+	}
+
+	// Unique names
+	if err := s.CreateStockStatusPreset(t.Context(), &models.StockStatusPreset{Name: "Digi-Key order"}); err != ErrUniqueConstraint {
+		t.Errorf("Expected ErrUniqueConstraint, got %v", err)
+	}
+
+	presets, _ := s.GetStockStatusPresets(t.Context())
+	if len(presets) != 1 {
+		t.Errorf("Expected 1 preset, got %d", len(presets))
+	}
+
+	if err := s.DeleteStockStatusPreset(t.Context(), p.ID); err != nil {
+		t.Fatalf("DeleteStockStatusPreset failed: %v", err)
+	}
+	presets, _ = s.GetStockStatusPresets(t.Context())
+	if len(presets) != 0 {
+		t.Errorf("Expected preset to be deleted")
+	}
+}
@@ -0,0 +1,161 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"modernc.org/sqlite"
+	sqlitelib "modernc.org/sqlite/lib"
+)
+
+// dialect is the database a Store runs on. Queries are written with ?
+// placeholders in the SQL that SQLite and Postgres share, the dialect
+// covers the rest.
+type dialect int
+
+const (
+	sqliteDialect dialect = iota
+	postgresDialect
+)
+
+func (d dialect) String() string {
+	if d == postgresDialect {
+		return "PostgreSQL"
+	}
+	return "SQLite"
+}
+
+// driver is the database/sql driver of the dialect
+func (d dialect) driver() string {
+	if d == postgresDialect {
+		return "pgx"
+	}
+	return "sqlite"
+}
+
+// rebind numbers the ? placeholders $1, $2, ... for Postgres. String
+// literals, quoted names and comments are copied as they are.
+func (d dialect) rebind(query string) string {
+	if d != postgresDialect || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(query[i+1:], c)
+			if end < 0 {
+				b.WriteString(query[i:])
+				return b.String()
+			}
+			b.WriteString(query[i : i+end+2])
+			i += end + 1
+			continue
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			b.WriteString(query[i : i+end])
+			i += end - 1
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// postgresTypes translates the SQLite column definitions of the schema
+var postgresTypes = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`INTEGER PRIMARY KEY AUTOINCREMENT`), "SERIAL PRIMARY KEY"},
+	{regexp.MustCompile(`\bDATETIME\b`), "TIMESTAMPTZ"},
+	{regexp.MustCompile(`\bREAL\b`), "DOUBLE PRECISION"},
+	{regexp.MustCompile(`\b(BOOLEAN(?: NOT NULL)? DEFAULT) 0\b`), "$1 FALSE"},
+	{regexp.MustCompile(`\b(BOOLEAN(?: NOT NULL)? DEFAULT) 1\b`), "$1 TRUE"},
+}
+
+// schema translates the SQLite DDL the migrations are written in
+func (d dialect) schema(ddl string) string {
+	if d != postgresDialect {
+		return ddl
+	}
+	for _, t := range postgresTypes {
+		ddl = t.pattern.ReplaceAllString(ddl, t.replacement)
+	}
+	return ddl
+}
+
+// resetIDs makes new rows of a table continue after its highest ID, after
+// rows were inserted with their IDs
+func (d dialect) resetIDs(ctx context.Context, q dbtx, table string) error {
+	if d == postgresDialect {
+		_, err := q.ExecContext(ctx,
+			`SELECT setval(pg_get_serial_sequence('`+table+`', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM `+table)
+		return err
+	}
+	// Without its sequence entry, AUTOINCREMENT carries on from the highest ID
+	_, err := q.ExecContext(ctx, "DELETE FROM sqlite_sequence WHERE name = ?", table)
+	return err
+}
+
+// boundConn runs statements with the placeholders of its dialect
+type boundConn struct {
+	q       dbtx
+	dialect dialect
+}
+
+func (c boundConn) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return c.q.ExecContext(ctx, c.dialect.rebind(query), args...)
+}
+
+func (c boundConn) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return c.q.QueryContext(ctx, c.dialect.rebind(query), args...)
+}
+
+func (c boundConn) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return c.q.QueryRowContext(ctx, c.dialect.rebind(query), args...)
+}
+
+func (c boundConn) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return c.q.PrepareContext(ctx, c.dialect.rebind(query))
+}
+
+// Postgres error codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pgUniqueViolation     = "23505"
+	pgForeignKeyViolation = "23503"
+)
+
+// isUniqueViolation reports whether err is a unique constraint failing
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == sqlitelib.SQLITE_CONSTRAINT_UNIQUE
+	}
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation
+}
+
+// isForeignKeyViolation reports whether err is a foreign key constraint failing
+func isForeignKeyViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == sqlitelib.SQLITE_CONSTRAINT_FOREIGNKEY
+	}
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgForeignKeyViolation
+}
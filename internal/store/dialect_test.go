@@ -0,0 +1,83 @@
+package store
+
+import (
+	"errors"
+	"testing"
+
+	"wledger/internal/models"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestDialect_Rebind(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"Placeholders", `SELECT id FROM bins WHERE name = ? AND led_index < ?`, `SELECT id FROM bins WHERE name = $1 AND led_index < $2`},
+		{"String Literal", `INSERT INTO wled_controllers (name, status) VALUES (?, 'unknown?')`, `INSERT INTO wled_controllers (name, status) VALUES ($1, 'unknown?')`},
+		{"Escaped Quote", `SELECT 'it''s ?', ?`, `SELECT 'it''s ?', $1`},
+		{"Quoted Name", `SELECT "odd?name" FROM t WHERE id = ?`, `SELECT "odd?name" FROM t WHERE id = $1`},
+		{"Comment", "SELECT ? -- the controller's ?\nFROM t WHERE id = ?", "SELECT $1 -- the controller's ?\nFROM t WHERE id = $2"},
+		{"Unterminated", `SELECT ?, 'oops ?`, `SELECT $1, 'oops ?`},
+		{"None", `SELECT COUNT(*) FROM bins`, `SELECT COUNT(*) FROM bins`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := postgresDialect.rebind(tc.query); got != tc.want {
+				t.Errorf("got  %q\nwant %q", got, tc.want)
+			}
+			if got := sqliteDialect.rebind(tc.query); got != tc.query {
+				t.Errorf("SQLite query changed to %q", got)
+			}
+		})
+	}
+}
+
+func TestDialect_Schema(t *testing.T) {
+	ddl := `CREATE TABLE t (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		seen       DATETIME,
+		cost       REAL NOT NULL DEFAULT 0,
+		shared     BOOLEAN NOT NULL DEFAULT 0,
+		enabled    BOOLEAN DEFAULT 1,
+		realm      TEXT NOT NULL DEFAULT ''
+	);`
+	want := `CREATE TABLE t (
+		id         SERIAL PRIMARY KEY,
+		seen       TIMESTAMPTZ,
+		cost       DOUBLE PRECISION NOT NULL DEFAULT 0,
+		shared     BOOLEAN NOT NULL DEFAULT FALSE,
+		enabled    BOOLEAN DEFAULT TRUE,
+		realm      TEXT NOT NULL DEFAULT ''
+	);`
+	if got := postgresDialect.schema(ddl); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if got := sqliteDialect.schema(ddl); got != ddl {
+		t.Errorf("SQLite DDL changed to\n%s", got)
+	}
+}
+
+func TestDialect_ConstraintViolations(t *testing.T) {
+	unique := &pgconn.PgError{Code: pgUniqueViolation}
+	foreignKey := &pgconn.PgError{Code: pgForeignKeyViolation}
+	other := errors.New("connection refused")
+
+	if !isUniqueViolation(unique) || isUniqueViolation(foreignKey) || isUniqueViolation(other) {
+		t.Error("Only a unique violation should be one")
+	}
+	if !isForeignKeyViolation(foreignKey) || isForeignKeyViolation(unique) || isForeignKeyViolation(nil) {
+		t.Error("Only a foreign key violation should be one")
+	}
+
+	// The backend's own errors are mapped the same way
+	s := newTestStore(t)
+	if err := s.CreateZone(t.Context(), &models.Zone{Name: "Shelf"}); err != nil {
+		t.Fatalf("CreateZone failed: %v", err)
+	}
+	if err := s.CreateZone(t.Context(), &models.Zone{Name: "Shelf"}); !errors.Is(err, ErrUniqueConstraint) {
+		t.Errorf("Expected ErrUniqueConstraint, got %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"wledger/internal/models"
+)
+
+// Probe times are stored in UTC, to the second, so they compare correctly as text
+func probeTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Second)
+}
+
+// RecordHealthProbe logs the result of a controller health check
+func (s *Store) RecordHealthProbe(ctx context.Context, p *models.HealthProbe) error {
+	return s.conn().QueryRowContext(ctx,
+		`INSERT INTO controller_health_probes (controller_id, checked_at, online, latency_ms, error)
+		 VALUES (?, ?, ?, ?, ?) RETURNING id`,
+		p.ControllerID, probeTime(p.CheckedAt), p.Online, p.Latency.Milliseconds(), p.Error,
+	).Scan(&p.ID)
+}
+
+// PruneHealthProbes deletes the health probes older than the given time
+func (s *Store) PruneHealthProbes(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.conn().ExecContext(ctx, `DELETE FROM controller_health_probes WHERE checked_at < ?`, probeTime(before))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// GetControllerHealth summarizes every controller's health probes between
+// since and until. The timeline splits that window into slots of the given length.
+func (s *Store) GetControllerHealth(ctx context.Context, since, until time.Time, slot time.Duration) ([]models.ControllerHealth, error) {
+	since, until = probeTime(since), probeTime(until)
+	slots := int(until.Sub(since) / slot)
+	if until.Sub(since)%slot != 0 {
+		slots++
+	}
+
+	controllers, err := s.conn().QueryContext(ctx, `SELECT id, name FROM wled_controllers ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer controllers.Close()
+
+	health := []models.ControllerHealth{}
+	index := make(map[int]int)
+	for controllers.Next() {
+		h := models.ControllerHealth{Timeline: make([]models.HealthSlot, slots)}
+		if err := controllers.Scan(&h.ControllerID, &h.ControllerName); err != nil {
+			return nil, err
+		}
+		for i := range h.Timeline {
+			h.Timeline[i].Start = since.Add(time.Duration(i) * slot).Local()
+		}
+		index[h.ControllerID] = len(health)
+		health = append(health, h)
+	}
+	if err := controllers.Err(); err != nil {
+		return nil, err
+	}
+
+	probes, err := s.conn().QueryContext(ctx, `
+		SELECT controller_id, checked_at, online, latency_ms
+		FROM controller_health_probes
+		WHERE checked_at >= ? AND checked_at <= ?
+	`, since, until)
+	if err != nil {
+		return nil, err
+	}
+	defer probes.Close()
+
+	latency := make(map[int]int64)
+	online := make(map[int]int)
+	for probes.Next() {
+		var controllerID int
+		var checkedAt time.Time
+		var ok bool
+		var latencyMs int64
+		if err := probes.Scan(&controllerID, &checkedAt, &ok, &latencyMs); err != nil {
+			return nil, err
+		}
+		i, found := index[controllerID]
+		if !found {
+			continue
+		}
+		h := &health[i]
+		h.Probes++
+
+		n := int(checkedAt.Sub(since) / slot)
+		if n >= slots {
+			n = slots - 1
+		}
+		h.Timeline[n].Probes++
+		if ok {
+			online[controllerID]++
+			latency[controllerID] += latencyMs
+		} else {
+			h.Timeline[n].Failed++
+		}
+	}
+	if err := probes.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range health {
+		h := &health[i]
+		if n := online[h.ControllerID]; n > 0 {
+			h.Uptime = float64(n) * 100 / float64(h.Probes)
+			h.AvgLatency = time.Duration(latency[h.ControllerID]/int64(n)) * time.Millisecond
+		}
+	}
+
+	// Last error over the whole retention window, not just the timeline
+	lastErrors, err := s.conn().QueryContext(ctx, `
+		SELECT controller_id, error, checked_at
+		FROM controller_health_probes
+		WHERE id IN (
+			SELECT MAX(id) FROM controller_health_probes WHERE online = FALSE GROUP BY controller_id
+		)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer lastErrors.Close()
+
+	for lastErrors.Next() {
+		var controllerID int
+		var message string
+		var at time.Time
+		if err := lastErrors.Scan(&controllerID, &message, &at); err != nil {
+			return nil, err
+		}
+		if i, found := index[controllerID]; found {
+			health[i].LastError = message
+			health[i].LastErrorAt.Time, health[i].LastErrorAt.Valid = at.Local(), true
+		}
+	}
+	return health, lastErrors.Err()
+}
@@ -0,0 +1,76 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"wledger/internal/models"
+)
+
+func TestStore_ControllerHealth(t *testing.T) {
+	s := newTestStore(t)
+
+	if err := s.CreateController(t.Context(), &models.WLEDController{Name: "Cabinet C", IPAddress: "10.0.0.3"}); err != nil {
+		t.Fatalf("CreateController failed: %v", err)
+	}
+	if err := s.CreateController(t.Context(), &models.WLEDController{Name: "Idle", IPAddress: "10.0.0.4"}); err != nil {
+		t.Fatalf("CreateController failed: %v", err)
+	}
+	controllers, _ := s.GetControllers(t.Context())
+	cabinet := controllers[0]
+
+	since := time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC)
+	probes := []models.HealthProbe{
+		{ControllerID: cabinet.ID, CheckedAt: since.Add(-48 * time.Hour), Online: false, Error: "old failure"},
+		{ControllerID: cabinet.ID, CheckedAt: since.Add(10 * time.Minute), Online: true, Latency: 20 * time.Millisecond},
+		{ControllerID: cabinet.ID, CheckedAt: since.Add(70 * time.Minute), Online: true, Latency: 40 * time.Millisecond},
+		{ControllerID: cabinet.ID, CheckedAt: since.Add(80 * time.Minute), Online: true, Latency: 30 * time.Millisecond},
+		{ControllerID: cabinet.ID, CheckedAt: since.Add(90 * time.Minute), Online: false, Error: "timeout"},
+	}
+	for i := range probes {
+		if err := s.RecordHealthProbe(t.Context(), &probes[i]); err != nil {
+			t.Fatalf("RecordHealthProbe failed: %v", err)
+		}
+	}
+
+	health, err := s.GetControllerHealth(t.Context(), since, since.Add(3*time.Hour), time.Hour)
+	if err != nil {
+		t.Fatalf("GetControllerHealth failed: %v", err)
+	}
+	if len(health) != 2 || health[0].ControllerName != "Cabinet C" {
+		t.Fatalf("Expected both controllers, got %+v", health)
+	}
+
+	h := health[0]
+	if h.Probes != 4 || h.Uptime != 75 || h.AvgLatency != 30*time.Millisecond {
+		t.Errorf("Unexpected summary: %+v", h)
+	}
+	if len(h.Timeline) != 3 {
+		t.Fatalf("Expected 3 hourly slots, got %d", len(h.Timeline))
+	}
+	if h.Timeline[0].Probes != 1 || h.Timeline[1].Probes != 3 || h.Timeline[1].Failed != 1 || h.Timeline[2].Probes != 0 {
+		t.Errorf("Unexpected timeline: %+v", h.Timeline)
+	}
+	if h.LastError != "timeout" || !h.LastErrorAt.Valid || !h.LastErrorAt.Time.Equal(since.Add(90*time.Minute)) {
+		t.Errorf("Expected the latest error, got %q at %v", h.LastError, h.LastErrorAt)
+	}
+	if health[1].Probes != 0 || health[1].LastError != "" {
+		t.Errorf("Expected no probes for the idle controller, got %+v", health[1])
+	}
+
+	// Pruning keeps the recent probes
+	pruned, err := s.PruneHealthProbes(t.Context(), since)
+	if err != nil || pruned != 1 {
+		t.Fatalf("Expected 1 probe pruned, got %d (%v)", pruned, err)
+	}
+
+	// Probes are removed with their controller
+	if err := s.DeleteController(t.Context(), cabinet.ID); err != nil {
+		t.Fatalf("DeleteController failed: %v", err)
+	}
+	var count int
+	s.db.QueryRow(`SELECT COUNT(*) FROM controller_health_probes`).Scan(&count)
+	if count != 0 {
+		t.Errorf("Expected probes to be deleted with the controller, %d left", count)
+	}
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"wledger/internal/models"
+)
+
+// jobRunsKept is how many runs are kept per background job
+const jobRunsKept = 100
+
+// createJobRuns is the migration adding the background job run history
+func createJobRuns(tx *schemaTx) error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS job_runs (
+			id            INTEGER PRIMARY KEY AUTOINCREMENT,
+			job_name      TEXT NOT NULL,
+			trigger       TEXT NOT NULL,
+			started_at    DATETIME NOT NULL,
+			duration_ms   INTEGER NOT NULL DEFAULT 0,
+			status        TEXT NOT NULL,
+			error         TEXT NOT NULL DEFAULT ''
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_job_runs_job ON job_runs (job_name, id);`,
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(tx.dialect.schema(query)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordJobRun logs a background job run, keeping the job's recent runs only
+func (s *Store) RecordJobRun(ctx context.Context, run *models.JobRun) error {
+	err := s.conn().QueryRowContext(ctx,
+		`INSERT INTO job_runs (job_name, trigger, started_at, duration_ms, status, error)
+		 VALUES (?, ?, ?, ?, ?, ?) RETURNING id`,
+		run.JobName, run.Trigger, run.StartedAt, run.Duration.Milliseconds(), run.Status, run.Error,
+	).Scan(&run.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.conn().ExecContext(ctx,
+		`DELETE FROM job_runs WHERE job_name = ? AND id NOT IN (
+			SELECT id FROM job_runs WHERE job_name = ? ORDER BY id DESC LIMIT ?
+		)`,
+		run.JobName, run.JobName, jobRunsKept,
+	)
+	return err
+}
+
+// GetJobRuns returns a job's most recent runs, newest first
+func (s *Store) GetJobRuns(ctx context.Context, jobName string, limit int) ([]models.JobRun, error) {
+	return s.queryJobRuns(ctx, `
+		SELECT id, job_name, trigger, started_at, duration_ms, status, error
+		FROM job_runs
+		WHERE job_name = ?
+		ORDER BY id DESC
+		LIMIT ?;
+	`, jobName, limit)
+}
+
+// GetLatestJobRuns returns the latest run of every job that has run
+func (s *Store) GetLatestJobRuns(ctx context.Context) ([]models.JobRun, error) {
+	return s.queryJobRuns(ctx, `
+		SELECT id, job_name, trigger, started_at, duration_ms, status, error
+		FROM job_runs
+		WHERE id IN (SELECT MAX(id) FROM job_runs GROUP BY job_name)
+		ORDER BY job_name;
+	`)
+}
+
+func (s *Store) queryJobRuns(ctx context.Context, query string, args ...any) ([]models.JobRun, error) {
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []models.JobRun{}
+	for rows.Next() {
+		var run models.JobRun
+		var durationMs int64
+		if err := rows.Scan(&run.ID, &run.JobName, &run.Trigger, &run.StartedAt, &durationMs, &run.Status, &run.Error); err != nil {
+			return nil, err
+		}
+		run.Duration = time.Duration(durationMs) * time.Millisecond
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
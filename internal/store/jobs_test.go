@@ -0,0 +1,40 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"wledger/internal/models"
+)
+
+func TestStore_JobRuns(t *testing.T) {
+	s := newTestStore(t)
+
+	for i := 0; i < jobRunsKept+5; i++ {
+		run := &models.JobRun{JobName: "health-checks", Trigger: "schedule", StartedAt: time.Now(), Duration: 30 * time.Millisecond, Status: "ok"}
+		if err := s.RecordJobRun(t.Context(), run); err != nil {
+			t.Fatalf("RecordJobRun failed: %v", err)
+		}
+	}
+	failed := &models.JobRun{JobName: "cleanup", Trigger: "manual", StartedAt: time.Now(), Status: "error", Error: "database is locked"}
+	if err := s.RecordJobRun(t.Context(), failed); err != nil {
+		t.Fatalf("RecordJobRun failed: %v", err)
+	}
+
+	// Only the recent runs of each job are kept
+	runs, err := s.GetJobRuns(t.Context(), "health-checks", 1000)
+	if err != nil {
+		t.Fatalf("GetJobRuns failed: %v", err)
+	}
+	if len(runs) != jobRunsKept || runs[0].Duration != 30*time.Millisecond {
+		t.Errorf("Expected the %d newest runs, got %d: %+v", jobRunsKept, len(runs), runs[0])
+	}
+
+	latest, err := s.GetLatestJobRuns(t.Context())
+	if err != nil {
+		t.Fatalf("GetLatestJobRuns failed: %v", err)
+	}
+	if len(latest) != 2 || latest[0].JobName != "cleanup" || latest[0].Error != "database is locked" || latest[1].ID != runs[0].ID {
+		t.Errorf("Unexpected latest runs: %+v", latest)
+	}
+}
@@ -0,0 +1,191 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// migration is one versioned change to the schema
+type migration struct {
+	version     int
+	description string
+	up          func(tx *schemaTx) error
+}
+
+// schemaTx is the transaction a migration runs in. Its statements use ?
+// placeholders, and DDL is written for SQLite and translated with
+// tx.dialect.schema.
+type schemaTx struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (tx *schemaTx) Exec(query string, args ...any) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialect.rebind(query), args...)
+}
+
+func (tx *schemaTx) Query(query string, args ...any) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.dialect.rebind(query), args...)
+}
+
+func (tx *schemaTx) QueryRow(query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRow(tx.dialect.rebind(query), args...)
+}
+
+// column reports whether a table has a column, and if it's NOT NULL
+func (tx *schemaTx) column(table, column string) (exists, notNull bool, err error) {
+	query := `SELECT "notnull" FROM pragma_table_info(?) WHERE name = ?`
+	if tx.dialect == postgresDialect {
+		query = `SELECT is_nullable = 'NO' FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?`
+	}
+	err = tx.QueryRow(query, table, column).Scan(&notNull)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, false, nil
+	}
+	return err == nil, notNull, err
+}
+
+// migrations run in order at startup, each in its own transaction, and are
+// recorded in schema_migrations. Never change a released migration, append
+// a new one with the next version instead.
+//
+// The first four predate versioning and are idempotent, so databases from
+// before schema_migrations existed are brought up to date by running them.
+var migrations = []migration{
+	{1, "Create tables", createTables},
+	{2, "Add columns missing from older databases", addMissingColumns},
+	{3, "Split controller addresses into endpoints", migrateControllerEndpoints},
+	{4, "Let bins be detached from their controller", migrateDetachableBins},
+	{5, "Add the background job run history", createJobRuns},
+	{6, "Add user accounts and sessions", createUsers},
+	{7, "Add API tokens and their audit log", createAPITokens},
+	{8, "Add webhooks and their delivery queue", createWebhooks},
+	{9, "Seed the default stock rules", seedDefaultStockRules},
+}
+
+// LatestSchemaVersion is the schema version this build migrates databases to
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// migrate applies the pending migrations. A SQLite file that already has
+// tables is copied next to itself first, so a failed migration can be
+// undone by hand. Postgres databases are left to their own backups.
+func migrate(db *sql.DB, d dialect, path string) error {
+	// Foreign keys are per connection, and must be off so rebuilding a
+	// SQLite table doesn't cascade. The pragma has no effect inside a
+	// transaction.
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, d.schema(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version       INTEGER PRIMARY KEY,
+		description   TEXT NOT NULL,
+		applied_at    DATETIME NOT NULL
+	);`))
+	if err != nil {
+		return err
+	}
+
+	current, err := schemaVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if current > LatestSchemaVersion() {
+		return fmt.Errorf("database schema v%d is newer than this version of WLEDger supports (v%d)", current, LatestSchemaVersion())
+	}
+
+	pending := []migration{}
+	for _, m := range migrations {
+		if m.version > current {
+			pending = append(pending, m)
+		}
+	}
+
+	if len(pending) > 0 && d == sqliteDialect {
+		if err := backupBeforeMigrating(ctx, conn, path, current); err != nil {
+			return fmt.Errorf("backing up before migrating: %w", err)
+		}
+
+		if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF;"); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON;")
+	}
+	for _, m := range pending {
+		log.Printf("Migrating database to v%d: %s", m.version, m.description)
+		if err := applyMigration(ctx, conn, d, m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.description, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration runs one migration and records it in the same transaction
+func applyMigration(ctx context.Context, conn *sql.Conn, d dialect, m migration) error {
+	sqlTx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer sqlTx.Rollback()
+
+	tx := &schemaTx{Tx: sqlTx, dialect: d}
+	if err := m.up(tx); err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`INSERT INTO schema_migrations (version, description, applied_at) VALUES (?, ?, ?)`,
+		m.version, m.description, time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// backupBeforeMigrating copies a database that already has tables to
+// <path>.v<version>-<timestamp>.bak. In-memory databases aren't copied.
+func backupBeforeMigrating(ctx context.Context, conn *sql.Conn, path string, version int) error {
+	if path == "" || path == ":memory:" {
+		return nil
+	}
+
+	var tables int
+	err := conn.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM sqlite_master
+		 WHERE type = 'table' AND name != 'schema_migrations' AND name NOT LIKE 'sqlite_%'`,
+	).Scan(&tables)
+	if err != nil || tables == 0 {
+		return err
+	}
+
+	backup := fmt.Sprintf("%s.v%d-%s.bak", path, version, time.Now().Format("20060102-150405"))
+	if _, err := conn.ExecContext(ctx, `VACUUM INTO ?`, backup); err != nil {
+		return err
+	}
+	log.Printf("Backed up the database to %s before migrating", backup)
+	return nil
+}
+
+// schemaVersion returns the latest applied migration, or 0 if there's none
+func schemaVersion(ctx context.Context, conn *sql.Conn) (int, error) {
+	var version int
+	err := conn.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+// SchemaVersion returns the version of the database's schema
+func (s *Store) SchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore_Migrations(t *testing.T) {
+	s := newTestStore(t)
+
+	version, err := s.SchemaVersion(t.Context())
+	if err != nil || version != LatestSchemaVersion() {
+		t.Fatalf("Expected schema v%d, got v%d (%v)", LatestSchemaVersion(), version, err)
+	}
+	var applied int
+	s.db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&applied)
+	if applied != len(migrations) {
+		t.Errorf("Expected %d recorded migrations, got %d", len(migrations), applied)
+	}
+
+	// Nothing is pending, so nothing runs twice
+	if err := migrate(s.db, s.dialect, ""); err != nil {
+		t.Fatalf("migrate failed on second run: %v", err)
+	}
+	s.db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&applied)
+	if applied != len(migrations) {
+		t.Errorf("Migrations were recorded twice: %d", applied)
+	}
+
+	// A database from a newer build is left alone
+	s.conn().ExecContext(t.Context(), "INSERT INTO schema_migrations (version, description, applied_at) VALUES (?, 'future', CURRENT_TIMESTAMP)", LatestSchemaVersion()+1)
+	if err := migrate(s.db, s.dialect, ""); err == nil {
+		t.Error("Expected an error migrating a newer schema")
+	}
+}
+
+func TestStore_Migrate_BacksUpFirst(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "inventory.db")
+
+	// A new database has nothing to back up
+	s, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	s.db.Close()
+	if backups, _ := filepath.Glob(dbPath + ".v*.bak"); len(backups) != 0 {
+		t.Errorf("Expected no backup of a new database, got %v", backups)
+	}
+
+	// Pretend the database predates the last migration
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	db.Exec("DELETE FROM schema_migrations WHERE version = ?", LatestSchemaVersion())
+	db.Close()
+
+	s, err = NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	backups, _ := filepath.Glob(dbPath + ".v*.bak")
+	if len(backups) != 1 {
+		t.Fatalf("Expected one backup, got %v", backups)
+	}
+	if version, _ := s.SchemaVersion(t.Context()); version != LatestSchemaVersion() {
+		t.Errorf("Expected schema v%d after migrating, got v%d", LatestSchemaVersion(), version)
+	}
+
+	// The backup is a usable copy from before the migration
+	backup, err := sql.Open("sqlite", backups[0])
+	if err != nil {
+		t.Fatalf("Failed to open backup: %v", err)
+	}
+	defer backup.Close()
+	var version int
+	backup.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	if version != LatestSchemaVersion()-1 {
+		t.Errorf("Expected the backup at v%d, got v%d", LatestSchemaVersion()-1, version)
+	}
+}
+
+func TestStore_Backup_SchemaVersion(t *testing.T) {
+	s := newTestStore(t)
+
+	data, err := s.GetAllDataForBackup(t.Context())
+	if err != nil {
+		t.Fatalf("GetAllDataForBackup failed: %v", err)
+	}
+	if data.SchemaVersion != LatestSchemaVersion() {
+		t.Errorf("Expected the backup to record schema v%d, got v%d", LatestSchemaVersion(), data.SchemaVersion)
+	}
+
+	data.SchemaVersion = LatestSchemaVersion() + 1
+	if err := s.RestoreFromBackup(t.Context(), data); !errors.Is(err, ErrBackupTooNew) {
+		t.Errorf("Expected ErrBackupTooNew, got %v", err)
+	}
+}
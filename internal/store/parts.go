@@ -1,12 +1,14 @@
 package store
 
 import (
+	"context"
+	"database/sql"
 	"log"
 	"wledger/internal/models"
 )
 
 // Part methods
-func (s *Store) GetPartByID(id int) (models.Part, error) {
+func (s *Store) GetPartByID(ctx context.Context, id int) (models.Part, error) {
 	var p models.Part
 	var createdStr, updatedStr string // Temp vars
 
@@ -18,7 +20,7 @@ func (s *Store) GetPartByID(id int) (models.Part, error) {
 		FROM parts
 		WHERE id = ?;
 	`
-	row := s.db.QueryRow(query, id)
+	row := s.conn().QueryRowContext(ctx, query, id)
 
 	// Scan into strings for dates
 	err := row.Scan(
@@ -36,55 +38,33 @@ func (s *Store) GetPartByID(id int) (models.Part, error) {
 	p.CreatedAt = parseTime(createdStr)
 	p.UpdatedAt = parseTime(updatedStr)
 
-	qtyQuery := `SELECT IFNULL(SUM(quantity), 0) FROM part_locations WHERE part_id = ?`
-	err = s.db.QueryRow(qtyQuery, id).Scan(&p.TotalQuantity)
+	qtyQuery := `SELECT COALESCE(SUM(quantity), 0) FROM part_locations WHERE part_id = ?`
+	err = s.conn().QueryRowContext(ctx, qtyQuery, id).Scan(&p.TotalQuantity)
 	return p, err
 }
 
-func (s *Store) GetParts() ([]models.Part, error) {
+func (s *Store) GetParts(ctx context.Context) ([]models.Part, error) {
 	query := `
 		SELECT 
 			p.id, p.name, p.description, p.part_number, p.datasheet_url, p.created_at, p.updated_at,
 			p.image_path, p.manufacturer, p.supplier, p.unit_cost, p.status,
 			p.stock_tracking_enabled, p.reorder_point, p.min_stock,
-			IFNULL(SUM(pl.quantity), 0) AS total_quantity
+			COALESCE(SUM(pl.quantity), 0) AS total_quantity
 		FROM parts p
 		LEFT JOIN part_locations pl ON p.id = pl.part_id
 		GROUP BY p.id
 		ORDER BY p.name ASC;
 	`
-	rows, err := s.db.Query(query)
+	rows, err := s.conn().QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	parts := []models.Part{}
-	for rows.Next() {
-		var p models.Part
-		var createdStr, updatedStr string // Temp vars
-
-		err := rows.Scan(
-			&p.ID, &p.Name, &p.Description, &p.PartNumber, &p.DatasheetURL,
-			&createdStr, &updatedStr, // <--- CHANGED
-			&p.ImagePath, &p.Manufacturer, &p.Supplier, &p.UnitCost, &p.Status,
-			&p.StockTracking, &p.ReorderPoint, &p.MinStock,
-			&p.TotalQuantity,
-		)
-		if err != nil {
-			log.Println("Error scanning part row:", err)
-			continue
-		}
-
-		p.CreatedAt = parseTime(createdStr)
-		p.UpdatedAt = parseTime(updatedStr)
-
-		parts = append(parts, p)
-	}
-	return parts, nil
+	return scanPartRows(rows), nil
 }
 
-func (s *Store) SearchParts(searchTerm string) ([]models.Part, error) {
+func (s *Store) SearchParts(ctx context.Context, searchTerm string) ([]models.Part, error) {
 	searchQuery := "%" + searchTerm + "%"
 
 	query := `
@@ -92,25 +72,94 @@ func (s *Store) SearchParts(searchTerm string) ([]models.Part, error) {
 			p.id, p.name, p.description, p.part_number, p.datasheet_url, p.created_at, p.updated_at,
 			p.image_path, p.manufacturer, p.supplier, p.unit_cost, p.status,
 			p.stock_tracking_enabled, p.reorder_point, p.min_stock,
-			IFNULL(SUM(pl.quantity), 0) AS total_quantity
+			COALESCE(SUM(pl.quantity), 0) AS total_quantity
 		FROM parts p
 		LEFT JOIN part_locations pl ON p.id = pl.part_id
 		LEFT JOIN part_categories pc ON p.id = pc.part_id
 		LEFT JOIN categories c ON pc.category_id = c.id
 		WHERE 
-			p.name LIKE ? OR 
-			p.description LIKE ? OR 
-			p.part_number LIKE ? OR
-			c.name LIKE ?
+			LOWER(p.name) LIKE LOWER(?) OR 
+			LOWER(p.description) LIKE LOWER(?) OR 
+			LOWER(p.part_number) LIKE LOWER(?) OR
+			LOWER(c.name) LIKE LOWER(?)
 		GROUP BY p.id
 		ORDER BY p.name ASC;
 	`
-	rows, err := s.db.Query(query, searchQuery, searchQuery, searchQuery, searchQuery)
+	rows, err := s.conn().QueryContext(ctx, query, searchQuery, searchQuery, searchQuery, searchQuery)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	return scanPartRows(rows), nil
+}
+
+// ListParts returns the page of parts the filter asks for, by name, and
+// how many parts match on all pages
+func (s *Store) ListParts(ctx context.Context, f models.PartFilter) ([]models.Part, int, error) {
+	where := ` WHERE 1 = 1`
+	args := []any{}
+	if f.Search != "" {
+		search := "%" + escapeLike(f.Search) + "%"
+		where += ` AND (
+			LOWER(p.name) LIKE LOWER(?) ESCAPE '\' OR
+			LOWER(p.description) LIKE LOWER(?) ESCAPE '\' OR
+			LOWER(p.part_number) LIKE LOWER(?) ESCAPE '\' OR
+			EXISTS (
+				SELECT 1 FROM part_categories pc
+				JOIN categories c ON pc.category_id = c.id
+				WHERE pc.part_id = p.id AND LOWER(c.name) LIKE LOWER(?) ESCAPE '\'))`
+		args = append(args, search, search, search, search)
+	}
+	if f.Manufacturer != "" {
+		where += ` AND LOWER(p.manufacturer) = LOWER(?)`
+		args = append(args, f.Manufacturer)
+	}
+	if f.Supplier != "" {
+		where += ` AND LOWER(p.supplier) = LOWER(?)`
+		args = append(args, f.Supplier)
+	}
+	if f.Status != "" {
+		where += ` AND LOWER(p.status) = LOWER(?)`
+		args = append(args, f.Status)
+	}
+	if f.StockTracking.Valid {
+		where += ` AND p.stock_tracking_enabled = ?`
+		args = append(args, f.StockTracking.Bool)
+	}
+
+	var total int
+	err := s.conn().QueryRowContext(ctx, `SELECT COUNT(*) FROM parts p`+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT
+			p.id, p.name, p.description, p.part_number, p.datasheet_url, p.created_at, p.updated_at,
+			p.image_path, p.manufacturer, p.supplier, p.unit_cost, p.status,
+			p.stock_tracking_enabled, p.reorder_point, p.min_stock,
+			COALESCE(SUM(pl.quantity), 0) AS total_quantity
+		FROM parts p
+		LEFT JOIN part_locations pl ON p.id = pl.part_id` + where + `
+		GROUP BY p.id
+		ORDER BY p.name ASC, p.id ASC`
+	if f.Limit > 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, f.Limit, f.Offset)
+	}
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	return scanPartRows(rows), total, nil
+}
+
+// scanPartRows reads the parts of a list query. Rows that can't be read
+// are logged and skipped.
+func scanPartRows(rows *sql.Rows) []models.Part {
 	parts := []models.Part{}
 	for rows.Next() {
 		var p models.Part
@@ -118,7 +167,7 @@ func (s *Store) SearchParts(searchTerm string) ([]models.Part, error) {
 
 		err := rows.Scan(
 			&p.ID, &p.Name, &p.Description, &p.PartNumber, &p.DatasheetURL,
-			&createdStr, &updatedStr, // <--- CHANGED
+			&createdStr, &updatedStr,
 			&p.ImagePath, &p.Manufacturer, &p.Supplier, &p.UnitCost, &p.Status,
 			&p.StockTracking, &p.ReorderPoint, &p.MinStock,
 			&p.TotalQuantity,
@@ -133,26 +182,44 @@ func (s *Store) SearchParts(searchTerm string) ([]models.Part, error) {
 
 		parts = append(parts, p)
 	}
-	return parts, nil
+	return parts
 }
 
-func (s *Store) CreatePart(p *models.Part) error {
-	_, err := s.db.Exec(
+func (s *Store) CreatePart(ctx context.Context, p *models.Part) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx,
 		`INSERT INTO parts (
 			name, description, part_number, created_at, updated_at,
 			manufacturer, supplier, unit_cost, status, 
 			stock_tracking_enabled, reorder_point, min_stock
 		 )
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
 		p.Name, p.Description, p.PartNumber, p.CreatedAt, p.UpdatedAt,
 		p.Manufacturer, p.Supplier, p.UnitCost, p.Status,
 		p.StockTracking, p.ReorderPoint, p.MinStock,
-	)
-	return err
+	).Scan(&p.ID)
+	if err != nil {
+		return err
+	}
+	if err := partEvent(ctx, tx, models.EventPartCreated, *p); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (s *Store) UpdatePart(p *models.Part) error {
-	_, err := s.db.Exec(
+func (s *Store) UpdatePart(ctx context.Context, p *models.Part) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
 		`UPDATE parts SET 
 			name = ?, description = ?, part_number = ?, updated_at = ?,
 			manufacturer = ?, supplier = ?, unit_cost = ?, status = ?,
@@ -163,187 +230,323 @@ func (s *Store) UpdatePart(p *models.Part) error {
 		p.StockTracking, p.ReorderPoint, p.MinStock,
 		p.ID,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n > 0 {
+		if err := partEvent(ctx, tx, models.EventPartUpdated, *p); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
-func (s *Store) DeletePart(id int) error {
-	_, err := s.db.Exec(`DELETE FROM parts WHERE id = ?`, id)
-	return err
+func (s *Store) DeletePart(ctx context.Context, id int) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The event needs the part's name, so it's read before it's gone
+	p := models.Part{ID: id}
+	err = tx.QueryRowContext(ctx, `SELECT name, part_number FROM parts WHERE id = ?`, id).Scan(&p.Name, &p.PartNumber)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	// Its stock goes first, so the stock events are emitted like for any
+	// other location that's deleted
+	err = deletePartLocations(ctx, tx, `SELECT id FROM part_locations WHERE part_id = ? ORDER BY id`, id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM parts WHERE id = ?`, id); err != nil {
+		return err
+	}
+	if err := partEvent(ctx, tx, models.EventPartDeleted, p); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (s *Store) UpdatePartImagePath(partID int, imagePath string) error {
-	_, err := s.db.Exec(`UPDATE parts SET image_path = ? WHERE id = ?`, imagePath, partID)
+func (s *Store) UpdatePartImagePath(ctx context.Context, partID int, imagePath string) error {
+	_, err := s.conn().ExecContext(ctx, `UPDATE parts SET image_path = ? WHERE id = ?`, imagePath, partID)
 	return err
 }
 
-func (s *Store) GetBinLocationCount(partID int) (int, error) {
+func (s *Store) GetBinLocationCount(ctx context.Context, partID int) (int, error) {
 	var count int
 	query := `SELECT COUNT(id) FROM part_locations WHERE part_id = ? AND quantity > 0`
-	err := s.db.QueryRow(query, partID).Scan(&count)
+	err := s.conn().QueryRowContext(ctx, query, partID).Scan(&count)
 	return count, err
 }
 
 // Category methods
-func (s *Store) GetCategories() ([]models.Category, error) {
-	query := `SELECT id, name FROM categories ORDER BY name`
-	rows, err := s.db.Query(query)
-	if err != nil {
-		return nil, err
+func (s *Store) GetCategories(ctx context.Context) ([]models.Category, error) {
+	cats, _, err := s.ListCategories(ctx, models.CategoryFilter{})
+	return cats, err
+}
+
+func (s *Store) GetCategoriesByPartID(ctx context.Context, partID int) ([]models.Category, error) {
+	cats, _, err := s.ListCategories(ctx, models.CategoryFilter{PartID: partID})
+	return cats, err
+}
+
+// ListCategories returns a page of the categories the filter matches, and
+// how many match in all
+func (s *Store) ListCategories(ctx context.Context, f models.CategoryFilter) ([]models.Category, int, error) {
+	where := ` WHERE 1 = 1`
+	args := []any{}
+	if f.Search != "" {
+		where += ` AND LOWER(c.name) LIKE LOWER(?) ESCAPE '\'`
+		args = append(args, "%"+escapeLike(f.Search)+"%")
+	}
+	if f.PartID != 0 {
+		where += ` AND EXISTS (SELECT 1 FROM part_categories pc WHERE pc.category_id = c.id AND pc.part_id = ?)`
+		args = append(args, f.PartID)
 	}
-	defer rows.Close()
 
-	var cats []models.Category
-	for rows.Next() {
-		var c models.Category
-		if err := rows.Scan(&c.ID, &c.Name); err != nil {
-			return nil, err
-		}
-		cats = append(cats, c)
+	var total int
+	err := s.conn().QueryRowContext(ctx, `SELECT COUNT(*) FROM categories c`+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
 	}
-	return cats, nil
-}
 
-func (s *Store) GetCategoriesByPartID(partID int) ([]models.Category, error) {
-	query := `
-		SELECT c.id, c.name FROM categories c
-		JOIN part_categories pc ON c.id = pc.category_id
-		WHERE pc.part_id = ?
-		ORDER BY c.name
-	`
-	rows, err := s.db.Query(query, partID)
+	query := `SELECT c.id, c.name FROM categories c` + where + ` ORDER BY c.name, c.id`
+	if f.Limit > 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, f.Limit, f.Offset)
+	}
+	rows, err := s.conn().QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
-	var cats []models.Category
+	cats := []models.Category{}
 	for rows.Next() {
 		var c models.Category
 		if err := rows.Scan(&c.ID, &c.Name); err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 		cats = append(cats, c)
 	}
-	return cats, nil
+	return cats, total, nil
 }
 
-func (s *Store) CreateCategory(name string) (models.Category, error) {
+func (s *Store) GetCategoryByID(ctx context.Context, id int) (models.Category, error) {
+	var c models.Category
+	err := s.conn().QueryRowContext(ctx, `SELECT id, name FROM categories WHERE id = ?`, id).Scan(&c.ID, &c.Name)
+	return c, err
+}
+
+func (s *Store) CreateCategory(ctx context.Context, name string) (models.Category, error) {
 	var c models.Category
 	// Check for unique constraint
-	err := s.db.QueryRow(`SELECT id, name FROM categories WHERE name = ?`, name).Scan(&c.ID, &c.Name)
+	err := s.conn().QueryRowContext(ctx, `SELECT id, name FROM categories WHERE name = ?`, name).Scan(&c.ID, &c.Name)
 	if err == nil {
 		// Already exists, just return it
 		return c, nil
 	}
 
-	res, err := s.db.Exec(`INSERT INTO categories (name) VALUES (?)`, name)
+	err = s.conn().QueryRowContext(ctx, `INSERT INTO categories (name) VALUES (?) RETURNING id`, name).Scan(&c.ID)
 	if err != nil {
 		return c, err
 	}
-	id, _ := res.LastInsertId()
-	c.ID = int(id)
 	c.Name = name
 	return c, nil
 }
 
-func (s *Store) AssignCategoryToPart(partID int, categoryID int) error {
-	// Ignore errors for "UNIQUE constraint failed"
-	_, err := s.db.Exec(
-		`INSERT OR IGNORE INTO part_categories (part_id, category_id) VALUES (?, ?)`,
+// UpdateCategory renames a category, returning ErrUniqueConstraint if
+// another one has the name
+func (s *Store) UpdateCategory(ctx context.Context, c *models.Category) error {
+	_, err := s.conn().ExecContext(ctx, `UPDATE categories SET name = ? WHERE id = ?`, c.Name, c.ID)
+	if isUniqueViolation(err) {
+		return ErrUniqueConstraint
+	}
+	return err
+}
+
+// DeleteCategory deletes a category, taking it off every part
+func (s *Store) DeleteCategory(ctx context.Context, id int) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM part_categories WHERE category_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM categories WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Store) AssignCategoryToPart(ctx context.Context, partID int, categoryID int) error {
+	// A part that already has the category keeps it
+	_, err := s.conn().ExecContext(ctx,
+		`INSERT INTO part_categories (part_id, category_id) VALUES (?, ?) ON CONFLICT DO NOTHING`,
 		partID, categoryID,
 	)
 	return err
 }
 
-func (s *Store) RemoveCategoryFromPart(partID int, categoryID int) error {
-	_, err := s.db.Exec(
+func (s *Store) RemoveCategoryFromPart(ctx context.Context, partID int, categoryID int) error {
+	_, err := s.conn().ExecContext(ctx,
 		`DELETE FROM part_categories WHERE part_id = ? AND category_id = ?`,
 		partID, categoryID,
 	)
 	return err
 }
 
-func (s *Store) CleanupOrphanedCategories() error {
+func (s *Store) CleanupOrphanedCategories(ctx context.Context) error {
 	// remove categories that are not assigned to any part
 	// Used by the category management cleanup feature in health checks to keep the database tidy.
 	query := `
         DELETE FROM categories 
         WHERE id NOT IN (SELECT DISTINCT category_id FROM part_categories);
     `
-	_, err := s.db.Exec(query)
+	_, err := s.conn().ExecContext(ctx, query)
 	return err
 }
 
 // URL methods
-func (s *Store) GetURLsByPartID(partID int) ([]models.PartURL, error) {
+func (s *Store) GetURLsByPartID(ctx context.Context, partID int) ([]models.PartURL, error) {
+	urls, _, err := s.ListPartURLs(ctx, partID, 0, 0)
+	return urls, err
+}
+
+// ListPartURLs returns a page of a part's URLs, and how many it has. A
+// limit of 0 returns them all.
+func (s *Store) ListPartURLs(ctx context.Context, partID, limit, offset int) ([]models.PartURL, int, error) {
+	var total int
+	err := s.conn().QueryRowContext(ctx, `SELECT COUNT(*) FROM part_urls WHERE part_id = ?`, partID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
 	query := `SELECT id, part_id, url, description FROM part_urls WHERE part_id = ? ORDER BY id`
-	rows, err := s.db.Query(query, partID)
+	args := []any{partID}
+	if limit > 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, limit, offset)
+	}
+	rows, err := s.conn().QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
-	var urls []models.PartURL
+	urls := []models.PartURL{}
 	for rows.Next() {
 		var u models.PartURL
 		if err := rows.Scan(&u.ID, &u.PartID, &u.URL, &u.Description); err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 		urls = append(urls, u)
 	}
-	return urls, nil
+	return urls, total, nil
 }
 
-func (s *Store) CreatePartURL(partID int, url string, description string) error {
-	_, err := s.db.Exec(
-		`INSERT INTO part_urls (part_id, url, description) VALUES (?, ?, ?)`,
+func (s *Store) CreatePartURL(ctx context.Context, partID int, url string, description string) (int, error) {
+	var id int
+	err := s.conn().QueryRowContext(ctx,
+		`INSERT INTO part_urls (part_id, url, description) VALUES (?, ?, ?) RETURNING id`,
 		partID, url, description,
+	).Scan(&id)
+	return id, err
+}
+
+func (s *Store) GetPartURLByID(ctx context.Context, urlID int) (models.PartURL, error) {
+	var u models.PartURL
+	err := s.conn().QueryRowContext(ctx,
+		`SELECT id, part_id, url, description FROM part_urls WHERE id = ?`, urlID,
+	).Scan(&u.ID, &u.PartID, &u.URL, &u.Description)
+	return u, err
+}
+
+func (s *Store) UpdatePartURL(ctx context.Context, u *models.PartURL) error {
+	_, err := s.conn().ExecContext(ctx,
+		`UPDATE part_urls SET url = ?, description = ? WHERE id = ?`,
+		u.URL, u.Description, u.ID,
 	)
 	return err
 }
 
-func (s *Store) DeletePartURL(urlID int) error {
-	_, err := s.db.Exec(`DELETE FROM part_urls WHERE id = ?`, urlID)
+func (s *Store) DeletePartURL(ctx context.Context, urlID int) error {
+	_, err := s.conn().ExecContext(ctx, `DELETE FROM part_urls WHERE id = ?`, urlID)
 	return err
 }
 
 // Document methods
-func (s *Store) GetDocumentsByPartID(partID int) ([]models.PartDocument, error) {
-	query := `SELECT id, part_id, filename, filepath, description, mimetype FROM part_documents WHERE part_id = ? ORDER BY filename`
-	rows, err := s.db.Query(query, partID)
+func (s *Store) GetDocumentsByPartID(ctx context.Context, partID int) ([]models.PartDocument, error) {
+	docs, _, err := s.ListPartDocuments(ctx, partID, 0, 0)
+	return docs, err
+}
+
+// ListPartDocuments returns a page of a part's documents, and how many it
+// has. A limit of 0 returns them all.
+func (s *Store) ListPartDocuments(ctx context.Context, partID, limit, offset int) ([]models.PartDocument, int, error) {
+	var total int
+	err := s.conn().QueryRowContext(ctx, `SELECT COUNT(*) FROM part_documents WHERE part_id = ?`, partID).Scan(&total)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+
+	query := `SELECT id, part_id, filename, filepath, description, mimetype FROM part_documents WHERE part_id = ? ORDER BY filename, id`
+	args := []any{partID}
+	if limit > 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, limit, offset)
+	}
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
 	}
 	defer rows.Close()
 
-	var docs []models.PartDocument
+	docs := []models.PartDocument{}
 	for rows.Next() {
 		var d models.PartDocument
 		if err := rows.Scan(&d.ID, &d.PartID, &d.Filename, &d.Filepath, &d.Description, &d.Mimetype); err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 		docs = append(docs, d)
 	}
-	return docs, nil
+	return docs, total, nil
 }
 
-func (s *Store) GetDocumentByID(docID int) (models.PartDocument, error) {
+func (s *Store) GetDocumentByID(ctx context.Context, docID int) (models.PartDocument, error) {
 	var d models.PartDocument
 	query := `SELECT id, part_id, filename, filepath, description, mimetype FROM part_documents WHERE id = ?`
-	row := s.db.QueryRow(query, docID)
+	row := s.conn().QueryRowContext(ctx, query, docID)
 	err := row.Scan(&d.ID, &d.PartID, &d.Filename, &d.Filepath, &d.Description, &d.Mimetype)
 	return d, err
 }
 
-func (s *Store) CreatePartDocument(doc *models.PartDocument) error {
-	_, err := s.db.Exec(
-		`INSERT INTO part_documents (part_id, filename, filepath, description, mimetype) VALUES (?, ?, ?, ?, ?)`,
+func (s *Store) CreatePartDocument(ctx context.Context, doc *models.PartDocument) error {
+	return s.conn().QueryRowContext(ctx,
+		`INSERT INTO part_documents (part_id, filename, filepath, description, mimetype) VALUES (?, ?, ?, ?, ?) RETURNING id`,
 		doc.PartID, doc.Filename, doc.Filepath, doc.Description, doc.Mimetype,
+	).Scan(&doc.ID)
+}
+
+// UpdatePartDocument changes a document's name and description. Its file stays where it is.
+func (s *Store) UpdatePartDocument(ctx context.Context, doc *models.PartDocument) error {
+	_, err := s.conn().ExecContext(ctx,
+		`UPDATE part_documents SET filename = ?, description = ? WHERE id = ?`,
+		doc.Filename, doc.Description, doc.ID,
 	)
 	return err
 }
 
-func (s *Store) DeletePartDocument(docID int) error {
-	_, err := s.db.Exec(`DELETE FROM part_documents WHERE id = ?`, docID)
+func (s *Store) DeletePartDocument(ctx context.Context, docID int) error {
+	_, err := s.conn().ExecContext(ctx, `DELETE FROM part_documents WHERE id = ?`, docID)
 	return err
 }
@@ -2,6 +2,8 @@ package store
 
 import (
 	"database/sql"
+	"errors"
+	"slices"
 	"testing"
 	"time"
 
@@ -31,12 +33,15 @@ func TestStore_PartCRUD(t *testing.T) {
 
 	// Create
 	part := getValidPart("Test Part")
-	if err := s.CreatePart(part); err != nil {
+	if err := s.CreatePart(t.Context(), part); err != nil {
 		t.Fatalf("CreatePart failed: %v", err)
 	}
+	if part.ID != 1 {
+		t.Errorf("Expected CreatePart to set the ID, got %d", part.ID)
+	}
 
 	// Get By ID
-	p, err := s.GetPartByID(1)
+	p, err := s.GetPartByID(t.Context(), 1)
 	if err != nil {
 		t.Fatalf("GetPartByID failed: %v", err)
 	}
@@ -47,29 +52,29 @@ func TestStore_PartCRUD(t *testing.T) {
 	// Update
 	p.Name = "Updated Part"
 	// Ensure we keep valid data for update
-	if err := s.UpdatePart(&p); err != nil {
+	if err := s.UpdatePart(t.Context(), &p); err != nil {
 		t.Fatalf("UpdatePart failed: %v", err)
 	}
 
-	p2, _ := s.GetPartByID(1)
+	p2, _ := s.GetPartByID(t.Context(), 1)
 	if p2.Name != "Updated Part" {
 		t.Errorf("Update failed, got %q", p2.Name)
 	}
 
 	// Update Image Path
-	if err := s.UpdatePartImagePath(1, "images/test.jpg"); err != nil {
+	if err := s.UpdatePartImagePath(t.Context(), 1, "images/test.jpg"); err != nil {
 		t.Fatalf("UpdatePartImagePath failed: %v", err)
 	}
-	p3, _ := s.GetPartByID(1)
+	p3, _ := s.GetPartByID(t.Context(), 1)
 	if p3.ImagePath.String != "images/test.jpg" {
 		t.Errorf("Image update failed")
 	}
 
 	// Delete
-	if err := s.DeletePart(1); err != nil {
+	if err := s.DeletePart(t.Context(), 1); err != nil {
 		t.Fatalf("DeletePart failed: %v", err)
 	}
-	_, err = s.GetPartByID(1)
+	_, err = s.GetPartByID(t.Context(), 1)
 	if err == nil {
 		t.Error("Expected error getting deleted part, got nil")
 	}
@@ -79,17 +84,17 @@ func TestStore_GetParts(t *testing.T) {
 	s := newTestStore(t)
 
 	// Create 3 parts
-	if err := s.CreatePart(getValidPart("C Part")); err != nil {
+	if err := s.CreatePart(t.Context(), getValidPart("C Part")); err != nil {
 		t.Fatal(err)
 	}
-	if err := s.CreatePart(getValidPart("A Part")); err != nil {
+	if err := s.CreatePart(t.Context(), getValidPart("A Part")); err != nil {
 		t.Fatal(err)
 	}
-	if err := s.CreatePart(getValidPart("B Part")); err != nil {
+	if err := s.CreatePart(t.Context(), getValidPart("B Part")); err != nil {
 		t.Fatal(err)
 	}
 
-	parts, err := s.GetParts()
+	parts, err := s.GetParts(t.Context())
 	if err != nil {
 		t.Fatalf("GetParts failed: %v", err)
 	}
@@ -104,21 +109,76 @@ func TestStore_GetParts(t *testing.T) {
 	}
 }
 
+func TestStore_ListParts(t *testing.T) {
+	s := newTestStore(t)
+
+	resistor := getValidPart("Resistor")
+	resistor.Manufacturer = sql.NullString{String: "Yageo", Valid: true}
+	resistor.StockTracking = true
+	s.CreatePart(t.Context(), resistor) // ID 1
+	array := getValidPart("Resistor Array")
+	array.Manufacturer = sql.NullString{String: "yageo", Valid: true}
+	s.CreatePart(t.Context(), array) // ID 2
+	capacitor := getValidPart("Capacitor 10%")
+	s.CreatePart(t.Context(), capacitor) // ID 3
+	cat, _ := s.CreateCategory(t.Context(), "Passives")
+	s.AssignCategoryToPart(t.Context(), 3, cat.ID)
+	s.CreateController(t.Context(), &models.WLEDController{Name: "Cabinet A", IPAddress: "1.1.1.1"})
+	s.CreateBin(t.Context(), "A1", 1, 0, 0, false)
+	s.CreatePartLocation(t.Context(), 1, 1, 7)
+
+	tests := []struct {
+		name   string
+		filter models.PartFilter
+		want   []string
+		total  int
+	}{
+		{"all", models.PartFilter{}, []string{"Capacitor 10%", "Resistor", "Resistor Array"}, 3},
+		{"page", models.PartFilter{Limit: 1, Offset: 1}, []string{"Resistor"}, 3},
+		{"past the end", models.PartFilter{Limit: 10, Offset: 5}, []string{}, 3},
+		{"search", models.PartFilter{Search: "resistor", Limit: 1}, []string{"Resistor"}, 2},
+		{"search category", models.PartFilter{Search: "passive"}, []string{"Capacitor 10%"}, 1},
+		{"search literal", models.PartFilter{Search: "r_"}, []string{}, 0},
+		{"manufacturer", models.PartFilter{Manufacturer: "YAGEO"}, []string{"Resistor", "Resistor Array"}, 2},
+		{"tracking", models.PartFilter{StockTracking: sql.NullBool{Bool: true, Valid: true}}, []string{"Resistor"}, 1},
+		{"combined", models.PartFilter{Search: "array", Supplier: "digikey", Status: "ACTIVE"}, []string{"Resistor Array"}, 1},
+	}
+	for _, tc := range tests {
+		parts, total, err := s.ListParts(t.Context(), tc.filter)
+		if err != nil {
+			t.Fatalf("%s: ListParts failed: %v", tc.name, err)
+		}
+		names := []string{}
+		for _, p := range parts {
+			names = append(names, p.Name)
+		}
+		if total != tc.total || !slices.Equal(names, tc.want) {
+			t.Errorf("%s: got %v of %d, want %v of %d", tc.name, names, total, tc.want, tc.total)
+		}
+	}
+
+	// Quantities are summed over the part's locations
+	parts, _, _ := s.ListParts(t.Context(), models.PartFilter{Search: "Resistor", Limit: 1})
+	if len(parts) != 1 || parts[0].TotalQuantity != 7 {
+		t.Errorf("Expected the resistor's total quantity, got %+v", parts)
+	}
+}
+
 func TestStore_GetBinLocationCount(t *testing.T) {
 	s := newTestStore(t)
-	if err := s.CreatePart(getValidPart("P1")); err != nil {
+	if err := s.CreatePart(t.Context(), getValidPart("P1")); err != nil {
 		t.Fatal(err)
 	}
 
-	s.CreateController("C1", "1.1.1.1")
-	s.CreateBin("B1", 1, 0, 0)
-	s.CreateBin("B2", 1, 0, 1)
+	s.CreateController(t.Context(), &models.WLEDController{Name: "C1", IPAddress: "1.1.1.1"})
+	s.CreateBin(t.Context(), "B1", 1, 0, 0, false)
+	s.CreateBin(t.Context(), "B2", 1, 0, 1, false)
 
 	// Add stock to 2 bins
-	s.CreatePartLocation(1, 1, 10)
-	s.CreatePartLocation(1, 2, 5)
+	s.CreatePartLocation(t.Context(), 1, 1, 10)
+	s.CreatePartLocation(t.Context(), 1, 2, 5)
 
-	count, err := s.GetBinLocationCount(1)
+	count, err := s.GetBinLocationCount(t.Context(), 1)
 	if err != nil {
 		t.Fatalf("GetBinLocationCount failed: %v", err)
 	}
@@ -129,17 +189,31 @@ func TestStore_GetBinLocationCount(t *testing.T) {
 
 func TestStore_PartURLs(t *testing.T) {
 	s := newTestStore(t)
-	if err := s.CreatePart(getValidPart("P1")); err != nil {
+	if err := s.CreatePart(t.Context(), getValidPart("P1")); err != nil {
 		t.Fatal(err)
 	}
 
 	// Create URL
-	if err := s.CreatePartURL(1, "http://google.com", "Search"); err != nil {
+	id, err := s.CreatePartURL(t.Context(), 1, "http://google.com", "Search")
+	if err != nil {
 		t.Fatalf("CreatePartURL failed: %v", err)
 	}
 
+	// Update URL
+	u, err := s.GetPartURLByID(t.Context(), id)
+	if err != nil || u.URL != "http://google.com" {
+		t.Fatalf("GetPartURLByID failed: %v", err)
+	}
+	u.Description = sql.NullString{String: "Search engine", Valid: true}
+	if err := s.UpdatePartURL(t.Context(), &u); err != nil {
+		t.Fatalf("UpdatePartURL failed: %v", err)
+	}
+	if u, _ := s.GetPartURLByID(t.Context(), id); u.Description.String != "Search engine" {
+		t.Errorf("URL not updated, got %+v", u)
+	}
+
 	// Get URLs
-	urls, err := s.GetURLsByPartID(1)
+	urls, err := s.GetURLsByPartID(t.Context(), 1)
 	if err != nil {
 		t.Fatalf("GetURLsByPartID failed: %v", err)
 	}
@@ -148,10 +222,10 @@ func TestStore_PartURLs(t *testing.T) {
 	}
 
 	// Delete URL
-	if err := s.DeletePartURL(urls[0].ID); err != nil {
+	if err := s.DeletePartURL(t.Context(), urls[0].ID); err != nil {
 		t.Fatalf("DeletePartURL failed: %v", err)
 	}
-	urls, _ = s.GetURLsByPartID(1)
+	urls, _ = s.GetURLsByPartID(t.Context(), 1)
 	if len(urls) != 0 {
 		t.Errorf("URL not deleted")
 	}
@@ -159,81 +233,166 @@ func TestStore_PartURLs(t *testing.T) {
 
 func TestStore_PartDocs(t *testing.T) {
 	s := newTestStore(t)
-	if err := s.CreatePart(getValidPart("P1")); err != nil {
+	if err := s.CreatePart(t.Context(), getValidPart("P1")); err != nil {
 		t.Fatal(err)
 	}
 
 	doc := &models.PartDocument{
 		PartID: 1, Filename: "test.pdf", Filepath: "docs/test.pdf",
 	}
-	if err := s.CreatePartDocument(doc); err != nil {
+	if err := s.CreatePartDocument(t.Context(), doc); err != nil {
 		t.Fatalf("CreatePartDocument failed: %v", err)
 	}
 
-	docs, err := s.GetDocumentsByPartID(1)
+	docs, err := s.GetDocumentsByPartID(t.Context(), 1)
 	if err != nil || len(docs) != 1 {
 		t.Fatalf("Failed to get docs")
 	}
 
 	// Test Get Single
-	d, err := s.GetDocumentByID(docs[0].ID)
+	d, err := s.GetDocumentByID(t.Context(), doc.ID)
 	if err != nil || d.Filename != "test.pdf" {
 		t.Errorf("GetDocumentByID failed")
 	}
 
-	if err := s.DeletePartDocument(docs[0].ID); err != nil {
+	d.Filename = "datasheet.pdf"
+	if err := s.UpdatePartDocument(t.Context(), &d); err != nil {
+		t.Fatalf("UpdatePartDocument failed: %v", err)
+	}
+	if d, _ := s.GetDocumentByID(t.Context(), doc.ID); d.Filename != "datasheet.pdf" || d.Filepath != "docs/test.pdf" {
+		t.Errorf("Expected only the name to change, got %+v", d)
+	}
+
+	if err := s.DeletePartDocument(t.Context(), docs[0].ID); err != nil {
 		t.Fatalf("Delete failed")
 	}
 }
 
 func TestStore_Categories(t *testing.T) {
 	s := newTestStore(t)
-	if err := s.CreatePart(getValidPart("P1")); err != nil {
+	if err := s.CreatePart(t.Context(), getValidPart("P1")); err != nil {
 		t.Fatal(err)
 	}
 
 	// Create Category
-	cat, err := s.CreateCategory("Resistors")
+	cat, err := s.CreateCategory(t.Context(), "Resistors")
 	if err != nil {
 		t.Fatalf("CreateCategory failed: %v", err)
 	}
 	// Ensure creating same category returns existing ID
-	cat2, _ := s.CreateCategory("Resistors")
+	cat2, _ := s.CreateCategory(t.Context(), "Resistors")
 	if cat.ID != cat2.ID {
 		t.Error("Duplicate category creation should return existing ID")
 	}
 
 	// Assign
-	if err := s.AssignCategoryToPart(1, cat.ID); err != nil {
+	if err := s.AssignCategoryToPart(t.Context(), 1, cat.ID); err != nil {
 		t.Fatalf("Assign failed: %v", err)
 	}
 
 	// Get
-	cats, err := s.GetCategoriesByPartID(1)
+	cats, err := s.GetCategoriesByPartID(t.Context(), 1)
 	if err != nil || len(cats) != 1 || cats[0].Name != "Resistors" {
 		t.Errorf("GetCategoriesByPartID failed")
 	}
 
-	allCats, _ := s.GetCategories()
+	allCats, _ := s.GetCategories(t.Context())
 	if len(allCats) != 1 {
 		t.Errorf("GetCategories failed")
 	}
 
 	// Remove
-	if err := s.RemoveCategoryFromPart(1, cat.ID); err != nil {
+	if err := s.RemoveCategoryFromPart(t.Context(), 1, cat.ID); err != nil {
 		t.Fatalf("Remove failed: %v", err)
 	}
-	cats, _ = s.GetCategoriesByPartID(1)
+	cats, _ = s.GetCategoriesByPartID(t.Context(), 1)
 	if len(cats) != 0 {
 		t.Errorf("Category not removed")
 	}
 
+	// Rename
+	other, _ := s.CreateCategory(t.Context(), "Capacitors")
+	cat.Name = "Capacitors"
+	if err := s.UpdateCategory(t.Context(), &cat); !errors.Is(err, ErrUniqueConstraint) {
+		t.Errorf("Expected ErrUniqueConstraint renaming to a taken name, got %v", err)
+	}
+	cat.Name = "Resistor Networks"
+	if err := s.UpdateCategory(t.Context(), &cat); err != nil {
+		t.Fatalf("UpdateCategory failed: %v", err)
+	}
+	if c, _ := s.GetCategoryByID(t.Context(), cat.ID); c.Name != "Resistor Networks" {
+		t.Errorf("Category not renamed, got %+v", c)
+	}
+
+	// Delete takes it off its parts
+	s.AssignCategoryToPart(t.Context(), 1, other.ID)
+	if err := s.DeleteCategory(t.Context(), other.ID); err != nil {
+		t.Fatalf("DeleteCategory failed: %v", err)
+	}
+	if cats, _ := s.GetCategoriesByPartID(t.Context(), 1); len(cats) != 0 {
+		t.Errorf("Expected the deleted category to be gone from the part, got %v", cats)
+	}
+
 	// Cleanup Orphaned
-	if err := s.CleanupOrphanedCategories(); err != nil {
+	if err := s.CleanupOrphanedCategories(t.Context()); err != nil {
 		t.Fatalf("Cleanup failed: %v", err)
 	}
-	allCats, _ = s.GetCategories()
+	allCats, _ = s.GetCategories(t.Context())
 	if len(allCats) != 0 {
 		t.Errorf("Orphaned category was not cleaned up")
 	}
 }
+
+func TestStore_ListCategories(t *testing.T) {
+	s := newTestStore(t)
+	s.CreatePart(t.Context(), getValidPart("Resistor"))
+	for _, name := range []string{"Passives", "SMD", "Through_hole"} {
+		c, _ := s.CreateCategory(t.Context(), name)
+		if name != "SMD" {
+			s.AssignCategoryToPart(t.Context(), 1, c.ID)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter models.CategoryFilter
+		want   []string
+		total  int
+	}{
+		{"all", models.CategoryFilter{}, []string{"Passives", "SMD", "Through_hole"}, 3},
+		{"page", models.CategoryFilter{Limit: 1, Offset: 1}, []string{"SMD"}, 3},
+		{"search literal", models.CategoryFilter{Search: "h_"}, []string{"Through_hole"}, 1},
+		{"part", models.CategoryFilter{PartID: 1, Limit: 1}, []string{"Passives"}, 2},
+	}
+	for _, tc := range tests {
+		cats, total, err := s.ListCategories(t.Context(), tc.filter)
+		if err != nil {
+			t.Fatalf("%s: ListCategories failed: %v", tc.name, err)
+		}
+		names := []string{}
+		for _, c := range cats {
+			names = append(names, c.Name)
+		}
+		if total != tc.total || !slices.Equal(names, tc.want) {
+			t.Errorf("%s: got %v of %d, want %v of %d", tc.name, names, total, tc.want, tc.total)
+		}
+	}
+}
+
+func TestStore_ListPartURLs(t *testing.T) {
+	s := newTestStore(t)
+	s.CreatePart(t.Context(), getValidPart("Resistor"))
+	s.CreatePart(t.Context(), getValidPart("Capacitor"))
+	for _, u := range []string{"https://a.example", "https://b.example", "https://c.example"} {
+		s.CreatePartURL(t.Context(), 1, u, "")
+	}
+	s.CreatePartURL(t.Context(), 2, "https://other.example", "")
+
+	urls, total, err := s.ListPartURLs(t.Context(), 1, 2, 1)
+	if err != nil {
+		t.Fatalf("ListPartURLs failed: %v", err)
+	}
+	if total != 3 || len(urls) != 2 || urls[0].URL != "https://b.example" {
+		t.Errorf("Expected the second page of 3 URLs, got %+v of %d", urls, total)
+	}
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"context"
+	"wledger/internal/models"
+)
+
+// Stock status preset methods
+
+func (s *Store) GetStockStatusPresets(ctx context.Context) ([]models.StockStatusPreset, error) {
+	rows, err := s.conn().QueryContext(ctx, `
+		SELECT id, name, level, mode, category, supplier, manufacturer, controller_id, location, zone_id
+		FROM stock_status_presets
+		ORDER BY name ASC;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	presets := []models.StockStatusPreset{}
+	for rows.Next() {
+		p, err := scanStockStatusPreset(rows)
+		if err != nil {
+			return nil, err
+		}
+		presets = append(presets, p)
+	}
+	return presets, rows.Err()
+}
+
+func (s *Store) GetStockStatusPresetByID(ctx context.Context, id int) (models.StockStatusPreset, error) {
+	row := s.conn().QueryRowContext(ctx, `
+		SELECT id, name, level, mode, category, supplier, manufacturer, controller_id, location, zone_id
+		FROM stock_status_presets
+		WHERE id = ?;
+	`, id)
+	return scanStockStatusPreset(row)
+}
+
+func (s *Store) CreateStockStatusPreset(ctx context.Context, p *models.StockStatusPreset) error {
+	err := s.conn().QueryRowContext(ctx,
+		`INSERT INTO stock_status_presets (name, level, mode, category, supplier, manufacturer, controller_id, location, zone_id)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		p.Name, p.Level, p.Mode, p.Filter.Category, p.Filter.Supplier, p.Filter.Manufacturer, p.Filter.ControllerID, p.Filter.Location, p.Filter.ZoneID,
+	).Scan(&p.ID)
+	if isUniqueViolation(err) {
+		return ErrUniqueConstraint
+	}
+	return err
+}
+
+func (s *Store) DeleteStockStatusPreset(ctx context.Context, id int) error {
+	_, err := s.conn().ExecContext(ctx, `DELETE FROM stock_status_presets WHERE id = ?`, id)
+	return err
+}
+
+func scanStockStatusPreset(row scanner) (models.StockStatusPreset, error) {
+	var p models.StockStatusPreset
+	err := row.Scan(
+		&p.ID, &p.Name, &p.Level, &p.Mode,
+		&p.Filter.Category, &p.Filter.Supplier, &p.Filter.Manufacturer, &p.Filter.ControllerID, &p.Filter.Location, &p.Filter.ZoneID,
+	)
+	return p, err
+}
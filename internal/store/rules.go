@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"wledger/internal/models"
+	"wledger/internal/stockstatus"
+)
+
+// Stock rule methods
+
+func (s *Store) GetStockRules(ctx context.Context) ([]models.StockRule, error) {
+	rows, err := s.conn().QueryContext(ctx, `
+		SELECT id, name, priority, enabled, conditions, severity, action, color, effect, message
+		FROM stock_rules
+		ORDER BY priority ASC, id ASC;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []models.StockRule{}
+	for rows.Next() {
+		r, err := scanStockRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+func (s *Store) GetStockRuleByID(ctx context.Context, id int) (models.StockRule, error) {
+	row := s.conn().QueryRowContext(ctx, `
+		SELECT id, name, priority, enabled, conditions, severity, action, color, effect, message
+		FROM stock_rules
+		WHERE id = ?;
+	`, id)
+	return scanStockRule(row)
+}
+
+func (s *Store) CreateStockRule(ctx context.Context, r *models.StockRule) error {
+	conditions, err := json.Marshal(r.Conditions)
+	if err != nil {
+		return err
+	}
+	return s.conn().QueryRowContext(ctx,
+		`INSERT INTO stock_rules (name, priority, enabled, conditions, severity, action, color, effect, message)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		r.Name, r.Priority, r.Enabled, string(conditions), r.Severity, r.Action, r.Color, r.Effect, r.Message,
+	).Scan(&r.ID)
+}
+
+func (s *Store) UpdateStockRule(ctx context.Context, r *models.StockRule) error {
+	conditions, err := json.Marshal(r.Conditions)
+	if err != nil {
+		return err
+	}
+	_, err = s.conn().ExecContext(ctx,
+		`UPDATE stock_rules SET name = ?, priority = ?, enabled = ?, conditions = ?, severity = ?,
+		 action = ?, color = ?, effect = ?, message = ? WHERE id = ?`,
+		r.Name, r.Priority, r.Enabled, string(conditions), r.Severity, r.Action, r.Color, r.Effect, r.Message, r.ID,
+	)
+	return err
+}
+
+func (s *Store) DeleteStockRule(ctx context.Context, id int) error {
+	_, err := s.conn().ExecContext(ctx, `DELETE FROM stock_rules WHERE id = ?`, id)
+	return err
+}
+
+// scanner is satisfied by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanStockRule(row scanner) (models.StockRule, error) {
+	var r models.StockRule
+	var conditions string
+	err := row.Scan(&r.ID, &r.Name, &r.Priority, &r.Enabled, &conditions, &r.Severity, &r.Action, &r.Color, &r.Effect, &r.Message)
+	if err != nil {
+		return r, err
+	}
+	if err := json.Unmarshal([]byte(conditions), &r.Conditions); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// seedDefaultStockRules inserts the built-in rule set, unless rules were
+// created before it ran. It's a migration, so rules deleted later stay deleted.
+func seedDefaultStockRules(tx *schemaTx) error {
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM stock_rules`).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	for _, r := range stockstatus.DefaultRules() {
+		conditions, err := json.Marshal(r.Conditions)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			`INSERT INTO stock_rules (name, priority, enabled, conditions, severity, action, color, effect, message)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			r.Name, r.Priority, r.Enabled, string(conditions), r.Severity, r.Action, r.Color, r.Effect, r.Message,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
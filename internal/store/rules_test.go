@@ -0,0 +1,99 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+
+	"wledger/internal/models"
+)
+
+func TestStore_StockRules(t *testing.T) {
+	s := newTestStore(t)
+
+	// Default rule set is seeded on a fresh database
+	rules, err := s.GetStockRules(t.Context())
+	if err != nil {
+		t.Fatalf("GetStockRules failed: %v", err)
+	}
+	if len(rules) != 3 {
+		t.Fatalf("Expected 3 default rules, got %d", len(rules))
+	}
+	if rules[0].Severity != "critical" || len(rules[0].Conditions) != 1 {
+		t.Errorf("Unexpected first default rule: %+v", rules[0])
+	}
+
+	// Create
+	r := &models.StockRule{
+		Name:     "Digi-Key",
+		Priority: 1,
+		Enabled:  true,
+		Severity: "attention",
+		Action:   "color",
+		Color:    "0000FF",
+		Conditions: []models.StockRuleCondition{
+			{Field: "supplier", Operator: "eq", Value: "Digi-Key"},
+		},
+	}
+	if err := s.CreateStockRule(t.Context(), r); err != nil {
+		t.Fatalf("CreateStockRule failed: %v", err)
+	}
+	if r.ID == 0 {
+		t.Fatalf("Expected ID to be set")
+	}
+
+	// Read back
+	got, err := s.GetStockRuleByID(t.Context(), r.ID)
+	if err != nil {
+		t.Fatalf("GetStockRuleByID failed: %v", err)
+	}
+	if got.Name != "Digi-Key" || len(got.Conditions) != 1 || got.Conditions[0].Value != "Digi-Key" {
+		t.Errorf("Rule mismatch: %+v", got)
+	}
+
+	// Lowest priority comes first
+	rules, _ = s.GetStockRules(t.Context())
+	if rules[0].ID != r.ID {
+		t.Errorf("Expected new rule first, got %q", rules[0].Name)
+	}
+
+	// Update
+	got.Enabled = false
+	if err := s.UpdateStockRule(t.Context(), &got); err != nil {
+		t.Fatalf("UpdateStockRule failed: %v", err)
+	}
+	got, _ = s.GetStockRuleByID(t.Context(), r.ID)
+	if got.Enabled {
+		t.Errorf("Expected rule to be disabled")
+	}
+
+	// Delete
+	if err := s.DeleteStockRule(t.Context(), r.ID); err != nil {
+		t.Fatalf("DeleteStockRule failed: %v", err)
+	}
+	if _, err := s.GetStockRuleByID(t.Context(), r.ID); err == nil {
+		t.Errorf("Expected rule to be deleted")
+	}
+}
+
+func TestStore_DefaultStockRulesSeededOnce(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "inventory.db")
+	s, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	rules, _ := s.GetStockRules(t.Context())
+	for _, r := range rules {
+		s.DeleteStockRule(t.Context(), r.ID)
+	}
+	s.db.Close()
+
+	// Deleted rules don't come back on the next start
+	s, err = NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer s.db.Close()
+	if rules, _ := s.GetStockRules(t.Context()); len(rules) != 0 {
+		t.Errorf("Expected the deleted rules to stay deleted, got %d", len(rules))
+	}
+}
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"wledger/internal/models"
+)
+
+// scheduleRunsKept is how many schedule run log entries are kept
+const scheduleRunsKept = 500
+
+// Lighting schedule methods
+
+func (s *Store) GetLightingSchedules(ctx context.Context) ([]models.LightingSchedule, error) {
+	rows, err := s.conn().QueryContext(ctx, `
+		SELECT id, name, cron, action, preset_id, color, brightness, enabled
+		FROM lighting_schedules
+		ORDER BY name ASC, id ASC;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []models.LightingSchedule{}
+	for rows.Next() {
+		ls, err := scanLightingSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, ls)
+	}
+	return schedules, rows.Err()
+}
+
+func (s *Store) GetLightingScheduleByID(ctx context.Context, id int) (models.LightingSchedule, error) {
+	row := s.conn().QueryRowContext(ctx, `
+		SELECT id, name, cron, action, preset_id, color, brightness, enabled
+		FROM lighting_schedules
+		WHERE id = ?;
+	`, id)
+	return scanLightingSchedule(row)
+}
+
+func (s *Store) CreateLightingSchedule(ctx context.Context, ls *models.LightingSchedule) error {
+	return s.conn().QueryRowContext(ctx,
+		`INSERT INTO lighting_schedules (name, cron, action, preset_id, color, brightness, enabled)
+		 VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		ls.Name, ls.Cron, ls.Action, ls.PresetID, ls.Color, ls.Brightness, ls.Enabled,
+	).Scan(&ls.ID)
+}
+
+func (s *Store) UpdateLightingSchedule(ctx context.Context, ls *models.LightingSchedule) error {
+	_, err := s.conn().ExecContext(ctx,
+		`UPDATE lighting_schedules
+		 SET name = ?, cron = ?, action = ?, preset_id = ?, color = ?, brightness = ?, enabled = ?
+		 WHERE id = ?`,
+		ls.Name, ls.Cron, ls.Action, ls.PresetID, ls.Color, ls.Brightness, ls.Enabled, ls.ID,
+	)
+	return err
+}
+
+func (s *Store) DeleteLightingSchedule(ctx context.Context, id int) error {
+	_, err := s.conn().ExecContext(ctx, `DELETE FROM lighting_schedules WHERE id = ?`, id)
+	return err
+}
+
+func scanLightingSchedule(row scanner) (models.LightingSchedule, error) {
+	var ls models.LightingSchedule
+	err := row.Scan(&ls.ID, &ls.Name, &ls.Cron, &ls.Action, &ls.PresetID, &ls.Color, &ls.Brightness, &ls.Enabled)
+	return ls, err
+}
+
+// Schedule run log methods
+
+// RecordScheduleRun logs a schedule run and trims the log to the newest entries
+func (s *Store) RecordScheduleRun(ctx context.Context, run *models.ScheduleRun) error {
+	err := s.conn().QueryRowContext(ctx,
+		`INSERT INTO schedule_runs (schedule_id, schedule_name, action, started_at, duration_ms, status, message)
+		 VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		run.ScheduleID, run.ScheduleName, run.Action, run.StartedAt, run.Duration.Milliseconds(), run.Status, run.Message,
+	).Scan(&run.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.conn().ExecContext(ctx, `DELETE FROM schedule_runs WHERE id <= ?`, run.ID-scheduleRunsKept)
+	return err
+}
+
+// GetScheduleRuns returns the most recent schedule runs, newest first
+func (s *Store) GetScheduleRuns(ctx context.Context, limit int) ([]models.ScheduleRun, error) {
+	rows, err := s.conn().QueryContext(ctx, `
+		SELECT id, schedule_id, schedule_name, action, started_at, duration_ms, status, message
+		FROM schedule_runs
+		ORDER BY id DESC
+		LIMIT ?;
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []models.ScheduleRun{}
+	for rows.Next() {
+		var run models.ScheduleRun
+		var durationMs int64
+		if err := rows.Scan(&run.ID, &run.ScheduleID, &run.ScheduleName, &run.Action, &run.StartedAt, &durationMs, &run.Status, &run.Message); err != nil {
+			return nil, err
+		}
+		run.Duration = time.Duration(durationMs) * time.Millisecond
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
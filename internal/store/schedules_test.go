@@ -0,0 +1,73 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"wledger/internal/models"
+)
+
+func TestStore_LightingSchedules(t *testing.T) {
+	s := newTestStore(t)
+
+	ls := &models.LightingSchedule{
+		Name:       "Quiet hours",
+		Cron:       "0 22 * * *",
+		Action:     "quiet_start",
+		Brightness: 0,
+		Enabled:    true,
+	}
+	if err := s.CreateLightingSchedule(t.Context(), ls); err != nil {
+		t.Fatalf("CreateLightingSchedule failed: %v", err)
+	}
+	if ls.ID == 0 {
+		t.Fatalf("Expected ID to be set")
+	}
+
+	ls.Enabled = false
+	ls.Brightness = 20
+	if err := s.UpdateLightingSchedule(t.Context(), ls); err != nil {
+		t.Fatalf("UpdateLightingSchedule failed: %v", err)
+	}
+	got, err := s.GetLightingScheduleByID(t.Context(), ls.ID)
+	if err != nil {
+		t.Fatalf("GetLightingScheduleByID failed: %v", err)
+	}
+	if got.Enabled || got.Brightness != 20 || got.Cron != "0 22 * * *" {
+		t.Errorf("Schedule mismatch: %+v", got)
+	}
+
+	// Runs keep their schedule's name after it is deleted
+	run := &models.ScheduleRun{
+		ScheduleID:   sql.NullInt64{Int64: int64(ls.ID), Valid: true},
+		ScheduleName: ls.Name,
+		Action:       ls.Action,
+		StartedAt:    time.Now(),
+		Duration:     1500 * time.Millisecond,
+		Status:       "ok",
+		Message:      "LEDs turned off",
+	}
+	if err := s.RecordScheduleRun(t.Context(), run); err != nil {
+		t.Fatalf("RecordScheduleRun failed: %v", err)
+	}
+
+	if err := s.DeleteLightingSchedule(t.Context(), ls.ID); err != nil {
+		t.Fatalf("DeleteLightingSchedule failed: %v", err)
+	}
+	schedules, _ := s.GetLightingSchedules(t.Context())
+	if len(schedules) != 0 {
+		t.Errorf("Expected no schedules, got %d", len(schedules))
+	}
+
+	runs, err := s.GetScheduleRuns(t.Context(), 10)
+	if err != nil {
+		t.Fatalf("GetScheduleRuns failed: %v", err)
+	}
+	if len(runs) != 1 {
+		t.Fatalf("Expected 1 run, got %d", len(runs))
+	}
+	if runs[0].ScheduleID.Valid || runs[0].ScheduleName != "Quiet hours" || runs[0].Duration != 1500*time.Millisecond {
+		t.Errorf("Run mismatch: %+v", runs[0])
+	}
+}
@@ -4,21 +4,34 @@ import (
 	"database/sql"
 	"errors"
 	"log"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
 var ErrForeignKeyConstraint = errors.New("foreign key constraint violation")
 var ErrUniqueConstraint = errors.New("unique constraint violation")
+var ErrInvalidOffset = errors.New("offset would move bins below segment or LED 0")
+var ErrLEDConflict = errors.New("LED is already used by another bin")
+var ErrBackupTooNew = errors.New("backup is from a newer schema version")
+var ErrLastAdmin = errors.New("at least one admin is required")
+var ErrUsersExist = errors.New("the first user has already been created")
 
 // Store holds the database connection
 type Store struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect dialect
+	tx      *sql.Tx // Set in the copy WithTx passes to its fn
 }
 
-// NewStore initializes the database and returns a new Store
+// NewStore initializes the SQLite database file and returns a new Store
 func NewStore(filepath string) (*Store, error) {
 	log.Println("Initializing database...")
 
-	db, err := sql.Open("sqlite", filepath)
+	// Writers wait for each other instead of failing with SQLITE_BUSY,
+	// e.g. when two background jobs finish at once. Both pragmas are per
+	// connection, so they're set for every connection the pool opens;
+	// ON DELETE clauses only run with foreign keys on.
+	db, err := sql.Open(sqliteDialect.driver(), filepath+"?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)")
 	if err != nil {
 		return nil, err
 	}
@@ -29,36 +42,83 @@ func NewStore(filepath string) (*Store, error) {
 		return nil, err
 	}
 
-	_, err = db.Exec("PRAGMA foreign_keys = ON;")
+	return open(db, sqliteDialect, filepath)
+}
+
+// NewPostgresStore connects to a PostgreSQL database and returns a new
+// Store. The DSN is a URL or a list of key=value settings, see
+// https://pkg.go.dev/github.com/jackc/pgx/v5/pgconn#ParseConfig
+func NewPostgresStore(dsn string) (*Store, error) {
+	log.Println("Connecting to the database...")
+
+	db, err := sql.Open(postgresDialect.driver(), dsn)
 	if err != nil {
 		return nil, err
 	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return open(db, postgresDialect, "")
+}
 
-	// Run Migrations
-	if err := createTables(db); err != nil {
+// open migrates the database and returns the Store using it. The path of
+// a SQLite file is where it's backed up to before migrating.
+func open(db *sql.DB, d dialect, path string) (*Store, error) {
+	if err := migrate(db, d, path); err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	log.Println("Database initialized successfully.")
-	return &Store{db: db}, nil
+	log.Printf("Database initialized successfully (%s, schema v%d).", d, LatestSchemaVersion())
+	return &Store{db: db, dialect: d}, nil
+}
+
+// Close closes the database, after checkpointing the write-ahead log of
+// a SQLite database into its file. The Store can't be used afterwards.
+func (s *Store) Close() error {
+	if s.dialect != sqliteDialect {
+		return s.db.Close()
+	}
+	if _, err := s.db.Exec("PRAGMA wal_checkpoint(TRUNCATE);"); err != nil {
+		s.db.Close()
+		return err
+	}
+	return s.db.Close()
 }
 
-// createTables runs all the CREATE TABLE IF NOT EXISTS queries
-func createTables(db *sql.DB) error {
+// createTables runs all the CREATE TABLE IF NOT EXISTS queries. It's the
+// first migration, so new tables and columns go in a new migration instead.
+func createTables(tx *schemaTx) error {
 	queries := []string{
+		`CREATE TABLE IF NOT EXISTS zones (
+			id            INTEGER PRIMARY KEY AUTOINCREMENT,
+			name          TEXT NOT NULL UNIQUE
+		);`,
 		`CREATE TABLE IF NOT EXISTS wled_controllers (
 			id            INTEGER PRIMARY KEY AUTOINCREMENT,
 			name          TEXT NOT NULL,
 			ip_address    TEXT NOT NULL UNIQUE,
 			status        TEXT NOT NULL DEFAULT 'unknown',
-			last_seen     DATETIME
+			last_seen     DATETIME,
+			restore_state BOOLEAN NOT NULL DEFAULT 0,
+			zone_id       INTEGER REFERENCES zones (id) ON DELETE SET NULL,
+			scheme        TEXT NOT NULL DEFAULT 'http',
+			host          TEXT NOT NULL DEFAULT '',
+			port          INTEGER NOT NULL DEFAULT 0,
+			base_path     TEXT NOT NULL DEFAULT '',
+			username      TEXT NOT NULL DEFAULT '',
+			password      TEXT NOT NULL DEFAULT ''
 		);`,
 		`CREATE TABLE IF NOT EXISTS bins (
 			id                     INTEGER PRIMARY KEY AUTOINCREMENT,
 			name                   TEXT NOT NULL UNIQUE,
-			wled_controller_id     INTEGER NOT NULL,
+			wled_controller_id     INTEGER, -- NULL once detached from its controller
 			wled_segment_id        INTEGER NOT NULL,
 			led_index              INTEGER NOT NULL,
+			capacity               INTEGER NOT NULL DEFAULT 0,
+			zone_id                INTEGER REFERENCES zones (id) ON DELETE SET NULL, -- NULL uses the controller's zone
+			shared_led             BOOLEAN NOT NULL DEFAULT 0, -- Deliberately shares its LED with other bins
 			FOREIGN KEY (wled_controller_id) REFERENCES wled_controllers (id)
 		);`,
 		`CREATE TABLE IF NOT EXISTS parts (
@@ -84,6 +144,9 @@ func createTables(db *sql.DB) error {
 			part_id       INTEGER NOT NULL,
 			bin_id        INTEGER NOT NULL,
 			quantity      INTEGER NOT NULL DEFAULT 0,
+			reorder_point INTEGER,
+			min_stock     INTEGER,
+			capacity      INTEGER,
 			
 			-- MUST HAVE 'ON DELETE CASCADE' TO PASS THE TEST
 			FOREIGN KEY (part_id) REFERENCES parts (id) ON DELETE CASCADE,
@@ -117,12 +180,109 @@ func createTables(db *sql.DB) error {
 			FOREIGN KEY (part_id) REFERENCES parts (id) ON DELETE CASCADE,
 			FOREIGN KEY (category_id) REFERENCES categories (id) ON DELETE CASCADE
 		);`,
+		`CREATE TABLE IF NOT EXISTS stock_rules (
+			id            INTEGER PRIMARY KEY AUTOINCREMENT,
+			name          TEXT NOT NULL,
+			priority      INTEGER NOT NULL DEFAULT 0,
+			enabled       BOOLEAN NOT NULL DEFAULT 1,
+			conditions    TEXT NOT NULL DEFAULT '[]', -- JSON list of conditions
+			severity      TEXT NOT NULL DEFAULT 'ok',
+			action        TEXT NOT NULL DEFAULT 'color',
+			color         TEXT NOT NULL DEFAULT '',
+			effect        INTEGER NOT NULL DEFAULT 0,
+			message       TEXT NOT NULL DEFAULT ''
+		);`,
+		`CREATE TABLE IF NOT EXISTS stock_status_presets (
+			id            INTEGER PRIMARY KEY AUTOINCREMENT,
+			name          TEXT NOT NULL UNIQUE,
+			level         TEXT NOT NULL DEFAULT 'all',
+			mode          TEXT NOT NULL DEFAULT 'bin',
+			category      TEXT NOT NULL DEFAULT '',
+			supplier      TEXT NOT NULL DEFAULT '',
+			manufacturer  TEXT NOT NULL DEFAULT '',
+			controller_id INTEGER NOT NULL DEFAULT 0, -- 0 means any controller, not a foreign key
+			location      TEXT NOT NULL DEFAULT '',
+			zone_id       INTEGER NOT NULL DEFAULT 0 -- 0 means any zone, not a foreign key
+		);`,
+		`CREATE TABLE IF NOT EXISTS lighting_schedules (
+			id            INTEGER PRIMARY KEY AUTOINCREMENT,
+			name          TEXT NOT NULL,
+			cron          TEXT NOT NULL,
+			action        TEXT NOT NULL,
+			preset_id     INTEGER NOT NULL DEFAULT 0, -- 0 means no preset, not a foreign key
+			color         TEXT NOT NULL DEFAULT '',
+			brightness    INTEGER NOT NULL DEFAULT 0,
+			enabled       BOOLEAN NOT NULL DEFAULT 1
+		);`,
+		`CREATE TABLE IF NOT EXISTS controller_health_probes (
+			id            INTEGER PRIMARY KEY AUTOINCREMENT,
+			controller_id INTEGER NOT NULL,
+			checked_at    DATETIME NOT NULL,
+			online        BOOLEAN NOT NULL,
+			latency_ms    INTEGER NOT NULL DEFAULT 0,
+			error         TEXT NOT NULL DEFAULT '',
+			FOREIGN KEY (controller_id) REFERENCES wled_controllers (id) ON DELETE CASCADE
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_health_probes_controller ON controller_health_probes (controller_id, checked_at);`,
+		`CREATE TABLE IF NOT EXISTS schedule_runs (
+			id            INTEGER PRIMARY KEY AUTOINCREMENT,
+			schedule_id   INTEGER,
+			schedule_name TEXT NOT NULL,
+			action        TEXT NOT NULL,
+			started_at    DATETIME NOT NULL,
+			duration_ms   INTEGER NOT NULL DEFAULT 0,
+			status        TEXT NOT NULL,
+			message       TEXT NOT NULL DEFAULT '',
+			FOREIGN KEY (schedule_id) REFERENCES lighting_schedules (id) ON DELETE SET NULL
+		);`,
 	}
 
 	for _, query := range queries {
-		if _, err := db.Exec(query); err != nil {
+		if _, err := tx.Exec(tx.dialect.schema(query)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addMissingColumns adds the columns added before migrations were versioned.
+// CREATE TABLE IF NOT EXISTS won't add these to an existing database.
+func addMissingColumns(tx *schemaTx) error {
+	columns := []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		{"part_locations", "reorder_point", "INTEGER"},
+		{"part_locations", "min_stock", "INTEGER"},
+		{"part_locations", "capacity", "INTEGER"},
+		{"bins", "capacity", "INTEGER NOT NULL DEFAULT 0"},
+		{"wled_controllers", "restore_state", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"wled_controllers", "zone_id", "INTEGER REFERENCES zones (id) ON DELETE SET NULL"},
+		{"bins", "zone_id", "INTEGER REFERENCES zones (id) ON DELETE SET NULL"},
+		{"stock_status_presets", "zone_id", "INTEGER NOT NULL DEFAULT 0"},
+		{"wled_controllers", "scheme", "TEXT NOT NULL DEFAULT 'http'"},
+		{"wled_controllers", "host", "TEXT NOT NULL DEFAULT ''"},
+		{"wled_controllers", "port", "INTEGER NOT NULL DEFAULT 0"},
+		{"wled_controllers", "base_path", "TEXT NOT NULL DEFAULT ''"},
+		{"wled_controllers", "username", "TEXT NOT NULL DEFAULT ''"},
+		{"wled_controllers", "password", "TEXT NOT NULL DEFAULT ''"},
+		{"bins", "shared_led", "BOOLEAN NOT NULL DEFAULT 0"},
+	}
+	for _, c := range columns {
+		if err := ensureColumn(tx, c.table, c.column, c.definition); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// ensureColumn adds a column to a table if it doesn't exist yet
+func ensureColumn(tx *schemaTx, table, column, definition string) error {
+	exists, _, err := tx.column(table, column)
+	if err != nil || exists {
+		return err
+	}
+	_, err = tx.Exec(tx.dialect.schema("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition))
+	return err
+}
@@ -1,17 +1,32 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
 	"wledger/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
 )
 
-// newTestStore creates an in-memory SQLite database for tests
+// postgresTestDSN names the environment variable with the DSN of a
+// PostgreSQL database. If it's set, the tests run against it instead of SQLite.
+const postgresTestDSN = "WLEDGER_TEST_POSTGRES_DSN"
+
+// newTestStore creates an in-memory SQLite database for tests, or a new
+// schema in the PostgreSQL test database
 func newTestStore(t *testing.T) *Store {
+	if dsn := os.Getenv(postgresTestDSN); dsn != "" {
+		return newPostgresTestStore(t, dsn)
+	}
+
 	// Use in-memory database
 	db, err := sql.Open("sqlite", ":memory:")
 	if err != nil {
@@ -31,11 +46,45 @@ func newTestStore(t *testing.T) *Store {
 	}
 
 	// Run migrations
-	if err := createTables(db); err != nil {
-		t.Fatalf("Failed to create tables: %v", err)
+	if err := migrate(db, sqliteDialect, ""); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+
+	return &Store{db: db, dialect: sqliteDialect}
+}
+
+// newPostgresTestStore migrates a schema of its own in the database, so
+// every test starts empty. The schema is dropped after the test.
+func newPostgresTestStore(t *testing.T, dsn string) *Store {
+	t.Helper()
+	admin, err := sql.Open(postgresDialect.driver(), dsn)
+	if err != nil {
+		t.Fatalf("Failed to open the PostgreSQL database: %v", err)
+	}
+	schema := fmt.Sprintf("wledger_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		admin.Close()
+		t.Fatalf("Failed to create schema %s: %v", schema, err)
 	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Errorf("Failed to drop schema %s: %v", schema, err)
+		}
+		admin.Close()
+	})
 
-	return &Store{db: db}
+	config, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		t.Fatalf("Invalid %s: %v", postgresTestDSN, err)
+	}
+	config.RuntimeParams["search_path"] = schema
+	db := stdlib.OpenDB(*config)
+	t.Cleanup(func() { db.Close() })
+
+	if err := migrate(db, postgresDialect, ""); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	return &Store{db: db, dialect: postgresDialect}
 }
 
 func TestStore_CreatePart(t *testing.T) {
@@ -56,12 +105,12 @@ func TestStore_CreatePart(t *testing.T) {
 		MinStock:      0,
 	}
 
-	err := s.CreatePart(part)
+	err := s.CreatePart(t.Context(), part)
 	if err != nil {
 		t.Fatalf("CreatePart() failed: %v", err)
 	}
 
-	got, err := s.GetPartByID(1)
+	got, err := s.GetPartByID(t.Context(), 1)
 	if err != nil {
 		t.Fatalf("GetPartByID() failed: %v", err)
 	}
@@ -77,16 +126,16 @@ func TestStore_CreatePart(t *testing.T) {
 func TestStore_CreateBin_Duplicate(t *testing.T) {
 	s := newTestStore(t)
 
-	if err := s.CreateController("Test C", "1.2.3.4"); err != nil {
+	if err := s.CreateController(t.Context(), &models.WLEDController{Name: "Test C", IPAddress: "1.2.3.4"}); err != nil {
 		t.Fatalf("CreateController() failed: %v", err)
 	}
 
-	err := s.CreateBin("A1-1", 1, 0, 1)
+	_, err := s.CreateBin(t.Context(), "A1-1", 1, 0, 1, false)
 	if err != nil {
 		t.Fatalf("CreateBin() (first) failed: %v", err)
 	}
 
-	err = s.CreateBin("A1-1", 1, 0, 1)
+	_, err = s.CreateBin(t.Context(), "A1-1", 1, 0, 1, false)
 
 	if err == nil {
 		t.Fatal("CreateBin() (second) did not return an error, but it should have")
@@ -112,19 +161,19 @@ func TestStore_SearchParts_ByTag(t *testing.T) {
 		ReorderPoint:  0,
 		MinStock:      0,
 	}
-	if err := s.CreatePart(part); err != nil {
+	if err := s.CreatePart(t.Context(), part); err != nil {
 		t.Fatalf("CreatePart() failed: %v", err)
 	}
 
-	cat, err := s.CreateCategory("MCU")
+	cat, err := s.CreateCategory(t.Context(), "MCU")
 	if err != nil {
 		t.Fatalf("CreateCategory() failed: %v", err)
 	}
-	if err := s.AssignCategoryToPart(1, cat.ID); err != nil {
+	if err := s.AssignCategoryToPart(t.Context(), 1, cat.ID); err != nil {
 		t.Fatalf("AssignCategoryToPart() failed: %v", err)
 	}
 
-	parts, err := s.SearchParts("MCU")
+	parts, err := s.SearchParts(t.Context(), "MCU")
 	if err != nil {
 		t.Fatalf("SearchParts() failed: %v", err)
 	}
@@ -149,7 +198,166 @@ func TestStore_NewStore_Physical(t *testing.T) {
 	}
 
 	// Verify basic functionality works on physical DB
-	if err := s.CreatePart(getValidPart("Real DB Part")); err != nil {
+	if err := s.CreatePart(t.Context(), getValidPart("Real DB Part")); err != nil {
 		t.Errorf("Failed to write to physical DB: %v", err)
 	}
 }
+
+func TestStore_NewStore_ForeignKeysOnEveryConnection(t *testing.T) {
+	s, err := NewStore(filepath.Join(t.TempDir(), "test_inventory.db"))
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer s.Close()
+
+	zone := &models.Zone{Name: "Wall"}
+	s.CreateZone(t.Context(), zone)
+	s.CreateController(t.Context(), &models.WLEDController{Name: "Wall", IPAddress: "10.0.0.1"})
+	c, _ := s.GetControllerByID(t.Context(), 1)
+	c.ZoneID = zone.ID
+	s.UpdateController(t.Context(), &c)
+
+	// Holding a connection makes the pool open another for the delete
+	held, err := s.db.Conn(t.Context())
+	if err != nil {
+		t.Fatalf("Conn failed: %v", err)
+	}
+	defer held.Close()
+
+	if err := s.DeleteZone(t.Context(), zone.ID); err != nil {
+		t.Fatalf("DeleteZone failed: %v", err)
+	}
+	if c, _ := s.GetControllerByID(t.Context(), 1); c.ZoneID != 0 {
+		t.Errorf("Expected the controller to be left without a zone, got zone %d", c.ZoneID)
+	}
+}
+
+func TestStore_Close(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test_inventory.db")
+	s, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	if err := s.CreatePart(t.Context(), getValidPart("Checkpointed Part")); err != nil {
+		t.Fatalf("CreatePart failed: %v", err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	// The write-ahead log was checkpointed into the database file
+	if info, err := os.Stat(dbPath + "-wal"); err == nil && info.Size() > 0 {
+		t.Errorf("Expected an empty WAL after closing, got %d bytes", info.Size())
+	}
+
+	s, err = NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore failed on reopening: %v", err)
+	}
+	defer s.Close()
+	if p, err := s.GetPartByID(t.Context(), 1); err != nil || p.Name != "Checkpointed Part" {
+		t.Errorf("Part lost after closing: %+v, %v", p, err)
+	}
+}
+
+func TestStore_CancelledContext(t *testing.T) {
+	s := newTestStore(t)
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	if _, err := s.GetParts(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetParts: expected context.Canceled, got %v", err)
+	}
+	if err := s.CreatePart(ctx, getValidPart("Never Saved")); !errors.Is(err, context.Canceled) {
+		t.Errorf("CreatePart: expected context.Canceled, got %v", err)
+	}
+	if parts, err := s.GetParts(t.Context()); err != nil || len(parts) != 0 {
+		t.Errorf("Expected no parts, got %d (%v)", len(parts), err)
+	}
+}
+
+func TestStore_Migrate_AddsMissingColumns(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open in-memory database: %v", err)
+	}
+
+	// Simulate a database created before per-location thresholds existed
+	_, err = db.Exec(`CREATE TABLE part_locations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		part_id INTEGER NOT NULL,
+		bin_id INTEGER NOT NULL,
+		quantity INTEGER NOT NULL DEFAULT 0
+	);`)
+	if err != nil {
+		t.Fatalf("Failed to create legacy table: %v", err)
+	}
+
+	if err := migrate(db, sqliteDialect, ""); err != nil {
+		t.Fatalf("migrate failed on legacy schema: %v", err)
+	}
+	// Running twice must be a no-op
+	if err := migrate(db, sqliteDialect, ""); err != nil {
+		t.Fatalf("migrate failed on second run: %v", err)
+	}
+
+	if _, err := db.Exec("SELECT reorder_point, min_stock FROM part_locations"); err != nil {
+		t.Errorf("Expected threshold columns to exist: %v", err)
+	}
+}
+
+func TestStore_MigrateDetachableBins(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+
+	// Simulate a database created before bins could be detached
+	legacy := []string{
+		`CREATE TABLE wled_controllers (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL, ip_address TEXT NOT NULL UNIQUE, status TEXT NOT NULL DEFAULT 'unknown', last_seen DATETIME);`,
+		`CREATE TABLE bins (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			wled_controller_id INTEGER NOT NULL,
+			wled_segment_id INTEGER NOT NULL,
+			led_index INTEGER NOT NULL,
+			FOREIGN KEY (wled_controller_id) REFERENCES wled_controllers (id)
+		);`,
+		`CREATE TABLE part_locations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			part_id INTEGER NOT NULL,
+			bin_id INTEGER NOT NULL,
+			quantity INTEGER NOT NULL DEFAULT 0,
+			FOREIGN KEY (bin_id) REFERENCES bins (id) ON DELETE CASCADE
+		);`,
+		`INSERT INTO wled_controllers (name, ip_address) VALUES ('C1', '1.1.1.1');`,
+		`INSERT INTO bins (name, wled_controller_id, wled_segment_id, led_index) VALUES ('B1', 1, 0, 3);`,
+		`INSERT INTO part_locations (part_id, bin_id, quantity) VALUES (1, 1, 7);`,
+	}
+	for _, q := range legacy {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("Failed to create legacy schema: %v", err)
+		}
+	}
+	db.Close()
+
+	s, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore failed on legacy schema: %v", err)
+	}
+
+	b, err := s.GetBinByID(t.Context(), 1)
+	if err != nil || b.LEDIndex != 3 || b.WLEDControllerID != 1 {
+		t.Fatalf("Bin lost in migration: %+v, %v", b, err)
+	}
+	var quantity int
+	if err := s.db.QueryRow("SELECT quantity FROM part_locations WHERE bin_id = 1").Scan(&quantity); err != nil || quantity != 7 {
+		t.Errorf("Stock lost in migration: %d, %v", quantity, err)
+	}
+
+	b.WLEDControllerID = 0
+	if err := s.UpdateBin(t.Context(), &b); err != nil {
+		t.Errorf("Expected the bin to be detachable after migrating: %v", err)
+	}
+}
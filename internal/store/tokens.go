@@ -0,0 +1,204 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"wledger/internal/models"
+)
+
+// apiTokenEventsKept is how many API token audit log entries are kept
+const apiTokenEventsKept = 1000
+
+// createAPITokens adds the API tokens and their audit log. Like sessions,
+// tokens are stored as a hash.
+func createAPITokens(tx *schemaTx) error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS api_tokens (
+			id              INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id         INTEGER NOT NULL,
+			name            TEXT NOT NULL,
+			token_hash      TEXT NOT NULL UNIQUE,
+			prefix          TEXT NOT NULL,
+			scopes          TEXT NOT NULL,
+			expires_at      DATETIME NOT NULL,
+			last_used_at    DATETIME,
+			created_at      DATETIME NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_api_tokens_user ON api_tokens (user_id);`,
+		`CREATE TABLE IF NOT EXISTS api_token_events (
+			id              INTEGER PRIMARY KEY AUTOINCREMENT,
+			token_id        INTEGER NOT NULL,
+			token_name      TEXT NOT NULL,
+			user_id         INTEGER NOT NULL,
+			username        TEXT NOT NULL,
+			at              DATETIME NOT NULL,
+			event           TEXT NOT NULL,
+			detail          TEXT NOT NULL DEFAULT ''
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_api_token_events_user ON api_token_events (user_id, id);`,
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(tx.dialect.schema(query)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// API token methods. Expiry and use times are stored like probe times,
+// see probeTime.
+
+const apiTokenColumns = `t.id, t.user_id, u.username, t.name, t.prefix, t.scopes, t.expires_at, t.last_used_at, t.created_at`
+
+// GetAPITokens returns a user's tokens, or everyone's for userID 0
+func (s *Store) GetAPITokens(ctx context.Context, userID int) ([]models.APIToken, error) {
+	rows, err := s.conn().QueryContext(ctx, `
+		SELECT `+apiTokenColumns+`
+		FROM api_tokens t
+		JOIN users u ON t.user_id = u.id
+		WHERE ? = 0 OR t.user_id = ?
+		ORDER BY u.username ASC, t.name ASC, t.id ASC;
+	`, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []models.APIToken{}
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *Store) GetAPITokenByID(ctx context.Context, id int) (models.APIToken, error) {
+	row := s.conn().QueryRowContext(ctx, `
+		SELECT `+apiTokenColumns+`
+		FROM api_tokens t
+		JOIN users u ON t.user_id = u.id
+		WHERE t.id = ?;
+	`, id)
+	return scanAPIToken(row)
+}
+
+// GetAPITokenByHash returns the token with the hash and its user, expired
+// or not, or sql.ErrNoRows if there's no such token
+func (s *Store) GetAPITokenByHash(ctx context.Context, tokenHash string) (models.APIToken, models.User, error) {
+	var t models.APIToken
+	var u models.User
+	var scopes string
+	err := s.conn().QueryRowContext(ctx, `
+		SELECT `+apiTokenColumns+`, u.password_hash, u.role, u.created_at
+		FROM api_tokens t
+		JOIN users u ON t.user_id = u.id
+		WHERE t.token_hash = ?;
+	`, tokenHash).Scan(&t.ID, &t.UserID, &t.Username, &t.Name, &t.Prefix, &scopes, &t.ExpiresAt, &t.LastUsedAt, &t.CreatedAt,
+		&u.PasswordHash, &u.Role, &u.CreatedAt)
+	t.Scopes = splitScopes(scopes)
+	u.ID, u.Username = t.UserID, t.Username
+	return t, u, err
+}
+
+func (s *Store) CreateAPIToken(ctx context.Context, t *models.APIToken, tokenHash string) error {
+	t.CreatedAt = time.Now()
+	t.ExpiresAt = probeTime(t.ExpiresAt)
+	return s.conn().QueryRowContext(ctx,
+		`INSERT INTO api_tokens (user_id, name, token_hash, prefix, scopes, expires_at, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		t.UserID, t.Name, tokenHash, t.Prefix, joinScopes(t.Scopes), t.ExpiresAt, t.CreatedAt,
+	).Scan(&t.ID)
+}
+
+// TouchAPIToken records that a token was used. The time is only updated
+// once a minute, so busy scripts don't write on every request.
+func (s *Store) TouchAPIToken(ctx context.Context, id int, at time.Time) error {
+	at = probeTime(at)
+	_, err := s.conn().ExecContext(ctx,
+		`UPDATE api_tokens SET last_used_at = ?
+		 WHERE id = ? AND (last_used_at IS NULL OR last_used_at <= ?)`,
+		at, id, at.Add(-time.Minute),
+	)
+	return err
+}
+
+func (s *Store) DeleteAPIToken(ctx context.Context, id int) error {
+	_, err := s.conn().ExecContext(ctx, `DELETE FROM api_tokens WHERE id = ?`, id)
+	return err
+}
+
+func scanAPIToken(row scanner) (models.APIToken, error) {
+	var t models.APIToken
+	var scopes string
+	err := row.Scan(&t.ID, &t.UserID, &t.Username, &t.Name, &t.Prefix, &scopes, &t.ExpiresAt, &t.LastUsedAt, &t.CreatedAt)
+	t.Scopes = splitScopes(scopes)
+	return t, err
+}
+
+// Scopes are stored as a comma separated list
+func joinScopes(scopes []models.Scope) string {
+	names := make([]string, len(scopes))
+	for i, s := range scopes {
+		names[i] = string(s)
+	}
+	return strings.Join(names, ",")
+}
+
+func splitScopes(scopes string) []models.Scope {
+	list := []models.Scope{}
+	for _, s := range strings.Split(scopes, ",") {
+		if s != "" {
+			list = append(list, models.Scope(s))
+		}
+	}
+	return list
+}
+
+// API token audit log methods
+
+// RecordAPITokenEvent adds an entry to the audit log, keeping the newest entries only
+func (s *Store) RecordAPITokenEvent(ctx context.Context, e *models.APITokenEvent) error {
+	err := s.conn().QueryRowContext(ctx,
+		`INSERT INTO api_token_events (token_id, token_name, user_id, username, at, event, detail)
+		 VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		e.TokenID, e.TokenName, e.UserID, e.Username, e.At, e.Event, e.Detail,
+	).Scan(&e.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.conn().ExecContext(ctx, `DELETE FROM api_token_events WHERE id <= ?`, e.ID-apiTokenEventsKept)
+	return err
+}
+
+// GetAPITokenEvents returns the newest audit log entries of a user's
+// tokens, or of everyone's for userID 0
+func (s *Store) GetAPITokenEvents(ctx context.Context, userID, limit int) ([]models.APITokenEvent, error) {
+	rows, err := s.conn().QueryContext(ctx, `
+		SELECT id, token_id, token_name, user_id, username, at, event, detail
+		FROM api_token_events
+		WHERE ? = 0 OR user_id = ?
+		ORDER BY id DESC
+		LIMIT ?;
+	`, userID, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.APITokenEvent{}
+	for rows.Next() {
+		var e models.APITokenEvent
+		if err := rows.Scan(&e.ID, &e.TokenID, &e.TokenName, &e.UserID, &e.Username, &e.At, &e.Event, &e.Detail); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
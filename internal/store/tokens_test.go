@@ -0,0 +1,93 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"wledger/internal/models"
+)
+
+func TestStore_APITokens(t *testing.T) {
+	s := newTestStore(t)
+	alice := &models.User{Username: "alice", PasswordHash: "hash", Role: models.RoleAdmin}
+	bob := &models.User{Username: "bob", PasswordHash: "hash", Role: models.RoleViewer}
+	s.CreateUser(t.Context(), alice)
+	s.CreateUser(t.Context(), bob)
+
+	printer := &models.APIToken{
+		UserID: bob.ID, Name: "Label printer", Prefix: "wlg_abcd",
+		Scopes: []models.Scope{models.ScopeRead, models.ScopeLocate}, ExpiresAt: time.Now().Add(24 * time.Hour),
+	}
+	if err := s.CreateAPIToken(t.Context(), printer, "hash-1"); err != nil {
+		t.Fatalf("CreateAPIToken failed: %v", err)
+	}
+	s.CreateAPIToken(t.Context(), &models.APIToken{UserID: alice.ID, Name: "Home Assistant", Scopes: []models.Scope{models.ScopeAdmin}, ExpiresAt: time.Now()}, "hash-2")
+
+	token, user, err := s.GetAPITokenByHash(t.Context(), "hash-1")
+	if err != nil {
+		t.Fatalf("GetAPITokenByHash failed: %v", err)
+	}
+	if token.ID != printer.ID || len(token.Scopes) != 2 || token.Scopes[1] != models.ScopeLocate || token.LastUsedAt.Valid {
+		t.Errorf("Unexpected token: %+v", token)
+	}
+	if user.ID != bob.ID || user.Role != models.RoleViewer {
+		t.Errorf("Expected the token's user, got %+v", user)
+	}
+	if _, _, err := s.GetAPITokenByHash(t.Context(), "hash-3"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for an unknown token, got %v", err)
+	}
+
+	// Use is recorded at most once a minute
+	now := time.Now()
+	s.TouchAPIToken(t.Context(), printer.ID, now)
+	s.TouchAPIToken(t.Context(), printer.ID, now.Add(30*time.Second))
+	if got, _ := s.GetAPITokenByID(t.Context(), printer.ID); !got.LastUsedAt.Valid || !got.LastUsedAt.Time.Equal(probeTime(now)) {
+		t.Errorf("Expected the first use to be recorded, got %+v", got.LastUsedAt)
+	}
+
+	if all, _ := s.GetAPITokens(t.Context(), 0); len(all) != 2 || all[0].Username != "alice" {
+		t.Errorf("Expected everyone's tokens, got %+v", all)
+	}
+	if mine, _ := s.GetAPITokens(t.Context(), bob.ID); len(mine) != 1 || mine[0].Name != "Label printer" {
+		t.Errorf("Expected bob's token, got %+v", mine)
+	}
+
+	if err := s.DeleteAPIToken(t.Context(), printer.ID); err != nil {
+		t.Fatalf("DeleteAPIToken failed: %v", err)
+	}
+	if _, err := s.GetAPITokenByID(t.Context(), printer.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected the token to be deleted, got %v", err)
+	}
+
+	// A user's tokens go with them
+	s.CreateAPIToken(t.Context(), printer, "hash-1")
+	s.DeleteUser(t.Context(), bob.ID)
+	if _, _, err := s.GetAPITokenByHash(t.Context(), "hash-1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected the deleted user's token to be gone, got %v", err)
+	}
+}
+
+func TestStore_APITokenEvents(t *testing.T) {
+	s := newTestStore(t)
+
+	for i := 0; i < apiTokenEventsKept+5; i++ {
+		e := &models.APITokenEvent{TokenID: 1, TokenName: "Label printer", UserID: 2, Username: "bob", At: time.Now(), Event: "used", Detail: "POST /locate/part/3: 200"}
+		if err := s.RecordAPITokenEvent(t.Context(), e); err != nil {
+			t.Fatalf("RecordAPITokenEvent failed: %v", err)
+		}
+	}
+	s.RecordAPITokenEvent(t.Context(), &models.APITokenEvent{TokenID: 2, TokenName: "Home Assistant", UserID: 1, Username: "alice", At: time.Now(), Event: "created"})
+
+	all, err := s.GetAPITokenEvents(t.Context(), 0, 5000)
+	if err != nil {
+		t.Fatalf("GetAPITokenEvents failed: %v", err)
+	}
+	if len(all) != apiTokenEventsKept || all[0].Event != "created" {
+		t.Errorf("Expected the %d newest events, got %d: %+v", apiTokenEventsKept, len(all), all[0])
+	}
+	if bobs, _ := s.GetAPITokenEvents(t.Context(), 2, 10); len(bobs) != 10 || bobs[0].Detail != "POST /locate/part/3: 200" {
+		t.Errorf("Expected bob's newest events, got %+v", bobs)
+	}
+}
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// dbtx is satisfied by both *sql.DB and *sql.Tx
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// WithTx runs fn in one transaction. fn gets a copy of the store bound to
+// the transaction: everything it does through tx is committed if fn
+// returns nil and rolled back otherwise. A WithTx on tx joins it.
+func (s *Store) WithTx(ctx context.Context, fn func(tx *Store) error) error {
+	t, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+
+	tx := *s
+	tx.tx = t.tx
+	if err := fn(&tx); err != nil {
+		return err
+	}
+	return t.Commit()
+}
+
+// TxFor gives a Store the WithTx of a feature's own interface T, which
+// lists the store methods the feature uses in transactions. *Store must
+// implement T.
+//
+//	inventory.New(store.TxFor[inventory.TxStore]{Store: db}, templates)
+type TxFor[T any] struct {
+	*Store
+}
+
+// WithTx runs fn in one transaction, see Store.WithTx
+func (s TxFor[T]) WithTx(ctx context.Context, fn func(tx T) error) error {
+	return s.Store.WithTx(ctx, func(tx *Store) error {
+		return fn(any(tx).(T))
+	})
+}
+
+// conn returns the store's transaction, or the database, taking the
+// placeholders of the store's dialect
+func (s *Store) conn() dbtx {
+	if s.tx != nil {
+		return boundConn{q: s.tx, dialect: s.dialect}
+	}
+	return boundConn{q: s.db, dialect: s.dialect}
+}
+
+// txn is the transaction a store method runs its statements in. In a store
+// bound to a transaction it's a savepoint of that transaction, so a failing
+// method still undoes its own changes.
+type txn struct {
+	dbtx      // Runs the statements in tx
+	tx        *sql.Tx
+	ctx       context.Context
+	savepoint bool
+	done      bool
+}
+
+func (s *Store) begin(ctx context.Context) (*txn, error) {
+	if s.tx != nil {
+		if _, err := s.tx.ExecContext(ctx, "SAVEPOINT nested"); err != nil {
+			return nil, err
+		}
+		return &txn{dbtx: boundConn{q: s.tx, dialect: s.dialect}, tx: s.tx, ctx: ctx, savepoint: true}, nil
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &txn{dbtx: boundConn{q: tx, dialect: s.dialect}, tx: tx, ctx: ctx}, nil
+}
+
+// Commit commits the transaction, or releases the savepoint into the
+// surrounding transaction
+func (t *txn) Commit() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	if t.savepoint {
+		_, err := t.ExecContext(t.ctx, "RELEASE nested")
+		return err
+	}
+	return t.tx.Commit()
+}
+
+// Rollback undoes the transaction or the savepoint. Like sql.Tx, it
+// returns sql.ErrTxDone once committed, so it can be deferred.
+func (t *txn) Rollback() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	if t.savepoint {
+		// Undone even if the request was cancelled, the surrounding
+		// transaction may carry on
+		ctx := context.WithoutCancel(t.ctx)
+		if _, err := t.ExecContext(ctx, "ROLLBACK TO nested"); err != nil {
+			return err
+		}
+		_, err := t.ExecContext(ctx, "RELEASE nested")
+		return err
+	}
+	return t.tx.Rollback()
+}
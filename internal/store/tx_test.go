@@ -0,0 +1,107 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"wledger/internal/models"
+)
+
+func TestStore_WithTx_Commit(t *testing.T) {
+	s := newTestStore(t)
+	if err := s.CreatePart(t.Context(), getValidPart("Resistor")); err != nil {
+		t.Fatalf("CreatePart failed: %v", err)
+	}
+
+	err := s.WithTx(t.Context(), func(tx *Store) error {
+		c, err := tx.CreateCategory(t.Context(), "Passives")
+		if err != nil {
+			return err
+		}
+		return tx.AssignCategoryToPart(t.Context(), 1, c.ID)
+	})
+	if err != nil {
+		t.Fatalf("WithTx failed: %v", err)
+	}
+
+	cats, err := s.GetCategoriesByPartID(t.Context(), 1)
+	if err != nil || len(cats) != 1 || cats[0].Name != "Passives" {
+		t.Errorf("Expected the part tagged Passives, got %v (%v)", cats, err)
+	}
+}
+
+func TestStore_WithTx_Rollback(t *testing.T) {
+	s := newTestStore(t)
+	failed := errors.New("file write failed")
+
+	err := s.WithTx(t.Context(), func(tx *Store) error {
+		if err := tx.CreatePart(t.Context(), getValidPart("Capacitor")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateCategory(t.Context(), "Passives"); err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("Expected fn's error, got %v", err)
+	}
+
+	if parts, _ := s.GetParts(t.Context()); len(parts) != 0 {
+		t.Errorf("Expected the part rolled back, got %d parts", len(parts))
+	}
+	if cats, _ := s.GetCategories(t.Context()); len(cats) != 0 {
+		t.Errorf("Expected the category rolled back, got %d categories", len(cats))
+	}
+}
+
+func TestStore_WithTx_FailedMethodUndoesItself(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateController(t.Context(), &models.WLEDController{Name: "C1", IPAddress: "1.1.1.1"})
+	if _, err := s.CreateBin(t.Context(), "B1", 1, 0, 0, false); err != nil {
+		t.Fatalf("CreateBin failed: %v", err)
+	}
+
+	err := s.WithTx(t.Context(), func(tx *Store) error {
+		// CreateBin inserts the bin before finding the LED taken
+		if _, err := tx.CreateBin(t.Context(), "B2", 1, 0, 0, false); !errors.Is(err, ErrLEDConflict) {
+			t.Errorf("Expected ErrLEDConflict, got %v", err)
+		}
+		_, err := tx.CreateBin(t.Context(), "B3", 1, 0, 1, false)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WithTx failed: %v", err)
+	}
+
+	bins, err := s.GetBins(t.Context())
+	if err != nil {
+		t.Fatalf("GetBins failed: %v", err)
+	}
+	names := []string{}
+	for _, b := range bins {
+		names = append(names, b.Name)
+	}
+	if len(names) != 2 || names[0] != "B1" || names[1] != "B3" {
+		t.Errorf("Expected bins B1 and B3, got %v", names)
+	}
+}
+
+func TestStore_TxFor(t *testing.T) {
+	type partCreator interface {
+		CreatePart(ctx context.Context, p *models.Part) error
+	}
+	s := newTestStore(t)
+	tx := TxFor[partCreator]{Store: s}
+
+	err := tx.WithTx(t.Context(), func(tx partCreator) error {
+		return tx.CreatePart(t.Context(), getValidPart("Resistor"))
+	})
+	if err != nil {
+		t.Fatalf("WithTx failed: %v", err)
+	}
+	if parts, _ := s.GetParts(t.Context()); len(parts) != 1 {
+		t.Errorf("Expected the part committed, got %d parts", len(parts))
+	}
+}
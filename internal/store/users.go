@@ -0,0 +1,229 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"wledger/internal/models"
+)
+
+// createUsers adds the user accounts and their sign-in sessions. Sessions
+// are looked up by a hash of their token, so the table can't be used to
+// sign in.
+func createUsers(tx *schemaTx) error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS users (
+			id              INTEGER PRIMARY KEY AUTOINCREMENT,
+			username        TEXT NOT NULL UNIQUE,
+			password_hash   TEXT NOT NULL,
+			role            TEXT NOT NULL,
+			created_at      DATETIME NOT NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS sessions (
+			token_hash      TEXT PRIMARY KEY,
+			user_id         INTEGER NOT NULL,
+			expires_at      DATETIME NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions (user_id);`,
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(tx.dialect.schema(query)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// User methods
+
+func (s *Store) CountUsers(ctx context.Context) (int, error) {
+	var count int
+	err := s.conn().QueryRowContext(ctx, `SELECT COUNT(*) FROM users`).Scan(&count)
+	return count, err
+}
+
+func (s *Store) GetUsers(ctx context.Context) ([]models.User, error) {
+	rows, err := s.conn().QueryContext(ctx, `
+		SELECT id, username, password_hash, role, created_at
+		FROM users
+		ORDER BY username ASC;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func (s *Store) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	row := s.conn().QueryRowContext(ctx, `
+		SELECT id, username, password_hash, role, created_at
+		FROM users
+		WHERE id = ?;
+	`, id)
+	return scanUser(row)
+}
+
+func (s *Store) GetUserByUsername(ctx context.Context, username string) (models.User, error) {
+	row := s.conn().QueryRowContext(ctx, `
+		SELECT id, username, password_hash, role, created_at
+		FROM users
+		WHERE username = ?;
+	`, username)
+	return scanUser(row)
+}
+
+func (s *Store) CreateUser(ctx context.Context, u *models.User) error {
+	u.CreatedAt = time.Now()
+	err := s.conn().QueryRowContext(ctx,
+		`INSERT INTO users (username, password_hash, role, created_at) VALUES (?, ?, ?, ?) RETURNING id`,
+		u.Username, u.PasswordHash, u.Role, u.CreatedAt,
+	).Scan(&u.ID)
+	return userError(err)
+}
+
+// CreateFirstUser creates the first user, unless someone else got there
+// first, in which case it returns ErrUsersExist
+func (s *Store) CreateFirstUser(ctx context.Context, u *models.User) error {
+	return s.WithTx(ctx, func(tx *Store) error {
+		count, err := tx.CountUsers(ctx)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrUsersExist
+		}
+		return tx.CreateUser(ctx, u)
+	})
+}
+
+// UpdateUserRole changes a user's role. Taking it from the last admin
+// fails with ErrLastAdmin.
+func (s *Store) UpdateUserRole(ctx context.Context, id int, role models.Role) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET role = ? WHERE id = ?`, role, id); err != nil {
+		return err
+	}
+	if err := requireAdmin(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateUserPassword sets a new password hash and signs the user out everywhere
+func (s *Store) UpdateUserPassword(ctx context.Context, id int, passwordHash string) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET password_hash = ? WHERE id = ?`, passwordHash, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteUser deletes a user with their sessions and API tokens. Deleting
+// the last admin fails with ErrLastAdmin.
+func (s *Store) DeleteUser(ctx context.Context, id int) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, id); err != nil {
+		return err
+	}
+	if err := requireAdmin(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// requireAdmin returns ErrLastAdmin if no admin is left
+func requireAdmin(ctx context.Context, tx dbtx) error {
+	var admins int
+	err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE role = ?`, models.RoleAdmin).Scan(&admins)
+	if err != nil {
+		return err
+	}
+	if admins == 0 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+func scanUser(row scanner) (models.User, error) {
+	var u models.User
+	err := row.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Role, &u.CreatedAt)
+	return u, err
+}
+
+func userError(err error) error {
+	if isUniqueViolation(err) {
+		return ErrUniqueConstraint
+	}
+	return err
+}
+
+// Session methods. Expiry times are stored like probe times, see probeTime.
+
+// CreateSession signs a user in until expires, and clears out the
+// sessions that have expired since the last sign in
+func (s *Store) CreateSession(ctx context.Context, tokenHash string, userID int, expires time.Time) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at <= ?`, probeTime(time.Now())); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO sessions (token_hash, user_id, expires_at) VALUES (?, ?, ?)`,
+		tokenHash, userID, probeTime(expires),
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetSessionUser returns the user signed in with a session, or
+// sql.ErrNoRows if there's no such session or it has expired
+func (s *Store) GetSessionUser(ctx context.Context, tokenHash string) (models.User, error) {
+	row := s.conn().QueryRowContext(ctx, `
+		SELECT u.id, u.username, u.password_hash, u.role, u.created_at
+		FROM sessions se
+		JOIN users u ON se.user_id = u.id
+		WHERE se.token_hash = ? AND se.expires_at > ?;
+	`, tokenHash, probeTime(time.Now()))
+	return scanUser(row)
+}
+
+// DeleteSession signs a session out
+func (s *Store) DeleteSession(ctx context.Context, tokenHash string) error {
+	_, err := s.conn().ExecContext(ctx, `DELETE FROM sessions WHERE token_hash = ?`, tokenHash)
+	return err
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"wledger/internal/models"
+)
+
+func TestStore_Users(t *testing.T) {
+	s := newTestStore(t)
+
+	admin := &models.User{Username: "alice", PasswordHash: "hash-a", Role: models.RoleAdmin}
+	if err := s.CreateFirstUser(t.Context(), admin); err != nil {
+		t.Fatalf("CreateFirstUser failed: %v", err)
+	}
+	if err := s.CreateFirstUser(t.Context(), &models.User{Username: "mallory", Role: models.RoleAdmin}); !errors.Is(err, ErrUsersExist) {
+		t.Errorf("Expected ErrUsersExist, got %v", err)
+	}
+
+	viewer := &models.User{Username: "bob", PasswordHash: "hash-b", Role: models.RoleViewer}
+	if err := s.CreateUser(t.Context(), viewer); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if err := s.CreateUser(t.Context(), &models.User{Username: "bob", Role: models.RoleEditor}); !errors.Is(err, ErrUniqueConstraint) {
+		t.Errorf("Expected ErrUniqueConstraint, got %v", err)
+	}
+
+	got, err := s.GetUserByUsername(t.Context(), "bob")
+	if err != nil || got.ID != viewer.ID || got.Role != models.RoleViewer || got.PasswordHash != "hash-b" || got.CreatedAt.IsZero() {
+		t.Errorf("Unexpected user: %+v (%v)", got, err)
+	}
+	if count, _ := s.CountUsers(t.Context()); count != 2 {
+		t.Errorf("Expected 2 users, got %d", count)
+	}
+
+	// The last admin stays an admin
+	if err := s.UpdateUserRole(t.Context(), admin.ID, models.RoleEditor); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("Expected ErrLastAdmin demoting the last admin, got %v", err)
+	}
+	if err := s.DeleteUser(t.Context(), admin.ID); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("Expected ErrLastAdmin deleting the last admin, got %v", err)
+	}
+	if err := s.UpdateUserRole(t.Context(), viewer.ID, models.RoleAdmin); err != nil {
+		t.Fatalf("UpdateUserRole failed: %v", err)
+	}
+	if err := s.UpdateUserRole(t.Context(), admin.ID, models.RoleEditor); err != nil {
+		t.Errorf("Demoting one of two admins failed: %v", err)
+	}
+
+	users, err := s.GetUsers(t.Context())
+	if err != nil || len(users) != 2 || users[0].Username != "alice" || users[0].Role != models.RoleEditor {
+		t.Errorf("Unexpected users: %+v (%v)", users, err)
+	}
+
+	if err := s.DeleteUser(t.Context(), admin.ID); err != nil {
+		t.Fatalf("DeleteUser failed: %v", err)
+	}
+	if _, err := s.GetUserByID(t.Context(), admin.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected the user to be deleted, got %v", err)
+	}
+}
+
+func TestStore_Sessions(t *testing.T) {
+	s := newTestStore(t)
+	u := &models.User{Username: "alice", PasswordHash: "hash", Role: models.RoleAdmin}
+	s.CreateUser(t.Context(), u)
+
+	if err := s.CreateSession(t.Context(), "expired", u.ID, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	if _, err := s.GetSessionUser(t.Context(), "expired"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected an expired session to be rejected, got %v", err)
+	}
+
+	if err := s.CreateSession(t.Context(), "current", u.ID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	got, err := s.GetSessionUser(t.Context(), "current")
+	if err != nil || got.ID != u.ID {
+		t.Fatalf("Expected the session's user, got %+v (%v)", got, err)
+	}
+
+	// Expired sessions are cleared out when signing in
+	var sessions int
+	s.db.QueryRow("SELECT COUNT(*) FROM sessions").Scan(&sessions)
+	if sessions != 1 {
+		t.Errorf("Expected only the current session to be kept, got %d", sessions)
+	}
+
+	// A new password signs the user out
+	s.CreateSession(t.Context(), "other", u.ID, time.Now().Add(time.Hour))
+	if err := s.UpdateUserPassword(t.Context(), u.ID, "new-hash"); err != nil {
+		t.Fatalf("UpdateUserPassword failed: %v", err)
+	}
+	if _, err := s.GetSessionUser(t.Context(), "other"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected the sessions to end with a new password, got %v", err)
+	}
+
+	s.CreateSession(t.Context(), "current", u.ID, time.Now().Add(time.Hour))
+	if err := s.DeleteSession(t.Context(), "current"); err != nil {
+		t.Fatalf("DeleteSession failed: %v", err)
+	}
+	if _, err := s.GetSessionUser(t.Context(), "current"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected the session to be signed out, got %v", err)
+	}
+}
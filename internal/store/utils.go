@@ -1,6 +1,9 @@
 package store
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 // parseTime attempts to parse a time string from SQLite in various formats
 // fixes an issue with the SQLite drive crashing after a restore operation
@@ -22,3 +25,11 @@ func parseTime(tStr string) time.Time {
 	}
 	return time.Time{} // Return zero time on failure
 }
+
+// likeEscaper escapes LIKE's wildcards, for patterns used with ESCAPE '\'
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike makes s match itself literally in a LIKE pattern
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
@@ -0,0 +1,323 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+
+	"wledger/internal/models"
+)
+
+// createWebhooks adds the webhooks and the queue of their deliveries.
+// Deliveries keep their webhook's name so the log reads the same after
+// the webhook is renamed.
+func createWebhooks(tx *schemaTx) error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS webhooks (
+			id              INTEGER PRIMARY KEY AUTOINCREMENT,
+			name            TEXT NOT NULL,
+			url             TEXT NOT NULL,
+			secret          TEXT NOT NULL,
+			events          TEXT NOT NULL,
+			enabled         BOOLEAN NOT NULL DEFAULT 1,
+			created_at      DATETIME NOT NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id              INTEGER PRIMARY KEY AUTOINCREMENT,
+			webhook_id      INTEGER NOT NULL,
+			webhook_name    TEXT NOT NULL,
+			event           TEXT NOT NULL,
+			payload         TEXT NOT NULL,
+			status          TEXT NOT NULL DEFAULT 'pending',
+			attempts        INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at DATETIME NOT NULL,
+			last_attempt_at DATETIME,
+			response_code   INTEGER NOT NULL DEFAULT 0,
+			error           TEXT NOT NULL DEFAULT '',
+			created_at      DATETIME NOT NULL,
+			FOREIGN KEY (webhook_id) REFERENCES webhooks (id) ON DELETE CASCADE
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries (webhook_id, id);`,
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(tx.dialect.schema(query)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Webhook methods
+
+const webhookColumns = `id, name, url, secret, events, enabled, created_at`
+
+func (s *Store) GetWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	return queryWebhooks(ctx, s.conn(), `SELECT `+webhookColumns+` FROM webhooks ORDER BY name ASC, id ASC`)
+}
+
+func (s *Store) GetWebhookByID(ctx context.Context, id int) (models.Webhook, error) {
+	row := s.conn().QueryRowContext(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id)
+	return scanWebhook(row)
+}
+
+func (s *Store) CreateWebhook(ctx context.Context, w *models.Webhook) error {
+	w.CreatedAt = time.Now()
+	return s.conn().QueryRowContext(ctx,
+		`INSERT INTO webhooks (name, url, secret, events, enabled, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?) RETURNING id`,
+		w.Name, w.URL, w.Secret, joinEvents(w.Events), w.Enabled, w.CreatedAt,
+	).Scan(&w.ID)
+}
+
+// UpdateWebhook saves everything but the secret, which is only set when
+// the webhook is created
+func (s *Store) UpdateWebhook(ctx context.Context, w *models.Webhook) error {
+	_, err := s.conn().ExecContext(ctx,
+		`UPDATE webhooks SET name = ?, url = ?, events = ?, enabled = ? WHERE id = ?`,
+		w.Name, w.URL, joinEvents(w.Events), w.Enabled, w.ID,
+	)
+	return err
+}
+
+// DeleteWebhook deletes a webhook and its deliveries
+func (s *Store) DeleteWebhook(ctx context.Context, id int) error {
+	_, err := s.conn().ExecContext(ctx, `DELETE FROM webhooks WHERE id = ?`, id)
+	return err
+}
+
+func queryWebhooks(ctx context.Context, q dbtx, query string, args ...any) ([]models.Webhook, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := []models.Webhook{}
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, w)
+	}
+	return hooks, rows.Err()
+}
+
+func scanWebhook(row scanner) (models.Webhook, error) {
+	var w models.Webhook
+	var events string
+	err := row.Scan(&w.ID, &w.Name, &w.URL, &w.Secret, &events, &w.Enabled, &w.CreatedAt)
+	w.Events = splitEvents(events)
+	return w, err
+}
+
+// Events are stored as a comma separated list, like token scopes
+func joinEvents(events []models.WebhookEvent) string {
+	names := make([]string, len(events))
+	for i, e := range events {
+		names[i] = string(e)
+	}
+	return strings.Join(names, ",")
+}
+
+func splitEvents(events string) []models.WebhookEvent {
+	list := []models.WebhookEvent{}
+	for _, e := range strings.Split(events, ",") {
+		if e != "" {
+			list = append(list, models.WebhookEvent(e))
+		}
+	}
+	return list
+}
+
+// Webhook event methods. Events are queued in the same transaction as the
+// change they describe, so a rolled back change sends nothing. The
+// background service delivers them.
+
+// webhookPayload is the JSON body of every delivery
+type webhookPayload struct {
+	Event      models.WebhookEvent `json:"event"`
+	OccurredAt time.Time           `json:"occurred_at"`
+	Data       any                 `json:"data"`
+}
+
+// EmitWebhookEvent queues the event for every enabled webhook that
+// subscribes to it
+func (s *Store) EmitWebhookEvent(ctx context.Context, event models.WebhookEvent, data any) error {
+	return emit(ctx, s.conn(), event, data)
+}
+
+// SendWebhookPing queues a ping for the webhook, whatever its events
+func (s *Store) SendWebhookPing(ctx context.Context, id int) error {
+	w, err := s.GetWebhookByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	return queueDelivery(ctx, s.conn(), w, models.EventPing, map[string]any{"webhook_id": w.ID, "name": w.Name})
+}
+
+func emit(ctx context.Context, q dbtx, event models.WebhookEvent, data any) error {
+	hooks, err := queryWebhooks(ctx, q, `SELECT `+webhookColumns+` FROM webhooks WHERE enabled = TRUE ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	for _, w := range hooks {
+		if !slices.Contains(w.Events, event) {
+			continue
+		}
+		if err := queueDelivery(ctx, q, w, event, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func queueDelivery(ctx context.Context, q dbtx, w models.Webhook, event models.WebhookEvent, data any) error {
+	now := probeTime(time.Now())
+	payload, err := json.Marshal(webhookPayload{Event: event, OccurredAt: now, Data: data})
+	if err != nil {
+		return err
+	}
+	_, err = q.ExecContext(ctx,
+		`INSERT INTO webhook_deliveries (webhook_id, webhook_name, event, payload, next_attempt_at, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?)`,
+		w.ID, w.Name, event, string(payload), now, now,
+	)
+	return err
+}
+
+// partEvent emits a part.* event
+func partEvent(ctx context.Context, q dbtx, event models.WebhookEvent, p models.Part) error {
+	return emit(ctx, q, event, models.PartEventData{PartID: p.ID, Name: p.Name, PartNumber: p.PartNumber.String})
+}
+
+// locationStock returns the stock of a location and its part, and whether
+// the part tracks its stock
+func locationStock(ctx context.Context, q dbtx, locationID int) (models.StockEventData, bool, error) {
+	var d models.StockEventData
+	var tracked bool
+	err := q.QueryRowContext(ctx, `
+		SELECT pl.id, pl.part_id, p.name, pl.bin_id, b.name, pl.quantity, p.reorder_point, p.stock_tracking_enabled,
+			(SELECT COALESCE(SUM(quantity), 0) FROM part_locations WHERE part_id = pl.part_id)
+		FROM part_locations pl
+		JOIN parts p ON pl.part_id = p.id
+		JOIN bins b ON pl.bin_id = b.id
+		WHERE pl.id = ?;
+	`, locationID).Scan(&d.LocationID, &d.PartID, &d.PartName, &d.BinID, &d.BinName, &d.Quantity, &d.ReorderPoint, &tracked, &d.TotalQuantity)
+	return d, tracked, err
+}
+
+// stockEvents emits stock.changed when a location's quantity changed, and
+// stock.low when that took a tracked part's stock down to its reorder point
+func stockEvents(ctx context.Context, q dbtx, before, after models.StockEventData, tracked bool) error {
+	if before.Quantity == after.Quantity {
+		return nil
+	}
+	after.OldQuantity = before.Quantity
+	if err := emit(ctx, q, models.EventStockChanged, after); err != nil {
+		return err
+	}
+	if tracked && before.TotalQuantity > after.ReorderPoint && after.TotalQuantity <= after.ReorderPoint {
+		return emit(ctx, q, models.EventStockLow, after)
+	}
+	return nil
+}
+
+// Webhook delivery methods. Delivery times are stored like probe times,
+// see probeTime.
+
+const webhookDeliveryColumns = `id, webhook_id, webhook_name, event, payload, status, attempts,
+	next_attempt_at, last_attempt_at, response_code, error, created_at`
+
+// GetDueWebhookDeliveries returns the pending deliveries of enabled
+// webhooks that are due by now, oldest first
+func (s *Store) GetDueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	return s.queryWebhookDeliveries(ctx, `
+		SELECT `+webhookDeliveryColumns+`
+		FROM webhook_deliveries
+		WHERE status = 'pending' AND next_attempt_at <= ?
+		  AND webhook_id IN (SELECT id FROM webhooks WHERE enabled = TRUE)
+		ORDER BY id ASC
+		LIMIT ?;
+	`, probeTime(now), limit)
+}
+
+// GetWebhookDeliveries returns a webhook's newest deliveries, or everyone's
+// for webhookID 0
+func (s *Store) GetWebhookDeliveries(ctx context.Context, webhookID, limit int) ([]models.WebhookDelivery, error) {
+	return s.queryWebhookDeliveries(ctx, `
+		SELECT `+webhookDeliveryColumns+`
+		FROM webhook_deliveries
+		WHERE ? = 0 OR webhook_id = ?
+		ORDER BY id DESC
+		LIMIT ?;
+	`, webhookID, webhookID, limit)
+}
+
+func (s *Store) GetWebhookDeliveryByID(ctx context.Context, id int) (models.WebhookDelivery, error) {
+	row := s.conn().QueryRowContext(ctx, `SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries WHERE id = ?`, id)
+	return scanWebhookDelivery(row)
+}
+
+// RecordWebhookAttempt saves the outcome of an attempt to deliver d
+func (s *Store) RecordWebhookAttempt(ctx context.Context, d *models.WebhookDelivery) error {
+	d.NextAttemptAt = probeTime(d.NextAttemptAt)
+	if d.LastAttemptAt.Valid {
+		d.LastAttemptAt.Time = probeTime(d.LastAttemptAt.Time)
+	}
+	_, err := s.conn().ExecContext(ctx,
+		`UPDATE webhook_deliveries
+		 SET status = ?, attempts = ?, next_attempt_at = ?, last_attempt_at = ?, response_code = ?, error = ?
+		 WHERE id = ?`,
+		d.Status, d.Attempts, d.NextAttemptAt, d.LastAttemptAt, d.ResponseCode, d.Error, d.ID,
+	)
+	return err
+}
+
+// RetryWebhookDelivery queues a failed delivery again, for one more attempt
+func (s *Store) RetryWebhookDelivery(ctx context.Context, id int) error {
+	_, err := s.conn().ExecContext(ctx,
+		`UPDATE webhook_deliveries SET status = 'pending', next_attempt_at = ? WHERE id = ? AND status = 'failed'`,
+		probeTime(time.Now()), id,
+	)
+	return err
+}
+
+// PruneWebhookDeliveries deletes the finished deliveries created before
+// the given time. Pending ones are kept until they're sent or fail.
+func (s *Store) PruneWebhookDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.conn().ExecContext(ctx,
+		`DELETE FROM webhook_deliveries WHERE status <> 'pending' AND created_at < ?`, probeTime(before))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *Store) queryWebhookDeliveries(ctx context.Context, query string, args ...any) ([]models.WebhookDelivery, error) {
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func scanWebhookDelivery(row scanner) (models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	err := row.Scan(&d.ID, &d.WebhookID, &d.WebhookName, &d.Event, &d.Payload, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &d.LastAttemptAt, &d.ResponseCode, &d.Error, &d.CreatedAt)
+	return d, err
+}
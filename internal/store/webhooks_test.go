@@ -0,0 +1,245 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"wledger/internal/models"
+)
+
+// queuedEvents returns the events of the queued deliveries, oldest first
+func queuedEvents(t *testing.T, s *Store) []models.WebhookEvent {
+	t.Helper()
+	deliveries, err := s.GetWebhookDeliveries(t.Context(), 0, 100)
+	if err != nil {
+		t.Fatalf("GetWebhookDeliveries failed: %v", err)
+	}
+	events := []models.WebhookEvent{}
+	for i := len(deliveries) - 1; i >= 0; i-- {
+		events = append(events, deliveries[i].Event)
+	}
+	return events
+}
+
+func TestStore_Webhooks(t *testing.T) {
+	s := newTestStore(t)
+	w := &models.Webhook{
+		Name: "Sheet", URL: "https://example.com/hook", Secret: "s3cret",
+		Events: []models.WebhookEvent{models.EventPartCreated, models.EventStockLow}, Enabled: true,
+	}
+	if err := s.CreateWebhook(t.Context(), w); err != nil {
+		t.Fatalf("CreateWebhook failed: %v", err)
+	}
+
+	got, err := s.GetWebhookByID(t.Context(), w.ID)
+	if err != nil || got.Name != "Sheet" || got.Secret != "s3cret" || len(got.Events) != 2 || !got.Enabled {
+		t.Errorf("Unexpected webhook: %+v, %v", got, err)
+	}
+
+	got.Name, got.Secret, got.Enabled = "Order sheet", "ignored", false
+	got.Events = []models.WebhookEvent{models.EventPartDeleted}
+	if err := s.UpdateWebhook(t.Context(), &got); err != nil {
+		t.Fatalf("UpdateWebhook failed: %v", err)
+	}
+	if hooks, _ := s.GetWebhooks(t.Context()); len(hooks) != 1 || hooks[0].Name != "Order sheet" || hooks[0].Secret != "s3cret" ||
+		hooks[0].Enabled || hooks[0].Events[0] != models.EventPartDeleted {
+		t.Errorf("Expected the webhook to be updated but keep its secret, got %+v", hooks)
+	}
+
+	s.SendWebhookPing(t.Context(), w.ID)
+	if err := s.DeleteWebhook(t.Context(), w.ID); err != nil {
+		t.Fatalf("DeleteWebhook failed: %v", err)
+	}
+	if _, err := s.GetWebhookByID(t.Context(), w.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected the webhook to be deleted, got %v", err)
+	}
+	if events := queuedEvents(t, s); len(events) != 0 {
+		t.Errorf("Expected its deliveries to be deleted, got %v", events)
+	}
+}
+
+func TestStore_WebhookEvents(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateWebhook(t.Context(), &models.Webhook{Name: "All", URL: "https://example.com/a", Events: models.WebhookEvents, Enabled: true})
+	s.CreateWebhook(t.Context(), &models.Webhook{Name: "Off", URL: "https://example.com/b", Events: models.WebhookEvents, Enabled: false})
+	s.CreateController(t.Context(), &models.WLEDController{Name: "C1", IPAddress: "1.1.1.1"})
+	s.CreateBin(t.Context(), "B1", 1, 0, 0, false)
+	s.CreateBin(t.Context(), "B2", 1, 0, 1, false)
+
+	p := &models.Part{
+		Name: "Resistor", UnitCost: sql.NullFloat64{Valid: true}, Status: sql.NullString{String: "active", Valid: true},
+		StockTracking: true, ReorderPoint: 5,
+	}
+	if err := s.CreatePart(t.Context(), p); err != nil {
+		t.Fatalf("CreatePart failed: %v", err)
+	}
+	loc1, _ := s.CreatePartLocation(t.Context(), p.ID, 1, 10)
+	loc2, _ := s.CreatePartLocation(t.Context(), p.ID, 2, 0) // Nothing changed
+	s.UpdatePartLocation(t.Context(), loc1, 10)              // Nothing changed
+	s.UpdatePartLocation(t.Context(), loc1, 4)               // Down to the reorder point
+	s.UpdatePartLocation(t.Context(), loc2, 1)               // Already low
+	p.Name = "Resistor 10k"
+	s.UpdatePart(t.Context(), p)
+	s.DeletePartLocation(t.Context(), loc2)
+	s.DeletePart(t.Context(), p.ID)
+
+	want := []models.WebhookEvent{
+		models.EventPartCreated, models.EventStockChanged,
+		models.EventStockChanged, models.EventStockLow,
+		models.EventStockChanged, models.EventPartUpdated, models.EventStockChanged,
+		models.EventStockChanged, models.EventPartDeleted, // The part's last location goes with it
+	}
+	events := queuedEvents(t, s)
+	if len(events) != len(want) {
+		t.Fatalf("Expected events %v, got %v", want, events)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Fatalf("Expected events %v, got %v", want, events)
+		}
+	}
+
+	deliveries, _ := s.GetWebhookDeliveries(t.Context(), 0, 100)
+	var low struct {
+		Event models.WebhookEvent
+		Data  models.StockEventData
+	}
+	for _, d := range deliveries {
+		if d.Event == models.EventStockLow {
+			json.Unmarshal([]byte(d.Payload), &low)
+		}
+		if d.WebhookName != "All" {
+			t.Errorf("Expected only the enabled webhook to get deliveries, got %+v", d)
+		}
+	}
+	if low.Event != models.EventStockLow || low.Data.PartName != "Resistor" || low.Data.BinName != "B1" ||
+		low.Data.OldQuantity != 10 || low.Data.Quantity != 4 || low.Data.TotalQuantity != 4 || low.Data.ReorderPoint != 5 {
+		t.Errorf("Unexpected stock.low payload: %+v", low)
+	}
+}
+
+func TestStore_WebhookEvents_RolledBack(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateWebhook(t.Context(), &models.Webhook{Name: "All", URL: "https://example.com/a", Events: models.WebhookEvents, Enabled: true})
+
+	errStop := errors.New("stop")
+	err := s.WithTx(t.Context(), func(tx *Store) error {
+		p := &models.Part{Name: "Gone", UnitCost: sql.NullFloat64{Valid: true}, Status: sql.NullString{String: "active", Valid: true}}
+		if err := tx.CreatePart(t.Context(), p); err != nil {
+			return err
+		}
+		return errStop
+	})
+	if !errors.Is(err, errStop) {
+		t.Fatalf("Expected the error, got %v", err)
+	}
+	if events := queuedEvents(t, s); len(events) != 0 {
+		t.Errorf("Expected no events for a rolled back change, got %v", events)
+	}
+}
+
+func TestStore_WebhookDeliveries(t *testing.T) {
+	s := newTestStore(t)
+	w := &models.Webhook{Name: "Bot", URL: "https://example.com/bot", Events: []models.WebhookEvent{models.EventControllerOffline}, Enabled: true}
+	s.CreateWebhook(t.Context(), w)
+	s.EmitWebhookEvent(t.Context(), models.EventControllerOffline, models.ControllerEventData{ControllerID: 1, Name: "C1"})
+	s.EmitWebhookEvent(t.Context(), models.EventPartCreated, models.PartEventData{PartID: 1})
+	s.SendWebhookPing(t.Context(), w.ID)
+
+	now := time.Now()
+	due, err := s.GetDueWebhookDeliveries(t.Context(), now, 10)
+	if err != nil || len(due) != 2 || due[0].Event != models.EventControllerOffline || due[1].Event != models.EventPing {
+		t.Fatalf("Expected the offline event and the ping to be due, got %+v, %v", due, err)
+	}
+
+	// One fails and waits, the other is sent
+	failed, sent := due[0], due[1]
+	failed.Attempts, failed.ResponseCode, failed.Error = 1, 500, "webhook returned 500"
+	failed.NextAttemptAt = now.Add(time.Minute)
+	failed.LastAttemptAt = sql.NullTime{Time: now, Valid: true}
+	sent.Status, sent.Attempts, sent.ResponseCode = "ok", 1, 200
+	s.RecordWebhookAttempt(t.Context(), &failed)
+	s.RecordWebhookAttempt(t.Context(), &sent)
+
+	if due, _ := s.GetDueWebhookDeliveries(t.Context(), now, 10); len(due) != 0 {
+		t.Errorf("Expected nothing due until the retry, got %+v", due)
+	}
+	if due, _ := s.GetDueWebhookDeliveries(t.Context(), now.Add(time.Minute), 10); len(due) != 1 || due[0].Attempts != 1 || due[0].ResponseCode != 500 {
+		t.Errorf("Expected the retry to be due, got %+v", due)
+	}
+
+	// A failed delivery can be retried by hand
+	failed.Status = "failed"
+	s.RecordWebhookAttempt(t.Context(), &failed)
+	if err := s.RetryWebhookDelivery(t.Context(), failed.ID); err != nil {
+		t.Fatalf("RetryWebhookDelivery failed: %v", err)
+	}
+	if d, _ := s.GetWebhookDeliveryByID(t.Context(), failed.ID); d.Status != "pending" || d.NextAttemptAt.After(time.Now()) {
+		t.Errorf("Expected the delivery to be due again, got %+v", d)
+	}
+
+	// Deliveries of disabled webhooks wait
+	w.Enabled = false
+	s.UpdateWebhook(t.Context(), w)
+	if due, _ := s.GetDueWebhookDeliveries(t.Context(), now.Add(time.Hour), 10); len(due) != 0 {
+		t.Errorf("Expected no deliveries for a disabled webhook, got %+v", due)
+	}
+
+	// Only finished deliveries are pruned
+	pruned, err := s.PruneWebhookDeliveries(t.Context(), now.Add(time.Hour))
+	if err != nil || pruned != 1 {
+		t.Errorf("Expected the sent delivery to be pruned, got %d, %v", pruned, err)
+	}
+}
+
+func TestStore_WebhookEvents_DeleteControllerWithBins(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateWebhook(t.Context(), &models.Webhook{Name: "Low", URL: "https://example.com/a", Events: []models.WebhookEvent{models.EventStockLow}, Enabled: true})
+	c := &models.WLEDController{Name: "C1", IPAddress: "1.1.1.1"}
+	s.CreateController(t.Context(), c)
+	s.CreateBin(t.Context(), "B1", c.ID, 0, 0, false)
+	s.CreateBin(t.Context(), "B2", c.ID, 0, 1, false)
+
+	p := &models.Part{
+		Name: "Diode", UnitCost: sql.NullFloat64{Valid: true}, Status: sql.NullString{String: "active", Valid: true},
+		StockTracking: true, ReorderPoint: 2,
+	}
+	s.CreatePart(t.Context(), p)
+	s.CreatePartLocation(t.Context(), p.ID, 1, 3)
+	s.CreatePartLocation(t.Context(), p.ID, 2, 3)
+
+	if err := s.DeleteControllerWithBins(t.Context(), c.ID, models.BinDisposal{Action: models.BinsDelete}); err != nil {
+		t.Fatalf("DeleteControllerWithBins failed: %v", err)
+	}
+	// The stock falls below the reorder point once
+	if events := queuedEvents(t, s); len(events) != 1 || events[0] != models.EventStockLow {
+		t.Errorf("Expected one stock.low event, got %v", events)
+	}
+}
+
+func TestStore_WebhookEvents_DeletePart(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateWebhook(t.Context(), &models.Webhook{Name: "Low", URL: "https://example.com/a", Events: []models.WebhookEvent{models.EventStockLow}, Enabled: true})
+	s.CreateController(t.Context(), &models.WLEDController{Name: "C1", IPAddress: "1.1.1.1"})
+	s.CreateBin(t.Context(), "B1", 1, 0, 0, false)
+	s.CreateBin(t.Context(), "B2", 1, 0, 1, false)
+
+	p := &models.Part{
+		Name: "Diode", UnitCost: sql.NullFloat64{Valid: true}, Status: sql.NullString{String: "active", Valid: true},
+		StockTracking: true, ReorderPoint: 2,
+	}
+	s.CreatePart(t.Context(), p)
+	s.CreatePartLocation(t.Context(), p.ID, 1, 3)
+	s.CreatePartLocation(t.Context(), p.ID, 2, 3)
+
+	if err := s.DeletePart(t.Context(), p.ID); err != nil {
+		t.Fatalf("DeletePart failed: %v", err)
+	}
+	// The stock falls below the reorder point once
+	if events := queuedEvents(t, s); len(events) != 1 || events[0] != models.EventStockLow {
+		t.Errorf("Expected one stock.low event, got %v", events)
+	}
+}
@@ -0,0 +1,133 @@
+package store
+
+import (
+	"context"
+	"wledger/internal/models"
+)
+
+// Zone methods
+
+// A bin is in its own zone if it has one, otherwise in its controller's zone
+const binZoneExpr = `COALESCE(b.zone_id, c.zone_id)`
+
+func (s *Store) GetZones(ctx context.Context) ([]models.Zone, error) {
+	rows, err := s.conn().QueryContext(ctx, `
+		SELECT z.id, z.name,
+		       (SELECT COUNT(*) FROM wled_controllers c WHERE c.zone_id = z.id),
+		       (SELECT COUNT(*) FROM bins b JOIN wled_controllers c ON b.wled_controller_id = c.id
+		        WHERE `+binZoneExpr+` = z.id)
+		FROM zones z
+		ORDER BY z.name ASC;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	zones := []models.Zone{}
+	for rows.Next() {
+		var z models.Zone
+		if err := rows.Scan(&z.ID, &z.Name, &z.ControllerCount, &z.BinCount); err != nil {
+			return nil, err
+		}
+		zones = append(zones, z)
+	}
+	return zones, rows.Err()
+}
+
+func (s *Store) GetZoneByID(ctx context.Context, id int) (models.Zone, error) {
+	var z models.Zone
+	err := s.conn().QueryRowContext(ctx, `
+		SELECT z.id, z.name,
+		       (SELECT COUNT(*) FROM wled_controllers c WHERE c.zone_id = z.id),
+		       (SELECT COUNT(*) FROM bins b JOIN wled_controllers c ON b.wled_controller_id = c.id
+		        WHERE `+binZoneExpr+` = z.id)
+		FROM zones z
+		WHERE z.id = ?;
+	`, id).Scan(&z.ID, &z.Name, &z.ControllerCount, &z.BinCount)
+	return z, err
+}
+
+func (s *Store) CreateZone(ctx context.Context, z *models.Zone) error {
+	err := s.conn().QueryRowContext(ctx, `INSERT INTO zones (name) VALUES (?) RETURNING id`, z.Name).Scan(&z.ID)
+	return zoneError(err)
+}
+
+func (s *Store) UpdateZone(ctx context.Context, z *models.Zone) error {
+	_, err := s.conn().ExecContext(ctx, `UPDATE zones SET name = ? WHERE id = ?`, z.Name, z.ID)
+	return zoneError(err)
+}
+
+// DeleteZone deletes a zone. Its controllers and bins are left without a zone.
+func (s *Store) DeleteZone(ctx context.Context, id int) error {
+	_, err := s.conn().ExecContext(ctx, `DELETE FROM zones WHERE id = ?`, id)
+	return err
+}
+
+// GetZoneBinLocations returns the LED of every bin in a zone
+func (s *Store) GetZoneBinLocations(ctx context.Context, zoneID int) ([]struct {
+	IP       string
+	SegID    int
+	LEDIndex int
+}, error) {
+	rows, err := s.conn().QueryContext(ctx, `
+		SELECT c.ip_address, b.wled_segment_id, b.led_index
+		FROM bins b
+		JOIN wled_controllers c ON b.wled_controller_id = c.id
+		WHERE `+binZoneExpr+` = ?;
+	`, zoneID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var locations []struct {
+		IP       string
+		SegID    int
+		LEDIndex int
+	}
+	for rows.Next() {
+		var loc struct {
+			IP       string
+			SegID    int
+			LEDIndex int
+		}
+		if err := rows.Scan(&loc.IP, &loc.SegID, &loc.LEDIndex); err != nil {
+			return nil, err
+		}
+		locations = append(locations, loc)
+	}
+	return locations, rows.Err()
+}
+
+// GetZoneControllerIPs returns the IP addresses of the controllers with bins
+// in a zone, whether the bins are in it on their own or through their controller
+func (s *Store) GetZoneControllerIPs(ctx context.Context, zoneID int) ([]string, error) {
+	rows, err := s.conn().QueryContext(ctx, `
+		SELECT c.ip_address FROM wled_controllers c
+		WHERE EXISTS (
+			SELECT 1 FROM bins b
+			WHERE b.wled_controller_id = c.id AND `+binZoneExpr+` = ?)
+		ORDER BY c.name ASC`, zoneID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ips := []string{}
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return nil, err
+		}
+		ips = append(ips, ip)
+	}
+	return ips, rows.Err()
+}
+
+func zoneError(err error) error {
+	if isUniqueViolation(err) {
+		return ErrUniqueConstraint
+	}
+	return err
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"testing"
+
+	"wledger/internal/models"
+)
+
+func TestStore_Zones(t *testing.T) {
+	s := newTestStore(t)
+
+	wall := &models.Zone{Name: "Electronics wall"}
+	bench := &models.Zone{Name: "Mechanical bench"}
+	for _, z := range []*models.Zone{wall, bench} {
+		if err := s.CreateZone(t.Context(), z); err != nil {
+			t.Fatalf("CreateZone failed: %v", err)
+		}
+	}
+	if err := s.CreateZone(t.Context(), &models.Zone{Name: "Electronics wall"}); err != ErrUniqueConstraint {
+		t.Errorf("Expected ErrUniqueConstraint for duplicate name, got %v", err)
+	}
+
+	// Two controllers on the wall, one bin moved to the bench on its own
+	s.CreateController(t.Context(), &models.WLEDController{Name: "Wall A", IPAddress: "10.0.0.1"})
+	s.CreateController(t.Context(), &models.WLEDController{Name: "Wall B", IPAddress: "10.0.0.2"})
+	for _, id := range []int{1, 2} {
+		c, _ := s.GetControllerByID(t.Context(), id)
+		c.ZoneID = wall.ID
+		if err := s.UpdateController(t.Context(), &c); err != nil {
+			t.Fatalf("UpdateController failed: %v", err)
+		}
+	}
+	s.CreateBin(t.Context(), "A1", 1, 0, 0, false)
+	s.CreateBin(t.Context(), "A2", 1, 0, 1, false)
+	s.CreateBin(t.Context(), "B1", 2, 0, 0, false)
+
+	bin, _ := s.GetBinByID(t.Context(), 2)
+	bin.ZoneID = bench.ID
+	if err := s.UpdateBin(t.Context(), &bin); err != nil {
+		t.Fatalf("UpdateBin failed: %v", err)
+	}
+	bin, _ = s.GetBinByID(t.Context(), 2)
+	if bin.ZoneName.String != "Mechanical bench" {
+		t.Errorf("Expected bin to be in its own zone, got %q", bin.ZoneName.String)
+	}
+	bin, _ = s.GetBinByID(t.Context(), 1)
+	if bin.ZoneID != 0 || bin.ZoneName.String != "Electronics wall" {
+		t.Errorf("Expected bin to inherit its controller's zone, got %d %q", bin.ZoneID, bin.ZoneName.String)
+	}
+
+	got, err := s.GetZoneByID(t.Context(), wall.ID)
+	if err != nil {
+		t.Fatalf("GetZoneByID failed: %v", err)
+	}
+	if got.ControllerCount != 2 || got.BinCount != 2 {
+		t.Errorf("got %d controllers and %d bins, want 2 and 2", got.ControllerCount, got.BinCount)
+	}
+
+	locations, _ := s.GetZoneBinLocations(t.Context(), bench.ID)
+	if len(locations) != 1 || locations[0].IP != "10.0.0.1" || locations[0].LEDIndex != 1 {
+		t.Errorf("Unexpected bench locations: %+v", locations)
+	}
+	ips, _ := s.GetZoneControllerIPs(t.Context(), wall.ID)
+	if len(ips) != 2 {
+		t.Errorf("Expected 2 controllers on the wall, got %v", ips)
+	}
+	// Controllers are in every zone their bins are in
+	ips, _ = s.GetZoneControllerIPs(t.Context(), bench.ID)
+	if len(ips) != 1 || ips[0] != "10.0.0.1" {
+		t.Errorf("Expected the bench bin's controller, got %v", ips)
+	}
+	bin, _ = s.GetBinByID(t.Context(), 3)
+	bin.ZoneID = bench.ID
+	s.UpdateBin(t.Context(), &bin)
+	ips, _ = s.GetZoneControllerIPs(t.Context(), wall.ID)
+	if len(ips) != 1 || ips[0] != "10.0.0.1" {
+		t.Errorf("Expected only the controller with a bin left on the wall, got %v", ips)
+	}
+
+	// Deleting a zone leaves its controllers and bins without one
+	if err := s.DeleteZone(t.Context(), wall.ID); err != nil {
+		t.Fatalf("DeleteZone failed: %v", err)
+	}
+	c, _ := s.GetControllerByID(t.Context(), 1)
+	if c.ZoneID != 0 {
+		t.Errorf("Expected controller zone to be cleared, got %d", c.ZoneID)
+	}
+	zones, _ := s.GetZones(t.Context())
+	if len(zones) != 1 || zones[0].Name != "Mechanical bench" {
+		t.Errorf("Unexpected zones: %+v", zones)
+	}
+}
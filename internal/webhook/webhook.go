@@ -0,0 +1,113 @@
+// Package webhook sends signed webhook deliveries.
+//
+// Every delivery is a JSON POST. Receivers check it came from WLEDger by
+// computing the HMAC-SHA256 of "<timestamp>.<body>" with the webhook's
+// secret, and comparing it to the signature header.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"wledger/internal/models"
+)
+
+// The headers of a delivery
+const (
+	HeaderEvent     = "X-WLEDger-Event"
+	HeaderDelivery  = "X-WLEDger-Delivery"
+	HeaderTimestamp = "X-WLEDger-Timestamp" // Unix seconds
+	HeaderSignature = "X-WLEDger-Signature" // "sha256=" and the hex HMAC
+)
+
+const (
+	// MaxAttempts is how often a delivery is tried before it's failed.
+	// With the backoff, the last attempt is about 15 hours after the first.
+	MaxAttempts = 12
+
+	// The wait after the first failed attempt, doubling up to maxBackoff
+	firstBackoff = 30 * time.Second
+	maxBackoff   = 6 * time.Hour
+)
+
+// Backoff returns how long to wait after the given number of failed attempts
+func Backoff(attempts int) time.Duration {
+	wait := firstBackoff
+	for i := 1; i < attempts && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, maxBackoff)
+}
+
+// Sign returns the signature header of a body sent at timestamp
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret returns a random signing secret
+func NewSecret() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+// Client delivers webhooks
+type Client struct {
+	client *http.Client
+	now    func() time.Time
+}
+
+func NewClient() *Client {
+	return &Client{
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			// A redirect would resend the body unsigned for its new URL,
+			// receivers have to be configured with their final URL
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		now: time.Now,
+	}
+}
+
+// Send POSTs the delivery to the webhook. It returns the response code,
+// 0 if there was no response, and an error unless the code was 2xx.
+func (c *Client) Send(ctx context.Context, w models.Webhook, d models.WebhookDelivery) (int, error) {
+	body := []byte(d.Payload)
+	req, err := http.NewRequestWithContext(ctx, "POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(c.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "WLEDger-Webhook")
+	req.Header.Set(HeaderEvent, string(d.Event))
+	req.Header.Set(HeaderDelivery, strconv.Itoa(d.ID))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(w.Secret, timestamp, body))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // Lets the connection be reused
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,97 @@
+package webhook
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"wledger/internal/models"
+)
+
+func TestSign(t *testing.T) {
+	// echo -n '1700000000.{"event":"ping"}' | openssl dgst -sha256 -hmac secret
+	got := Sign("secret", "1700000000", []byte(`{"event":"ping"}`))
+	want := "sha256=4d39bd2442f073b6bc62e95d0297ce25475582a17389ab860abdc778fe1d9f77"
+	if got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if Sign("other", "1700000000", []byte(`{"event":"ping"}`)) == got {
+		t.Error("Expected the secret to change the signature")
+	}
+	if Sign("secret", "1700000001", []byte(`{"event":"ping"}`)) == got {
+		t.Error("Expected the timestamp to change the signature")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{20, 6 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := Backoff(tt.attempts); got != tt.want {
+			t.Errorf("Backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestClient_Send(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if r.URL.Path == "/moved" {
+			http.Redirect(w, r, "/elsewhere", http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c := NewClient()
+	c.now = func() time.Time { return time.Unix(1700000000, 0) }
+	hook := models.Webhook{ID: 1, URL: srv.URL + "/hook", Secret: "secret"}
+	d := models.WebhookDelivery{ID: 42, Event: models.EventStockLow, Payload: `{"event":"stock.low"}`}
+
+	code, err := c.Send(t.Context(), hook, d)
+	if err != nil || code != http.StatusNoContent {
+		t.Fatalf("Send failed: %d, %v", code, err)
+	}
+	if got.Method != "POST" || string(body) != d.Payload || got.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("Unexpected request: %s %q", got.Method, body)
+	}
+	if got.Header.Get(HeaderEvent) != "stock.low" || got.Header.Get(HeaderDelivery) != "42" || got.Header.Get(HeaderTimestamp) != "1700000000" {
+		t.Errorf("Unexpected headers: %v", got.Header)
+	}
+	if want := Sign("secret", "1700000000", body); got.Header.Get(HeaderSignature) != want {
+		t.Errorf("Expected signature %s, got %s", want, got.Header.Get(HeaderSignature))
+	}
+
+	hook.URL = srv.URL + "/down"
+	if code, err := c.Send(t.Context(), hook, d); err == nil || code != http.StatusBadGateway {
+		t.Errorf("Expected a 502 to fail, got %d, %v", code, err)
+	}
+
+	// Redirects aren't followed
+	hook.URL = srv.URL + "/moved"
+	if code, err := c.Send(t.Context(), hook, d); err == nil || code != http.StatusFound {
+		t.Errorf("Expected a redirect to fail, got %d, %v", code, err)
+	}
+
+	srv.Close()
+	if code, err := c.Send(t.Context(), hook, d); err == nil || code != 0 {
+		t.Errorf("Expected no response from a closed server, got %d, %v", code, err)
+	}
+}
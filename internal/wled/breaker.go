@@ -0,0 +1,257 @@
+package wled
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"wledger/internal/models"
+)
+
+// ErrOffline is returned, wrapped, for commands to a controller that is
+// considered offline. The command is queued and replayed once it's back.
+var ErrOffline = errors.New("controller is offline")
+
+const (
+	// breakerRetryAfter is how long an open breaker fails fast
+	// before letting a single command through to test the controller
+	breakerRetryAfter = 30 * time.Second
+
+	// Queued commands beyond these limits are dropped, oldest first
+	breakerQueueSize   = 50
+	breakerQueueMaxAge = 10 * time.Minute
+)
+
+type queuedCommand struct {
+	queuedAt time.Time
+	send     func() error
+}
+
+// circuit is the breaker state of one controller
+type circuit struct {
+	open     bool
+	openedAt time.Time // Also reset whenever a test command is let through
+	queue    []queuedCommand
+	send     sync.Mutex // Keeps commands to the controller in order
+}
+
+// Breaker wraps a WLED client with a circuit breaker per controller.
+// A command or health probe the controller doesn't answer (ErrUnreachable)
+// opens the breaker. Other errors, like a rejected password, are returned
+// as they are, as retrying wouldn't help. While it's open,
+// commands fail fast with ErrOffline instead of waiting out the HTTP timeout,
+// and are queued. A successful probe or test command closes the breaker
+// and replays the queued commands in order.
+type Breaker struct {
+	client WLEDClientInterface
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+	now      func() time.Time
+}
+
+var _ WLEDClientInterface = (*Breaker)(nil)
+
+func NewBreaker(c WLEDClientInterface) *Breaker {
+	return &Breaker{client: c, circuits: make(map[string]*circuit), now: time.Now}
+}
+
+// circuit returns the controller's breaker state. Callers hold b.mu.
+func (b *Breaker) circuit(ipAddress string) *circuit {
+	c, ok := b.circuits[ipAddress]
+	if !ok {
+		c = &circuit{}
+		b.circuits[ipAddress] = c
+	}
+	return c
+}
+
+// IsOpen reports whether the controller is considered offline
+func (b *Breaker) IsOpen(ipAddress string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.circuit(ipAddress).open
+}
+
+// Forget drops the controller's breaker state and its queued commands,
+// for when the controller moved to another address
+func (b *Breaker) Forget(ipAddress string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.circuits, ipAddress)
+}
+
+// Queued returns how many commands are waiting for the controller to come back
+func (b *Breaker) Queued(ipAddress string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.circuit(ipAddress).queue)
+}
+
+// failFast queues the command and reports failed if the breaker is open.
+// Once breakerRetryAfter has passed, one caller is let through as a test.
+func (b *Breaker) failFast(ipAddress string, command func() error) (failed, test bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuit(ipAddress)
+	if !c.open {
+		return false, false
+	}
+	if b.now().Sub(c.openedAt) >= breakerRetryAfter {
+		c.openedAt = b.now()
+		return false, true
+	}
+	b.enqueue(c, command)
+	return true, false
+}
+
+// enqueue adds a command to the replay queue. Callers hold b.mu.
+func (b *Breaker) enqueue(c *circuit, command func() error) {
+	c.queue = append(c.queue, queuedCommand{queuedAt: b.now(), send: command})
+	if len(c.queue) > breakerQueueSize {
+		c.queue = c.queue[len(c.queue)-breakerQueueSize:]
+	}
+}
+
+// trip opens the breaker and optionally queues the failed command
+func (b *Breaker) trip(ipAddress string, command func() error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuit(ipAddress)
+	if !c.open {
+		log.Printf("Breaker: %s is offline, failing fast and queueing commands", ipAddress)
+	}
+	c.open = true
+	c.openedAt = b.now()
+	if command != nil {
+		b.enqueue(c, command)
+	}
+}
+
+// replay sends the queued commands in order and closes the breaker once
+// they're all sent. Callers hold c.send.
+func (b *Breaker) replay(ipAddress string, c *circuit) error {
+	replayed := 0
+	for {
+		b.mu.Lock()
+		if len(c.queue) == 0 {
+			if c.open {
+				log.Printf("Breaker: %s is back online, replayed %d commands", ipAddress, replayed)
+			}
+			c.open = false
+			b.mu.Unlock()
+			return nil
+		}
+		next := c.queue[0]
+		c.queue = c.queue[1:]
+		b.mu.Unlock()
+
+		if b.now().Sub(next.queuedAt) > breakerQueueMaxAge {
+			continue
+		}
+		if err := next.send(); err != nil {
+			if !errors.Is(err, ErrUnreachable) {
+				// The controller answered, it won't take this command later either
+				log.Printf("Breaker: %s rejected a queued command, dropping it: %v", ipAddress, err)
+				continue
+			}
+			b.mu.Lock()
+			c.queue = append([]queuedCommand{next}, c.queue...)
+			b.mu.Unlock()
+			return err
+		}
+		replayed++
+	}
+}
+
+// do sends a command through the controller's breaker
+func (b *Breaker) do(ipAddress string, command func() error) error {
+	failed, test := b.failFast(ipAddress, command)
+	if failed {
+		return fmt.Errorf("%s: %w, command queued", ipAddress, ErrOffline)
+	}
+
+	b.mu.Lock()
+	c := b.circuit(ipAddress)
+	b.mu.Unlock()
+
+	c.send.Lock()
+	defer c.send.Unlock()
+
+	// Another command may have found the controller offline while this one waited
+	if !test {
+		if failed, _ := b.failFast(ipAddress, command); failed {
+			return fmt.Errorf("%s: %w, command queued", ipAddress, ErrOffline)
+		}
+	}
+
+	// Older queued commands go first
+	err := b.replay(ipAddress, c)
+	if err == nil {
+		if err = command(); err != nil && !errors.Is(err, ErrUnreachable) {
+			return fmt.Errorf("%s: %w", ipAddress, err)
+		}
+	}
+	if err != nil {
+		b.trip(ipAddress, command)
+		return fmt.Errorf("%s: %w, command queued: %v", ipAddress, ErrOffline, err)
+	}
+	return nil
+}
+
+// SendCommand sends a state command, or queues it while the controller is offline
+func (b *Breaker) SendCommand(ipAddress string, state models.WLEDState) error {
+	return b.do(ipAddress, func() error { return b.client.SendCommand(ipAddress, state) })
+}
+
+// SetState restores a full state, or queues it while the controller is offline
+func (b *Breaker) SetState(ipAddress string, state json.RawMessage) error {
+	return b.do(ipAddress, func() error { return b.client.SetState(ipAddress, state) })
+}
+
+// GetState fails fast while the controller is offline. Reads are never queued.
+func (b *Breaker) GetState(ipAddress string) (json.RawMessage, error) {
+	if b.IsOpen(ipAddress) {
+		return nil, fmt.Errorf("%s: %w", ipAddress, ErrOffline)
+	}
+	state, err := b.client.GetState(ipAddress)
+	if errors.Is(err, ErrUnreachable) {
+		b.trip(ipAddress, nil)
+	}
+	return state, err
+}
+
+// Ping reports whether the controller answers, see Probe
+func (b *Breaker) Ping(ipAddress string) bool {
+	_, err := b.Probe(ipAddress)
+	return err == nil
+}
+
+// Probe always reaches the controller, whatever the breaker state.
+// An unanswered probe opens the breaker, a successful one replays the
+// queued commands and closes it.
+func (b *Breaker) Probe(ipAddress string) (time.Duration, error) {
+	latency, err := b.client.Probe(ipAddress)
+	if err != nil {
+		if errors.Is(err, ErrUnreachable) {
+			b.trip(ipAddress, nil)
+		}
+		return latency, err
+	}
+
+	b.mu.Lock()
+	c := b.circuit(ipAddress)
+	b.mu.Unlock()
+
+	c.send.Lock()
+	defer c.send.Unlock()
+	if err := b.replay(ipAddress, c); err != nil {
+		b.trip(ipAddress, nil)
+	}
+	return latency, nil
+}
@@ -0,0 +1,168 @@
+package wled
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"wledger/internal/models"
+)
+
+// fakeClient records the commands that reached the controller
+type fakeClient struct {
+	down     bool
+	rejected bool // Answers, but turns every command down
+	sent     []string
+}
+
+var errTimeout = fmt.Errorf("%w: timeout", ErrUnreachable)
+
+func (f *fakeClient) SendCommand(ip string, state models.WLEDState) error {
+	if f.down {
+		return errTimeout
+	}
+	if f.rejected {
+		return errors.New("unexpected status 401 Unauthorized")
+	}
+	f.sent = append(f.sent, string(state.Segments[0].I[1].(string)))
+	return nil
+}
+func (f *fakeClient) GetState(ip string) (json.RawMessage, error) {
+	if f.down {
+		return nil, errTimeout
+	}
+	return json.RawMessage(`{}`), nil
+}
+func (f *fakeClient) SetState(ip string, state json.RawMessage) error { return nil }
+func (f *fakeClient) Ping(ip string) bool                             { return !f.down }
+func (f *fakeClient) Probe(ip string) (time.Duration, error) {
+	if f.down {
+		return 0, errTimeout
+	}
+	return time.Millisecond, nil
+}
+
+func color(c string) models.WLEDState {
+	return models.WLEDState{Segments: []models.WLEDSegment{{I: []interface{}{0, c}}}}
+}
+
+func TestBreaker_QueuesWhileOffline(t *testing.T) {
+	fake := &fakeClient{}
+	b := NewBreaker(fake)
+	now := time.Date(2025, 6, 2, 19, 0, 0, 0, time.UTC)
+	b.now = func() time.Time { return now }
+
+	// The health check finds the controller offline
+	fake.down = true
+	if _, err := b.Probe("10.0.0.3"); err == nil || !b.IsOpen("10.0.0.3") {
+		t.Fatalf("Expected a failed probe to open the breaker")
+	}
+
+	// Commands fail fast without reaching the controller
+	fake.down = false
+	if err := b.SendCommand("10.0.0.3", color("FF0000")); !errors.Is(err, ErrOffline) {
+		t.Fatalf("Expected ErrOffline, got %v", err)
+	}
+	if err := b.SendCommand("10.0.0.3", color("000000")); !errors.Is(err, ErrOffline) {
+		t.Fatalf("Expected ErrOffline, got %v", err)
+	}
+	if len(fake.sent) != 0 || b.Queued("10.0.0.3") != 2 {
+		t.Fatalf("Expected 2 queued commands and none sent, sent %v", fake.sent)
+	}
+	if _, err := b.GetState("10.0.0.3"); !errors.Is(err, ErrOffline) {
+		t.Errorf("Expected GetState to fail fast, got %v", err)
+	}
+
+	// Other controllers are unaffected
+	if err := b.SendCommand("10.0.0.1", color("00FF00")); err != nil {
+		t.Errorf("Expected another controller to work, got %v", err)
+	}
+
+	// Back online: queued commands are replayed in order
+	fake.sent = nil
+	if _, err := b.Probe("10.0.0.3"); err != nil {
+		t.Fatalf("Probe failed: %v", err)
+	}
+	if b.IsOpen("10.0.0.3") || b.Queued("10.0.0.3") != 0 {
+		t.Errorf("Expected the breaker to close with an empty queue")
+	}
+	if len(fake.sent) != 2 || fake.sent[0] != "FF0000" || fake.sent[1] != "000000" {
+		t.Errorf("Expected the queued commands in order, got %v", fake.sent)
+	}
+}
+
+func TestBreaker_CommandFailureAndRetry(t *testing.T) {
+	fake := &fakeClient{down: true}
+	b := NewBreaker(fake)
+	now := time.Date(2025, 6, 2, 19, 0, 0, 0, time.UTC)
+	b.now = func() time.Time { return now }
+
+	// A timed out command opens the breaker and is queued
+	if err := b.SendCommand("10.0.0.3", color("FF0000")); !errors.Is(err, ErrOffline) {
+		t.Fatalf("Expected ErrOffline, got %v", err)
+	}
+	if !b.IsOpen("10.0.0.3") || b.Queued("10.0.0.3") != 1 {
+		t.Fatalf("Expected an open breaker with 1 queued command")
+	}
+
+	// After the retry delay, a command is let through and replays the queue first
+	fake.down = false
+	now = now.Add(breakerRetryAfter)
+	if err := b.SendCommand("10.0.0.3", color("0000FF")); err != nil {
+		t.Fatalf("Expected the test command to succeed, got %v", err)
+	}
+	if len(fake.sent) != 2 || fake.sent[0] != "FF0000" || fake.sent[1] != "0000FF" {
+		t.Errorf("Expected the queued command before the new one, got %v", fake.sent)
+	}
+
+	// Stale commands are dropped
+	fake.down = true
+	b.SendCommand("10.0.0.3", color("FFFFFF"))
+	fake.down = false
+	fake.sent = nil
+	now = now.Add(breakerQueueMaxAge + time.Minute)
+	b.Probe("10.0.0.3")
+	if len(fake.sent) != 0 || b.IsOpen("10.0.0.3") {
+		t.Errorf("Expected the stale command to be dropped, got %v", fake.sent)
+	}
+}
+
+func TestBreaker_Forget(t *testing.T) {
+	fake := &fakeClient{down: true}
+	b := NewBreaker(fake)
+	b.Probe("10.0.0.3")
+	b.SendCommand("10.0.0.3", color("FF0000"))
+
+	// The controller moved to another address, its old breaker is dropped
+	b.Forget("10.0.0.3")
+	if b.IsOpen("10.0.0.3") || b.Queued("10.0.0.3") != 0 {
+		t.Errorf("Expected the breaker to be reset")
+	}
+}
+
+func TestBreaker_RejectedCommandsAreNotQueued(t *testing.T) {
+	fake := &fakeClient{rejected: true}
+	b := NewBreaker(fake)
+
+	// A wrong password isn't the controller being offline
+	err := b.SendCommand("10.0.0.3", color("FF0000"))
+	if err == nil || errors.Is(err, ErrOffline) {
+		t.Fatalf("Expected the rejection as it is, got %v", err)
+	}
+	if b.IsOpen("10.0.0.3") || b.Queued("10.0.0.3") != 0 {
+		t.Errorf("Expected a closed breaker and nothing queued")
+	}
+
+	// A queued command the controller rejects once it's back is dropped
+	fake.rejected = false
+	fake.down = true
+	b.SendCommand("10.0.0.3", color("00FF00"))
+	fake.down = false
+	fake.rejected = true
+	b.Probe("10.0.0.3")
+	if b.IsOpen("10.0.0.3") || b.Queued("10.0.0.3") != 0 {
+		t.Errorf("Expected the rejected command to be dropped and the breaker closed")
+	}
+}
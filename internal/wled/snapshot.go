@@ -0,0 +1,149 @@
+package wled
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+)
+
+// LED identifies a single LED on a controller
+type LED struct {
+	Segment int
+	Index   int
+}
+
+// stateClient is the part of the WLED client the StateKeeper needs
+type stateClient interface {
+	GetState(ipAddress string) (json.RawMessage, error)
+	SetState(ipAddress string, state json.RawMessage) error
+}
+
+// StateKeeper tracks which LEDs WLEDger has lit on each controller.
+// For controllers that opt in, it snapshots the controller's state when
+// WLEDger takes it over and restores it once the last LED is released,
+// so presets and effects running on the controller aren't lost.
+type StateKeeper struct {
+	client stateClient
+
+	mu          sync.Mutex // Guards the map and the controllers' owned LEDs and snapshots
+	controllers map[string]*keptController
+}
+
+// keptController is what the StateKeeper keeps for one controller address
+type keptController struct {
+	// busy keeps claims and releases of the controller in order. It's held
+	// across the snapshot and restore calls, so a slow controller only
+	// holds up its own LEDs, not every other controller's.
+	busy     sync.Mutex
+	owned    map[LED]bool
+	snapshot json.RawMessage
+}
+
+func NewStateKeeper(c stateClient) *StateKeeper {
+	return &StateKeeper{
+		client:      c,
+		controllers: make(map[string]*keptController),
+	}
+}
+
+// controller returns the kept state of an address. Entries are only
+// removed by Forget, for addresses that aren't used anymore, so callers
+// waiting on busy work on the entry that's in the map.
+func (k *StateKeeper) controller(ipAddress string) *keptController {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	c, ok := k.controllers[ipAddress]
+	if !ok {
+		c = &keptController{owned: make(map[LED]bool)}
+		k.controllers[ipAddress] = c
+	}
+	return c
+}
+
+// Claim marks LEDs as lit by WLEDger. Call it before sending the command.
+// If snapshot is true and WLEDger doesn't own any LEDs on the controller yet,
+// the controller's current state is saved first.
+func (k *StateKeeper) Claim(ipAddress string, snapshot bool, leds []LED) {
+	c := k.controller(ipAddress)
+	c.busy.Lock()
+	defer c.busy.Unlock()
+
+	k.mu.Lock()
+	first := len(c.owned) == 0
+	k.mu.Unlock()
+
+	var state json.RawMessage
+	if first && snapshot {
+		var err error
+		state, err = k.client.GetState(ipAddress)
+		if err != nil {
+			log.Printf("StateKeeper: Failed to snapshot %s, it won't be restored: %v", ipAddress, err)
+		}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if state != nil {
+		c.snapshot = state
+	}
+	for _, led := range leds {
+		c.owned[led] = true
+	}
+}
+
+// Release marks LEDs as no longer lit by WLEDger. Call it after turning them off.
+// Once no LEDs are left, the controller's saved state is restored.
+func (k *StateKeeper) Release(ipAddress string, leds []LED) {
+	k.release(ipAddress, func(c *keptController) {
+		for _, led := range leds {
+			delete(c.owned, led)
+		}
+	})
+}
+
+// ReleaseAll releases every LED on the controller and restores its saved state
+func (k *StateKeeper) ReleaseAll(ipAddress string) {
+	k.release(ipAddress, func(c *keptController) {
+		clear(c.owned)
+	})
+}
+
+// release updates the owned LEDs, then restores the saved state once none
+// are left, outside k.mu
+func (k *StateKeeper) release(ipAddress string, update func(c *keptController)) {
+	c := k.controller(ipAddress)
+	c.busy.Lock()
+	defer c.busy.Unlock()
+
+	k.mu.Lock()
+	update(c)
+	state := c.snapshot
+	if len(c.owned) > 0 || state == nil {
+		k.mu.Unlock()
+		return
+	}
+	c.snapshot = nil
+	k.mu.Unlock()
+
+	if err := k.client.SetState(ipAddress, state); err != nil {
+		log.Printf("StateKeeper: Failed to restore %s: %v", ipAddress, err)
+	}
+}
+
+// Forget drops the LEDs and saved state of the controller without restoring
+// it, for when the controller moved to another address
+func (k *StateKeeper) Forget(ipAddress string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.controllers, ipAddress)
+}
+
+// Owned returns how many LEDs WLEDger has lit on the controller
+func (k *StateKeeper) Owned(ipAddress string) int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if c, ok := k.controllers[ipAddress]; ok {
+		return len(c.owned)
+	}
+	return 0
+}
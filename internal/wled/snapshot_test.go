@@ -0,0 +1,137 @@
+package wled
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+type mockStateClient struct {
+	Gets     int
+	Restored map[string]string
+}
+
+func (m *mockStateClient) GetState(ip string) (json.RawMessage, error) {
+	m.Gets++
+	return json.RawMessage(`{"on":true,"ps":3}`), nil
+}
+
+func (m *mockStateClient) SetState(ip string, state json.RawMessage) error {
+	if m.Restored == nil {
+		m.Restored = make(map[string]string)
+	}
+	m.Restored[ip] = string(state)
+	return nil
+}
+
+func TestStateKeeper_SnapshotAndRestore(t *testing.T) {
+	mc := &mockStateClient{}
+	k := NewStateKeeper(mc)
+
+	// Two parts located on the same controller, only the first one snapshots
+	k.Claim("10.0.0.1", true, []LED{{0, 1}, {0, 2}})
+	k.Claim("10.0.0.1", true, []LED{{0, 5}})
+	if mc.Gets != 1 {
+		t.Fatalf("Expected 1 snapshot, got %d", mc.Gets)
+	}
+	if k.Owned("10.0.0.1") != 3 {
+		t.Errorf("Expected 3 owned LEDs, got %d", k.Owned("10.0.0.1"))
+	}
+
+	// Releasing one part keeps the controller taken over
+	k.Release("10.0.0.1", []LED{{0, 1}, {0, 2}})
+	if len(mc.Restored) != 0 {
+		t.Fatalf("Restored too early")
+	}
+
+	// Releasing the last LED restores the snapshot
+	k.Release("10.0.0.1", []LED{{0, 5}})
+	if mc.Restored["10.0.0.1"] != `{"on":true,"ps":3}` {
+		t.Errorf("Expected snapshot to be restored, got %v", mc.Restored)
+	}
+
+	// The snapshot is only restored once
+	delete(mc.Restored, "10.0.0.1")
+	k.ReleaseAll("10.0.0.1")
+	if len(mc.Restored) != 0 {
+		t.Errorf("Snapshot restored twice")
+	}
+
+	// A new take-over snapshots again
+	k.Claim("10.0.0.1", true, []LED{{0, 1}})
+	if mc.Gets != 2 {
+		t.Errorf("Expected a new snapshot, got %d total", mc.Gets)
+	}
+}
+
+func TestStateKeeper_OptOut(t *testing.T) {
+	mc := &mockStateClient{}
+	k := NewStateKeeper(mc)
+
+	k.Claim("10.0.0.2", false, []LED{{1, 7}})
+	k.ReleaseAll("10.0.0.2")
+	if mc.Gets != 0 || len(mc.Restored) != 0 {
+		t.Errorf("Controllers without state restore shouldn't be snapshotted, got %d gets, %v", mc.Gets, mc.Restored)
+	}
+}
+
+func TestStateKeeper_Forget(t *testing.T) {
+	mc := &mockStateClient{}
+	k := NewStateKeeper(mc)
+	k.Claim("10.0.0.1", true, []LED{{0, 1}})
+
+	// Forgetting doesn't restore, the address may be another controller by now
+	k.Forget("10.0.0.1")
+	if k.Owned("10.0.0.1") != 0 {
+		t.Errorf("Expected no owned LEDs, got %d", k.Owned("10.0.0.1"))
+	}
+	k.ReleaseAll("10.0.0.1")
+	if len(mc.Restored) != 0 {
+		t.Errorf("Expected the snapshot to be dropped, got %v", mc.Restored)
+	}
+}
+
+// blockingStateClient holds GetState of one address until it's released
+type blockingStateClient struct {
+	mockStateClient
+	slow    string
+	release chan struct{}
+}
+
+func (b *blockingStateClient) GetState(ip string) (json.RawMessage, error) {
+	if ip == b.slow {
+		<-b.release
+	}
+	return json.RawMessage(`{"on":true}`), nil
+}
+
+func TestStateKeeper_SlowControllerDoesNotBlockOthers(t *testing.T) {
+	bc := &blockingStateClient{slow: "10.0.0.1", release: make(chan struct{})}
+	k := NewStateKeeper(bc)
+
+	claimed := make(chan struct{})
+	go func() {
+		k.Claim("10.0.0.1", true, []LED{{0, 1}})
+		close(claimed)
+	}()
+
+	// Another controller is claimed and released while the first one hangs
+	done := make(chan struct{})
+	go func() {
+		k.Claim("10.0.0.2", true, []LED{{0, 1}})
+		k.Release("10.0.0.2", []LED{{0, 1}})
+		k.Owned("10.0.0.1")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("A slow snapshot blocked another controller")
+	}
+
+	close(bc.release)
+	<-claimed
+	if k.Owned("10.0.0.1") != 1 {
+		t.Errorf("Expected the slow claim to finish, got %d owned", k.Owned("10.0.0.1"))
+	}
+}
@@ -2,22 +2,43 @@ package wled
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"time"
+
+	"wledger/internal/endpoint"
 	"wledger/internal/models"
 )
 
+// ErrUnreachable is returned, wrapped, when a controller didn't answer:
+// the connection failed or timed out. Errors from a controller that did
+// answer, such as a rejected password, aren't wrapped with it.
+var ErrUnreachable = errors.New("controller unreachable")
+
 type WLEDClientInterface interface {
 	SendCommand(ipAddress string, state models.WLEDState) error
+	GetState(ipAddress string) (json.RawMessage, error)
+	SetState(ipAddress string, state json.RawMessage) error
 	Ping(ipAddress string) bool
+	Probe(ipAddress string) (time.Duration, error)
 }
 
 var _ WLEDClientInterface = (*WLEDClient)(nil)
 
-// WLEDClient for communicating with WLED controllers
+// CredentialSource looks up the basic auth credentials of a controller address
+type CredentialSource interface {
+	GetControllerCredentials(ctx context.Context, address string) (username, password string, err error)
+}
+
+// WLEDClient for communicating with WLED controllers.
+// Controllers are addressed by their endpoint address, see endpoint.Address.
 type WLEDClient struct {
-	client *http.Client
+	client      *http.Client
+	credentials CredentialSource
 }
 
 // Creates a new WLED client
@@ -30,38 +51,127 @@ func NewWLEDClient() *WLEDClient {
 	}
 }
 
-// SendCommand sends a JSON state command to a WLED controller IP
-func (c *WLEDClient) SendCommand(ipAddress string, state models.WLEDState) error {
-	jsonData, err := json.Marshal(state)
+// WithCredentials makes the client send basic auth to controllers that have credentials
+func (c *WLEDClient) WithCredentials(s CredentialSource) *WLEDClient {
+	c.credentials = s
+	return c
+}
+
+// newRequest builds a request for an API path on a controller, with its credentials
+func (c *WLEDClient) newRequest(method, address, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, endpoint.URL(address, path), body)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.credentials != nil {
+		username, password, err := c.credentials.GetControllerCredentials(req.Context(), address)
+		if err != nil {
+			return nil, fmt.Errorf("loading credentials: %w", err)
+		}
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+	}
+	return req, nil
+}
 
-	url := "http://" + ipAddress + "/json/state"
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+// post sends a JSON body to a controller and checks that it was accepted
+func (c *WLEDClient) post(address, path string, body []byte) error {
+	req, err := c.newRequest("POST", address, path, bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
 
 	// Use the client's internal http.Client
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %w", ErrUnreachable, err)
 	}
 	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
 	return nil
 }
 
+// SendCommand sends a JSON state command to a WLED controller
+func (c *WLEDClient) SendCommand(ipAddress string, state models.WLEDState) error {
+	jsonData, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return c.post(ipAddress, "/json/state", jsonData)
+}
+
+// GetState returns the controller's full current state, as reported by WLED
+func (c *WLEDClient) GetState(ipAddress string) (json.RawMessage, error) {
+	req, err := c.newRequest("GET", ipAddress, "/json/state", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("invalid JSON state")
+	}
+	return json.RawMessage(body), nil
+}
+
+// SetState sends a previously saved state back to a WLED controller
+func (c *WLEDClient) SetState(ipAddress string, state json.RawMessage) error {
+	return c.post(ipAddress, "/json/state", state)
+}
+
 // Ping sends a GET request to a WLED controller
 func (c *WLEDClient) Ping(ipAddress string) bool {
+	_, err := c.Probe(ipAddress)
+	return err == nil
+}
+
+// Probe checks that a WLED controller answers and measures how long it took
+func (c *WLEDClient) Probe(ipAddress string) (time.Duration, error) {
 	// Use a shorter timeout for pings
 	pingClient := &http.Client{Timeout: 2 * time.Second}
 
-	resp, err := pingClient.Get("http://" + ipAddress + "/json/info")
+	req, err := c.newRequest("GET", ipAddress, "/json/info", nil)
 	if err != nil {
-		return false
+		return 0, err
+	}
+	start := time.Now()
+	resp, err := pingClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrUnreachable, err)
 	}
 	defer resp.Body.Close()
-	return resp.StatusCode == http.StatusOK
+	latency := time.Since(start)
+
+	if resp.StatusCode != http.StatusOK {
+		return latency, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return latency, nil
+}
+
+// Forgetters forgets a controller address in each of them, e.g. the
+// Breaker and StateKeeper once a controller is moved to another address
+type Forgetters []interface{ Forget(ipAddress string) }
+
+func (f Forgetters) Forget(ipAddress string) {
+	for _, forgetter := range f {
+		forgetter.Forget(ipAddress)
+	}
 }
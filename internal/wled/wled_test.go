@@ -1,6 +1,7 @@
 package wled
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -69,3 +70,82 @@ func TestWLEDClient_Ping(t *testing.T) {
 		t.Error("client.Ping() returned true for a dead server, want false")
 	}
 }
+
+func TestWLEDClient_Probe(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	client := NewWLEDClient()
+	_, err := client.Probe(strings.TrimPrefix(ts.URL, "http://"))
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("Expected a 503 error, got %v", err)
+	}
+}
+
+func TestWLEDClient_GetAndSetState(t *testing.T) {
+	saved := `{"on":true,"bri":128,"ps":2}`
+	var restored string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/json/state" {
+			t.Errorf("got path %s, want /json/state", r.URL.Path)
+		}
+		if r.Method == "GET" {
+			w.Write([]byte(saved))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		restored = string(body)
+	}))
+	defer ts.Close()
+
+	client := NewWLEDClient()
+	ip := strings.TrimPrefix(ts.URL, "http://")
+
+	state, err := client.GetState(ip)
+	if err != nil {
+		t.Fatalf("GetState() failed: %v", err)
+	}
+	if err := client.SetState(ip, state); err != nil {
+		t.Fatalf("SetState() failed: %v", err)
+	}
+	if restored != saved {
+		t.Errorf("got restored state %s, want %s", restored, saved)
+	}
+}
+
+type staticCredentials struct{ username, password string }
+
+func (s staticCredentials) GetControllerCredentials(_ context.Context, address string) (string, string, error) {
+	return s.username, s.password, nil
+}
+
+func TestWLEDClient_EndpointWithBasePathAndAuth(t *testing.T) {
+	var gotPath, gotUser, gotPass string
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotUser, gotPass, _ = r.BasicAuth()
+		if gotUser != "admin" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer ts.Close()
+
+	client := NewWLEDClient().WithCredentials(staticCredentials{"admin", "s3cret"})
+	client.client = ts.Client()
+
+	address := ts.URL + "/wled/a"
+	if err := client.SendCommand(address, models.WLEDState{}); err != nil {
+		t.Fatalf("SendCommand() failed: %v", err)
+	}
+	if gotPath != "/wled/a/json/state" || gotUser != "admin" || gotPass != "s3cret" {
+		t.Errorf("got path %q, user %q, password %q", gotPath, gotUser, gotPass)
+	}
+
+	// Rejected credentials are an error
+	client.WithCredentials(staticCredentials{"guest", ""})
+	if err := client.SendCommand(address, models.WLEDState{}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Expected a 401 error, got %v", err)
+	}
+}
@@ -0,0 +1,11 @@
+// Package ui embeds the templates and static files, so the server runs
+// from any directory. In dev mode they're read from disk instead.
+package ui
+
+import "embed"
+
+// The templates are matched by pattern, because embedding the directory
+// would leave out the partials starting with an underscore.
+//
+//go:embed templates/*.html static
+var FS embed.FS
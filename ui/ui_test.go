@@ -0,0 +1,18 @@
+package ui
+
+import (
+	"html/template"
+	"testing"
+)
+
+func TestFS_Templates(t *testing.T) {
+	templates, err := template.ParseFS(FS, "templates/*.html")
+	if err != nil {
+		t.Fatalf("Failed to parse the embedded templates: %v", err)
+	}
+	for _, name := range []string{"settings.html", "_footer.html"} {
+		if templates.Lookup(name) == nil {
+			t.Errorf("Expected %s to be embedded", name)
+		}
+	}
+}